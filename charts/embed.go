@@ -0,0 +1,25 @@
+// Copyright 2019-2021 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package charts provides the sources of the Helm chart, so the chart can be exported by kubectl-gadget
+package charts
+
+import (
+	"embed"
+)
+
+// Gadget contains the gadget chart; Chart.yaml.tmpl needs to be rendered and the CRDs added to get a complete chart
+//
+//go:embed all:gadget
+var Gadget embed.FS
@@ -0,0 +1,29 @@
+# Copyright 2024 The Inspektor Gadget authors
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Python client for the Inspektor Gadget gRPC API."""
+
+from .decoder import DataSourceDecoder, nest
+
+__version__ = "0.0.0"
+
+__all__ = ["Client", "Event", "DataSourceDecoder", "nest"]
+
+
+def __getattr__(name):
+    # The client needs the generated gRPC stubs, only import it when it's actually used
+    if name in ("Client", "Event"):
+        from . import client
+        return getattr(client, name)
+    raise AttributeError(name)
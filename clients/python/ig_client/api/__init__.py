@@ -0,0 +1 @@
+# Generated gRPC stubs for pkg/gadget-service/api/api.proto, see "make generate"
@@ -0,0 +1,141 @@
+# Copyright 2024 The Inspektor Gadget authors
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Client for the gadget service (ig daemon or the gadget pods in Kubernetes)."""
+
+import logging
+import queue
+from dataclasses import dataclass
+from typing import Any, Dict, Iterator, Optional
+
+import grpc
+
+from .api import api_pb2, api_pb2_grpc
+from .decoder import DataSourceDecoder
+
+# Keep aligned with pkg/gadget-service/api/consts.go
+VERSION_GADGET_INFO = 1
+VERSION_GADGET_RUN_PROTOCOL = 1
+
+EVENT_TYPE_GADGET_PAYLOAD = 0
+EVENT_TYPE_GADGET_RESULT = 1
+EVENT_TYPE_GADGET_DONE = 2
+EVENT_TYPE_GADGET_JOB_ID = 3
+EVENT_TYPE_GADGET_INFO = 4
+EVENT_LOG_SHIFT = 16
+
+DEFAULT_DAEMON_PATH = "unix:///var/run/ig/ig.socket"
+
+# logrus levels as used by the gadget service
+LOG_LEVELS = {
+    0: logging.CRITICAL,  # panic
+    1: logging.CRITICAL,  # fatal
+    2: logging.ERROR,
+    3: logging.WARNING,
+    4: logging.INFO,
+    5: logging.DEBUG,
+    6: logging.DEBUG,  # trace
+}
+_LOGRUS_LEVELS = {logging.ERROR: 2, logging.WARNING: 3, logging.INFO: 4, logging.DEBUG: 5}
+
+log = logging.getLogger("ig_client")
+
+
+@dataclass
+class Event:
+    """An event emitted by a datasource of a gadget."""
+
+    datasource: str
+    data: Dict[str, Any]
+    seq: int
+
+
+class Client:
+    def __init__(self, target: str = DEFAULT_DAEMON_PATH, channel: Optional[grpc.Channel] = None):
+        """target uses the gRPC naming scheme, e.g. unix:///var/run/ig/ig.socket or 127.0.0.1:8080."""
+        self.channel = channel or grpc.insecure_channel(target)
+        self.stub = api_pb2_grpc.GadgetManagerStub(self.channel)
+
+    def close(self):
+        self.channel.close()
+
+    def __enter__(self):
+        return self
+
+    def __exit__(self, *args):
+        self.close()
+
+    def gadget_info(self, image: str, params: Optional[Dict[str, str]] = None):
+        """Returns the api.GadgetInfo of the given image."""
+        res = self.stub.GetGadgetInfo(api_pb2.GetGadgetInfoRequest(
+            imageName=image,
+            paramValues=params or {},
+            version=VERSION_GADGET_INFO,
+        ))
+        return res.gadgetInfo
+
+    def run(
+        self,
+        image: str,
+        params: Optional[Dict[str, str]] = None,
+        timeout: float = 0,
+        log_level: int = logging.INFO,
+        nested: bool = False,
+        show_hidden: bool = False,
+    ) -> Iterator[Event]:
+        """Runs a gadget and yields its events until it stops or the iterator is closed.
+
+        timeout is given in seconds, 0 means the gadget runs until stopped. If nested is set, fields are returned
+        as nested dicts (e.g. {"k8s": {"namespace": ...}}) instead of using their full names as keys.
+        """
+        requests = queue.Queue()
+        requests.put(api_pb2.GadgetControlRequest(runRequest=api_pb2.GadgetRunRequest(
+            imageName=image,
+            paramValues=params or {},
+            timeout=int(timeout * 1e9),
+            logLevel=_LOGRUS_LEVELS.get(log_level, 4),
+            version=VERSION_GADGET_RUN_PROTOCOL,
+        )))
+
+        def request_iterator():
+            while True:
+                req = requests.get()
+                if req is None:
+                    return
+                yield req
+
+        stream = self.stub.RunGadget(request_iterator())
+        decoders = {}
+        try:
+            for ev in stream:
+                if ev.type == EVENT_TYPE_GADGET_PAYLOAD:
+                    decoder = decoders.get(ev.dataSourceID)
+                    if decoder is None:
+                        continue
+                    data = api_pb2.GadgetData()
+                    data.ParseFromString(ev.payload)
+                    values = decoder.decode_nested(data) if nested else decoder.decode(data)
+                    yield Event(datasource=decoder.name, data=values, seq=ev.seq)
+                elif ev.type == EVENT_TYPE_GADGET_INFO:
+                    info = api_pb2.GadgetInfo()
+                    info.ParseFromString(ev.payload)
+                    decoders = {ds.id: DataSourceDecoder(ds, show_hidden) for ds in info.dataSources}
+                elif ev.type >= 1 << EVENT_LOG_SHIFT:
+                    level = LOG_LEVELS.get(ev.type >> EVENT_LOG_SHIFT, logging.INFO)
+                    log.log(level, ev.payload.decode("utf-8", errors="replace"))
+        finally:
+            # Ask the server to stop the gadget in case we're leaving early
+            requests.put(api_pb2.GadgetControlRequest(stopRequest=api_pb2.GadgetStopRequest()))
+            requests.put(None)
+            stream.cancel()
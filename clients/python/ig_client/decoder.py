@@ -0,0 +1,162 @@
+# Copyright 2024 The Inspektor Gadget authors
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Decoding of datasource payloads using the field schema sent by the gadget service.
+
+Keep this aligned with pkg/datasource (field flags, encoding of payloads) and
+pkg/gadget-service/api (kinds, datasource flags).
+"""
+
+import dataclasses
+import keyword
+import re
+import struct
+
+# api.Kind
+KIND_INVALID = 0
+KIND_BOOL = 1
+KIND_INT8 = 2
+KIND_INT16 = 3
+KIND_INT32 = 4
+KIND_INT64 = 5
+KIND_UINT8 = 6
+KIND_UINT16 = 7
+KIND_UINT32 = 8
+KIND_UINT64 = 9
+KIND_FLOAT32 = 10
+KIND_FLOAT64 = 11
+KIND_STRING = 12
+KIND_CSTRING = 13
+
+# datasource.FieldFlag
+FIELD_FLAG_EMPTY = 1 << 0
+FIELD_FLAG_CONTAINER = 1 << 1
+FIELD_FLAG_HIDDEN = 1 << 2
+FIELD_FLAG_HAS_PARENT = 1 << 3
+FIELD_FLAG_STATIC_MEMBER = 1 << 4
+FIELD_FLAG_UNREFERENCED = 1 << 5
+
+# api.DataSourceFlags
+DATASOURCE_FLAG_BIG_ENDIAN = 1 << 0
+
+_STRUCT_FORMATS = {
+    KIND_BOOL: "?",
+    KIND_INT8: "b",
+    KIND_INT16: "h",
+    KIND_INT32: "i",
+    KIND_INT64: "q",
+    KIND_UINT8: "B",
+    KIND_UINT16: "H",
+    KIND_UINT32: "I",
+    KIND_UINT64: "Q",
+    KIND_FLOAT32: "f",
+    KIND_FLOAT64: "d",
+}
+
+
+class _FieldDecoder:
+    def __init__(self, field, byte_order):
+        self.name = field.fullName
+        self.payload_index = field.payloadIndex
+        self.offs = field.offs
+        self.size = field.size
+        self.kind = field.kind
+        self.struct = None
+        fmt = _STRUCT_FORMATS.get(field.kind)
+        if fmt is not None:
+            self.struct = struct.Struct(byte_order + fmt)
+
+    def decode(self, payload):
+        if self.payload_index >= len(payload):
+            return None
+        raw = payload[self.payload_index]
+        if self.size > 0:
+            raw = raw[self.offs:self.offs + self.size]
+        if self.struct is not None:
+            if len(raw) < self.struct.size:
+                return None
+            return self.struct.unpack_from(raw)[0]
+        if self.kind == KIND_CSTRING:
+            return raw.split(b"\0", 1)[0].decode("utf-8", errors="replace")
+        if self.kind == KIND_STRING:
+            return raw.decode("utf-8", errors="replace")
+        return bytes(raw)
+
+
+class DataSourceDecoder:
+    """Decodes payloads of a single datasource.
+
+    datasource is an api.DataSource message as sent in the GadgetInfo.
+    """
+
+    def __init__(self, datasource, show_hidden=False):
+        self.name = datasource.name
+        byte_order = ">" if datasource.flags & DATASOURCE_FLAG_BIG_ENDIAN else "<"
+        skip = FIELD_FLAG_EMPTY | FIELD_FLAG_CONTAINER | FIELD_FLAG_UNREFERENCED
+        if not show_hidden:
+            skip |= FIELD_FLAG_HIDDEN
+        self.fields = [
+            _FieldDecoder(f, byte_order)
+            for f in sorted(datasource.fields, key=lambda f: (f.order, f.index))
+            if not f.flags & skip
+        ]
+        self._dataclass = None
+
+    def field_names(self):
+        return [f.name for f in self.fields]
+
+    def decode(self, gadget_data):
+        """Returns a flat dict mapping the full names of the fields to their values.
+
+        gadget_data is an api.GadgetData message (or anything with a payload list of bytes).
+        """
+        payload = gadget_data.payload
+        return {f.name: f.decode(payload) for f in self.fields}
+
+    def decode_nested(self, gadget_data):
+        """Like decode(), but splits the full names of fields at dots into nested dicts."""
+        return nest(self.decode(gadget_data))
+
+    def dataclass(self):
+        """Returns a dataclass type with one attribute per field (dots in names are replaced by underscores)."""
+        if self._dataclass is None:
+            names = [_identifier(f.name) for f in self.fields]
+            self._dataclass = dataclasses.make_dataclass(_identifier(self.name).title() + "Event", names)
+        return self._dataclass
+
+    def decode_dataclass(self, gadget_data):
+        payload = gadget_data.payload
+        return self.dataclass()(*[f.decode(payload) for f in self.fields])
+
+
+def nest(flat):
+    res = {}
+    for name, value in flat.items():
+        parts = name.split(".")
+        cur = res
+        for part in parts[:-1]:
+            nxt = cur.get(part)
+            if not isinstance(nxt, dict):
+                nxt = {}
+                cur[part] = nxt
+            cur = nxt
+        cur[parts[-1]] = value
+    return res
+
+
+def _identifier(name):
+    ident = re.sub(r"\W", "_", name)
+    if not ident or ident[0].isdigit() or keyword.iskeyword(ident):
+        ident = "_" + ident
+    return ident
@@ -0,0 +1,68 @@
+# Copyright 2024 The Inspektor Gadget authors
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+import struct
+import unittest
+from types import SimpleNamespace
+
+from ig_client import decoder
+
+
+def field(full_name, kind, payload_index=0, offs=0, size=0, flags=0, index=0, order=0):
+    return SimpleNamespace(fullName=full_name, kind=kind, payloadIndex=payload_index, offs=offs, size=size,
+                           flags=flags, index=index, order=order)
+
+
+def datasource(fields, flags=0):
+    return SimpleNamespace(name="exec", flags=flags, fields=fields)
+
+
+class DecoderTest(unittest.TestCase):
+    def test_decode(self):
+        ds = datasource([
+            field("pid", decoder.KIND_UINT32, offs=0, size=4, index=0),
+            field("comm", decoder.KIND_CSTRING, offs=4, size=8, index=1),
+            field("k8s", decoder.KIND_INVALID, flags=decoder.FIELD_FLAG_EMPTY, index=2),
+            field("k8s.namespace", decoder.KIND_STRING, payload_index=1, index=3),
+            field("secret", decoder.KIND_UINT8, offs=12, size=1, flags=decoder.FIELD_FLAG_HIDDEN, index=4),
+        ])
+        payload = [struct.pack("<I", 1234) + b"bash\0\0\0\0" + b"\x01", b"default"]
+        d = decoder.DataSourceDecoder(ds)
+
+        self.assertEqual(d.field_names(), ["pid", "comm", "k8s.namespace"])
+        self.assertEqual(d.decode(SimpleNamespace(payload=payload)),
+                         {"pid": 1234, "comm": "bash", "k8s.namespace": "default"})
+        self.assertEqual(d.decode_nested(SimpleNamespace(payload=payload)),
+                         {"pid": 1234, "comm": "bash", "k8s": {"namespace": "default"}})
+
+        ev = d.decode_dataclass(SimpleNamespace(payload=payload))
+        self.assertEqual(ev.pid, 1234)
+        self.assertEqual(ev.k8s_namespace, "default")
+
+        hidden = decoder.DataSourceDecoder(ds, show_hidden=True)
+        self.assertEqual(hidden.decode(SimpleNamespace(payload=payload))["secret"], 1)
+
+    def test_big_endian(self):
+        ds = datasource([field("value", decoder.KIND_INT16, size=2)], flags=decoder.DATASOURCE_FLAG_BIG_ENDIAN)
+        d = decoder.DataSourceDecoder(ds)
+        self.assertEqual(d.decode(SimpleNamespace(payload=[struct.pack(">h", -2)])), {"value": -2})
+
+    def test_short_payload(self):
+        ds = datasource([field("value", decoder.KIND_UINT64, size=8), field("missing", decoder.KIND_STRING, 1)])
+        d = decoder.DataSourceDecoder(ds)
+        self.assertEqual(d.decode(SimpleNamespace(payload=[b"\x01"])), {"value": None, "missing": None})
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,38 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/inspektor-gadget/inspektor-gadget/cmd/common/utils"
+	gadgetcatalog "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-catalog"
+)
+
+func NewCatalogCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "catalog",
+		Short: "Manage the local catalog of published gadget images",
+	}
+
+	cmd.AddCommand(NewSyncCmd())
+	cmd.AddCommand(NewShowCmd())
+
+	return utils.MarkExperimental(cmd)
+}
+
+func addCatalogFileFlag(cmd *cobra.Command, path *string) {
+	cmd.Flags().StringVar(path, "catalog-file", gadgetcatalog.DefaultCatalogFile, "Path to the catalog file")
+}
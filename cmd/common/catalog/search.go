@@ -0,0 +1,56 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/inspektor-gadget/inspektor-gadget/cmd/common/utils"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/columns"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/columns/formatter/textcolumns"
+	gadgetcatalog "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-catalog"
+)
+
+func NewSearchCmd() *cobra.Command {
+	var path string
+	var noTrunc bool
+	cmd := &cobra.Command{
+		Use:          "search [KEYWORD...]",
+		Short:        "Search the catalog for gadgets matching all keywords",
+		Long:         "Search the catalog for gadgets whose name, description, data sources or fields match all keywords. The catalog needs to be synchronized first using 'catalog sync'.",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			catalog, err := gadgetcatalog.Load(path)
+			if err != nil {
+				return err
+			}
+
+			isTerm := term.IsTerminal(int(os.Stdout.Fd()))
+
+			cols := columns.MustCreateColumns[gadgetcatalog.Gadget]()
+			formatter := textcolumns.NewFormatter(cols.GetColumnMap(), textcolumns.WithShouldTruncate(!noTrunc && isTerm))
+			formatter.WriteTable(cmd.OutOrStdout(), catalog.Search(args...))
+			return nil
+		},
+	}
+
+	addCatalogFileFlag(cmd, &path)
+	cmd.Flags().BoolVar(&noTrunc, "no-trunc", false, "Don't truncate output, this option is only valid when used in a terminal")
+
+	return utils.MarkExperimental(cmd)
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inspektor-gadget/inspektor-gadget/cmd/common/utils"
+	gadgetcatalog "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-catalog"
+)
+
+func NewShowCmd() *cobra.Command {
+	var path string
+	cmd := &cobra.Command{
+		Use:          "show GADGET",
+		Short:        "Show the data sources, fields and capabilities of a gadget in the catalog",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			catalog, err := gadgetcatalog.Load(path)
+			if err != nil {
+				return err
+			}
+			gadget, ok := catalog.Get(args[0])
+			if !ok {
+				return fmt.Errorf("gadget %q not found in catalog", args[0])
+			}
+			out, err := yaml.Marshal(gadget)
+			if err != nil {
+				return fmt.Errorf("marshaling gadget: %w", err)
+			}
+			cmd.Print(string(out))
+			return nil
+		},
+	}
+
+	addCatalogFileFlag(cmd, &path)
+
+	return utils.MarkExperimental(cmd)
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/inspektor-gadget/inspektor-gadget/cmd/common/utils"
+	gadgetcatalog "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-catalog"
+)
+
+func NewSyncCmd() *cobra.Command {
+	var path string
+	var opts gadgetcatalog.SyncOptions
+	cmd := &cobra.Command{
+		Use:          "sync",
+		Short:        "Fetch the list of published gadget images into the catalog file",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			catalog, err := gadgetcatalog.Sync(context.TODO(), opts)
+			if err != nil {
+				return fmt.Errorf("synchronizing catalog: %w", err)
+			}
+			if err := catalog.Save(path); err != nil {
+				return fmt.Errorf("saving catalog: %w", err)
+			}
+			cmd.Printf("Successfully stored %d gadgets in %s\n", len(catalog.Gadgets), path)
+			return nil
+		},
+	}
+
+	addCatalogFileFlag(cmd, &path)
+	cmd.Flags().StringVar(&opts.ArtifactHubURL, "artifacthub-url", gadgetcatalog.DefaultArtifactHubURL, "Artifact Hub instance to get the list of gadgets from")
+	cmd.Flags().StringSliceVar(&opts.Repositories, "repository", []string{gadgetcatalog.DefaultRepository}, "Artifact Hub repositories to include")
+	utils.AddRegistryAuthVariablesAndFlags(cmd, &opts.AuthOptions)
+
+	return utils.MarkExperimental(cmd)
+}
@@ -16,53 +16,18 @@ package image
 
 import (
 	"context"
-	_ "embed"
 	"errors"
 	"fmt"
-	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"runtime"
-	"strings"
-	"time"
 
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/api/types/mount"
-	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v3"
 
 	"github.com/inspektor-gadget/inspektor-gadget/cmd/common"
 	"github.com/inspektor-gadget/inspektor-gadget/cmd/common/utils"
-	"github.com/inspektor-gadget/inspektor-gadget/pkg/oci"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/oci/build"
 )
 
-//go:embed Makefile.build
-var makefile []byte
-
-//go:embed Makefile.build.btfgen
-var makefileBtfgen []byte
-
-// It can be overridden at build time
-var builderImage = "ghcr.io/inspektor-gadget/ebpf-builder:latest"
-
-const (
-	DEFAULT_EBPF_SOURCE = "program.bpf.c"
-	DEFAULT_WASM        = "" // Wasm is optional; unset by default
-	DEFAULT_METADATA    = "gadget.yaml"
-)
-
-type buildFile struct {
-	EBPFSource string `yaml:"ebpfsource"`
-	Wasm       string `yaml:"wasm"`
-	Metadata   string `yaml:"metadata"`
-	CFlags     string `yaml:"cflags"`
-}
-
 type cmdOpts struct {
 	path             string
 	file             string
@@ -86,7 +51,7 @@ func NewBuildCmd() *cobra.Command {
 		SilenceUsage: true,
 		Args:         cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if opts.local && opts.builderImage != builderImage {
+			if opts.local && opts.builderImage != build.DefaultBuilderImage {
 				return fmt.Errorf("--local and --builder-image cannot be used at the same time")
 			}
 
@@ -99,11 +64,11 @@ func NewBuildCmd() *cobra.Command {
 		},
 	}
 
-	cmd.Flags().StringVarP(&opts.file, "file", "f", "build.yaml", "Path to build.yaml")
+	cmd.Flags().StringVarP(&opts.file, "file", "f", build.DefaultBuildFile, "Path to build.yaml")
 	cmd.Flags().BoolVarP(&opts.local, "local", "l", false, "Build using local tools")
 	cmd.Flags().StringVarP(&opts.outputDir, "output", "o", "", "Path to a folder to store generated files while building")
 	cmd.Flags().StringVarP(&opts.image, "tag", "t", "", "Name for the built image (format name:tag)")
-	cmd.Flags().StringVar(&opts.builderImage, "builder-image", builderImage, "Builder image to use")
+	cmd.Flags().StringVar(&opts.builderImage, "builder-image", build.DefaultBuilderImage, "Builder image to use")
 	cmd.Flags().BoolVar(&opts.updateMetadata, "update-metadata", false, "Update the metadata according to the eBPF code")
 	cmd.Flags().BoolVar(&opts.validateMetadata, "validate-metadata", true, "Validate the metadata file before building the gadget image")
 
@@ -114,25 +79,22 @@ func NewBuildCmd() *cobra.Command {
 }
 
 func runBuild(cmd *cobra.Command, opts *cmdOpts) error {
-	conf := &buildFile{
-		EBPFSource: DEFAULT_EBPF_SOURCE,
-		Wasm:       DEFAULT_WASM,
-		Metadata:   DEFAULT_METADATA,
-	}
-
-	var buildContent []byte
+	var conf *build.Config
 	var err error
 
 	if opts.fileChanged {
-		buildContent, err = os.ReadFile(opts.file)
+		conf, err = build.ReadConfig(opts.file)
 		if err != nil {
-			return fmt.Errorf("reading build file: %w", err)
+			return err
 		}
 	} else {
 		// The user specified the path but not the file. Use the default file build.yaml
-		buildContent, err = os.ReadFile(filepath.Join(opts.path, opts.file))
-		if err != nil && !errors.Is(err, os.ErrNotExist) {
-			return fmt.Errorf("reading build file: %w", err)
+		conf, err = build.ReadConfig(filepath.Join(opts.path, opts.file))
+		if errors.Is(err, os.ErrNotExist) {
+			conf, err = build.DefaultConfig(), nil
+		}
+		if err != nil {
+			return err
 		}
 	}
 
@@ -140,96 +102,27 @@ func runBuild(cmd *cobra.Command, opts *cmdOpts) error {
 		return errors.New("btfgen requires --btfhub-archive")
 	}
 
-	if opts.btfgen {
+	if opts.btfgen && conf.Synthetic == "" {
 		cmd.Printf("btfgen is enabled, building will take a while...\n")
 	}
 
-	if err := yaml.Unmarshal(buildContent, conf); err != nil {
-		return fmt.Errorf("unmarshaling build.yaml: %w", err)
-	}
-
-	if opts.outputDir != "" {
-		if _, err := os.Stat(opts.outputDir); err != nil {
-			return err
-		}
-	} else {
-		// make a temp folder to store the build results
-		tmpDir, err := os.MkdirTemp("", "gadget-build-")
-		if err != nil {
-			return fmt.Errorf("creating temp dir: %w", err)
-		}
-		defer os.RemoveAll(tmpDir)
-
-		opts.outputDir = tmpDir
-	}
-
-	if opts.path != "." {
-		cwd, err := os.Getwd()
-		if err != nil {
-			return fmt.Errorf("getting current directory: %w", err)
-		}
-		defer os.Chdir(cwd)
-
-		if err := os.Chdir(opts.path); err != nil {
-			return fmt.Errorf("changing directory: %w", err)
-		}
-	}
-
-	if _, err := os.Stat(conf.EBPFSource); errors.Is(err, os.ErrNotExist) {
-		return fmt.Errorf("source file %q not found", conf.EBPFSource)
-	}
-
-	if opts.local {
-		if err := buildLocal(opts, conf); err != nil {
-			return err
-		}
-	} else {
-		if err := buildInContainer(opts, conf); err != nil {
-			return err
-		}
-	}
-
-	// TODO: make this configurable?
-	archs := []string{oci.ArchAmd64, oci.ArchArm64}
-	objectsPaths := map[string]*oci.ObjectPath{}
-
-	for _, arch := range archs {
-		obj := &oci.ObjectPath{
-			EBPF: filepath.Join(opts.outputDir, arch+".bpf.o"),
-		}
-
-		// TODO: the same wasm file is provided for all architectures. Should we allow per-arch
-		// wasm files?
-		if strings.HasSuffix(conf.Wasm, ".wasm") {
-			// User provided an already-built wasm file
-			obj.Wasm = conf.Wasm
-		} else if conf.Wasm != "" {
-			// User provided a source file to build wasm from
-			obj.Wasm = filepath.Join(opts.outputDir, "program.wasm")
-		}
-
-		if opts.btfgen {
-			archClean := arch
-			if arch == oci.ArchAmd64 {
-				archClean = "x86_64"
-			}
-
-			obj.Btfgen = filepath.Join(opts.outputDir, fmt.Sprintf("btfs-%s.tar.gz", archClean))
-		}
-
-		objectsPaths[arch] = obj
-	}
-
-	buildOpts := &oci.BuildGadgetImageOpts{
-		EBPFSourcePath:   conf.EBPFSource,
-		ObjectPaths:      objectsPaths,
-		MetadataPath:     conf.Metadata,
+	buildOpts := &build.Options{
+		Path:             opts.path,
+		Config:           conf,
+		Image:            opts.image,
+		Local:            opts.local,
+		BuilderImage:     opts.builderImage,
+		OutputDir:        opts.outputDir,
 		UpdateMetadata:   opts.updateMetadata,
 		ValidateMetadata: opts.validateMetadata,
-		CreatedDate:      time.Now().Format(time.RFC3339),
+		Btfgen:           opts.btfgen,
+		BtfHubArchive:    opts.btfhubarchive,
+	}
+	if common.Verbose {
+		buildOpts.Output = cmd.OutOrStdout()
 	}
 
-	desc, err := oci.BuildGadgetImage(context.TODO(), buildOpts, opts.image)
+	desc, err := build.Build(context.TODO(), buildOpts)
 	if err != nil {
 		return err
 	}
@@ -238,176 +131,3 @@ func runBuild(cmd *cobra.Command, opts *cmdOpts) error {
 
 	return nil
 }
-
-func buildLocal(opts *cmdOpts, conf *buildFile) error {
-	makefilePath := filepath.Join(opts.outputDir, "Makefile")
-	if err := os.WriteFile(makefilePath, makefile, 0o644); err != nil {
-		return fmt.Errorf("writing Makefile: %w", err)
-	}
-
-	buildCmd := exec.Command(
-		"make", "-f", makefilePath,
-		"-j", fmt.Sprintf("%d", runtime.NumCPU()),
-		"EBPFSOURCE="+conf.EBPFSource,
-		"WASM="+conf.Wasm,
-		"OUTPUTDIR="+opts.outputDir,
-		"CFLAGS="+conf.CFlags,
-		"all",
-	)
-
-	if opts.btfgen {
-		makefileBtfgenPath := filepath.Join(opts.outputDir, "Makefile.build.btfgen")
-		if err := os.WriteFile(makefileBtfgenPath, makefileBtfgen, 0o644); err != nil {
-			return fmt.Errorf("writing Makefile: %w", err)
-		}
-
-		buildCmd.Args = append(buildCmd.Args, "BTFHUB_ARCHIVE="+opts.btfhubarchive)
-		buildCmd.Args = append(buildCmd.Args, "btfgen")
-	}
-
-	if out, err := buildCmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("build script: %w: %s", err, out)
-	}
-
-	return nil
-}
-
-func buildInContainer(opts *cmdOpts, conf *buildFile) error {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("getting current directory: %w", err)
-	}
-
-	ctx := context.TODO()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		return fmt.Errorf("creating docker client: %w", err)
-	}
-	defer cli.Close()
-
-	f := filters.NewArgs()
-	f.Add("reference", opts.builderImage)
-
-	images, err := cli.ImageList(ctx, image.ListOptions{Filters: f})
-	if err != nil {
-		return fmt.Errorf("listing images: %w", err)
-	}
-
-	var found bool
-
-	for _, img := range images {
-		for _, tag := range img.RepoTags {
-			if tag == opts.builderImage {
-				found = true
-				break
-			}
-		}
-
-		if found {
-			break
-		}
-	}
-
-	if !found {
-		fmt.Printf("Pulling builder image %s. It could take few minutes.\n", opts.builderImage)
-		reader, err := cli.ImagePull(ctx, opts.builderImage, image.PullOptions{})
-		if err != nil {
-			return fmt.Errorf("pulling builder image: %w", err)
-		}
-		io.Copy(io.Discard, reader)
-		reader.Close()
-	}
-
-	wasmFullPath := ""
-	if conf.Wasm != "" {
-		wasmFullPath = filepath.Join("/work", conf.Wasm)
-	}
-
-	cmd := []string{
-		"make", "-f", "/Makefile", "-j", fmt.Sprintf("%d", runtime.NumCPU()),
-		"EBPFSOURCE=" + filepath.Join("/work", conf.EBPFSource),
-		"WASM=" + wasmFullPath,
-		"OUTPUTDIR=/out",
-		"CFLAGS=" + conf.CFlags,
-		"all",
-	}
-
-	mounts := []mount.Mount{
-		{
-			Type:     mount.TypeBind,
-			Target:   "/work",
-			Source:   cwd,
-			ReadOnly: true,
-		},
-		{
-			Type:   mount.TypeBind,
-			Target: "/out",
-			Source: opts.outputDir,
-		},
-	}
-
-	if opts.btfgen {
-		cmd = append(cmd, "BTFHUB_ARCHIVE=/btfhub-archive")
-		cmd = append(cmd, "btfgen")
-
-		mounts = append(mounts, mount.Mount{
-			Type:   mount.TypeBind,
-			Target: "/btfhub-archive",
-			Source: opts.btfhubarchive,
-		})
-	}
-
-	resp, err := cli.ContainerCreate(
-		ctx,
-		&container.Config{
-			Image: opts.builderImage,
-			Cmd:   cmd,
-			User:  fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
-		},
-		&container.HostConfig{
-			Mounts: mounts,
-		},
-		nil, nil, "",
-	)
-	if err != nil {
-		return fmt.Errorf("creating builder container: %w", err)
-	}
-	defer func() {
-		if err := cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{}); err != nil {
-			fmt.Printf("Failed to remove builder container: %s\n", err)
-		}
-	}()
-
-	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-		return fmt.Errorf("starting builder container: %w", err)
-	}
-
-	var status container.WaitResponse
-
-	statusCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
-		if err != nil {
-			return fmt.Errorf("waiting for builder container: %w", err)
-		}
-	case status = <-statusCh:
-	}
-
-	if status.StatusCode != 0 || common.Verbose {
-		opts := container.LogsOptions{ShowStdout: true, ShowStderr: true}
-		out, err := cli.ContainerLogs(ctx, resp.ID, opts)
-		if err != nil {
-			return fmt.Errorf("getting builder container logs: %w", err)
-		}
-
-		fmt.Printf("Builder container logs start:\n")
-		stdcopy.StdCopy(os.Stdout, os.Stderr, out)
-		fmt.Printf("Builder container logs end\n")
-	}
-
-	if status.StatusCode != 0 {
-		return fmt.Errorf("builder container exited with status %d", status.StatusCode)
-	}
-
-	return nil
-}
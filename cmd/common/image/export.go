@@ -17,6 +17,7 @@ package image
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
 
@@ -26,14 +27,23 @@ import (
 
 func NewExportCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:          "export SRC_IMAGE [SRC_IMAGE n] DST_FILE",
-		Short:        "Export the SRC_IMAGE images to DST_FILE",
+		Use:   "export SRC_IMAGE [SRC_IMAGE n] DST_FILE",
+		Short: "Export the SRC_IMAGE images to DST_FILE",
+		Long: `Export the SRC_IMAGE images, including all their architectures, to DST_FILE as tar archive of an OCI image
+layout, e.g. to import them on a node without access to a registry. Use "-" as DST_FILE to write the archive to
+stdout.`,
 		SilenceUsage: true,
 		Args:         cobra.MinimumNArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			l := len(args)
 			srcImages := args[:l-1]
 			dstFile := args[l-1]
+			if dstFile == "-" {
+				if err := oci.ExportImage(context.TODO(), os.Stdout, srcImages...); err != nil {
+					return fmt.Errorf("exporting images: %w", err)
+				}
+				return nil
+			}
 			err := oci.ExportGadgetImages(context.TODO(), dstFile, srcImages...)
 			if err != nil {
 				return fmt.Errorf("exporting images: %w", err)
@@ -32,6 +32,7 @@ func NewImageCmd() *cobra.Command {
 	cmd.AddCommand(NewPushCmd())
 	cmd.AddCommand(NewPullCmd())
 	cmd.AddCommand(NewTagCmd())
+	cmd.AddCommand(NewMergeCmd())
 	cmd.AddCommand(NewListCmd())
 	cmd.AddCommand(NewRemoveCmd())
 
@@ -17,6 +17,7 @@ package image
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
 
@@ -26,13 +27,21 @@ import (
 
 func NewImportCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:          "import SRC_FILE",
-		Short:        "Import images from SRC_FILE",
+		Use:   "import SRC_FILE",
+		Short: "Import images from SRC_FILE",
+		Long: `Import images from SRC_FILE, a tar archive of an OCI image layout as created by "image export". The archive
+may be gzip compressed. Use "-" as SRC_FILE to read the archive from stdin.`,
 		SilenceUsage: true,
 		Args:         cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			srcFile := args[0]
-			tags, err := oci.ImportGadgetImages(context.TODO(), srcFile)
+			var tags []string
+			var err error
+			if srcFile == "-" {
+				tags, err = oci.ImportImage(context.TODO(), os.Stdin)
+			} else {
+				tags, err = oci.ImportGadgetImages(context.TODO(), srcFile)
+			}
 			if err != nil {
 				return fmt.Errorf("importing images: %w", err)
 			}
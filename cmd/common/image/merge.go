@@ -0,0 +1,45 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/inspektor-gadget/inspektor-gadget/cmd/common/utils"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/oci"
+)
+
+func NewMergeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "merge DST_IMAGE SRC_IMAGE...",
+		Short:        "Create the multi-arch image DST_IMAGE containing the architectures of the local SRC_IMAGEs",
+		SilenceUsage: true,
+		Args:         cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			desc, err := oci.MergeGadgetImages(context.TODO(), args[0], args[1:]...)
+			if err != nil {
+				return fmt.Errorf("merging images: %w", err)
+			}
+
+			cmd.Printf("Successfully merged into %s\n", desc.String())
+			return nil
+		},
+	}
+
+	return utils.MarkExperimental(cmd)
+}
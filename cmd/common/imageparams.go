@@ -0,0 +1,110 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+// imageParams merges the params of multiple gadget images into a single set of flags. Images having a param with the
+// same key share its flag, but every image keeps its own param description: values given to the flag are validated
+// and handed over using the param of each image, and images keep their own default if the flag isn't set.
+type imageParams struct {
+	descs      params.ParamDescs
+	flagParams *params.Params
+	images     [][]imageParam
+}
+
+type imageParam struct {
+	prefix string
+	param  *params.Param
+}
+
+// add adds the params of the next image; it must not be called after flags
+func (ip *imageParams) add(info *api.GadgetInfo) {
+	var image []imageParam
+	for _, p := range info.Params {
+		// Skip already registered params (but this still lets "operator.oci.<image-operator>." pass)
+		if p.Prefix == "operator.oci." {
+			continue
+		}
+		image = append(image, imageParam{
+			prefix: p.Prefix,
+			param:  apihelpers.ParamToParamDesc(p).ToParam(),
+		})
+
+		if desc := ip.descs.Get(p.Key); desc != nil {
+			mergeParamDesc(desc, apihelpers.ParamToParamDesc(p))
+			continue
+		}
+		ip.descs.Add(apihelpers.ParamToParamDesc(p))
+	}
+	ip.images = append(ip.images, image)
+}
+
+// mergeParamDesc widens desc, the description of a flag, so that it also accepts the values of other
+func mergeParamDesc(desc, other *params.ParamDesc) {
+	if desc.Type() != other.Type() {
+		desc.TypeHint = params.TypeString
+	}
+	if len(desc.PossibleValues) == 0 || len(other.PossibleValues) == 0 {
+		desc.PossibleValues = nil
+	} else {
+		for _, v := range other.PossibleValues {
+			if !slices.Contains(desc.PossibleValues, v) {
+				desc.PossibleValues = append(desc.PossibleValues, v)
+			}
+		}
+	}
+	if desc.DefaultValue != other.DefaultValue {
+		// Images keep their own default values
+		desc.DefaultValue = ""
+	}
+	desc.IsMandatory = desc.IsMandatory && other.IsMandatory
+}
+
+// flags returns the params to register as flags
+func (ip *imageParams) flags() *params.Params {
+	if ip.flagParams == nil {
+		ip.flagParams = ip.descs.ToParams()
+	}
+	return ip.flagParams
+}
+
+// values returns the param values of the i-th image. Values of flags that were set are validated using the params of
+// the image.
+func (ip *imageParams) values(i int) (api.ParamValues, error) {
+	flags := ip.flags()
+	values := make(api.ParamValues)
+	for _, p := range ip.images[i] {
+		flag := flags.Get(p.param.Key)
+		if flag != nil && flag.IsSet() {
+			err := p.param.Set(flag.String())
+			if err != nil {
+				return nil, fmt.Errorf("flag --%s: %w", p.param.Key, err)
+			}
+		}
+		if p.param.IsMandatory && p.param.String() == "" {
+			return nil, fmt.Errorf("flag --%s: expected value", p.param.Key)
+		}
+		values[p.prefix+p.param.Key] = p.param.String()
+	}
+	return values, nil
+}
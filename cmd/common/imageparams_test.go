@@ -0,0 +1,125 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+func newTestImageParams() *imageParams {
+	ip := &imageParams{}
+	ip.add(&api.GadgetInfo{Params: []*api.Param{
+		{Key: "pull", Prefix: "operator.oci."},
+		{Key: "count", Prefix: "operator.oci.ebpf.", TypeHint: api.TypeUint16, DefaultValue: "10"},
+		{Key: "mode", Prefix: "operator.oci.ebpf.", PossibleValues: []string{"a", "b"}, DefaultValue: "a"},
+		{Key: "verbose", Prefix: "operator.oci.ebpf.", TypeHint: api.TypeBool, DefaultValue: "false"},
+	}})
+	ip.add(&api.GadgetInfo{Params: []*api.Param{
+		{Key: "count", Prefix: "operator.oci.wasm.", TypeHint: api.TypeString, DefaultValue: "all"},
+		{Key: "mode", Prefix: "operator.oci.wasm.", PossibleValues: []string{"b", "c"}, DefaultValue: "c"},
+		{Key: "verbose", Prefix: "operator.oci.wasm.", TypeHint: api.TypeBool, DefaultValue: "false"},
+	}})
+	return ip
+}
+
+func TestImageParamsFlags(t *testing.T) {
+	flags := newTestImageParams().flags()
+	require.Len(t, *flags, 3)
+
+	// Params with different types are shared as string
+	count := flags.Get("count")
+	require.Equal(t, params.TypeString, count.TypeHint)
+	require.Equal(t, "", count.DefaultValue)
+
+	// Possible values are merged
+	mode := flags.Get("mode")
+	require.Equal(t, []string{"a", "b", "c"}, mode.PossibleValues)
+	require.Equal(t, "", mode.DefaultValue)
+
+	// Identical params are kept as they are
+	verbose := flags.Get("verbose")
+	require.Equal(t, params.TypeBool, verbose.TypeHint)
+	require.Equal(t, "false", verbose.DefaultValue)
+}
+
+func TestImageParamsValues(t *testing.T) {
+	ip := newTestImageParams()
+
+	// Images keep their own defaults
+	values, err := ip.values(0)
+	require.NoError(t, err)
+	require.Equal(t, api.ParamValues{
+		"operator.oci.ebpf.count":   "10",
+		"operator.oci.ebpf.mode":    "a",
+		"operator.oci.ebpf.verbose": "false",
+	}, values)
+
+	values, err = ip.values(1)
+	require.NoError(t, err)
+	require.Equal(t, api.ParamValues{
+		"operator.oci.wasm.count":   "all",
+		"operator.oci.wasm.mode":    "c",
+		"operator.oci.wasm.verbose": "false",
+	}, values)
+
+	// Values of flags are handed over to all images
+	require.NoError(t, ip.flags().Set("verbose", "true"))
+	require.NoError(t, ip.flags().Set("mode", "b"))
+	values, err = ip.values(1)
+	require.NoError(t, err)
+	require.Equal(t, "true", values["operator.oci.wasm.verbose"])
+	require.Equal(t, "b", values["operator.oci.wasm.mode"])
+}
+
+func TestImageParamsValidation(t *testing.T) {
+	ip := newTestImageParams()
+
+	// The flag accepts values of all images, but every image validates them using its own param
+	require.NoError(t, ip.flags().Set("count", "all"))
+	_, err := ip.values(0)
+	require.ErrorContains(t, err, "flag --count")
+	values, err := ip.values(1)
+	require.NoError(t, err)
+	require.Equal(t, "all", values["operator.oci.wasm.count"])
+
+	require.NoError(t, ip.flags().Set("count", "5"))
+	require.NoError(t, ip.flags().Set("mode", "c"))
+	_, err = ip.values(0)
+	require.ErrorContains(t, err, "flag --mode")
+
+	require.Error(t, ip.flags().Set("mode", "d"))
+}
+
+func TestImageParamsMandatory(t *testing.T) {
+	ip := &imageParams{}
+	ip.add(&api.GadgetInfo{Params: []*api.Param{{Key: "target", IsMandatory: true}}})
+	ip.add(&api.GadgetInfo{Params: []*api.Param{{Key: "target"}}})
+	require.False(t, ip.flags().Get("target").IsMandatory)
+
+	_, err := ip.values(0)
+	require.ErrorContains(t, err, "flag --target")
+	_, err = ip.values(1)
+	require.NoError(t, err)
+
+	require.NoError(t, ip.flags().Set("target", "x"))
+	values, err := ip.values(0)
+	require.NoError(t, err)
+	require.Equal(t, api.ParamValues{"target": "x"}, values)
+}
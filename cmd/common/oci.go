@@ -49,11 +49,15 @@ func NewRunCommand(rootCmd *cobra.Command, runtime runtime.Runtime, hiddenColumn
 	var info *api.GadgetInfo
 	paramLookup := map[string]*params.Param{}
 
+	// params of the images and of the shared output, if multiple images are run at once
+	var images imageParams
+	var outputParams api.Params
+
 	var timeoutSeconds int
 
 	cmd := &cobra.Command{
-		Use:          "run",
-		Short:        "Run a gadget",
+		Use:          "run IMAGE [IMAGE...]",
+		Short:        "Run one or more gadgets",
 		SilenceUsage: true, // do not print usage when there is an error
 		// We have to disable flag parsing in here to be able to handle certain
 		// flags more dynamically and have `--help` also react to those changes.
@@ -91,6 +95,40 @@ func NewRunCommand(rootCmd *cobra.Command, runtime runtime.Runtime, hiddenColumn
 			for _, op := range operators.GetDataOperators() {
 				ops = append(ops, op)
 			}
+
+			// GetOCIGadget needs at least the params from the oci handler, so let's prepare those in here
+			paramValueMap := make(map[string]string)
+			ociParams.CopyToMap(paramValueMap, "operator.oci.")
+
+			if len(actualArgs) > 1 {
+				gadgetCtx := gadgetcontext.NewComposite(
+					context.Background(),
+					actualArgs,
+					ops,
+					gadgetcontext.WithDataOperators(clioperator.CLIOperator),
+				)
+
+				infos, err := gadgetCtx.PrepareImages(runtime, runtimeParams, paramValueMap)
+				if err != nil {
+					return err
+				}
+				for _, info := range infos {
+					images.add(info)
+				}
+				gadgetParams.Add(*images.flags()...)
+
+				outputParams = gadgetCtx.Params()
+				for _, p := range outputParams {
+					param := apihelpers.ParamToParamDesc(p).ToParam()
+					paramLookup[p.Prefix+p.Key] = param
+					gadgetParams.Add(param)
+				}
+
+				AddFlags(cmd, &gadgetParams, nil, runtime)
+
+				return cmd.ParseFlags(args)
+			}
+
 			ops = append(ops, clioperator.CLIOperator)
 
 			gadgetCtx := gadgetcontext.New(
@@ -99,10 +137,6 @@ func NewRunCommand(rootCmd *cobra.Command, runtime runtime.Runtime, hiddenColumn
 				gadgetcontext.WithDataOperators(ops...),
 			)
 
-			// GetOCIGadget needs at least the params from the oci handler, so let's prepare those in here
-			paramValueMap := make(map[string]string)
-			ociParams.CopyToMap(paramValueMap, "operator.oci.")
-
 			// Fetch gadget information; TODO: this can potentially be cached
 			info, err = runtime.GetGadgetInfo(gadgetCtx, runtimeParams, paramValueMap)
 			if err != nil {
@@ -156,10 +190,38 @@ func NewRunCommand(rootCmd *cobra.Command, runtime runtime.Runtime, hiddenColumn
 			for _, op := range operators.GetDataOperators() {
 				ops = append(ops, op)
 			}
-			ops = append(ops, clioperator.CLIOperator)
 
 			timeoutDuration := time.Duration(timeoutSeconds) * time.Second
 
+			if len(args) > 1 {
+				gadgetCtx := gadgetcontext.NewComposite(
+					ctx,
+					args,
+					ops,
+					gadgetcontext.WithDataOperators(clioperator.CLIOperator),
+					gadgetcontext.WithTimeout(timeoutDuration),
+				)
+
+				paramValueMap := make(map[string]string)
+				for _, p := range outputParams {
+					paramValueMap[p.Prefix+p.Key] = paramLookup[p.Prefix+p.Key].String()
+				}
+
+				imageParamValues := make([]api.ParamValues, 0, len(args))
+				for i, imageName := range args {
+					values, err := images.values(i)
+					if err != nil {
+						return fmt.Errorf("%q: %w", imageName, err)
+					}
+					ociParams.CopyToMap(values, "operator.oci.")
+					imageParamValues = append(imageParamValues, values)
+				}
+
+				return gadgetCtx.RunImages(runtime, runtimeParams, paramValueMap, imageParamValues)
+			}
+
+			ops = append(ops, clioperator.CLIOperator)
+
 			gadgetCtx := gadgetcontext.New(
 				ctx,
 				args[0],
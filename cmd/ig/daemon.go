@@ -15,18 +15,26 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
 	"strconv"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	gadgetservice "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/prometheus"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/runtime"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/spiffe"
 )
 
 func newDaemonCommand(runtime runtime.Runtime) *cobra.Command {
@@ -39,7 +47,14 @@ func newDaemonCommand(runtime runtime.Runtime) *cobra.Command {
 
 	var socket string
 	var group string
+	var handoffSocket string
+	var configPath string
+	var restAddress string
+	var auditLog string
 	var eventBufferLength uint64
+	var processIsolation, worker bool
+	var spiffeSVID, spiffeSVIDKey, spiffeBundle string
+	var spiffeAuthorizedIDs []string
 
 	daemonCmd.PersistentFlags().StringVarP(
 		&group,
@@ -56,6 +71,36 @@ func newDaemonCommand(runtime runtime.Runtime) *cobra.Command {
 		"The socket to listen on for new requests. Can be a unix socket"+
 			" (unix:///path/to.socket) or a tcp socket (tcp://127.0.0.1:1234)")
 
+	daemonCmd.PersistentFlags().StringVarP(
+		&handoffSocket,
+		"handoff-socket",
+		"",
+		"",
+		"Path of a unix socket used to hand over the listener to a new daemon (e.g. on upgrades). If a daemon is"+
+			" already running using the same path, its listener will be taken over")
+
+	daemonCmd.PersistentFlags().StringVarP(
+		&configPath,
+		"config",
+		"",
+		"",
+		"Path of the daemon configuration file. It is reloaded when it changes or when receiving SIGHUP")
+
+	daemonCmd.PersistentFlags().StringVarP(
+		&restAddress,
+		"rest-address",
+		"",
+		"",
+		"Address (host:port) to serve the REST API at. The REST API is disabled if empty")
+
+	daemonCmd.PersistentFlags().StringVarP(
+		&auditLog,
+		"audit-log",
+		"",
+		"",
+		"Backend to persist the audit log of gadget runs to: file:<path> or k8s-events[:<namespace>]. Recent records"+
+			" can be queried from the daemon in any case")
+
 	daemonCmd.PersistentFlags().Uint64VarP(
 		&eventBufferLength,
 		"events-buffer-length",
@@ -63,6 +108,53 @@ func newDaemonCommand(runtime runtime.Runtime) *cobra.Command {
 		16384,
 		"The events buffer length. A low value could impact horizontal scaling.")
 
+	daemonCmd.PersistentFlags().BoolVarP(
+		&processIsolation,
+		"process-isolation",
+		"",
+		false,
+		"Run each gadget in a separate worker process, so a crashing or leaking gadget doesn't affect the others."+
+			" Detached gadgets and gadgets of the configuration file are still run by the daemon process")
+
+	// Used internally to start the worker processes of --process-isolation
+	daemonCmd.PersistentFlags().BoolVarP(
+		&worker,
+		"worker",
+		"",
+		false,
+		"Serve a single gadget run and exit afterwards")
+	daemonCmd.PersistentFlags().MarkHidden("worker")
+
+	daemonCmd.PersistentFlags().StringVarP(
+		&spiffeSVID,
+		"spiffe-svid",
+		"",
+		"",
+		"Path of the X.509 SVID (PEM) to authenticate with. If set, clients must authenticate with an SVID issued by"+
+			" the trust bundle")
+
+	daemonCmd.PersistentFlags().StringVarP(
+		&spiffeSVIDKey,
+		"spiffe-svid-key",
+		"",
+		"",
+		"Path of the private key (PEM) of the X.509 SVID")
+
+	daemonCmd.PersistentFlags().StringVarP(
+		&spiffeBundle,
+		"spiffe-bundle",
+		"",
+		"",
+		"Path of the SPIFFE trust bundle (PEM) used to verify clients")
+
+	daemonCmd.PersistentFlags().StringSliceVarP(
+		&spiffeAuthorizedIDs,
+		"spiffe-authorized-ids",
+		"",
+		nil,
+		"SPIFFE IDs of the clients allowed to connect. A trust domain (spiffe://example.org) allows all of its"+
+			" workloads. All clients with a valid SVID are allowed if empty")
+
 	daemonCmd.RunE = func(cmd *cobra.Command, args []string) error {
 		if os.Geteuid() != 0 {
 			return fmt.Errorf("%s must be run as root to be able to run eBPF programs", filepath.Base(os.Args[0]))
@@ -85,14 +177,102 @@ func newDaemonCommand(runtime runtime.Runtime) *cobra.Command {
 			return fmt.Errorf("group %q not found", group)
 		}
 
-		log.Infof("starting Inspektor Gadget daemon at %q", socket)
+		var serverOptions []grpc.ServerOption
+		var tlsConfig *tls.Config
+		if spiffeSVID != "" || spiffeSVIDKey != "" || spiffeBundle != "" {
+			source, err := spiffe.NewFileSource(spiffeSVID, spiffeSVIDKey, spiffeBundle)
+			if err != nil {
+				return fmt.Errorf("loading SPIFFE SVID: %w", err)
+			}
+			authorize := spiffe.AuthorizeAny()
+			if len(spiffeAuthorizedIDs) > 0 {
+				authorize, err = spiffe.AuthorizeIDs(spiffeAuthorizedIDs...)
+				if err != nil {
+					return fmt.Errorf("invalid authorized SPIFFE IDs: %w", err)
+				}
+			}
+			tlsConfig = spiffe.ServerTLSConfig(source, authorize)
+			serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		} else if len(spiffeAuthorizedIDs) > 0 {
+			return errors.New("--spiffe-authorized-ids requires --spiffe-svid, --spiffe-svid-key and --spiffe-bundle")
+		}
+
 		service := gadgetservice.NewService(log.StandardLogger(), eventBufferLength)
+		if worker {
+			if socketType != "unix" || len(serverOptions) > 0 {
+				return errors.New("--worker requires a unix socket and no authentication")
+			}
+			return service.Run(gadgetservice.RunConfig{
+				SocketType: socketType,
+				SocketPath: socketPath,
+				Worker:     true,
+			})
+		}
+		if processIsolation {
+			executable, err := os.Executable()
+			if err != nil {
+				return fmt.Errorf("getting path of executable for worker processes: %w", err)
+			}
+			flags := workerFlags(cmd)
+			service.SetWorkerCommand(func(socketPath string) *exec.Cmd {
+				return workerCommand(executable, socketPath, eventBufferLength, flags)
+			})
+		}
+
+		var auditBackend gadgetservice.AuditBackend
+		if auditLog != "" {
+			auditBackend, err = gadgetservice.NewAuditBackend(auditLog)
+			if err != nil {
+				return fmt.Errorf("creating audit log: %w", err)
+			}
+		}
+
+		log.Infof("starting Inspektor Gadget daemon at %q", socket)
 		return service.Run(gadgetservice.RunConfig{
-			SocketType: socketType,
-			SocketPath: socketPath,
-			SocketGID:  gid,
-		})
+			SocketType:    socketType,
+			SocketPath:    socketPath,
+			SocketGID:     gid,
+			HandoffPath:   handoffSocket,
+			ConfigPath:    configPath,
+			RESTAddress:   restAddress,
+			RESTTLSConfig: tlsConfig,
+			AuditBackend:  auditBackend,
+		}, serverOptions...)
 	}
 
+	daemonCmd.AddCommand(newDaemonInstallCommand())
+
 	return daemonCmd
 }
+
+// workerFlags returns the flags of the root command (like the host workarounds and the global params of operators)
+// that were set for the daemon, so that workers run gadgets the same way. Listeners of operators are disabled, as
+// they're already served by the daemon and would fail to bind in the worker.
+func workerFlags(cmd *cobra.Command) []string {
+	var flags []string
+	cmd.InheritedFlags().Visit(func(f *pflag.Flag) {
+		if f.Name == prometheus.ParamListenAddress {
+			return
+		}
+		if values, ok := f.Value.(pflag.SliceValue); ok {
+			for _, value := range values.GetSlice() {
+				flags = append(flags, "--"+f.Name+"="+value)
+			}
+			return
+		}
+		flags = append(flags, "--"+f.Name+"="+f.Value.String())
+	})
+	return append(flags, "--"+prometheus.ParamListenAddress+"=")
+}
+
+// workerCommand returns the command to start a worker process listening on socketPath
+func workerCommand(executable, socketPath string, eventBufferLength uint64, flags []string) *exec.Cmd {
+	args := []string{
+		"daemon",
+		"--worker",
+		"--host", "unix://" + socketPath,
+		"--events-buffer-length", strconv.FormatUint(eventBufferLength, 10),
+	}
+	args = append(args, flags...)
+	return exec.Command(executable, args...)
+}
@@ -0,0 +1,173 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+const (
+	serviceUnitName = "ig.service"
+	socketUnitName  = "ig.socket"
+)
+
+var socketUnitTemplate = template.Must(template.New(socketUnitName).Parse(`[Unit]
+Description=Inspektor Gadget socket
+
+[Socket]
+ListenStream={{ .ListenStream }}
+{{- if .SocketGroup }}
+SocketGroup={{ .SocketGroup }}
+{{- end }}
+{{- if .Unix }}
+SocketMode=0660
+DirectoryMode=0710
+{{- end }}
+
+[Install]
+WantedBy=sockets.target
+`))
+
+var serviceUnitTemplate = template.Must(template.New(serviceUnitName).Parse(`[Unit]
+Description=Inspektor Gadget
+Requires=` + socketUnitName + `
+After=` + socketUnitName + `
+
+[Service]
+Type=notify
+User=root
+Restart=on-failure
+RestartSec=30
+ExecStart={{ .ExecStart }}
+
+[Install]
+WantedBy=multi-user.target
+Also=` + socketUnitName + `
+`))
+
+// quoteUnitArg quotes an argument of ExecStart if needed
+func quoteUnitArg(arg string) string {
+	if arg == "" || strings.ContainsAny(arg, " \t\"'\\;$%") {
+		return strconv.Quote(arg)
+	}
+	return arg
+}
+
+func newDaemonInstallCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "install",
+		Short: "Install systemd units to run the daemon",
+		Long: `Generate systemd units to run the daemon as a service using socket activation.
+
+The flags of the daemon command given to install are used when the daemon is started. The units are written to
+the given directory; enable them afterwards using "systemctl daemon-reload && systemctl enable --now ` + socketUnitName + `".`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+	}
+
+	var unitDir string
+	var print bool
+
+	cmd.Flags().StringVar(&unitDir, "unit-dir", "/etc/systemd/system", "Directory to write the systemd units to")
+	cmd.Flags().BoolVar(&print, "print", false, "Print the systemd units instead of writing them")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		host, err := cmd.Flags().GetString("host")
+		if err != nil {
+			return err
+		}
+		group, err := cmd.Flags().GetString("group")
+		if err != nil {
+			return err
+		}
+
+		socketType, socketPath, err := api.ParseSocketAddress(host)
+		if err != nil {
+			return fmt.Errorf("invalid daemon-socket address: %w", err)
+		}
+
+		executable, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("getting path of executable: %w", err)
+		}
+
+		// Start the daemon with the same flags given to install
+		execStart := []string{quoteUnitArg(executable), "daemon"}
+		cmd.InheritedFlags().VisitAll(func(f *pflag.Flag) {
+			if !f.Changed {
+				return
+			}
+			value := f.Value.String()
+			if sv, ok := f.Value.(pflag.SliceValue); ok {
+				value = strings.Join(sv.GetSlice(), ",")
+			}
+			execStart = append(execStart, quoteUnitArg("--"+f.Name+"="+value))
+		})
+
+		socketGroup := ""
+		if group != "0" && group != "root" {
+			socketGroup = group
+		}
+
+		units := map[string]*bytes.Buffer{
+			socketUnitName:  {},
+			serviceUnitName: {},
+		}
+		err = socketUnitTemplate.Execute(units[socketUnitName], map[string]any{
+			"ListenStream": socketPath,
+			"SocketGroup":  socketGroup,
+			"Unix":         socketType == "unix",
+		})
+		if err != nil {
+			return fmt.Errorf("generating %s: %w", socketUnitName, err)
+		}
+		err = serviceUnitTemplate.Execute(units[serviceUnitName], map[string]any{
+			"ExecStart": strings.Join(execStart, " "),
+		})
+		if err != nil {
+			return fmt.Errorf("generating %s: %w", serviceUnitName, err)
+		}
+
+		for _, name := range []string{socketUnitName, serviceUnitName} {
+			if print {
+				fmt.Fprintf(cmd.OutOrStdout(), "# %s\n%s\n", name, units[name])
+				continue
+			}
+			path := filepath.Join(unitDir, name)
+			if err := os.WriteFile(path, units[name].Bytes(), 0o644); err != nil {
+				return fmt.Errorf("writing %s: %w", name, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Wrote %s\n", path)
+		}
+		if !print {
+			fmt.Fprintf(cmd.OutOrStdout(), "Run \"systemctl daemon-reload && systemctl enable --now %s\" to start the daemon\n",
+				socketUnitName)
+		}
+		return nil
+	}
+
+	return cmd
+}
@@ -27,6 +27,7 @@ import (
 	ocihandler "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/oci-handler"
 
 	"github.com/inspektor-gadget/inspektor-gadget/cmd/common"
+	"github.com/inspektor-gadget/inspektor-gadget/cmd/common/catalog"
 	"github.com/inspektor-gadget/inspektor-gadget/cmd/common/image"
 	commonutils "github.com/inspektor-gadget/inspektor-gadget/cmd/common/utils"
 	"github.com/inspektor-gadget/inspektor-gadget/cmd/ig/containers"
@@ -40,12 +41,35 @@ import (
 	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/traceloop/tracer"
 
 	// Another blank import for the used operator
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/aggregate"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/audit"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/blockdev"
 	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/btfgen"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/buffer"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/dns"
 	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/ebpf"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/exechash"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/fallback"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/filesink"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/filter"
 	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/formatters"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/gpu"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/ioc"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/kafka"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/kmsg"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/legacy"
 	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/localmanager"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/nettopology"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/numa"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/otlp"
 	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/prometheus"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/reversedns"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/sample"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/snapshotdiff"
 	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/socketenricher"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/stats"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/symbolizer"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/synthetic"
 	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/uidgidresolver"
 )
 
@@ -84,6 +108,8 @@ func main() {
 
 	rootCmd.AddCommand(newDaemonCommand(runtime))
 	rootCmd.AddCommand(image.NewImageCmd())
+	rootCmd.AddCommand(catalog.NewCatalogCmd())
+	rootCmd.AddCommand(catalog.NewSearchCmd())
 	rootCmd.AddCommand(common.NewLoginCmd())
 	rootCmd.AddCommand(common.NewLogoutCmd())
 	rootCmd.AddCommand(common.NewRunCommand(rootCmd, runtime, hiddenColumnTags))
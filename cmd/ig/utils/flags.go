@@ -60,19 +60,16 @@ type CommonFlags struct {
 	// RuntimeProtocol specifies whether to use the CRI API to talk to the runtime.
 	// Useful for docker and containerd.
 	// CRI-O is always using the CRI API. Podman is always using the internal API.
-	// Supported values: internal, cri.
+	// Supported values: internal, cri. Several values can be given separated by
+	// comma, in which case they are tried in order until one works.
 	RuntimeProtocol string
 }
 
 func AddCommonFlags(command *cobra.Command, commonFlags *CommonFlags) {
 	command.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
 		// Runtimes Configuration
-		switch commonFlags.RuntimeProtocol {
-		case containerutilsTypes.RuntimeProtocolInternal:
-		case containerutilsTypes.RuntimeProtocolCRI:
-		default:
-			return commonutils.WrapInErrInvalidArg("--runtime-protocol",
-				fmt.Errorf("runtime protocol %q is not supported", commonFlags.RuntimeProtocol))
+		if _, err := containerutils.ParseRuntimeProtocols(commonFlags.RuntimeProtocol); err != nil {
+			return commonutils.WrapInErrInvalidArg("--runtime-protocol", err)
 		}
 
 		parts := strings.Split(commonFlags.Runtimes, ",")
@@ -172,8 +169,8 @@ func AddCommonFlags(command *cobra.Command, commonFlags *CommonFlags) {
 	command.PersistentFlags().StringVar(
 		&commonFlags.RuntimeProtocol,
 		"runtime-protocol",
-		containerutilsTypes.RuntimeProtocolInternal,
-		fmt.Sprintf("Container runtime protocol (docker and containerd). Supported values are: %s",
+		strings.Join(containerutils.AvailableRuntimeProtocols, ","),
+		fmt.Sprintf("Container runtime protocols (docker and containerd) separated by comma, in the order they are tried. Supported values are: %s",
 			strings.Join(containerutils.AvailableRuntimeProtocols, ", ")),
 	)
 }
@@ -412,6 +412,13 @@ func createAffinity(client *kubernetes.Clientset) (*v1.Affinity, error) {
 }
 
 func runDeploy(cmd *cobra.Command, args []string) error {
+	if exportHelm != "" {
+		if _, err := loadDeployValues(cmd); err != nil {
+			return err
+		}
+		return exportHelmChart(exportHelm)
+	}
+
 	gadgetNamespace := runtimeGlobalParams.Get(grpcruntime.ParamGadgetNamespace).AsString()
 	if !printOnly {
 		gadgetNamespaces, err := utils.GetRunningGadgetNamespaces()
@@ -441,6 +448,22 @@ func runDeploy(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("it's not possible to use --quiet and --debug together")
 	}
 
+	if minimalPrivileges {
+		mode, err := minimalPrivilegesHookMode(hookMode)
+		if err != nil {
+			return err
+		}
+		if mode != hookMode {
+			info("Using --hook-mode=%s because of --minimal-privileges\n", mode)
+			hookMode = mode
+		}
+	}
+
+	values, err := loadDeployValues(cmd)
+	if err != nil {
+		return err
+	}
+
 	objects, err := parseK8sYaml(resources.GadgetDeployment)
 	if err != nil {
 		return err
@@ -637,6 +660,12 @@ func runDeploy(cmd *cobra.Command, args []string) error {
 				daemonSet.Spec.Template.Spec.Affinity = affinity
 			}
 
+			values.apply(daemonSet)
+
+			if minimalPrivileges {
+				applyMinimalPrivileges(gadgetContainer)
+			}
+
 			// skip SELinux options if the user explicitly requests it
 			if legacyHostPID || skipSELinuxOpts {
 				gadgetContainer.SecurityContext.SELinuxOptions = nil
@@ -0,0 +1,141 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/distribution/reference"
+
+	"github.com/inspektor-gadget/inspektor-gadget/charts"
+	"github.com/inspektor-gadget/inspektor-gadget/internal/version"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/resources"
+)
+
+const (
+	chartName    = "gadget"
+	chartCRDFile = "crds/gadget.kinvolk.io_traces.yaml"
+)
+
+var exportHelm string
+
+func init() {
+	deployCmd.PersistentFlags().StringVarP(
+		&exportHelm,
+		"export-helm", "",
+		"",
+		"write the Helm chart equivalent to the deployed resources to the given directory instead of deploying")
+}
+
+// setChartValue replaces the value of key in the given section of values.yaml, keeping the comments of the file
+func setChartValue(values []byte, section, key, value string) ([]byte, error) {
+	re := regexp.MustCompile(`(?m)^(` + regexp.QuoteMeta(section) + `:\n(?:[ #].*\n)*?  ` + regexp.QuoteMeta(key) + `:).*$`)
+	if !re.Match(values) {
+		return nil, fmt.Errorf("%s.%s not found in values", section, key)
+	}
+	return re.ReplaceAll(values, []byte("${1} "+strings.ReplaceAll(fmt.Sprintf("%q", value), "$", "$$"))), nil
+}
+
+// chartValues returns the values.yaml of the chart with the defaults of the image set according to the flags
+func chartValues(values []byte) ([]byte, error) {
+	var err error
+	if image != "" && image != "undefined" {
+		ref, err := reference.ParseNormalizedNamed(image)
+		if err != nil {
+			return nil, fmt.Errorf("parsing image name %q: %w", image, err)
+		}
+		values, err = setChartValue(values, "image", "repository", ref.Name())
+		if err != nil {
+			return nil, err
+		}
+		tag := ""
+		if tagged, ok := ref.(reference.Tagged); ok {
+			tag = tagged.Tag()
+		}
+		values, err = setChartValue(values, "image", "tag", tag)
+		if err != nil {
+			return nil, err
+		}
+	}
+	values, err = setChartValue(values, "image", "pullPolicy", imagePullPolicy)
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// exportHelmChart writes the gadget chart to dir/gadget. The chart is the one the built-in manifests are generated
+// from, with its version and default image matching this binary.
+func exportHelmChart(dir string) error {
+	chartDir := filepath.Join(dir, chartName)
+	if _, err := os.Stat(chartDir); err == nil {
+		return fmt.Errorf("%q already exists", chartDir)
+	}
+
+	err := fs.WalkDir(charts.Gadget, chartName, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(chartName, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(chartDir, rel)
+		if d.IsDir() {
+			return os.MkdirAll(dest, 0o755)
+		}
+
+		content, err := fs.ReadFile(charts.Gadget, path)
+		if err != nil {
+			return err
+		}
+		switch rel {
+		case "README.md.gotmpl":
+			// Only used to generate the documentation of the chart
+			return nil
+		case "Chart.yaml.tmpl":
+			dest = filepath.Join(chartDir, "Chart.yaml")
+			content = []byte(strings.NewReplacer(
+				"%VERSION%", version.Version().String(),
+				"%APP_VERSION%", "v"+version.Version().String(),
+			).Replace(string(content)))
+		case "values.yaml":
+			content, err = chartValues(content)
+			if err != nil {
+				return fmt.Errorf("setting chart values: %w", err)
+			}
+		}
+		return os.WriteFile(dest, content, 0o644)
+	})
+	if err != nil {
+		return fmt.Errorf("exporting chart: %w", err)
+	}
+
+	crdPath := filepath.Join(chartDir, chartCRDFile)
+	if err := os.MkdirAll(filepath.Dir(crdPath), 0o755); err != nil {
+		return fmt.Errorf("exporting chart: %w", err)
+	}
+	if err := os.WriteFile(crdPath, []byte(resources.TracesCustomResource), 0o644); err != nil {
+		return fmt.Errorf("exporting chart: %w", err)
+	}
+
+	info("Helm chart written to %s\n", chartDir)
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+var minimalPrivileges bool
+
+// minimalCapabilities replace the default capabilities of the gadget container with --minimal-privileges. Compared
+// to them, CAP_SYS_ADMIN is replaced by CAP_BPF and CAP_PERFMON (Linux 5.8+), which are enough to load and attach the
+// eBPF programs of most gadgets. Whether a gadget can run with the resulting capabilities is checked when it's
+// started.
+var minimalCapabilities = []v1.Capability{
+	// Load eBPF programs and create maps
+	"BPF",
+	// Attach kprobes, tracepoints and other tracing programs
+	"PERFMON",
+	// Attach networking programs and qdiscs
+	"NET_ADMIN",
+	// Open raw sockets for socket filter programs
+	"NET_RAW",
+	// Access /proc/$pid/ns/* of host processes
+	"SYS_PTRACE",
+	// Remove the memlock limit on kernels without memcg based accounting
+	"SYS_RESOURCE",
+	"IPC_LOCK",
+	// Read the addresses of /proc/kallsyms
+	"SYSLOG",
+}
+
+func init() {
+	deployCmd.PersistentFlags().BoolVarP(
+		&minimalPrivileges,
+		"minimal-privileges", "",
+		false,
+		"run the gadget pod with a minimal set of capabilities (CAP_BPF and CAP_PERFMON instead of CAP_SYS_ADMIN); requires Linux 5.8 or later")
+}
+
+// minimalPrivilegesHookMode returns the hook mode to use with --minimal-privileges: the fanotify based modes need
+// CAP_SYS_ADMIN, so auto falls back to the pod informer
+func minimalPrivilegesHookMode(mode string) (string, error) {
+	switch mode {
+	case "auto":
+		return "podinformer", nil
+	case "fanotify", "fanotify+ebpf":
+		return "", fmt.Errorf("--hook-mode=%s requires CAP_SYS_ADMIN and can't be used with --minimal-privileges", mode)
+	}
+	return mode, nil
+}
+
+// applyMinimalPrivileges drops all capabilities of the gadget container but minimalCapabilities
+func applyMinimalPrivileges(gadgetContainer *v1.Container) {
+	if gadgetContainer.SecurityContext == nil {
+		gadgetContainer.SecurityContext = &v1.SecurityContext{}
+	}
+	gadgetContainer.SecurityContext.Capabilities = &v1.Capabilities{
+		Drop: []v1.Capability{"ALL"},
+		Add:  minimalCapabilities,
+	}
+}
@@ -16,9 +16,17 @@ package main
 
 import (
 	"bytes"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
 	"github.com/inspektor-gadget/inspektor-gadget/cmd/common"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/resources"
 	grpcruntime "github.com/inspektor-gadget/inspektor-gadget/pkg/runtime/grpc"
 )
 
@@ -37,3 +45,144 @@ func TestPrintOnly(t *testing.T) {
 		t.Fatalf("Error while running command: %s", stdErr.String())
 	}
 }
+
+func TestParseToleration(t *testing.T) {
+	tests := map[string]v1.Toleration{
+		"":                     {Operator: v1.TolerationOpExists},
+		":NoExecute":           {Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute},
+		"dedicated":            {Key: "dedicated", Operator: v1.TolerationOpExists},
+		"dedicated=ig:NoSched": {},
+		"dedicated=ig:NoSchedule": {
+			Key: "dedicated", Value: "ig", Operator: v1.TolerationOpEqual, Effect: v1.TaintEffectNoSchedule,
+		},
+		"=ig": {},
+	}
+	for s, expected := range tests {
+		toleration, err := parseToleration(s)
+		if expected == (v1.Toleration{}) {
+			require.Error(t, err, s)
+			continue
+		}
+		require.NoError(t, err, s)
+		require.Equal(t, expected, toleration, s)
+	}
+}
+
+func TestReplaceImageRegistry(t *testing.T) {
+	res, err := replaceImageRegistry("ghcr.io/inspektor-gadget/inspektor-gadget:v0.30.0", "mirror.example.com:5000/")
+	require.NoError(t, err)
+	require.Equal(t, "mirror.example.com:5000/inspektor-gadget/inspektor-gadget:v0.30.0", res)
+
+	res, err = replaceImageRegistry("busybox", "mirror.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "mirror.example.com/library/busybox", res)
+}
+
+func TestDeployValues(t *testing.T) {
+	oldImage, oldAppArmorProfile, oldValuesFile := image, appArmorprofile, valuesFile
+	t.Cleanup(func() {
+		image, appArmorprofile, valuesFile, imageRegistry = oldImage, oldAppArmorProfile, oldValuesFile, ""
+	})
+
+	valuesFile = filepath.Join(t.TempDir(), "values.yaml")
+	err := os.WriteFile(valuesFile, []byte(`
+image: ghcr.io/inspektor-gadget/inspektor-gadget:v0.30.0
+imageRegistry: mirror.example.com
+appArmorProfile: localhost/ig
+resources:
+  requests:
+    cpu: 100m
+  limits:
+    memory: 1Gi
+nodeSelector:
+  pool: monitoring
+tolerations:
+- key: dedicated
+  operator: Exists
+`), 0o644)
+	require.NoError(t, err)
+
+	values, err := loadDeployValues(deployCmd)
+	require.NoError(t, err)
+	require.Equal(t, "mirror.example.com/inspektor-gadget/inspektor-gadget:v0.30.0", image)
+	require.Equal(t, "localhost/ig", appArmorprofile)
+
+	objects, err := parseK8sYaml(resources.GadgetDeployment)
+	require.NoError(t, err)
+	for _, object := range objects {
+		daemonSet, ok := object.(*appsv1.DaemonSet)
+		if !ok {
+			continue
+		}
+		values.apply(daemonSet)
+		podSpec := daemonSet.Spec.Template.Spec
+		require.Equal(t, resource.MustParse("100m"), podSpec.Containers[0].Resources.Requests[v1.ResourceCPU])
+		require.Equal(t, resource.MustParse("1Gi"), podSpec.Containers[0].Resources.Limits[v1.ResourceMemory])
+		require.Equal(t, map[string]string{"kubernetes.io/os": "linux", "pool": "monitoring"}, podSpec.NodeSelector)
+		require.Equal(t, []v1.Toleration{{Key: "dedicated", Operator: v1.TolerationOpExists}}, podSpec.Tolerations)
+		return
+	}
+	t.Fatal("no DaemonSet found")
+}
+
+func TestDeployValuesInvalid(t *testing.T) {
+	oldValuesFile := valuesFile
+	t.Cleanup(func() { valuesFile = oldValuesFile })
+
+	valuesFile = filepath.Join(t.TempDir(), "values.yaml")
+	require.NoError(t, os.WriteFile(valuesFile, []byte("unknown: true\n"), 0o644))
+	_, err := loadDeployValues(deployCmd)
+	require.Error(t, err)
+}
+
+func TestExportHelm(t *testing.T) {
+	oldImage := image
+	t.Cleanup(func() { image = oldImage })
+	image = "registry.example.com/inspektor-gadget:v0.30.0"
+
+	dir := t.TempDir()
+	require.NoError(t, exportHelmChart(dir))
+
+	for _, file := range []string{"Chart.yaml", "templates/daemonset.yaml", chartCRDFile} {
+		require.FileExists(t, filepath.Join(dir, chartName, file))
+	}
+	require.NoFileExists(t, filepath.Join(dir, chartName, "Chart.yaml.tmpl"))
+
+	values, err := os.ReadFile(filepath.Join(dir, chartName, "values.yaml"))
+	require.NoError(t, err)
+	require.Contains(t, string(values), `repository: "registry.example.com/inspektor-gadget"`)
+	require.Contains(t, string(values), `tag: "v0.30.0"`)
+
+	// Existing charts aren't overwritten
+	require.Error(t, exportHelmChart(dir))
+}
+
+func TestMinimalPrivileges(t *testing.T) {
+	mode, err := minimalPrivilegesHookMode("auto")
+	require.NoError(t, err)
+	require.Equal(t, "podinformer", mode)
+	mode, err = minimalPrivilegesHookMode("crio")
+	require.NoError(t, err)
+	require.Equal(t, "crio", mode)
+	_, err = minimalPrivilegesHookMode("fanotify+ebpf")
+	require.Error(t, err)
+
+	objects, err := parseK8sYaml(resources.GadgetDeployment)
+	require.NoError(t, err)
+	for _, object := range objects {
+		daemonSet, ok := object.(*appsv1.DaemonSet)
+		if !ok {
+			continue
+		}
+		gadgetContainer := &daemonSet.Spec.Template.Spec.Containers[0]
+		require.Contains(t, gadgetContainer.SecurityContext.Capabilities.Add, v1.Capability("SYS_ADMIN"))
+		applyMinimalPrivileges(gadgetContainer)
+		require.Equal(t, []v1.Capability{"ALL"}, gadgetContainer.SecurityContext.Capabilities.Drop)
+		require.NotContains(t, gadgetContainer.SecurityContext.Capabilities.Add, v1.Capability("SYS_ADMIN"))
+		require.Contains(t, gadgetContainer.SecurityContext.Capabilities.Add, v1.Capability("BPF"))
+		require.Contains(t, gadgetContainer.SecurityContext.Capabilities.Add, v1.Capability("PERFMON"))
+		require.NotNil(t, gadgetContainer.SecurityContext.SELinuxOptions)
+		return
+	}
+	t.Fatal("no DaemonSet found")
+}
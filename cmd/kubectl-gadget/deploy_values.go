@@ -0,0 +1,230 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// deployValues customizes the gadget DaemonSet. The values are read from the file given by --values; flags that are
+// set explicitly take precedence over the file.
+type deployValues struct {
+	Image           string `json:"image,omitempty"`
+	ImagePullPolicy string `json:"imagePullPolicy,omitempty"`
+
+	// ImageRegistry replaces the registry of the image, e.g. to use a mirror
+	ImageRegistry string `json:"imageRegistry,omitempty"`
+
+	Resources v1.ResourceRequirements `json:"resources,omitempty"`
+
+	// NodeSelector is added to the node selector of the DaemonSet
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Tolerations replace the default tolerations, which tolerate all taints
+	Tolerations []v1.Toleration `json:"tolerations,omitempty"`
+
+	// SeccompProfile is the path of a file containing a SeccompProfile resource
+	SeccompProfile  string `json:"seccompProfile,omitempty"`
+	AppArmorProfile string `json:"appArmorProfile,omitempty"`
+}
+
+var (
+	valuesFile       string
+	imageRegistry    string
+	resourceRequests map[string]string
+	resourceLimits   map[string]string
+	tolerations      []string
+)
+
+func init() {
+	deployCmd.PersistentFlags().StringVarP(
+		&valuesFile,
+		"values", "",
+		"",
+		"YAML file with values customizing the deployment (image, imageRegistry, imagePullPolicy, resources, nodeSelector, tolerations, seccompProfile, appArmorProfile)")
+	deployCmd.PersistentFlags().StringVarP(
+		&imageRegistry,
+		"image-registry", "",
+		"",
+		"replace the registry of the container image, e.g. to use a mirror")
+	deployCmd.PersistentFlags().StringToStringVarP(
+		&resourceRequests,
+		"resources-requests", "",
+		nil,
+		"resource requests of the gadget container, e.g. cpu=100m,memory=256Mi")
+	deployCmd.PersistentFlags().StringToStringVarP(
+		&resourceLimits,
+		"resources-limits", "",
+		nil,
+		"resource limits of the gadget container, e.g. memory=1Gi")
+	deployCmd.PersistentFlags().StringSliceVarP(
+		&tolerations,
+		"toleration", "",
+		nil,
+		"toleration of the gadget pod as key[=value][:effect], replacing the default ones; can be given multiple times")
+}
+
+func parseResourceList(values map[string]string) (v1.ResourceList, error) {
+	res := v1.ResourceList{}
+	for name, value := range values {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing quantity of %q: %w", name, err)
+		}
+		res[v1.ResourceName(name)] = quantity
+	}
+	return res, nil
+}
+
+// parseToleration parses a toleration given as key[=value][:effect]; the operator is Equal if a value is given and
+// Exists otherwise
+func parseToleration(s string) (v1.Toleration, error) {
+	keyValue, effect, _ := strings.Cut(s, ":")
+	toleration := v1.Toleration{
+		Operator: v1.TolerationOpExists,
+		Effect:   v1.TaintEffect(effect),
+	}
+	switch toleration.Effect {
+	case "", v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+	default:
+		return toleration, fmt.Errorf("invalid effect %q in toleration %q", effect, s)
+	}
+	if key, value, ok := strings.Cut(keyValue, "="); ok {
+		if key == "" {
+			return toleration, fmt.Errorf("toleration %q has a value but no key", s)
+		}
+		toleration.Key = key
+		toleration.Value = value
+		toleration.Operator = v1.TolerationOpEqual
+	} else {
+		toleration.Key = keyValue
+	}
+	return toleration, nil
+}
+
+// replaceImageRegistry returns image with its registry replaced by registry
+func replaceImageRegistry(image, registry string) (string, error) {
+	ref, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", fmt.Errorf("parsing image name %q: %w", image, err)
+	}
+	res := strings.TrimSuffix(registry, "/") + "/" + reference.Path(ref)
+	if tagged, ok := ref.(reference.Tagged); ok {
+		res += ":" + tagged.Tag()
+	}
+	if digested, ok := ref.(reference.Digested); ok {
+		res += "@" + digested.Digest().String()
+	}
+	return res, nil
+}
+
+// loadDeployValues reads the values file and merges it with the flags given to cmd. It updates the variables of
+// the flags that have a corresponding value, so the remaining deploy logic can use them unchanged.
+func loadDeployValues(cmd *cobra.Command) (*deployValues, error) {
+	values := &deployValues{}
+	if valuesFile != "" {
+		content, err := os.ReadFile(valuesFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading values: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(content, values); err != nil {
+			return nil, fmt.Errorf("decoding values %q: %w", valuesFile, err)
+		}
+	}
+
+	flags := cmd.Flags()
+	for _, v := range []struct {
+		flag  string
+		value string
+		dest  *string
+	}{
+		{"image", values.Image, &image},
+		{"image-pull-policy", values.ImagePullPolicy, &imagePullPolicy},
+		{"image-registry", values.ImageRegistry, &imageRegistry},
+		{"seccomp-profile", values.SeccompProfile, &seccompProfile},
+		{"apparmor-profile", values.AppArmorProfile, &appArmorprofile},
+	} {
+		if v.value != "" && !flags.Changed(v.flag) {
+			*v.dest = v.value
+		}
+	}
+
+	if flags.Changed("resources-requests") {
+		requests, err := parseResourceList(resourceRequests)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --resources-requests: %w", err)
+		}
+		values.Resources.Requests = requests
+	}
+	if flags.Changed("resources-limits") {
+		limits, err := parseResourceList(resourceLimits)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --resources-limits: %w", err)
+		}
+		values.Resources.Limits = limits
+	}
+	if flags.Changed("toleration") {
+		values.Tolerations = make([]v1.Toleration, 0, len(tolerations))
+		for _, s := range tolerations {
+			toleration, err := parseToleration(s)
+			if err != nil {
+				return nil, err
+			}
+			values.Tolerations = append(values.Tolerations, toleration)
+		}
+	}
+
+	if imageRegistry != "" {
+		var err error
+		image, err = replaceImageRegistry(image, imageRegistry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+// apply applies the values that don't have a corresponding flag to the gadget DaemonSet
+func (values *deployValues) apply(daemonSet *appsv1.DaemonSet) {
+	podSpec := &daemonSet.Spec.Template.Spec
+	gadgetContainer := &podSpec.Containers[0]
+
+	if len(values.Resources.Requests) > 0 {
+		gadgetContainer.Resources.Requests = values.Resources.Requests
+	}
+	if len(values.Resources.Limits) > 0 {
+		gadgetContainer.Resources.Limits = values.Resources.Limits
+	}
+	if len(values.NodeSelector) > 0 {
+		if podSpec.NodeSelector == nil {
+			podSpec.NodeSelector = map[string]string{}
+		}
+		maps.Copy(podSpec.NodeSelector, values.NodeSelector)
+	}
+	if len(values.Tolerations) > 0 {
+		podSpec.Tolerations = values.Tolerations
+	}
+}
@@ -44,11 +44,32 @@ import (
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/experimental"
 
 	// Blank import for some operators
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/aggregate"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/audit"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/blockdev"
 	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/btfgen"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/buffer"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/dns"
 	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/ebpf"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/exechash"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/fallback"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/filter"
 	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/formatters"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/gpu"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/ioc"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/kafka"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/kmsg"
 	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/kubemanager"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/nettopology"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/numa"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/otlp"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/reversedns"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/sample"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/snapshotdiff"
 	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/socketenricher"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/stats"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/symbolizer"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/synthetic"
 	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/uidgidresolver"
 
 	gadgetservice "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service"
@@ -68,6 +89,9 @@ var (
 	hookMode            string
 	socketfile          string
 	gadgetServiceHost   string
+	serviceHandoffPath  string
+	serviceConfigPath   string
+	serviceAuditLog     string
 	method              string
 	label               string
 	tracerid            string
@@ -83,6 +107,9 @@ var clientTimeout = 2 * time.Second
 func init() {
 	flag.StringVar(&socketfile, "socketfile", "/run/gadgettracermanager.socket", "Socket file")
 	flag.StringVar(&gadgetServiceHost, "service-host", fmt.Sprintf("tcp://127.0.0.1:%d", api.GadgetServicePort), "Socket address for gadget service")
+	flag.StringVar(&serviceHandoffPath, "service-handoff-path", "", "Path of the unix socket used to hand over the gadget service to a new instance")
+	flag.StringVar(&serviceConfigPath, "service-config", "", "Path of the gadget service configuration file")
+	flag.StringVar(&serviceAuditLog, "service-audit-log", "", "Backend of the audit log of gadget runs (file:<path> or k8s-events[:<namespace>])")
 	flag.StringVar(&hookMode, "hook-mode", "auto", "how to get containers start/stop notifications (podinformer, fanotify, auto, none)")
 
 	flag.BoolVar(&serve, "serve", false, "Start server")
@@ -342,10 +369,20 @@ func main() {
 		if err != nil {
 			log.Fatalf("invalid service host: %v", err)
 		}
+		var auditBackend gadgetservice.AuditBackend
+		if serviceAuditLog != "" {
+			auditBackend, err = gadgetservice.NewAuditBackend(serviceAuditLog)
+			if err != nil {
+				log.Fatalf("creating audit log: %v", err)
+			}
+		}
 		go func() {
 			err := service.Run(gadgetservice.RunConfig{
-				SocketType: socketType,
-				SocketPath: socketPath,
+				SocketType:   socketType,
+				SocketPath:   socketPath,
+				HandoffPath:  serviceHandoffPath,
+				ConfigPath:   serviceConfigPath,
+				AuditBackend: auditBackend,
 			})
 			if err != nil {
 				log.Fatalf("starting gadget service: %v", err)
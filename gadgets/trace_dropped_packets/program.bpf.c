@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: GPL-2.0
+// Copyright 2024 The Inspektor Gadget authors
+
+#include <vmlinux.h>
+
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_tracing.h>
+
+#include <gadget/buffer.h>
+#include <gadget/drop_reason.h>
+#include <gadget/macros.h>
+#include <gadget/stacks.h>
+#include <gadget/types.h>
+
+#define GADGET_TYPE_TRACING
+#include <gadget/sockets-map.h>
+
+#define ETH_P_IPV6 0x86DD
+
+struct event {
+	gadget_timestamp timestamp;
+	struct gadget_l4endpoint_t src;
+	struct gadget_l4endpoint_t dst;
+	enum skb_drop_reason reason;
+	__u32 netns;
+	gadget_kernel_stack kernel_stack;
+
+	// Only set if the packet belongs to a socket of a known process
+	gadget_mntns_id mount_ns_id;
+	__u32 pid;
+	__u32 tid;
+	__u32 uid;
+	__u32 gid;
+	__u8 task[TASK_COMM_LEN];
+};
+
+const volatile bool all_reasons = false;
+
+GADGET_PARAM(all_reasons);
+
+GADGET_TRACER_MAP(events, 1024 * 256);
+
+GADGET_TRACER(droppedpackets, events, event);
+
+// read_endpoints fills in the addresses and ports of the packet from its IP and TCP or UDP headers. Packets that
+// aren't IP packets, or whose network header isn't set yet, are left empty.
+static __always_inline void read_endpoints(struct event *event,
+					   struct sk_buff *skb, __u16 protocol)
+{
+	unsigned char *head = BPF_CORE_READ(skb, head);
+	__u16 network_header = BPF_CORE_READ(skb, network_header);
+	__u16 l4_off;
+	__u8 proto;
+
+	if (network_header == (__u16)~0U)
+		return;
+
+	switch (protocol) {
+	case ETH_P_IP: {
+		struct iphdr iph;
+		if (bpf_probe_read_kernel(&iph, sizeof(iph),
+					  head + network_header))
+			return;
+		event->src.l3.version = event->dst.l3.version = 4;
+		event->src.l3.addr.v4 = iph.saddr;
+		event->dst.l3.addr.v4 = iph.daddr;
+		proto = iph.protocol;
+		l4_off = network_header + iph.ihl * 4;
+		break;
+	}
+	case ETH_P_IPV6: {
+		struct ipv6hdr ip6h;
+		if (bpf_probe_read_kernel(&ip6h, sizeof(ip6h),
+					  head + network_header))
+			return;
+		event->src.l3.version = event->dst.l3.version = 6;
+		__builtin_memcpy(event->src.l3.addr.v6, &ip6h.saddr,
+				 sizeof(event->src.l3.addr.v6));
+		__builtin_memcpy(event->dst.l3.addr.v6, &ip6h.daddr,
+				 sizeof(event->dst.l3.addr.v6));
+		// Extension headers aren't followed
+		proto = ip6h.nexthdr;
+		l4_off = network_header + sizeof(ip6h);
+		break;
+	}
+	default:
+		return;
+	}
+
+	event->src.proto = event->dst.proto = proto;
+	if (proto != IPPROTO_TCP && proto != IPPROTO_UDP)
+		return;
+
+	// The source and destination ports are at the same offsets in the TCP and UDP headers; like the addresses, they're
+	// kept in network byte order
+	struct udphdr l4h;
+	if (bpf_probe_read_kernel(&l4h, sizeof(l4h), head + l4_off))
+		return;
+	event->src.port = l4h.source;
+	event->dst.port = l4h.dest;
+}
+
+SEC("tracepoint/skb/kfree_skb")
+int ig_dropped_packet(struct trace_event_raw_kfree_skb *ctx)
+{
+	struct sk_buff *skb = ctx->skbaddr;
+	enum skb_drop_reason reason = gadget_skb_drop_reason(ctx);
+	struct event *event;
+
+	if (!all_reasons && !gadget_skb_drop_reason_specified(reason))
+		return 0;
+
+	event = gadget_reserve_buf(&events, sizeof(*event));
+	if (!event)
+		return 0;
+	__builtin_memset(event, 0, sizeof(*event));
+
+	event->timestamp = bpf_ktime_get_boot_ns();
+	event->reason = reason;
+	event->netns = gadget_skb_netns(skb);
+	event->kernel_stack = gadget_get_kernel_stack(ctx);
+	read_endpoints(event, skb, ctx->protocol);
+
+	// Packets are often dropped in softirq context; the process is taken from the socket instead of the current task
+	struct sock *sk = BPF_CORE_READ(skb, sk);
+	if (sk) {
+		struct sockets_value *skb_val =
+			gadget_socket_lookup(sk, event->netns);
+		if (skb_val != NULL) {
+			event->mount_ns_id = skb_val->mntns;
+			event->pid = skb_val->pid_tgid >> 32;
+			event->tid = (__u32)skb_val->pid_tgid;
+			__builtin_memcpy(&event->task, skb_val->task,
+					 sizeof(event->task));
+			event->uid = (__u32)skb_val->uid_gid;
+			event->gid = (__u32)(skb_val->uid_gid >> 32);
+		}
+	}
+
+	gadget_submit_buf(ctx, &events, event, sizeof(*event));
+	return 0;
+}
+
+char LICENSE[] SEC("license") = "GPL";
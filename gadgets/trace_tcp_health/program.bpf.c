@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: GPL-2.0
+// Copyright 2024 The Inspektor Gadget authors
+
+#include <vmlinux.h>
+
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_tracing.h>
+
+#include <gadget/buffer.h>
+#include <gadget/macros.h>
+#include <gadget/mntns_filter.h>
+#include <gadget/tcp.h>
+#include <gadget/types.h>
+
+#define GADGET_TYPE_TRACING
+#include <gadget/sockets-map.h>
+
+#define TASK_COMM_LEN 16
+
+enum type {
+	RETRANS,
+	CLOSE,
+};
+
+struct event {
+	gadget_timestamp timestamp;
+	struct gadget_l4endpoint_t src;
+	struct gadget_l4endpoint_t dst;
+	enum type type;
+	__u32 netns;
+	struct gadget_tcp_quality quality;
+
+	gadget_mntns_id mntns_id;
+	__u32 pid;
+	__u32 tid;
+	__u32 uid;
+	__u32 gid;
+	__u8 task[TASK_COMM_LEN];
+};
+
+GADGET_TRACER_MAP(events, 1024 * 256);
+
+GADGET_TRACER(tcphealth, events, event);
+
+static __always_inline int submit_quality(void *ctx, const struct sock *sk,
+					  enum type type)
+{
+	struct sockets_value *skb_val;
+	struct event *event;
+
+	if (sk == NULL)
+		return 0;
+
+	event = gadget_reserve_buf(&events, sizeof(*event));
+	if (!event)
+		return 0;
+
+	if (!gadget_tcp_read_endpoints(sk, &event->src, &event->dst))
+		goto cleanup;
+
+	event->timestamp = bpf_ktime_get_boot_ns();
+	event->type = type;
+	event->netns = gadget_tcp_netns(sk);
+	gadget_tcp_read_quality(sk, &event->quality);
+
+	event->mntns_id = 0;
+	event->pid = event->tid = event->uid = event->gid = 0;
+	event->task[0] = '\0';
+
+	skb_val = gadget_socket_lookup(sk, event->netns);
+	if (skb_val != NULL) {
+		event->mntns_id = skb_val->mntns;
+		event->pid = skb_val->pid_tgid >> 32;
+		event->tid = (__u32)skb_val->pid_tgid;
+		__builtin_memcpy(&event->task, skb_val->task,
+				 sizeof(event->task));
+		event->uid = (__u32)skb_val->uid_gid;
+		event->gid = (__u32)(skb_val->uid_gid >> 32);
+	}
+
+	// Use the mount namespace of the socket to filter by container
+	if (gadget_should_discard_mntns_id(event->mntns_id))
+		goto cleanup;
+
+	gadget_submit_buf(ctx, &events, event, sizeof(*event));
+	return 0;
+
+cleanup:
+	gadget_discard_buf(event);
+	return 0;
+}
+
+SEC("tracepoint/tcp/tcp_retransmit_skb")
+int ig_tcph_retrans(struct trace_event_raw_tcp_event_sk_skb *ctx)
+{
+	return submit_quality(ctx, ctx->skaddr, RETRANS);
+}
+
+// Report the quality of connections a last time when they are closed
+SEC("tracepoint/sock/inet_sock_set_state")
+int ig_tcph_close(struct trace_event_raw_inet_sock_set_state *ctx)
+{
+	if (ctx->protocol != IPPROTO_TCP || ctx->newstate != TCP_CLOSE)
+		return 0;
+
+	// Connections that never got established have nothing to report
+	if (ctx->oldstate == TCP_SYN_SENT || ctx->oldstate == TCP_SYN_RECV)
+		return 0;
+
+	return submit_quality(ctx, ctx->skaddr, CLOSE);
+}
+
+char LICENSE[] SEC("license") = "GPL";
@@ -0,0 +1,48 @@
+/* SPDX-License-Identifier: (GPL-2.0 WITH Linux-syscall-note) OR Apache-2.0 */
+
+#ifndef DROP_REASON_H
+#define DROP_REASON_H
+
+#include <bpf/bpf_core_read.h>
+
+// Helpers for gadgets tracing packets dropped by the kernel using the skb/kfree_skb tracepoint. Fields of type enum
+// skb_drop_reason are resolved to the name of the reason using the kernel BTF, as the values of the enum change
+// between kernel versions, and a field with a description of the reason is automatically added. Keep this aligned
+// with pkg/operators/ebpf/dropreasons.go.
+
+// gadget_skb_drop_reason returns the reason of the drop reported by the kfree_skb tracepoint. Kernels before 5.17
+// don't report a reason, SKB_DROP_REASON_NOT_SPECIFIED is returned then.
+static __always_inline enum skb_drop_reason
+gadget_skb_drop_reason(struct trace_event_raw_kfree_skb *ctx)
+{
+	if (bpf_core_field_exists(ctx->reason))
+		return ctx->reason;
+	return bpf_core_enum_value(enum skb_drop_reason,
+				   SKB_DROP_REASON_NOT_SPECIFIED);
+}
+
+// gadget_skb_drop_reason_specified returns whether the kernel gave a reason for the drop. sk_buffs freed by kfree_skb()
+// without a reason aren't necessarily dropped packets, e.g. on error paths of protocols.
+static __always_inline bool
+gadget_skb_drop_reason_specified(enum skb_drop_reason reason)
+{
+	return reason > bpf_core_enum_value(enum skb_drop_reason,
+					    SKB_DROP_REASON_NOT_SPECIFIED);
+}
+
+// gadget_skb_netns returns the inode id of the network namespace an sk_buff belongs to: the one of its socket or, for
+// packets that aren't associated to a socket yet, the one of its device. It's 0 if it can't be determined.
+static __always_inline __u32 gadget_skb_netns(struct sk_buff *skb)
+{
+	struct sock *sk = BPF_CORE_READ(skb, sk);
+	if (sk)
+		return BPF_CORE_READ(sk, __sk_common.skc_net.net, ns.inum);
+
+	struct net_device *dev = BPF_CORE_READ(skb, dev);
+	if (dev)
+		return BPF_CORE_READ(dev, nd_net.net, ns.inum);
+
+	return 0;
+}
+
+#endif
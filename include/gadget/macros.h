@@ -26,7 +26,8 @@
 // Public macros. Use these in your code
 // Keep this aligned with pkg/gadgets/run/types/metadata.go
 
-// GADGET_TRACER is used to define a tracer. Currently only one tracer per eBPF object is allowed.
+// GADGET_TRACER is used to define a tracer. An eBPF object can define multiple tracers, each of them
+// provides a data source with the given name.
 // name is the tracer's name
 // map_name is the name of the perf event array or ring buffer maps used to send events to user
 // space
@@ -55,4 +56,18 @@
 	const struct type *unusedevent_##name##___##type __attribute__((unused)); \
     __GADGET_SNAPSHOTTER_IMPL(name, type, __VA_ARGS__)
 
+// GADGET_DATASOURCE_ANNOTATION adds an annotation to the data source provided by a tracer, topper or
+// snapshotter when the metadata file is generated, unless the annotation is already set there.
+// Keys and values must be valid C identifiers: use "__" for "." in them, e.g.
+// GADGET_DATASOURCE_ANNOTATION(open, cli__clear_screen_before, false) sets "cli.clear_screen_before".
+#define GADGET_DATASOURCE_ANNOTATION(ds, key, value) \
+	const void *gadget_ds_annotation_##ds##___##key##___##value __attribute__((unused));
+
+// GADGET_FIELD_ANNOTATION adds an annotation to the field of the given structure when the metadata
+// file is generated, unless the annotation is already set there. Keys and values are encoded like
+// in GADGET_DATASOURCE_ANNOTATION. The "hidden" key with the "true" value hides the field by default,
+// e.g. GADGET_FIELD_ANNOTATION(event, latency, hidden, true).
+#define GADGET_FIELD_ANNOTATION(type, field, key, value) \
+	const void *gadget_field_annotation_##type##___##field##___##key##___##value __attribute__((unused));
+
 #endif /* __MACROS_H */
@@ -0,0 +1,40 @@
+/* SPDX-License-Identifier: (GPL-2.0 WITH Linux-syscall-note) OR Apache-2.0 */
+
+#ifndef STACKS_H
+#define STACKS_H
+
+#include <bpf/bpf_helpers.h>
+
+#ifndef GADGET_STACK_MAX_ENTRIES
+#define GADGET_STACK_MAX_ENTRIES 10240
+#endif
+
+// Keep this aligned with pkg/operators/symbolizer/symbolizer.go
+#define GADGET_STACK_MAX_DEPTH 127
+
+// gadget_kernel_stack and gadget_user_stack hold the id of a stack stored in gadget_stack_map, as returned by
+// gadget_get_kernel_stack() and gadget_get_user_stack(). Fields containing the symbolized stacks are automatically
+// added.
+typedef __s32 gadget_kernel_stack;
+typedef __s32 gadget_user_stack;
+
+struct {
+	__uint(type, BPF_MAP_TYPE_STACK_TRACE);
+	__uint(key_size, sizeof(__u32));
+	__uint(value_size, GADGET_STACK_MAX_DEPTH * sizeof(__u64));
+	__uint(max_entries, GADGET_STACK_MAX_ENTRIES);
+} gadget_stack_map SEC(".maps");
+
+// gadget_get_kernel_stack stores the kernel stack of the current task and returns its id; it's negative on errors
+static __always_inline gadget_kernel_stack gadget_get_kernel_stack(void *ctx)
+{
+	return bpf_get_stackid(ctx, &gadget_stack_map, 0);
+}
+
+// gadget_get_user_stack stores the user stack of the current task and returns its id; it's negative on errors
+static __always_inline gadget_user_stack gadget_get_user_stack(void *ctx)
+{
+	return bpf_get_stackid(ctx, &gadget_stack_map, BPF_F_USER_STACK);
+}
+
+#endif
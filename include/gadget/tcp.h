@@ -0,0 +1,103 @@
+/* SPDX-License-Identifier: (GPL-2.0 WITH Linux-syscall-note) OR Apache-2.0 */
+
+#ifndef TCP_H
+#define TCP_H
+
+#include <bpf/bpf_core_read.h>
+
+#include <gadget/types.h>
+
+#ifndef AF_INET
+#define AF_INET 2
+#endif
+
+#ifndef AF_INET6
+#define AF_INET6 10
+#endif
+
+// struct gadget_tcp_quality holds the quality metrics the kernel keeps for a TCP connection in struct tcp_sock. Gadgets
+// embed it in their events, so that the fields are named the same in all gadgets and can be exported as metrics with
+// the same annotations; see "TCP connection quality" in docs/reference/gadget-helper-api.md.
+struct gadget_tcp_quality {
+	// Smoothed round trip time and its mean deviation, in microseconds
+	__u32 srtt_us;
+	__u32 rttvar_us;
+	// Lowest round trip time seen recently, in microseconds
+	__u32 min_rtt_us;
+	// Congestion window and slow start threshold, in segments
+	__u32 snd_cwnd;
+	__u32 snd_ssthresh;
+	// Segments retransmitted since the connection was established, and the ones currently in flight
+	__u32 total_retrans;
+	__u32 retrans_out;
+	// Segments currently considered lost
+	__u32 lost_out;
+	__u64 bytes_acked;
+	__u64 bytes_received;
+	// Bytes retransmitted since the connection was established; 0 on kernels before 5.5
+	__u64 bytes_retrans;
+};
+
+// gadget_tcp_read_quality fills q with the quality metrics of the TCP socket sk
+static __always_inline void
+gadget_tcp_read_quality(const struct sock *sk, struct gadget_tcp_quality *q)
+{
+	const struct tcp_sock *tp = (const struct tcp_sock *)sk;
+
+	// The kernel keeps the smoothed RTT shifted left by 3 and its deviation by 2
+	q->srtt_us = BPF_CORE_READ(tp, srtt_us) >> 3;
+	q->rttvar_us = BPF_CORE_READ(tp, mdev_us) >> 2;
+	q->min_rtt_us = BPF_CORE_READ(tp, rtt_min.s[0].v);
+	q->snd_cwnd = BPF_CORE_READ(tp, snd_cwnd);
+	q->snd_ssthresh = BPF_CORE_READ(tp, snd_ssthresh);
+	q->total_retrans = BPF_CORE_READ(tp, total_retrans);
+	q->retrans_out = BPF_CORE_READ(tp, retrans_out);
+	q->lost_out = BPF_CORE_READ(tp, lost_out);
+	q->bytes_acked = BPF_CORE_READ(tp, bytes_acked);
+	q->bytes_received = BPF_CORE_READ(tp, bytes_received);
+	if (bpf_core_field_exists(tp->bytes_retrans))
+		q->bytes_retrans = BPF_CORE_READ(tp, bytes_retrans);
+	else
+		q->bytes_retrans = 0;
+}
+
+// gadget_tcp_read_endpoints fills src and dst with the local and remote endpoints of the TCP socket sk. Like the
+// addresses, the ports are kept in network byte order, which is what the endpoint formatters expect. It returns false
+// if the socket isn't an IPv4 or IPv6 socket.
+static __always_inline bool
+gadget_tcp_read_endpoints(const struct sock *sk, struct gadget_l4endpoint_t *src,
+			  struct gadget_l4endpoint_t *dst)
+{
+	const struct inet_sock *inet = (const struct inet_sock *)sk;
+
+	switch (BPF_CORE_READ(sk, __sk_common.skc_family)) {
+	case AF_INET:
+		src->l3.version = dst->l3.version = 4;
+		src->l3.addr.v4 = BPF_CORE_READ(sk, __sk_common.skc_rcv_saddr);
+		dst->l3.addr.v4 = BPF_CORE_READ(sk, __sk_common.skc_daddr);
+		break;
+	case AF_INET6:
+		src->l3.version = dst->l3.version = 6;
+		BPF_CORE_READ_INTO(
+			&src->l3.addr.v6, sk,
+			__sk_common.skc_v6_rcv_saddr.in6_u.u6_addr32);
+		BPF_CORE_READ_INTO(&dst->l3.addr.v6, sk,
+				   __sk_common.skc_v6_daddr.in6_u.u6_addr32);
+		break;
+	default:
+		return false;
+	}
+
+	src->proto = dst->proto = IPPROTO_TCP;
+	src->port = BPF_CORE_READ(inet, inet_sport);
+	dst->port = BPF_CORE_READ(sk, __sk_common.skc_dport);
+	return true;
+}
+
+// gadget_tcp_netns returns the inode id of the network namespace of the socket sk
+static __always_inline __u32 gadget_tcp_netns(const struct sock *sk)
+{
+	return BPF_CORE_READ(sk, __sk_common.skc_net.net, ns.inum);
+}
+
+#endif
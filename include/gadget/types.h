@@ -38,4 +38,14 @@ typedef __u64 gadget_timestamp;
 // as string.
 typedef __u32 gadget_signal;
 
+// gadget_uid and gadget_gid are used to represent user and group ids. Fields containing the name of the user or group
+// are automatically added. The names are looked up in the container of the process referenced by the event.
+typedef __u32 gadget_uid;
+typedef __u32 gadget_gid;
+
+// gadget_cpu is used to represent the CPU an event happened on, e.g. the value returned by bpf_get_smp_processor_id().
+// Fields containing its NUMA node are automatically added; see "CPU and NUMA context" in
+// docs/reference/gadget-helper-api.md.
+typedef __u32 gadget_cpu;
+
 #endif /* __TYPES_H */
@@ -0,0 +1,123 @@
+/* SPDX-License-Identifier: (GPL-2.0 WITH Linux-syscall-note) OR Apache-2.0 */
+
+#ifndef USDT_H
+#define USDT_H
+
+#include <bpf/bpf_helpers.h>
+
+// Keep this aligned with pkg/uprobetracer/usdt_args.go
+
+#ifndef GADGET_USDT_MAX_SPECS
+#define GADGET_USDT_MAX_SPECS 256
+#endif
+
+#define GADGET_USDT_MAX_ARGS 12
+
+// GADGET_USDT_PROBE attaches the program prog, which has to be in the "usdt" section, to the probe of the given
+// provider. The binary or library containing the probe is set with the uprobe-target-<prog> param, whose default
+// can be set in the uprobes section of the gadget metadata, e.g. to trace different versions of a runtime:
+//
+// GADGET_USDT_PROBE(ig_function_entry, python, function__entry)
+// SEC("usdt")
+// int ig_function_entry(struct pt_regs *ctx) { ... }
+#define GADGET_USDT_PROBE(prog, provider, probe) \
+	const void *gadget_usdt_probe_##prog##___##provider##___##probe __attribute__((unused));
+
+enum gadget_usdt_arg_type {
+	GADGET_USDT_ARG_CONST,
+	GADGET_USDT_ARG_REG,
+	GADGET_USDT_ARG_REG_DEREF,
+};
+
+struct gadget_usdt_arg_spec {
+	// constant value or offset of the value from the address in the register
+	__u64 val_off;
+	enum gadget_usdt_arg_type arg_type;
+	// offset of the register in struct pt_regs
+	short reg_off;
+	bool arg_signed;
+	// shift left and right to truncate and sign-extend the value
+	char arg_bitshift;
+};
+
+struct gadget_usdt_spec {
+	struct gadget_usdt_arg_spec args[GADGET_USDT_MAX_ARGS];
+	short arg_cnt;
+};
+
+// gadget_usdt_specs is filled by Inspektor Gadget with the location of the arguments of each attached probe. The
+// index of the spec is the attach cookie of the program.
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__uint(max_entries, GADGET_USDT_MAX_SPECS);
+	__type(key, __u32);
+	__type(value, struct gadget_usdt_spec);
+} gadget_usdt_specs SEC(".maps");
+
+// gadget_usdt_arg_cnt returns the number of arguments of the probe that triggered the program, or a negative error
+static __always_inline int gadget_usdt_arg_cnt(struct pt_regs *ctx)
+{
+	__u32 spec_id = bpf_get_attach_cookie(ctx);
+	struct gadget_usdt_spec *spec;
+
+	spec = bpf_map_lookup_elem(&gadget_usdt_specs, &spec_id);
+	if (!spec)
+		return -3; // -ESRCH
+
+	return spec->arg_cnt;
+}
+
+// gadget_usdt_arg stores the argument n (starting at 0) of the probe that triggered the program in res. It returns
+// 0 on success and a negative error otherwise.
+static __always_inline int gadget_usdt_arg(struct pt_regs *ctx, __u64 n, long *res)
+{
+	__u32 spec_id = bpf_get_attach_cookie(ctx);
+	struct gadget_usdt_arg_spec *arg_spec;
+	struct gadget_usdt_spec *spec;
+	unsigned long val;
+	int err;
+
+	*res = 0;
+
+	spec = bpf_map_lookup_elem(&gadget_usdt_specs, &spec_id);
+	if (!spec)
+		return -3; // -ESRCH
+
+	if (n >= GADGET_USDT_MAX_ARGS || n >= spec->arg_cnt)
+		return -2; // -ENOENT
+
+	arg_spec = &spec->args[n];
+	switch (arg_spec->arg_type) {
+	case GADGET_USDT_ARG_CONST:
+		val = arg_spec->val_off;
+		break;
+	case GADGET_USDT_ARG_REG:
+		err = bpf_probe_read_kernel(&val, sizeof(val), (void *)ctx + arg_spec->reg_off);
+		if (err)
+			return err;
+		break;
+	case GADGET_USDT_ARG_REG_DEREF:
+		err = bpf_probe_read_kernel(&val, sizeof(val), (void *)ctx + arg_spec->reg_off);
+		if (err)
+			return err;
+		err = bpf_probe_read_user(&val, sizeof(val), (void *)val + arg_spec->val_off);
+		if (err)
+			return err;
+#if __BYTE_ORDER__ == __ORDER_BIG_ENDIAN__
+		val >>= arg_spec->arg_bitshift;
+#endif
+		break;
+	default:
+		return -22; // -EINVAL
+	}
+
+	val <<= arg_spec->arg_bitshift;
+	if (arg_spec->arg_signed)
+		val = ((long)val) >> arg_spec->arg_bitshift;
+	else
+		val = val >> arg_spec->arg_bitshift;
+	*res = val;
+	return 0;
+}
+
+#endif
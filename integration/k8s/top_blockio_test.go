@@ -35,6 +35,10 @@ func newTopBlockIOCmd(ns string, cmd string, startAndStop bool, expectedEntry *t
 			e.Bytes = 0
 			e.MicroSecs = 0
 			e.Operations = 0
+			e.Device = ""
+			e.MountPoint = ""
+			e.PV = ""
+			e.PVC = ""
 
 			normalizeCommonData(&e.CommonData, ns)
 		}
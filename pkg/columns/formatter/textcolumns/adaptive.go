@@ -0,0 +1,81 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textcolumns
+
+// widthSamples keeps the lengths of the last values of a column
+type widthSamples struct {
+	lengths []int
+	next    int
+}
+
+func newWidthSamples(size int) *widthSamples {
+	return &widthSamples{lengths: make([]int, 0, size)}
+}
+
+func (s *widthSamples) add(length int) {
+	if len(s.lengths) < cap(s.lengths) {
+		s.lengths = append(s.lengths, length)
+		return
+	}
+	s.lengths[s.next] = length
+	s.next = (s.next + 1) % len(s.lengths)
+}
+
+func (s *widthSamples) max() int {
+	res := 0
+	for _, l := range s.lengths {
+		res = max(res, l)
+	}
+	return res
+}
+
+// ObserveEntry adapts the widths of the shown columns to the values of entry and of the previous entries, if enabled
+// using WithAdaptiveWidths. Columns grow to fit the longest value of the last entries, up to their maximum width,
+// and shrink back as long values get out of the sample, but never below the width they had before observing entries.
+// Fixed columns are left untouched. It returns true if a width changed, which means that the header should be
+// printed again.
+func (tf *TextColumnsFormatter[T]) ObserveEntry(entry *T) bool {
+	if tf.options.WidthSamples <= 0 || entry == nil {
+		return false
+	}
+
+	changed := false
+	for _, column := range tf.showColumns {
+		if column.col.FixedWidth {
+			continue
+		}
+		if column.samples == nil {
+			column.samples = newWidthSamples(tf.options.WidthSamples)
+		}
+		if column.baseWidth == 0 {
+			column.baseWidth = column.calculatedWidth
+		}
+		column.samples.add(len([]rune(column.toString(entry))))
+
+		width := max(column.samples.max(), column.baseWidth)
+		if column.col.MaxWidth > 0 && width > column.col.MaxWidth {
+			width = max(column.col.MaxWidth, column.baseWidth)
+		}
+		if width != column.calculatedWidth {
+			column.calculatedWidth = width
+			changed = true
+		}
+	}
+
+	if changed {
+		tf.buildFillString()
+	}
+	return changed
+}
@@ -38,6 +38,7 @@ type Options struct {
 	HeaderStyle    HeaderStyle // defines how column headers are decorated (e.g. uppercase/lowercase)
 	RowDivider     string      // defines the (to be repeated) string that should be used below the header
 	ShouldTruncate bool        // defines whether to truncate strings or not
+	WidthSamples   int         // if > 0, widths adapt to the values of the last WidthSamples entries, see ObserveEntry
 }
 
 func DefaultOptions() *Options {
@@ -92,3 +93,11 @@ func WithShouldTruncate(ellipsis bool) Option {
 		opts.ShouldTruncate = ellipsis
 	}
 }
+
+// WithAdaptiveWidths makes the widths of columns adapt to the lengths of the values of the last samples entries
+// passed to ObserveEntry
+func WithAdaptiveWidths(samples int) Option {
+	return func(opts *Options) {
+		opts.WidthSamples = samples
+	}
+}
@@ -49,4 +49,9 @@ func TestOptions(t *testing.T) {
 	if opts.RowDivider != "X" {
 		t.Errorf("Expected RowDivider to be X")
 	}
+
+	WithAdaptiveWidths(10)(opts)
+	if opts.WidthSamples != 10 {
+		t.Errorf("Expected WidthSamples to be 10")
+	}
 }
@@ -35,6 +35,11 @@ func (tf *TextColumnsFormatter[T]) RecalculateWidths(maxWidth int, force bool) {
 	// set for caching (to avoid recalculation)
 	tf.currentMaxWidth = maxWidth
 
+	// Widths adapted to observed values start over from the new widths
+	for _, column := range tf.columns {
+		column.baseWidth = 0
+	}
+
 	if len(tf.showColumns) == 0 {
 		return
 	}
@@ -27,6 +27,11 @@ type Column[T any] struct {
 	calculatedWidth int
 	treatAsFixed    bool
 	formatter       func(*T) string
+	toString        func(*T) string
+
+	// baseWidth is the width before adapting it to the observed values, see ObserveEntry
+	baseWidth int
+	samples   *widthSamples
 }
 
 type TextColumnsFormatter[T any] struct {
@@ -131,6 +136,9 @@ func (tf *TextColumnsFormatter[T]) SetAutoScale(enableAutoScale bool) {
 }
 
 func (tf *TextColumnsFormatter[T]) rebuild() {
+	for _, column := range tf.columns {
+		column.baseWidth = 0
+	}
 	tf.buildFillString()
 	tf.currentMaxWidth = -1 // force recalculation
 	tf.AdjustWidthsToScreen()
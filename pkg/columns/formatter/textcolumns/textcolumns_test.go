@@ -170,6 +170,40 @@ func TestWidthRestrictions(t *testing.T) {
 	})
 }
 
+func TestTextColumnsFormatter_ObserveEntry(t *testing.T) {
+	type testStruct struct {
+		Name string `column:"name,width:5,maxWidth:8"`
+		Age  uint   `column:"age,width:3,fixed"`
+	}
+	cols, err := columns.NewColumns[testStruct]()
+	require.Nil(t, err, "error initializing: %s", err)
+
+	formatter := NewFormatter(cols.GetColumnMap(), WithAutoScale(false), WithAdaptiveWidths(2))
+	assert.Equal(t, "NAME  AGE", formatter.FormatHeader())
+
+	// Short values keep the original width
+	assert.False(t, formatter.ObserveEntry(&testStruct{"abc", 1}))
+	assert.Equal(t, "abc   1  ", formatter.FormatEntry(&testStruct{"abc", 1}))
+
+	// Longer values widen the column, up to its maximum width; fixed columns are left untouched
+	assert.True(t, formatter.ObserveEntry(&testStruct{"abcdefg", 1000}))
+	assert.Equal(t, "NAME    AGE", formatter.FormatHeader())
+	assert.Equal(t, "abcdefg 10…", formatter.FormatEntry(&testStruct{"abcdefg", 1000}))
+	assert.True(t, formatter.ObserveEntry(&testStruct{"abcdefghijk", 1}))
+	assert.Equal(t, "abcdefg… 1  ", formatter.FormatEntry(&testStruct{"abcdefghijk", 1}))
+	assert.False(t, formatter.ObserveEntry(&testStruct{"abc", 1}))
+
+	// Once long values are out of the sample, the column shrinks back to its original width
+	assert.True(t, formatter.ObserveEntry(&testStruct{"abc", 1}))
+	assert.Equal(t, "NAME  AGE", formatter.FormatHeader())
+}
+
+func TestTextColumnsFormatter_ObserveEntryDisabled(t *testing.T) {
+	formatter := NewFormatter(testColumns, WithAutoScale(false))
+	assert.False(t, formatter.ObserveEntry(&testStruct{Name: "a very long name"}))
+	assert.Equal(t, "NAME        AGE   SIZE  BALANCE CANDANCE", formatter.FormatHeader())
+}
+
 func TestWithTypeDefinition(t *testing.T) {
 	type StringAlias string
 	type testStruct struct {
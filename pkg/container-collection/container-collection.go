@@ -82,6 +82,9 @@ type ContainerCollection struct {
 
 	// disableContainerRuntimeWarnings is used to disable warnings about container runtimes.
 	disableContainerRuntimeWarnings bool
+
+	// restarts correlates containers with the previous instances they replaced
+	restarts restartTracker
 }
 
 // ContainerCollectionOption are options to pass to
@@ -143,6 +146,8 @@ func (cc *ContainerCollection) RemoveContainer(id string) {
 		cc.pubsub.Publish(EventTypeRemoveContainer, container)
 	}
 
+	cc.restarts.removed(container, time.Now())
+
 	// Save the container in the cache as enrichers might need the container some time after it
 	// has been removed.
 	if cc.cachedContainers != nil {
@@ -214,6 +219,10 @@ func (cc *ContainerCollection) AddContainer(container *Container) {
 	if loaded {
 		return
 	}
+
+	// Fill the restart metadata before the container can be looked up by its namespaces
+	cc.restarts.added(container, time.Now())
+
 	cc.mu.Lock()
 	cc.containersByMntNs.Store(container.Mntns, container)
 	arr, ok := cc.containersByNetNs.Load(container.Netns)
@@ -241,3 +241,74 @@ func TestWithTracerCollection(t *testing.T) {
 	cc.EnrichByNetNs(&ev, containers[0].Netns)
 	require.Equal(t, expected, ev, "events should be equal")
 }
+
+func TestContainerRestarts(t *testing.T) {
+	t.Parallel()
+
+	cc := ContainerCollection{}
+	newContainer := func(id string, mntns uint64) *Container {
+		return &Container{
+			Runtime: RuntimeMetadata{
+				BasicRuntimeMetadata: types.BasicRuntimeMetadata{
+					ContainerID:   id,
+					ContainerName: "k8s_app_pod",
+				},
+			},
+			K8s: K8sMetadata{
+				BasicK8sMetadata: types.BasicK8sMetadata{
+					Namespace:     "default",
+					PodName:       "pod",
+					ContainerName: "app",
+				},
+				PodUID: "uid",
+			},
+			Mntns: mntns,
+		}
+	}
+
+	first := newContainer("a", 1)
+	cc.AddContainer(first)
+	require.Zero(t, first.RestartCount)
+	require.Empty(t, first.PreviousContainerID)
+
+	cc.RemoveContainer("a")
+	second := newContainer("b", 2)
+	cc.AddContainer(second)
+	require.Equal(t, uint32(1), second.RestartCount)
+	require.Equal(t, "a", second.PreviousContainerID)
+
+	// The new instance can be added before the previous one is removed
+	third := newContainer("c", 3)
+	cc.AddContainer(third)
+	cc.RemoveContainer("b")
+	require.Equal(t, uint32(2), third.RestartCount)
+	require.Equal(t, "b", third.PreviousContainerID)
+
+	// Runtimes like Docker restart containers keeping their ID
+	cc.RemoveContainer("c")
+	fourth := newContainer("c", 4)
+	cc.AddContainer(fourth)
+	require.Equal(t, uint32(3), fourth.RestartCount)
+	require.Equal(t, "c", fourth.PreviousContainerID)
+
+	// A restart count reported by the runtime is kept if it's higher
+	cc.RemoveContainer("c")
+	fifth := newContainer("d", 5)
+	fifth.RestartCount = 10
+	cc.AddContainer(fifth)
+	require.Equal(t, uint32(10), fifth.RestartCount)
+
+	// Containers of other pods are unrelated
+	other := newContainer("e", 6)
+	other.K8s.PodUID = "other"
+	cc.AddContainer(other)
+	require.Zero(t, other.RestartCount)
+
+	// Removed containers are forgotten after a while
+	cc.RemoveContainer("d")
+	cc.restarts.instances[restartKey(fifth)].removed = time.Now().Add(-2 * restartTTL)
+	sixth := newContainer("f", 7)
+	cc.AddContainer(sixth)
+	require.Zero(t, sixth.RestartCount)
+	require.Empty(t, sixth.PreviousContainerID)
+}
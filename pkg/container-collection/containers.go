@@ -53,6 +53,13 @@ type Container struct {
 	// SandboxId is the sandbox id for the corresponding pod
 	SandboxId string `json:"sandboxId,omitempty"`
 
+	// Sandbox is the user-mode kernel sandbox the container runs in, like SandboxGVisor, or empty if it runs on the
+	// host kernel
+	Sandbox string `json:"sandbox,omitempty" column:"sandbox,width:8,hide"`
+
+	// Restarts of the container, filled when the container is added to the collection
+	types.BasicRestartMetadata `json:",inline"`
+
 	// Linux metadata can be derived from the pid via /proc/$pid/...
 	Mntns       uint64 `json:"mntns,omitempty" column:"mntns,template:ns"`
 	Netns       uint64 `json:"netns,omitempty" column:"netns,template:ns"`
@@ -223,6 +230,10 @@ func (c *Container) RuntimeMetadata() *types.BasicRuntimeMetadata {
 	return &c.Runtime.BasicRuntimeMetadata
 }
 
+func (c *Container) RestartMetadata() *types.BasicRestartMetadata {
+	return &c.BasicRestartMetadata
+}
+
 func (c *Container) UsesHostNetwork() bool {
 	return c.HostNetwork
 }
@@ -57,3 +57,8 @@ func (cc *ContainerCollection) EnrichEventByNetNs(event operators.ContainerInfoF
 
 	return
 }
+
+// EnrichEventNode only sets the node of an event, e.g. for events that are known to come from the host
+func (cc *ContainerCollection) EnrichEventNode(event operators.NodeSetter) {
+	event.SetNode(cc.nodeName)
+}
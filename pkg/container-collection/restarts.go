@@ -0,0 +1,96 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package containercollection
+
+import (
+	"sync"
+	"time"
+)
+
+// restartTTL is how long a removed container is remembered to detect its restart. It's above the maximum back-off
+// of 5 minutes Kubernetes waits before restarting crashing containers.
+const restartTTL = 10 * time.Minute
+
+type containerInstance struct {
+	containerID  string
+	restartCount uint32
+	// removed is zero as long as the container is running
+	removed time.Time
+}
+
+// restartTracker remembers the last instance of each container to tell when a container replaces a previous
+// instance of itself
+type restartTracker struct {
+	mu        sync.Mutex
+	instances map[string]*containerInstance
+}
+
+// restartKey identifies a container across restarts, or returns an empty string if it can't be identified
+func restartKey(container *Container) string {
+	switch {
+	case container.K8s.ContainerName != "" && container.K8s.PodUID != "":
+		return "k8s/" + container.K8s.PodUID + "/" + container.K8s.ContainerName
+	case container.K8s.ContainerName != "" && container.K8s.PodName != "":
+		return "k8s/" + container.K8s.Namespace + "/" + container.K8s.PodName + "/" + container.K8s.ContainerName
+	case container.Runtime.ContainerName != "":
+		return "runtime/" + string(container.Runtime.RuntimeName) + "/" + container.Runtime.ContainerName
+	}
+	return ""
+}
+
+// added fills the restart metadata of a container that is added to the collection
+func (t *restartTracker) added(container *Container, now time.Time) {
+	key := restartKey(container)
+	if key == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.instances == nil {
+		t.instances = make(map[string]*containerInstance)
+	}
+	for k, instance := range t.instances {
+		if !instance.removed.IsZero() && now.Sub(instance.removed) > restartTTL {
+			delete(t.instances, k)
+		}
+	}
+
+	// The previous instance is usually removed before the new one is added, but that's not guaranteed
+	if previous, ok := t.instances[key]; ok && (previous.containerID != container.Runtime.ContainerID || !previous.removed.IsZero()) {
+		container.PreviousContainerID = previous.containerID
+		container.RestartCount = max(container.RestartCount, previous.restartCount+1)
+	}
+	t.instances[key] = &containerInstance{
+		containerID:  container.Runtime.ContainerID,
+		restartCount: container.RestartCount,
+	}
+}
+
+// removed remembers when a container has been removed from the collection
+func (t *restartTracker) removed(container *Container, now time.Time) {
+	key := restartKey(container)
+	if key == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if instance, ok := t.instances[key]; ok && instance.containerID == container.Runtime.ContainerID {
+		instance.removed = now
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package containercollection
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+)
+
+// SandboxGVisor is the sandbox of containers run by gVisor (runsc). Their processes run on top of the gVisor
+// kernel (the sentry) in user space, so the host kernel, and thus eBPF programs, only see the sentry.
+const SandboxGVisor = "gvisor"
+
+// gVisorProcessNames are the names runsc gives its sandbox processes; the pid of a gVisor container is the one of
+// its sandbox
+var gVisorProcessNames = [][]byte{[]byte("runsc-sandbox"), []byte("runsc-gofer")}
+
+// detectSandbox returns the user-mode kernel sandbox the process runs in, or "" if it runs on the host kernel
+func detectSandbox(pid int) (string, error) {
+	cmdline, err := os.ReadFile(filepath.Join(host.HostProcFs, fmt.Sprint(pid), "cmdline"))
+	if err != nil {
+		return "", err
+	}
+	return sandboxFromCmdline(cmdline), nil
+}
+
+func sandboxFromCmdline(cmdline []byte) string {
+	args := bytes.Split(bytes.TrimRight(cmdline, "\x00"), []byte{0})
+	if len(args) == 0 {
+		return ""
+	}
+	name := args[0]
+	if i := bytes.LastIndexByte(name, '/'); i >= 0 {
+		name = name[i+1:]
+	}
+	for _, n := range gVisorProcessNames {
+		if bytes.Equal(name, n) {
+			return SandboxGVisor
+		}
+	}
+	// Older versions of runsc keep their own name and are started with the "boot" command
+	if bytes.Equal(name, []byte("runsc")) {
+		for _, arg := range args[1:] {
+			if bytes.Equal(arg, []byte("boot")) {
+				return SandboxGVisor
+			}
+		}
+	}
+	return ""
+}
+
+// UntraceableReason returns why the events of the container can't be traced with eBPF, or "" if they can
+func (c *Container) UntraceableReason() string {
+	switch c.Sandbox {
+	case SandboxGVisor:
+		return "runs in a gVisor sandbox: its processes run on the gVisor kernel in user space, which eBPF programs don't see"
+	}
+	return ""
+}
+
+// WithSandboxEnrichment enables an enricher telling whether containers run in a user-mode kernel sandbox like
+// gVisor, which can't be traced
+func WithSandboxEnrichment() ContainerCollectionOption {
+	return func(cc *ContainerCollection) error {
+		cc.containerEnrichers = append(cc.containerEnrichers, func(container *Container) bool {
+			if container.Pid == 0 {
+				return true
+			}
+			sandbox, err := detectSandbox(int(container.Pid))
+			if err != nil {
+				log.Debugf("sandbox enricher: failed to detect sandbox of container %s: %s", container.Runtime.ContainerID, err)
+				return true
+			}
+			container.Sandbox = sandbox
+			if reason := container.UntraceableReason(); reason != "" {
+				log.Infof("container %q %s; its events can't be traced", container.Runtime.ContainerName, reason)
+			}
+			return true
+		})
+		return nil
+	}
+}
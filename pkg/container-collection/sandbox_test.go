@@ -0,0 +1,39 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package containercollection
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSandboxFromCmdline(t *testing.T) {
+	tests := map[string]string{
+		"runsc-sandbox\x00--root=/run/containerd/runsc/k8s.io\x00boot\x00--bundle=/run/bundle\x00": SandboxGVisor,
+		"/usr/local/bin/runsc-gofer\x00gofer\x00":                                                  SandboxGVisor,
+		"/usr/bin/runsc\x00--root=/var/run/runsc\x00boot\x00abcdef\x00":                            SandboxGVisor,
+		"/usr/bin/runsc\x00state\x00abcdef\x00":                                                    "",
+		"/pause\x00":                                                                               "",
+		"nginx: master process nginx -g daemon off;\x00":                                           "",
+		"": "",
+	}
+	for cmdline, expected := range tests {
+		require.Equal(t, expected, sandboxFromCmdline([]byte(cmdline)), cmdline)
+	}
+
+	require.NotEmpty(t, (&Container{Sandbox: SandboxGVisor}).UntraceableReason())
+	require.Empty(t, (&Container{}).UntraceableReason())
+}
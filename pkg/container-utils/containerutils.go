@@ -22,10 +22,12 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"syscall"
 
 	ocispec "github.com/opencontainers/runtime-spec/specs-go"
+	log "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
 
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/container-utils/containerd"
@@ -51,31 +53,112 @@ var AvailableRuntimeProtocols = []string{
 	containerutilsTypes.RuntimeProtocolCRI,
 }
 
+// ParseRuntimeProtocols parses a comma-separated list of runtime protocols,
+// given in the order they should be tried.
+func ParseRuntimeProtocols(protocols string) ([]string, error) {
+	if protocols == "" {
+		return nil, nil
+	}
+
+	var res []string
+	for _, p := range strings.Split(protocols, ",") {
+		p = strings.TrimSpace(p)
+		if !slices.Contains(AvailableRuntimeProtocols, p) {
+			return nil, fmt.Errorf("runtime protocol %q is not supported (available %s)",
+				p, strings.Join(AvailableRuntimeProtocols, ", "))
+		}
+		if slices.Contains(res, p) {
+			return nil, fmt.Errorf("runtime protocol %q given more than once", p)
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+// NewContainerRuntimeClient creates a client for the given runtime. If several
+// protocols are given in runtime.RuntimeProtocol, the returned client uses the
+// first one and falls back to the next ones when it fails.
 func NewContainerRuntimeClient(runtime *containerutilsTypes.RuntimeConfig) (runtimeclient.ContainerRuntimeClient, error) {
+	protocols, err := ParseRuntimeProtocols(runtime.RuntimeProtocol)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only docker and containerd support more than one protocol
+	if runtime.Name != types.RuntimeNameDocker && runtime.Name != types.RuntimeNameContainerd {
+		return newContainerRuntimeClient(runtime, "")
+	}
+	switch len(protocols) {
+	case 0:
+		return newContainerRuntimeClient(runtime, "")
+	case 1:
+		return newContainerRuntimeClient(runtime, protocols[0])
+	}
+
+	client := &fallbackClient{
+		name: runtime.Name,
+	}
+	var firstErr error
+	for _, protocol := range protocols {
+		c, err := newContainerRuntimeClient(runtime, protocol)
+		if err != nil {
+			log.Warnf("Runtime %s: failed to create client using %s protocol: %s", runtime.Name, protocol, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		client.clients = append(client.clients, c)
+		client.protocols = append(client.protocols, protocol)
+	}
+
+	switch len(client.clients) {
+	case 0:
+		return nil, firstErr
+	case 1:
+		return client.clients[0], nil
+	default:
+		return client, nil
+	}
+}
+
+func newContainerRuntimeClient(runtime *containerutilsTypes.RuntimeConfig, protocol string) (runtimeclient.ContainerRuntimeClient, error) {
 	switch runtime.Name {
 	case types.RuntimeNameDocker:
 		socketPath := runtime.SocketPath
 		if envsp := os.Getenv("INSPEKTOR_GADGET_DOCKER_SOCKETPATH"); envsp != "" && socketPath == "" {
 			socketPath = filepath.Join(host.HostRoot, envsp)
 		}
-		return docker.NewDockerClient(socketPath, runtime.RuntimeProtocol)
+		if socketPath == "" && protocol != containerutilsTypes.RuntimeProtocolCRI {
+			socketPath = host.HostSocketPath(runtimeclient.DockerDefaultSocketPath)
+		}
+		return docker.NewDockerClient(socketPath, protocol)
 	case types.RuntimeNameContainerd:
 		socketPath := runtime.SocketPath
 		if envsp := os.Getenv("INSPEKTOR_GADGET_CONTAINERD_SOCKETPATH"); envsp != "" && socketPath == "" {
 			socketPath = filepath.Join(host.HostRoot, envsp)
 		}
-		return containerd.NewContainerdClient(socketPath, runtime.RuntimeProtocol, &runtime.Extra)
+		if socketPath == "" {
+			socketPath = host.HostSocketPath(runtimeclient.ContainerdDefaultSocketPath)
+		}
+		return containerd.NewContainerdClient(socketPath, protocol, &runtime.Extra)
 	case types.RuntimeNameCrio:
 		socketPath := runtime.SocketPath
 		if envsp := os.Getenv("INSPEKTOR_GADGET_CRIO_SOCKETPATH"); envsp != "" && socketPath == "" {
 			socketPath = filepath.Join(host.HostRoot, envsp)
 		}
+		if socketPath == "" {
+			socketPath = host.HostSocketPath(runtimeclient.CrioDefaultSocketPath)
+		}
 		return crio.NewCrioClient(socketPath)
 	case types.RuntimeNamePodman:
 		socketPath := runtime.SocketPath
 		if envsp := os.Getenv("INSPEKTOR_GADGET_PODMAN_SOCKETPATH"); envsp != "" && socketPath == "" {
 			socketPath = filepath.Join(host.HostRoot, envsp)
 		}
+		if socketPath == "" {
+			socketPath = host.HostSocketPath(runtimeclient.PodmanDefaultSocketPath)
+		}
 		return podman.NewPodmanClient(socketPath), nil
 	default:
 		return nil, fmt.Errorf("unknown container runtime: %s (available %s)",
@@ -425,6 +425,8 @@ func buildContainerData(runtimeName types.RuntimeName, container CRIContainer, p
 				ContainerImageDigest: digestFromRef(imageRef),
 			},
 			State: containerStatusStateToRuntimeClientState(container.GetState()),
+			// The kubelet increments the attempt each time it restarts a container
+			RestartCount: containerMetadata.GetAttempt(),
 		},
 	}
 
@@ -0,0 +1,104 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package containerutils
+
+import (
+	"errors"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	runtimeclient "github.com/inspektor-gadget/inspektor-gadget/pkg/container-utils/runtime-client"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/types"
+)
+
+// fallbackClient implements the ContainerRuntimeClient interface on top of
+// several clients talking to the same runtime using different protocols. All
+// calls go to the current client and, if it fails, the other clients are tried
+// in order. The first one that succeeds becomes the current client.
+type fallbackClient struct {
+	name      types.RuntimeName
+	clients   []runtimeclient.ContainerRuntimeClient
+	protocols []string
+
+	mu      sync.Mutex
+	current int
+}
+
+func (c *fallbackClient) call(fn func(client runtimeclient.ContainerRuntimeClient) error) error {
+	c.mu.Lock()
+	current := c.current
+	c.mu.Unlock()
+
+	var firstErr error
+	for n := range c.clients {
+		idx := (current + n) % len(c.clients)
+		err := fn(c.clients[idx])
+		if err == nil {
+			if idx != current {
+				log.Warnf("Runtime %s: %s protocol failed (%s), falling back to %s protocol",
+					c.name, c.protocols[current], firstErr, c.protocols[idx])
+
+				c.mu.Lock()
+				c.current = idx
+				c.mu.Unlock()
+			}
+			return nil
+		}
+
+		// Not an error of the protocol, the other clients will fail too
+		if errors.Is(err, runtimeclient.ErrPauseContainer) {
+			return err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (c *fallbackClient) GetContainers() (containers []*runtimeclient.ContainerData, err error) {
+	err = c.call(func(client runtimeclient.ContainerRuntimeClient) error {
+		containers, err = client.GetContainers()
+		return err
+	})
+	return containers, err
+}
+
+func (c *fallbackClient) GetContainer(containerID string) (container *runtimeclient.ContainerData, err error) {
+	err = c.call(func(client runtimeclient.ContainerRuntimeClient) error {
+		container, err = client.GetContainer(containerID)
+		return err
+	})
+	return container, err
+}
+
+func (c *fallbackClient) GetContainerDetails(containerID string) (details *runtimeclient.ContainerDetailsData, err error) {
+	err = c.call(func(client runtimeclient.ContainerRuntimeClient) error {
+		details, err = client.GetContainerDetails(containerID)
+		return err
+	})
+	return details, err
+}
+
+func (c *fallbackClient) Close() error {
+	var firstErr error
+	for _, client := range c.clients {
+		if err := client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,127 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package containerutils
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	runtimeclient "github.com/inspektor-gadget/inspektor-gadget/pkg/container-utils/runtime-client"
+	containerutilsTypes "github.com/inspektor-gadget/inspektor-gadget/pkg/container-utils/types"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/types"
+)
+
+type fakeClient struct {
+	err   error
+	calls int
+}
+
+func (c *fakeClient) GetContainers() ([]*runtimeclient.ContainerData, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return []*runtimeclient.ContainerData{{}}, nil
+}
+
+func (c *fakeClient) GetContainer(containerID string) (*runtimeclient.ContainerData, error) {
+	c.calls++
+	return &runtimeclient.ContainerData{}, c.err
+}
+
+func (c *fakeClient) GetContainerDetails(containerID string) (*runtimeclient.ContainerDetailsData, error) {
+	c.calls++
+	return &runtimeclient.ContainerDetailsData{}, c.err
+}
+
+func (c *fakeClient) Close() error {
+	return nil
+}
+
+func TestParseRuntimeProtocols(t *testing.T) {
+	t.Parallel()
+
+	protocols, err := ParseRuntimeProtocols("")
+	require.NoError(t, err)
+	require.Empty(t, protocols)
+
+	protocols, err = ParseRuntimeProtocols("cri, internal")
+	require.NoError(t, err)
+	require.Equal(t, []string{containerutilsTypes.RuntimeProtocolCRI, containerutilsTypes.RuntimeProtocolInternal}, protocols)
+
+	_, err = ParseRuntimeProtocols("internal,foo")
+	require.Error(t, err)
+
+	_, err = ParseRuntimeProtocols("cri,cri")
+	require.Error(t, err)
+}
+
+func TestFallbackClient(t *testing.T) {
+	t.Parallel()
+
+	internal := &fakeClient{err: errors.New("version mismatch")}
+	cri := &fakeClient{}
+	c := &fallbackClient{
+		name:      types.RuntimeNameContainerd,
+		clients:   []runtimeclient.ContainerRuntimeClient{internal, cri},
+		protocols: []string{containerutilsTypes.RuntimeProtocolInternal, containerutilsTypes.RuntimeProtocolCRI},
+	}
+
+	containers, err := c.GetContainers()
+	require.NoError(t, err)
+	require.Len(t, containers, 1)
+	require.Equal(t, 1, internal.calls)
+	require.Equal(t, 1, cri.calls)
+
+	// The client that worked is used first from now on
+	_, err = c.GetContainerDetails("foo")
+	require.NoError(t, err)
+	require.Equal(t, 1, internal.calls)
+	require.Equal(t, 2, cri.calls)
+
+	// The first error is returned if all clients fail
+	cri.err = errors.New("unavailable")
+	_, err = c.GetContainer("foo")
+	require.ErrorContains(t, err, "unavailable")
+	require.Equal(t, 2, internal.calls)
+
+	// Errors not related to the protocol are returned directly
+	cri.err = runtimeclient.ErrPauseContainer
+	_, err = c.GetContainerDetails("foo")
+	require.ErrorIs(t, err, runtimeclient.ErrPauseContainer)
+	require.Equal(t, 2, internal.calls)
+}
+
+func TestNewContainerRuntimeClientWithFallback(t *testing.T) {
+	t.Parallel()
+
+	rc, err := NewContainerRuntimeClient(&containerutilsTypes.RuntimeConfig{
+		Name:            types.RuntimeNameContainerd,
+		RuntimeProtocol: "cri,internal",
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { rc.Close() })
+	require.IsType(t, &fallbackClient{}, rc)
+	require.Equal(t, []string{containerutilsTypes.RuntimeProtocolCRI, containerutilsTypes.RuntimeProtocolInternal},
+		rc.(*fallbackClient).protocols)
+
+	_, err = NewContainerRuntimeClient(&containerutilsTypes.RuntimeConfig{
+		Name:            types.RuntimeNameContainerd,
+		RuntimeProtocol: "foo",
+	})
+	require.Error(t, err)
+}
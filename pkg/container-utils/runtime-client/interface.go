@@ -45,6 +45,9 @@ type RuntimeContainerData struct {
 
 	// Current state of the container.
 	State string
+
+	// Number of times the container has been restarted, if reported by the runtime.
+	RestartCount uint32
 }
 
 // ContainerData contains container information returned from the container
@@ -26,8 +26,10 @@ type ExtraConfig struct {
 }
 
 type RuntimeConfig struct {
-	Name            types.RuntimeName
-	SocketPath      string
+	Name       types.RuntimeName
+	SocketPath string
+	// RuntimeProtocol is the protocol used to talk to the runtime. It can be
+	// a comma-separated list of protocols, which are tried in order.
 	RuntimeProtocol string
 	Extra           ExtraConfig
 }
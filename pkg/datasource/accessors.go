@@ -15,11 +15,13 @@
 package datasource
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"maps"
 	"math"
 	"slices"
+	"unsafe"
 
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets"
@@ -76,6 +78,12 @@ type FieldAccessor interface {
 	// Rename changes the name of the field. Currently it's not supported for subfields.
 	Rename(string) error
 
+	// SetDecoder makes the field lazily decoded: instead of computing its value for every Data, decoder is only
+	// called once a subscriber reads the field (or the whole payload is serialized). Values set explicitly or
+	// unmarshaled into the payload take precedence over the decoder. Decoders should only be set in the initialization
+	// phase.
+	SetDecoder(decoder DecodeFunc) error
+
 	Uint8(Data) uint8
 	Uint16(Data) uint16
 	Uint32(Data) uint32
@@ -88,6 +96,9 @@ type FieldAccessor interface {
 	Float32(Data) float32
 	Float64(Data) float64
 
+	// Bool returns the value of a field of kind api.Kind_Bool, which is stored in one byte
+	Bool(Data) bool
+
 	PutUint8(Data, uint8)
 	PutUint16(Data, uint16)
 	PutUint32(Data, uint32)
@@ -96,9 +107,22 @@ type FieldAccessor interface {
 	PutInt16(Data, int16)
 	PutInt32(Data, int32)
 	PutInt64(Data, int64)
+	PutBool(Data, bool)
 
 	String(Data) string
 	CString(Data) string
+
+	// StringUnsafe works like String, but returns a view of the underlying memory instead of a copy. It's only valid
+	// as long as Data is, i.e. until the DataFunc it was passed to returns, and must not be kept or used after the
+	// field was set again. Use it to avoid allocations when the value is only read, e.g. when serializing Data.
+	StringUnsafe(Data) string
+
+	// CStringUnsafe works like CString without copying; see StringUnsafe for when it's valid
+	CStringUnsafe(Data) string
+
+	// BytesUnsafe returns the value of the field as a view of the underlying memory like Get; values of fields of
+	// kind api.Kind_CString end at the first NUL byte. See StringUnsafe for when it's valid; it must not be modified.
+	BytesUnsafe(Data) []byte
 }
 
 type fieldAccessor struct {
@@ -143,11 +167,15 @@ func (a *fieldAccessor) Get(d Data) []byte {
 	if FieldFlagEmpty.In(a.f.Flags) {
 		return nil
 	}
+	dd := d.(*data)
+	if dd.decoded != nil {
+		dd.decode(a.f.Index)
+	}
 	if a.f.Size > 0 {
 		// size and offset must be valid here; checks take place on initialization
-		return d.(*data).Payload[a.f.PayloadIndex][a.f.Offs : a.f.Offs+a.f.Size]
+		return dd.Payload[a.f.PayloadIndex][a.f.Offs : a.f.Offs+a.f.Size]
 	}
-	return d.(*data).Payload[a.f.PayloadIndex]
+	return dd.Payload[a.f.PayloadIndex]
 }
 
 func (a *fieldAccessor) setHidden(hidden bool, recurse bool) {
@@ -178,7 +206,7 @@ func (a *fieldAccessor) Set(d Data, b []byte) error {
 			return fmt.Errorf("invalid size, static member expected %d, got %d", a.f.Size, len(b))
 		}
 		// When accessing a member of a statically sized field, copy memory
-		copy(d.Raw().Payload[a.f.PayloadIndex][a.f.Offs:a.f.Offs+a.f.Size], b)
+		copy(d.(*data).Payload[a.f.PayloadIndex][a.f.Offs:a.f.Offs+a.f.Size], b)
 		return nil
 	}
 	if FieldFlagContainer.In(a.f.Flags) {
@@ -186,7 +214,11 @@ func (a *fieldAccessor) Set(d Data, b []byte) error {
 			return fmt.Errorf("invalid size, container expected %d, got %d", a.f.Size, len(b))
 		}
 	}
-	d.(*data).Payload[a.f.PayloadIndex] = b
+	dd := d.(*data)
+	if dd.decoded != nil {
+		dd.markDecoded(a.f.Index)
+	}
+	dd.Payload[a.f.PayloadIndex] = b
 	return nil
 }
 
@@ -390,6 +422,10 @@ func (a *fieldAccessor) Float64(data Data) float64 {
 	return math.Float64frombits(a.Uint64(data))
 }
 
+func (a *fieldAccessor) Bool(data Data) bool {
+	return a.Uint8(data) != 0
+}
+
 func (a *fieldAccessor) String(data Data) string {
 	return string(a.Get(data))
 }
@@ -398,6 +434,38 @@ func (a *fieldAccessor) CString(data Data) string {
 	return gadgets.FromCString(a.Get(data))
 }
 
+// unsafeString returns b as a string without copying it
+func unsafeString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return unsafe.String(&b[0], len(b))
+}
+
+// trimCString cuts b at its first NUL byte
+func trimCString(b []byte) []byte {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return b[:i]
+	}
+	return b
+}
+
+func (a *fieldAccessor) StringUnsafe(data Data) string {
+	return unsafeString(a.Get(data))
+}
+
+func (a *fieldAccessor) CStringUnsafe(data Data) string {
+	return unsafeString(trimCString(a.Get(data)))
+}
+
+func (a *fieldAccessor) BytesUnsafe(data Data) []byte {
+	b := a.Get(data)
+	if a.f.Kind == api.Kind_CString {
+		return trimCString(b)
+	}
+	return b
+}
+
 func (a *fieldAccessor) PutUint8(data Data, val uint8) {
 	a.Get(data)[0] = val
 }
@@ -429,3 +497,11 @@ func (a *fieldAccessor) PutInt32(data Data, val int32) {
 func (a *fieldAccessor) PutInt64(data Data, val int64) {
 	a.ds.byteOrder.PutUint64(a.Get(data), uint64(val))
 }
+
+func (a *fieldAccessor) PutBool(data Data, val bool) {
+	if val {
+		a.PutUint8(data, 1)
+	} else {
+		a.PutUint8(data, 0)
+	}
+}
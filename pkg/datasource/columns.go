@@ -156,8 +156,12 @@ func (ds *dataSource) Columns() (*columns.Columns[DataTuple], error) {
 
 		df.Type = f.ReflectType()
 		idx := f.PayloadIndex
+		fieldIndex := f.Index
 
 		err := cols.AddFields([]columns.DynamicField{df}, func(d *DataTuple) unsafe.Pointer {
+			if d.data.decoded != nil {
+				d.data.decode(fieldIndex)
+			}
 			if len(d.data.Payload[idx]) == 0 {
 				return nil
 			}
@@ -0,0 +1,136 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compat
+
+import (
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/types"
+)
+
+// CommonField maps a field of the metadata that built-in gadgets store in types.CommonData to the field of data
+// sources holding the same information
+type CommonField struct {
+	// Name is the full name of the field in data sources, e.g. "k8s.pod"
+	Name string
+
+	// LegacyName is the path of the field in the JSON representation of events of built-in gadgets, e.g.
+	// "k8s.podName"
+	LegacyName string
+
+	accessor func(ev *EventWrapperBase) datasource.FieldAccessor
+	value    func(c *types.CommonData) any
+}
+
+var commonFields = []CommonField{
+	{
+		Name:       "k8s.node",
+		LegacyName: "k8s.node",
+		accessor:   func(ev *EventWrapperBase) datasource.FieldAccessor { return ev.nodeAccessor },
+		value:      func(c *types.CommonData) any { return c.K8s.Node },
+	},
+	{
+		Name:       "k8s.namespace",
+		LegacyName: "k8s.namespace",
+		accessor:   func(ev *EventWrapperBase) datasource.FieldAccessor { return ev.namespaceAccessor },
+		value:      func(c *types.CommonData) any { return c.K8s.Namespace },
+	},
+	{
+		Name:       "k8s.pod",
+		LegacyName: "k8s.podName",
+		accessor:   func(ev *EventWrapperBase) datasource.FieldAccessor { return ev.podnameAccessor },
+		value:      func(c *types.CommonData) any { return c.K8s.PodName },
+	},
+	{
+		Name:       "k8s.container",
+		LegacyName: "k8s.containerName",
+		accessor:   func(ev *EventWrapperBase) datasource.FieldAccessor { return ev.containernameAccessorK8s },
+		value:      func(c *types.CommonData) any { return c.K8s.ContainerName },
+	},
+	{
+		Name:       "k8s.hostnetwork",
+		LegacyName: "k8s.hostNetwork",
+		accessor:   func(ev *EventWrapperBase) datasource.FieldAccessor { return ev.hostNetworkAccessor },
+		value:      func(c *types.CommonData) any { return c.K8s.HostNetwork },
+	},
+	{
+		Name:       "runtime.containerName",
+		LegacyName: "runtime.containerName",
+		accessor:   func(ev *EventWrapperBase) datasource.FieldAccessor { return ev.containernameAccessor },
+		value:      func(c *types.CommonData) any { return c.Runtime.ContainerName },
+	},
+	{
+		Name:       "runtime.runtimeName",
+		LegacyName: "runtime.runtimeName",
+		accessor:   func(ev *EventWrapperBase) datasource.FieldAccessor { return ev.runtimenameAccessor },
+		value:      func(c *types.CommonData) any { return string(c.Runtime.RuntimeName) },
+	},
+	{
+		Name:       "runtime.containerId",
+		LegacyName: "runtime.containerId",
+		accessor:   func(ev *EventWrapperBase) datasource.FieldAccessor { return ev.containeridAccessor },
+		value:      func(c *types.CommonData) any { return c.Runtime.ContainerID },
+	},
+	{
+		Name:       "runtime.containerImageName",
+		LegacyName: "runtime.containerImageName",
+		accessor:   func(ev *EventWrapperBase) datasource.FieldAccessor { return ev.containerimagenameAccessor },
+		value:      func(c *types.CommonData) any { return c.Runtime.ContainerImageName },
+	},
+	{
+		Name:       "runtime.containerImageDigest",
+		LegacyName: "runtime.containerImageDigest",
+		accessor:   func(ev *EventWrapperBase) datasource.FieldAccessor { return ev.containerimagedigestAccessor },
+		value:      func(c *types.CommonData) any { return c.Runtime.ContainerImageDigest },
+	},
+}
+
+// CommonFields returns the fields of types.CommonData that have a counterpart in data sources
+func CommonFields() []CommonField {
+	res := make([]CommonField, len(commonFields))
+	copy(res, commonFields)
+	return res
+}
+
+// FieldName returns the name of the data source field holding the information of the field legacyName of events of
+// built-in gadgets; names of fields that don't belong to types.CommonData are returned unchanged
+func FieldName(legacyName string) string {
+	for _, f := range commonFields {
+		if f.LegacyName == legacyName {
+			return f.Name
+		}
+	}
+	return legacyName
+}
+
+// SetCommonData populates the fields of the container metadata from the metadata of an event of a built-in gadget.
+// Fields without a value in c are left untouched, so that they can still be enriched by other operators.
+func (ev *EventWrapper) SetCommonData(c *types.CommonData) {
+	for _, f := range commonFields {
+		acc := f.accessor(ev.EventWrapperBase)
+		if !acc.IsRequested() {
+			continue
+		}
+		switch v := f.value(c).(type) {
+		case string:
+			if v != "" {
+				acc.Set(ev.Data, []byte(v))
+			}
+		case bool:
+			if v {
+				acc.Set(ev.Data, []byte{1})
+			}
+		}
+	}
+}
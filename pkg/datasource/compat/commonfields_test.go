@@ -0,0 +1,68 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/types"
+)
+
+func TestFieldName(t *testing.T) {
+	require.Equal(t, "k8s.pod", FieldName("k8s.podName"))
+	require.Equal(t, "k8s.container", FieldName("k8s.containerName"))
+	require.Equal(t, "runtime.containerId", FieldName("runtime.containerId"))
+	require.Equal(t, "pid", FieldName("pid"))
+}
+
+func TestCommonFieldsExist(t *testing.T) {
+	ds := datasource.New(datasource.TypeEvent, "test")
+	_, err := WrapAccessors(ds, nil, nil)
+	require.NoError(t, err)
+
+	for _, f := range CommonFields() {
+		require.NotNil(t, ds.GetField(f.Name), f.Name)
+	}
+}
+
+func TestWrapAccessorsTwice(t *testing.T) {
+	ds := datasource.New(datasource.TypeEvent, "test")
+	first, err := WrapAccessors(ds, nil, nil)
+	require.NoError(t, err)
+	second, err := WrapAccessors(ds, nil, nil)
+	require.NoError(t, err)
+
+	data := ds.NewData()
+	wr := &EventWrapper{EventWrapperBase: first, Data: data}
+	wr.SetCommonData(&types.CommonData{
+		K8s: types.K8sMetadata{
+			Node:             "node-1",
+			BasicK8sMetadata: types.BasicK8sMetadata{Namespace: "default", PodName: "pod"},
+			HostNetwork:      true,
+		},
+		Runtime: types.BasicRuntimeMetadata{RuntimeName: types.RuntimeNameDocker, ContainerID: "abc"},
+	})
+
+	require.Equal(t, "node-1", string(second.nodeAccessor.Get(data)))
+	require.Equal(t, "default", string(second.namespaceAccessor.Get(data)))
+	require.Equal(t, "pod", string(second.podnameAccessor.Get(data)))
+	require.Equal(t, []byte{1}, second.hostNetworkAccessor.Get(data))
+	require.Equal(t, "docker", string(second.runtimenameAccessor.Get(data)))
+	require.Equal(t, "abc", string(second.containeridAccessor.Get(data)))
+	require.Empty(t, second.containernameAccessor.Get(data))
+}
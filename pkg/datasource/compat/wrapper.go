@@ -44,6 +44,10 @@ type EventWrapperBase struct {
 	containerimagenameAccessor   datasource.FieldAccessor
 	containerimagedigestAccessor datasource.FieldAccessor
 	hostNetworkAccessor          datasource.FieldAccessor
+	ownerKindAccessor            datasource.FieldAccessor
+	ownerNameAccessor            datasource.FieldAccessor
+	restartCountAccessor         datasource.FieldAccessor
+	previousContainerIDAccessor  datasource.FieldAccessor
 }
 
 type (
@@ -113,6 +117,8 @@ func Subscribe(
 	}
 }
 
+// WrapAccessors adds the fields of the container metadata to source and returns accessors to them. Fields that have
+// already been added by a previous call are reused, so several operators can wrap the same data source.
 func WrapAccessors(source datasource.DataSource, mntnsidAccessor datasource.FieldAccessor, netnsidAccessor datasource.FieldAccessor) (*EventWrapperBase, error) {
 	ev := &EventWrapperBase{
 		ds:              source,
@@ -120,34 +126,34 @@ func WrapAccessors(source datasource.DataSource, mntnsidAccessor datasource.Fiel
 		NetnsidAccessor: netnsidAccessor,
 	}
 
-	k8s, err := source.AddField("k8s", datasource.WithFlags(datasource.FieldFlagEmpty))
+	k8s, err := addField(source, "k8s", datasource.WithFlags(datasource.FieldFlagEmpty))
 	if err != nil {
 		return nil, err
 	}
 
-	ev.nodeAccessor, err = k8s.AddSubField("node", datasource.WithTags("kubernetes"))
+	ev.nodeAccessor, err = addSubField(source, k8s, "node", datasource.WithTags("kubernetes"))
 	if err != nil {
 		return nil, err
 	}
-	ev.namespaceAccessor, err = k8s.AddSubField("namespace", datasource.WithTags("kubernetes"), datasource.WithAnnotations(map[string]string{
+	ev.namespaceAccessor, err = addSubField(source, k8s, "namespace", datasource.WithTags("kubernetes"), datasource.WithAnnotations(map[string]string{
 		"columns.template": "namespace",
 	}), datasource.WithOrder(-30))
 	if err != nil {
 		return nil, err
 	}
-	ev.podnameAccessor, err = k8s.AddSubField("pod", datasource.WithTags("kubernetes"), datasource.WithAnnotations(map[string]string{
+	ev.podnameAccessor, err = addSubField(source, k8s, "pod", datasource.WithTags("kubernetes"), datasource.WithAnnotations(map[string]string{
 		"columns.template": "pod",
 	}), datasource.WithOrder(-29))
 	if err != nil {
 		return nil, err
 	}
-	ev.containernameAccessorK8s, err = k8s.AddSubField("container", datasource.WithTags("kubernetes"), datasource.WithAnnotations(map[string]string{
+	ev.containernameAccessorK8s, err = addSubField(source, k8s, "container", datasource.WithTags("kubernetes"), datasource.WithAnnotations(map[string]string{
 		"columns.template": "container",
 	}), datasource.WithOrder(-28))
 	if err != nil {
 		return nil, err
 	}
-	ev.hostNetworkAccessor, err = k8s.AddSubField(
+	ev.hostNetworkAccessor, err = addSubField(source, k8s,
 		"hostnetwork",
 		datasource.WithTags("kubernetes"),
 		datasource.WithKind(api.Kind_Bool),
@@ -158,17 +164,42 @@ func WrapAccessors(source datasource.DataSource, mntnsidAccessor datasource.Fiel
 		return nil, err
 	}
 
+	ev.ownerKindAccessor, err = addSubField(source, k8s,
+		"ownerKind",
+		datasource.WithTags("kubernetes"),
+		datasource.WithAnnotations(map[string]string{
+			"description": "Kind of the workload owning the pod, e.g. Deployment",
+		}),
+		datasource.WithFlags(datasource.FieldFlagHidden),
+		datasource.WithOrder(-27),
+	)
+	if err != nil {
+		return nil, err
+	}
+	ev.ownerNameAccessor, err = addSubField(source, k8s,
+		"ownerName",
+		datasource.WithTags("kubernetes"),
+		datasource.WithAnnotations(map[string]string{
+			"description": "Name of the workload owning the pod",
+		}),
+		datasource.WithFlags(datasource.FieldFlagHidden),
+		datasource.WithOrder(-27),
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	// TODO: Instead of just hiding fields, we can skip adding them in the first place (integration tests don't like
 	// that right now, though)
 	if environment.Environment != environment.Kubernetes {
 		k8s.SetHidden(true, true)
 	}
 
-	runtime, err := source.AddField("runtime", datasource.WithFlags(datasource.FieldFlagEmpty))
+	runtime, err := addField(source, "runtime", datasource.WithFlags(datasource.FieldFlagEmpty))
 	if err != nil {
 		return nil, err
 	}
-	ev.containernameAccessor, err = runtime.AddSubField(
+	ev.containernameAccessor, err = addSubField(source, runtime,
 		"containerName",
 		datasource.WithAnnotations(map[string]string{
 			"columns.template": "container",
@@ -178,7 +209,7 @@ func WrapAccessors(source datasource.DataSource, mntnsidAccessor datasource.Fiel
 	if err != nil {
 		return nil, err
 	}
-	ev.runtimenameAccessor, err = runtime.AddSubField(
+	ev.runtimenameAccessor, err = addSubField(source, runtime,
 		"runtimeName",
 		datasource.WithAnnotations(map[string]string{
 			"columns.width": "19",
@@ -190,7 +221,7 @@ func WrapAccessors(source datasource.DataSource, mntnsidAccessor datasource.Fiel
 	if err != nil {
 		return nil, err
 	}
-	ev.containeridAccessor, err = runtime.AddSubField(
+	ev.containeridAccessor, err = addSubField(source, runtime,
 		"containerId",
 		datasource.WithAnnotations(map[string]string{
 			"columns.width":    "13",
@@ -201,7 +232,7 @@ func WrapAccessors(source datasource.DataSource, mntnsidAccessor datasource.Fiel
 	if err != nil {
 		return nil, err
 	}
-	ev.containerimagenameAccessor, err = runtime.AddSubField(
+	ev.containerimagenameAccessor, err = addSubField(source, runtime,
 		"containerImageName",
 		datasource.WithFlags(datasource.FieldFlagHidden),
 		datasource.WithOrder(-23),
@@ -209,7 +240,7 @@ func WrapAccessors(source datasource.DataSource, mntnsidAccessor datasource.Fiel
 	if err != nil {
 		return nil, err
 	}
-	ev.containerimagedigestAccessor, err = runtime.AddSubField(
+	ev.containerimagedigestAccessor, err = addSubField(source, runtime,
 		"containerImageDigest",
 		datasource.WithFlags(datasource.FieldFlagHidden),
 		datasource.WithOrder(-22),
@@ -217,6 +248,32 @@ func WrapAccessors(source datasource.DataSource, mntnsidAccessor datasource.Fiel
 	if err != nil {
 		return nil, err
 	}
+	ev.restartCountAccessor, err = addSubField(source, runtime,
+		"restartCount",
+		datasource.WithKind(api.Kind_Uint32),
+		datasource.WithAnnotations(map[string]string{
+			"description":   "Number of times the container has been restarted",
+			"columns.width": "7",
+		}),
+		datasource.WithFlags(datasource.FieldFlagHidden),
+		datasource.WithOrder(-21),
+	)
+	if err != nil {
+		return nil, err
+	}
+	ev.previousContainerIDAccessor, err = addSubField(source, runtime,
+		"previousContainerId",
+		datasource.WithAnnotations(map[string]string{
+			"description":      "ID of the container replaced by the restarted container",
+			"columns.width":    "13",
+			"columns.maxWidth": "64",
+		}),
+		datasource.WithFlags(datasource.FieldFlagHidden),
+		datasource.WithOrder(-20),
+	)
+	if err != nil {
+		return nil, err
+	}
 
 	// TODO: Instead of just hiding fields, we can skip adding them in the first place (integration tests don't like
 	// that right now, though)
@@ -227,6 +284,49 @@ func WrapAccessors(source datasource.DataSource, mntnsidAccessor datasource.Fiel
 	return ev, nil
 }
 
+// addField returns the field called name of ds, adding it if it doesn't exist yet
+func addField(ds datasource.DataSource, name string, opts ...datasource.FieldOption) (datasource.FieldAccessor, error) {
+	if f := ds.GetField(name); f != nil {
+		return f, nil
+	}
+	return ds.AddField(name, opts...)
+}
+
+// addSubField returns the sub field called name of the top-level field parent, adding it if it doesn't exist yet
+func addSubField(ds datasource.DataSource, parent datasource.FieldAccessor, name string, opts ...datasource.FieldOption) (datasource.FieldAccessor, error) {
+	if f := ds.GetField(parent.Name() + "." + name); f != nil {
+		return f, nil
+	}
+	return parent.AddSubField(name, opts...)
+}
+
+// OwnerResolver returns the kind and name of the workload owning a pod
+type OwnerResolver func(namespace, podName string) (kind string, name string)
+
+// SetOwnerResolver makes the k8s.ownerKind and k8s.ownerName fields use resolver. The owner is only looked up when
+// one of the fields is read, using the namespace and pod set by SetPodMetadata. It must be called before the gadget
+// is started.
+func (ev *EventWrapperBase) SetOwnerResolver(resolver OwnerResolver) error {
+	resolve := func(data datasource.Data) (string, string) {
+		return resolver(string(ev.namespaceAccessor.Get(data)), string(ev.podnameAccessor.Get(data)))
+	}
+	err := ev.ownerKindAccessor.SetDecoder(func(ds datasource.DataSource, data datasource.Data) []byte {
+		kind, _ := resolve(data)
+		return []byte(kind)
+	})
+	if err != nil {
+		return fmt.Errorf("setting decoder for owner kind: %w", err)
+	}
+	err = ev.ownerNameAccessor.SetDecoder(func(ds datasource.DataSource, data datasource.Data) []byte {
+		_, name := resolve(data)
+		return []byte(name)
+	})
+	if err != nil {
+		return fmt.Errorf("setting decoder for owner name: %w", err)
+	}
+	return nil
+}
+
 type EventWrapper struct {
 	*EventWrapperBase
 	Data datasource.Data
@@ -288,6 +388,16 @@ func (ev *EventWrapper) SetPodMetadata(container types.Container) {
 			ev.containerimagedigestAccessor.Set(ev.Data, []byte(rt.ContainerImageDigest))
 		}
 	}
+	restarts := container.RestartMetadata()
+	if restarts != nil {
+		if ev.restartCountAccessor.IsRequested() {
+			ev.restartCountAccessor.Set(ev.Data, make([]byte, 4))
+			ev.restartCountAccessor.PutUint32(ev.Data, restarts.RestartCount)
+		}
+		if ev.previousContainerIDAccessor.IsRequested() {
+			ev.previousContainerIDAccessor.Set(ev.Data, []byte(restarts.PreviousContainerID))
+		}
+	}
 }
 
 func (ev *EventWrapper) SetContainerMetadata(container types.Container) {
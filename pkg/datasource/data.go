@@ -25,17 +25,58 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
 )
 
-type (
-	data  api.GadgetData
-	field api.Field
-)
+type field api.Field
+
+type data struct {
+	api.GadgetData
+
+	ds *dataSource
+
+	// decoded tracks which of the lazily decoded fields of ds have already been decoded; it's nil if the DataSource
+	// has no such fields
+	decoded []bool
+}
 
 func (*data) private() {}
 
+// decode runs the decoder of the field with the given index, if it has one and hasn't been decoded or set before
+func (d *data) decode(fieldIndex uint32) {
+	lf, ok := d.ds.lazyFields[fieldIndex]
+	if !ok || d.decoded[lf.idx] {
+		return
+	}
+	// Mark as decoded first, so decoders can read the field without recursing
+	d.decoded[lf.idx] = true
+	if len(d.Payload[lf.payloadIndex]) > 0 {
+		// The value has been written to the payload directly, e.g. when unmarshaling Data decoded by a remote
+		return
+	}
+	d.Payload[lf.payloadIndex] = lf.decoder(d.ds, d)
+}
+
+// decodeAll decodes all pending lazy fields, e.g. before the whole payload is dumped or serialized
+func (d *data) decodeAll() {
+	if d.decoded == nil {
+		return
+	}
+	for fieldIndex := range d.ds.lazyFields {
+		d.decode(fieldIndex)
+	}
+}
+
+// markDecoded makes sure the decoder of a field won't override a value that has been set explicitly
+func (d *data) markDecoded(fieldIndex uint32) {
+	if lf, ok := d.ds.lazyFields[fieldIndex]; ok {
+		d.decoded[lf.idx] = true
+	}
+}
+
 func (f *field) ReflectType() reflect.Type {
 	switch f.Kind {
 	default:
@@ -84,14 +125,32 @@ type dataSource struct {
 
 	subscriptions []*subscription
 
+	// lazyFields holds the decoders of fields by field index; it's only modified in the initialization phase
+	lazyFields map[uint32]*lazyField
+
 	requested bool
 
+	// priority is read on every emit, so it's kept outside the lock
+	priority atomic.Int32
+	shedder  *Shedder
+
+	stats dataSourceStats
+
 	byteOrder binary.ByteOrder
 	lock      sync.RWMutex
 }
 
-func newDataSource(t Type, name string) *dataSource {
-	return &dataSource{
+type DataSourceOption func(*dataSource)
+
+// WithShedder makes the DataSource drop its data when its priority class is below the threshold of shedder
+func WithShedder(shedder *Shedder) DataSourceOption {
+	return func(ds *dataSource) {
+		ds.shedder = shedder
+	}
+}
+
+func newDataSource(t Type, name string, options ...DataSourceOption) *dataSource {
+	ds := &dataSource{
 		name:            name,
 		dType:           t,
 		requestedFields: make(map[string]bool),
@@ -99,20 +158,33 @@ func newDataSource(t Type, name string) *dataSource {
 		byteOrder:       binary.NativeEndian,
 		tags:            make([]string, 0),
 		annotations:     map[string]string{},
+		lazyFields:      make(map[uint32]*lazyField),
+	}
+	ds.priority.Store(int32(PriorityNormal))
+	for _, option := range options {
+		option(ds)
 	}
+	return ds
 }
 
-func New(t Type, name string) DataSource {
-	return newDataSource(t, name)
+func New(t Type, name string, options ...DataSourceOption) DataSource {
+	return newDataSource(t, name, options...)
 }
 
-func NewFromAPI(in *api.DataSource) (DataSource, error) {
-	ds := newDataSource(Type(in.Type), in.Name)
+func NewFromAPI(in *api.DataSource, options ...DataSourceOption) (DataSource, error) {
+	ds := newDataSource(Type(in.Type), in.Name, options...)
+	for k, v := range in.Annotations {
+		ds.AddAnnotation(k, v)
+	}
 	for _, f := range in.Fields {
 		ds.fields = append(ds.fields, (*field)(f))
 		if !FieldFlagUnreferenced.In(f.Flags) {
 			ds.fieldMap[f.Name] = (*field)(f)
 		}
+		// Reserve the payloads used by the fields, so that data can also be created locally
+		if !FieldFlagEmpty.In(f.Flags) {
+			ds.payloadCount = max(ds.payloadCount, f.PayloadIndex+1)
+		}
 	}
 	if in.Flags&api.DataSourceFlagsBigEndian != 0 {
 		ds.byteOrder = binary.BigEndian
@@ -133,11 +205,17 @@ func (ds *dataSource) Type() Type {
 
 func (ds *dataSource) NewData() Data {
 	d := &data{
-		Payload: make([][]byte, ds.payloadCount),
+		GadgetData: api.GadgetData{
+			Payload: make([][]byte, ds.payloadCount),
+		},
+		ds: ds,
 	}
 	for i := range d.Payload {
 		d.Payload[i] = make([]byte, 0)
 	}
+	if len(ds.lazyFields) > 0 {
+		d.decoded = make([]bool, len(ds.lazyFields))
+	}
 	return d
 }
 
@@ -312,22 +390,71 @@ func (ds *dataSource) Subscribe(fn DataFunc, priority int) {
 	if fn == nil {
 		return
 	}
+	ds.subscribe(&subscription{
+		priority: priority,
+		fn:       fn,
+	})
+}
 
+func (ds *dataSource) SubscribeBatch(fn BatchFunc, priority int) {
+	if fn == nil {
+		return
+	}
+	ds.subscribe(&subscription{
+		priority: priority,
+		batchFn:  fn,
+	})
+}
+
+func (ds *dataSource) subscribe(sub *subscription) {
 	ds.lock.Lock()
 	defer ds.lock.Unlock()
 
-	ds.subscriptions = append(ds.subscriptions, &subscription{
-		priority: priority,
-		fn:       fn,
-	})
+	ds.subscriptions = append(ds.subscriptions, sub)
 	sort.SliceStable(ds.subscriptions, func(i, j int) bool {
 		return ds.subscriptions[i].priority < ds.subscriptions[j].priority
 	})
 }
 
+func (ds *dataSource) SubscribeFiltered(fn DataFunc, priority int, expr string) error {
+	filter, err := CompileFilter(ds, expr)
+	if err != nil {
+		return fmt.Errorf("compiling filter: %w", err)
+	}
+	ds.Subscribe(func(ds DataSource, d Data) error {
+		if !filter.Match(d) {
+			return nil
+		}
+		return fn(ds, d)
+	}, priority)
+	return nil
+}
+
 func (ds *dataSource) EmitAndRelease(d Data) error {
+	if ds.shedder != nil && ds.shedder.shouldShed(ds.PriorityClass()) {
+		ds.stats.shed.Add(1)
+		return nil
+	}
+	ds.stats.emitted.Add(1)
+	measure := ds.stats.measure.Load()
 	for _, sub := range ds.subscriptions {
-		err := sub.fn(ds, d)
+		var start time.Time
+		if measure {
+			start = time.Now()
+		}
+		var err error
+		if sub.fn != nil {
+			err = sub.fn(ds, d)
+		} else {
+			err = sub.batchFn(ds, []Data{d})
+		}
+		if measure {
+			sub.stats.record(time.Since(start), 1)
+		}
+		if errors.Is(err, ErrDiscard) {
+			ds.stats.discarded.Add(1)
+			return nil
+		}
 		if err != nil {
 			return err
 		}
@@ -335,11 +462,73 @@ func (ds *dataSource) EmitAndRelease(d Data) error {
 	return nil
 }
 
+func (ds *dataSource) EmitBatch(batch []Data) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	if ds.shedder != nil && ds.shedder.shouldShed(ds.PriorityClass()) {
+		ds.stats.shed.Add(uint64(len(batch)))
+		return nil
+	}
+	ds.stats.emitted.Add(uint64(len(batch)))
+	measure := ds.stats.measure.Load()
+	for _, sub := range ds.subscriptions {
+		var start time.Time
+		if measure {
+			start = time.Now()
+		}
+		calls := len(batch)
+		if sub.batchFn != nil {
+			err := sub.batchFn(ds, batch)
+			if errors.Is(err, ErrDiscard) {
+				batch = batch[:0]
+			} else if err != nil {
+				return err
+			}
+		} else {
+			// Keep the Data that wasn't discarded for the following subscribers in a new slice, since the caller
+			// might reuse the batch
+			var kept []Data
+			for i, d := range batch {
+				err := sub.fn(ds, d)
+				if errors.Is(err, ErrDiscard) {
+					if kept == nil {
+						kept = append(make([]Data, 0, len(batch)), batch[:i]...)
+					}
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if kept != nil {
+					kept = append(kept, d)
+				}
+			}
+			if kept != nil {
+				batch = kept
+			}
+		}
+		if measure {
+			sub.stats.record(time.Since(start), calls)
+		}
+		ds.stats.discarded.Add(uint64(calls - len(batch)))
+		if len(batch) == 0 {
+			return nil
+		}
+	}
+	return nil
+}
+
 func (ds *dataSource) Release(d Data) {
 }
 
 func (ds *dataSource) ReportLostData(ctr uint64) {
-	// TODO
+	ds.stats.lost.Add(ctr)
+}
+
+func (ds *dataSource) ReportLostDataReason(ctr uint64, reason string) {
+	ds.stats.lost.Add(ctr)
+	ds.stats.addLost(reason, ctr)
 }
 
 func (ds *dataSource) IsRequestedField(fieldName string) bool {
@@ -354,6 +543,7 @@ func (ds *dataSource) Dump(xd Data, wr io.Writer) {
 	defer ds.lock.RUnlock()
 
 	d := xd.(*data)
+	d.decodeAll()
 	for _, f := range ds.fields {
 		if f.Offs+f.Size > uint32(len(d.Payload[f.PayloadIndex])) {
 			fmt.Fprintf(wr, "%s (%d): ! invalid size\n", f.Name, f.Size)
@@ -406,6 +596,16 @@ func (ds *dataSource) AddAnnotation(key, value string) {
 	ds.lock.Lock()
 	defer ds.lock.Unlock()
 	ds.annotations[key] = value
+
+	if key == AnnotationPriority {
+		// Invalid values are rejected when validating the gadget metadata; fall back to the default here
+		p, _ := ParsePriorityClass(value)
+		ds.priority.Store(int32(p))
+	}
+}
+
+func (ds *dataSource) PriorityClass() PriorityClass {
+	return PriorityClass(ds.priority.Load())
 }
 
 func (ds *dataSource) AddTag(tag string) {
@@ -0,0 +1,76 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datasource
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+func TestNewFromAPINewData(t *testing.T) {
+	in := New(TypeEvent, "test")
+	_, err := in.AddField("name", WithKind(api.Kind_String))
+	require.NoError(t, err)
+	_, err = in.AddField("comm", WithKind(api.Kind_String))
+	require.NoError(t, err)
+
+	ds, err := NewFromAPI(&api.DataSource{Name: in.Name(), Fields: in.Fields()})
+	require.NoError(t, err)
+
+	// Data created locally holds the payloads of all fields
+	data := ds.NewData()
+	require.Len(t, data.Raw().Payload, 2)
+	require.NoError(t, ds.GetField("name").Set(data, []byte("foo")))
+	require.NoError(t, ds.GetField("comm").Set(data, []byte("bar")))
+	require.Equal(t, "foo", ds.GetField("name").String(data))
+	require.Equal(t, "bar", ds.GetField("comm").String(data))
+}
+
+func TestEmitBatchKeepsBatch(t *testing.T) {
+	ds := New(TypeEvent, "test")
+	name, err := ds.AddField("name", WithKind(api.Kind_String))
+	require.NoError(t, err)
+
+	// The first subscriber discards "b", the second one gets the rest
+	ds.Subscribe(func(ds DataSource, data Data) error {
+		if name.String(data) == "b" {
+			return ErrDiscard
+		}
+		return nil
+	}, 0)
+	var got []string
+	ds.Subscribe(func(ds DataSource, data Data) error {
+		got = append(got, name.String(data))
+		return nil
+	}, 1)
+
+	batch := make([]Data, 0, 3)
+	for _, s := range []string{"a", "b", "c"} {
+		data := ds.NewData()
+		require.NoError(t, name.Set(data, []byte(s)))
+		batch = append(batch, data)
+	}
+	require.NoError(t, ds.EmitBatch(batch))
+	require.Equal(t, []string{"a", "c"}, got)
+
+	// The batch of the caller is left as it was
+	require.Len(t, batch, 3)
+	for i, s := range []string{"a", "b", "c"} {
+		require.Equal(t, s, name.String(batch[i]))
+	}
+}
@@ -30,6 +30,14 @@ const (
 	TypeMetrics
 )
 
+// AnnotationImage is the DataSource annotation holding the image of the gadget the DataSource belongs to; it's set
+// when multiple gadgets share a gadget context
+const AnnotationImage = "gadget.image"
+
+// AnnotationShared marks DataSources that are identical for all gadgets sharing a gadget context, like the ones added
+// by a runtime; only a single one of them is used then
+const AnnotationShared = "gadget.shared"
+
 type Data interface {
 	private()
 	SetSeq(uint32)
@@ -40,14 +48,29 @@ func (d *data) SetSeq(seq uint32) {
 	d.Seq = seq
 }
 
+// Raw returns the underlying payload as is; lazily decoded fields that haven't been read yet are empty. Use
+// Serializable to get a payload that can be sent to others.
 func (d *data) Raw() *api.GadgetData {
-	return (*api.GadgetData)(d)
+	return &d.GadgetData
+}
+
+// Serializable decodes the pending lazy fields of d and returns the payload, e.g. to marshal it. Hidden fields are
+// decoded as well, since remote or buffered consumers might read them; decoded values aren't decoded again by the
+// receiving side.
+func Serializable(d Data) *api.GadgetData {
+	dd := d.(*data)
+	dd.decodeAll()
+	return &dd.GadgetData
 }
 
 // DataFunc is the callback that will be called for Data emitted by a DataSource. Data has to be consumed
 // synchronously and may not be accessed after returning - make a copy if you need to hold on to Data.
 type DataFunc func(DataSource, Data) error
 
+// BatchFunc is the callback that will be called for batches of Data emitted by a DataSource using EmitBatch. The same
+// rules as for DataFunc apply to every Data of the batch; the slice must not be accessed after returning either.
+type BatchFunc func(DataSource, []Data) error
+
 // DataSource is an interface that represents a data source of a gadget. Usually, it represents a map in eBPF and some
 // tooling around handling it in Go. An eBPF program can have multiple DataSources, each one representing a different
 // map.
@@ -75,12 +98,21 @@ type DataSource interface {
 	// in the initialization phase.
 	EmitAndRelease(Data) error
 
+	// EmitBatch works like EmitAndRelease for several Data at once; subscribers registered using SubscribeBatch get
+	// them in a single call, the others get them one by one. Data discarded by a subscriber isn't handed to the
+	// following ones. This saves the overhead of passing high rates of Data through the operator chain one by one.
+	EmitBatch([]Data) error
+
 	// Release releases the memory of Data; Data may not be used after calling this
 	Release(Data)
 
 	// ReportLostData reports a number of lost data cases
 	ReportLostData(lostSampleCount uint64)
 
+	// ReportLostDataReason works like ReportLostData, but also counts the lost data cases by reason, e.g.
+	// LostReasonBufferFull
+	ReportLostDataReason(lostSampleCount uint64, reason string)
+
 	// Dump dumps the content of Data to a writer for debugging purposes
 	Dump(Data, io.Writer)
 
@@ -90,6 +122,15 @@ type DataSource interface {
 	// and must not be accessed after returning.
 	Subscribe(dataFn DataFunc, priority int)
 
+	// SubscribeFiltered works like Subscribe, but only passes Data matching the filter expression expr to dataFn;
+	// see Filter for the syntax of expressions.
+	SubscribeFiltered(dataFn DataFunc, priority int, expr string) error
+
+	// SubscribeBatch works like Subscribe, but passes the Data emitted using EmitBatch to batchFn in a single call.
+	// Data emitted using EmitAndRelease is passed as a batch of one. If batchFn returns ErrDiscard, the whole batch
+	// is discarded.
+	SubscribeBatch(batchFn BatchFunc, priority int)
+
 	Parser() (parser.Parser, error)
 
 	Fields() []*api.Field
@@ -104,6 +145,15 @@ type DataSource interface {
 	AddAnnotation(key, value string)
 	AddTag(tag string)
 
+	// PriorityClass returns the priority class set by the AnnotationPriority annotation
+	PriorityClass() PriorityClass
+
 	Annotations() map[string]string
 	Tags() []string
+
+	// Stats returns the counters of the DataSource
+	Stats() Stats
+
+	// MeasureSubscribers enables measuring the time spent in each subscriber for Stats
+	MeasureSubscribers()
 }
@@ -0,0 +1,364 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datasource
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+// ErrDiscard can be returned by a DataFunc to drop Data silently; subscribers with a higher priority won't get it
+var ErrDiscard = errors.New("data discarded")
+
+// Filter is a compiled filter expression like
+//
+//	proc.comm == "nginx" && (dns.qr == "R" || addr in 10.0.0.0/8)
+//
+// Comparisons (==, !=, <, <=, >, >=) are done according to the type of the field: numerically for numeric fields,
+// lexicographically for strings. "in" checks whether an IP address is part of a CIDR. Comparisons can be combined
+// using && and || and negated using !; parentheses can be used for grouping.
+type Filter struct {
+	expr  string
+	match func(Data) bool
+}
+
+// CompileFilter compiles expr into a Filter that matches Data of ds
+func CompileFilter(ds DataSource, expr string) (*Filter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{ds: ds, tokens: tokens}
+	match, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].val)
+	}
+	return &Filter{expr: expr, match: match}, nil
+}
+
+// Match returns whether data matches the filter
+func (f *Filter) Match(data Data) bool {
+	return f.match(data)
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
+
+type filterTokenType int
+
+const (
+	filterTokenWord filterTokenType = iota
+	filterTokenString
+	filterTokenOp
+)
+
+type filterToken struct {
+	typ filterTokenType
+	val string
+}
+
+var filterOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"}
+
+func isFilterWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.:/-+", r)
+}
+
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(expr) && expr[end] != '"'; end++ {
+				if expr[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			val, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %w", i, err)
+			}
+			tokens = append(tokens, filterToken{typ: filterTokenString, val: val})
+			i = end + 1
+		default:
+			op := ""
+			for _, o := range filterOperators {
+				if strings.HasPrefix(expr[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op != "" {
+				tokens = append(tokens, filterToken{typ: filterTokenOp, val: op})
+				i += len(op)
+				continue
+			}
+			end := i
+			for end < len(expr) && isFilterWordRune(rune(expr[end])) {
+				end++
+			}
+			if end == i {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+			tokens = append(tokens, filterToken{typ: filterTokenWord, val: expr[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	ds     DataSource
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peekOp(op string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].typ == filterTokenOp && p.tokens[p.pos].val == op
+}
+
+func (p *filterParser) next() (filterToken, error) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, errors.New("unexpected end of expression")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *filterParser) parseOr() (func(Data) bool, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOp("||") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(d Data) bool { return l(d) || right(d) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (func(Data) bool, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOp("&&") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(d Data) bool { return l(d) && right(d) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (func(Data) bool, error) {
+	switch {
+	case p.peekOp("!"):
+		p.pos++
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(d Data) bool { return !inner(d) }, nil
+	case p.peekOp("("):
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peekOp(")") {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (func(Data) bool, error) {
+	name, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if name.typ != filterTokenWord {
+		return nil, fmt.Errorf("expected field name, got %q", name.val)
+	}
+	f := p.ds.GetField(name.val)
+	if f == nil {
+		return nil, fmt.Errorf("field %q not found in data source %q", name.val, p.ds.Name())
+	}
+
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if op.typ == filterTokenWord && op.val == "in" {
+		value, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if value.typ == filterTokenOp {
+			return nil, fmt.Errorf("expected CIDR, got %q", value.val)
+		}
+		return compileCIDRMatch(f, value.val)
+	}
+	if op.typ != filterTokenOp {
+		return nil, fmt.Errorf("expected operator after %q, got %q", name.val, op.val)
+	}
+	switch op.val {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return nil, fmt.Errorf("expected operator after %q, got %q", name.val, op.val)
+	}
+
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if value.typ == filterTokenOp {
+		return nil, fmt.Errorf("expected value, got %q", value.val)
+	}
+
+	match, err := compileComparison(f, op.val, value.val)
+	if err != nil {
+		return nil, fmt.Errorf("comparing %q: %w", name.val, err)
+	}
+	return match, nil
+}
+
+// compare converts the result of a three-way comparison into the result of op
+func compare(op string, res int) bool {
+	switch op {
+	case "==":
+		return res == 0
+	case "!=":
+		return res != 0
+	case "<":
+		return res < 0
+	case "<=":
+		return res <= 0
+	case ">":
+		return res > 0
+	default:
+		return res >= 0
+	}
+}
+
+func compileComparison(f FieldAccessor, op, value string) (func(Data) bool, error) {
+	switch f.Type() {
+	case api.Kind_Bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bool %q", value)
+		}
+		if op != "==" && op != "!=" {
+			return nil, fmt.Errorf("operator %q not supported for bool", op)
+		}
+		return func(d Data) bool { return (f.Bool(d) == v) == (op == "==") }, nil
+	case api.Kind_Int8, api.Kind_Int16, api.Kind_Int32, api.Kind_Int64:
+		v, err := strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", value)
+		}
+		get := map[api.Kind]func(Data) int64{
+			api.Kind_Int8:  func(d Data) int64 { return int64(f.Int8(d)) },
+			api.Kind_Int16: func(d Data) int64 { return int64(f.Int16(d)) },
+			api.Kind_Int32: func(d Data) int64 { return int64(f.Int32(d)) },
+			api.Kind_Int64: f.Int64,
+		}[f.Type()]
+		return func(d Data) bool { return compare(op, cmp.Compare(get(d), v)) }, nil
+	case api.Kind_Uint8, api.Kind_Uint16, api.Kind_Uint32, api.Kind_Uint64:
+		v, err := strconv.ParseUint(value, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unsigned integer %q", value)
+		}
+		get := map[api.Kind]func(Data) uint64{
+			api.Kind_Uint8:  func(d Data) uint64 { return uint64(f.Uint8(d)) },
+			api.Kind_Uint16: func(d Data) uint64 { return uint64(f.Uint16(d)) },
+			api.Kind_Uint32: func(d Data) uint64 { return uint64(f.Uint32(d)) },
+			api.Kind_Uint64: f.Uint64,
+		}[f.Type()]
+		return func(d Data) bool { return compare(op, cmp.Compare(get(d), v)) }, nil
+	case api.Kind_Float32, api.Kind_Float64:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", value)
+		}
+		get := func(d Data) float64 { return float64(f.Float32(d)) }
+		if f.Type() == api.Kind_Float64 {
+			get = f.Float64
+		}
+		return func(d Data) bool { return compare(op, cmp.Compare(get(d), v)) }, nil
+	case api.Kind_String:
+		return func(d Data) bool { return compare(op, cmp.Compare(f.StringUnsafe(d), value)) }, nil
+	case api.Kind_CString:
+		return func(d Data) bool { return compare(op, cmp.Compare(f.CStringUnsafe(d), value)) }, nil
+	}
+	return nil, fmt.Errorf("unsupported field type %s", f.Type())
+}
+
+// compileCIDRMatch returns a matcher checking whether the IP in f is part of cidr; f can either hold a textual
+// representation of the IP or its 4 or 16 raw bytes
+func compileCIDRMatch(f FieldAccessor, cidr string) (func(Data) bool, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", cidr)
+	}
+	switch f.Type() {
+	case api.Kind_String:
+		return func(d Data) bool {
+			ip := net.ParseIP(f.StringUnsafe(d))
+			return ip != nil && network.Contains(ip)
+		}, nil
+	case api.Kind_CString:
+		return func(d Data) bool {
+			ip := net.ParseIP(f.CStringUnsafe(d))
+			return ip != nil && network.Contains(ip)
+		}, nil
+	case api.Kind_Invalid:
+		if f.Size() != net.IPv4len && f.Size() != net.IPv6len {
+			break
+		}
+		return func(d Data) bool {
+			ip := f.Get(d)
+			return len(ip) == int(f.Size()) && network.Contains(net.IP(ip))
+		}, nil
+	}
+	return nil, fmt.Errorf("field %q can't hold an IP address", f.Name())
+}
@@ -246,7 +246,8 @@ func (f *Formatter) addSubFields(accessors []datasource.FieldAccessor, prefix st
 			}
 		case api.Kind_String:
 			fn = func(e *encodeState, data datasource.Data) {
-				writeString(e, string(accessor.Get(data)))
+				// The encoder copies the value, so there's no need to copy it before
+				writeString(e, accessor.StringUnsafe(data))
 			}
 		case api.Kind_Bool:
 			fn = func(e *encodeState, data datasource.Data) {
@@ -261,7 +262,7 @@ func (f *Formatter) addSubFields(accessors []datasource.FieldAccessor, prefix st
 			}
 		default:
 			fn = func(e *encodeState, data datasource.Data) {
-				writeString(e, accessor.CString(data))
+				writeString(e, accessor.CStringUnsafe(data))
 			}
 		}
 		fns = append(fns, func(e *encodeState, data datasource.Data) {
@@ -0,0 +1,50 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datasource
+
+import (
+	"errors"
+)
+
+// DecodeFunc computes the value of a lazily decoded field from other fields of data. It is called at most once per
+// Data, the first time the field is read, and must not keep references to data.
+type DecodeFunc func(ds DataSource, data Data) []byte
+
+type lazyField struct {
+	// idx is the position of the field in data.decoded
+	idx          int
+	payloadIndex uint32
+	decoder      DecodeFunc
+}
+
+func (a *fieldAccessor) SetDecoder(decoder DecodeFunc) error {
+	if FieldFlagEmpty.In(a.f.Flags) || FieldFlagStaticMember.In(a.f.Flags) || FieldFlagContainer.In(a.f.Flags) {
+		return errors.New("decoders are only supported for fields with their own payload")
+	}
+
+	a.ds.lock.Lock()
+	defer a.ds.lock.Unlock()
+
+	if lf, ok := a.ds.lazyFields[a.f.Index]; ok {
+		lf.decoder = decoder
+		return nil
+	}
+	a.ds.lazyFields[a.f.Index] = &lazyField{
+		idx:          len(a.ds.lazyFields),
+		payloadIndex: a.f.PayloadIndex,
+		decoder:      decoder,
+	}
+	return nil
+}
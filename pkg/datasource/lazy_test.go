@@ -0,0 +1,103 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datasource
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+type staticField struct {
+	name string
+	size uint32
+	offs uint32
+}
+
+func (f staticField) FieldName() string   { return f.name }
+func (f staticField) FieldSize() uint32   { return f.size }
+func (f staticField) FieldOffset() uint32 { return f.offs }
+
+// newLazyDataSource returns a DataSource with a static field "addr" and the fields "name" and "hidden" that are
+// decoded from it, along with the container of the static field
+func newLazyDataSource(t *testing.T, calls *int) (DataSource, FieldAccessor, FieldAccessor, FieldAccessor, FieldAccessor) {
+	ds := New(TypeEvent, "test")
+	container, err := ds.AddStaticFields(4, []StaticField{staticField{name: "addr", size: 4}})
+	require.NoError(t, err)
+	addr := ds.GetField("addr")
+
+	decoder := func(ds DataSource, data Data) []byte {
+		*calls++
+		// Reading the static field panics if its payload is missing
+		return []byte(fmt.Sprintf("host-%d", addr.Get(data)[0]))
+	}
+	name, err := ds.AddField("name", WithKind(api.Kind_String))
+	require.NoError(t, err)
+	require.NoError(t, name.SetDecoder(decoder))
+	hidden, err := ds.AddField("hidden", WithKind(api.Kind_String), WithFlags(FieldFlagHidden))
+	require.NoError(t, err)
+	require.NoError(t, hidden.SetDecoder(decoder))
+	return ds, container, addr, name, hidden
+}
+
+func TestLazyFieldsOnNewData(t *testing.T) {
+	calls := 0
+	ds, container, addr, name, hidden := newLazyDataSource(t, &calls)
+
+	// Neither accessing the payload of fresh Data nor setting static fields runs decoders
+	data := ds.NewData()
+	require.NotNil(t, data.Raw())
+	require.NoError(t, container.Set(data, make([]byte, 4)))
+	require.NoError(t, addr.Set(data, []byte{0, 0, 0, 0}))
+	require.Zero(t, calls)
+
+	// Unmarshaling into fresh Data doesn't either, and values decoded by the remote are kept
+	payload, err := proto.Marshal(&api.GadgetData{Payload: [][]byte{{7, 0, 0, 0}, []byte("remote"), nil}})
+	require.NoError(t, err)
+	data = ds.NewData()
+	require.NoError(t, proto.Unmarshal(payload, data.Raw()))
+	require.Zero(t, calls)
+	require.Equal(t, []byte{7, 0, 0, 0}, addr.Get(data))
+	require.Equal(t, "remote", name.String(data))
+	require.Zero(t, calls)
+
+	// Fields the remote didn't send are decoded locally
+	require.Equal(t, "host-7", hidden.String(data))
+	require.Equal(t, 1, calls)
+}
+
+func TestSerializable(t *testing.T) {
+	calls := 0
+	ds, container, addr, name, hidden := newLazyDataSource(t, &calls)
+
+	data := ds.NewData()
+	require.NoError(t, container.Set(data, make([]byte, 4)))
+	require.NoError(t, addr.Set(data, []byte{1, 0, 0, 0}))
+
+	// All fields, including hidden ones, are decoded before serialization
+	gd := Serializable(data)
+	require.Equal(t, 2, calls)
+	require.Equal(t, []byte("host-1"), gd.Payload[name.(*fieldAccessor).f.PayloadIndex])
+	require.Equal(t, []byte("host-1"), gd.Payload[hidden.(*fieldAccessor).f.PayloadIndex])
+
+	// Fields are decoded only once
+	Serializable(data)
+	require.Equal(t, "host-1", hidden.String(data))
+	require.Equal(t, 2, calls)
+}
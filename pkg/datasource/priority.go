@@ -0,0 +1,95 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datasource
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// AnnotationPriority is the DataSource annotation that sets its PriorityClass
+const AnnotationPriority = "priority"
+
+// PriorityClass decides which DataSources are shed first when a gadget is overloaded; DataSources without an
+// explicit priority class use PriorityNormal.
+type PriorityClass int32
+
+const (
+	PriorityDebug PriorityClass = iota
+	PriorityLow
+	PriorityNormal
+	PriorityHigh
+	PriorityCritical
+)
+
+var priorityClassNames = map[PriorityClass]string{
+	PriorityDebug:    "debug",
+	PriorityLow:      "low",
+	PriorityNormal:   "normal",
+	PriorityHigh:     "high",
+	PriorityCritical: "critical",
+}
+
+func (p PriorityClass) String() string {
+	if name, ok := priorityClassNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("PriorityClass(%d)", p)
+}
+
+// ParsePriorityClass returns the PriorityClass with the given name
+func ParsePriorityClass(name string) (PriorityClass, error) {
+	for p, n := range priorityClassNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return PriorityNormal, fmt.Errorf("invalid priority class %q", name)
+}
+
+// Shedder is shared between the DataSources of a gadget instance. Consumers that can't keep up (e.g. a slow remote
+// client) raise its threshold; DataSources with a lower priority class then drop their data instead of handing it
+// to their subscribers. Data of PriorityCritical DataSources is never shed.
+type Shedder struct {
+	threshold atomic.Int32
+	shed      atomic.Uint64
+}
+
+func NewShedder() *Shedder {
+	return &Shedder{}
+}
+
+// SetThreshold sets the lowest PriorityClass that is still emitted; use PriorityDebug to stop shedding
+func (s *Shedder) SetThreshold(p PriorityClass) {
+	s.threshold.Store(int32(min(p, PriorityCritical)))
+}
+
+// Threshold returns the lowest PriorityClass that is currently emitted
+func (s *Shedder) Threshold() PriorityClass {
+	return PriorityClass(s.threshold.Load())
+}
+
+// Shed returns the number of Data that have been dropped by shedding
+func (s *Shedder) Shed() uint64 {
+	return s.shed.Load()
+}
+
+func (s *Shedder) shouldShed(p PriorityClass) bool {
+	if p >= PriorityClass(s.threshold.Load()) {
+		return false
+	}
+	s.shed.Add(1)
+	return true
+}
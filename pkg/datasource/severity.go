@@ -0,0 +1,157 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datasource
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+const (
+	// AnnotationSeverity is the DataSource annotation that sets the Severity of all its Data
+	AnnotationSeverity = "severity"
+
+	// AnnotationSeverityField marks the field holding the Severity of each Data when set to "true"; it takes
+	// precedence over AnnotationSeverity unless it is empty. String fields hold the name of the Severity, integer
+	// fields its value.
+	AnnotationSeverityField = "severity.field"
+)
+
+// Severity classifies Data so that consumers like exporters can handle it uniformly across gadgets, e.g. to only
+// forward Data of at least SeverityMedium
+type Severity int32
+
+const (
+	SeverityUnknown Severity = iota
+	SeverityDebug
+	SeverityInfo
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+var severityNames = map[Severity]string{
+	SeverityUnknown:  "unknown",
+	SeverityDebug:    "debug",
+	SeverityInfo:     "info",
+	SeverityLow:      "low",
+	SeverityMedium:   "medium",
+	SeverityHigh:     "high",
+	SeverityCritical: "critical",
+}
+
+func (s Severity) String() string {
+	if name, ok := severityNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Severity(%d)", s)
+}
+
+// ParseSeverity returns the Severity with the given name; names are case-insensitive
+func ParseSeverity(name string) (Severity, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for s, n := range severityNames {
+		if n == name {
+			return s, nil
+		}
+	}
+	return SeverityUnknown, fmt.Errorf("invalid severity %q", name)
+}
+
+// SeverityReader returns the Severity of Data of a DataSource as set by its severity annotations
+type SeverityReader struct {
+	field           FieldAccessor
+	defaultSeverity Severity
+}
+
+// NewSeverityReader creates a SeverityReader for ds; it fails if the severity annotations of ds are invalid
+func NewSeverityReader(ds DataSource) (*SeverityReader, error) {
+	r := &SeverityReader{}
+	if name, ok := ds.Annotations()[AnnotationSeverity]; ok {
+		s, err := ParseSeverity(name)
+		if err != nil {
+			return nil, err
+		}
+		r.defaultSeverity = s
+	}
+	for _, f := range ds.Accessors(false) {
+		if f.Annotations()[AnnotationSeverityField] != "true" {
+			continue
+		}
+		if r.field != nil {
+			return nil, fmt.Errorf("fields %q and %q are both annotated with %s", r.field.Name(), f.Name(),
+				AnnotationSeverityField)
+		}
+		switch f.Type() {
+		case api.Kind_String, api.Kind_CString,
+			api.Kind_Int8, api.Kind_Int16, api.Kind_Int32, api.Kind_Int64,
+			api.Kind_Uint8, api.Kind_Uint16, api.Kind_Uint32, api.Kind_Uint64:
+		default:
+			return nil, fmt.Errorf("severity field %q has unsupported type %s", f.Name(), f.Type())
+		}
+		r.field = f
+	}
+	return r, nil
+}
+
+// HasSeverity returns whether the DataSource sets a Severity at all
+func (r *SeverityReader) HasSeverity() bool {
+	return r.field != nil || r.defaultSeverity != SeverityUnknown
+}
+
+func (r *SeverityReader) fieldSeverity(data Data) Severity {
+	var value int64
+	switch r.field.Type() {
+	case api.Kind_String:
+		s, _ := ParseSeverity(r.field.StringUnsafe(data))
+		return s
+	case api.Kind_CString:
+		s, _ := ParseSeverity(r.field.CStringUnsafe(data))
+		return s
+	case api.Kind_Int8:
+		value = int64(r.field.Int8(data))
+	case api.Kind_Int16:
+		value = int64(r.field.Int16(data))
+	case api.Kind_Int32:
+		value = int64(r.field.Int32(data))
+	case api.Kind_Int64:
+		value = r.field.Int64(data)
+	case api.Kind_Uint8:
+		value = int64(r.field.Uint8(data))
+	case api.Kind_Uint16:
+		value = int64(r.field.Uint16(data))
+	case api.Kind_Uint32:
+		value = int64(r.field.Uint32(data))
+	case api.Kind_Uint64:
+		value = int64(min(r.field.Uint64(data), uint64(SeverityCritical)+1))
+	}
+	if value < int64(SeverityUnknown) || value > int64(SeverityCritical) {
+		return SeverityUnknown
+	}
+	return Severity(value)
+}
+
+// Severity returns the Severity of data; SeverityUnknown if neither the severity field nor the DataSource set one
+func (r *SeverityReader) Severity(data Data) Severity {
+	if r.field != nil {
+		if s := r.fieldSeverity(data); s != SeverityUnknown {
+			return s
+		}
+	}
+	return r.defaultSeverity
+}
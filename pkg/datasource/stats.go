@@ -0,0 +1,133 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datasource
+
+import (
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reasons for losing data, see ReportLostDataReason
+const (
+	// LostReasonBufferFull is used when the buffer data is read from, like a perf buffer, was full
+	LostReasonBufferFull = "buffer_full"
+	// LostReasonRateLimit is used when data was dropped to stay below a configured rate
+	LostReasonRateLimit = "rate_limit"
+)
+
+// Stats are the counters a DataSource keeps about the Data emitted on it since it was created
+type Stats struct {
+	// Emitted is the number of Data handed to the subscribers
+	Emitted uint64
+	// Discarded is the number of Data a subscriber returned ErrDiscard for, e.g. because of a filter
+	Discarded uint64
+	// Shed is the number of Data dropped because the priority class of the DataSource was shed
+	Shed uint64
+	// Lost is the number of Data the creator of the DataSource reported as lost, e.g. because a buffer was full
+	Lost uint64
+	// LostByReason holds the part of Lost that was reported with a reason, by reason
+	LostByReason map[string]uint64
+
+	// Subscribers holds the statistics of the subscribers, in the order they are called
+	Subscribers []SubscriberStats
+}
+
+// SubscriberStats are the counters of a subscriber of a DataSource. The time spent in the subscriber is only measured
+// after MeasureSubscribers has been called on the DataSource.
+type SubscriberStats struct {
+	Priority int
+	// Calls is the number of Data the subscriber was called with while being measured
+	Calls uint64
+	// Time is the total time spent in the subscriber
+	Time time.Duration
+	// MaxTime is the longest time the subscriber took for a single Data
+	MaxTime time.Duration
+}
+
+type dataSourceStats struct {
+	emitted   atomic.Uint64
+	discarded atomic.Uint64
+	shed      atomic.Uint64
+	lost      atomic.Uint64
+
+	// measure enables measuring the time spent in subscribers, which has a cost on every emitted Data
+	measure atomic.Bool
+
+	lostMu       sync.Mutex
+	lostByReason map[string]uint64
+}
+
+func (s *dataSourceStats) addLost(reason string, ctr uint64) {
+	s.lostMu.Lock()
+	defer s.lostMu.Unlock()
+	if s.lostByReason == nil {
+		s.lostByReason = make(map[string]uint64)
+	}
+	s.lostByReason[reason] += ctr
+}
+
+func (s *dataSourceStats) lostReasons() map[string]uint64 {
+	s.lostMu.Lock()
+	defer s.lostMu.Unlock()
+	return maps.Clone(s.lostByReason)
+}
+
+type subscriptionStats struct {
+	calls   atomic.Uint64
+	time    atomic.Int64
+	maxTime atomic.Int64
+}
+
+// record records that the subscriber took d to handle n Data; the time per Data is used as maximum for batches
+func (s *subscriptionStats) record(d time.Duration, n int) {
+	s.calls.Add(uint64(n))
+	s.time.Add(int64(d))
+	perData := int64(d) / int64(n)
+	for {
+		maxTime := s.maxTime.Load()
+		if perData <= maxTime || s.maxTime.CompareAndSwap(maxTime, perData) {
+			return
+		}
+	}
+}
+
+func (ds *dataSource) MeasureSubscribers() {
+	ds.stats.measure.Store(true)
+}
+
+func (ds *dataSource) Stats() Stats {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	s := Stats{
+		Emitted:      ds.stats.emitted.Load(),
+		Discarded:    ds.stats.discarded.Load(),
+		Shed:         ds.stats.shed.Load(),
+		Lost:         ds.stats.lost.Load(),
+		LostByReason: ds.stats.lostReasons(),
+		Subscribers:  make([]SubscriberStats, 0, len(ds.subscriptions)),
+	}
+	for _, sub := range ds.subscriptions {
+		s.Subscribers = append(s.Subscribers, SubscriberStats{
+			Priority: sub.priority,
+			Calls:    sub.stats.calls.Load(),
+			Time:     time.Duration(sub.stats.time.Load()),
+			MaxTime:  time.Duration(sub.stats.maxTime.Load()),
+		})
+	}
+	return s
+}
@@ -16,5 +16,8 @@ package datasource
 
 type subscription struct {
 	priority int
-	fn       DataFunc
+	// Either fn or batchFn is set
+	fn      DataFunc
+	batchFn BatchFunc
+	stats   subscriptionStats
 }
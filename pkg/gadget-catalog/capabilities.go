@@ -0,0 +1,33 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetcatalog
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/capabilities"
+)
+
+// capabilitiesFromProgram returns the capabilities needed to run the programs of the given eBPF object
+func capabilitiesFromProgram(program []byte) ([]string, error) {
+	spec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(program))
+	if err != nil {
+		return nil, fmt.Errorf("parsing eBPF program: %w", err)
+	}
+	return capabilities.Names(capabilities.Requirements(spec)), nil
+}
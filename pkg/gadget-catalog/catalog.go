@@ -0,0 +1,169 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gadgetcatalog maintains a local list of published gadget images, including what data they provide and
+// what they need to run. The list is stored in a file, so it can be searched without access to the registries, for
+// example in air-gapped environments.
+package gadgetcatalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const DefaultCatalogFile = "/var/lib/ig/catalog.json"
+
+// Field describes a field of a data source
+type Field struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// DataSource describes a data source of a gadget
+type DataSource struct {
+	Name   string   `json:"name"`
+	Fields []*Field `json:"fields,omitempty"`
+}
+
+// Gadget describes a gadget image
+type Gadget struct {
+	Name             string `json:"name" column:"name"`
+	Image            string `json:"image" column:"image,width:50"`
+	Description      string `json:"description,omitempty" column:"description,width:50"`
+	Version          string `json:"version,omitempty"`
+	Official         bool   `json:"official" column:"official,width:8,fixed"`
+	Verified         bool   `json:"verified" column:"verified,width:8,fixed"`
+	DocumentationURL string `json:"documentationURL,omitempty"`
+
+	DataSources []*DataSource `json:"dataSources,omitempty"`
+
+	// Capabilities are the Linux capabilities needed to load and attach the eBPF programs of the gadget
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// Catalog is a list of gadget images
+type Catalog struct {
+	Updated time.Time `json:"updated"`
+	Gadgets []*Gadget `json:"gadgets"`
+}
+
+// Load reads the catalog from path
+func Load(path string) (*Catalog, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("catalog %q not found, synchronize it first", path)
+		}
+		return nil, fmt.Errorf("reading catalog: %w", err)
+	}
+	catalog := &Catalog{}
+	if err := json.Unmarshal(b, catalog); err != nil {
+		return nil, fmt.Errorf("decoding catalog %q: %w", path, err)
+	}
+	return catalog, nil
+}
+
+// Save writes the catalog to path, replacing an existing catalog only after it has been written completely
+func (c *Catalog) Save(path string) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding catalog: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("creating directory for catalog: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("creating catalog: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing catalog: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing catalog: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("writing catalog: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (g *Gadget) matches(keyword string) bool {
+	contains := func(s string) bool {
+		return strings.Contains(strings.ToLower(s), keyword)
+	}
+	if contains(g.Name) || contains(g.Image) || contains(g.Description) {
+		return true
+	}
+	for _, ds := range g.DataSources {
+		if contains(ds.Name) {
+			return true
+		}
+		for _, f := range ds.Fields {
+			if contains(f.Name) || contains(f.Description) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Search returns the gadgets whose name, image, description, data sources or fields contain all of the given
+// keywords, ignoring case. All gadgets are returned if no keyword is given.
+func (c *Catalog) Search(keywords ...string) []*Gadget {
+	res := make([]*Gadget, 0)
+gadgets:
+	for _, g := range c.Gadgets {
+		for _, keyword := range keywords {
+			if !g.matches(strings.ToLower(keyword)) {
+				continue gadgets
+			}
+		}
+		res = append(res, g)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// Get returns the gadget with the given name or image
+func (c *Catalog) Get(nameOrImage string) (*Gadget, bool) {
+	for _, g := range c.Gadgets {
+		if g.Name == nameOrImage || g.Image == nameOrImage {
+			return g, true
+		}
+	}
+	// Allow omitting the tag
+	for _, g := range c.Gadgets {
+		if trimTag(g.Image) == nameOrImage {
+			return g, true
+		}
+	}
+	return nil, false
+}
+
+func trimTag(image string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i]
+	}
+	return image
+}
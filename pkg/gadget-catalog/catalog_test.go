@@ -0,0 +1,169 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetcatalog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/oci"
+)
+
+func testCatalog() *Catalog {
+	return &Catalog{
+		Gadgets: []*Gadget{
+			{
+				Name:        "trace_open",
+				Image:       "ghcr.io/inspektor-gadget/gadget/trace_open:latest",
+				Description: "trace open files",
+				DataSources: []*DataSource{{Name: "open", Fields: []*Field{{Name: "fname", Description: "file name"}}}},
+			},
+			{
+				Name:        "trace_dns",
+				Image:       "ghcr.io/inspektor-gadget/gadget/trace_dns:latest",
+				Description: "trace DNS requests",
+				DataSources: []*DataSource{{Name: "dns", Fields: []*Field{{Name: "qr"}}}},
+			},
+		},
+	}
+}
+
+func TestSearch(t *testing.T) {
+	c := testCatalog()
+
+	names := func(gadgets []*Gadget) []string {
+		res := []string{}
+		for _, g := range gadgets {
+			res = append(res, g.Name)
+		}
+		return res
+	}
+	require.Equal(t, []string{"trace_dns", "trace_open"}, names(c.Search()))
+	require.Equal(t, []string{"trace_dns"}, names(c.Search("dns")))
+	require.Equal(t, []string{"trace_open"}, names(c.Search("FILE NAME")))
+	require.Equal(t, []string{"trace_open"}, names(c.Search("trace", "fname")))
+	require.Empty(t, c.Search("tcp"))
+
+	for _, name := range []string{"trace_open", "ghcr.io/inspektor-gadget/gadget/trace_open", "ghcr.io/inspektor-gadget/gadget/trace_open:latest"} {
+		g, ok := c.Get(name)
+		require.True(t, ok, name)
+		require.Equal(t, "trace_open", g.Name)
+	}
+	_, ok := c.Get("trace_tcp")
+	require.False(t, ok)
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dir", "catalog.json")
+
+	_, err := Load(path)
+	require.Error(t, err)
+
+	c := testCatalog()
+	require.NoError(t, c.Save(path))
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	require.Equal(t, c, loaded)
+}
+
+func TestCapabilitiesFromProgram(t *testing.T) {
+	program, err := os.ReadFile("../../testdata/validate_metadata_sched_cls.o")
+	require.NoError(t, err)
+	caps, err := capabilitiesFromProgram(program)
+	require.NoError(t, err)
+	require.Equal(t, []string{"CAP_BPF", "CAP_NET_ADMIN"}, caps)
+
+	_, err = capabilitiesFromProgram([]byte("not an ELF"))
+	require.Error(t, err)
+}
+
+func TestSync(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/packages/search", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "gadgets", r.URL.Query().Get("repo"))
+		w.Header().Set("Pagination-Total-Count", "2")
+		json.NewEncoder(w).Encode(map[string]any{
+			"packages": []map[string]any{
+				{"name": "trace open", "normalized_name": "trace_open"},
+				{"name": "trace dns", "normalized_name": "trace_dns"},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/packages/inspektor-gadget/gadgets/", func(w http.ResponseWriter, r *http.Request) {
+		name := filepath.Base(r.URL.Path)
+		json.NewEncoder(w).Encode(map[string]any{
+			"name":              name,
+			"normalized_name":   name,
+			"description":       name + " description",
+			"version":           "0.27.0",
+			"repository":        map[string]any{"name": "gadgets", "official": true, "verified_publisher": true},
+			"containers_images": []map[string]any{{"name": "gadget", "image": "ghcr.io/inspektor-gadget/gadget/" + name + ":latest"}},
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	program, err := os.ReadFile("../../testdata/validate_metadata_sched_cls.o")
+	require.NoError(t, err)
+
+	oldFetch := fetchImageContent
+	defer func() { fetchImageContent = oldFetch }()
+	fetchImageContent = func(ctx context.Context, image string, authOpts *oci.AuthOptions) (*oci.GadgetImageContent, error) {
+		if image != "ghcr.io/inspektor-gadget/gadget/trace_open:latest" {
+			return nil, errors.New("not found")
+		}
+		return &oci.GadgetImageContent{
+			Metadata: []byte(`
+name: trace open
+tracers:
+  open:
+    mapName: events
+    structName: event
+structs:
+  event:
+    fields:
+    - name: fname
+      description: file name
+`),
+			EBPFProgram: program,
+		}, nil
+	}
+
+	c, err := Sync(context.Background(), SyncOptions{ArtifactHubURL: server.URL})
+	require.NoError(t, err)
+	require.Len(t, c.Gadgets, 2)
+
+	open, ok := c.Get("trace_open")
+	require.True(t, ok)
+	require.Equal(t, "trace_open description", open.Description)
+	require.Equal(t, "0.27.0", open.Version)
+	require.True(t, open.Official)
+	require.True(t, open.Verified)
+	require.Equal(t, []*DataSource{{Name: "open", Fields: []*Field{{Name: "fname", Description: "file name"}}}}, open.DataSources)
+	require.Equal(t, []string{"CAP_BPF", "CAP_NET_ADMIN"}, open.Capabilities)
+
+	// Gadgets whose images can't be inspected are kept
+	dns, ok := c.Get("trace_dns")
+	require.True(t, ok)
+	require.Empty(t, dns.DataSources)
+}
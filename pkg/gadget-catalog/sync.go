@@ -0,0 +1,254 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetcatalog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	metadatav1 "github.com/inspektor-gadget/inspektor-gadget/pkg/metadata/v1"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/oci"
+)
+
+const (
+	DefaultArtifactHubURL = "https://artifacthub.io"
+
+	// DefaultRepository is the Artifact Hub repository of the official gadgets
+	DefaultRepository = "gadgets"
+
+	// artifactHubKind is the name Artifact Hub uses for packages of Inspektor Gadget
+	artifactHubKind = "inspektor-gadget"
+
+	artifactHubPageSize = 60
+)
+
+type SyncOptions struct {
+	// ArtifactHubURL is the base URL of the Artifact Hub instance to get the list of gadgets from
+	ArtifactHubURL string
+	// Repositories are the Artifact Hub repositories to include
+	Repositories []string
+	// AuthOptions are used to fetch the metadata from the registries of the gadget images
+	AuthOptions oci.AuthOptions
+}
+
+// fetchImageContent can be replaced in tests
+var fetchImageContent = oci.FetchGadgetImageContent
+
+type artifactHubRepository struct {
+	Name              string `json:"name"`
+	Official          bool   `json:"official"`
+	VerifiedPublisher bool   `json:"verified_publisher"`
+}
+
+type artifactHubPackage struct {
+	Name             string                `json:"name"`
+	NormalizedName   string                `json:"normalized_name"`
+	Description      string                `json:"description"`
+	Version          string                `json:"version"`
+	Official         bool                  `json:"official"`
+	Repository       artifactHubRepository `json:"repository"`
+	ContainersImages []struct {
+		Name  string `json:"name"`
+		Image string `json:"image"`
+	} `json:"containers_images"`
+	Links []struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	} `json:"links"`
+}
+
+type artifactHubClient struct {
+	client  *http.Client
+	baseURL string
+}
+
+func (c *artifactHubClient) get(ctx context.Context, path string, query url.Values, v any) (http.Header, error) {
+	u := c.baseURL + "/api/v1" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("requesting %q: unexpected status %q", u, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return nil, fmt.Errorf("decoding response of %q: %w", u, err)
+	}
+	return resp.Header, nil
+}
+
+// listPackages returns all packages of the given repository
+func (c *artifactHubClient) listPackages(ctx context.Context, repository string) ([]*artifactHubPackage, error) {
+	var res []*artifactHubPackage
+	for offset := 0; ; offset += artifactHubPageSize {
+		var page struct {
+			Packages []*artifactHubPackage `json:"packages"`
+		}
+		header, err := c.get(ctx, "/packages/search", url.Values{
+			"repo":   {repository},
+			"limit":  {strconv.Itoa(artifactHubPageSize)},
+			"offset": {strconv.Itoa(offset)},
+		}, &page)
+		if err != nil {
+			return nil, fmt.Errorf("searching packages: %w", err)
+		}
+		res = append(res, page.Packages...)
+
+		total, err := strconv.Atoi(header.Get("Pagination-Total-Count"))
+		if err != nil || len(page.Packages) == 0 || len(res) >= total {
+			return res, nil
+		}
+	}
+}
+
+// getPackage returns the details of a package, including its images
+func (c *artifactHubClient) getPackage(ctx context.Context, repository, name string) (*artifactHubPackage, error) {
+	pkg := &artifactHubPackage{}
+	_, err := c.get(ctx, "/packages/"+artifactHubKind+"/"+url.PathEscape(repository)+"/"+url.PathEscape(name), nil, pkg)
+	if err != nil {
+		return nil, fmt.Errorf("getting package %q: %w", name, err)
+	}
+	return pkg, nil
+}
+
+// dataSourcesFromMetadata returns the data sources and their fields as declared in the metadata of a gadget
+func dataSourcesFromMetadata(metadata *metadatav1.GadgetMetadata) []*DataSource {
+	structs := map[string]string{}
+	for name, t := range metadata.Tracers {
+		structs[name] = t.StructName
+	}
+	for name, t := range metadata.Toppers {
+		structs[name] = t.StructName
+	}
+	for name, s := range metadata.Snapshotters {
+		structs[name] = s.StructName
+	}
+
+	res := make([]*DataSource, 0, len(structs))
+	for name, structName := range structs {
+		ds := &DataSource{Name: name}
+		for _, f := range metadata.Structs[structName].Fields {
+			ds.Fields = append(ds.Fields, &Field{Name: f.Name, Description: f.Description})
+		}
+		res = append(res, ds)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// describeImage adds the information only available in the image itself to g
+func describeImage(ctx context.Context, g *Gadget, authOpts *oci.AuthOptions) error {
+	content, err := fetchImageContent(ctx, g.Image, authOpts)
+	if err != nil {
+		return fmt.Errorf("fetching image: %w", err)
+	}
+
+	metadata := &metadatav1.GadgetMetadata{}
+	if err := yaml.Unmarshal(content.Metadata, metadata); err != nil {
+		return fmt.Errorf("decoding metadata: %w", err)
+	}
+	if g.Description == "" {
+		g.Description = metadata.Description
+	}
+	if g.DocumentationURL == "" {
+		g.DocumentationURL = metadata.DocumentationURL
+	}
+	g.DataSources = dataSourcesFromMetadata(metadata)
+
+	if len(content.EBPFProgram) > 0 {
+		g.Capabilities, err = capabilitiesFromProgram(content.EBPFProgram)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync creates a catalog of the gadgets published in the given Artifact Hub repositories. Gadgets whose images
+// can't be inspected are still added, but without information about their data sources and capabilities.
+func Sync(ctx context.Context, opts SyncOptions) (*Catalog, error) {
+	client := &artifactHubClient{
+		client:  &http.Client{Timeout: 30 * time.Second},
+		baseURL: opts.ArtifactHubURL,
+	}
+	if client.baseURL == "" {
+		client.baseURL = DefaultArtifactHubURL
+	}
+	repositories := opts.Repositories
+	if len(repositories) == 0 {
+		repositories = []string{DefaultRepository}
+	}
+
+	catalog := &Catalog{Updated: time.Now().UTC()}
+	for _, repository := range repositories {
+		packages, err := client.listPackages(ctx, repository)
+		if err != nil {
+			return nil, fmt.Errorf("listing gadgets of repository %q: %w", repository, err)
+		}
+
+		for _, p := range packages {
+			pkg, err := client.getPackage(ctx, repository, p.NormalizedName)
+			if err != nil {
+				return nil, err
+			}
+			if len(pkg.ContainersImages) == 0 {
+				log.Warnf("skipping gadget %q: no image given", pkg.Name)
+				continue
+			}
+
+			g := &Gadget{
+				Name:        pkg.NormalizedName,
+				Image:       pkg.ContainersImages[0].Image,
+				Description: pkg.Description,
+				Version:     pkg.Version,
+				Official:    pkg.Official || pkg.Repository.Official,
+				Verified:    pkg.Repository.VerifiedPublisher,
+			}
+			for _, l := range pkg.Links {
+				if l.Name == "documentation" {
+					g.DocumentationURL = l.URL
+				}
+			}
+
+			if err := describeImage(ctx, g, &opts.AuthOptions); err != nil {
+				log.Warnf("inspecting image %q: %v", g.Image, err)
+			}
+			catalog.Gadgets = append(catalog.Gadgets, g)
+		}
+	}
+	return catalog, nil
+}
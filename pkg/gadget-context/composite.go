@@ -0,0 +1,206 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetcontext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/runtime"
+)
+
+// NewComposite returns a GadgetContext that runs multiple gadget images at once. The DataSources of all images are
+// added to it, annotated with datasource.AnnotationImage, and its own DataOperators (like the CLI) run only once for
+// all of them, so the images share a single output pipeline and timeout. The operators of image-based gadgets keep
+// their state (like eBPF objects) in the context they're running in, so every image is still handled by the runtime
+// in a context of its own using imageOperators.
+func NewComposite(
+	ctx context.Context,
+	imageNames []string,
+	imageOperators []operators.DataOperator,
+	options ...Option,
+) *GadgetContext {
+	c := New(ctx, strings.Join(imageNames, ","), options...)
+	c.images = slices.Clone(imageNames)
+	c.imageOperators = slices.Clone(imageOperators)
+	return c
+}
+
+// Images returns the images run by a composite GadgetContext
+func (c *GadgetContext) Images() []string {
+	return slices.Clone(c.images)
+}
+
+func (c *GadgetContext) newImageContext(imageName string) *GadgetContext {
+	return New(
+		c.ctx,
+		imageName,
+		WithLogger(c.logger),
+		WithDataOperators(c.imageOperators...),
+		WithTimeout(c.timeout),
+	)
+}
+
+// addImageDataSources adds the DataSources of imageCtx to the composite context c
+func (c *GadgetContext) addImageDataSources(imageCtx *GadgetContext) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for name, ds := range imageCtx.GetDataSources() {
+		shared := ds.Annotations()[datasource.AnnotationShared] == "true"
+		if other, ok := c.dataSources[name]; ok {
+			if !shared {
+				return fmt.Errorf("data source %q of %q is also provided by %q", name, imageCtx.ImageName(),
+					other.Annotations()[datasource.AnnotationImage])
+			}
+			// Let the image emit to the DataSource the DataOperators of c are using
+			imageCtx.lock.Lock()
+			imageCtx.dataSources[name] = other
+			imageCtx.lock.Unlock()
+			continue
+		}
+		if !shared {
+			ds.AddAnnotation(datasource.AnnotationImage, imageCtx.ImageName())
+		}
+		c.dataSources[name] = ds
+	}
+	return nil
+}
+
+// PrepareImages fetches the gadget information of every image of a composite GadgetContext from rt and prepares
+// the DataOperators of c for the DataSources of all of them. It returns the gadget information of the images in the
+// order they were given; the params of the DataOperators of c are available using Params().
+func (c *GadgetContext) PrepareImages(
+	rt runtime.Runtime,
+	runtimeParams *params.Params,
+	paramValues api.ParamValues,
+) ([]*api.GadgetInfo, error) {
+	infos := make([]*api.GadgetInfo, 0, len(c.images))
+	for _, imageName := range c.images {
+		imageCtx := c.newImageContext(imageName)
+		info, err := rt.GetGadgetInfo(imageCtx, runtimeParams, paramValues)
+		if err != nil {
+			return nil, fmt.Errorf("fetching gadget information of %q: %w", imageName, err)
+		}
+		err = c.addImageDataSources(imageCtx)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	err := c.PrepareGadgetInfo(paramValues)
+	if err != nil {
+		return nil, fmt.Errorf("initializing and preparing operators: %w", err)
+	}
+	return infos, nil
+}
+
+// RunImages runs every image of a composite GadgetContext using rt, handing over imageParamValues[i] to the i-th
+// image, and the DataOperators of c using paramValues. The DataOperators of c are started once all images
+// registered their DataSources, and before any of the images is started. If one of the images fails, the other
+// ones are stopped as well. RunImages returns once all images are done.
+func (c *GadgetContext) RunImages(
+	rt runtime.Runtime,
+	runtimeParams *params.Params,
+	paramValues api.ParamValues,
+	imageParamValues []api.ParamValues,
+) error {
+	defer c.cancel()
+
+	if len(imageParamValues) != len(c.images) {
+		return fmt.Errorf("got param values for %d images, expected %d", len(imageParamValues), len(c.images))
+	}
+
+	ready := make(chan *GadgetContext, len(c.images))
+	started := make(chan struct{})
+	done := make(chan struct{})
+
+	errs := make([]error, len(c.images)+1)
+	var wg sync.WaitGroup
+	for i, imageName := range c.images {
+		imageCtx := c.newImageContext(imageName)
+		var isReady atomic.Bool
+		imageCtx.waitForStart = func() bool {
+			isReady.Store(true)
+			ready <- imageCtx
+			select {
+			case <-started:
+				return true
+			case <-imageCtx.Context().Done():
+				return false
+			}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := rt.RunGadget(imageCtx, runtimeParams, imageParamValues[i])
+			if err != nil {
+				errs[i] = fmt.Errorf("running %q: %w", imageName, err)
+			}
+			if err != nil || !isReady.Load() {
+				// Stop the other images as well; they'd wait for this one to get ready otherwise
+				c.cancel()
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Wait for the DataSources of all images
+	for pending := len(c.images); pending > 0; pending-- {
+		select {
+		case imageCtx := <-ready:
+			err := c.addImageDataSources(imageCtx)
+			if err != nil {
+				c.cancel()
+				<-done
+				errs[len(c.images)] = err
+				return errors.Join(errs...)
+			}
+		case <-done:
+			return errors.Join(errs...)
+		}
+	}
+
+	dataOperatorInstances, err := c.initAndPrepareOperators(paramValues)
+	if err == nil {
+		err = c.startOperators(dataOperatorInstances)
+	}
+	if err != nil {
+		c.cancel()
+		<-done
+		errs[len(c.images)] = err
+		return errors.Join(errs...)
+	}
+
+	close(started)
+	c.Logger().Debugf("running...")
+	<-done
+
+	c.stopOperators(dataOperatorInstances)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,238 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetcontext
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/simple"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/runtime"
+)
+
+// testRuntime runs gadgets in-process, like the local runtime
+type testRuntime struct {
+	runtime.Runtime
+}
+
+func (r *testRuntime) GetGadgetInfo(gadgetCtx runtime.GadgetContext, _ *params.Params, paramValues api.ParamValues) (*api.GadgetInfo, error) {
+	err := gadgetCtx.PrepareGadgetInfo(paramValues)
+	if err != nil {
+		return nil, err
+	}
+	return gadgetCtx.SerializeGadgetInfo()
+}
+
+func (r *testRuntime) RunGadget(gadgetCtx runtime.GadgetContext, _ *params.Params, paramValues api.ParamValues) error {
+	return gadgetCtx.Run(paramValues)
+}
+
+// testImageOperator registers a DataSource named like the image (without tag) that emits the image name once
+// started; images named "fail" fail to start
+func testImageOperator() operators.DataOperator {
+	dsName := func(gadgetCtx operators.GadgetContext) string {
+		return strings.Split(gadgetCtx.ImageName(), ":")[0]
+	}
+	return simple.New("image",
+		simple.OnInit(func(gadgetCtx operators.GadgetContext) error {
+			ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, dsName(gadgetCtx))
+			if err != nil {
+				return err
+			}
+			_, err = ds.AddField("value", datasource.WithKind(api.Kind_String))
+			return err
+		}),
+		simple.OnStart(func(gadgetCtx operators.GadgetContext) error {
+			if gadgetCtx.ImageName() == "fail" {
+				return errors.New("failing")
+			}
+			ds := gadgetCtx.GetDataSources()[dsName(gadgetCtx)]
+			value := ds.GetField("value")
+			data := ds.NewData()
+			err := value.Set(data, []byte(gadgetCtx.ImageName()))
+			if err != nil {
+				return err
+			}
+			return ds.EmitAndRelease(data)
+		}),
+		simple.OnStop(func(gadgetCtx operators.GadgetContext) error {
+			return nil
+		}),
+	)
+}
+
+type testEvent struct {
+	image string
+	value string
+}
+
+// testOutputOperator collects the events of all DataSources of the composite context
+func testOutputOperator(events *[]testEvent, mu *sync.Mutex) operators.DataOperator {
+	return simple.New("output",
+		simple.OnStart(func(gadgetCtx operators.GadgetContext) error {
+			for _, ds := range gadgetCtx.GetDataSources() {
+				value := ds.GetField("value")
+				ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+					mu.Lock()
+					defer mu.Unlock()
+					*events = append(*events, testEvent{ds.Annotations()[datasource.AnnotationImage], value.String(data)})
+					return nil
+				}, 0)
+			}
+			return nil
+		}),
+		simple.OnStop(func(gadgetCtx operators.GadgetContext) error {
+			return nil
+		}),
+	)
+}
+
+func TestCompositeRunImages(t *testing.T) {
+	var events []testEvent
+	var mu sync.Mutex
+
+	images := []string{"exec:latest", "dns:latest"}
+	gadgetCtx := NewComposite(context.Background(), images,
+		[]operators.DataOperator{testImageOperator()},
+		WithDataOperators(testOutputOperator(&events, &mu)),
+		WithTimeout(100*time.Millisecond),
+	)
+	require.Equal(t, images, gadgetCtx.Images())
+
+	err := gadgetCtx.RunImages(&testRuntime{}, nil, nil, []api.ParamValues{{}, {}})
+	require.NoError(t, err)
+
+	// Both images were started only after the output subscribed to their DataSources
+	require.ElementsMatch(t, []testEvent{
+		{"exec:latest", "exec:latest"},
+		{"dns:latest", "dns:latest"},
+	}, events)
+
+	dataSources := gadgetCtx.GetDataSources()
+	require.Len(t, dataSources, 2)
+	require.Equal(t, "dns:latest", dataSources["dns"].Annotations()[datasource.AnnotationImage])
+}
+
+func TestCompositeRunImagesFailure(t *testing.T) {
+	var events []testEvent
+	var mu sync.Mutex
+
+	// Without timeout, the other image only stops because the failing one stops it
+	gadgetCtx := NewComposite(context.Background(), []string{"exec", "fail"},
+		[]operators.DataOperator{testImageOperator()},
+		WithDataOperators(testOutputOperator(&events, &mu)),
+	)
+
+	err := gadgetCtx.RunImages(&testRuntime{}, nil, nil, []api.ParamValues{{}, {}})
+	require.ErrorContains(t, err, `running "fail"`)
+	require.ErrorContains(t, err, "failing")
+	require.Error(t, gadgetCtx.Context().Err())
+}
+
+func TestCompositeDataSourceConflict(t *testing.T) {
+	var events []testEvent
+	var mu sync.Mutex
+
+	gadgetCtx := NewComposite(context.Background(), []string{"exec:v1", "exec:v2"},
+		[]operators.DataOperator{testImageOperator()},
+		WithDataOperators(testOutputOperator(&events, &mu)),
+	)
+
+	err := gadgetCtx.RunImages(&testRuntime{}, nil, nil, []api.ParamValues{{}, {}})
+	require.ErrorContains(t, err, `data source "exec"`)
+	require.Empty(t, events)
+
+	err = gadgetCtx.RunImages(&testRuntime{}, nil, nil, []api.ParamValues{{}})
+	require.ErrorContains(t, err, "got param values for 1 images, expected 2")
+}
+
+func TestCompositePrepareImages(t *testing.T) {
+	var events []testEvent
+	var mu sync.Mutex
+
+	gadgetCtx := NewComposite(context.Background(), []string{"exec", "dns"},
+		[]operators.DataOperator{testImageOperator()},
+		WithDataOperators(testOutputOperator(&events, &mu)),
+	)
+
+	infos, err := gadgetCtx.PrepareImages(&testRuntime{}, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	require.Equal(t, "exec", infos[0].ImageName)
+	require.Equal(t, "exec", infos[0].DataSources[0].Name)
+	require.Equal(t, "dns", infos[1].ImageName)
+
+	dataSources := gadgetCtx.GetDataSources()
+	require.Len(t, dataSources, 2)
+	require.Equal(t, "exec", dataSources["exec"].Annotations()[datasource.AnnotationImage])
+}
+
+func TestCompositeSharedDataSource(t *testing.T) {
+	var events []testEvent
+	var mu sync.Mutex
+
+	// sharedOperator registers the same DataSource for every image, like a runtime does
+	sharedOperator := simple.New("shared",
+		simple.OnInit(func(gadgetCtx operators.GadgetContext) error {
+			ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "shared")
+			if err != nil {
+				return err
+			}
+			ds.AddAnnotation(datasource.AnnotationShared, "true")
+			_, err = ds.AddField("value", datasource.WithKind(api.Kind_String))
+			return err
+		}),
+		simple.OnStart(func(gadgetCtx operators.GadgetContext) error {
+			ds := gadgetCtx.GetDataSources()["shared"]
+			data := ds.NewData()
+			err := ds.GetField("value").Set(data, []byte(gadgetCtx.ImageName()))
+			if err != nil {
+				return err
+			}
+			return ds.EmitAndRelease(data)
+		}),
+		simple.OnStop(func(gadgetCtx operators.GadgetContext) error {
+			return nil
+		}),
+	)
+
+	gadgetCtx := NewComposite(context.Background(), []string{"exec", "dns"},
+		[]operators.DataOperator{testImageOperator(), sharedOperator},
+		WithDataOperators(testOutputOperator(&events, &mu)),
+		WithTimeout(100*time.Millisecond),
+	)
+
+	err := gadgetCtx.RunImages(&testRuntime{}, nil, nil, []api.ParamValues{{}, {}})
+	require.NoError(t, err)
+
+	// Both images emitted to the single shared DataSource, which isn't annotated with an image
+	require.ElementsMatch(t, []testEvent{
+		{"exec", "exec"},
+		{"dns", "dns"},
+		{"", "exec"},
+		{"", "dns"},
+	}, events)
+	require.Len(t, gadgetCtx.GetDataSources(), 3)
+}
@@ -65,6 +65,15 @@ type GadgetContext struct {
 	loaded           bool
 	imageName        string
 	metadata         []byte
+	shedder          *datasource.Shedder
+
+	// images and imageOperators are only set for composite contexts, see NewComposite
+	images         []string
+	imageOperators []operators.DataOperator
+
+	// waitForStart is set for the contexts running the images of a composite context; it blocks until the
+	// composite context subscribed to the DataSources and returns false if the gadget was stopped in the meantime
+	waitForStart func() bool
 }
 
 func NewBuiltIn(
@@ -99,6 +108,7 @@ func NewBuiltIn(
 
 		dataSources: make(map[string]datasource.DataSource),
 		vars:        make(map[string]any),
+		shedder:     datasource.NewShedder(),
 	}
 }
 
@@ -117,6 +127,7 @@ func New(
 		imageName:   imageName,
 		dataSources: make(map[string]datasource.DataSource),
 		vars:        make(map[string]any),
+		shedder:     datasource.NewShedder(),
 		// dataOperators: operators.GetDataOperators(),
 	}
 	for _, option := range options {
@@ -188,11 +199,17 @@ func (c *GadgetContext) DataOperators() []operators.DataOperator {
 func (c *GadgetContext) RegisterDataSource(t datasource.Type, name string) (datasource.DataSource, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	ds := datasource.New(t, name)
+	ds := datasource.New(t, name, datasource.WithShedder(c.shedder))
 	c.dataSources[name] = ds
 	return ds, nil
 }
 
+// Shedder returns the Shedder shared by all DataSources of the gadget; raising its threshold makes DataSources with
+// lower priority classes drop their data
+func (c *GadgetContext) Shedder() *datasource.Shedder {
+	return c.shedder
+}
+
 func (c *GadgetContext) GetDataSources() map[string]datasource.DataSource {
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -261,7 +278,7 @@ func (c *GadgetContext) LoadGadgetInfo(info *api.GadgetInfo, paramValues api.Par
 
 	c.dataSources = make(map[string]datasource.DataSource)
 	for _, inds := range info.DataSources {
-		ds, err := datasource.NewFromAPI(inds)
+		ds, err := datasource.NewFromAPI(inds, datasource.WithShedder(c.shedder))
 		if err != nil {
 			c.lock.Unlock()
 			return fmt.Errorf("creating DataSource from API: %w", err)
@@ -281,6 +298,9 @@ func (c *GadgetContext) LoadGadgetInfo(info *api.GadgetInfo, paramValues api.Par
 	}
 
 	if run {
+		if c.waitForStart != nil && !c.waitForStart() {
+			return nil
+		}
 		go c.run(localOperators)
 	}
 
@@ -92,6 +92,27 @@ func (c *GadgetContext) initAndPrepareOperators(paramValues api.ParamValues) ([]
 }
 
 func (c *GadgetContext) run(dataOperatorInstances []operators.DataOperatorInstance) error {
+	err := c.startOperators(dataOperatorInstances)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Context()
+	if c.timeout > 0 {
+		newContext, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+		ctx = newContext
+	}
+
+	c.Logger().Debugf("running...")
+
+	<-ctx.Done()
+
+	c.stopOperators(dataOperatorInstances)
+	return nil
+}
+
+func (c *GadgetContext) startOperators(dataOperatorInstances []operators.DataOperatorInstance) error {
 	log := c.Logger()
 
 	for _, opInst := range dataOperatorInstances {
@@ -107,13 +128,6 @@ func (c *GadgetContext) run(dataOperatorInstances []operators.DataOperatorInstan
 		}
 	}
 
-	ctx := c.Context()
-	if c.timeout > 0 {
-		newContext, cancel := context.WithTimeout(ctx, c.timeout)
-		defer cancel()
-		ctx = newContext
-	}
-
 	for _, opInst := range dataOperatorInstances {
 		log.Debugf("starting op %q", opInst.Name())
 		err := opInst.Start(c)
@@ -122,10 +136,11 @@ func (c *GadgetContext) run(dataOperatorInstances []operators.DataOperatorInstan
 			return fmt.Errorf("starting operator %q: %w", opInst.Name(), err)
 		}
 	}
+	return nil
+}
 
-	log.Debugf("running...")
-
-	<-ctx.Done()
+func (c *GadgetContext) stopOperators(dataOperatorInstances []operators.DataOperatorInstance) {
+	log := c.Logger()
 
 	// Stop/DeInit in reverse order
 	for i := len(dataOperatorInstances) - 1; i >= 0; i-- {
@@ -150,7 +165,6 @@ func (c *GadgetContext) run(dataOperatorInstances []operators.DataOperatorInstan
 			log.Errorf("post-stopping operator %q: %v", opInst.Name(), err)
 		}
 	}
-	return nil
 }
 
 func (c *GadgetContext) PrepareGadgetInfo(paramValues api.ParamValues) error {
@@ -164,5 +178,8 @@ func (c *GadgetContext) Run(paramValues api.ParamValues) error {
 		c.cancel()
 		return fmt.Errorf("initializing and preparing operators: %w", err)
 	}
+	if c.waitForStart != nil && !c.waitForStart() {
+		return nil
+	}
 	return c.run(dataOperatorInstances)
 }
@@ -175,6 +175,10 @@ var BuiltInGadgetManager_ServiceDesc = grpc.ServiceDesc{
 type GadgetManagerClient interface {
 	GetGadgetInfo(ctx context.Context, in *GetGadgetInfoRequest, opts ...grpc.CallOption) (*GetGadgetInfoResponse, error)
 	RunGadget(ctx context.Context, opts ...grpc.CallOption) (GadgetManager_RunGadgetClient, error)
+	ListGadgetInstances(ctx context.Context, in *ListGadgetInstancesRequest, opts ...grpc.CallOption) (*ListGadgetInstancesResponse, error)
+	AttachToGadgetInstance(ctx context.Context, in *AttachToGadgetInstanceRequest, opts ...grpc.CallOption) (GadgetManager_AttachToGadgetInstanceClient, error)
+	GetGadgetInstance(ctx context.Context, in *GetGadgetInstanceRequest, opts ...grpc.CallOption) (*GadgetInstance, error)
+	DeleteGadgetInstance(ctx context.Context, in *DeleteGadgetInstanceRequest, opts ...grpc.CallOption) (*DeleteGadgetInstanceResponse, error)
 }
 
 type gadgetManagerClient struct {
@@ -225,12 +229,75 @@ func (x *gadgetManagerRunGadgetClient) Recv() (*GadgetEvent, error) {
 	return m, nil
 }
 
+func (c *gadgetManagerClient) ListGadgetInstances(ctx context.Context, in *ListGadgetInstancesRequest, opts ...grpc.CallOption) (*ListGadgetInstancesResponse, error) {
+	out := new(ListGadgetInstancesResponse)
+	err := c.cc.Invoke(ctx, "/api.GadgetManager/ListGadgetInstances", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gadgetManagerClient) AttachToGadgetInstance(ctx context.Context, in *AttachToGadgetInstanceRequest, opts ...grpc.CallOption) (GadgetManager_AttachToGadgetInstanceClient, error) {
+	stream, err := c.cc.NewStream(ctx, &GadgetManager_ServiceDesc.Streams[1], "/api.GadgetManager/AttachToGadgetInstance", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &gadgetManagerAttachToGadgetInstanceClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type GadgetManager_AttachToGadgetInstanceClient interface {
+	Recv() (*GadgetEvent, error)
+	grpc.ClientStream
+}
+
+type gadgetManagerAttachToGadgetInstanceClient struct {
+	grpc.ClientStream
+}
+
+func (x *gadgetManagerAttachToGadgetInstanceClient) Recv() (*GadgetEvent, error) {
+	m := new(GadgetEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *gadgetManagerClient) GetGadgetInstance(ctx context.Context, in *GetGadgetInstanceRequest, opts ...grpc.CallOption) (*GadgetInstance, error) {
+	out := new(GadgetInstance)
+	err := c.cc.Invoke(ctx, "/api.GadgetManager/GetGadgetInstance", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gadgetManagerClient) DeleteGadgetInstance(ctx context.Context, in *DeleteGadgetInstanceRequest, opts ...grpc.CallOption) (*DeleteGadgetInstanceResponse, error) {
+	out := new(DeleteGadgetInstanceResponse)
+	err := c.cc.Invoke(ctx, "/api.GadgetManager/DeleteGadgetInstance", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // GadgetManagerServer is the server API for GadgetManager service.
 // All implementations must embed UnimplementedGadgetManagerServer
 // for forward compatibility
 type GadgetManagerServer interface {
 	GetGadgetInfo(context.Context, *GetGadgetInfoRequest) (*GetGadgetInfoResponse, error)
 	RunGadget(GadgetManager_RunGadgetServer) error
+	ListGadgetInstances(context.Context, *ListGadgetInstancesRequest) (*ListGadgetInstancesResponse, error)
+	AttachToGadgetInstance(*AttachToGadgetInstanceRequest, GadgetManager_AttachToGadgetInstanceServer) error
+	GetGadgetInstance(context.Context, *GetGadgetInstanceRequest) (*GadgetInstance, error)
+	DeleteGadgetInstance(context.Context, *DeleteGadgetInstanceRequest) (*DeleteGadgetInstanceResponse, error)
 	mustEmbedUnimplementedGadgetManagerServer()
 }
 
@@ -244,6 +311,18 @@ func (UnimplementedGadgetManagerServer) GetGadgetInfo(context.Context, *GetGadge
 func (UnimplementedGadgetManagerServer) RunGadget(GadgetManager_RunGadgetServer) error {
 	return status.Errorf(codes.Unimplemented, "method RunGadget not implemented")
 }
+func (UnimplementedGadgetManagerServer) ListGadgetInstances(context.Context, *ListGadgetInstancesRequest) (*ListGadgetInstancesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListGadgetInstances not implemented")
+}
+func (UnimplementedGadgetManagerServer) AttachToGadgetInstance(*AttachToGadgetInstanceRequest, GadgetManager_AttachToGadgetInstanceServer) error {
+	return status.Errorf(codes.Unimplemented, "method AttachToGadgetInstance not implemented")
+}
+func (UnimplementedGadgetManagerServer) GetGadgetInstance(context.Context, *GetGadgetInstanceRequest) (*GadgetInstance, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetGadgetInstance not implemented")
+}
+func (UnimplementedGadgetManagerServer) DeleteGadgetInstance(context.Context, *DeleteGadgetInstanceRequest) (*DeleteGadgetInstanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteGadgetInstance not implemented")
+}
 func (UnimplementedGadgetManagerServer) mustEmbedUnimplementedGadgetManagerServer() {}
 
 // UnsafeGadgetManagerServer may be embedded to opt out of forward compatibility for this service.
@@ -301,6 +380,81 @@ func (x *gadgetManagerRunGadgetServer) Recv() (*GadgetControlRequest, error) {
 	return m, nil
 }
 
+func _GadgetManager_ListGadgetInstances_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListGadgetInstancesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GadgetManagerServer).ListGadgetInstances(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.GadgetManager/ListGadgetInstances",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GadgetManagerServer).ListGadgetInstances(ctx, req.(*ListGadgetInstancesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GadgetManager_AttachToGadgetInstance_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(AttachToGadgetInstanceRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GadgetManagerServer).AttachToGadgetInstance(m, &gadgetManagerAttachToGadgetInstanceServer{stream})
+}
+
+type GadgetManager_AttachToGadgetInstanceServer interface {
+	Send(*GadgetEvent) error
+	grpc.ServerStream
+}
+
+type gadgetManagerAttachToGadgetInstanceServer struct {
+	grpc.ServerStream
+}
+
+func (x *gadgetManagerAttachToGadgetInstanceServer) Send(m *GadgetEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _GadgetManager_GetGadgetInstance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetGadgetInstanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GadgetManagerServer).GetGadgetInstance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.GadgetManager/GetGadgetInstance",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GadgetManagerServer).GetGadgetInstance(ctx, req.(*GetGadgetInstanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GadgetManager_DeleteGadgetInstance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteGadgetInstanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GadgetManagerServer).DeleteGadgetInstance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.GadgetManager/DeleteGadgetInstance",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GadgetManagerServer).DeleteGadgetInstance(ctx, req.(*DeleteGadgetInstanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // GadgetManager_ServiceDesc is the grpc.ServiceDesc for GadgetManager service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -312,6 +466,18 @@ var GadgetManager_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetGadgetInfo",
 			Handler:    _GadgetManager_GetGadgetInfo_Handler,
 		},
+		{
+			MethodName: "ListGadgetInstances",
+			Handler:    _GadgetManager_ListGadgetInstances_Handler,
+		},
+		{
+			MethodName: "GetGadgetInstance",
+			Handler:    _GadgetManager_GetGadgetInstance_Handler,
+		},
+		{
+			MethodName: "DeleteGadgetInstance",
+			Handler:    _GadgetManager_DeleteGadgetInstance_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -320,6 +486,183 @@ var GadgetManager_ServiceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "AttachToGadgetInstance",
+			Handler:       _GadgetManager_AttachToGadgetInstance_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api/api.proto",
+}
+
+// ConfigManagerClient is the client API for ConfigManager service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ConfigManagerClient interface {
+	ReloadConfig(ctx context.Context, in *ReloadConfigRequest, opts ...grpc.CallOption) (*ReloadConfigResponse, error)
+}
+
+type configManagerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewConfigManagerClient(cc grpc.ClientConnInterface) ConfigManagerClient {
+	return &configManagerClient{cc}
+}
+
+func (c *configManagerClient) ReloadConfig(ctx context.Context, in *ReloadConfigRequest, opts ...grpc.CallOption) (*ReloadConfigResponse, error) {
+	out := new(ReloadConfigResponse)
+	err := c.cc.Invoke(ctx, "/api.ConfigManager/ReloadConfig", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ConfigManagerServer is the server API for ConfigManager service.
+// All implementations must embed UnimplementedConfigManagerServer
+// for forward compatibility
+type ConfigManagerServer interface {
+	ReloadConfig(context.Context, *ReloadConfigRequest) (*ReloadConfigResponse, error)
+	mustEmbedUnimplementedConfigManagerServer()
+}
+
+// UnimplementedConfigManagerServer must be embedded to have forward compatible implementations.
+type UnimplementedConfigManagerServer struct {
+}
+
+func (UnimplementedConfigManagerServer) ReloadConfig(context.Context, *ReloadConfigRequest) (*ReloadConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReloadConfig not implemented")
+}
+func (UnimplementedConfigManagerServer) mustEmbedUnimplementedConfigManagerServer() {}
+
+// UnsafeConfigManagerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ConfigManagerServer will
+// result in compilation errors.
+type UnsafeConfigManagerServer interface {
+	mustEmbedUnimplementedConfigManagerServer()
+}
+
+func RegisterConfigManagerServer(s grpc.ServiceRegistrar, srv ConfigManagerServer) {
+	s.RegisterService(&ConfigManager_ServiceDesc, srv)
+}
+
+func _ConfigManager_ReloadConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReloadConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConfigManagerServer).ReloadConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.ConfigManager/ReloadConfig",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConfigManagerServer).ReloadConfig(ctx, req.(*ReloadConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ConfigManager_ServiceDesc is the grpc.ServiceDesc for ConfigManager service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ConfigManager_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "api.ConfigManager",
+	HandlerType: (*ConfigManagerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ReloadConfig",
+			Handler:    _ConfigManager_ReloadConfig_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "api/api.proto",
+}
+
+// AuditManagerClient is the client API for AuditManager service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AuditManagerClient interface {
+	QueryAuditLog(ctx context.Context, in *QueryAuditLogRequest, opts ...grpc.CallOption) (*QueryAuditLogResponse, error)
+}
+
+type auditManagerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAuditManagerClient(cc grpc.ClientConnInterface) AuditManagerClient {
+	return &auditManagerClient{cc}
+}
+
+func (c *auditManagerClient) QueryAuditLog(ctx context.Context, in *QueryAuditLogRequest, opts ...grpc.CallOption) (*QueryAuditLogResponse, error) {
+	out := new(QueryAuditLogResponse)
+	err := c.cc.Invoke(ctx, "/api.AuditManager/QueryAuditLog", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AuditManagerServer is the server API for AuditManager service.
+// All implementations must embed UnimplementedAuditManagerServer
+// for forward compatibility
+type AuditManagerServer interface {
+	QueryAuditLog(context.Context, *QueryAuditLogRequest) (*QueryAuditLogResponse, error)
+	mustEmbedUnimplementedAuditManagerServer()
+}
+
+// UnimplementedAuditManagerServer must be embedded to have forward compatible implementations.
+type UnimplementedAuditManagerServer struct {
+}
+
+func (UnimplementedAuditManagerServer) QueryAuditLog(context.Context, *QueryAuditLogRequest) (*QueryAuditLogResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryAuditLog not implemented")
+}
+func (UnimplementedAuditManagerServer) mustEmbedUnimplementedAuditManagerServer() {}
+
+// UnsafeAuditManagerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AuditManagerServer will
+// result in compilation errors.
+type UnsafeAuditManagerServer interface {
+	mustEmbedUnimplementedAuditManagerServer()
+}
+
+func RegisterAuditManagerServer(s grpc.ServiceRegistrar, srv AuditManagerServer) {
+	s.RegisterService(&AuditManager_ServiceDesc, srv)
+}
+
+func _AuditManager_QueryAuditLog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryAuditLogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuditManagerServer).QueryAuditLog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.AuditManager/QueryAuditLog",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuditManagerServer).QueryAuditLog(ctx, req.(*QueryAuditLogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// AuditManager_ServiceDesc is the grpc.ServiceDesc for AuditManager service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AuditManager_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "api.AuditManager",
+	HandlerType: (*AuditManagerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "QueryAuditLog",
+			Handler:    _AuditManager_QueryAuditLog_Handler,
+		},
 	},
+	Streams:  []grpc.StreamDesc{},
 	Metadata: "api/api.proto",
 }
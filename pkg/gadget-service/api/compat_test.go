@@ -0,0 +1,163 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// The golden file describes all elements of the API that third-party clients might rely on. Elements must never be
+// removed, renamed or renumbered; mark them as deprecated instead. After making compatible changes (e.g. adding
+// fields), update the golden file with
+//
+//	go test ./pkg/gadget-service/api/... -update
+const goldenFile = "testdata/api.golden"
+
+var update = flag.Bool("update", false, "update the golden API description")
+
+const deprecatedSuffix = " deprecated"
+
+func isDeprecated(d protoreflect.Descriptor) bool {
+	switch opts := d.Options().(type) {
+	case *descriptorpb.MessageOptions:
+		return opts.GetDeprecated()
+	case *descriptorpb.FieldOptions:
+		return opts.GetDeprecated()
+	case *descriptorpb.EnumOptions:
+		return opts.GetDeprecated()
+	case *descriptorpb.EnumValueOptions:
+		return opts.GetDeprecated()
+	case *descriptorpb.ServiceOptions:
+		return opts.GetDeprecated()
+	case *descriptorpb.MethodOptions:
+		return opts.GetDeprecated()
+	}
+	return false
+}
+
+func line(d protoreflect.Descriptor, s string) string {
+	if isDeprecated(d) {
+		return s + deprecatedSuffix
+	}
+	return s
+}
+
+func fieldType(f protoreflect.FieldDescriptor) string {
+	if f.IsMap() {
+		return fmt.Sprintf("map<%s, %s>", fieldType(f.MapKey()), fieldType(f.MapValue()))
+	}
+	switch f.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return string(f.Message().FullName())
+	case protoreflect.EnumKind:
+		return string(f.Enum().FullName())
+	}
+	return f.Kind().String()
+}
+
+func describeEnum(e protoreflect.EnumDescriptor) []string {
+	res := []string{line(e, fmt.Sprintf("enum %s", e.FullName()))}
+	for i := 0; i < e.Values().Len(); i++ {
+		v := e.Values().Get(i)
+		res = append(res, line(v, fmt.Sprintf("value %s = %d", v.FullName(), v.Number())))
+	}
+	return res
+}
+
+func describeMessage(m protoreflect.MessageDescriptor) []string {
+	if m.IsMapEntry() {
+		return nil
+	}
+	res := []string{line(m, fmt.Sprintf("message %s", m.FullName()))}
+	for i := 0; i < m.Fields().Len(); i++ {
+		f := m.Fields().Get(i)
+		oneof := ""
+		if o := f.ContainingOneof(); o != nil {
+			oneof = " oneof " + string(o.Name())
+		}
+		cardinality := f.Cardinality().String() + " "
+		if f.IsMap() {
+			cardinality = ""
+		}
+		res = append(res, line(f, fmt.Sprintf("field %s = %d %s%s%s", f.FullName(), f.Number(), cardinality, fieldType(f), oneof)))
+	}
+	for i := 0; i < m.Enums().Len(); i++ {
+		res = append(res, describeEnum(m.Enums().Get(i))...)
+	}
+	for i := 0; i < m.Messages().Len(); i++ {
+		res = append(res, describeMessage(m.Messages().Get(i))...)
+	}
+	return res
+}
+
+func describeFile(fd protoreflect.FileDescriptor) []string {
+	var res []string
+	for i := 0; i < fd.Enums().Len(); i++ {
+		res = append(res, describeEnum(fd.Enums().Get(i))...)
+	}
+	for i := 0; i < fd.Messages().Len(); i++ {
+		res = append(res, describeMessage(fd.Messages().Get(i))...)
+	}
+	for i := 0; i < fd.Services().Len(); i++ {
+		s := fd.Services().Get(i)
+		res = append(res, line(s, fmt.Sprintf("service %s", s.FullName())))
+		for j := 0; j < s.Methods().Len(); j++ {
+			m := s.Methods().Get(j)
+			in, out := string(m.Input().FullName()), string(m.Output().FullName())
+			if m.IsStreamingClient() {
+				in = "stream " + in
+			}
+			if m.IsStreamingServer() {
+				out = "stream " + out
+			}
+			res = append(res, line(m, fmt.Sprintf("rpc %s(%s) returns (%s)", m.FullName(), in, out)))
+		}
+	}
+	slices.Sort(res)
+	return res
+}
+
+func TestAPICompatibility(t *testing.T) {
+	current := describeFile(File_api_api_proto)
+
+	if *update {
+		require.NoError(t, os.WriteFile(goldenFile, []byte(strings.Join(current, "\n")+"\n"), 0o644))
+		return
+	}
+
+	content, err := os.ReadFile(goldenFile)
+	require.NoError(t, err)
+	golden := strings.Split(strings.TrimSpace(string(content)), "\n")
+
+	stripped := make(map[string]struct{}, len(current))
+	for _, l := range current {
+		stripped[strings.TrimSuffix(l, deprecatedSuffix)] = struct{}{}
+	}
+	for _, l := range golden {
+		_, ok := stripped[strings.TrimSuffix(l, deprecatedSuffix)]
+		require.True(t, ok, "incompatible API change: %q was removed or changed", l)
+	}
+
+	require.Equal(t, golden, current, "API changed in a compatible way; update the golden file using -update")
+}
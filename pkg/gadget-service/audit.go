@@ -0,0 +1,425 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/encoding/protojson"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/k8sutil"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/spiffe"
+)
+
+const (
+	AuditActionStart = "start"
+	AuditActionStop  = "stop"
+	AuditActionEnd   = "end"
+
+	// Principals of actions not requested by an authenticated client
+	principalConfig          = "config"
+	principalHandoff         = "handoff"
+	principalUnauthenticated = "unauthenticated"
+
+	// maxAuditRecords is the number of records kept in memory to answer queries if the backend can't be read
+	maxAuditRecords = 4096
+)
+
+// AuditBackend persists the records of the audit log
+type AuditBackend interface {
+	Write(record *api.AuditRecord) error
+	Close() error
+}
+
+// AuditReader is implemented by backends that can be queried; they return all records, oldest first
+type AuditReader interface {
+	Read() ([]*api.AuditRecord, error)
+}
+
+// NewAuditBackend returns the backend described by spec: "file:<path>" appends records as JSON lines to a file and
+// "k8s-events[:<namespace>]" creates Kubernetes events for the node given by the NODE_NAME environment variable
+func NewAuditBackend(spec string) (AuditBackend, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "file":
+		if arg == "" {
+			return nil, errors.New("file audit backend needs a path")
+		}
+		return newFileAuditBackend(arg)
+	case "k8s-events":
+		if arg == "" {
+			arg = metav1.NamespaceDefault
+		}
+		node := os.Getenv("NODE_NAME")
+		if node == "" {
+			return nil, errors.New("k8s-events audit backend needs the NODE_NAME environment variable")
+		}
+		client, err := k8sutil.NewClientset("")
+		if err != nil {
+			return nil, fmt.Errorf("creating Kubernetes client: %w", err)
+		}
+		return newK8sEventsAuditBackend(client, arg, node), nil
+	}
+	return nil, fmt.Errorf("unknown audit backend %q, expected file:<path> or k8s-events[:<namespace>]", spec)
+}
+
+// auditLog records who started and stopped which gadgets; the most recent records are also kept in memory
+type auditLog struct {
+	mu      sync.Mutex
+	backend AuditBackend
+	node    string
+	records []*api.AuditRecord
+}
+
+func newAuditLog() *auditLog {
+	node, _ := os.Hostname()
+	return &auditLog{node: node}
+}
+
+func (l *auditLog) setBackend(backend AuditBackend) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.backend = backend
+}
+
+func (l *auditLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.backend == nil {
+		return nil
+	}
+	err := l.backend.Close()
+	l.backend = nil
+	return err
+}
+
+func (l *auditLog) write(record *api.AuditRecord) error {
+	record.Node = l.node
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.records) == maxAuditRecords {
+		l.records = l.records[1:]
+	}
+	l.records = append(l.records, record)
+	if l.backend == nil {
+		return nil
+	}
+	return l.backend.Write(record)
+}
+
+func (l *auditLog) query(req *api.QueryAuditLogRequest) ([]*api.AuditRecord, error) {
+	l.mu.Lock()
+	records := l.records
+	reader, ok := l.backend.(AuditReader)
+	l.mu.Unlock()
+
+	if ok {
+		var err error
+		records, err = reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("reading audit log: %w", err)
+		}
+	}
+
+	var res []*api.AuditRecord
+	for _, record := range records {
+		if matchAuditRecord(record, req) {
+			res = append(res, record)
+		}
+	}
+	if req.Limit > 0 && len(res) > int(req.Limit) {
+		res = res[len(res)-int(req.Limit):]
+	}
+	return res, nil
+}
+
+func matchAuditRecord(record *api.AuditRecord, req *api.QueryAuditLogRequest) bool {
+	switch {
+	case req.Since != 0 && record.Timestamp < req.Since,
+		req.Until != 0 && record.Timestamp > req.Until,
+		req.Principal != "" && record.Principal != req.Principal,
+		req.ImageName != "" && record.ImageName != req.ImageName,
+		req.InstanceId != "" && record.InstanceId != req.InstanceId:
+		return false
+	}
+	return true
+}
+
+// auditPeer identifies the client requesting an action
+type auditPeer struct {
+	principal string
+	address   string
+}
+
+// grpcPeer returns the client of a gRPC request: TLS clients are identified by their SPIFFE ID, clients connected to
+// a unix socket by their uid
+func grpcPeer(ctx context.Context) auditPeer {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return auditPeer{principal: principalUnauthenticated}
+	}
+	res := auditPeer{principal: principalUnauthenticated}
+	if p.Addr != nil {
+		res.address = p.Addr.String()
+	}
+	if addr, ok := p.Addr.(*peerCredAddr); ok {
+		res.principal = fmt.Sprintf("uid:%d", addr.uid)
+	}
+	if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.PeerCertificates) > 0 {
+		if id, err := spiffe.IDFromCertificate(tlsInfo.State.PeerCertificates[0]); err == nil {
+			res.principal = id.String()
+		}
+	}
+	return res
+}
+
+// httpPeer returns the client of a REST request
+func httpPeer(r *http.Request) auditPeer {
+	res := auditPeer{principal: principalUnauthenticated, address: r.RemoteAddr}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		if id, err := spiffe.IDFromCertificate(r.TLS.PeerCertificates[0]); err == nil {
+			res.principal = id.String()
+		}
+	}
+	return res
+}
+
+// audit records an action on a gadget instance
+func (s *Service) audit(action string, p auditPeer, instance *api.GadgetInstance, err error) {
+	record := &api.AuditRecord{
+		Timestamp:    time.Now().UnixNano(),
+		Action:       action,
+		Principal:    p.principal,
+		Peer:         p.address,
+		InstanceId:   instance.Id,
+		InstanceName: instance.Name,
+		ImageName:    instance.ImageName,
+		ParamValues:  maps.Clone(instance.ParamValues),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if err := s.auditLog.write(record); err != nil {
+		s.logger.Warnf("writing audit record: %v", err)
+	}
+}
+
+// auditRun records the start of a gadget run and returns a function recording its end
+func (s *Service) auditRun(p auditPeer, instance *api.GadgetInstance) func(err error) {
+	s.audit(AuditActionStart, p, instance, nil)
+	return func(err error) {
+		s.audit(AuditActionEnd, p, instance, err)
+	}
+}
+
+func (s *Service) QueryAuditLog(ctx context.Context, req *api.QueryAuditLogRequest) (*api.QueryAuditLogResponse, error) {
+	records, err := s.auditLog.query(req)
+	if err != nil {
+		return nil, err
+	}
+	return &api.QueryAuditLogResponse{Records: records}, nil
+}
+
+// fileAuditBackend appends records as JSON lines to a file
+type fileAuditBackend struct {
+	path string
+	file *os.File
+}
+
+func newFileAuditBackend(path string) (*fileAuditBackend, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+	return &fileAuditBackend{path: path, file: f}, nil
+}
+
+func (b *fileAuditBackend) Write(record *api.AuditRecord) error {
+	line, err := protojson.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = b.file.Write(append(line, '\n'))
+	return err
+}
+
+func (b *fileAuditBackend) Read() ([]*api.AuditRecord, error) {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []*api.AuditRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		record := &api.AuditRecord{}
+		if err := protojson.Unmarshal(scanner.Bytes(), record); err != nil {
+			return nil, fmt.Errorf("parsing %q: %w", b.path, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// Keep the order stable even if the clock jumped back
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Timestamp < records[j].Timestamp
+	})
+	return records, nil
+}
+
+func (b *fileAuditBackend) Close() error {
+	return b.file.Close()
+}
+
+// k8sEventsAuditBackend creates a Kubernetes event on the node for each record
+type k8sEventsAuditBackend struct {
+	client    kubernetes.Interface
+	namespace string
+	node      string
+}
+
+func newK8sEventsAuditBackend(client kubernetes.Interface, namespace, node string) *k8sEventsAuditBackend {
+	return &k8sEventsAuditBackend{client: client, namespace: namespace, node: node}
+}
+
+var auditEventReasons = map[string]string{
+	AuditActionStart: "GadgetStarted",
+	AuditActionStop:  "GadgetStopRequested",
+	AuditActionEnd:   "GadgetEnded",
+}
+
+func (b *k8sEventsAuditBackend) Write(record *api.AuditRecord) error {
+	params := make([]string, 0, len(record.ParamValues))
+	for k, v := range record.ParamValues {
+		params = append(params, k+"="+v)
+	}
+	sort.Strings(params)
+
+	msg := fmt.Sprintf("%s: %s %s (instance %s)", record.Principal, record.Action, record.ImageName, record.InstanceId)
+	if len(params) > 0 {
+		msg += " with " + strings.Join(params, ",")
+	}
+	eventType := corev1.EventTypeNormal
+	if record.Error != "" {
+		eventType = corev1.EventTypeWarning
+		msg += ": " + record.Error
+	}
+
+	ts := metav1.NewTime(time.Unix(0, record.Timestamp))
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "inspektor-gadget-audit-",
+			Namespace:    b.namespace,
+			Labels: map[string]string{
+				"k8s-app": "gadget",
+			},
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Node",
+			Name:       b.node,
+		},
+		Reason:         auditEventReasons[record.Action],
+		Message:        msg,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: "inspektor-gadget", Host: record.Node},
+		FirstTimestamp: ts,
+		LastTimestamp:  ts,
+		Count:          1,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := b.client.CoreV1().Events(b.namespace).Create(ctx, event, metav1.CreateOptions{})
+	return err
+}
+
+func (b *k8sEventsAuditBackend) Close() error {
+	return nil
+}
+
+// peerCredAddr is the address of clients connected to a unix socket, carrying their credentials
+type peerCredAddr struct {
+	uid, gid uint32
+	pid      int32
+}
+
+func (a *peerCredAddr) Network() string {
+	return "unix"
+}
+
+func (a *peerCredAddr) String() string {
+	return fmt.Sprintf("pid:%d", a.pid)
+}
+
+type peerCredConn struct {
+	net.Conn
+	addr *peerCredAddr
+}
+
+func (c *peerCredConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// peerCredListener makes the credentials of clients connecting to a unix socket available as their address, so that
+// gRPC handlers can get them from the peer of the request
+type peerCredListener struct {
+	net.Listener
+}
+
+func (l *peerCredListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return conn, nil
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return conn, nil
+	}
+	var cred *unix.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return conn, nil
+	}
+	return &peerCredConn{Conn: conn, addr: &peerCredAddr{uid: cred.Uid, gid: cred.Gid, pid: cred.Pid}}, nil
+}
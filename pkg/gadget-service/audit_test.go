@@ -0,0 +1,176 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/peer"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+func auditActions(records []*api.AuditRecord) []string {
+	var actions []string
+	for _, record := range records {
+		actions = append(actions, record.Action+" "+record.InstanceId)
+	}
+	return actions
+}
+
+func TestAuditLogQuery(t *testing.T) {
+	s := NewService(log.StandardLogger(), 1)
+	alice := auditPeer{principal: "spiffe://example.org/alice", address: "10.0.0.1:1234"}
+	bob := auditPeer{principal: "uid:1000", address: "pid:42"}
+
+	first := &api.GadgetInstance{Id: "1", ImageName: "trace_exec", ParamValues: api.ParamValues{"operator.oci.ebpf.uid": "0"}}
+	second := &api.GadgetInstance{Id: "2", ImageName: "trace_open"}
+
+	end := s.auditRun(alice, first)
+	s.auditRun(bob, second)(errors.New("failed"))
+	s.audit(AuditActionStop, bob, first, nil)
+	end(nil)
+
+	res, err := s.QueryAuditLog(context.Background(), &api.QueryAuditLogRequest{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"start 1", "start 2", "end 2", "stop 1", "end 1"}, auditActions(res.Records))
+	require.Equal(t, "failed", res.Records[2].Error)
+	require.Equal(t, "0", res.Records[0].ParamValues["operator.oci.ebpf.uid"])
+	require.Equal(t, "10.0.0.1:1234", res.Records[0].Peer)
+
+	res, err = s.QueryAuditLog(context.Background(), &api.QueryAuditLogRequest{Principal: "uid:1000"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"start 2", "end 2", "stop 1"}, auditActions(res.Records))
+
+	res, err = s.QueryAuditLog(context.Background(), &api.QueryAuditLogRequest{ImageName: "trace_exec", Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{"stop 1", "end 1"}, auditActions(res.Records))
+
+	res, err = s.QueryAuditLog(context.Background(), &api.QueryAuditLogRequest{InstanceId: "2"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"start 2", "end 2"}, auditActions(res.Records))
+}
+
+func TestAuditLogKeepsRecentRecords(t *testing.T) {
+	l := newAuditLog()
+	for i := 0; i < maxAuditRecords+10; i++ {
+		require.NoError(t, l.write(&api.AuditRecord{Timestamp: int64(i)}))
+	}
+	records, err := l.query(&api.QueryAuditLogRequest{})
+	require.NoError(t, err)
+	require.Len(t, records, maxAuditRecords)
+	require.Equal(t, int64(10), records[0].Timestamp)
+
+	records, err = l.query(&api.QueryAuditLogRequest{Since: 100, Until: 102})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	require.Equal(t, int64(100), records[0].Timestamp)
+}
+
+func TestFileAuditBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	backend, err := NewAuditBackend("file:" + path)
+	require.NoError(t, err)
+	s := NewService(log.StandardLogger(), 1)
+	s.auditLog.setBackend(backend)
+	s.auditRun(auditPeer{principal: principalConfig}, &api.GadgetInstance{Id: "1", Name: "exec", ImageName: "trace_exec"})(nil)
+	require.NoError(t, s.auditLog.close())
+
+	// Records written by a previous daemon can be queried as well
+	backend, err = NewAuditBackend("file:" + path)
+	require.NoError(t, err)
+	s = NewService(log.StandardLogger(), 1)
+	s.auditLog.setBackend(backend)
+	defer s.auditLog.close()
+	s.audit(AuditActionStart, auditPeer{principal: principalConfig}, &api.GadgetInstance{Id: "2", ImageName: "trace_open"}, nil)
+
+	res, err := s.QueryAuditLog(context.Background(), &api.QueryAuditLogRequest{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"start 1", "end 1", "start 2"}, auditActions(res.Records))
+	require.Equal(t, "exec", res.Records[0].InstanceName)
+	require.Equal(t, principalConfig, res.Records[0].Principal)
+}
+
+func TestNewAuditBackendErrors(t *testing.T) {
+	for _, spec := range []string{"file", "file:", "syslog", "k8s"} {
+		_, err := NewAuditBackend(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestK8sEventsAuditBackend(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	backend := newK8sEventsAuditBackend(client, "gadget", "node-1")
+
+	require.NoError(t, backend.Write(&api.AuditRecord{
+		Action:      AuditActionEnd,
+		Principal:   "uid:0",
+		InstanceId:  "1",
+		ImageName:   "trace_exec",
+		ParamValues: map[string]string{"b": "2", "a": "1"},
+		Error:       "failed",
+	}))
+
+	events, err := client.CoreV1().Events("gadget").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events.Items, 1)
+	ev := events.Items[0]
+	require.Equal(t, "Node", ev.InvolvedObject.Kind)
+	require.Equal(t, "node-1", ev.InvolvedObject.Name)
+	require.Equal(t, "GadgetEnded", ev.Reason)
+	require.Equal(t, corev1.EventTypeWarning, ev.Type)
+	require.Equal(t, "uid:0: end trace_exec (instance 1) with a=1,b=2: failed", ev.Message)
+}
+
+func TestGRPCPeer(t *testing.T) {
+	require.Equal(t, auditPeer{principal: principalUnauthenticated}, grpcPeer(context.Background()))
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}})
+	require.Equal(t, auditPeer{principal: principalUnauthenticated, address: "127.0.0.1:1234"}, grpcPeer(ctx))
+
+	ctx = peer.NewContext(context.Background(), &peer.Peer{Addr: &peerCredAddr{uid: 1000, pid: 42}})
+	require.Equal(t, auditPeer{principal: "uid:1000", address: "pid:42"}, grpcPeer(ctx))
+}
+
+func TestPeerCredListener(t *testing.T) {
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "test.socket"))
+	require.NoError(t, err)
+	l := &peerCredListener{Listener: listener}
+	defer l.Close()
+
+	go func() {
+		conn, err := net.Dial("unix", listener.Addr().String())
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	addr, ok := conn.RemoteAddr().(*peerCredAddr)
+	require.True(t, ok)
+	require.NotZero(t, addr.pid)
+}
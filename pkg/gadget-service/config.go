@@ -0,0 +1,464 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/oci"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+// reloadDelay is used to coalesce multiple file system events (e.g. when an editor writes the file in several steps)
+const reloadDelay = 500 * time.Millisecond
+
+// DaemonConfig is the configuration of the daemon that can be changed at runtime
+type DaemonConfig struct {
+	// LogLevel of the daemon (trace, debug, info, warning, error)
+	LogLevel string `yaml:"logLevel"`
+
+	// DefaultParams are applied to all gadget runs, unless they're set explicitly by the client; this can be used to
+	// configure operators, e.g. "operator.ioc.lists"
+	DefaultParams map[string]string `yaml:"defaultParams"`
+
+	// Instances are gadgets that are run by the daemon for as long as they're part of the configuration
+	Instances []InstanceConfig `yaml:"instances"`
+
+	// Schedules are gadgets that are run periodically by the daemon
+	Schedules []ScheduleConfig `yaml:"schedules"`
+
+	// ScheduleDir is where the status and outputs of scheduled runs are stored; defaults to DefaultScheduleDir
+	ScheduleDir string `yaml:"scheduleDir"`
+
+	// Triggers are gadgets that are run by the daemon while a resource is under pressure
+	Triggers []TriggerConfig `yaml:"triggers"`
+
+	// TriggerDir is where the status and outputs of triggered runs are stored; defaults to DefaultTriggerDir
+	TriggerDir string `yaml:"triggerDir"`
+
+	// ImagePolicy restricts which gadget images may be run by clients and by the daemon itself
+	ImagePolicy *oci.ImagePolicy `yaml:"imagePolicy"`
+}
+
+// InstanceConfig describes a gadget instance that is managed by the daemon
+type InstanceConfig struct {
+	Name   string            `yaml:"name"`
+	Image  string            `yaml:"image"`
+	Params map[string]string `yaml:"params"`
+}
+
+func (c *InstanceConfig) equal(other *InstanceConfig) bool {
+	return c.Image == other.Image && maps.Equal(c.Params, other.Params)
+}
+
+// ReadConfig parses and validates a daemon configuration
+func ReadConfig(r io.Reader) (*DaemonConfig, error) {
+	config := &DaemonConfig{}
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("decoding config: %w", err)
+	}
+
+	if config.LogLevel != "" {
+		if _, err := log.ParseLevel(config.LogLevel); err != nil {
+			return nil, fmt.Errorf("invalid log level: %w", err)
+		}
+	}
+
+	if config.ImagePolicy != nil {
+		if err := config.ImagePolicy.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid image policy: %w", err)
+		}
+	}
+
+	names := make(map[string]struct{})
+	for _, instance := range config.Instances {
+		if instance.Name == "" {
+			return nil, fmt.Errorf("instance without name")
+		}
+		if instance.Image == "" {
+			return nil, fmt.Errorf("instance %q: no image given", instance.Name)
+		}
+		if _, ok := names[instance.Name]; ok {
+			return nil, fmt.Errorf("instance %q: duplicate name", instance.Name)
+		}
+		names[instance.Name] = struct{}{}
+	}
+	for _, schedule := range config.Schedules {
+		if err := schedule.validate(); err != nil {
+			return nil, err
+		}
+		// Scheduled runs are listed as instances while they're running
+		if _, ok := names[schedule.Name]; ok {
+			return nil, fmt.Errorf("schedule %q: duplicate name", schedule.Name)
+		}
+		names[schedule.Name] = struct{}{}
+	}
+	for _, trigger := range config.Triggers {
+		if err := trigger.validate(); err != nil {
+			return nil, err
+		}
+		// Triggered runs are listed as instances while they're running
+		if _, ok := names[trigger.Name]; ok {
+			return nil, fmt.Errorf("trigger %q: duplicate name", trigger.Name)
+		}
+		names[trigger.Name] = struct{}{}
+	}
+	return config, nil
+}
+
+// LoadConfig reads the daemon configuration from the given file
+func LoadConfig(path string) (*DaemonConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ReadConfig(bytes.NewReader(content))
+}
+
+// ConfigChanges lists what differs between two configurations
+type ConfigChanges struct {
+	Added    []string
+	Removed  []string
+	Updated  []string
+	Settings []string
+}
+
+func diffConfig(oldConfig, newConfig *DaemonConfig) *ConfigChanges {
+	changes := &ConfigChanges{}
+
+	if oldConfig.LogLevel != newConfig.LogLevel {
+		changes.Settings = append(changes.Settings, "logLevel")
+	}
+	if !maps.Equal(oldConfig.DefaultParams, newConfig.DefaultParams) {
+		changes.Settings = append(changes.Settings, "defaultParams")
+	}
+	if oldConfig.ScheduleDir != newConfig.ScheduleDir ||
+		!slices.EqualFunc(oldConfig.Schedules, newConfig.Schedules, func(a, b ScheduleConfig) bool { return a.equal(&b) }) {
+		changes.Settings = append(changes.Settings, "schedules")
+	}
+	if oldConfig.TriggerDir != newConfig.TriggerDir ||
+		!slices.EqualFunc(oldConfig.Triggers, newConfig.Triggers, func(a, b TriggerConfig) bool { return a.equal(&b) }) {
+		changes.Settings = append(changes.Settings, "triggers")
+	}
+	if !reflect.DeepEqual(oldConfig.ImagePolicy, newConfig.ImagePolicy) {
+		changes.Settings = append(changes.Settings, "imagePolicy")
+	}
+
+	oldInstances := make(map[string]*InstanceConfig)
+	for i := range oldConfig.Instances {
+		oldInstances[oldConfig.Instances[i].Name] = &oldConfig.Instances[i]
+	}
+	for i := range newConfig.Instances {
+		instance := &newConfig.Instances[i]
+		oldInstance, ok := oldInstances[instance.Name]
+		if !ok {
+			changes.Added = append(changes.Added, instance.Name)
+			continue
+		}
+		delete(oldInstances, instance.Name)
+		// Changed defaults might affect all instances
+		if !instance.equal(oldInstance) || slices.Contains(changes.Settings, "defaultParams") {
+			changes.Updated = append(changes.Updated, instance.Name)
+		}
+	}
+	for name := range oldInstances {
+		changes.Removed = append(changes.Removed, name)
+	}
+	slices.Sort(changes.Removed)
+
+	return changes
+}
+
+type managedInstance struct {
+	config InstanceConfig
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func mergeParams(defaults, paramValues api.ParamValues) api.ParamValues {
+	res := make(api.ParamValues, len(paramValues)+len(defaults))
+	maps.Copy(res, defaults)
+	maps.Copy(res, paramValues)
+	return res
+}
+
+// paramValues returns the given params merged with the configured defaults
+func (s *Service) paramValues(paramValues api.ParamValues) api.ParamValues {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	return mergeParams(s.config.DefaultParams, paramValues)
+}
+
+// runManagedGadget runs a gadget of the configuration until it's done or ctx is canceled; clients can attach to it
+// while it's running
+func (s *Service) runManagedGadget(ctx context.Context, name, image string, paramValues api.ParamValues) error {
+	gadgetInstance := newGadgetInstance(name, image, paramValues)
+	s.registerInstance(gadgetInstance)
+	defer s.unregisterInstance(gadgetInstance)
+
+	ops := make([]operators.DataOperator, 0)
+	for _, op := range operators.GetDataOperators() {
+		ops = append(ops, op)
+	}
+	ops = append(ops, gadgetInstance.operator())
+
+	gadgetCtx := gadgetcontext.New(
+		ctx,
+		image,
+		gadgetcontext.WithLogger(s.logger),
+		gadgetcontext.WithDataOperators(ops...),
+	)
+
+	runtimeParams, err := s.runtimeParams(paramValues)
+	if err != nil {
+		return err
+	}
+
+	auditEnd := s.auditRun(auditPeer{principal: principalConfig}, gadgetInstance.info)
+	err = s.runtime.RunGadget(gadgetCtx, runtimeParams, paramValues)
+	auditEnd(err)
+	return err
+}
+
+// runtimeParams returns the params of the runtime set to the values of paramValues
+func (s *Service) runtimeParams(paramValues api.ParamValues) (*params.Params, error) {
+	runtimeParams := s.runtime.ParamDescs().ToParams()
+	if err := runtimeParams.CopyFromMap(paramValues, "runtime."); err != nil {
+		return nil, fmt.Errorf("setting runtime params: %w", err)
+	}
+	return runtimeParams, nil
+}
+
+func (s *Service) startInstance(config InstanceConfig, defaults api.ParamValues) *managedInstance {
+	ctx, cancel := context.WithCancel(context.Background())
+	instance := &managedInstance{
+		config: config,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	paramValues := mergeParams(defaults, config.Params)
+
+	go func() {
+		defer close(instance.done)
+
+		s.logger.Infof("starting gadget instance %q (%s)", config.Name, config.Image)
+		err := s.runManagedGadget(ctx, config.Name, config.Image, paramValues)
+		if err != nil {
+			s.logger.Errorf("running gadget instance %q: %v", config.Name, err)
+			return
+		}
+		s.logger.Infof("gadget instance %q stopped", config.Name)
+	}()
+
+	return instance
+}
+
+func (i *managedInstance) stop() {
+	i.cancel()
+	<-i.done
+}
+
+// applyConfig makes newConfig the active configuration and starts / stops managed instances accordingly. If the
+// params of an instance to be started are invalid, nothing is changed.
+func (s *Service) applyConfig(newConfig *DaemonConfig) (*ConfigChanges, error) {
+	s.configLock.Lock()
+
+	changes := diffConfig(s.config, newConfig)
+	for _, instance := range newConfig.Instances {
+		if !slices.Contains(changes.Added, instance.Name) && !slices.Contains(changes.Updated, instance.Name) {
+			continue
+		}
+		if _, err := s.runtimeParams(mergeParams(newConfig.DefaultParams, instance.Params)); err != nil {
+			s.configLock.Unlock()
+			return nil, fmt.Errorf("instance %q: %w", instance.Name, err)
+		}
+	}
+	s.config = newConfig
+
+	if slices.Contains(changes.Settings, "logLevel") && newConfig.LogLevel != "" {
+		// Validated in ReadConfig
+		level, _ := log.ParseLevel(newConfig.LogLevel)
+		s.logger.SetLevel(level)
+	}
+
+	// Applied before (re)starting instances, so that they're subject to the new policy
+	if slices.Contains(changes.Settings, "imagePolicy") {
+		oci.SetImagePolicy(newConfig.ImagePolicy)
+	}
+
+	var stopped []*managedInstance
+	for _, name := range slices.Concat(changes.Removed, changes.Updated) {
+		stopped = append(stopped, s.instances[name])
+		delete(s.instances, name)
+	}
+
+	// Don't block clients reading the configuration (e.g. to run gadgets) while waiting for instances to stop;
+	// concurrent reloads are prevented by reloadLock
+	s.configLock.Unlock()
+	for _, instance := range stopped {
+		instance.stop()
+	}
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+
+	for _, instance := range newConfig.Instances {
+		if slices.Contains(changes.Added, instance.Name) || slices.Contains(changes.Updated, instance.Name) {
+			s.instances[instance.Name] = s.startInstance(instance, newConfig.DefaultParams)
+		}
+	}
+
+	if slices.Contains(changes.Settings, "schedules") || slices.Contains(changes.Settings, "defaultParams") {
+		s.applySchedules(newConfig, slices.Contains(changes.Settings, "defaultParams"))
+	}
+	if slices.Contains(changes.Settings, "triggers") || slices.Contains(changes.Settings, "defaultParams") {
+		s.applyTriggers(newConfig, slices.Contains(changes.Settings, "defaultParams"))
+	}
+
+	return changes, nil
+}
+
+// reloadConfig reads the configuration file again and applies it
+func (s *Service) reloadConfig() (*ConfigChanges, error) {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	newConfig, err := LoadConfig(s.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading config %q: %w", s.configPath, err)
+	}
+	changes, err := s.applyConfig(newConfig)
+	if err != nil {
+		return nil, fmt.Errorf("applying config %q: %w", s.configPath, err)
+	}
+	s.logger.Infof("config reloaded: added instances %v, removed instances %v, updated instances %v, changed settings %v",
+		changes.Added, changes.Removed, changes.Updated, changes.Settings)
+	return changes, nil
+}
+
+func (s *Service) ReloadConfig(ctx context.Context, req *api.ReloadConfigRequest) (*api.ReloadConfigResponse, error) {
+	if s.configPath == "" {
+		return nil, fmt.Errorf("daemon is running without a config file")
+	}
+	changes, err := s.reloadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &api.ReloadConfigResponse{
+		Added:    changes.Added,
+		Removed:  changes.Removed,
+		Updated:  changes.Updated,
+		Settings: changes.Settings,
+	}, nil
+}
+
+// watchConfig reloads the configuration on SIGHUP and whenever the configuration file changes
+func (s *Service) watchConfig(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating watcher: %w", err)
+	}
+
+	// Watch the directory instead of the file itself to also catch files being replaced (e.g. ConfigMaps in
+	// Kubernetes are updated by swapping symlinks)
+	configPath := filepath.Clean(s.configPath)
+	if err := watcher.Add(filepath.Dir(configPath)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("watching %q: %w", filepath.Dir(configPath), err)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer watcher.Close()
+		defer signal.Stop(hup)
+
+		reload := func() {
+			if _, err := s.reloadConfig(); err != nil {
+				s.logger.Errorf("reloading config: %v", err)
+			}
+		}
+
+		timer := time.NewTimer(reloadDelay)
+		timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				s.logger.Infof("received SIGHUP, reloading config")
+				reload()
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) != configPath && filepath.Base(ev.Name) != "..data" {
+					continue
+				}
+				timer.Reset(reloadDelay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				s.logger.Warnf("watching config: %v", err)
+			case <-timer.C:
+				reload()
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *Service) stopInstances() {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	s.configLock.Lock()
+	instances := maps.Clone(s.instances)
+	clear(s.instances)
+	s.configLock.Unlock()
+
+	for _, instance := range instances {
+		instance.stop()
+	}
+
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	s.stopSchedules()
+	s.stopTriggers()
+}
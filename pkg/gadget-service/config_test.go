@@ -0,0 +1,141 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/runtime"
+)
+
+func TestReadConfig(t *testing.T) {
+	config, err := ReadConfig(strings.NewReader(`
+logLevel: debug
+defaultParams:
+  operator.ioc.lists: /etc/ig/iocs.txt
+instances:
+- name: exec
+  image: trace_exec
+  params:
+    operator.exechash.enable: "true"
+imagePolicy:
+  allowedRegistries:
+  - ghcr.io/inspektor-gadget
+  requireSignature: true
+`))
+	require.NoError(t, err)
+	require.Equal(t, "debug", config.LogLevel)
+	require.Equal(t, "/etc/ig/iocs.txt", config.DefaultParams["operator.ioc.lists"])
+	require.Len(t, config.Instances, 1)
+	require.Equal(t, "trace_exec", config.Instances[0].Image)
+	require.Equal(t, []string{"ghcr.io/inspektor-gadget"}, config.ImagePolicy.AllowedRegistries)
+	require.True(t, config.ImagePolicy.RequireSignature)
+
+	config, err = ReadConfig(strings.NewReader(""))
+	require.NoError(t, err)
+	require.Empty(t, config.Instances)
+
+	invalid := []string{
+		"logLevel: loud",
+		"unknown: true",
+		"instances:\n- image: trace_exec",
+		"instances:\n- name: exec",
+		"instances:\n- name: exec\n  image: trace_exec\n- name: exec\n  image: trace_open",
+		"imagePolicy:\n  deniedDigests:\n  - abc",
+	}
+	for _, c := range invalid {
+		_, err := ReadConfig(strings.NewReader(c))
+		require.Error(t, err, c)
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	oldConfig := &DaemonConfig{
+		LogLevel: "info",
+		Instances: []InstanceConfig{
+			{Name: "exec", Image: "trace_exec"},
+			{Name: "open", Image: "trace_open"},
+			{Name: "dns", Image: "trace_dns", Params: map[string]string{"a": "b"}},
+		},
+	}
+	newConfig := &DaemonConfig{
+		LogLevel: "debug",
+		Instances: []InstanceConfig{
+			{Name: "exec", Image: "trace_exec"},
+			{Name: "dns", Image: "trace_dns", Params: map[string]string{"a": "c"}},
+			{Name: "tcp", Image: "trace_tcp"},
+		},
+	}
+
+	changes := diffConfig(oldConfig, newConfig)
+	require.Equal(t, []string{"tcp"}, changes.Added)
+	require.Equal(t, []string{"open"}, changes.Removed)
+	require.Equal(t, []string{"dns"}, changes.Updated)
+	require.Equal(t, []string{"logLevel"}, changes.Settings)
+
+	// Changing the defaults affects all instances
+	newConfig.DefaultParams = map[string]string{"a": "b"}
+	changes = diffConfig(oldConfig, newConfig)
+	require.Equal(t, []string{"exec", "dns"}, changes.Updated)
+	require.Equal(t, []string{"logLevel", "defaultParams"}, changes.Settings)
+
+	newConfig = &DaemonConfig{
+		LogLevel:  "info",
+		Instances: oldConfig.Instances,
+		Schedules: []ScheduleConfig{{Name: "sockets", Image: "snapshot_socket", Schedule: "@hourly"}},
+	}
+	changes = diffConfig(oldConfig, newConfig)
+	require.Empty(t, changes.Updated)
+	require.Equal(t, []string{"schedules"}, changes.Settings)
+}
+
+// paramsRuntime is a runtime that only provides params
+type paramsRuntime struct {
+	runtime.Runtime
+	paramDescs params.ParamDescs
+}
+
+func (r *paramsRuntime) ParamDescs() params.ParamDescs {
+	return r.paramDescs
+}
+
+func TestApplyConfigInvalidParams(t *testing.T) {
+	s := NewService(log.StandardLogger(), 1)
+	s.runtime = &paramsRuntime{paramDescs: params.ParamDescs{
+		{Key: "node-limit", DefaultValue: "0", TypeHint: params.TypeUint32},
+	}}
+	oldConfig := s.config
+
+	config, err := ReadConfig(strings.NewReader(`
+logLevel: debug
+instances:
+- name: exec
+  image: trace_exec
+  params:
+    runtime.node-limit: many
+`))
+	require.NoError(t, err)
+
+	// Nothing is applied if an instance can't be started
+	_, err = s.applyConfig(config)
+	require.ErrorContains(t, err, `instance "exec"`)
+	require.Same(t, oldConfig, s.config)
+	require.Empty(t, s.instances)
+}
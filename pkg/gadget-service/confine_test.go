@@ -0,0 +1,43 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestConfineWorker(t *testing.T) {
+	// The filter can't be removed, so it's installed in a separate process running this test
+	if os.Getenv("IG_TEST_CONFINE_WORKER") != "" {
+		require.NoError(t, confineWorker())
+
+		err := exec.Command("/bin/true").Run()
+		require.ErrorIs(t, err, unix.EPERM)
+		_, err = os.ReadFile("/proc/self/status")
+		require.NoError(t, err)
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestConfineWorker$")
+	cmd.Env = append(os.Environ(), "IG_TEST_CONFINE_WORKER=1")
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.Contains(t, string(out), "PASS")
+}
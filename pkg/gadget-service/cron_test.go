@@ -0,0 +1,91 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCronNext(t *testing.T) {
+	// Friday
+	now := time.Date(2024, time.March, 15, 10, 42, 30, 0, time.UTC)
+	for spec, expected := range map[string]time.Time{
+		"* * * * *":         time.Date(2024, time.March, 15, 10, 43, 0, 0, time.UTC),
+		"@hourly":           time.Date(2024, time.March, 15, 11, 0, 0, 0, time.UTC),
+		"@daily":            time.Date(2024, time.March, 16, 0, 0, 0, 0, time.UTC),
+		"30 2 * * *":        time.Date(2024, time.March, 16, 2, 30, 0, 0, time.UTC),
+		"*/15 * * * *":      time.Date(2024, time.March, 15, 10, 45, 0, 0, time.UTC),
+		"5/20 10 * * *":     time.Date(2024, time.March, 15, 10, 45, 0, 0, time.UTC),
+		"0 9-17/4 * * *":    time.Date(2024, time.March, 15, 13, 0, 0, 0, time.UTC),
+		"0 0 * * mon-wed":   time.Date(2024, time.March, 18, 0, 0, 0, 0, time.UTC),
+		"0 0 * * 7":         time.Date(2024, time.March, 17, 0, 0, 0, 0, time.UTC),
+		"0 0 1 apr,jun *":   time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 29 feb *":      time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC),
+		"0 12 20 * sun":     time.Date(2024, time.March, 17, 12, 0, 0, 0, time.UTC),
+		"0 12 20 * *":       time.Date(2024, time.March, 20, 12, 0, 0, 0, time.UTC),
+		"0 0 31 * *":        time.Date(2024, time.March, 31, 0, 0, 0, 0, time.UTC),
+		"0 0 30 2 *":        {},
+		"42,43 10 15 3 fri": time.Date(2024, time.March, 15, 10, 43, 0, 0, time.UTC),
+	} {
+		c, err := parseCron(spec)
+		require.NoError(t, err, spec)
+		require.Equal(t, expected, c.next(now), spec)
+	}
+}
+
+func TestCronNextTimeZone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+
+	c, err := parseCron("30 2 * * *")
+	require.NoError(t, err)
+	// 2:30 doesn't exist on the day daylight saving time starts in Berlin
+	now := time.Date(2024, time.March, 30, 12, 0, 0, 0, berlin)
+	require.Equal(t, time.Date(2024, time.April, 1, 2, 30, 0, 0, berlin), c.next(now))
+
+	c, err = parseCron("0 * * * *")
+	require.NoError(t, err)
+	now = time.Date(2024, time.March, 15, 10, 42, 0, 0, kolkata)
+	require.Equal(t, time.Date(2024, time.March, 15, 11, 0, 0, 0, kolkata), c.next(now))
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@often",
+	} {
+		_, err := parseCron(spec)
+		require.Error(t, err, spec)
+	}
+}
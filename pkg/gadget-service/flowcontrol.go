@@ -0,0 +1,191 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+const (
+	FlowControlPolicyDropNewest = "drop-newest"
+	FlowControlPolicyDropOldest = "drop-oldest"
+)
+
+// eventQueue buffers payload events for a client. If flow control is enabled, events are only handed out while the
+// client has credits left. The queue never grows beyond its maximum length; instead events are dropped according to
+// the configured policy.
+type eventQueue struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	events  []*api.GadgetEvent
+	maxLen  int
+	policy  string
+	seq     uint32
+	dropped uint64
+	closed  bool
+
+	flowControl bool
+	credits     uint64
+
+	// priorities maps the ids of data sources to their priority class. Depending on the fill level of the queue,
+	// events of low priority data sources are shed before events of more important ones get dropped; other clients
+	// of the gadget aren't affected.
+	priorities map[uint32]datasource.PriorityClass
+	threshold  datasource.PriorityClass
+	shed       uint64
+}
+
+func newEventQueue(maxLen uint64, credits uint32, policy string) (*eventQueue, error) {
+	switch policy {
+	case "":
+		policy = FlowControlPolicyDropNewest
+	case FlowControlPolicyDropNewest, FlowControlPolicyDropOldest:
+	default:
+		return nil, fmt.Errorf("invalid flow control policy %q", policy)
+	}
+	if maxLen == 0 {
+		maxLen = 1
+	}
+	q := &eventQueue{
+		events:      make([]*api.GadgetEvent, 0, min(maxLen, 1024)),
+		maxLen:      int(maxLen),
+		policy:      policy,
+		flowControl: credits > 0,
+		credits:     uint64(credits),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q, nil
+}
+
+// push assigns the next sequence number to ev and adds it to the queue
+func (q *eventQueue) push(ev *api.GadgetEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	// The sequence number is incremented even for dropped events, so clients can detect the loss
+	q.seq++
+	ev.Seq = q.seq
+
+	if q.shouldShed(ev) {
+		q.shed++
+		return
+	}
+
+	if len(q.events) >= q.maxLen {
+		q.dropped++
+		if q.policy == FlowControlPolicyDropNewest {
+			return
+		}
+		q.events[0] = nil
+		q.events = q.events[1:]
+	}
+	q.events = append(q.events, ev)
+	q.updateShedding()
+	q.cond.Signal()
+}
+
+// setPriorities enables shedding using the priority classes of the data sources with the given ids
+func (q *eventQueue) setPriorities(priorities map[uint32]datasource.PriorityClass) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.priorities = priorities
+}
+
+// shouldShed returns whether ev belongs to a data source whose priority class is below the current threshold
+func (q *eventQueue) shouldShed(ev *api.GadgetEvent) bool {
+	if q.priorities == nil || ev.Type != api.EventTypeGadgetPayload {
+		return false
+	}
+	p, ok := q.priorities[ev.DataSourceID]
+	if !ok {
+		p = datasource.PriorityNormal
+	}
+	return p < q.threshold
+}
+
+// updateShedding sheds debug and low priority data sources once the queue is filled by three quarters and everything
+// below high priority once it's full; shedding stops when the queue has been drained below a quarter
+func (q *eventQueue) updateShedding() {
+	switch fill := len(q.events) * 4 / q.maxLen; {
+	case fill >= 4:
+		q.threshold = datasource.PriorityHigh
+	case fill >= 3:
+		q.threshold = max(q.threshold, datasource.PriorityNormal)
+	case fill < 1:
+		q.threshold = datasource.PriorityDebug
+	}
+}
+
+// grant adds credits for the client
+func (q *eventQueue) grant(credits uint32) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.credits += uint64(credits)
+	q.cond.Signal()
+}
+
+// pop waits for the next event that may be sent to the client; it returns false once the queue has been closed
+func (q *eventQueue) pop() (*api.GadgetEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for !q.closed && (len(q.events) == 0 || (q.flowControl && q.credits == 0)) {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil, false
+	}
+
+	ev := q.events[0]
+	q.events[0] = nil
+	q.events = q.events[1:]
+	if q.flowControl {
+		q.credits--
+	}
+	q.updateShedding()
+	return ev, true
+}
+
+// close wakes up all waiting consumers; events still in the queue are discarded
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.events = nil
+	q.cond.Broadcast()
+}
+
+// droppedEvents returns the number of events that have been dropped because the queue was full
+func (q *eventQueue) droppedEvents() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// shedEvents returns the number of events of low priority data sources that have been shed
+func (q *eventQueue) shedEvents() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.shed
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+func pushEvents(q *eventQueue, n int) {
+	for i := 0; i < n; i++ {
+		q.push(&api.GadgetEvent{})
+	}
+}
+
+func popSeqs(t *testing.T, q *eventQueue, n int) []uint32 {
+	res := make([]uint32, 0, n)
+	for i := 0; i < n; i++ {
+		ev, ok := q.pop()
+		require.True(t, ok)
+		res = append(res, ev.Seq)
+	}
+	return res
+}
+
+func TestEventQueuePolicies(t *testing.T) {
+	_, err := newEventQueue(4, 0, "drop-all")
+	require.Error(t, err)
+
+	q, err := newEventQueue(3, 0, "")
+	require.NoError(t, err)
+	pushEvents(q, 5)
+	require.Equal(t, []uint32{1, 2, 3}, popSeqs(t, q, 3))
+	require.Equal(t, uint64(2), q.droppedEvents())
+
+	q, err = newEventQueue(3, 0, FlowControlPolicyDropOldest)
+	require.NoError(t, err)
+	pushEvents(q, 5)
+	require.Equal(t, []uint32{3, 4, 5}, popSeqs(t, q, 3))
+	require.Equal(t, uint64(2), q.droppedEvents())
+}
+
+func TestEventQueueCredits(t *testing.T) {
+	q, err := newEventQueue(10, 2, FlowControlPolicyDropNewest)
+	require.NoError(t, err)
+	pushEvents(q, 4)
+	require.Equal(t, []uint32{1, 2}, popSeqs(t, q, 2))
+
+	// No credits left, pop must block until more are granted
+	popped := make(chan uint32)
+	go func() {
+		ev, ok := q.pop()
+		if ok {
+			popped <- ev.Seq
+		}
+		close(popped)
+	}()
+	select {
+	case <-popped:
+		t.Fatal("event sent without credits")
+	case <-time.After(50 * time.Millisecond):
+	}
+	q.grant(1)
+	require.Equal(t, uint32(3), <-popped)
+
+	// Closing wakes up waiting consumers
+	done := make(chan bool)
+	go func() {
+		_, ok := q.pop()
+		done <- ok
+	}()
+	q.close()
+	require.False(t, <-done)
+}
+
+func TestEventQueueShedding(t *testing.T) {
+	q, err := newEventQueue(8, 0, FlowControlPolicyDropNewest)
+	require.NoError(t, err)
+	q.setPriorities(map[uint32]datasource.PriorityClass{
+		0: datasource.PriorityLow,
+		1: datasource.PriorityNormal,
+		2: datasource.PriorityCritical,
+	})
+	pushPriorities := func() {
+		for id := uint32(0); id < 3; id++ {
+			q.push(&api.GadgetEvent{Type: api.EventTypeGadgetPayload, DataSourceID: id})
+		}
+	}
+
+	pushEvents(q, 6)
+	require.Equal(t, datasource.PriorityNormal, q.threshold)
+	pushPriorities()
+	require.Equal(t, uint64(1), q.shedEvents())
+	require.Equal(t, datasource.PriorityHigh, q.threshold)
+
+	// Critical data sources are never shed, but dropped once the queue is full
+	pushPriorities()
+	require.Equal(t, uint64(3), q.shedEvents())
+	require.Equal(t, uint64(1), q.droppedEvents())
+
+	// Shedding continues until the queue is mostly drained
+	popSeqs(t, q, 4)
+	require.Equal(t, datasource.PriorityHigh, q.threshold)
+	popSeqs(t, q, 3)
+	require.Equal(t, datasource.PriorityDebug, q.threshold)
+	pushPriorities()
+	require.Equal(t, uint64(3), q.shedEvents())
+}
@@ -0,0 +1,292 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+// The handoff protocol allows a new daemon (e.g. after an upgrade) to take over the listening socket of a running
+// daemon, so that clients don't see connection errors in between:
+//
+//  1. the new daemon connects to the handoff socket of the old daemon
+//  2. the old daemon sends a HandoffManifest together with the file descriptor of its listener (SCM_RIGHTS)
+//  3. the new daemon verifies the manifest, starts the detached instances listed in it and acknowledges once it's
+//     ready to serve on the received listener
+//  4. the old daemon stops accepting new connections and its detached instances, and exits once the gadgets of its
+//     remaining clients have finished
+//
+// Both daemons run the detached instances for a short time, so no events are lost in between. State of the instances
+// that should survive this (like maps pinned using the ebpf operator's pin-maps parameter) is kept in bpffs and
+// re-adopted by the new daemon.
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/inspektor-gadget/inspektor-gadget/internal/version"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+const (
+	handoffAck     = "ok"
+	handoffTimeout = 10 * time.Second
+	// handoffAckTimeout is longer, as the new daemon starts the detached instances before acknowledging
+	handoffAckTimeout = time.Minute
+
+	maxManifestSize = 64 * 1024
+)
+
+// HandoffManifest describes what a running daemon hands over to its successor
+type HandoffManifest struct {
+	// Version of the daemon handing over
+	Version string `json:"version"`
+
+	// SocketType and SocketPath describe the listener that is handed over
+	SocketType string `json:"socketType"`
+	SocketPath string `json:"socketPath"`
+
+	// Instances are the detached instances the new daemon takes over
+	Instances []HandoffInstance `json:"instances,omitempty"`
+}
+
+// HandoffInstance describes a detached gadget instance that is taken over by the new daemon
+type HandoffInstance struct {
+	ID          string            `json:"id"`
+	ImageName   string            `json:"imageName"`
+	ParamValues map[string]string `json:"paramValues,omitempty"`
+	// StartedAt is the time the instance was started by the old daemon in nanoseconds since the epoch
+	StartedAt int64 `json:"startedAt"`
+	// Deadline is the time the instance stops at in nanoseconds since the epoch; 0 if it runs until deleted
+	Deadline int64 `json:"deadline,omitempty"`
+}
+
+// runRequest returns the request to run the instance at now; false is returned if its deadline has passed already
+func (hi *HandoffInstance) runRequest(now time.Time) (*api.GadgetRunRequest, bool) {
+	request := &api.GadgetRunRequest{
+		ImageName:   hi.ImageName,
+		ParamValues: hi.ParamValues,
+		Detach:      true,
+	}
+	if hi.Deadline != 0 {
+		remaining := time.Unix(0, hi.Deadline).Sub(now)
+		if remaining <= 0 {
+			return nil, false
+		}
+		request.Timeout = int64(remaining)
+	}
+	return request, true
+}
+
+// handoffInstances returns the detached instances that are still running
+func (s *Service) handoffInstances() []HandoffInstance {
+	s.gadgetInstancesLock.Lock()
+	defer s.gadgetInstancesLock.Unlock()
+
+	var instances []HandoffInstance
+	for _, instance := range s.gadgetInstances {
+		if !instance.info.Detached {
+			continue
+		}
+		hi := HandoffInstance{
+			ID:          instance.info.Id,
+			ImageName:   instance.info.ImageName,
+			ParamValues: instance.info.ParamValues,
+			StartedAt:   instance.info.StartedAt,
+		}
+		if !instance.deadline.IsZero() {
+			hi.Deadline = instance.deadline.UnixNano()
+		}
+		instances = append(instances, hi)
+	}
+	slices.SortFunc(instances, func(a, b HandoffInstance) int {
+		return cmp.Compare(a.StartedAt, b.StartedAt)
+	})
+	return instances
+}
+
+// adoptInstances starts the detached instances handed over by the old daemon, keeping their ids
+func (s *Service) adoptInstances(instances []HandoffInstance) {
+	for _, hi := range instances {
+		request, ok := hi.runRequest(time.Now())
+		if !ok {
+			continue
+		}
+		instance := newGadgetInstance("", hi.ImageName, hi.ParamValues)
+		instance.info.Id = hi.ID
+		instance.info.StartedAt = hi.StartedAt
+		if _, err := s.startDetached(instance, request, auditPeer{principal: principalHandoff}); err != nil {
+			s.logger.Errorf("taking over gadget instance %q (%s): %v", hi.ID, hi.ImageName, err)
+			continue
+		}
+		s.logger.Infof("took over gadget instance %q (%s)", hi.ID, hi.ImageName)
+	}
+}
+
+// requestHandoff connects to the handoff socket of a running daemon and receives its listener. If no daemon is
+// listening, nil is returned without an error.
+func requestHandoff(handoffPath string, runConfig RunConfig) (net.Listener, *net.UnixConn, *HandoffManifest, error) {
+	conn, err := net.DialTimeout("unix", handoffPath, handoffTimeout)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, nil, nil, nil
+		}
+		return nil, nil, nil, fmt.Errorf("connecting to %q: %w", handoffPath, err)
+	}
+	uconn := conn.(*net.UnixConn)
+	uconn.SetDeadline(time.Now().Add(handoffTimeout))
+
+	buf := make([]byte, maxManifestSize)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := uconn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		uconn.Close()
+		return nil, nil, nil, fmt.Errorf("receiving handoff: %w", err)
+	}
+
+	fd, err := parseRights(oob[:oobn])
+	if err != nil {
+		uconn.Close()
+		return nil, nil, nil, err
+	}
+	f := os.NewFile(uintptr(fd), "handoff-listener")
+	defer f.Close()
+
+	manifest := &HandoffManifest{}
+	if err := json.Unmarshal(buf[:n], manifest); err != nil {
+		uconn.Close()
+		return nil, nil, nil, fmt.Errorf("decoding handoff manifest: %w", err)
+	}
+	if manifest.SocketType != runConfig.SocketType || manifest.SocketPath != runConfig.SocketPath {
+		uconn.Close()
+		return nil, nil, nil, fmt.Errorf("running daemon listens on %s://%s instead of %s://%s",
+			manifest.SocketType, manifest.SocketPath, runConfig.SocketType, runConfig.SocketPath)
+	}
+
+	// net.FileListener duplicates the file descriptor, so f can be closed afterwards
+	listener, err := net.FileListener(f)
+	if err != nil {
+		uconn.Close()
+		return nil, nil, nil, fmt.Errorf("creating listener from handed over socket: %w", err)
+	}
+	return listener, uconn, manifest, nil
+}
+
+func parseRights(oob []byte) (int, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return -1, fmt.Errorf("parsing control message: %w", err)
+	}
+	for _, msg := range msgs {
+		fds, err := syscall.ParseUnixRights(&msg)
+		if err != nil {
+			continue
+		}
+		if len(fds) != 1 {
+			for _, fd := range fds {
+				syscall.Close(fd)
+			}
+			return -1, fmt.Errorf("expected exactly one file descriptor, got %d", len(fds))
+		}
+		return fds[0], nil
+	}
+	return -1, errors.New("no file descriptor received")
+}
+
+// acknowledgeHandoff tells the old daemon that the listener and the instances have been taken over
+func acknowledgeHandoff(conn *net.UnixConn) error {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(handoffTimeout))
+	_, err := conn.Write([]byte(handoffAck + "\n"))
+	return err
+}
+
+// serveHandoff waits for a successor on handoffPath and hands the listener over to it. It returns after a
+// successful handoff or when the handoff listener is closed.
+func (s *Service) serveHandoff(handoffListener net.Listener, runConfig RunConfig) error {
+	for {
+		conn, err := handoffListener.Accept()
+		if err != nil {
+			return err
+		}
+		// The instances are collected for each attempt, as they might have changed in between
+		manifest, err := json.Marshal(&HandoffManifest{
+			Version:    version.Version().String(),
+			SocketType: runConfig.SocketType,
+			SocketPath: runConfig.SocketPath,
+			Instances:  s.handoffInstances(),
+		})
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		err = s.handoff(conn.(*net.UnixConn), manifest)
+		if err == nil {
+			return nil
+		}
+		s.logger.Warnf("handing over to new daemon: %v", err)
+	}
+}
+
+func (s *Service) handoff(conn *net.UnixConn, manifest []byte) error {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(handoffTimeout))
+
+	sc, ok := s.listener.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("listener of type %T can't be handed over", s.listener)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sendErr error
+	err = rc.Control(func(fd uintptr) {
+		_, _, sendErr = conn.WriteMsgUnix(manifest, syscall.UnixRights(int(fd)), nil)
+	})
+	if err != nil {
+		return err
+	}
+	if sendErr != nil {
+		return fmt.Errorf("sending listener: %w", sendErr)
+	}
+
+	conn.SetDeadline(time.Now().Add(handoffAckTimeout))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("waiting for acknowledgement: %w", err)
+	}
+	if line != handoffAck+"\n" {
+		return fmt.Errorf("unexpected acknowledgement %q", line)
+	}
+	return nil
+}
+
+func newHandoffListener(handoffPath string) (net.Listener, error) {
+	if err := os.Remove(handoffPath); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("removing existing handoff socket at %q: %w", handoffPath, err)
+	}
+
+	// Only root should be able to take over the daemon
+	oldMask := syscall.Umask(0o077)
+	defer syscall.Umask(oldMask)
+
+	return net.Listen("unix", handoffPath)
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+func TestHandoff(t *testing.T) {
+	dir := t.TempDir()
+	runConfig := RunConfig{
+		SocketType:  "unix",
+		SocketPath:  filepath.Join(dir, "gadget.socket"),
+		HandoffPath: filepath.Join(dir, "handoff.socket"),
+	}
+
+	// Nobody to take over from
+	listener, _, _, err := requestHandoff(runConfig.HandoffPath, runConfig)
+	require.NoError(t, err)
+	require.Nil(t, listener)
+
+	oldListener, err := net.Listen("unix", runConfig.SocketPath)
+	require.NoError(t, err)
+	defer oldListener.Close()
+
+	handoffListener, err := newHandoffListener(runConfig.HandoffPath)
+	require.NoError(t, err)
+	defer handoffListener.Close()
+
+	old := NewService(log.StandardLogger(), 1)
+	old.listener = oldListener
+
+	// Detached instances are handed over, others are kept by the clients of the old daemon
+	detached := newGadgetInstance("", "trace_exec", api.ParamValues{"operator.oci.ebpf.pin-maps": "exec"})
+	detached.info.Detached = true
+	detached.deadline = time.Unix(0, detached.info.StartedAt).Add(time.Hour)
+	old.registerInstance(detached)
+	old.registerInstance(newGadgetInstance("", "trace_open", nil))
+
+	done := make(chan error)
+	go func() {
+		done <- old.serveHandoff(handoffListener, runConfig)
+	}()
+
+	// Mismatching configuration must be rejected
+	otherConfig := runConfig
+	otherConfig.SocketPath = filepath.Join(dir, "other.socket")
+	_, _, _, err = requestHandoff(runConfig.HandoffPath, otherConfig)
+	require.Error(t, err)
+
+	listener, conn, manifest, err := requestHandoff(runConfig.HandoffPath, runConfig)
+	require.NoError(t, err)
+	require.NotNil(t, listener)
+	defer listener.Close()
+	require.Equal(t, runConfig.SocketPath, manifest.SocketPath)
+	require.Equal(t, []HandoffInstance{{
+		ID:          detached.info.Id,
+		ImageName:   "trace_exec",
+		ParamValues: map[string]string{"operator.oci.ebpf.pin-maps": "exec"},
+		StartedAt:   detached.info.StartedAt,
+		Deadline:    detached.info.StartedAt + int64(time.Hour),
+	}}, manifest.Instances)
+
+	require.NoError(t, acknowledgeHandoff(conn))
+	require.NoError(t, <-done)
+
+	// The old listener is gone, new connections must be accepted by the handed over one
+	oldListener.(*net.UnixListener).SetUnlinkOnClose(false)
+	oldListener.Close()
+
+	accepted := make(chan struct{})
+	go func() {
+		c, err := listener.Accept()
+		if err == nil {
+			c.Close()
+		}
+		close(accepted)
+	}()
+	c, err := net.Dial("unix", runConfig.SocketPath)
+	require.NoError(t, err)
+	c.Close()
+	<-accepted
+}
+
+func TestHandoffInstanceRunRequest(t *testing.T) {
+	now := time.Now()
+
+	hi := HandoffInstance{ImageName: "trace_exec", ParamValues: map[string]string{"a": "b"}}
+	request, ok := hi.runRequest(now)
+	require.True(t, ok)
+	require.True(t, request.Detach)
+	require.Equal(t, "trace_exec", request.ImageName)
+	require.Equal(t, map[string]string{"a": "b"}, request.ParamValues)
+	require.Zero(t, request.Timeout)
+
+	// Only the remaining time is run
+	hi.Deadline = now.Add(time.Minute).UnixNano()
+	request, ok = hi.runRequest(now)
+	require.True(t, ok)
+	require.Equal(t, int64(time.Minute), request.Timeout)
+
+	_, ok = hi.runRequest(now.Add(time.Minute))
+	require.False(t, ok)
+}
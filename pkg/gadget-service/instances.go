@@ -0,0 +1,526 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/buffer"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/simple"
+)
+
+// gadgetInstance is a running gadget whose events can be consumed by additional clients attaching to it
+type gadgetInstance struct {
+	info *api.GadgetInstance
+
+	// cancel stops a detached instance; done is closed once it stopped
+	cancel context.CancelFunc
+	done   chan struct{}
+	// deadline is the time a detached instance with a timeout stops at
+	deadline time.Time
+
+	mu          sync.Mutex
+	gadgetCtx   operators.GadgetContext
+	gadgetInfo  *api.GadgetEvent
+	dataSources []datasource.DataSource
+	// dsIDs maps the data sources to the ids used in payload events
+	dsIDs       map[datasource.DataSource]uint32
+	initialized chan struct{}
+	// history keeps the recent events of detached instances unless the buffer operator is enabled
+	history     *eventRing
+	subscribers []*instanceSubscriber
+	finished    bool
+}
+
+// eventRing keeps the most recent payload events of a detached instance
+type eventRing struct {
+	events []*api.GadgetEvent
+	next   int
+	full   bool
+}
+
+func newEventRing(length uint64) *eventRing {
+	return &eventRing{events: make([]*api.GadgetEvent, length)}
+}
+
+func (r *eventRing) add(ev *api.GadgetEvent) {
+	if len(r.events) == 0 {
+		return
+	}
+	r.events[r.next] = ev
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *eventRing) len() int {
+	if r.full {
+		return len(r.events)
+	}
+	return r.next
+}
+
+// all returns the buffered events, oldest first
+func (r *eventRing) all() []*api.GadgetEvent {
+	if !r.full {
+		return r.events[:r.next]
+	}
+	return append(slices.Clone(r.events[r.next:]), r.events[:r.next]...)
+}
+
+type instanceSubscriber struct {
+	events chan *api.GadgetEvent
+
+	// seqLock guards seq, as subscribers of the buffer operator get events of several data sources concurrently
+	seqLock sync.Mutex
+	// seq numbers the payload events of this subscriber, so it can detect dropped events
+	seq uint32
+
+	// unsubscribeBuffers is set if the subscriber gets its events from the buffer operator instead of publish
+	unsubscribeBuffers []func()
+}
+
+func (sub *instanceSubscriber) send(ev *api.GadgetEvent) {
+	select {
+	case sub.events <- ev:
+	default:
+	}
+}
+
+func (sub *instanceSubscriber) sendPayload(ev *api.GadgetEvent) {
+	sub.seqLock.Lock()
+	defer sub.seqLock.Unlock()
+	sub.seq++
+	sub.send(&api.GadgetEvent{
+		Type:         ev.Type,
+		Seq:          sub.seq,
+		Payload:      ev.Payload,
+		DataSourceID: ev.DataSourceID,
+	})
+}
+
+// subscribe returns a subscriber receiving the gadget info followed by all payload events of the instance; its
+// channel is closed when the instance stops. Events are dropped if the subscriber can't keep up. If history is set,
+// the events buffered by a detached instance or by the buffer operator are sent first.
+func (i *gadgetInstance) subscribe(length uint64, history bool) *instanceSubscriber {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var buffered []*api.GadgetEvent
+	bufferedLen := 0
+	useBuffer := history && i.gadgetCtx != nil && buffer.Enabled(i.gadgetCtx)
+	switch {
+	case useBuffer:
+		for ds := range i.dsIDs {
+			n, _ := buffer.Len(i.gadgetCtx, ds)
+			bufferedLen += n
+		}
+	case history && i.history != nil:
+		buffered = i.history.all()
+		bufferedLen = len(buffered)
+	}
+	sub := &instanceSubscriber{events: make(chan *api.GadgetEvent, length+1+uint64(bufferedLen))}
+	if i.finished {
+		close(sub.events)
+		return sub
+	}
+	if i.gadgetInfo != nil {
+		sub.send(i.gadgetInfo)
+		if useBuffer {
+			sub.unsubscribeBuffers = make([]func(), 0, len(i.dsIDs))
+			for ds, dsID := range i.dsIDs {
+				unsubscribe, err := buffer.Subscribe(i.gadgetCtx, ds, func(ds datasource.DataSource, data datasource.Data) error {
+					sub.sendPayload(payloadEvent(dsID, data))
+					return nil
+				})
+				if err != nil {
+					i.gadgetCtx.Logger().Warnf("replaying buffered events of %q: %v", ds.Name(), err)
+					continue
+				}
+				sub.unsubscribeBuffers = append(sub.unsubscribeBuffers, unsubscribe)
+			}
+		}
+		for _, ev := range buffered {
+			sub.sendPayload(ev)
+		}
+	}
+	i.subscribers = append(i.subscribers, sub)
+	return sub
+}
+
+// stopBuffers makes sure no more events are sent to sub by the buffer operator, so that its channel can be closed
+func (sub *instanceSubscriber) stopBuffers() {
+	for _, unsubscribe := range sub.unsubscribeBuffers {
+		unsubscribe()
+	}
+}
+
+func (i *gadgetInstance) unsubscribe(sub *instanceSubscriber) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if idx := slices.Index(i.subscribers, sub); idx >= 0 {
+		i.subscribers = slices.Delete(i.subscribers, idx, idx+1)
+		sub.stopBuffers()
+		close(sub.events)
+	}
+}
+
+func (i *gadgetInstance) setGadgetInfo(ev *api.GadgetEvent) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.gadgetInfo = ev
+	for _, sub := range i.subscribers {
+		sub.send(ev)
+	}
+	close(i.initialized)
+}
+
+// setDataSources sets the data sources whose lost events are reported in the status of the instance, along with
+// their ids; if the buffer operator is enabled, it replaces the history of the instance
+func (i *gadgetInstance) setDataSources(gadgetCtx operators.GadgetContext, dsIDs map[string]uint32) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.gadgetCtx = gadgetCtx
+	i.dataSources = i.dataSources[:0]
+	i.dsIDs = make(map[datasource.DataSource]uint32)
+	for _, ds := range gadgetCtx.GetDataSources() {
+		i.dataSources = append(i.dataSources, ds)
+		i.dsIDs[ds] = dsIDs[ds.Name()]
+	}
+	if buffer.Enabled(gadgetCtx) {
+		i.history = nil
+	}
+}
+
+func (i *gadgetInstance) publish(ev *api.GadgetEvent) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	// Don't hand out payloads before subscribers know how to decode them
+	if i.gadgetInfo == nil {
+		return
+	}
+	if i.history != nil {
+		i.history.add(&api.GadgetEvent{
+			Type:         ev.Type,
+			Payload:      ev.Payload,
+			DataSourceID: ev.DataSourceID,
+		})
+	}
+	for _, sub := range i.subscribers {
+		if sub.unsubscribeBuffers != nil {
+			continue
+		}
+		sub.sendPayload(ev)
+	}
+}
+
+func (i *gadgetInstance) finish() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.finished = true
+	for _, sub := range i.subscribers {
+		sub.stopBuffers()
+		close(sub.events)
+	}
+	i.subscribers = nil
+}
+
+// status returns the information about the instance sent to clients
+func (i *gadgetInstance) status() *api.GadgetInstance {
+	res := proto.Clone(i.info).(*api.GadgetInstance)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.history != nil {
+		res.BufferedEvents = uint64(i.history.len())
+	}
+	if i.gadgetCtx != nil && buffer.Enabled(i.gadgetCtx) {
+		for _, ds := range i.dataSources {
+			n, _ := buffer.Len(i.gadgetCtx, ds)
+			res.BufferedEvents += uint64(n)
+		}
+	}
+	for _, ds := range i.dataSources {
+		res.LostEvents += ds.Stats().Lost
+	}
+	return res
+}
+
+func newGadgetInstance(name, imageName string, paramValues api.ParamValues) *gadgetInstance {
+	return &gadgetInstance{
+		info: &api.GadgetInstance{
+			Id:          uuid.New().String(),
+			Name:        name,
+			ImageName:   imageName,
+			ParamValues: maps.Clone(paramValues),
+			StartedAt:   time.Now().UnixNano(),
+		},
+		initialized: make(chan struct{}),
+	}
+}
+
+// operator returns an operator publishing all events of the gadget to the subscribers of the instance
+func (i *gadgetInstance) operator() operators.DataOperator {
+	return simple.New("svc",
+		simple.WithPriority(50000),
+		simple.OnInit(func(gadgetCtx operators.GadgetContext) error {
+			gadgetInfo, dsIDs, err := subscribeGadgetEvents(gadgetCtx, i.publish)
+			if err != nil {
+				return err
+			}
+			i.setDataSources(gadgetCtx, dsIDs)
+			i.setGadgetInfo(gadgetInfo)
+			return nil
+		}),
+	)
+}
+
+// registerInstance makes a running gadget available to ListGadgetInstances and AttachToGadgetInstance until
+// unregisterInstance is called
+func (s *Service) registerInstance(instance *gadgetInstance) {
+	s.gadgetInstancesLock.Lock()
+	defer s.gadgetInstancesLock.Unlock()
+	s.gadgetInstances[instance.info.Id] = instance
+}
+
+func (s *Service) getInstance(id string) (*gadgetInstance, error) {
+	s.gadgetInstancesLock.Lock()
+	defer s.gadgetInstancesLock.Unlock()
+	instance, ok := s.gadgetInstances[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "gadget instance %q not found", id)
+	}
+	return instance, nil
+}
+
+func (s *Service) unregisterInstance(instance *gadgetInstance) {
+	s.gadgetInstancesLock.Lock()
+	delete(s.gadgetInstances, instance.info.Id)
+	s.gadgetInstancesLock.Unlock()
+	instance.finish()
+}
+
+// payloadEvent returns the event sending data of the data source with the given id to clients
+func payloadEvent(dsID uint32, data datasource.Data) *api.GadgetEvent {
+	d, _ := proto.Marshal(datasource.Serializable(data))
+	return &api.GadgetEvent{
+		Type:         api.EventTypeGadgetPayload,
+		Payload:      d,
+		DataSourceID: dsID,
+	}
+}
+
+// subscribeGadgetEvents subscribes to all data sources of gadgetCtx and calls send with every payload event. It
+// returns the event carrying the serialized gadget info, which needs to be sent to clients before any payload, and
+// the ids of the data sources by name.
+func subscribeGadgetEvents(gadgetCtx operators.GadgetContext, send func(*api.GadgetEvent)) (*api.GadgetEvent, map[string]uint32, error) {
+	gi, err := gadgetCtx.SerializeGadgetInfo()
+	if err != nil {
+		return nil, nil, fmt.Errorf("serializing gadget info: %w", err)
+	}
+
+	// datasource mapping; we're sending an array of available DataSources including a
+	// DataSourceID; this ID will be used when sending actual data and needs to be remapped
+	// to the actual DataSource on the client later on
+	dsLookup := make(map[string]uint32)
+	for i, ds := range gi.DataSources {
+		ds.Id = uint32(i)
+		dsLookup[ds.Name] = ds.Id
+	}
+
+	// todo: skip DataSources we're not interested in
+
+	for _, ds := range gadgetCtx.GetDataSources() {
+		dsID := dsLookup[ds.Name()]
+		ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+			send(payloadEvent(dsID, data))
+			return nil
+		}, 1000000) // TODO: static int?
+	}
+
+	d, _ := proto.Marshal(gi)
+	return &api.GadgetEvent{
+		Type:    api.EventTypeGadgetInfo,
+		Payload: d,
+	}, dsLookup, nil
+}
+
+func (s *Service) ListGadgetInstances(ctx context.Context, req *api.ListGadgetInstancesRequest) (*api.ListGadgetInstancesResponse, error) {
+	s.gadgetInstancesLock.Lock()
+	instances := make([]*api.GadgetInstance, 0, len(s.gadgetInstances))
+	for _, instance := range s.gadgetInstances {
+		instances = append(instances, instance.status())
+	}
+	s.gadgetInstancesLock.Unlock()
+
+	slices.SortFunc(instances, func(a, b *api.GadgetInstance) int {
+		return cmp.Compare(a.StartedAt, b.StartedAt)
+	})
+	return &api.ListGadgetInstancesResponse{GadgetInstances: instances}, nil
+}
+
+func (s *Service) AttachToGadgetInstance(req *api.AttachToGadgetInstanceRequest, stream api.GadgetManager_AttachToGadgetInstanceServer) error {
+	if req.Version != api.VersionGadgetRunProtocol {
+		return fmt.Errorf("expected version to be %d, got %d", api.VersionGadgetRunProtocol, req.Version)
+	}
+
+	instance, err := s.getInstance(req.Id)
+	if err != nil {
+		return err
+	}
+
+	sub := instance.subscribe(s.eventBufferLength, req.History)
+	defer instance.unsubscribe(sub)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-sub.events:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Service) GetGadgetInstance(ctx context.Context, req *api.GetGadgetInstanceRequest) (*api.GadgetInstance, error) {
+	instance, err := s.getInstance(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return instance.status(), nil
+}
+
+// DeleteGadgetInstance stops a detached instance; other instances are stopped by the client that started them or
+// by changing the daemon configuration
+func (s *Service) DeleteGadgetInstance(ctx context.Context, req *api.DeleteGadgetInstanceRequest) (*api.DeleteGadgetInstanceResponse, error) {
+	instance, err := s.getInstance(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if !instance.info.Detached {
+		return nil, status.Errorf(codes.FailedPrecondition, "gadget instance %q is not detached", req.Id)
+	}
+	s.audit(AuditActionStop, grpcPeer(ctx), instance.info, nil)
+	instance.cancel()
+	<-instance.done
+	return &api.DeleteGadgetInstanceResponse{}, nil
+}
+
+// stopDetachedInstances stops all detached instances and waits for them to finish
+func (s *Service) stopDetachedInstances() {
+	s.gadgetInstancesLock.Lock()
+	var detached []*gadgetInstance
+	for _, instance := range s.gadgetInstances {
+		if instance.info.Detached {
+			detached = append(detached, instance)
+		}
+	}
+	s.gadgetInstancesLock.Unlock()
+
+	for _, instance := range detached {
+		instance.cancel()
+		<-instance.done
+	}
+}
+
+// runDetached starts the gadget of the request of client as a detached instance and returns once it has been
+// initialized
+func (s *Service) runDetached(request *api.GadgetRunRequest, client auditPeer) (*gadgetInstance, error) {
+	return s.startDetached(newGadgetInstance("", request.ImageName, request.ParamValues), request, client)
+}
+
+// startDetached runs the gadget of request as the given detached instance and returns once it has been initialized
+func (s *Service) startDetached(instance *gadgetInstance, request *api.GadgetRunRequest, client auditPeer) (*gadgetInstance, error) {
+	runtimeParams, err := s.runtimeParams(request.ParamValues)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	instance.info.Detached = true
+	instance.history = newEventRing(s.eventBufferLength)
+	instance.cancel = cancel
+	instance.done = make(chan struct{})
+	if request.Timeout > 0 {
+		instance.deadline = time.Now().Add(time.Duration(request.Timeout))
+	}
+
+	ops := make([]operators.DataOperator, 0)
+	for _, op := range operators.GetDataOperators() {
+		ops = append(ops, op)
+	}
+	ops = append(ops, instance.operator())
+
+	gadgetCtx := gadgetcontext.New(
+		ctx,
+		request.ImageName,
+		gadgetcontext.WithLogger(s.logger),
+		gadgetcontext.WithDataOperators(ops...),
+		gadgetcontext.WithTimeout(time.Duration(request.Timeout)),
+	)
+
+	s.registerInstance(instance)
+	auditEnd := s.auditRun(client, instance.info)
+
+	errs := make(chan error, 1)
+	go func() {
+		defer close(instance.done)
+		defer cancel()
+		defer s.unregisterInstance(instance)
+
+		s.logger.Infof("starting detached gadget instance %q (%s)", instance.info.Id, request.ImageName)
+		err := s.runtime.RunGadget(gadgetCtx, runtimeParams, request.ParamValues)
+		auditEnd(err)
+		if err != nil {
+			s.logger.Errorf("running detached gadget instance %q: %v", instance.info.Id, err)
+			errs <- err
+			return
+		}
+		s.logger.Infof("detached gadget instance %q stopped", instance.info.Id)
+	}()
+
+	select {
+	case <-instance.initialized:
+		return instance, nil
+	case <-instance.done:
+		select {
+		case err := <-errs:
+			return nil, err
+		case <-instance.initialized:
+			return instance, nil
+		default:
+			return nil, fmt.Errorf("gadget stopped before being initialized")
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/buffer"
+)
+
+func receiveEvents(sub *instanceSubscriber) []*api.GadgetEvent {
+	var res []*api.GadgetEvent
+	for {
+		select {
+		case ev, ok := <-sub.events:
+			if !ok {
+				return res
+			}
+			res = append(res, ev)
+		default:
+			return res
+		}
+	}
+}
+
+func TestGadgetInstanceSubscribers(t *testing.T) {
+	instance := newGadgetInstance("", "trace_exec", nil)
+	info := &api.GadgetEvent{Type: api.EventTypeGadgetInfo}
+	payload := &api.GadgetEvent{Type: api.EventTypeGadgetPayload, Payload: []byte("data")}
+
+	// Payloads aren't forwarded before the gadget info is known
+	early := instance.subscribe(2, false)
+	instance.publish(payload)
+	require.Empty(t, receiveEvents(early))
+
+	instance.setGadgetInfo(info)
+	instance.publish(payload)
+	events := receiveEvents(early)
+	require.Len(t, events, 2)
+	require.Equal(t, info, events[0])
+	require.Equal(t, uint32(1), events[1].Seq)
+
+	// Late subscribers get the gadget info first and their own sequence numbers
+	late := instance.subscribe(2, false)
+	instance.publish(payload)
+	events = receiveEvents(late)
+	require.Len(t, events, 2)
+	require.Equal(t, info, events[0])
+	require.Equal(t, uint32(1), events[1].Seq)
+	require.Equal(t, []byte("data"), events[1].Payload)
+
+	// Events are dropped for slow subscribers, which is visible by the gap in sequence numbers
+	for i := 0; i < 3; i++ {
+		instance.publish(payload)
+	}
+	require.Len(t, receiveEvents(early), 3)
+	instance.publish(payload)
+	events = receiveEvents(early)
+	require.Len(t, events, 1)
+	require.Equal(t, uint32(6), events[0].Seq)
+
+	instance.unsubscribe(late)
+	receiveEvents(late)
+	_, ok := <-late.events
+	require.False(t, ok)
+
+	instance.finish()
+	_, ok = <-early.events
+	require.False(t, ok)
+	_, ok = <-instance.subscribe(2, false).events
+	require.False(t, ok)
+}
+
+func TestListGadgetInstances(t *testing.T) {
+	s := NewService(nil, 16)
+
+	first := newGadgetInstance("exec", "trace_exec", api.ParamValues{"operator.oci.verify-image": "false"})
+	s.registerInstance(first)
+	second := newGadgetInstance("", "trace_open", nil)
+	s.registerInstance(second)
+
+	res, err := s.ListGadgetInstances(context.Background(), &api.ListGadgetInstancesRequest{})
+	require.NoError(t, err)
+	require.Len(t, res.GadgetInstances, 2)
+	require.ElementsMatch(t, []string{first.info.Id, second.info.Id},
+		[]string{res.GadgetInstances[0].Id, res.GadgetInstances[1].Id})
+	require.Equal(t, "exec", first.info.Name)
+	require.Equal(t, "trace_exec", first.info.ImageName)
+
+	s.unregisterInstance(first)
+	res, err = s.ListGadgetInstances(context.Background(), &api.ListGadgetInstancesRequest{})
+	require.NoError(t, err)
+	require.Len(t, res.GadgetInstances, 1)
+	require.Equal(t, second.info.Id, res.GadgetInstances[0].Id)
+
+	_, err = s.GetGadgetInstance(context.Background(), &api.GetGadgetInstanceRequest{Id: first.info.Id})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	res2, err := s.GetGadgetInstance(context.Background(), &api.GetGadgetInstanceRequest{Id: second.info.Id})
+	require.NoError(t, err)
+	require.Equal(t, "trace_open", res2.ImageName)
+
+	// Only detached instances can be deleted
+	_, err = s.DeleteGadgetInstance(context.Background(), &api.DeleteGadgetInstanceRequest{Id: second.info.Id})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestGadgetInstanceHistory(t *testing.T) {
+	instance := newGadgetInstance("", "trace_exec", nil)
+	instance.history = newEventRing(3)
+	instance.setGadgetInfo(&api.GadgetEvent{Type: api.EventTypeGadgetInfo})
+	for i := 0; i < 5; i++ {
+		instance.publish(&api.GadgetEvent{Type: api.EventTypeGadgetPayload, Payload: []byte{byte(i)}})
+	}
+	require.Equal(t, uint64(3), instance.status().BufferedEvents)
+
+	// Without history, only new events are received
+	require.Len(t, receiveEvents(instance.subscribe(2, false)), 1)
+
+	// The most recent events are sent after the gadget info, oldest first
+	events := receiveEvents(instance.subscribe(2, true))
+	require.Len(t, events, 4)
+	require.Equal(t, api.EventTypeGadgetInfo, events[0].Type)
+	for i, ev := range events[1:] {
+		require.Equal(t, []byte{byte(i + 2)}, ev.Payload)
+		require.Equal(t, uint32(i+1), ev.Seq)
+	}
+}
+
+func TestGadgetInstanceLostEvents(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "trace_exec")
+	exec, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "exec")
+	require.NoError(t, err)
+	open, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "open")
+	require.NoError(t, err)
+
+	instance := newGadgetInstance("", "trace_exec", nil)
+	instance.setDataSources(gadgetCtx, nil)
+	require.Zero(t, instance.status().LostEvents)
+
+	exec.ReportLostDataReason(3, datasource.LostReasonBufferFull)
+	open.ReportLostData(2)
+	require.Equal(t, uint64(5), instance.status().LostEvents)
+}
+
+func TestGadgetInstanceBufferOperator(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "trace_exec")
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "exec")
+	require.NoError(t, err)
+	pid, err := ds.AddField("pid", datasource.WithKind(api.Kind_Uint32))
+	require.NoError(t, err)
+
+	var bufferOp operators.DataOperator
+	for _, op := range operators.GetDataOperators() {
+		if op.Name() == buffer.OperatorName {
+			bufferOp = op
+		}
+	}
+	require.NotNil(t, bufferOp)
+	inst, err := bufferOp.InstantiateDataOperator(gadgetCtx, api.ParamValues{buffer.ParamMaxEvents: "2"})
+	require.NoError(t, err)
+	require.NoError(t, inst.(operators.PreStart).PreStart(gadgetCtx))
+
+	instance := newGadgetInstance("", "trace_exec", nil)
+	instance.history = newEventRing(16)
+	gadgetInfo, dsIDs, err := subscribeGadgetEvents(gadgetCtx, instance.publish)
+	require.NoError(t, err)
+	instance.setDataSources(gadgetCtx, dsIDs)
+	instance.setGadgetInfo(gadgetInfo)
+	require.Nil(t, instance.history)
+
+	emit := func(value uint32) {
+		data := ds.NewData()
+		require.NoError(t, pid.Set(data, make([]byte, 4)))
+		pid.PutUint32(data, value)
+		require.NoError(t, ds.EmitAndRelease(data))
+	}
+	pids := func(events []*api.GadgetEvent) []uint32 {
+		var res []uint32
+		for _, ev := range events[1:] {
+			data := ds.NewData()
+			require.NoError(t, proto.Unmarshal(ev.Payload, data.Raw()))
+			res = append(res, pid.Uint32(data))
+		}
+		return res
+	}
+
+	for i := uint32(1); i <= 3; i++ {
+		emit(i)
+	}
+	require.Equal(t, uint64(2), instance.status().BufferedEvents)
+
+	// The buffered events are replayed, followed by new ones without duplicates
+	sub := instance.subscribe(4, true)
+	emit(4)
+	events := receiveEvents(sub)
+	require.Equal(t, []uint32{2, 3, 4}, pids(events))
+	require.Equal(t, uint32(3), events[3].Seq)
+
+	instance.unsubscribe(sub)
+	emit(5)
+	_, ok := <-sub.events
+	require.False(t, ok)
+}
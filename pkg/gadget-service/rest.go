@@ -0,0 +1,413 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+// The REST API is a thin translation layer for clients that can't easily use gRPC (curl based automation, simple web
+// UIs). It offers the following endpoints:
+//
+//	POST   /gadgets/run               run a gadget; body (application/json): {"image": "...", "params": {...}, "timeout": "10s"}
+//	GET    /instances                 list gadget instances started using the REST API
+//	DELETE /instances/{id}            stop a gadget instance
+//	GET    /instances/{id}/events     websocket streaming the events of a gadget instance as JSON
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	jsonformatter "github.com/inspektor-gadget/inspektor-gadget/pkg/datasource/formatters/json"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/simple"
+)
+
+const (
+	// restSubscriberBuffer is the number of events buffered for each websocket client; if a client is too slow,
+	// events are dropped
+	restSubscriberBuffer = 1024
+
+	restWriteTimeout = 10 * time.Second
+
+	// restFinishedRetention is how long finished instances are still listed, so clients can learn about their errors
+	restFinishedRetention = 5 * time.Minute
+)
+
+type restRunRequest struct {
+	Image   string            `json:"image"`
+	Params  map[string]string `json:"params,omitempty"`
+	Timeout string            `json:"timeout,omitempty"`
+}
+
+type restInstanceInfo struct {
+	ID        string            `json:"id"`
+	Image     string            `json:"image"`
+	Params    map[string]string `json:"params,omitempty"`
+	StartedAt time.Time         `json:"startedAt"`
+	Running   bool              `json:"running"`
+	Error     string            `json:"error,omitempty"`
+}
+
+type restInstance struct {
+	restInstanceInfo
+
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	subscribers []chan []byte
+	finishedAt  time.Time
+}
+
+// auditInfo returns the instance as recorded in the audit log
+func (i *restInstance) auditInfo() *api.GadgetInstance {
+	return &api.GadgetInstance{
+		Id:          i.ID,
+		ImageName:   i.Image,
+		ParamValues: i.Params,
+	}
+}
+
+type restEvent struct {
+	DataSource string          `json:"dataSource"`
+	Data       json.RawMessage `json:"data"`
+}
+
+func (i *restInstance) subscribe() chan []byte {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	ch := make(chan []byte, restSubscriberBuffer)
+	if !i.Running {
+		close(ch)
+		return ch
+	}
+	i.subscribers = append(i.subscribers, ch)
+	return ch
+}
+
+func (i *restInstance) unsubscribe(ch chan []byte) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if idx := slices.Index(i.subscribers, ch); idx >= 0 {
+		i.subscribers = slices.Delete(i.subscribers, idx, idx+1)
+		close(ch)
+	}
+}
+
+func (i *restInstance) publish(ev []byte) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, ch := range i.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func (i *restInstance) finish(err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.Running = false
+	i.finishedAt = time.Now()
+	if err != nil {
+		i.Error = err.Error()
+	}
+	for _, ch := range i.subscribers {
+		close(ch)
+	}
+	i.subscribers = nil
+}
+
+type restServer struct {
+	service   *Service
+	upgrader  websocket.Upgrader
+	now       func() time.Time
+	mu        sync.Mutex
+	instances map[string]*restInstance
+}
+
+func newRESTServer(s *Service) *restServer {
+	return &restServer{
+		service:   s,
+		now:       time.Now,
+		instances: make(map[string]*restInstance),
+	}
+}
+
+// prune removes instances that finished more than restFinishedRetention ago; mu must be held
+func (rs *restServer) prune() {
+	for id, instance := range rs.instances {
+		instance.mu.Lock()
+		expired := !instance.Running && rs.now().Sub(instance.finishedAt) > restFinishedRetention
+		instance.mu.Unlock()
+		if expired {
+			delete(rs.instances, id)
+		}
+	}
+}
+
+func (rs *restServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /gadgets/run", rs.runGadget)
+	mux.HandleFunc("GET /instances", rs.listInstances)
+	mux.HandleFunc("DELETE /instances/{id}", rs.deleteInstance)
+	mux.HandleFunc("GET /instances/{id}/events", rs.instanceEvents)
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (rs *restServer) runGadget(w http.ResponseWriter, r *http.Request) {
+	// Browsers can send requests with other content types to any site without asking it first, so this makes sure
+	// that web pages can't run gadgets using the browser of a user having access to the API
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("expected content type application/json"))
+		return
+	}
+
+	req := &restRunRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %w", err))
+		return
+	}
+	if req.Image == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no image given"))
+		return
+	}
+	var timeout time.Duration
+	if req.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(req.Timeout)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout: %w", err))
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	instance := &restInstance{
+		restInstanceInfo: restInstanceInfo{
+			ID:        uuid.New().String(),
+			Image:     req.Image,
+			Params:    req.Params,
+			StartedAt: time.Now(),
+			Running:   true,
+		},
+		cancel: cancel,
+	}
+
+	// Forward all events of all data sources to the subscribers of the instance
+	rest := simple.New("rest",
+		simple.WithPriority(50000),
+		simple.OnInit(func(gadgetCtx operators.GadgetContext) error {
+			for _, ds := range gadgetCtx.GetDataSources() {
+				formatter, err := jsonformatter.New(ds, jsonformatter.WithShowAll(true))
+				if err != nil {
+					return fmt.Errorf("initializing JSON formatter: %w", err)
+				}
+				ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+					ev, _ := json.Marshal(&restEvent{
+						DataSource: ds.Name(),
+						Data:       slices.Clone(formatter.Marshal(data)),
+					})
+					instance.publish(ev)
+					return nil
+				}, 1000000)
+			}
+			return nil
+		}),
+	)
+
+	ops := make([]operators.DataOperator, 0)
+	for _, op := range operators.GetDataOperators() {
+		ops = append(ops, op)
+	}
+	ops = append(ops, rest)
+
+	gadgetCtx := gadgetcontext.New(
+		ctx,
+		req.Image,
+		gadgetcontext.WithLogger(rs.service.logger),
+		gadgetcontext.WithDataOperators(ops...),
+		gadgetcontext.WithTimeout(timeout),
+	)
+
+	paramValues := rs.service.paramValues(req.Params)
+	runtimeParams := rs.service.runtime.ParamDescs().ToParams()
+	runtimeParams.CopyFromMap(paramValues, "runtime.")
+
+	rs.mu.Lock()
+	rs.prune()
+	rs.instances[instance.ID] = instance
+	rs.mu.Unlock()
+
+	auditEnd := rs.service.auditRun(httpPeer(r), instance.auditInfo())
+	go func() {
+		err := rs.service.runtime.RunGadget(gadgetCtx, runtimeParams, paramValues)
+		auditEnd(err)
+		if err != nil {
+			rs.service.logger.Warnf("running gadget instance %q: %v", instance.ID, err)
+		}
+		instance.finish(err)
+		cancel()
+	}()
+
+	writeJSON(w, http.StatusCreated, map[string]string{"id": instance.ID})
+}
+
+func (rs *restServer) getInstance(id string) *restInstance {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.instances[id]
+}
+
+func (rs *restServer) listInstances(w http.ResponseWriter, r *http.Request) {
+	rs.mu.Lock()
+	rs.prune()
+	instances := make([]*restInstance, 0, len(rs.instances))
+	for _, instance := range rs.instances {
+		instances = append(instances, instance)
+	}
+	rs.mu.Unlock()
+
+	slices.SortFunc(instances, func(a, b *restInstance) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+
+	res := make([]restInstanceInfo, 0, len(instances))
+	for _, instance := range instances {
+		instance.mu.Lock()
+		res = append(res, instance.restInstanceInfo)
+		instance.mu.Unlock()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (rs *restServer) deleteInstance(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	rs.mu.Lock()
+	instance, ok := rs.instances[id]
+	delete(rs.instances, id)
+	rs.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("instance %q not found", id))
+		return
+	}
+	rs.service.audit(AuditActionStop, httpPeer(r), instance.auditInfo(), nil)
+	instance.cancel()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rs *restServer) instanceEvents(w http.ResponseWriter, r *http.Request) {
+	instance := rs.getInstance(r.PathValue("id"))
+	if instance == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("instance %q not found", r.PathValue("id")))
+		return
+	}
+
+	conn, err := rs.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already replied with an error
+		return
+	}
+	defer conn.Close()
+
+	ch := instance.subscribe()
+	defer instance.unsubscribe(ch)
+
+	// Detect clients going away; we don't expect any messages from them
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case ev, ok := <-ch:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, "gadget instance stopped"),
+					time.Now().Add(restWriteTimeout))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(restWriteTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, ev); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (rs *restServer) stopAll() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for id, instance := range rs.instances {
+		instance.cancel()
+		delete(rs.instances, id)
+	}
+}
+
+// serveREST serves the REST API using listener until ctx is done; if tlsConfig is set, clients have to use TLS
+func (s *Service) serveREST(ctx context.Context, listener net.Listener, tlsConfig *tls.Config) error {
+	rs := newRESTServer(s)
+	server := &http.Server{
+		Handler:           rs.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+		rs.stopAll()
+	}()
+
+	s.logger.Infof("serving REST API at %q", listener.Addr())
+	err := server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRESTErrors(t *testing.T) {
+	rs := newRESTServer(NewService(log.StandardLogger(), 1))
+	handler := rs.handler()
+
+	type testCase struct {
+		method      string
+		path        string
+		contentType string
+		body        string
+		status      int
+	}
+	tests := []testCase{
+		{method: http.MethodPost, path: "/gadgets/run", contentType: "application/json", body: "{", status: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/gadgets/run", contentType: "application/json", body: "{}", status: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/gadgets/run", contentType: "application/json; charset=utf-8", body: `{"image": "trace_exec", "timeout": "soon"}`, status: http.StatusBadRequest},
+		// Requests that browsers send cross-origin without a preflight request are rejected
+		{method: http.MethodPost, path: "/gadgets/run", body: `{"image": "trace_exec"}`, status: http.StatusUnsupportedMediaType},
+		{method: http.MethodPost, path: "/gadgets/run", contentType: "text/plain", body: `{"image": "trace_exec"}`, status: http.StatusUnsupportedMediaType},
+		{method: http.MethodGet, path: "/gadgets/run", status: http.StatusMethodNotAllowed},
+		{method: http.MethodDelete, path: "/instances/unknown", status: http.StatusNotFound},
+		{method: http.MethodGet, path: "/instances/unknown/events", status: http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, tc.status, rec.Code, "%s %s", tc.method, tc.path)
+	}
+}
+
+func TestRESTInstances(t *testing.T) {
+	rs := newRESTServer(NewService(log.StandardLogger(), 1))
+	handler := rs.handler()
+
+	_, cancel := context.WithCancel(context.Background())
+	instance := &restInstance{
+		restInstanceInfo: restInstanceInfo{
+			ID:        "test",
+			Image:     "trace_exec",
+			StartedAt: time.Now(),
+			Running:   true,
+		},
+		cancel: cancel,
+	}
+	rs.instances[instance.ID] = instance
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/instances", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var instances []restInstanceInfo
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &instances))
+	require.Len(t, instances, 1)
+	require.Equal(t, "test", instances[0].ID)
+	require.True(t, instances[0].Running)
+
+	// Subscribers are notified when the instance finishes
+	ch := instance.subscribe()
+	instance.publish([]byte("event"))
+	instance.finish(nil)
+	require.Equal(t, []byte("event"), <-ch)
+	_, ok := <-ch
+	require.False(t, ok)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/instances/test", nil))
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Empty(t, rs.instances)
+}
+
+func TestRESTPruneInstances(t *testing.T) {
+	rs := newRESTServer(NewService(log.StandardLogger(), 1))
+	now := time.Now()
+	rs.now = func() time.Time { return now }
+
+	for _, id := range []string{"running", "finished"} {
+		rs.instances[id] = &restInstance{
+			restInstanceInfo: restInstanceInfo{ID: id, Running: true},
+			cancel:           func() {},
+		}
+	}
+	rs.instances["finished"].finish(nil)
+
+	// Finished instances are kept for a while, so that clients can see how they ended
+	rs.prune()
+	require.Len(t, rs.instances, 2)
+
+	now = now.Add(restFinishedRetention + time.Second)
+	rs.prune()
+	require.Len(t, rs.instances, 1)
+	require.Contains(t, rs.instances, "running")
+}
@@ -0,0 +1,352 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+)
+
+// DefaultScheduleDir is where the status and outputs of scheduled runs are stored unless configured otherwise
+const DefaultScheduleDir = "/var/lib/ig/schedules"
+
+const (
+	defaultScheduleTimeout  = time.Minute
+	defaultScheduleKeepRuns = 10
+
+	scheduleStatusFile = "status.json"
+	scheduleRunsDir    = "runs"
+	scheduleOutputFile = "events.json"
+
+	// runDirFormat names the directories of runs so that they sort by time
+	runDirFormat = "20060102T150405Z"
+
+	// fileSinkPathParam makes the filesink operator write the events of a scheduled run to its directory
+	fileSinkPathParam = "operator.filesink.filesink-path"
+)
+
+// ScheduleConfig describes a gadget that is run periodically by the daemon
+type ScheduleConfig struct {
+	Name   string            `yaml:"name"`
+	Image  string            `yaml:"image"`
+	Params map[string]string `yaml:"params"`
+
+	// Schedule in cron format (minute, hour, day of month, month and day of week), e.g. "0 2 * * mon-fri", or one of
+	// @hourly, @daily, @weekly, @monthly and @yearly
+	Schedule string `yaml:"schedule"`
+
+	// TimeZone the schedule is evaluated in, e.g. "Europe/Berlin"; defaults to the local time zone of the daemon
+	TimeZone string `yaml:"timeZone"`
+
+	// Timeout stops runs of gadgets that don't finish by themselves, like tracers; defaults to 1m
+	Timeout time.Duration `yaml:"timeout"`
+
+	// KeepRuns is the number of runs whose outputs are kept; defaults to 10
+	KeepRuns int `yaml:"keepRuns"`
+}
+
+func (c *ScheduleConfig) equal(other *ScheduleConfig) bool {
+	return c.Name == other.Name && c.Image == other.Image && maps.Equal(c.Params, other.Params) &&
+		c.Schedule == other.Schedule && c.TimeZone == other.TimeZone && c.Timeout == other.Timeout &&
+		c.KeepRuns == other.KeepRuns
+}
+
+func (c *ScheduleConfig) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("schedule without name")
+	}
+	// The name is used as directory name
+	if strings.ContainsRune(c.Name, filepath.Separator) || c.Name == "." || c.Name == ".." {
+		return fmt.Errorf("schedule %q: invalid name", c.Name)
+	}
+	if c.Image == "" {
+		return fmt.Errorf("schedule %q: no image given", c.Name)
+	}
+	if _, err := parseCron(c.Schedule); err != nil {
+		return fmt.Errorf("schedule %q: %w", c.Name, err)
+	}
+	if _, err := time.LoadLocation(c.TimeZone); err != nil {
+		return fmt.Errorf("schedule %q: invalid time zone: %w", c.Name, err)
+	}
+	if c.Timeout < 0 || c.KeepRuns < 0 {
+		return fmt.Errorf("schedule %q: timeout and keepRuns must not be negative", c.Name)
+	}
+	return nil
+}
+
+// ScheduleRun describes a single run of a scheduled gadget
+type ScheduleRun struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Error string    `json:"error,omitempty"`
+
+	// Output is the directory holding the events of the run
+	Output string `json:"output"`
+}
+
+// ScheduleStatus is stored as status.json in the directory of a schedule, so it's kept across restarts of the daemon
+type ScheduleStatus struct {
+	LastRun  *ScheduleRun `json:"lastRun,omitempty"`
+	NextRun  time.Time    `json:"nextRun"`
+	Runs     uint64       `json:"runs"`
+	Failures uint64       `json:"failures"`
+}
+
+// scheduledGadget runs a gadget whenever its schedule matches
+type scheduledGadget struct {
+	config      ScheduleConfig
+	scheduleDir string
+	timeout     time.Duration
+	keepRuns    int
+	cron        *cronSchedule
+	location    *time.Location
+	dir         string
+	paramValues api.ParamValues
+	logger      logger.Logger
+
+	// run runs the gadget until it's done or ctx is canceled
+	run func(ctx context.Context, paramValues api.ParamValues) error
+
+	status ScheduleStatus
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newScheduledGadget(config ScheduleConfig, scheduleDir string, defaults api.ParamValues, logger logger.Logger) (*scheduledGadget, error) {
+	cron, err := parseCron(config.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	location, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	g := &scheduledGadget{
+		config:      config,
+		scheduleDir: scheduleDir,
+		timeout:     cmp.Or(config.Timeout, defaultScheduleTimeout),
+		keepRuns:    cmp.Or(config.KeepRuns, defaultScheduleKeepRuns),
+		cron:        cron,
+		location:    location,
+		dir:         filepath.Join(cmp.Or(scheduleDir, DefaultScheduleDir), config.Name),
+		paramValues: mergeParams(defaults, config.Params),
+		logger:      logger,
+	}
+	if err := g.loadStatus(); err != nil {
+		g.logger.Warnf("loading status of schedule %q: %v", config.Name, err)
+	}
+	return g, nil
+}
+
+func (g *scheduledGadget) loadStatus() error {
+	return readStatusFile(g.dir, &g.status)
+}
+
+func (g *scheduledGadget) saveStatus() error {
+	return writeStatusFile(g.dir, &g.status)
+}
+
+func (g *scheduledGadget) pruneRuns() error {
+	return pruneRunDirs(filepath.Join(g.dir, scheduleRunsDir), g.keepRuns)
+}
+
+// readStatusFile reads the status file of a schedule or trigger from dir, if it exists
+func readStatusFile(dir string, status any) error {
+	content, err := os.ReadFile(filepath.Join(dir, scheduleStatusFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, status)
+}
+
+// writeStatusFile replaces the status file in dir atomically, so that readers never see a partial one
+func writeStatusFile(dir string, status any) error {
+	content, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, scheduleStatusFile)
+	if err := os.WriteFile(path+".tmp", content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// pruneRunDirs removes the outputs of all but the keep most recent runs in runsDir
+func pruneRunDirs(runsDir string, keep int) error {
+	entries, err := os.ReadDir(runsDir)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names[:max(len(names)-keep, 0)] {
+		if err := os.RemoveAll(filepath.Join(runsDir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runOnce runs the gadget, storing its events in a new directory, and records the result in the status
+func (g *scheduledGadget) runOnce(ctx context.Context, now time.Time) {
+	run := &ScheduleRun{
+		Start:  now,
+		Output: filepath.Join(g.dir, scheduleRunsDir, now.UTC().Format(runDirFormat)),
+	}
+
+	err := os.MkdirAll(run.Output, 0o700)
+	if err == nil {
+		paramValues := maps.Clone(g.paramValues)
+		if _, ok := paramValues[fileSinkPathParam]; !ok {
+			paramValues[fileSinkPathParam] = filepath.Join(run.Output, scheduleOutputFile)
+		}
+
+		g.logger.Infof("starting scheduled gadget %q (%s)", g.config.Name, g.config.Image)
+		runCtx, cancel := context.WithTimeout(ctx, g.timeout)
+		err = g.run(runCtx, paramValues)
+		cancel()
+		// Reaching the timeout is the usual way for gadgets like tracers to stop
+		if err == nil || errors.Is(err, context.DeadlineExceeded) {
+			err = ctx.Err()
+		}
+	}
+
+	run.End = time.Now()
+	g.status.LastRun = run
+	g.status.Runs++
+	if err != nil {
+		run.Error = err.Error()
+		g.status.Failures++
+		g.logger.Errorf("running scheduled gadget %q: %v", g.config.Name, err)
+	} else {
+		g.logger.Infof("scheduled gadget %q finished, output stored in %q", g.config.Name, run.Output)
+	}
+
+	if err := g.saveStatus(); err != nil {
+		g.logger.Warnf("saving status of schedule %q: %v", g.config.Name, err)
+	}
+	if err := g.pruneRuns(); err != nil {
+		g.logger.Warnf("removing old runs of schedule %q: %v", g.config.Name, err)
+	}
+}
+
+// loop waits for the next time matching the schedule and runs the gadget until ctx is canceled
+func (g *scheduledGadget) loop(ctx context.Context) {
+	for {
+		next := g.cron.next(time.Now().In(g.location))
+		if next.IsZero() {
+			g.logger.Warnf("schedule %q never matches", g.config.Name)
+			return
+		}
+		g.status.NextRun = next
+		if err := g.saveStatus(); err != nil {
+			g.logger.Warnf("saving status of schedule %q: %v", g.config.Name, err)
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		g.runOnce(ctx, time.Now().In(g.location))
+	}
+}
+
+func (g *scheduledGadget) stop() {
+	g.cancel()
+	<-g.done
+}
+
+func (s *Service) startSchedule(config ScheduleConfig, daemonConfig *DaemonConfig) (*scheduledGadget, error) {
+	g, err := newScheduledGadget(config, daemonConfig.ScheduleDir, daemonConfig.DefaultParams, s.logger)
+	if err != nil {
+		return nil, err
+	}
+	g.run = func(ctx context.Context, paramValues api.ParamValues) error {
+		return s.runManagedGadget(ctx, config.Name, config.Image, paramValues)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g.cancel = cancel
+	g.done = make(chan struct{})
+	go func() {
+		defer close(g.done)
+		g.loop(ctx)
+	}()
+	return g, nil
+}
+
+// applySchedules restarts the schedules that changed in newConfig, or all of them if restartAll is set; a running
+// gadget of a restarted schedule is stopped
+func (s *Service) applySchedules(newConfig *DaemonConfig, restartAll bool) {
+	configs := make(map[string]*ScheduleConfig, len(newConfig.Schedules))
+	for i := range newConfig.Schedules {
+		configs[newConfig.Schedules[i].Name] = &newConfig.Schedules[i]
+	}
+	for name, g := range s.schedules {
+		config, ok := configs[name]
+		if ok && !restartAll && g.scheduleDir == newConfig.ScheduleDir && config.equal(&g.config) {
+			continue
+		}
+		g.stop()
+		delete(s.schedules, name)
+	}
+	for _, schedule := range newConfig.Schedules {
+		if _, ok := s.schedules[schedule.Name]; ok {
+			continue
+		}
+		g, err := s.startSchedule(schedule, newConfig)
+		if err != nil {
+			// The schedule has been validated by ReadConfig already
+			s.logger.Errorf("starting schedule %q: %v", schedule.Name, err)
+			continue
+		}
+		s.schedules[schedule.Name] = g
+	}
+}
+
+func (s *Service) stopSchedules() {
+	for name, g := range s.schedules {
+		g.stop()
+		delete(s.schedules, name)
+	}
+}
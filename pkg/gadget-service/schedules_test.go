@@ -0,0 +1,150 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+func TestReadConfigSchedules(t *testing.T) {
+	config, err := ReadConfig(strings.NewReader(`
+scheduleDir: /tmp/schedules
+schedules:
+- name: sockets
+  image: snapshot_socket
+  schedule: "@hourly"
+- name: dns
+  image: trace_dns
+  schedule: "0 2 * * mon-fri"
+  timeZone: UTC
+  timeout: 5m
+  keepRuns: 3
+`))
+	require.NoError(t, err)
+	require.Equal(t, "/tmp/schedules", config.ScheduleDir)
+	require.Len(t, config.Schedules, 2)
+	require.Equal(t, 5*time.Minute, config.Schedules[1].Timeout)
+	require.Equal(t, 3, config.Schedules[1].KeepRuns)
+
+	invalid := []string{
+		"schedules:\n- image: trace_exec\n  schedule: '@daily'",
+		"schedules:\n- name: exec\n  schedule: '@daily'",
+		"schedules:\n- name: exec\n  image: trace_exec",
+		"schedules:\n- name: exec\n  image: trace_exec\n  schedule: '0 25 * * *'",
+		"schedules:\n- name: exec\n  image: trace_exec\n  schedule: '@daily'\n  timeZone: Nowhere/Never",
+		"schedules:\n- name: a/b\n  image: trace_exec\n  schedule: '@daily'",
+		"schedules:\n- name: exec\n  image: trace_exec\n  schedule: '@daily'\n  keepRuns: -1",
+		"instances:\n- name: exec\n  image: trace_exec\nschedules:\n- name: exec\n  image: trace_exec\n  schedule: '@daily'",
+	}
+	for _, c := range invalid {
+		_, err := ReadConfig(strings.NewReader(c))
+		require.Error(t, err, c)
+	}
+}
+
+func TestScheduledGadgetRuns(t *testing.T) {
+	dir := t.TempDir()
+	config := ScheduleConfig{
+		Name:     "sockets",
+		Image:    "snapshot_socket",
+		Params:   map[string]string{"a": "b"},
+		Schedule: "@hourly",
+		Timeout:  time.Millisecond,
+		KeepRuns: 2,
+	}
+	g, err := newScheduledGadget(config, dir, api.ParamValues{"a": "default", "c": "d"}, log.StandardLogger())
+	require.NoError(t, err)
+
+	var runErr error
+	var params []api.ParamValues
+	g.run = func(ctx context.Context, paramValues api.ParamValues) error {
+		params = append(params, paramValues)
+		// Gadgets like tracers run until the timeout is reached
+		<-ctx.Done()
+		return runErr
+	}
+
+	start := time.Date(2024, time.March, 15, 10, 0, 0, 0, time.UTC)
+	g.runOnce(context.Background(), start)
+	require.Len(t, params, 1)
+	output := filepath.Join(dir, "sockets", "runs", "20240315T100000Z")
+	require.Equal(t, api.ParamValues{
+		"a":               "b",
+		"c":               "d",
+		fileSinkPathParam: filepath.Join(output, "events.json"),
+	}, params[0])
+	require.DirExists(t, output)
+	require.Equal(t, uint64(1), g.status.Runs)
+	require.Zero(t, g.status.Failures)
+	require.Equal(t, output, g.status.LastRun.Output)
+	require.Empty(t, g.status.LastRun.Error)
+
+	runErr = errors.New("failed")
+	g.runOnce(context.Background(), start.Add(time.Hour))
+	g.runOnce(context.Background(), start.Add(2*time.Hour))
+	require.Equal(t, uint64(3), g.status.Runs)
+	require.Equal(t, uint64(2), g.status.Failures)
+	require.Equal(t, "failed", g.status.LastRun.Error)
+
+	// Only the most recent runs are kept
+	entries, err := os.ReadDir(filepath.Join(dir, "sockets", "runs"))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.NoDirExists(t, output)
+
+	// The status is kept across restarts
+	g, err = newScheduledGadget(config, dir, nil, log.StandardLogger())
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), g.status.Runs)
+	require.Equal(t, uint64(2), g.status.Failures)
+	require.Equal(t, "failed", g.status.LastRun.Error)
+}
+
+func TestScheduledGadgetCanceled(t *testing.T) {
+	g, err := newScheduledGadget(ScheduleConfig{
+		Name:     "sockets",
+		Image:    "snapshot_socket",
+		Schedule: "* * * * *",
+	}, t.TempDir(), nil, log.StandardLogger())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	g.run = func(ctx context.Context, paramValues api.ParamValues) error {
+		close(started)
+		<-ctx.Done()
+		return nil
+	}
+	go func() {
+		<-started
+		cancel()
+	}()
+	g.runOnce(ctx, time.Now())
+
+	// Runs interrupted by stopping the schedule aren't successful
+	require.Equal(t, uint64(1), g.status.Failures)
+	require.NotEmpty(t, g.status.LastRun.Error)
+}
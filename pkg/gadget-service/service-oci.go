@@ -17,11 +17,8 @@ package gadgetservice
 import (
 	"context"
 	"fmt"
-	"sync"
 	"time"
 
-	"google.golang.org/protobuf/proto"
-
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
 	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
@@ -51,6 +48,10 @@ func (s *Service) GetGadgetInfo(ctx context.Context, req *api.GetGadgetInfoReque
 }
 
 func (s *Service) RunGadget(runGadget api.GadgetManager_RunGadgetServer) error {
+	if s.workerDone != nil {
+		defer s.workerDone()
+	}
+
 	ctrl, err := runGadget.Recv()
 	if err != nil {
 		return err
@@ -72,22 +73,54 @@ func (s *Service) RunGadget(runGadget api.GadgetManager_RunGadgetServer) error {
 		fallbackLogger: s.logger,
 	})
 
+	ociRequest.ParamValues = s.paramValues(ociRequest.ParamValues)
+
 	for k, v := range ociRequest.ParamValues {
 		logger.Debugf("param %s: %s", k, v)
 	}
 
-	done := make(chan bool)
+	client := grpcPeer(runGadget.Context())
+
+	if ociRequest.Detach {
+		instance, err := s.runDetached(ociRequest, client)
+		if err != nil {
+			return err
+		}
+		return runGadget.Send(&api.GadgetEvent{
+			Type:    api.EventTypeGadgetJobID,
+			Payload: []byte(instance.info.Id),
+		})
+	}
+
+	if s.workerCommand != nil {
+		return s.runGadgetInWorker(runGadget, ociRequest, client)
+	}
+
+	// Payload events are buffered in a queue; if the client enabled flow control, they're only sent as long as it
+	// has credits left
+	queue, err := newEventQueue(s.eventBufferLength, ociRequest.Credits, ociRequest.FlowControlPolicy)
+	if err != nil {
+		return err
+	}
 	defer func() {
-		done <- true
+		queue.close()
+		if dropped := queue.droppedEvents(); dropped > 0 {
+			s.logger.Debugf("dropped %d events because the client was too slow", dropped)
+		}
+		if shed := queue.shedEvents(); shed > 0 {
+			s.logger.Debugf("shed %d events of low priority data sources because the client was too slow", shed)
+		}
 	}()
 
+	// Other clients can attach to the instance while it's running
+	instance := newGadgetInstance("", ociRequest.ImageName, ociRequest.ParamValues)
+	s.registerInstance(instance)
+	defer s.unregisterInstance(instance)
+
 	// Build a simple operator that subscribes to all events and forwards them
 	svc := simple.New("svc",
 		simple.WithPriority(50000),
 		simple.OnInit(func(gadgetCtx operators.GadgetContext) error {
-			// Create payload buffer
-			outputBuffer := make(chan *api.GadgetEvent, s.eventBufferLength)
-
 			log := gadgetCtx.Logger()
 
 			go func() {
@@ -102,8 +135,11 @@ func (s *Service) RunGadget(runGadget api.GadgetManager_RunGadgetServer) error {
 					switch msg.Event.(type) {
 					case *api.GadgetControlRequest_StopRequest:
 						log.Debugf("received stop request")
+						s.audit(AuditActionStop, client, instance.info, nil)
 						gadgetCtx.Cancel()
 						return
+					case *api.GadgetControlRequest_CreditRequest:
+						queue.grant(msg.GetCreditRequest().Credits)
 					default:
 						s.logger.Warn("received unexpected request")
 					}
@@ -113,69 +149,35 @@ func (s *Service) RunGadget(runGadget api.GadgetManager_RunGadgetServer) error {
 			go func() {
 				// Message pump to handle slow readers
 				for {
-					select {
-					case ev := <-outputBuffer:
-						runGadget.Send(ev)
-					case <-done:
+					ev, ok := queue.pop()
+					if !ok {
 						return
 					}
+					runGadget.Send(ev)
 				}
 			}()
 
-			seq := uint32(0)
-			var seqLock sync.Mutex
-
-			gi, err := gadgetCtx.SerializeGadgetInfo()
+			gadgetInfo, dsIDs, err := subscribeGadgetEvents(gadgetCtx, func(event *api.GadgetEvent) {
+				instance.publish(event)
+				queue.push(event)
+			})
 			if err != nil {
-				return fmt.Errorf("serializing gadget info: %w", err)
+				return err
 			}
 
-			// datasource mapping; we're sending an array of available DataSources including a
-			// DataSourceID; this ID will be used when sending actual data and needs to be remapped
-			// to the actual DataSource on the client later on
-			dsLookup := make(map[string]uint32)
-			for i, ds := range gi.DataSources {
-				ds.Id = uint32(i)
-				dsLookup[ds.Name] = ds.Id
-			}
-
-			// todo: skip DataSources we're not interested in
-
+			priorities := make(map[uint32]datasource.PriorityClass)
 			for _, ds := range gadgetCtx.GetDataSources() {
-				dsID := dsLookup[ds.Name()]
-				ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
-					d, _ := proto.Marshal(data.Raw())
-
-					event := &api.GadgetEvent{
-						Type:         api.EventTypeGadgetPayload,
-						Payload:      d,
-						DataSourceID: dsID,
-					}
-
-					seqLock.Lock()
-					seq++
-					event.Seq = seq
-
-					// Try to send event; if outputBuffer is full, it will be dropped by taking
-					// the default path.
-					select {
-					case outputBuffer <- event:
-					default:
-					}
-					seqLock.Unlock()
-					return nil
-				}, 1000000) // TODO: static int?
+				priorities[dsIDs[ds.Name()]] = ds.PriorityClass()
 			}
+			queue.setPriorities(priorities)
 
 			// Send gadget information
-			d, _ := proto.Marshal(gi)
-			err = runGadget.Send(&api.GadgetEvent{
-				Type:    api.EventTypeGadgetInfo,
-				Payload: d,
-			})
+			err = runGadget.Send(gadgetInfo)
 			if err != nil {
 				s.logger.Warnf("sending gadgetInfo: %v", err)
 			}
+			instance.setDataSources(gadgetCtx, dsIDs)
+			instance.setGadgetInfo(gadgetInfo)
 			s.logger.Debugf("sent gadget info")
 
 			return nil
@@ -199,7 +201,9 @@ func (s *Service) RunGadget(runGadget api.GadgetManager_RunGadgetServer) error {
 	runtimeParams := s.runtime.ParamDescs().ToParams()
 	runtimeParams.CopyFromMap(ociRequest.ParamValues, "runtime.")
 
+	auditEnd := s.auditRun(client, instance.info)
 	err = s.runtime.RunGadget(gadgetCtx, runtimeParams, ociRequest.ParamValues)
+	auditEnd(err)
 	if err != nil {
 		return err
 	}
@@ -16,11 +16,13 @@ package gadgetservice
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"sync"
 	"syscall"
@@ -28,6 +30,7 @@ import (
 
 	"github.com/google/uuid"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 
 	"github.com/inspektor-gadget/inspektor-gadget/internal/version"
 	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
@@ -46,22 +49,68 @@ type RunConfig struct {
 	SocketType string
 
 	// SocketPath must be the path to a unix socket or ip:port, depending on
-	// SocketType
+	// SocketType. SocketType and SocketPath are ignored when systemd passes a
+	// listening socket to the daemon (socket activation).
 	SocketPath string
 
 	// If SocketGID != 0 and a unix socket is used, the ownership of that socket
 	// will be changed to the given SocketGID
 	SocketGID int
+
+	// If HandoffPath is set, a unix socket is created at that path to allow a new daemon to take over the listener
+	// of this one (e.g. when upgrading). Before creating a new listener, Run will also try to take over the listener
+	// of a daemon that is already running using that path.
+	HandoffPath string
+
+	// If ConfigPath is set, the daemon configuration is read from that file and reloaded whenever it changes or the
+	// daemon receives SIGHUP
+	ConfigPath string
+
+	// If RESTAddress (host:port) is set, a REST API is served at that address in addition to the gRPC API
+	RESTAddress string
+
+	// If RESTTLSConfig is set, the REST API is served using TLS with this configuration, e.g. to require the same
+	// client certificates as the gRPC API
+	RESTTLSConfig *tls.Config
+
+	// If Worker is set, the service only handles a single gadget run using RunGadget and stops afterwards. It's used
+	// by the worker processes started in process isolation mode, see SetWorkerCommand.
+	Worker bool
+
+	// If AuditBackend is set, the records of the audit log are written to it; recent records can be queried in any
+	// case using QueryAuditLog
+	AuditBackend AuditBackend
 }
 
 type Service struct {
 	api.UnimplementedBuiltInGadgetManagerServer
 	api.UnimplementedGadgetManagerServer
+	api.UnimplementedConfigManagerServer
+	api.UnimplementedAuditManagerServer
 	listener          net.Listener
 	runtime           runtime.Runtime
 	logger            logger.Logger
 	servers           map[*grpc.Server]struct{}
 	eventBufferLength uint64
+
+	configPath string
+	reloadLock sync.Mutex
+	configLock sync.Mutex
+	config     *DaemonConfig
+	instances  map[string]*managedInstance
+	schedules  map[string]*scheduledGadget
+	triggers   map[string]*triggeredGadget
+
+	gadgetInstancesLock sync.Mutex
+	gadgetInstances     map[string]*gadgetInstance
+
+	auditLog *auditLog
+
+	// workerCommand creates the command of worker processes when process isolation is enabled
+	workerCommand func(socketPath string) *exec.Cmd
+
+	// workerDone is called after a gadget run finished when running as a worker
+	workerDone func()
 }
 
 func NewService(defaultLogger logger.Logger, length uint64) *Service {
@@ -69,6 +118,12 @@ func NewService(defaultLogger logger.Logger, length uint64) *Service {
 		servers:           map[*grpc.Server]struct{}{},
 		logger:            defaultLogger,
 		eventBufferLength: length,
+		config:            &DaemonConfig{},
+		instances:         map[string]*managedInstance{},
+		schedules:         map[string]*scheduledGadget{},
+		triggers:          map[string]*triggeredGadget{},
+		gadgetInstances:   map[string]*gadgetInstance{},
+		auditLog:          newAuditLog(),
 	}
 }
 
@@ -218,6 +273,13 @@ func (s *Service) RunBuiltInGadget(runGadget api.BuiltInGadgetManager_RunBuiltIn
 	)
 	defer gadgetCtx.Cancel()
 
+	client := grpcPeer(runGadget.Context())
+	auditInstance := &api.GadgetInstance{
+		Id:          runID,
+		ImageName:   request.GadgetCategory + "/" + request.GadgetName,
+		ParamValues: request.Params,
+	}
+
 	// Handle commands sent by the client
 	go func() {
 		defer func() {
@@ -231,6 +293,7 @@ func (s *Service) RunBuiltInGadget(runGadget api.BuiltInGadgetManager_RunBuiltIn
 			}
 			switch msg.Event.(type) {
 			case *api.BuiltInGadgetControlRequest_StopRequest:
+				s.audit(AuditActionStop, client, auditInstance, nil)
 				gadgetCtx.Cancel()
 				return
 			default:
@@ -240,7 +303,9 @@ func (s *Service) RunBuiltInGadget(runGadget api.BuiltInGadgetManager_RunBuiltIn
 	}()
 
 	// Hand over to runtime
+	auditEnd := s.auditRun(client, auditInstance)
 	results, err := runtime.RunBuiltInGadget(gadgetCtx)
+	auditEnd(err)
 	if err != nil {
 		return fmt.Errorf("running gadget: %w", err)
 	}
@@ -295,6 +360,18 @@ func newUnixListener(address string, gid int) (net.Listener, error) {
 }
 
 func (s *Service) Run(runConfig RunConfig, serverOptions ...grpc.ServerOption) error {
+	if runConfig.AuditBackend != nil {
+		s.auditLog.setBackend(runConfig.AuditBackend)
+		// Closed last, after the end of all running gadgets has been recorded
+		defer s.auditLog.close()
+	}
+
+	if runConfig.Worker {
+		if err := applyWorkerImagePolicy(); err != nil {
+			return err
+		}
+	}
+
 	s.runtime = local.New()
 	defer s.runtime.Close()
 
@@ -305,30 +382,148 @@ func (s *Service) Run(runConfig RunConfig, serverOptions ...grpc.ServerOption) e
 		return fmt.Errorf("initializing runtime: %w", err)
 	}
 
-	switch runConfig.SocketType {
-	case "unix":
-		listener, err := newUnixListener(runConfig.SocketPath, runConfig.SocketGID)
+	if runConfig.Worker {
+		// Applied once the runtime has set up the host, so a malicious gadget can't make use of all privileges of
+		// the daemon
+		if err := confineWorker(); err != nil {
+			return fmt.Errorf("confining worker: %w", err)
+		}
+	}
+
+	defer s.stopDetachedInstances()
+
+	if runConfig.ConfigPath != "" {
+		s.configPath = runConfig.ConfigPath
+		if _, err := s.reloadConfig(); err != nil {
+			return err
+		}
+		defer s.stopInstances()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if err := s.watchConfig(ctx); err != nil {
+			return fmt.Errorf("watching config: %w", err)
+		}
+	}
+
+	var handoffConn *net.UnixConn
+	var handoffManifest *HandoffManifest
+	if runConfig.HandoffPath != "" {
+		listener, conn, manifest, err := requestHandoff(runConfig.HandoffPath, runConfig)
 		if err != nil {
-			return fmt.Errorf("creating unix listener: %w", err)
+			return fmt.Errorf("taking over from running daemon: %w", err)
+		}
+		if listener != nil {
+			s.logger.Infof("taking over listener from daemon version %s", manifest.Version)
+			s.listener = listener
+			handoffConn = conn
+			handoffManifest = manifest
 		}
-		s.listener = listener
-	case "tcp":
-		listener, err := net.Listen(runConfig.SocketType, runConfig.SocketPath)
+	}
+
+	if s.listener == nil {
+		listener, err := activationListener()
 		if err != nil {
-			return fmt.Errorf("creating listener: %w", err)
+			return err
+		}
+		if listener != nil {
+			s.logger.Infof("using socket %q passed by systemd", listener.Addr())
+			s.listener = listener
 		}
-		s.listener = listener
-	default:
-		return fmt.Errorf("invalid socket type: %s", runConfig.SocketType)
+	}
+
+	if s.listener == nil {
+		switch runConfig.SocketType {
+		case "unix":
+			listener, err := newUnixListener(runConfig.SocketPath, runConfig.SocketGID)
+			if err != nil {
+				return fmt.Errorf("creating unix listener: %w", err)
+			}
+			s.listener = listener
+		case "tcp":
+			listener, err := net.Listen(runConfig.SocketType, runConfig.SocketPath)
+			if err != nil {
+				return fmt.Errorf("creating listener: %w", err)
+			}
+			s.listener = listener
+		default:
+			return fmt.Errorf("invalid socket type: %s", runConfig.SocketType)
+		}
+	}
+
+	if runConfig.RESTAddress != "" {
+		restListener, err := net.Listen("tcp", runConfig.RESTAddress)
+		if err != nil {
+			return fmt.Errorf("creating REST listener: %w", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			if err := s.serveREST(ctx, restListener, runConfig.RESTTLSConfig); err != nil {
+				s.logger.Errorf("serving REST API: %v", err)
+			}
+		}()
 	}
 
 	server := grpc.NewServer(serverOptions...)
 	api.RegisterBuiltInGadgetManagerServer(server, s)
 	api.RegisterGadgetManagerServer(server, s)
+	api.RegisterConfigManagerServer(server, s)
+	api.RegisterAuditManagerServer(server, s)
+
+	// Allow clients to discover the API, e.g. to generate clients in other languages
+	reflection.Register(server)
 
 	s.servers[server] = struct{}{}
 
-	return server.Serve(s.listener)
+	if runConfig.HandoffPath != "" {
+		handoffListener, err := newHandoffListener(runConfig.HandoffPath)
+		if err != nil {
+			return fmt.Errorf("creating handoff listener: %w", err)
+		}
+		defer handoffListener.Close()
+
+		go func() {
+			if err := s.serveHandoff(handoffListener, runConfig); err != nil {
+				return
+			}
+			s.logger.Infof("handed over listener to new daemon, waiting for running gadgets to finish")
+			s.notifyStopping()
+
+			// The new daemon is running them now
+			s.stopDetachedInstances()
+
+			// The socket files now belong to the new daemon
+			if ul, ok := handoffListener.(*net.UnixListener); ok {
+				ul.SetUnlinkOnClose(false)
+			}
+			if ul, ok := s.listener.(*net.UnixListener); ok {
+				ul.SetUnlinkOnClose(false)
+			}
+			server.GracefulStop()
+		}()
+	}
+
+	if handoffConn != nil {
+		// Only acknowledge once we're ready to accept connections; the old daemon keeps running its detached
+		// instances until then
+		s.adoptInstances(handoffManifest.Instances)
+		if err := acknowledgeHandoff(handoffConn); err != nil {
+			s.logger.Warnf("acknowledging handoff: %v", err)
+		}
+	}
+
+	if runConfig.Worker {
+		s.workerDone = sync.OnceFunc(func() {
+			go server.GracefulStop()
+		})
+	}
+
+	s.notifyReady()
+
+	// Make the credentials of clients connecting to a unix socket available to the audit log
+	return server.Serve(&peerCredListener{Listener: s.listener})
 }
 
 func (s *Service) Close() {
@@ -0,0 +1,64 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/coreos/go-systemd/v22/activation"
+	"github.com/coreos/go-systemd/v22/daemon"
+)
+
+// activationListener returns the listener systemd passed to the daemon when it was started using socket activation
+// (LISTEN_FDS). If the daemon wasn't socket activated, nil is returned without an error.
+func activationListener() (net.Listener, error) {
+	listeners, err := activation.Listeners()
+	if err != nil {
+		return nil, fmt.Errorf("getting listeners passed by systemd: %w", err)
+	}
+	switch len(listeners) {
+	case 0:
+		return nil, nil
+	case 1:
+		if listeners[0] == nil {
+			return nil, errors.New("socket passed by systemd is not a listening socket")
+		}
+		return listeners[0], nil
+	default:
+		for _, l := range listeners {
+			if l != nil {
+				l.Close()
+			}
+		}
+		return nil, fmt.Errorf("expected one socket from systemd, got %d", len(listeners))
+	}
+}
+
+// notifyReady tells systemd that the daemon is ready to accept connections. It does nothing if the daemon isn't
+// run as a systemd service of type "notify".
+func (s *Service) notifyReady() {
+	if _, err := daemon.SdNotify(false, daemon.SdNotifyReady); err != nil {
+		s.logger.Warnf("notifying systemd: %v", err)
+	}
+}
+
+// notifyStopping tells systemd that the daemon is shutting down
+func (s *Service) notifyStopping() {
+	if _, err := daemon.SdNotify(false, daemon.SdNotifyStopping); err != nil {
+		s.logger.Warnf("notifying systemd: %v", err)
+	}
+}
@@ -0,0 +1,490 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+)
+
+// DefaultTriggerDir is where the status and outputs of triggered runs are stored unless configured otherwise
+const DefaultTriggerDir = "/var/lib/ig/triggers"
+
+const (
+	defaultTriggerFor         = 30 * time.Second
+	defaultTriggerInterval    = 5 * time.Second
+	defaultTriggerMaxDuration = 10 * time.Minute
+	defaultTriggerKeepRuns    = 10
+
+	// Reasons for stopping a triggered run
+	stopReasonCleared     = "pressure cleared"
+	stopReasonMaxDuration = "max duration reached"
+	stopReasonFinished    = "gadget finished"
+	stopReasonStopped     = "trigger stopped"
+)
+
+// pressureResources are the resources the kernel reports pressure stall information (PSI) for
+var pressureResources = []string{"cpu", "memory", "io"}
+
+// TriggerConfig describes a gadget that is run by the daemon while a resource is under pressure, e.g. top_block-io
+// while processes are stalled on I/O for more than 20% of the time
+type TriggerConfig struct {
+	Name   string            `yaml:"name"`
+	Image  string            `yaml:"image"`
+	Params map[string]string `yaml:"params"`
+
+	// Resource whose pressure is watched: cpu, memory or io
+	Resource string `yaml:"resource"`
+
+	// Cgroup whose pressure is watched, given as path relative to the cgroup v2 root, e.g. "kubepods.slice"; the
+	// pressure of the whole system is watched if it's empty
+	Cgroup string `yaml:"cgroup"`
+
+	// Full uses the share of time in which all tasks were stalled instead of the one in which some were
+	Full bool `yaml:"full"`
+
+	// Threshold is the share of time in percent tasks have to be stalled for the pressure to be high
+	Threshold float64 `yaml:"threshold"`
+
+	// For is how long the pressure has to stay above the threshold to start the gadget; defaults to 30s
+	For time.Duration `yaml:"for"`
+
+	// ClearAfter is how long the pressure has to stay below the threshold to stop the gadget; defaults to For
+	ClearAfter time.Duration `yaml:"clearAfter"`
+
+	// Interval in which the pressure is measured; defaults to 5s
+	Interval time.Duration `yaml:"interval"`
+
+	// MaxDuration stops the gadget even if the pressure stays high; defaults to 10m
+	MaxDuration time.Duration `yaml:"maxDuration"`
+
+	// KeepRuns is the number of runs whose outputs are kept; defaults to 10
+	KeepRuns int `yaml:"keepRuns"`
+}
+
+func (c *TriggerConfig) equal(other *TriggerConfig) bool {
+	return c.Name == other.Name && c.Image == other.Image && maps.Equal(c.Params, other.Params) &&
+		c.Resource == other.Resource && c.Cgroup == other.Cgroup && c.Full == other.Full &&
+		c.Threshold == other.Threshold && c.For == other.For && c.ClearAfter == other.ClearAfter &&
+		c.Interval == other.Interval && c.MaxDuration == other.MaxDuration && c.KeepRuns == other.KeepRuns
+}
+
+func (c *TriggerConfig) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("trigger without name")
+	}
+	// The name is used as directory name
+	if strings.ContainsRune(c.Name, filepath.Separator) || c.Name == "." || c.Name == ".." {
+		return fmt.Errorf("trigger %q: invalid name", c.Name)
+	}
+	if c.Image == "" {
+		return fmt.Errorf("trigger %q: no image given", c.Name)
+	}
+	if !slices.Contains(pressureResources, c.Resource) {
+		return fmt.Errorf("trigger %q: invalid resource %q, expected one of %s", c.Name, c.Resource,
+			strings.Join(pressureResources, ", "))
+	}
+	if c.Cgroup != "" && !filepath.IsLocal(c.Cgroup) {
+		return fmt.Errorf("trigger %q: cgroup %q must be relative to the cgroup root", c.Name, c.Cgroup)
+	}
+	if c.Threshold <= 0 || c.Threshold > 100 {
+		return fmt.Errorf("trigger %q: threshold must be greater than 0 and at most 100", c.Name)
+	}
+	if c.For < 0 || c.ClearAfter < 0 || c.Interval < 0 || c.MaxDuration < 0 || c.KeepRuns < 0 {
+		return fmt.Errorf("trigger %q: durations and keepRuns must not be negative", c.Name)
+	}
+	return nil
+}
+
+// pressurePath returns the file holding the pressure stall information of the configured resource
+func (c *TriggerConfig) pressurePath() string {
+	if c.Cgroup == "" {
+		return filepath.Join(host.HostProcFs, "pressure", c.Resource)
+	}
+	return filepath.Join(host.HostRoot, "sys/fs/cgroup", c.Cgroup, c.Resource+".pressure")
+}
+
+// readPressureTotal returns the total time in microseconds some or, if full is set, all tasks were stalled as given
+// by a PSI file like /proc/pressure/io
+func readPressureTotal(path string, full bool) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	prefix := "some "
+	if full {
+		prefix = "full "
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), prefix)
+		if !ok {
+			continue
+		}
+		for _, field := range strings.Fields(line) {
+			if value, ok := strings.CutPrefix(field, "total="); ok {
+				return strconv.ParseUint(value, 10, 64)
+			}
+		}
+		return 0, fmt.Errorf("no total in %q", scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no %q line in %s", strings.TrimSpace(prefix), path)
+}
+
+// pressureDetector tells when the pressure has been above the threshold for long enough to start the gadget, and
+// when it has been below it for long enough to stop it again
+type pressureDetector struct {
+	threshold  float64
+	forDur     time.Duration
+	clearAfter time.Duration
+
+	lastTime  time.Time
+	lastTotal uint64
+	// since is when the pressure crossed the threshold in the direction that would change active
+	since  time.Time
+	active bool
+}
+
+// update takes the total stall time at now and returns the pressure since the last update in percent, and whether
+// active changed; the first update only records the total
+func (d *pressureDetector) update(now time.Time, total uint64) (float64, bool) {
+	lastTime, lastTotal := d.lastTime, d.lastTotal
+	d.lastTime, d.lastTotal = now, total
+	if lastTime.IsZero() || !now.After(lastTime) || total < lastTotal {
+		return 0, false
+	}
+
+	pressure := float64(total-lastTotal) / (float64(now.Sub(lastTime)) / float64(time.Microsecond)) * 100
+	// While inactive, the detector is waiting for the pressure to rise, and for it to fall while active
+	crossed := pressure > d.threshold
+	wait := d.forDur
+	if d.active {
+		crossed = !crossed
+		wait = d.clearAfter
+	}
+	if !crossed {
+		d.since = time.Time{}
+		return pressure, false
+	}
+	if d.since.IsZero() {
+		// The pressure has been like this since the previous measurement
+		d.since = lastTime
+	}
+	if now.Sub(d.since) < wait {
+		return pressure, false
+	}
+	d.active = !d.active
+	d.since = time.Time{}
+	return pressure, true
+}
+
+// TriggerRun describes a single run of a triggered gadget
+type TriggerRun struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Error string    `json:"error,omitempty"`
+
+	// Pressure in percent that started the run
+	Pressure float64 `json:"pressure"`
+
+	// StopReason tells why the run ended, e.g. because the pressure cleared
+	StopReason string `json:"stopReason"`
+
+	// Output is the directory holding the events of the run
+	Output string `json:"output"`
+}
+
+// TriggerStatus is stored as status.json in the directory of a trigger, so it's kept across restarts of the daemon
+type TriggerStatus struct {
+	// Pressure is the most recent measurement in percent
+	Pressure float64 `json:"pressure"`
+
+	// Active is set while the pressure is high
+	Active bool `json:"active"`
+
+	LastRun  *TriggerRun `json:"lastRun,omitempty"`
+	Runs     uint64      `json:"runs"`
+	Failures uint64      `json:"failures"`
+}
+
+// triggerRun is a run of a triggered gadget in progress
+type triggerRun struct {
+	run    *TriggerRun
+	cancel context.CancelFunc
+	// reason is set when the run is stopped by the trigger
+	reason string
+	done   chan error
+}
+
+// triggeredGadget runs a gadget while the pressure of a resource is high
+type triggeredGadget struct {
+	config      TriggerConfig
+	triggerDir  string
+	dir         string
+	path        string
+	interval    time.Duration
+	maxDuration time.Duration
+	keepRuns    int
+	paramValues api.ParamValues
+	logger      logger.Logger
+	detector    *pressureDetector
+
+	// run runs the gadget until it's done or ctx is canceled
+	run func(ctx context.Context, paramValues api.ParamValues) error
+
+	status  TriggerStatus
+	current *triggerRun
+	// readFailed avoids logging the same error at every interval
+	readFailed bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newTriggeredGadget(config TriggerConfig, triggerDir string, defaults api.ParamValues, logger logger.Logger) *triggeredGadget {
+	forDur := cmp.Or(config.For, defaultTriggerFor)
+	g := &triggeredGadget{
+		config:      config,
+		triggerDir:  triggerDir,
+		dir:         filepath.Join(cmp.Or(triggerDir, DefaultTriggerDir), config.Name),
+		path:        config.pressurePath(),
+		interval:    cmp.Or(config.Interval, defaultTriggerInterval),
+		maxDuration: cmp.Or(config.MaxDuration, defaultTriggerMaxDuration),
+		keepRuns:    cmp.Or(config.KeepRuns, defaultTriggerKeepRuns),
+		paramValues: mergeParams(defaults, config.Params),
+		logger:      logger,
+		detector: &pressureDetector{
+			threshold:  config.Threshold,
+			forDur:     forDur,
+			clearAfter: cmp.Or(config.ClearAfter, forDur),
+		},
+	}
+	if err := readStatusFile(g.dir, &g.status); err != nil {
+		g.logger.Warnf("loading status of trigger %q: %v", config.Name, err)
+	}
+	// Runs are never resumed after a restart; the pressure has to rise again
+	g.status.Active = false
+	return g
+}
+
+func (g *triggeredGadget) saveStatus() {
+	if err := writeStatusFile(g.dir, &g.status); err != nil {
+		g.logger.Warnf("saving status of trigger %q: %v", g.config.Name, err)
+	}
+}
+
+// startRun starts the gadget, storing its events in a new directory
+func (g *triggeredGadget) startRun(ctx context.Context, now time.Time, pressure float64) {
+	run := &TriggerRun{
+		Start:    now,
+		Pressure: pressure,
+		Output:   filepath.Join(g.dir, scheduleRunsDir, now.UTC().Format(runDirFormat)),
+	}
+	current := &triggerRun{run: run, done: make(chan error, 1)}
+	g.current = current
+
+	if err := os.MkdirAll(run.Output, 0o700); err != nil {
+		current.cancel = func() {}
+		current.done <- err
+		return
+	}
+	paramValues := maps.Clone(g.paramValues)
+	if _, ok := paramValues[fileSinkPathParam]; !ok {
+		paramValues[fileSinkPathParam] = filepath.Join(run.Output, scheduleOutputFile)
+	}
+
+	g.logger.Infof("%s pressure at %.1f%%, starting triggered gadget %q (%s)", g.config.Resource, pressure,
+		g.config.Name, g.config.Image)
+	runCtx, cancel := context.WithTimeout(ctx, g.maxDuration)
+	current.cancel = cancel
+	go func() {
+		current.done <- g.run(runCtx, paramValues)
+	}()
+}
+
+// stopRun stops the running gadget, if any, and waits for it to finish
+func (g *triggeredGadget) stopRun(reason string) {
+	if g.current == nil {
+		return
+	}
+	g.current.reason = reason
+	g.current.cancel()
+	g.finishRun(<-g.current.done)
+}
+
+// finishRun records the result of the current run, which has returned err
+func (g *triggeredGadget) finishRun(err error) {
+	current := g.current
+	g.current = nil
+	current.cancel()
+
+	run := current.run
+	run.End = time.Now()
+	run.StopReason = current.reason
+	switch {
+	case run.StopReason == stopReasonCleared:
+		// Canceling is the usual way to stop gadgets like tracers
+		if errors.Is(err, context.Canceled) {
+			err = nil
+		}
+	case run.StopReason == stopReasonStopped:
+		// Runs interrupted by stopping the trigger aren't successful
+		if err == nil {
+			err = context.Canceled
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		run.StopReason = stopReasonMaxDuration
+		err = nil
+	default:
+		run.StopReason = stopReasonFinished
+	}
+
+	g.status.LastRun = run
+	g.status.Runs++
+	if err != nil {
+		run.Error = err.Error()
+		g.status.Failures++
+		g.logger.Errorf("running triggered gadget %q: %v", g.config.Name, err)
+	} else {
+		g.logger.Infof("triggered gadget %q stopped (%s), output stored in %q", g.config.Name, run.StopReason, run.Output)
+	}
+
+	g.saveStatus()
+	if err := pruneRunDirs(filepath.Join(g.dir, scheduleRunsDir), g.keepRuns); err != nil {
+		g.logger.Warnf("removing old runs of trigger %q: %v", g.config.Name, err)
+	}
+}
+
+// check measures the pressure and starts or stops the gadget when the pressure rose or cleared
+func (g *triggeredGadget) check(ctx context.Context, now time.Time) {
+	total, err := readPressureTotal(g.path, g.config.Full)
+	if err != nil {
+		if !g.readFailed {
+			g.logger.Warnf("reading pressure of trigger %q: %v", g.config.Name, err)
+		}
+		g.readFailed = true
+		return
+	}
+	g.readFailed = false
+
+	pressure, changed := g.detector.update(now, total)
+	g.status.Pressure = pressure
+	if changed {
+		g.status.Active = g.detector.active
+		if g.detector.active {
+			g.startRun(ctx, now, pressure)
+		} else {
+			g.stopRun(stopReasonCleared)
+		}
+		g.saveStatus()
+	}
+}
+
+// loop measures the pressure every interval until ctx is canceled
+func (g *triggeredGadget) loop(ctx context.Context) {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	g.check(ctx, time.Now())
+	for {
+		var done chan error
+		if g.current != nil {
+			done = g.current.done
+		}
+		select {
+		case <-ctx.Done():
+			g.stopRun(stopReasonStopped)
+			g.status.Active = false
+			g.saveStatus()
+			return
+		case err := <-done:
+			// The gadget finished by itself or reached the max duration; it's only started again once the
+			// pressure cleared and rose again
+			g.finishRun(err)
+		case <-ticker.C:
+			g.check(ctx, time.Now())
+		}
+	}
+}
+
+func (g *triggeredGadget) stop() {
+	g.cancel()
+	<-g.done
+}
+
+func (s *Service) startTrigger(config TriggerConfig, daemonConfig *DaemonConfig) *triggeredGadget {
+	g := newTriggeredGadget(config, daemonConfig.TriggerDir, daemonConfig.DefaultParams, s.logger)
+	g.run = func(ctx context.Context, paramValues api.ParamValues) error {
+		return s.runManagedGadget(ctx, config.Name, config.Image, paramValues)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g.cancel = cancel
+	g.done = make(chan struct{})
+	go func() {
+		defer close(g.done)
+		g.loop(ctx)
+	}()
+	return g
+}
+
+// applyTriggers restarts the triggers that changed in newConfig, or all of them if restartAll is set; a running
+// gadget of a restarted trigger is stopped
+func (s *Service) applyTriggers(newConfig *DaemonConfig, restartAll bool) {
+	configs := make(map[string]*TriggerConfig, len(newConfig.Triggers))
+	for i := range newConfig.Triggers {
+		configs[newConfig.Triggers[i].Name] = &newConfig.Triggers[i]
+	}
+	for name, g := range s.triggers {
+		config, ok := configs[name]
+		if ok && !restartAll && g.triggerDir == newConfig.TriggerDir && config.equal(&g.config) {
+			continue
+		}
+		g.stop()
+		delete(s.triggers, name)
+	}
+	for _, trigger := range newConfig.Triggers {
+		if _, ok := s.triggers[trigger.Name]; ok {
+			continue
+		}
+		s.triggers[trigger.Name] = s.startTrigger(trigger, newConfig)
+	}
+}
+
+func (s *Service) stopTriggers() {
+	for name, g := range s.triggers {
+		g.stop()
+		delete(s.triggers, name)
+	}
+}
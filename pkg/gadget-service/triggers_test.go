@@ -0,0 +1,194 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+func TestReadConfigTriggers(t *testing.T) {
+	config, err := ReadConfig(strings.NewReader(`
+triggerDir: /tmp/triggers
+triggers:
+- name: io
+  image: top_block-io
+  resource: io
+  threshold: 20
+- name: memory
+  image: trace_oomkill
+  resource: memory
+  cgroup: kubepods.slice
+  full: true
+  threshold: 5.5
+  for: 1m
+  clearAfter: 2m
+  maxDuration: 5m
+`))
+	require.NoError(t, err)
+	require.Equal(t, "/tmp/triggers", config.TriggerDir)
+	require.Len(t, config.Triggers, 2)
+	require.Equal(t, 5.5, config.Triggers[1].Threshold)
+	require.Equal(t, 2*time.Minute, config.Triggers[1].ClearAfter)
+
+	invalid := []string{
+		"triggers:\n- image: top_block-io\n  resource: io\n  threshold: 20",
+		"triggers:\n- name: io\n  resource: io\n  threshold: 20",
+		"triggers:\n- name: io\n  image: top_block-io\n  resource: disk\n  threshold: 20",
+		"triggers:\n- name: io\n  image: top_block-io\n  resource: io",
+		"triggers:\n- name: io\n  image: top_block-io\n  resource: io\n  threshold: 101",
+		"triggers:\n- name: io\n  image: top_block-io\n  resource: io\n  threshold: 20\n  cgroup: ../escape",
+		"triggers:\n- name: io\n  image: top_block-io\n  resource: io\n  threshold: 20\n  for: -1s",
+		"schedules:\n- name: io\n  image: top_block-io\n  schedule: '@daily'\ntriggers:\n- name: io\n  image: top_block-io\n  resource: io\n  threshold: 20",
+	}
+	for _, c := range invalid {
+		_, err := ReadConfig(strings.NewReader(c))
+		require.Error(t, err, c)
+	}
+}
+
+func TestReadPressureTotal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "io")
+	require.NoError(t, os.WriteFile(path, []byte(
+		"some avg10=1.50 avg60=0.80 avg300=0.20 total=123456\nfull avg10=0.50 avg60=0.30 avg300=0.10 total=6543\n"), 0o644))
+
+	total, err := readPressureTotal(path, false)
+	require.NoError(t, err)
+	require.Equal(t, uint64(123456), total)
+	total, err = readPressureTotal(path, true)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6543), total)
+
+	// The cpu pressure of the whole system doesn't have a full line on older kernels
+	require.NoError(t, os.WriteFile(path, []byte("some avg10=0.00 avg60=0.00 avg300=0.00 total=1\n"), 0o644))
+	_, err = readPressureTotal(path, true)
+	require.Error(t, err)
+}
+
+func TestPressureDetector(t *testing.T) {
+	d := &pressureDetector{threshold: 20, forDur: 10 * time.Second, clearAfter: 15 * time.Second}
+	start := time.Date(2024, time.March, 15, 10, 0, 0, 0, time.UTC)
+	var total uint64
+	// step advances the time by 5s with tasks stalled for the given share of it
+	step := func(i int, share float64) (float64, bool) {
+		total += uint64(share * float64(5*time.Second/time.Microsecond))
+		return d.update(start.Add(time.Duration(i)*5*time.Second), total)
+	}
+
+	_, changed := step(0, 0)
+	require.False(t, changed)
+
+	pressure, changed := step(1, 0.5)
+	require.InDelta(t, 50, pressure, 0.01)
+	require.False(t, changed)
+	// A short drop restarts the wait
+	_, changed = step(2, 0.1)
+	require.False(t, changed)
+	_, changed = step(3, 0.5)
+	require.False(t, changed)
+	_, changed = step(4, 0.5)
+	require.True(t, changed)
+	require.True(t, d.active)
+
+	_, changed = step(5, 0)
+	require.False(t, changed)
+	_, changed = step(6, 0)
+	require.False(t, changed)
+	_, changed = step(7, 0)
+	require.True(t, changed)
+	require.False(t, d.active)
+}
+
+func TestTriggeredGadgetRuns(t *testing.T) {
+	dir := t.TempDir()
+	g := newTriggeredGadget(TriggerConfig{
+		Name:      "io",
+		Image:     "top_block-io",
+		Params:    map[string]string{"a": "b"},
+		Resource:  "io",
+		Threshold: 20,
+		For:       5 * time.Second,
+		KeepRuns:  1,
+	}, dir, api.ParamValues{"c": "d"}, log.StandardLogger())
+	g.path = filepath.Join(dir, "pressure")
+
+	var params []api.ParamValues
+	g.run = func(ctx context.Context, paramValues api.ParamValues) error {
+		params = append(params, paramValues)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	start := time.Date(2024, time.March, 15, 10, 0, 0, 0, time.UTC)
+	check := func(i int, total uint64) {
+		require.NoError(t, os.WriteFile(g.path, []byte(fmt.Sprintf("some avg10=0.00 avg60=0.00 avg300=0.00 total=%d\n", total)), 0o644))
+		g.check(context.Background(), start.Add(time.Duration(i)*5*time.Second))
+	}
+
+	check(0, 0)
+	require.Nil(t, g.current)
+	// Stalled for half of the time
+	check(1, 2500000)
+	require.NotNil(t, g.current)
+	require.True(t, g.status.Active)
+	require.InDelta(t, 50, g.status.Pressure, 0.01)
+
+	check(2, 2500000)
+	require.Nil(t, g.current)
+	require.False(t, g.status.Active)
+	require.Len(t, params, 1)
+
+	output := filepath.Join(dir, "io", "runs", "20240315T100005Z")
+	require.Equal(t, api.ParamValues{
+		"a":               "b",
+		"c":               "d",
+		fileSinkPathParam: filepath.Join(output, "events.json"),
+	}, params[0])
+	require.Equal(t, uint64(1), g.status.Runs)
+	require.Zero(t, g.status.Failures)
+	require.Equal(t, stopReasonCleared, g.status.LastRun.StopReason)
+	require.Equal(t, output, g.status.LastRun.Output)
+	require.InDelta(t, 50, g.status.LastRun.Pressure, 0.01)
+
+	// A gadget that fails is recorded as failure
+	g.run = func(ctx context.Context, paramValues api.ParamValues) error {
+		return fmt.Errorf("failed")
+	}
+	check(3, 5000000)
+	g.finishRun(<-g.current.done)
+	require.Equal(t, uint64(1), g.status.Failures)
+	require.Equal(t, "failed", g.status.LastRun.Error)
+	require.Equal(t, stopReasonFinished, g.status.LastRun.StopReason)
+
+	// Only the most recent runs are kept
+	entries, err := os.ReadDir(filepath.Join(dir, "io", "runs"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	// The status is kept across restarts
+	g = newTriggeredGadget(g.config, dir, nil, log.StandardLogger())
+	require.Equal(t, uint64(2), g.status.Runs)
+	require.Equal(t, "failed", g.status.LastRun.Error)
+}
@@ -0,0 +1,228 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/oci"
+)
+
+// workerExitTimeout is the time a worker gets to exit on its own after its gadget stopped
+const workerExitTimeout = 10 * time.Second
+
+// workerImagePolicyEnv passes the image policy of the daemon to its workers
+const workerImagePolicyEnv = "IG_WORKER_IMAGE_POLICY"
+
+// SetWorkerCommand enables process isolation: every gadget run using RunGadget is started in a separate worker
+// process created by cmd, so a crash or leak of one gadget doesn't affect the others. The command has to run a
+// Service with RunConfig.Worker set, listening on the given unix socket. Detached and config-managed instances are
+// still run by the daemon itself.
+func (s *Service) SetWorkerCommand(cmd func(socketPath string) *exec.Cmd) {
+	s.workerCommand = cmd
+}
+
+// workerEnv returns the environment of the daemon without the variables systemd uses to talk to it; the worker must
+// neither take over the activation socket nor report its status to systemd. The image policy of the daemon is added,
+// so that it is enforced by the worker as well.
+func workerEnv() ([]string, error) {
+	env := os.Environ()
+	env = slices.DeleteFunc(env, func(v string) bool {
+		return strings.HasPrefix(v, "LISTEN_") || strings.HasPrefix(v, "NOTIFY_SOCKET=") ||
+			strings.HasPrefix(v, workerImagePolicyEnv+"=")
+	})
+	if policy := oci.GetImagePolicy(); policy != nil {
+		encoded, err := json.Marshal(policy)
+		if err != nil {
+			return nil, fmt.Errorf("encoding image policy: %w", err)
+		}
+		env = append(env, workerImagePolicyEnv+"="+string(encoded))
+	}
+	return env, nil
+}
+
+// applyWorkerImagePolicy sets the image policy passed by the daemon
+func applyWorkerImagePolicy() error {
+	encoded, ok := os.LookupEnv(workerImagePolicyEnv)
+	if !ok {
+		return nil
+	}
+	policy := &oci.ImagePolicy{}
+	if err := json.Unmarshal([]byte(encoded), policy); err != nil {
+		return fmt.Errorf("decoding image policy: %w", err)
+	}
+	oci.SetImagePolicy(policy)
+	return nil
+}
+
+// startWorker starts a worker process listening on a unix socket in a new temporary directory. The returned channel
+// receives the result of the process once it exited.
+func (s *Service) startWorker() (*exec.Cmd, string, <-chan error, error) {
+	dir, err := os.MkdirTemp("", "ig-worker-")
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("creating worker directory: %w", err)
+	}
+	socketPath := filepath.Join(dir, "worker.socket")
+
+	env, err := workerEnv()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, "", nil, err
+	}
+
+	cmd := s.workerCommand(socketPath)
+	cmd.Env = env
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	// Don't leave workers behind if the daemon dies
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, "", nil, fmt.Errorf("starting worker: %w", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+		os.RemoveAll(dir)
+	}()
+	return cmd, socketPath, exited, nil
+}
+
+// runGadgetInWorker runs the gadget of request in a new worker process. Control messages of the client are
+// forwarded to the worker and its events back to the client.
+func (s *Service) runGadgetInWorker(runGadget api.GadgetManager_RunGadgetServer, request *api.GadgetRunRequest, client auditPeer) (err error) {
+	cmd, socketPath, exited, err := s.startWorker()
+	if err != nil {
+		return err
+	}
+	s.logger.Debugf("running gadget %q in worker process %d", request.ImageName, cmd.Process.Pid)
+
+	ctx, cancel := context.WithCancel(runGadget.Context())
+
+	// Stop forwarding as soon as the worker is gone
+	var exitErr error
+	done := make(chan struct{})
+	go func() {
+		exitErr = <-exited
+		close(done)
+		cancel()
+	}()
+
+	// Cancelling the stream to the worker stops its gadget; give it some time to clean up before killing it
+	defer func() {
+		select {
+		case <-done:
+		case <-time.After(workerExitTimeout):
+			s.logger.Warnf("worker process %d didn't exit, killing it", cmd.Process.Pid)
+			cmd.Process.Kill()
+			<-done
+		}
+	}()
+	defer cancel()
+
+	// workerError prefers reporting a crash of the worker over the resulting stream error
+	workerError := func(err error) error {
+		select {
+		case <-done:
+			if exitErr == nil {
+				return fmt.Errorf("worker process exited unexpectedly")
+			}
+			return fmt.Errorf("worker process: %w", exitErr)
+		case <-time.After(time.Second):
+			return fmt.Errorf("communicating with worker process: %w", err)
+		}
+	}
+
+	conn, err := grpc.NewClient("unix://"+socketPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("connecting to worker: %w", err)
+	}
+	defer conn.Close()
+
+	// The worker might not listen yet
+	worker, err := api.NewGadgetManagerClient(conn).RunGadget(ctx, grpc.WaitForReady(true))
+	if err != nil {
+		return workerError(err)
+	}
+	err = worker.Send(&api.GadgetControlRequest{Event: &api.GadgetControlRequest_RunRequest{RunRequest: request}})
+	if err != nil {
+		return workerError(err)
+	}
+
+	// Other clients can attach to the instance while it's running
+	instance := newGadgetInstance("", request.ImageName, request.ParamValues)
+	s.registerInstance(instance)
+	defer s.unregisterInstance(instance)
+
+	auditEnd := s.auditRun(client, instance.info)
+	defer func() {
+		auditEnd(err)
+	}()
+
+	go func() {
+		for {
+			msg, err := runGadget.Recv()
+			if err != nil {
+				cancel()
+				return
+			}
+			if msg.GetStopRequest() != nil {
+				s.audit(AuditActionStop, client, instance.info, nil)
+			}
+			if err := worker.Send(msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		ev, err := worker.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return workerError(err)
+		}
+		switch ev.Type {
+		case api.EventTypeGadgetInfo:
+			instance.setGadgetInfo(ev)
+		case api.EventTypeGadgetPayload:
+			instance.publish(ev)
+		}
+		if err := runGadget.Send(ev); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gadgetservice
+
+import (
+	"context"
+	"flag"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/oci"
+)
+
+// fakeWorker replies to a run request with the gadget info and a single payload containing the image name; it
+// crashes if the image name is "crash"
+type fakeWorker struct {
+	api.UnimplementedGadgetManagerServer
+	done chan struct{}
+}
+
+func (w *fakeWorker) RunGadget(runGadget api.GadgetManager_RunGadgetServer) error {
+	defer close(w.done)
+
+	ctrl, err := runGadget.Recv()
+	if err != nil {
+		return err
+	}
+	request := ctrl.GetRunRequest()
+	if request.ImageName == "crash" {
+		os.Exit(3)
+	}
+	if err := runGadget.Send(&api.GadgetEvent{Type: api.EventTypeGadgetInfo}); err != nil {
+		return err
+	}
+	return runGadget.Send(&api.GadgetEvent{Type: api.EventTypeGadgetPayload, Payload: []byte(request.ImageName)})
+}
+
+// TestWorkerProcess is run as worker process by TestRunGadgetInWorker
+func TestWorkerProcess(t *testing.T) {
+	if flag.NArg() != 1 {
+		t.Skip("only run as worker process")
+	}
+	listener, err := net.Listen("unix", flag.Arg(0))
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	worker := &fakeWorker{done: make(chan struct{})}
+	api.RegisterGadgetManagerServer(server, worker)
+	go func() {
+		<-worker.done
+		server.GracefulStop()
+	}()
+	require.NoError(t, server.Serve(listener))
+}
+
+type fakeRunGadgetServer struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events []*api.GadgetEvent
+}
+
+func (f *fakeRunGadgetServer) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fakeRunGadgetServer) Send(ev *api.GadgetEvent) error {
+	f.events = append(f.events, ev)
+	return nil
+}
+
+func (f *fakeRunGadgetServer) Recv() (*api.GadgetControlRequest, error) {
+	<-f.ctx.Done()
+	return nil, f.ctx.Err()
+}
+
+func TestRunGadgetInWorker(t *testing.T) {
+	s := NewService(logger.DefaultLogger(), 16)
+	s.SetWorkerCommand(func(socketPath string) *exec.Cmd {
+		return exec.Command(os.Args[0], "-test.run=^TestWorkerProcess$", "--", socketPath)
+	})
+
+	runGadget := &fakeRunGadgetServer{ctx: context.Background()}
+	err := s.runGadgetInWorker(runGadget, &api.GadgetRunRequest{ImageName: "trace_exec"}, auditPeer{})
+	require.NoError(t, err)
+	require.Len(t, runGadget.events, 2)
+	require.Equal(t, api.EventTypeGadgetInfo, runGadget.events[0].Type)
+	require.Equal(t, "trace_exec", string(runGadget.events[1].Payload))
+	require.Empty(t, s.gadgetInstances)
+
+	// A crashing worker must not affect the daemon
+	runGadget = &fakeRunGadgetServer{ctx: context.Background()}
+	err = s.runGadgetInWorker(runGadget, &api.GadgetRunRequest{ImageName: "crash"}, auditPeer{})
+	require.ErrorContains(t, err, "exit status 3")
+}
+
+func TestWorkerEnv(t *testing.T) {
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("NOTIFY_SOCKET", "/run/systemd/notify")
+	t.Setenv("IG_TEST", "1")
+
+	env, err := workerEnv()
+	require.NoError(t, err)
+	require.Contains(t, env, "IG_TEST=1")
+	require.NotContains(t, env, "LISTEN_FDS=1")
+	require.NotContains(t, env, "NOTIFY_SOCKET=/run/systemd/notify")
+}
+
+func TestWorkerImagePolicy(t *testing.T) {
+	oci.SetImagePolicy(&oci.ImagePolicy{AllowedRegistries: []string{"ghcr.io"}, RequireSignature: true})
+	defer oci.SetImagePolicy(nil)
+
+	env, err := workerEnv()
+	require.NoError(t, err)
+	for _, v := range env {
+		if name, value, _ := strings.Cut(v, "="); name == workerImagePolicyEnv {
+			t.Setenv(workerImagePolicyEnv, value)
+		}
+	}
+
+	oci.SetImagePolicy(nil)
+	require.NoError(t, applyWorkerImagePolicy())
+	require.Equal(t, &oci.ImagePolicy{AllowedRegistries: []string{"ghcr.io"}, RequireSignature: true}, oci.GetImagePolicy())
+}
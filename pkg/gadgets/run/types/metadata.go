@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/btf"
@@ -26,6 +27,8 @@ import (
 
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/btfhelpers"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/columns"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/kernelfeatures"
 	metadatav1 "github.com/inspektor-gadget/inspektor-gadget/pkg/metadata/v1"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
 )
@@ -44,6 +47,10 @@ const (
 	// Prefix used to mark snapshotters structs
 	snapshottersPrefix = "gadget_snapshotter_"
 
+	// Prefixes used to mark annotations of data sources and fields
+	dsAnnotationPrefix    = "gadget_ds_annotation_"
+	fieldAnnotationPrefix = "gadget_field_annotation_"
+
 	// Prefix used to mark tracer map created with GADGET_TRACER_MAP() defined in
 	// include/gadget/buffer.h.
 	TracerMapPrefix = "gadget_map_tracer_"
@@ -54,20 +61,30 @@ const (
 	IfaceParam = "iface"
 )
 
-// countDistImp returns the number of distinct implementations of tracers,
-// snapshotters and toppers that the gadget has.
-func countDistImp(m *metadatav1.GadgetMetadata) int {
-	count := 0
-	if len(m.Tracers) > 0 {
-		count++
+// validateNames checks that tracers, toppers and snapshotters don't use the
+// same name, as each of them provides a data source with that name.
+func validateNames(m *metadatav1.GadgetMetadata) error {
+	var result error
+
+	kinds := map[string]string{}
+	check := func(name, kind string) {
+		if other, ok := kinds[name]; ok {
+			result = multierror.Append(result, fmt.Errorf("%s %q has the same name as %s %q", kind, name, other, name))
+			return
+		}
+		kinds[name] = kind
 	}
-	if len(m.Snapshotters) > 0 {
-		count++
+	for name := range m.Tracers {
+		check(name, "tracer")
 	}
-	if len(m.Toppers) > 0 {
-		count++
+	for name := range m.Toppers {
+		check(name, "topper")
 	}
-	return count
+	for name := range m.Snapshotters {
+		check(name, "snapshotter")
+	}
+
+	return result
 }
 
 func Validate(m *metadatav1.GadgetMetadata, spec *ebpf.CollectionSpec) error {
@@ -77,12 +94,8 @@ func Validate(m *metadatav1.GadgetMetadata, spec *ebpf.CollectionSpec) error {
 		result = multierror.Append(result, errors.New("gadget name is required"))
 	}
 
-	// Temporary limitation
-	if count := countDistImp(m); count > 1 {
-		result = multierror.Append(
-			result,
-			fmt.Errorf("gadget can implement only one tracer or snapshotter or topper, found %d", count),
-		)
+	if err := validateNames(m); err != nil {
+		result = multierror.Append(result, err)
 	}
 
 	if err := validateEbpfParams(m, spec); err != nil {
@@ -109,17 +122,20 @@ func Validate(m *metadatav1.GadgetMetadata, spec *ebpf.CollectionSpec) error {
 		result = multierror.Append(result, err)
 	}
 
+	if err := validateDataSources(m); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if err := kernelfeatures.ValidateRequirements(m.Requirements); err != nil {
+		result = multierror.Append(result, fmt.Errorf("validating requirements: %w", err))
+	}
+
 	return result
 }
 
 func validateTracers(m *metadatav1.GadgetMetadata, spec *ebpf.CollectionSpec) error {
 	var result error
 
-	// Temporary limitation
-	if len(m.Tracers) > 1 {
-		result = multierror.Append(result, errors.New("only one tracer is allowed"))
-	}
-
 	for name, t := range m.Tracers {
 		err := validateMapAndStruct(t.MapName, t.StructName, spec, m, validateTracerMap)
 		if err != nil {
@@ -186,11 +202,6 @@ func validateTopperMap(topperMap *ebpf.MapSpec, expectedStructName string) error
 func validateSnapshotters(m *metadatav1.GadgetMetadata, spec *ebpf.CollectionSpec) error {
 	var result error
 
-	// Temporary limitation
-	if len(m.Snapshotters) > 1 {
-		result = multierror.Append(result, errors.New("only one snapshotter is allowed"))
-	}
-
 	for name, snapshotter := range m.Snapshotters {
 		if snapshotter.StructName == "" {
 			result = multierror.Append(result, fmt.Errorf("snapshotter %q is missing structName", name))
@@ -296,6 +307,53 @@ func validateGadgetParams(m *metadatav1.GadgetMetadata, spec *ebpf.CollectionSpe
 	return result
 }
 
+func validateDataSources(m *metadatav1.GadgetMetadata) error {
+	var result error
+	for name, ds := range m.DataSources {
+		_, isTracer := m.Tracers[name]
+		_, isTopper := m.Toppers[name]
+		_, isSnapshotter := m.Snapshotters[name]
+		if !isTracer && !isTopper && !isSnapshotter {
+			result = multierror.Append(result, fmt.Errorf("datasource %q not found", name))
+		}
+		if p, ok := ds.Annotations[datasource.AnnotationPriority]; ok {
+			if _, err := datasource.ParsePriorityClass(p); err != nil {
+				result = multierror.Append(result, fmt.Errorf("datasource %q: %w", name, err))
+			}
+		}
+		if s, ok := ds.Annotations[datasource.AnnotationSeverity]; ok {
+			if _, err := datasource.ParseSeverity(s); err != nil {
+				result = multierror.Append(result, fmt.Errorf("datasource %q: %w", name, err))
+			}
+		}
+		if ds.Pairing != nil {
+			if err := validatePairing(ds.Pairing); err != nil {
+				result = multierror.Append(result, fmt.Errorf("datasource %q: pairing: %w", name, err))
+			}
+		}
+	}
+	return result
+}
+
+func validatePairing(p *metadatav1.Pairing) error {
+	var result error
+	if len(p.Keys) == 0 {
+		result = multierror.Append(result, errors.New("no keys given"))
+	}
+	if p.RoleField == "" {
+		result = multierror.Append(result, errors.New("roleField is required"))
+	}
+	if p.StartValue == p.EndValue {
+		result = multierror.Append(result, errors.New("startValue and endValue must differ"))
+	}
+	if p.Timeout != "" {
+		if timeout, err := time.ParseDuration(p.Timeout); err != nil || timeout <= 0 {
+			result = multierror.Append(result, fmt.Errorf("invalid timeout %q", p.Timeout))
+		}
+	}
+	return result
+}
+
 // Populate fills the metadata from its ebpf spec
 func Populate(m *metadatav1.GadgetMetadata, spec *ebpf.CollectionSpec) error {
 	if m.Name == "" {
@@ -338,6 +396,10 @@ func Populate(m *metadatav1.GadgetMetadata, spec *ebpf.CollectionSpec) error {
 		return fmt.Errorf("handling gadget params: %w", err)
 	}
 
+	if err := populateAnnotations(m, spec); err != nil {
+		return fmt.Errorf("handling annotations: %w", err)
+	}
+
 	return nil
 }
 
@@ -382,11 +444,11 @@ func getColumnSize(typ btf.Type) uint {
 }
 
 func populateTracers(m *metadatav1.GadgetMetadata, spec *ebpf.CollectionSpec) error {
-	tracerInfo, err := getTracerInfo(spec)
+	tracersInfo, err := getTracersInfo(spec)
 	if err != nil {
 		return err
 	}
-	if tracerInfo == nil {
+	if len(tracersInfo) == 0 {
 		log.Debug("No tracer found in eBPF object")
 		return nil
 	}
@@ -395,6 +457,16 @@ func populateTracers(m *metadatav1.GadgetMetadata, spec *ebpf.CollectionSpec) er
 		m.Tracers = make(map[string]metadatav1.Tracer)
 	}
 
+	for _, tracerInfo := range tracersInfo {
+		if err := populateTracer(m, spec, tracerInfo); err != nil {
+			return fmt.Errorf("populating tracer %q: %w", tracerInfo.name, err)
+		}
+	}
+
+	return nil
+}
+
+func populateTracer(m *metadatav1.GadgetMetadata, spec *ebpf.CollectionSpec, tracerInfo *tracerInfo) error {
 	tracerMap := spec.Maps[tracerInfo.mapName]
 	if tracerMap == nil {
 		return fmt.Errorf("map %q not found in eBPF object", tracerInfo.mapName)
@@ -521,31 +593,28 @@ type tracerInfo struct {
 	eventType string
 }
 
-// getTracerInfo returns the tracer info generated with GADGET_TRACER().
-// If there are multiple annotations only the first one is returned.
-func getTracerInfo(spec *ebpf.CollectionSpec) (*tracerInfo, error) {
+// getTracersInfo returns the info of all tracers generated with GADGET_TRACER().
+func getTracersInfo(spec *ebpf.CollectionSpec) ([]*tracerInfo, error) {
 	tracersInfo, err := GetGadgetIdentByPrefix(spec, tracerInfoPrefix)
 	if err != nil {
 		return nil, err
 	}
-	if len(tracersInfo) == 0 {
-		return nil, nil
-	}
 
-	if len(tracersInfo) > 1 {
-		log.Warnf("multiple tracers found, using %q", tracersInfo[0])
-	}
+	res := make([]*tracerInfo, 0, len(tracersInfo))
+	for _, info := range tracersInfo {
+		parts := strings.Split(info, "___")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid tracer info: %q", info)
+		}
 
-	parts := strings.Split(tracersInfo[0], "___")
-	if len(parts) != 3 {
-		return nil, fmt.Errorf("invalid tracer info: %q", tracersInfo[0])
+		res = append(res, &tracerInfo{
+			name:      parts[0],
+			mapName:   parts[1],
+			eventType: parts[2],
+		})
 	}
 
-	return &tracerInfo{
-		name:      parts[0],
-		mapName:   parts[1],
-		eventType: parts[2],
-	}, nil
+	return res, nil
 }
 
 type topperInfo struct {
@@ -778,3 +847,100 @@ func populateSnapshotters(m *metadatav1.GadgetMetadata, spec *ebpf.CollectionSpe
 
 	return nil
 }
+
+// decodeAnnotationIdent converts a key or value of an annotation macro back to its string, as identifiers can't
+// contain dots
+func decodeAnnotationIdent(s string) string {
+	return strings.ReplaceAll(s, "__", ".")
+}
+
+// populateAnnotations adds the annotations defined with GADGET_DATASOURCE_ANNOTATION() and
+// GADGET_FIELD_ANNOTATION(). Annotations already present in the metadata are kept.
+func populateAnnotations(m *metadatav1.GadgetMetadata, spec *ebpf.CollectionSpec) error {
+	var result error
+
+	dsAnnotations, err := GetGadgetIdentByPrefix(spec, dsAnnotationPrefix)
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+	for _, info := range dsAnnotations {
+		parts := strings.Split(info, "___")
+		if len(parts) != 3 {
+			result = multierror.Append(result, fmt.Errorf("invalid data source annotation: %q", info))
+			continue
+		}
+		dsName, key, value := parts[0], decodeAnnotationIdent(parts[1]), decodeAnnotationIdent(parts[2])
+
+		_, isTracer := m.Tracers[dsName]
+		_, isTopper := m.Toppers[dsName]
+		_, isSnapshotter := m.Snapshotters[dsName]
+		if !isTracer && !isTopper && !isSnapshotter {
+			result = multierror.Append(result, fmt.Errorf("annotation %q: data source %q not found", key, dsName))
+			continue
+		}
+
+		if m.DataSources == nil {
+			m.DataSources = make(map[string]metadatav1.DataSource)
+		}
+		ds := m.DataSources[dsName]
+		if ds.Annotations == nil {
+			ds.Annotations = make(map[string]string)
+		}
+		if _, ok := ds.Annotations[key]; ok {
+			log.Debugf("Annotation %q of data source %q already defined, skipping", key, dsName)
+			continue
+		}
+		log.Debugf("Adding annotation %q to data source %q", key, dsName)
+		ds.Annotations[key] = value
+		m.DataSources[dsName] = ds
+	}
+
+	fieldAnnotations, err := GetGadgetIdentByPrefix(spec, fieldAnnotationPrefix)
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+	for _, info := range fieldAnnotations {
+		parts := strings.Split(info, "___")
+		if len(parts) != 4 {
+			result = multierror.Append(result, fmt.Errorf("invalid field annotation: %q", info))
+			continue
+		}
+		structName, fieldName := parts[0], parts[1]
+		key, value := decodeAnnotationIdent(parts[2]), decodeAnnotationIdent(parts[3])
+
+		var field *metadatav1.Field
+		for i := range m.Structs[structName].Fields {
+			if f := &m.Structs[structName].Fields[i]; f.Name == fieldName {
+				field = f
+				break
+			}
+		}
+		if field == nil {
+			result = multierror.Append(result, fmt.Errorf("annotation %q: field %q of struct %q not found",
+				key, fieldName, structName))
+			continue
+		}
+
+		// hidden is an attribute rather than an annotation in the metadata file
+		if key == "hidden" {
+			if value != "true" && value != "false" {
+				result = multierror.Append(result, fmt.Errorf("invalid value %q for hidden field %q", value, fieldName))
+				continue
+			}
+			field.Attributes.Hidden = field.Attributes.Hidden || value == "true"
+			continue
+		}
+
+		if field.Annotations == nil {
+			field.Annotations = make(map[string]interface{})
+		}
+		if _, ok := field.Annotations[key]; ok {
+			log.Debugf("Annotation %q of field %q already defined, skipping", key, fieldName)
+			continue
+		}
+		log.Debugf("Adding annotation %q to field %q", key, fieldName)
+		field.Annotations[key] = value
+	}
+
+	return result
+}
@@ -15,9 +15,11 @@
 package types
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
 	"github.com/stretchr/testify/require"
 
 	metadatav1 "github.com/inspektor-gadget/inspektor-gadget/pkg/metadata/v1"
@@ -42,24 +44,60 @@ func TestValidate(t *testing.T) {
 			metadata: &metadatav1.GadgetMetadata{
 				Name: "foo",
 				Tracers: map[string]metadatav1.Tracer{
-					"foo": {},
+					"foo": {
+						MapName:    "events",
+						StructName: "event",
+					},
 				},
 				Snapshotters: map[string]metadatav1.Snapshotter{
-					"bar": {},
+					"bar": {
+						StructName: "event",
+					},
+				},
+				Structs: map[string]metadatav1.Struct{
+					"event": {},
+				},
+			},
+		},
+		"multiple_types_same_name": {
+			objectPath: "../../../../testdata/validate_metadata1.o",
+			metadata: &metadatav1.GadgetMetadata{
+				Name: "foo",
+				Tracers: map[string]metadatav1.Tracer{
+					"foo": {
+						MapName:    "events",
+						StructName: "event",
+					},
+				},
+				Snapshotters: map[string]metadatav1.Snapshotter{
+					"foo": {
+						StructName: "event",
+					},
+				},
+				Structs: map[string]metadatav1.Struct{
+					"event": {},
 				},
 			},
-			expectedErrString: "gadget can implement only one tracer or snapshotter or topper",
+			expectedErrString: "snapshotter \"foo\" has the same name as tracer \"foo\"",
 		},
 		"tracers_more_than_one": {
 			objectPath: "../../../../testdata/validate_metadata1.o",
 			metadata: &metadatav1.GadgetMetadata{
 				Name: "foo",
 				Tracers: map[string]metadatav1.Tracer{
-					"foo": {},
-					"bar": {},
+					"foo": {
+						MapName:    "events",
+						StructName: "event",
+					},
+					"bar": {
+						MapName:    "events",
+						StructName: "event",
+					},
+				},
+				Structs: map[string]metadatav1.Struct{
+					"event": {},
 				},
 			},
-			expectedErrString: "only one tracer is allowed",
 		},
 		"tracers_missing_map_name": {
 			objectPath: "../../../../testdata/validate_metadata1.o",
@@ -349,11 +387,17 @@ func TestValidate(t *testing.T) {
 			metadata: &metadatav1.GadgetMetadata{
 				Name: "foo",
 				Snapshotters: map[string]metadatav1.Snapshotter{
-					"foo": {},
-					"bar": {},
+					"foo": {
+						StructName: "event",
+					},
+					"bar": {
+						StructName: "event",
+					},
+				},
+				Structs: map[string]metadatav1.Struct{
+					"event": {},
 				},
 			},
-			expectedErrString: "only one snapshotter is allowed",
 		},
 		"snapshotters_missing_struct_name": {
 			objectPath: "../../../../testdata/validate_metadata1.o",
@@ -390,6 +434,116 @@ func TestValidate(t *testing.T) {
 				},
 			},
 		},
+		"datasources_good": {
+			objectPath: "../../../../testdata/validate_metadata1.o",
+			metadata: &metadatav1.GadgetMetadata{
+				Name: "foo",
+				Tracers: map[string]metadatav1.Tracer{
+					"foo": {
+						MapName:    "events",
+						StructName: "event",
+					},
+				},
+				Structs: map[string]metadatav1.Struct{
+					"event": {},
+				},
+				DataSources: map[string]metadatav1.DataSource{
+					"foo": {
+						Annotations: map[string]string{"priority": "debug", "severity": "high"},
+					},
+				},
+			},
+		},
+		"datasources_unknown": {
+			objectPath: "../../../../testdata/validate_metadata1.o",
+			metadata: &metadatav1.GadgetMetadata{
+				Name: "foo",
+				DataSources: map[string]metadatav1.DataSource{
+					"foo": {},
+				},
+			},
+			expectedErrString: "datasource \"foo\" not found",
+		},
+		"datasources_bad_priority": {
+			objectPath: "../../../../testdata/validate_metadata1.o",
+			metadata: &metadatav1.GadgetMetadata{
+				Name: "foo",
+				Tracers: map[string]metadatav1.Tracer{
+					"foo": {
+						MapName:    "events",
+						StructName: "event",
+					},
+				},
+				Structs: map[string]metadatav1.Struct{
+					"event": {},
+				},
+				DataSources: map[string]metadatav1.DataSource{
+					"foo": {
+						Annotations: map[string]string{"priority": "urgent"},
+					},
+				},
+			},
+			expectedErrString: "invalid priority class \"urgent\"",
+		},
+		"datasources_bad_severity": {
+			objectPath: "../../../../testdata/validate_metadata1.o",
+			metadata: &metadatav1.GadgetMetadata{
+				Name: "foo",
+				Tracers: map[string]metadatav1.Tracer{
+					"foo": {
+						MapName:    "events",
+						StructName: "event",
+					},
+				},
+				Structs: map[string]metadatav1.Struct{
+					"event": {},
+				},
+				DataSources: map[string]metadatav1.DataSource{
+					"foo": {
+						Annotations: map[string]string{"severity": "urgent"},
+					},
+				},
+			},
+			expectedErrString: "invalid severity \"urgent\"",
+		},
+		"datasources_bad_pairing": {
+			objectPath: "../../../../testdata/validate_metadata1.o",
+			metadata: &metadatav1.GadgetMetadata{
+				Name: "foo",
+				Tracers: map[string]metadatav1.Tracer{
+					"foo": {
+						MapName:    "events",
+						StructName: "event",
+					},
+				},
+				Structs: map[string]metadatav1.Struct{
+					"event": {},
+				},
+				DataSources: map[string]metadatav1.DataSource{
+					"foo": {
+						Pairing: &metadatav1.Pairing{
+							Keys:       []string{"id"},
+							RoleField:  "qr",
+							StartValue: "0",
+							EndValue:   "1",
+							Timeout:    "soon",
+						},
+					},
+				},
+			},
+			expectedErrString: "invalid timeout \"soon\"",
+		},
+		"unknown_required_map_type": {
+			objectPath: "../../../../testdata/validate_metadata1.o",
+			metadata: &metadatav1.GadgetMetadata{
+				Name: "foo",
+				Requirements: &metadatav1.Requirements{
+					KernelVersion: "5.8",
+					MapTypes:      []string{"ringbuf", "ringbuffer"},
+				},
+			},
+			expectedErrString: "unknown map type \"ringbuffer\"",
+		},
 	}
 
 	for name, test := range tests {
@@ -953,3 +1107,103 @@ func TestPopulate(t *testing.T) {
 		})
 	}
 }
+
+// annotationsSpec returns a spec with the variables generated by the annotation macros for the given names
+func annotationsSpec(t *testing.T, names ...string) *ebpf.CollectionSpec {
+	b := &btf.Builder{}
+	constVoidPtr := &btf.Pointer{Target: &btf.Const{Type: &btf.Void{}}}
+	for _, name := range names {
+		_, err := b.Add(&btf.Var{Name: name, Type: constVoidPtr, Linkage: btf.GlobalVar})
+		require.NoError(t, err)
+	}
+	buf, err := b.Marshal(nil, nil)
+	require.NoError(t, err)
+	types, err := btf.LoadSpecFromReader(bytes.NewReader(buf))
+	require.NoError(t, err)
+	return &ebpf.CollectionSpec{Types: types}
+}
+
+func TestPopulateAnnotations(t *testing.T) {
+	newMetadata := func() *metadatav1.GadgetMetadata {
+		return &metadatav1.GadgetMetadata{
+			Tracers: map[string]metadatav1.Tracer{
+				"open": {MapName: "events", StructName: "event"},
+			},
+			DataSources: map[string]metadatav1.DataSource{
+				"open": {Annotations: map[string]string{"cli.clear_screen_before": "true"}},
+			},
+			Structs: map[string]metadatav1.Struct{
+				"event": {
+					Fields: []metadatav1.Field{
+						{Name: "pid"},
+						{Name: "latency", Annotations: map[string]interface{}{"unit": "us"}},
+					},
+				},
+			},
+		}
+	}
+
+	type testCase struct {
+		names             []string
+		expectedMetadata  func(m *metadatav1.GadgetMetadata)
+		expectedErrString string
+	}
+
+	tests := map[string]testCase{
+		"data_source": {
+			names: []string{
+				"gadget_ds_annotation_open___priority___high",
+				"gadget_ds_annotation_open___cli__clear_screen_before___false",
+			},
+			expectedMetadata: func(m *metadatav1.GadgetMetadata) {
+				m.DataSources["open"].Annotations["priority"] = "high"
+			},
+		},
+		"fields": {
+			names: []string{
+				"gadget_field_annotation_event___pid___hidden___true",
+				"gadget_field_annotation_event___pid___json__skip___true",
+				"gadget_field_annotation_event___latency___unit___ns",
+			},
+			expectedMetadata: func(m *metadatav1.GadgetMetadata) {
+				m.Structs["event"].Fields[0].Attributes.Hidden = true
+				m.Structs["event"].Fields[0].Annotations = map[string]interface{}{"json.skip": "true"}
+			},
+		},
+		"unknown_data_source": {
+			names:             []string{"gadget_ds_annotation_exec___priority___high"},
+			expectedErrString: `data source "exec" not found`,
+		},
+		"unknown_field": {
+			names:             []string{"gadget_field_annotation_event___comm___hidden___true"},
+			expectedErrString: `field "comm" of struct "event" not found`,
+		},
+		"invalid_hidden": {
+			names:             []string{"gadget_field_annotation_event___pid___hidden___yes"},
+			expectedErrString: `invalid value "yes"`,
+		},
+		"invalid_format": {
+			names:             []string{"gadget_ds_annotation_open___priority"},
+			expectedErrString: "invalid data source annotation",
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			m := newMetadata()
+			err := populateAnnotations(m, annotationsSpec(t, test.names...))
+			if test.expectedErrString != "" {
+				require.ErrorContains(t, err, test.expectedErrString)
+				return
+			}
+			require.NoError(t, err)
+
+			expected := newMetadata()
+			test.expectedMetadata(expected)
+			require.Equal(t, expected, m)
+		})
+	}
+}
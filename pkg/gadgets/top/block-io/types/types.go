@@ -43,6 +43,23 @@ type Stats struct {
 	Bytes      uint64 `json:"bytes,omitempty" column:"bytes"`
 	MicroSecs  uint64 `json:"us,omitempty" column:"time"`
 	Operations uint32 `json:"ops,omitempty" column:"ops"`
+
+	// Device is the device-mapper or kernel name of the device; MountPoint, PV and PVC tell where it's mounted
+	Device     string `json:"device,omitempty" column:"device,width:16"`
+	MountPoint string `json:"mountpoint,omitempty" column:"mountpoint,width:32,hide"`
+	PV         string `json:"pv,omitempty" column:"pv,width:24,hide"`
+	PVC        string `json:"pvc,omitempty" column:"pvc,width:24"`
+}
+
+func (s *Stats) GetDevice() (uint32, uint32) {
+	return uint32(s.Major), uint32(s.Minor)
+}
+
+func (s *Stats) SetBlockDevice(name, mountPoint, pv, pvc string) {
+	s.Device = name
+	s.MountPoint = mountPoint
+	s.PV = pv
+	s.PVC = pvc
 }
 
 func GetColumns() *columns.Columns[Stats] {
@@ -0,0 +1,273 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kernelfeatures probes the eBPF features of the running kernel, so that gadgets needing a missing
+// feature fail with an actionable error instead of a loader or verifier failure.
+package kernelfeatures
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/features"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// ErrMissingFeature is wrapped by the errors returned when the kernel lacks a feature
+var ErrMissingFeature = errors.New("missing kernel feature")
+
+// Version is a kernel version
+type Version struct {
+	Major, Minor, Patch int
+}
+
+// ParseVersion parses versions like "5.8" or "5.15.0-91-generic"
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	s, _, _ = strings.Cut(s, "-")
+	parts := strings.SplitN(s, ".", 3)
+	for i, dst := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		if i >= len(parts) {
+			break
+		}
+		// Ignore suffixes like "+" of custom kernels
+		digits := strings.TrimRightFunc(parts[i], func(r rune) bool { return r < '0' || r > '9' })
+		n, err := strconv.Atoi(digits)
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid kernel version %q", s)
+		}
+		*dst = n
+	}
+	return v, nil
+}
+
+// Less tells whether v is older than other
+func (v Version) Less(other Version) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor < other.Minor
+	}
+	return v.Patch < other.Patch
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Kernel versions that introduced some program and map types, used to suggest a kernel to users
+var (
+	programTypesSince = map[ebpf.ProgramType]string{
+		ebpf.RawTracepoint: "4.17",
+		ebpf.Tracing:       "5.5",
+		ebpf.StructOps:     "5.6",
+		ebpf.LSM:           "5.7",
+		ebpf.SkLookup:      "5.9",
+		ebpf.Syscall:       "5.14",
+		ebpf.Netfilter:     "6.4",
+	}
+	mapTypesSince = map[ebpf.MapType]string{
+		ebpf.SkStorage:    "5.2",
+		ebpf.StructOpsMap: "5.6",
+		ebpf.RingBuf:      "5.8",
+		ebpf.InodeStorage: "5.10",
+		ebpf.TaskStorage:  "5.11",
+	}
+)
+
+// normalize makes names from libbpf ("sched_cls", "bpf_ringbuf_output") and cilium/ebpf ("SchedCLS",
+// "FnRingbufOutput") comparable
+func normalize(name string) string {
+	name = strings.ToLower(name)
+	name = strings.NewReplacer("_", "", "-", "").Replace(name)
+	return name
+}
+
+func programTypeByName(name string) (ebpf.ProgramType, error) {
+	for t := ebpf.SocketFilter; t <= ebpf.Netfilter; t++ {
+		if normalize(t.String()) == normalize(name) {
+			return t, nil
+		}
+	}
+	return ebpf.UnspecifiedProgram, fmt.Errorf("unknown program type %q", name)
+}
+
+func mapTypeByName(name string) (ebpf.MapType, error) {
+	for t := ebpf.Hash; t <= ebpf.TaskStorage; t++ {
+		if normalize(t.String()) == normalize(name) {
+			return t, nil
+		}
+	}
+	return ebpf.UnspecifiedMap, fmt.Errorf("unknown map type %q", name)
+}
+
+func helperByName(name string) (asm.BuiltinFunc, error) {
+	normalized := strings.TrimPrefix(normalize(name), "bpf")
+	var fn asm.BuiltinFunc
+	for fn = asm.FnMapLookupElem; fn <= fn.Max(); fn++ {
+		if strings.TrimPrefix(normalize(fn.String()), "fn") == normalized {
+			return fn, nil
+		}
+	}
+	return asm.FnUnspec, fmt.Errorf("unknown helper %q", name)
+}
+
+// prober probes single features. Its functions can be replaced in tests.
+type prober struct {
+	kernelVersion   func() (Version, error)
+	haveBTF         func() error
+	haveProgramType func(ebpf.ProgramType) error
+	haveMapType     func(ebpf.MapType) error
+	haveHelper      func(ebpf.ProgramType, asm.BuiltinFunc) error
+}
+
+var defaultProber = &prober{
+	kernelVersion: func() (Version, error) {
+		var uname unix.Utsname
+		if err := unix.Uname(&uname); err != nil {
+			return Version{}, fmt.Errorf("getting kernel version: %w", err)
+		}
+		return ParseVersion(unix.ByteSliceToString(uname.Release[:]))
+	},
+	haveBTF: func() error {
+		_, err := btf.LoadKernelSpec()
+		return err
+	},
+	haveProgramType: features.HaveProgramType,
+	haveMapType:     features.HaveMapType,
+	haveHelper:      features.HaveProgramHelper,
+}
+
+// missing tells whether err means that a feature isn't available. Other errors, e.g. because of missing
+// permissions, don't tell anything about the kernel and are left to the loader to report.
+func missing(err error) bool {
+	return errors.Is(err, ebpf.ErrNotSupported)
+}
+
+// Features are the features of the running kernel
+type Features struct {
+	KernelVersion Version
+	// BTF tells whether the kernel provides its own BTF types
+	BTF          bool
+	ProgramTypes []string
+	MapTypes     []string
+}
+
+var (
+	probeOnce sync.Once
+	probed    *Features
+)
+
+// Get returns the features of the running kernel. They are probed on the first call.
+func Get() *Features {
+	probeOnce.Do(func() {
+		probed = defaultProber.probe()
+		log.Debugf("kernel %s, BTF: %t, program types: %s, map types: %s", probed.KernelVersion, probed.BTF,
+			strings.Join(probed.ProgramTypes, ","), strings.Join(probed.MapTypes, ","))
+	})
+	return probed
+}
+
+func (p *prober) probe() *Features {
+	f := &Features{}
+	if v, err := p.kernelVersion(); err == nil {
+		f.KernelVersion = v
+	} else {
+		log.Debugf("probing kernel version: %v", err)
+	}
+	f.BTF = p.haveBTF() == nil
+	for t := ebpf.SocketFilter; t <= ebpf.Netfilter; t++ {
+		if p.haveProgramType(t) == nil {
+			f.ProgramTypes = append(f.ProgramTypes, t.String())
+		}
+	}
+	for t := ebpf.Hash; t <= ebpf.TaskStorage; t++ {
+		if p.haveMapType(t) == nil {
+			f.MapTypes = append(f.MapTypes, t.String())
+		}
+	}
+	sort.Strings(f.ProgramTypes)
+	sort.Strings(f.MapTypes)
+	return f
+}
+
+func sinceHint(since string) string {
+	if since == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (requires kernel >= %s)", since)
+}
+
+func (p *prober) checkProgramType(t ebpf.ProgramType) error {
+	if err := p.haveProgramType(t); missing(err) {
+		return fmt.Errorf("%w: program type %s isn't supported%s", ErrMissingFeature, t, sinceHint(programTypesSince[t]))
+	}
+	return nil
+}
+
+func (p *prober) checkMapType(t ebpf.MapType) error {
+	if err := p.haveMapType(t); missing(err) {
+		return fmt.Errorf("%w: map type %s isn't supported%s", ErrMissingFeature, t, sinceHint(mapTypesSince[t]))
+	}
+	return nil
+}
+
+func (p *prober) checkHelper(t ebpf.ProgramType, fn asm.BuiltinFunc) error {
+	if err := p.haveHelper(t, fn); missing(err) {
+		return fmt.Errorf("%w: helper %s isn't available to %s programs", ErrMissingFeature, fn, t)
+	}
+	return nil
+}
+
+// HaveProgramType returns an error wrapping ErrMissingFeature if the kernel doesn't support the program type,
+// e.g. "lsm"
+func HaveProgramType(name string) error {
+	t, err := programTypeByName(name)
+	if err != nil {
+		return err
+	}
+	return defaultProber.checkProgramType(t)
+}
+
+// HaveMapType returns an error wrapping ErrMissingFeature if the kernel doesn't support the map type, e.g. "ringbuf"
+func HaveMapType(name string) error {
+	t, err := mapTypeByName(name)
+	if err != nil {
+		return err
+	}
+	return defaultProber.checkMapType(t)
+}
+
+// HaveHelper returns an error wrapping ErrMissingFeature if the helper, e.g. "bpf_ringbuf_output", can't be used
+// by programs of the given type
+func HaveHelper(programType, helper string) error {
+	t, err := programTypeByName(programType)
+	if err != nil {
+		return err
+	}
+	fn, err := helperByName(helper)
+	if err != nil {
+		return err
+	}
+	return defaultProber.checkHelper(t, fn)
+}
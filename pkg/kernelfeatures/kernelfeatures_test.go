@@ -0,0 +1,171 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernelfeatures
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/stretchr/testify/require"
+
+	metadatav1 "github.com/inspektor-gadget/inspektor-gadget/pkg/metadata/v1"
+)
+
+func TestParseVersion(t *testing.T) {
+	for s, expected := range map[string]Version{
+		"5.8":               {5, 8, 0},
+		"5.15.0-91-generic": {5, 15, 0},
+		"6.1.55+":           {6, 1, 55},
+		"4":                 {4, 0, 0},
+	} {
+		v, err := ParseVersion(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expected, v, s)
+	}
+
+	_, err := ParseVersion("latest")
+	require.Error(t, err)
+
+	require.True(t, Version{5, 4, 0}.Less(Version{5, 8, 0}))
+	require.False(t, Version{6, 1, 0}.Less(Version{5, 8, 0}))
+	require.False(t, Version{5, 8, 0}.Less(Version{5, 8, 0}))
+}
+
+func TestNames(t *testing.T) {
+	progType, err := programTypeByName("sched_cls")
+	require.NoError(t, err)
+	require.Equal(t, ebpf.SchedCLS, progType)
+
+	mapType, err := mapTypeByName("ringbuf")
+	require.NoError(t, err)
+	require.Equal(t, ebpf.RingBuf, mapType)
+
+	helper, err := helperByName("bpf_get_func_ip")
+	require.NoError(t, err)
+	require.Equal(t, asm.FnGetFuncIp, helper)
+
+	_, err = helperByName("bpf_does_not_exist")
+	require.Error(t, err)
+}
+
+// fakeProber returns a prober for a 5.4 kernel without BTF, ring buffers, LSM programs and bpf_get_func_ip
+func fakeProber() *prober {
+	notSupported := fmt.Errorf("probing: %w", ebpf.ErrNotSupported)
+	return &prober{
+		kernelVersion: func() (Version, error) {
+			return Version{5, 4, 0}, nil
+		},
+		haveBTF: func() error {
+			return errors.New("no BTF")
+		},
+		haveProgramType: func(t ebpf.ProgramType) error {
+			if t == ebpf.LSM {
+				return notSupported
+			}
+			// Other errors don't tell whether the feature is available
+			if t == ebpf.Syscall {
+				return errors.New("operation not permitted")
+			}
+			return nil
+		},
+		haveMapType: func(t ebpf.MapType) error {
+			if t == ebpf.RingBuf {
+				return notSupported
+			}
+			return nil
+		},
+		haveHelper: func(_ ebpf.ProgramType, fn asm.BuiltinFunc) error {
+			if fn == asm.FnGetFuncIp {
+				return notSupported
+			}
+			return nil
+		},
+	}
+}
+
+func TestCheckRequirements(t *testing.T) {
+	p := fakeProber()
+
+	require.NoError(t, p.checkRequirements(nil))
+	require.NoError(t, p.checkRequirements(&metadatav1.Requirements{
+		ProgramTypes: []string{"kprobe", "syscall"},
+		MapTypes:     []string{"hash"},
+		Helpers:      map[string][]string{"kprobe": {"bpf_get_current_task"}},
+	}))
+
+	err := p.checkRequirements(&metadatav1.Requirements{
+		KernelVersion: "5.8",
+		BTF:           true,
+		ProgramTypes:  []string{"lsm"},
+		MapTypes:      []string{"ringbuf"},
+		Helpers:       map[string][]string{"kprobe": {"bpf_get_func_ip"}},
+	})
+	require.ErrorIs(t, err, ErrMissingFeature)
+	require.ErrorContains(t, err, "gadget requires kernel >= 5.8, running 5.4.0")
+	require.ErrorContains(t, err, "CONFIG_DEBUG_INFO_BTF")
+	require.ErrorContains(t, err, "program type LSM isn't supported (requires kernel >= 5.7)")
+	require.ErrorContains(t, err, "map type RingBuf isn't supported (requires kernel >= 5.8)")
+	require.ErrorContains(t, err, "helper FnGetFuncIp isn't available to Kprobe programs")
+
+	err = p.checkRequirements(&metadatav1.Requirements{MapTypes: []string{"ringbuffer"}})
+	require.ErrorContains(t, err, "invalid requirements")
+	require.NotErrorIs(t, err, ErrMissingFeature)
+}
+
+func TestCheckSpec(t *testing.T) {
+	p := fakeProber()
+
+	spec := &ebpf.CollectionSpec{
+		Programs: map[string]*ebpf.ProgramSpec{
+			"ig_exec": {Type: ebpf.TracePoint},
+		},
+		Maps: map[string]*ebpf.MapSpec{
+			"events": {Type: ebpf.PerfEventArray},
+		},
+	}
+	require.NoError(t, p.checkSpec(spec))
+
+	spec.Maps["events"].Type = ebpf.RingBuf
+	err := p.checkSpec(spec)
+	require.ErrorIs(t, err, ErrMissingFeature)
+	require.ErrorContains(t, err, "map type RingBuf isn't supported (requires kernel >= 5.8)")
+}
+
+func TestValidateRequirements(t *testing.T) {
+	require.NoError(t, ValidateRequirements(nil))
+	require.NoError(t, ValidateRequirements(&metadatav1.Requirements{
+		KernelVersion: "5.8",
+		ProgramTypes:  []string{"lsm", "kprobe"},
+		MapTypes:      []string{"ringbuf"},
+		Helpers:       map[string][]string{"tracing": {"bpf_get_func_ip"}},
+	}))
+
+	err := ValidateRequirements(&metadatav1.Requirements{
+		KernelVersion: "5.4",
+		ProgramTypes:  []string{"lsm"},
+		MapTypes:      []string{"ringbuf", "ringbuffer"},
+		Helpers:       map[string][]string{"kprobe": {"bpf_foo"}},
+	})
+	require.ErrorContains(t, err, "kernelVersion 5.4 is too low: program type lsm needs >= 5.7")
+	require.ErrorContains(t, err, "kernelVersion 5.4 is too low: map type ringbuf needs >= 5.8")
+	require.ErrorContains(t, err, `unknown map type "ringbuffer"`)
+	require.ErrorContains(t, err, `unknown helper "bpf_foo"`)
+
+	require.ErrorContains(t, ValidateRequirements(&metadatav1.Requirements{KernelVersion: "five"}),
+		"invalid kernel version")
+}
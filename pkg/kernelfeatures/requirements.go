@@ -0,0 +1,178 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernelfeatures
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cilium/ebpf"
+	"github.com/hashicorp/go-multierror"
+
+	metadatav1 "github.com/inspektor-gadget/inspektor-gadget/pkg/metadata/v1"
+)
+
+// ValidateRequirements checks that the requirements only use known versions, types and helpers
+func ValidateRequirements(r *metadatav1.Requirements) error {
+	if r == nil {
+		return nil
+	}
+
+	var result error
+	var minVersion *Version
+	if r.KernelVersion != "" {
+		if v, err := ParseVersion(r.KernelVersion); err != nil {
+			result = multierror.Append(result, err)
+		} else {
+			minVersion = &v
+		}
+	}
+	// The kernel version can't be lower than the one introducing the types needed, as they'd never be available
+	checkSince := func(since, what string) {
+		if since == "" || minVersion == nil {
+			return
+		}
+		if v, _ := ParseVersion(since); minVersion.Less(v) {
+			result = multierror.Append(result, fmt.Errorf("kernelVersion %s is too low: %s needs >= %s",
+				r.KernelVersion, what, since))
+		}
+	}
+	for _, name := range r.ProgramTypes {
+		t, err := programTypeByName(name)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		checkSince(programTypesSince[t], "program type "+name)
+	}
+	for _, name := range r.MapTypes {
+		t, err := mapTypeByName(name)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		checkSince(mapTypesSince[t], "map type "+name)
+	}
+	for progType, helpers := range r.Helpers {
+		t, err := programTypeByName(progType)
+		if err != nil {
+			result = multierror.Append(result, err)
+		} else {
+			checkSince(programTypesSince[t], "program type "+progType)
+		}
+		for _, helper := range helpers {
+			if _, err := helperByName(helper); err != nil {
+				result = multierror.Append(result, err)
+			}
+		}
+	}
+	return result
+}
+
+// CheckRequirements returns an error wrapping ErrMissingFeature for each requirement the running kernel doesn't
+// meet
+func CheckRequirements(r *metadatav1.Requirements) error {
+	return defaultProber.checkRequirements(r)
+}
+
+func (p *prober) checkRequirements(r *metadatav1.Requirements) error {
+	if r == nil {
+		return nil
+	}
+	if err := ValidateRequirements(r); err != nil {
+		return fmt.Errorf("invalid requirements: %w", err)
+	}
+
+	var result error
+	if r.KernelVersion != "" {
+		minVersion, _ := ParseVersion(r.KernelVersion)
+		if current, err := p.kernelVersion(); err == nil && current.Less(minVersion) {
+			result = multierror.Append(result, fmt.Errorf("%w: gadget requires kernel >= %s, running %s",
+				ErrMissingFeature, r.KernelVersion, current))
+		}
+	}
+	if r.BTF {
+		if err := p.haveBTF(); err != nil {
+			result = multierror.Append(result, fmt.Errorf("%w: gadget requires the BTF types of the kernel "+
+				"(CONFIG_DEBUG_INFO_BTF): %w", ErrMissingFeature, err))
+		}
+	}
+	for _, name := range r.ProgramTypes {
+		t, _ := programTypeByName(name)
+		if err := p.checkProgramType(t); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	for _, name := range r.MapTypes {
+		t, _ := mapTypeByName(name)
+		if err := p.checkMapType(t); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	progTypes := make([]string, 0, len(r.Helpers))
+	for progType := range r.Helpers {
+		progTypes = append(progTypes, progType)
+	}
+	sort.Strings(progTypes)
+	for _, progType := range progTypes {
+		t, _ := programTypeByName(progType)
+		for _, helper := range r.Helpers[progType] {
+			fn, _ := helperByName(helper)
+			if err := p.checkHelper(t, fn); err != nil {
+				result = multierror.Append(result, err)
+			}
+		}
+	}
+	return result
+}
+
+// CheckSpec returns an error wrapping ErrMissingFeature for each program or map type used by spec that the running
+// kernel doesn't support
+func CheckSpec(spec *ebpf.CollectionSpec) error {
+	return defaultProber.checkSpec(spec)
+}
+
+func (p *prober) checkSpec(spec *ebpf.CollectionSpec) error {
+	var result error
+
+	progTypes := make(map[ebpf.ProgramType]struct{})
+	for _, prog := range spec.Programs {
+		progTypes[prog.Type] = struct{}{}
+	}
+	mapTypes := make(map[ebpf.MapType]struct{})
+	for _, m := range spec.Maps {
+		mapTypes[m.Type] = struct{}{}
+	}
+
+	// Go through the types in order so that errors are reported consistently
+	for t := ebpf.SocketFilter; t <= ebpf.Netfilter; t++ {
+		if _, ok := progTypes[t]; !ok {
+			continue
+		}
+		if err := p.checkProgramType(t); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	for t := ebpf.Hash; t <= ebpf.TaskStorage; t++ {
+		if _, ok := mapTypes[t]; !ok {
+			continue
+		}
+		if err := p.checkMapType(t); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result
+}
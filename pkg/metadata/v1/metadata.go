@@ -99,6 +99,62 @@ type Struct struct {
 	Fields []Field `yaml:"fields"`
 }
 
+// DataSource describes additional settings of a data source provided by the gadget
+type DataSource struct {
+	// Annotations are added to the data source. The "priority" annotation (debug, low, normal, high or critical)
+	// decides which data sources are shed first when the gadget is overloaded; the "severity" annotation (debug,
+	// info, low, medium, high or critical) sets the severity of all its events
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+	// Pairing matches start and end events of the data source and adds the time between them to end events
+	Pairing *Pairing `yaml:"pairing,omitempty"`
+}
+
+// Pairing describes how start and end events (e.g. requests and responses) of a data source are matched
+type Pairing struct {
+	// Keys are the fields identifying the events that belong together
+	Keys []string `yaml:"keys"`
+	// RoleField is the field telling start and end events apart
+	RoleField string `yaml:"roleField"`
+	// StartValue is the value of RoleField for start events
+	StartValue string `yaml:"startValue"`
+	// EndValue is the value of RoleField for end events
+	EndValue string `yaml:"endValue"`
+	// TimestampField contains the time of the event in nanoseconds; defaults to "timestamp"
+	TimestampField string `yaml:"timestampField,omitempty"`
+	// Output is the name of the field that gets the duration in nanoseconds; defaults to "latency_ns"
+	Output string `yaml:"output,omitempty"`
+	// Timeout after which start events without end event are discarded; defaults to 10s
+	Timeout string `yaml:"timeout,omitempty"`
+	// MaxEntries limits the number of start events waiting for their end event; defaults to 16384
+	MaxEntries int `yaml:"maxEntries,omitempty"`
+}
+
+// Uprobe configures the param overriding the target of a uprobe, uretprobe or USDT program
+type Uprobe struct {
+	// Key of the param; programs with the same key share the param. Defaults to "uprobe-target-<program>"
+	Key string `yaml:"key,omitempty"`
+	// Description of the param
+	Description string `yaml:"description,omitempty"`
+	// DefaultValue of the param; it's required for USDT probes declared with GADGET_USDT_PROBE() unless users always
+	// set the param
+	DefaultValue string `yaml:"defaultValue,omitempty"`
+}
+
+// Requirements are the kernel features needed by a gadget. Users get an error telling what's missing when they
+// run it on a kernel that doesn't provide them.
+type Requirements struct {
+	// KernelVersion is the minimum kernel version, e.g. "5.8"
+	KernelVersion string `yaml:"kernelVersion,omitempty"`
+	// BTF tells whether the kernel has to provide its BTF types, e.g. for fentry programs
+	BTF bool `yaml:"btf,omitempty"`
+	// ProgramTypes are the program types needed, e.g. "lsm"
+	ProgramTypes []string `yaml:"programTypes,omitempty"`
+	// MapTypes are the map types needed, e.g. "ringbuf"
+	MapTypes []string `yaml:"mapTypes,omitempty"`
+	// Helpers are the helpers needed by program type, e.g. "kprobe: [bpf_get_func_ip]"
+	Helpers map[string][]string `yaml:"helpers,omitempty"`
+}
+
 type EBPFParam struct {
 	params.ParamDesc `yaml:",inline"`
 }
@@ -126,8 +182,14 @@ type GadgetMetadata struct {
 	Snapshotters map[string]Snapshotter `yaml:"snapshotters,omitempty"`
 	// Types generated by the gadget
 	Structs map[string]Struct `yaml:"structs,omitempty"`
+	// DataSources configures the data sources of the gadget by name
+	DataSources map[string]DataSource `yaml:"datasources,omitempty"`
 	// Params exposed by the gadget through eBPF constants
 	EBPFParams map[string]EBPFParam `yaml:"ebpfParams,omitempty"`
+	// Uprobes configures the params overriding the targets of uprobe programs by program name
+	Uprobes map[string]Uprobe `yaml:"uprobes,omitempty"`
+	// Requirements are the kernel features needed by the gadget
+	Requirements *Requirements `yaml:"requirements,omitempty"`
 	// Other params exposed by the gadget
 	GadgetParams map[string]params.ParamDesc `yaml:"gadgetParams,omitempty"`
 }
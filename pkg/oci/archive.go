@@ -0,0 +1,294 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/oci"
+)
+
+// annotationImageName names the images of an OCI image layout for docker and containerd, which only use
+// org.opencontainers.image.ref.name for the tag
+const annotationImageName = "io.containerd.image.name"
+
+const archiveIndexFile = "index.json"
+
+// ExportImage writes the given images of the local store, including all their architectures and layers, to w as tar
+// archive of an OCI image layout. Besides ImportImage, the archive can be read by other tools supporting OCI image
+// layouts, like skopeo or oras; docker loads archives in this format as well, but can't run gadget images.
+func ExportImage(ctx context.Context, w io.Writer, images ...string) error {
+	ociStore, err := getLocalOciStore()
+	if err != nil {
+		return fmt.Errorf("getting oci store: %w", err)
+	}
+	return exportImages(ctx, ociStore, w, images...)
+}
+
+// ExportGadgetImages exports the given images of the local store to dstFile, see ExportImage
+func ExportGadgetImages(ctx context.Context, dstFile string, images ...string) error {
+	f, err := os.Create(dstFile)
+	if err != nil {
+		return fmt.Errorf("creating file: %w", err)
+	}
+	if err := ExportImage(ctx, f, images...); err != nil {
+		f.Close()
+		os.Remove(dstFile)
+		return err
+	}
+	return f.Close()
+}
+
+func exportImages(ctx context.Context, src oras.ReadOnlyTarget, w io.Writer, images ...string) error {
+	tmpDir, err := os.MkdirTemp("", "gadget-export-")
+	if err != nil {
+		return fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dstStore, err := oci.NewWithContext(ctx, tmpDir)
+	if err != nil {
+		return fmt.Errorf("creating oci storage: %w", err)
+	}
+
+	for _, image := range images {
+		targetImage, err := normalizeImageName(image)
+		if err != nil {
+			return fmt.Errorf("normalizing image: %w", err)
+		}
+		desc, err := src.Resolve(ctx, targetImage.String())
+		if err != nil {
+			return fmt.Errorf("resolving image %q: %w", image, err)
+		}
+		if err := oras.CopyGraph(ctx, src, dstStore, desc, oras.DefaultCopyGraphOptions); err != nil {
+			return fmt.Errorf("copying image %q: %w", image, err)
+		}
+		if err := dstStore.Tag(ctx, desc, targetImage.String()); err != nil {
+			return fmt.Errorf("tagging image %q: %w", image, err)
+		}
+	}
+
+	if err := annotateImageNames(filepath.Join(tmpDir, archiveIndexFile)); err != nil {
+		return fmt.Errorf("annotating image names: %w", err)
+	}
+	if err := tarFolder(tmpDir, w); err != nil {
+		return fmt.Errorf("creating tar for gadget images: %w", err)
+	}
+	return nil
+}
+
+// annotateImageNames adds the name of the images to the index of an OCI image layout the way docker and containerd
+// expect them
+func annotateImageNames(indexPath string) error {
+	content, err := os.ReadFile(indexPath)
+	if err != nil {
+		return err
+	}
+	index := &ocispec.Index{}
+	if err := json.Unmarshal(content, index); err != nil {
+		return err
+	}
+	for i := range index.Manifests {
+		m := &index.Manifests[i]
+		if name := m.Annotations[ocispec.AnnotationRefName]; name != "" {
+			m.Annotations[annotationImageName] = name
+		}
+	}
+	content, err = json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(indexPath, content, 0o644)
+}
+
+// tarFolder writes the regular files of src to w as tar archive, with paths relative to src
+func tarFolder(src string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// ImportImage loads the images of a tar archive of an OCI image layout read from r into the local store and returns
+// their names. The archive might be gzip compressed, and might have been created by ExportImage or by other tools
+// naming the images with the annotations of docker and containerd.
+func ImportImage(ctx context.Context, r io.Reader) ([]string, error) {
+	ociStore, err := getLocalOciStore()
+	if err != nil {
+		return nil, fmt.Errorf("getting oci store: %w", err)
+	}
+	return importImages(ctx, ociStore, r)
+}
+
+// ImportGadgetImages imports all the named gadget images from the src file, see ImportImage
+func ImportGadgetImages(ctx context.Context, srcFile string) ([]string, error) {
+	f, err := os.Open(srcFile)
+	if err != nil {
+		return nil, fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+	return ImportImage(ctx, f)
+}
+
+func importImages(ctx context.Context, dst oras.Target, r io.Reader) ([]string, error) {
+	br := bufio.NewReader(r)
+	// Support archives that were compressed for the transfer
+	if magic, err := br.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing archive: %w", err)
+		}
+		defer gr.Close()
+		r = gr
+	} else {
+		r = br
+	}
+
+	// The archive is read randomly, so it needs to be stored first
+	tmpFile, err := os.CreateTemp("", "gadget-import-*.tar")
+	if err != nil {
+		return nil, fmt.Errorf("creating temp file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = io.Copy(tmpFile, r)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading archive: %w", err)
+	}
+
+	src, err := oci.NewFromTar(ctx, tmpFile.Name())
+	if err != nil {
+		return nil, fmt.Errorf("loading archive: %w", err)
+	}
+	index, err := readArchiveIndex(tmpFile.Name())
+	if err != nil {
+		return nil, fmt.Errorf("reading index of archive: %w", err)
+	}
+
+	ret := []string{}
+	for _, desc := range index.Manifests {
+		name := archiveImageName(desc)
+		if name == "" {
+			log.Debugf("skipping image %s without name", desc.Digest)
+			continue
+		}
+		targetImage, err := normalizeImageName(name)
+		if err != nil {
+			return ret, fmt.Errorf("normalizing image %q: %w", name, err)
+		}
+		if err := oras.CopyGraph(ctx, src, dst, desc, oras.DefaultCopyGraphOptions); err != nil {
+			return ret, fmt.Errorf("copying image %q to local repository: %w", name, err)
+		}
+		if err := dst.Tag(ctx, desc, targetImage.String()); err != nil {
+			return ret, fmt.Errorf("tagging image %q: %w", name, err)
+		}
+		ret = append(ret, targetImage.String())
+	}
+	if len(ret) == 0 {
+		return nil, errors.New("no named images found in archive")
+	}
+	return ret, nil
+}
+
+// archiveImageName returns the name of an image in the index of an OCI image layout; org.opencontainers.image.ref.name
+// is ignored when it only holds a tag, like for archives of docker
+func archiveImageName(desc ocispec.Descriptor) string {
+	if name := desc.Annotations[annotationImageName]; name != "" {
+		return name
+	}
+	name := desc.Annotations[ocispec.AnnotationRefName]
+	if !strings.ContainsAny(name, "/:@") {
+		return ""
+	}
+	return name
+}
+
+// readArchiveIndex reads the index of an OCI image layout from the tar archive at path
+func readArchiveIndex(path string) (*ocispec.Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s not found", archiveIndexFile)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimPrefix(header.Name, "./") != archiveIndexFile {
+			continue
+		}
+		index := &ocispec.Index{}
+		if err := json.NewDecoder(tr).Decode(index); err != nil {
+			return nil, err
+		}
+		return index, nil
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+	"oras.land/oras-go/v2/content/memory"
+)
+
+func TestExportImportImages(t *testing.T) {
+	ctx := context.Background()
+	src := memory.New()
+	buildTestImage(t, src, "mygadget:latest", map[string]string{ArchAmd64: "amd64 program", ArchArm64: "arm64 program"})
+	buildTestImage(t, src, "other:v1", map[string]string{ArchAmd64: "other program"})
+
+	var archive bytes.Buffer
+	require.NoError(t, exportImages(ctx, src, &archive, "mygadget", "other:v1"))
+
+	// The images are named for docker and containerd as well
+	path := filepath.Join(t.TempDir(), "archive.tar")
+	require.NoError(t, os.WriteFile(path, archive.Bytes(), 0o644))
+	index, err := readArchiveIndex(path)
+	require.NoError(t, err)
+	var named []string
+	for _, m := range index.Manifests {
+		require.Equal(t, m.Annotations[ocispec.AnnotationRefName], m.Annotations[annotationImageName])
+		if name := archiveImageName(m); name != "" {
+			named = append(named, name)
+		}
+	}
+	require.Len(t, named, 2)
+
+	// Compressed archives can be imported as well
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	_, err = gw.Write(archive.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	for name, r := range map[string]*bytes.Buffer{"plain": &archive, "gzip": &compressed} {
+		t.Run(name, func(t *testing.T) {
+			dst := memory.New()
+			names, err := importImages(ctx, dst, bytes.NewReader(r.Bytes()))
+			require.NoError(t, err)
+			require.ElementsMatch(t, []string{
+				"ghcr.io/inspektor-gadget/gadget/mygadget:latest",
+				"ghcr.io/inspektor-gadget/gadget/other:v1",
+			}, names)
+
+			// All architectures and layers are imported
+			imported, err := getIndex(ctx, dst, "mygadget:latest")
+			require.NoError(t, err)
+			require.Equal(t, []string{ArchAmd64, ArchArm64}, indexArchitectures(imported))
+			for _, arch := range []string{ArchAmd64, ArchArm64} {
+				m, err := selectManifest(imported, arch)
+				require.NoError(t, err)
+				manifestJson, err := getContentBytesFromDescriptor(ctx, dst, *m)
+				require.NoError(t, err)
+				manifest := &ocispec.Manifest{}
+				require.NoError(t, json.Unmarshal(manifestJson, manifest))
+				require.NotEmpty(t, manifest.Layers)
+				for _, layer := range manifest.Layers {
+					exists, err := dst.Exists(ctx, layer)
+					require.NoError(t, err)
+					require.True(t, exists)
+				}
+			}
+		})
+	}
+
+	_, err = importImages(ctx, memory.New(), bytes.NewReader([]byte("not an archive")))
+	require.Error(t, err)
+	require.Error(t, exportImages(ctx, src, &bytes.Buffer{}, "missing"))
+}
+
+func TestArchiveImageName(t *testing.T) {
+	for _, c := range []struct {
+		annotations map[string]string
+		expected    string
+	}{
+		{map[string]string{ocispec.AnnotationRefName: "ghcr.io/org/gadget:v1"}, "ghcr.io/org/gadget:v1"},
+		{map[string]string{ocispec.AnnotationRefName: "v1", annotationImageName: "docker.io/org/gadget:v1"}, "docker.io/org/gadget:v1"},
+		// Only a tag, as written by docker
+		{map[string]string{ocispec.AnnotationRefName: "v1"}, ""},
+		{nil, ""},
+	} {
+		require.Equal(t, c.expected, archiveImageName(ocispec.Descriptor{Annotations: c.annotations}))
+	}
+}
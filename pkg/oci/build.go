@@ -22,6 +22,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/distribution/reference"
 	"github.com/opencontainers/image-spec/specs-go"
@@ -44,6 +46,7 @@ const (
 	eBPFObjectMediaType = "application/vnd.gadget.ebpf.program.v1+binary"
 	wasmObjectMediaType = "application/vnd.gadget.wasm.program.v1+binary"
 	btfgenMediaType     = "application/vnd.gadget.btfgen.v1+binary"
+	syntheticMediaType  = "application/vnd.gadget.synthetic.v1+yaml"
 	metadataMediaType   = "application/vnd.gadget.config.v1+yaml"
 )
 
@@ -54,6 +57,8 @@ type ObjectPath struct {
 	Wasm string
 	// Optional path to tarball containing BTF files generated with btfgen
 	Btfgen string
+	// Optional path to the spec of a synthetic gadget, generating events without eBPF; EBPF is empty in that case
+	Synthetic string
 }
 
 type BuildGadgetImageOpts struct {
@@ -201,11 +206,27 @@ func createEmptyDesc(ctx context.Context, target oras.Target) (ocispec.Descripto
 func createManifestForTarget(ctx context.Context, target oras.Target, metadataFilePath, arch string, paths *ObjectPath, createdDate string) (ocispec.Descriptor, error) {
 	layerDescs := []ocispec.Descriptor{}
 
-	progDesc, err := createLayerDesc(ctx, target, paths.EBPF, eBPFObjectMediaType)
-	if err != nil {
-		return ocispec.Descriptor{}, fmt.Errorf("creating and pushing eBPF descriptor: %w", err)
+	// artifactType is used if there's no metadata, see below
+	artifactType := eBPFObjectMediaType
+
+	if paths.EBPF != "" {
+		progDesc, err := createLayerDesc(ctx, target, paths.EBPF, eBPFObjectMediaType)
+		if err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("creating and pushing eBPF descriptor: %w", err)
+		}
+		layerDescs = append(layerDescs, progDesc)
+	}
+
+	if paths.Synthetic != "" {
+		syntheticDesc, err := createLayerDesc(ctx, target, paths.Synthetic, syntheticMediaType)
+		if err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("creating and pushing synthetic descriptor: %w", err)
+		}
+		layerDescs = append(layerDescs, syntheticDesc)
+		if paths.EBPF == "" {
+			artifactType = syntheticMediaType
+		}
 	}
-	layerDescs = append(layerDescs, progDesc)
 
 	if paths.Wasm != "" {
 		wasmDesc, err := createLayerDesc(ctx, target, paths.Wasm, wasmObjectMediaType)
@@ -224,10 +245,7 @@ func createManifestForTarget(ctx context.Context, target oras.Target, metadataFi
 	}
 
 	var defDesc ocispec.Descriptor
-	// artifactType must be only set when the config.mediaType is set to
-	// MediaTypeEmptyJSON. In our case, when the metadata file is not provided:
-	// https://github.com/opencontainers/image-spec/blob/f5f87016de46439ccf91b5381cf76faaae2bc28f/manifest.md?plain=1#L170
-	var artifactType string
+	var err error
 
 	if _, err := os.Stat(metadataFilePath); err == nil {
 		// Read the metadata file into a byte array
@@ -236,13 +254,17 @@ func createManifestForTarget(ctx context.Context, target oras.Target, metadataFi
 			return ocispec.Descriptor{}, fmt.Errorf("creating metadata descriptor: %w", err)
 		}
 		defDesc.Annotations[ocispec.AnnotationCreated] = createdDate
+
+		// artifactType must be only set when the config.mediaType is set to
+		// MediaTypeEmptyJSON. In our case, when the metadata file is not provided:
+		// https://github.com/opencontainers/image-spec/blob/f5f87016de46439ccf91b5381cf76faaae2bc28f/manifest.md?plain=1#L170
+		artifactType = ""
 	} else {
 		// Create an empty descriptor
 		defDesc, err = createEmptyDesc(ctx, target)
 		if err != nil {
 			return ocispec.Descriptor{}, fmt.Errorf("creating empty descriptor: %w", err)
 		}
-		artifactType = eBPFObjectMediaType
 
 		// Even without metadata, we can still set some annotations
 		defDesc.Annotations = map[string]string{
@@ -303,25 +325,11 @@ func createImageIndex(ctx context.Context, target oras.Target, o *BuildGadgetIma
 		return ocispec.Descriptor{}, fmt.Errorf("no eBPF objects found")
 	}
 
-	// Create the index which combines the architectures and push it to the memory store
-	index := ocispec.Index{
-		Versioned: specs.Versioned{
-			SchemaVersion: 2, // historical value. does not pertain to OCI or docker version
-		},
-		MediaType:   ocispec.MediaTypeImageIndex,
-		Manifests:   layers,
-		Annotations: layers[0].Annotations,
-	}
-	indexJson, err := json.Marshal(index)
-	if err != nil {
-		return ocispec.Descriptor{}, fmt.Errorf("marshalling manifest: %w", err)
-	}
-	indexDesc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageIndex, indexJson)
-	indexDesc.Annotations = index.Annotations
+	// Sort, so that building the same objects always results in the same digest
+	slices.SortFunc(layers, func(a, b ocispec.Descriptor) int {
+		return strings.Compare(a.Platform.Architecture, b.Platform.Architecture)
+	})
 
-	err = pushDescriptorIfNotExists(ctx, target, indexDesc, bytes.NewReader(indexJson))
-	if err != nil {
-		return ocispec.Descriptor{}, fmt.Errorf("pushing manifest index: %w", err)
-	}
-	return indexDesc, nil
+	// Create the index which combines the architectures and push it to the memory store
+	return pushIndex(ctx, target, layers, layers[0].Annotations)
 }
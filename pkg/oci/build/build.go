@@ -0,0 +1,364 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package build builds gadget images: it compiles the eBPF program and the optional wasm module of a gadget, either
+// with local tools or in a builder container, validates or generates its metadata and stores the resulting image in
+// the local OCI store. It's what "ig image build" uses and can be used to build images without the CLI.
+package build
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/oci"
+)
+
+//go:embed Makefile.build
+var makefile []byte
+
+//go:embed Makefile.build.btfgen
+var makefileBtfgen []byte
+
+// DefaultBuilderImage is the image used to build gadgets in a container. It can be overridden at build time.
+var DefaultBuilderImage = "ghcr.io/inspektor-gadget/ebpf-builder:latest"
+
+const (
+	DefaultBuildFile  = "build.yaml"
+	DefaultEBPFSource = "program.bpf.c"
+	DefaultMetadata   = "gadget.yaml"
+)
+
+// Archs are the architectures eBPF programs are compiled for
+var Archs = []string{oci.ArchAmd64, oci.ArchArm64}
+
+// Config describes the files of a gadget, it's usually read from a build.yaml file. Paths are relative to the
+// directory of the gadget.
+type Config struct {
+	EBPFSource string `yaml:"ebpfsource"`
+	// Wasm is either a compiled module (.wasm) or a Go source file (.go) compiled with TinyGo; it's optional
+	Wasm     string `yaml:"wasm"`
+	Metadata string `yaml:"metadata"`
+	CFlags   string `yaml:"cflags"`
+	// Synthetic is the spec of a gadget generating events without eBPF; no eBPF program is built if it's set
+	Synthetic string `yaml:"synthetic"`
+}
+
+// DefaultConfig returns the configuration used for gadgets without a build file
+func DefaultConfig() *Config {
+	return &Config{
+		EBPFSource: DefaultEBPFSource,
+		Metadata:   DefaultMetadata,
+	}
+}
+
+// ReadConfig reads the build file at path. Missing fields are set to their defaults.
+func ReadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading build file: %w", err)
+	}
+	return ParseConfig(content)
+}
+
+// ParseConfig parses the content of a build file. Missing fields are set to their defaults.
+func ParseConfig(content []byte) (*Config, error) {
+	conf := DefaultConfig()
+	if err := yaml.Unmarshal(content, conf); err != nil {
+		return nil, fmt.Errorf("unmarshaling build file: %w", err)
+	}
+	return conf, nil
+}
+
+// Options configure how an image is built
+type Options struct {
+	// Path is the directory of the gadget; defaults to the current directory
+	Path string
+
+	// Config describes the files of the gadget; defaults to DefaultConfig()
+	Config *Config
+
+	// Image is the name of the built image in the "name:tag" format; the image isn't named if it's empty
+	Image string
+
+	// Objects are prebuilt eBPF objects by architecture, e.g. {"amd64": "amd64.bpf.o"}. If set, the eBPF source
+	// isn't compiled. Relative paths are relative to Path.
+	Objects map[string]string
+
+	// Local builds using the tools installed locally instead of a builder container
+	Local bool
+
+	// BuilderImage is the image of the builder container; defaults to DefaultBuilderImage
+	BuilderImage string
+
+	// OutputDir stores the files generated while building; a temporary directory is used if it's empty
+	OutputDir string
+
+	// UpdateMetadata updates the metadata according to the eBPF program
+	UpdateMetadata bool
+
+	// ValidateMetadata validates the metadata before building the image
+	ValidateMetadata bool
+
+	// Btfgen generates BTF files for the kernels of BtfHubArchive and adds them to the image
+	Btfgen        bool
+	BtfHubArchive string
+
+	// Output receives the output of the build tools. If it's nil, the output is only part of the errors returned.
+	Output io.Writer
+
+	// CreatedDate is the date of the image; defaults to now
+	CreatedDate time.Time
+}
+
+func (o *Options) path(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(o.Path, file)
+}
+
+func (o *Options) validate() error {
+	if o.Local && o.BuilderImage != "" && o.BuilderImage != DefaultBuilderImage {
+		return errors.New("building locally and using a builder image are mutually exclusive")
+	}
+	if o.Btfgen && o.BtfHubArchive == "" {
+		return errors.New("btfgen requires the path to the btfhub-archive files")
+	}
+	if len(o.Objects) == 0 {
+		return nil
+	}
+	if o.Config.Synthetic != "" {
+		return errors.New("synthetic gadgets don't have eBPF objects")
+	}
+	if o.Btfgen {
+		return errors.New("btfgen isn't supported for prebuilt eBPF objects")
+	}
+	for arch, path := range o.Objects {
+		if !slices.Contains(Archs, arch) {
+			return fmt.Errorf("unsupported architecture %q: supported values are %s", arch, strings.Join(Archs, ", "))
+		}
+		if _, err := os.Stat(o.path(path)); err != nil {
+			return fmt.Errorf("eBPF object for %s: %w", arch, err)
+		}
+	}
+	return nil
+}
+
+// Build builds a gadget image according to opts and stores it in the local OCI store
+func Build(ctx context.Context, opts *Options) (*oci.GadgetImageDesc, error) {
+	o := *opts
+	if o.Path == "" {
+		o.Path = "."
+	}
+	if o.Config == nil {
+		o.Config = DefaultConfig()
+	}
+	if o.BuilderImage == "" {
+		o.BuilderImage = DefaultBuilderImage
+	}
+	if o.CreatedDate.IsZero() {
+		o.CreatedDate = time.Now()
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	if o.Config.Synthetic != "" {
+		return buildSynthetic(ctx, &o)
+	}
+
+	if o.OutputDir != "" {
+		if _, err := os.Stat(o.OutputDir); err != nil {
+			return nil, err
+		}
+	} else {
+		tmpDir, err := os.MkdirTemp("", "gadget-build-")
+		if err != nil {
+			return nil, fmt.Errorf("creating temp dir: %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		o.OutputDir = tmpDir
+	}
+
+	var targets []string
+	var ebpfSource string
+	if len(o.Objects) == 0 {
+		ebpfSource = o.path(o.Config.EBPFSource)
+		if _, err := os.Stat(ebpfSource); errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("source file %q not found", o.Config.EBPFSource)
+		}
+		targets = append(targets, "all")
+		if o.Btfgen {
+			targets = append(targets, "btfgen")
+		}
+	} else if o.Config.Wasm != "" && !strings.HasSuffix(o.Config.Wasm, ".wasm") {
+		// Only the wasm module needs to be compiled
+		targets = append(targets, "wasm")
+	}
+
+	if len(targets) > 0 {
+		if err := compile(ctx, &o, targets); err != nil {
+			return nil, err
+		}
+	}
+
+	buildOpts := &oci.BuildGadgetImageOpts{
+		EBPFSourcePath:   ebpfSource,
+		ObjectPaths:      objectPaths(&o),
+		MetadataPath:     o.path(o.Config.Metadata),
+		UpdateMetadata:   o.UpdateMetadata,
+		ValidateMetadata: o.ValidateMetadata,
+		CreatedDate:      o.CreatedDate.Format(time.RFC3339),
+	}
+
+	return oci.BuildGadgetImage(ctx, buildOpts, o.Image)
+}
+
+// objectPaths returns the files to add to the image for each architecture
+func objectPaths(o *Options) map[string]*oci.ObjectPath {
+	archs := Archs
+	if len(o.Objects) > 0 {
+		archs = make([]string, 0, len(o.Objects))
+		for arch := range o.Objects {
+			archs = append(archs, arch)
+		}
+		slices.Sort(archs)
+	}
+
+	res := map[string]*oci.ObjectPath{}
+	for _, arch := range archs {
+		obj := &oci.ObjectPath{
+			EBPF: filepath.Join(o.OutputDir, arch+".bpf.o"),
+		}
+		if path, ok := o.Objects[arch]; ok {
+			obj.EBPF = o.path(path)
+		}
+
+		// TODO: the same wasm file is provided for all architectures. Should we allow per-arch
+		// wasm files?
+		if strings.HasSuffix(o.Config.Wasm, ".wasm") {
+			// An already-built wasm file
+			obj.Wasm = o.path(o.Config.Wasm)
+		} else if o.Config.Wasm != "" {
+			// Built from source
+			obj.Wasm = filepath.Join(o.OutputDir, "program.wasm")
+		}
+
+		if o.Btfgen {
+			archClean := arch
+			if arch == oci.ArchAmd64 {
+				archClean = "x86_64"
+			}
+			obj.Btfgen = filepath.Join(o.OutputDir, fmt.Sprintf("btfs-%s.tar.gz", archClean))
+		}
+
+		res[arch] = obj
+	}
+	return res
+}
+
+// compile runs the given targets of the build Makefile
+func compile(ctx context.Context, o *Options, targets []string) error {
+	if o.Local {
+		return compileLocal(ctx, o, targets)
+	}
+	return compileInContainer(ctx, o, targets)
+}
+
+func makeArgs(makefilePath, ebpfSource, wasm, outputDir, cflags string) []string {
+	return []string{
+		"make", "-f", makefilePath,
+		"-j", fmt.Sprintf("%d", runtime.NumCPU()),
+		"EBPFSOURCE=" + ebpfSource,
+		"WASM=" + wasm,
+		"OUTPUTDIR=" + outputDir,
+		"CFLAGS=" + cflags,
+	}
+}
+
+func compileLocal(ctx context.Context, o *Options, targets []string) error {
+	makefilePath := filepath.Join(o.OutputDir, "Makefile")
+	if err := os.WriteFile(makefilePath, makefile, 0o644); err != nil {
+		return fmt.Errorf("writing Makefile: %w", err)
+	}
+
+	wasm := ""
+	if o.Config.Wasm != "" {
+		wasm = o.path(o.Config.Wasm)
+	}
+	args := makeArgs(makefilePath, o.path(o.Config.EBPFSource), wasm, o.OutputDir, o.Config.CFlags)
+
+	if o.Btfgen {
+		makefileBtfgenPath := filepath.Join(o.OutputDir, "Makefile.build.btfgen")
+		if err := os.WriteFile(makefileBtfgenPath, makefileBtfgen, 0o644); err != nil {
+			return fmt.Errorf("writing Makefile: %w", err)
+		}
+		args = append(args, "BTFHUB_ARCHIVE="+o.BtfHubArchive)
+	}
+	args = append(args, targets...)
+
+	buildCmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	out, err := buildCmd.CombinedOutput()
+	if o.Output != nil {
+		o.Output.Write(out)
+	}
+	if err != nil {
+		return fmt.Errorf("build script: %w: %s", err, out)
+	}
+	return nil
+}
+
+// buildSynthetic creates an image with the spec of a synthetic gadget instead of an eBPF program. The metadata can't
+// be generated or validated, as both rely on the eBPF program.
+func buildSynthetic(ctx context.Context, o *Options) (*oci.GadgetImageDesc, error) {
+	// Ignore instead of failing, so all gadgets can be built with the same options
+	if o.UpdateMetadata {
+		log.Warn("updating the metadata is ignored for synthetic gadgets")
+	}
+	if o.Btfgen {
+		log.Warn("btfgen is ignored for synthetic gadgets")
+	}
+	synthetic := o.path(o.Config.Synthetic)
+	if _, err := os.Stat(synthetic); err != nil {
+		return nil, fmt.Errorf("synthetic spec %q: %w", o.Config.Synthetic, err)
+	}
+
+	objectsPaths := map[string]*oci.ObjectPath{}
+	for _, arch := range Archs {
+		objectsPaths[arch] = &oci.ObjectPath{
+			Synthetic: synthetic,
+		}
+	}
+
+	buildOpts := &oci.BuildGadgetImageOpts{
+		ObjectPaths:  objectsPaths,
+		MetadataPath: o.path(o.Config.Metadata),
+		CreatedDate:  o.CreatedDate.Format(time.RFC3339),
+	}
+
+	return oci.BuildGadgetImage(ctx, buildOpts, o.Image)
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/oci"
+)
+
+func TestParseConfig(t *testing.T) {
+	conf, err := ParseConfig([]byte("wasm: program.go\ncflags: -DDEBUG\n"))
+	require.NoError(t, err)
+	require.Equal(t, &Config{
+		EBPFSource: DefaultEBPFSource,
+		Wasm:       "program.go",
+		Metadata:   DefaultMetadata,
+		CFlags:     "-DDEBUG",
+	}, conf)
+
+	_, err = ParseConfig([]byte("wasm: [\n"))
+	require.Error(t, err)
+}
+
+func TestObjectPaths(t *testing.T) {
+	o := &Options{
+		Path:      "gadget",
+		Config:    &Config{Wasm: "module.wasm"},
+		OutputDir: "/out",
+		Btfgen:    true,
+	}
+	require.Equal(t, map[string]*oci.ObjectPath{
+		oci.ArchAmd64: {
+			EBPF:   "/out/amd64.bpf.o",
+			Wasm:   "gadget/module.wasm",
+			Btfgen: "/out/btfs-x86_64.tar.gz",
+		},
+		oci.ArchArm64: {
+			EBPF:   "/out/arm64.bpf.o",
+			Wasm:   "gadget/module.wasm",
+			Btfgen: "/out/btfs-arm64.tar.gz",
+		},
+	}, objectPaths(o))
+
+	// Prebuilt objects are used as they are, only for their architectures
+	o = &Options{
+		Path:      "gadget",
+		Config:    &Config{Wasm: "program.go"},
+		OutputDir: "/out",
+		Objects:   map[string]string{oci.ArchArm64: "/objects/arm64.o"},
+	}
+	require.Equal(t, map[string]*oci.ObjectPath{
+		oci.ArchArm64: {
+			EBPF: "/objects/arm64.o",
+			Wasm: "/out/program.wasm",
+		},
+	}, objectPaths(o))
+}
+
+func TestValidateOptions(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "amd64.bpf.o"), nil, 0o644))
+
+	valid := Options{
+		Path:    dir,
+		Config:  DefaultConfig(),
+		Objects: map[string]string{oci.ArchAmd64: "amd64.bpf.o"},
+	}
+	require.NoError(t, valid.validate())
+
+	for name, change := range map[string]func(o *Options){
+		"local_and_builder_image": func(o *Options) { o.Local = true; o.BuilderImage = "builder:latest" },
+		"btfgen_without_archive":  func(o *Options) { o.Objects = nil; o.Btfgen = true },
+		"btfgen_prebuilt":         func(o *Options) { o.Btfgen = true; o.BtfHubArchive = dir },
+		"synthetic_prebuilt":      func(o *Options) { o.Config = &Config{Synthetic: "spec.yaml"} },
+		"unknown_arch":            func(o *Options) { o.Objects = map[string]string{"riscv64": "amd64.bpf.o"} },
+		"missing_object":          func(o *Options) { o.Objects = map[string]string{oci.ArchArm64: "arm64.bpf.o"} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			o := valid
+			change(&o)
+			require.Error(t, o.validate())
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2023-2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	log "github.com/sirupsen/logrus"
+)
+
+// compileInContainer runs the given targets of the build Makefile in a builder container. The directory of the
+// gadget is mounted read-only, so all files have to be below it.
+func compileInContainer(ctx context.Context, o *Options, targets []string) error {
+	workDir, err := filepath.Abs(o.Path)
+	if err != nil {
+		return fmt.Errorf("getting path of the gadget: %w", err)
+	}
+	outputDir, err := filepath.Abs(o.OutputDir)
+	if err != nil {
+		return fmt.Errorf("getting path of the output directory: %w", err)
+	}
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("creating docker client: %w", err)
+	}
+	defer cli.Close()
+
+	if err := ensureBuilderImage(ctx, cli, o.BuilderImage); err != nil {
+		return err
+	}
+
+	wasm := ""
+	if o.Config.Wasm != "" {
+		wasm = filepath.Join("/work", o.Config.Wasm)
+	}
+	cmd := makeArgs("/Makefile", filepath.Join("/work", o.Config.EBPFSource), wasm, "/out", o.Config.CFlags)
+
+	mounts := []mount.Mount{
+		{
+			Type:     mount.TypeBind,
+			Target:   "/work",
+			Source:   workDir,
+			ReadOnly: true,
+		},
+		{
+			Type:   mount.TypeBind,
+			Target: "/out",
+			Source: outputDir,
+		},
+	}
+
+	if o.Btfgen {
+		btfHubArchive, err := filepath.Abs(o.BtfHubArchive)
+		if err != nil {
+			return fmt.Errorf("getting path of the btfhub-archive: %w", err)
+		}
+		cmd = append(cmd, "BTFHUB_ARCHIVE=/btfhub-archive")
+		mounts = append(mounts, mount.Mount{
+			Type:   mount.TypeBind,
+			Target: "/btfhub-archive",
+			Source: btfHubArchive,
+		})
+	}
+	cmd = append(cmd, targets...)
+
+	resp, err := cli.ContainerCreate(
+		ctx,
+		&container.Config{
+			Image: o.BuilderImage,
+			Cmd:   cmd,
+			User:  fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		},
+		&container.HostConfig{
+			Mounts: mounts,
+		},
+		nil, nil, "",
+	)
+	if err != nil {
+		return fmt.Errorf("creating builder container: %w", err)
+	}
+	defer func() {
+		// Use a new context, so the container is removed even if ctx was canceled
+		if err := cli.ContainerRemove(context.Background(), resp.ID, container.RemoveOptions{Force: true}); err != nil {
+			log.Warnf("Failed to remove builder container: %s", err)
+		}
+	}()
+
+	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("starting builder container: %w", err)
+	}
+
+	var status container.WaitResponse
+
+	statusCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return fmt.Errorf("waiting for builder container: %w", err)
+		}
+	case status = <-statusCh:
+	}
+
+	if status.StatusCode == 0 && o.Output == nil {
+		return nil
+	}
+
+	logs, err := cli.ContainerLogs(ctx, resp.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return fmt.Errorf("getting builder container logs: %w", err)
+	}
+	defer logs.Close()
+
+	out := &bytes.Buffer{}
+	var w io.Writer = out
+	if o.Output != nil {
+		w = io.MultiWriter(out, o.Output)
+	}
+	stdcopy.StdCopy(w, w, logs)
+
+	if status.StatusCode != 0 {
+		return fmt.Errorf("builder container exited with status %d: %s", status.StatusCode, out)
+	}
+	return nil
+}
+
+// ensureBuilderImage pulls the builder image if it's not available yet
+func ensureBuilderImage(ctx context.Context, cli *client.Client, builderImage string) error {
+	f := filters.NewArgs()
+	f.Add("reference", builderImage)
+
+	images, err := cli.ImageList(ctx, image.ListOptions{Filters: f})
+	if err != nil {
+		return fmt.Errorf("listing images: %w", err)
+	}
+	for _, img := range images {
+		if slices.Contains(img.RepoTags, builderImage) {
+			return nil
+		}
+	}
+
+	log.Infof("Pulling builder image %s. It could take few minutes.", builderImage)
+	reader, err := cli.ImagePull(ctx, builderImage, image.PullOptions{})
+	if err != nil {
+		return fmt.Errorf("pulling builder image: %w", err)
+	}
+	defer reader.Close()
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return fmt.Errorf("pulling builder image: %w", err)
+	}
+	return nil
+}
@@ -128,8 +128,8 @@ func createOrUpdateMetadataFile(ctx context.Context, opts *BuildGadgetImageOpts)
 		return fmt.Errorf("writing metadata file: %w", err)
 	}
 
-	// fix owner of created metadata file
-	if !update {
+	// fix owner of created metadata file; there's no source file for prebuilt eBPF objects
+	if !update && opts.EBPFSourcePath != "" {
 		if err := fixOwner(opts.MetadataPath, opts.EBPFSourcePath); err != nil {
 			log.Warnf("Failed to fix metadata file owner: %v", err)
 		}
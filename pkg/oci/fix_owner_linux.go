@@ -37,6 +37,11 @@ func fixOwner(targetFile, modelFile string) error {
 }
 
 func fixGeneratedFilesOwner(opts *BuildGadgetImageOpts) error {
+	// Nothing is generated without an eBPF program, e.g. for synthetic gadgets
+	if opts.EBPFSourcePath == "" {
+		return nil
+	}
+
 	info, err := os.Stat(opts.EBPFSourcePath)
 	if err != nil {
 		return err
@@ -0,0 +1,324 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+)
+
+// Annotations of the signature layer of images signed by cosign without keys, taken from:
+// https://github.com/sigstore/cosign/blob/v2.2.4/pkg/oci/static/options.go
+const (
+	certificateAnnotation = "dev.sigstore.cosign/certificate"
+	chainAnnotation       = "dev.sigstore.cosign/chain"
+	bundleAnnotation      = "dev.sigstore.cosign/bundle"
+)
+
+// Extensions of Fulcio certificates holding the OIDC issuer, see
+// https://github.com/sigstore/fulcio/blob/main/docs/oid-info.md
+var (
+	oidIssuer   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+	oidIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+)
+
+// KeylessOptions configures the verification of images signed by cosign without keys: the signature is made with
+// a short-lived certificate issued by Fulcio to an OIDC identity and recorded in the Rekor transparency log.
+type KeylessOptions struct {
+	// Identity the certificate must be issued to, e.g. an email address or the URI of a CI workflow
+	Identity string `yaml:"identity"`
+
+	// IdentityRegexp must match the whole identity the certificate is issued to
+	IdentityRegexp string `yaml:"identityRegexp"`
+
+	// Issuer is the OIDC issuer that must have authenticated the identity, e.g.
+	// "https://token.actions.githubusercontent.com"
+	Issuer string `yaml:"issuer"`
+
+	// IssuerRegexp must match the whole OIDC issuer
+	IssuerRegexp string `yaml:"issuerRegexp"`
+
+	// FulcioRoots are the PEM encoded root (and optionally intermediate) certificates of Fulcio
+	FulcioRoots string `yaml:"fulcioRoots"`
+
+	// RekorPublicKey is the PEM encoded public key of the Rekor transparency log
+	RekorPublicKey string `yaml:"rekorPublicKey"`
+}
+
+// Enabled returns whether keyless verification is requested instead of the verification using a public key
+func (k *KeylessOptions) Enabled() bool {
+	return k.Identity != "" || k.IdentityRegexp != "" || k.Issuer != "" || k.IssuerRegexp != ""
+}
+
+// Validate checks that the options are complete
+func (k *KeylessOptions) Validate() error {
+	if k.Identity == "" && k.IdentityRegexp == "" {
+		return fmt.Errorf("keyless verification requires an identity or an identity regexp")
+	}
+	if k.Issuer == "" && k.IssuerRegexp == "" {
+		return fmt.Errorf("keyless verification requires an issuer or an issuer regexp")
+	}
+	for _, expr := range []string{k.IdentityRegexp, k.IssuerRegexp} {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("invalid regexp %q: %w", expr, err)
+		}
+	}
+	if k.FulcioRoots == "" {
+		return fmt.Errorf("keyless verification requires the Fulcio root certificates")
+	}
+	if k.RekorPublicKey == "" {
+		return fmt.Errorf("keyless verification requires the public key of Rekor")
+	}
+	return nil
+}
+
+// matches returns whether value equals exact or matches expr; empty constraints are ignored
+func matches(value, exact, expr string) bool {
+	if exact != "" && value != exact {
+		return false
+	}
+	if expr != "" && !regexp.MustCompile("^(?:"+expr+")$").MatchString(value) {
+		return false
+	}
+	return true
+}
+
+// certificateIssuer returns the OIDC issuer stored in a Fulcio certificate
+func certificateIssuer(cert *x509.Certificate) (string, error) {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidIssuer) {
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err != nil {
+				return "", fmt.Errorf("decoding issuer: %w", err)
+			}
+			return issuer, nil
+		}
+	}
+	// Older certificates store the issuer without encoding
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidIssuerV1) {
+			return string(ext.Value), nil
+		}
+	}
+	return "", fmt.Errorf("certificate has no issuer")
+}
+
+func (k *KeylessOptions) checkIdentity(cert *x509.Certificate) error {
+	issuer, err := certificateIssuer(cert)
+	if err != nil {
+		return err
+	}
+	if !matches(issuer, k.Issuer, k.IssuerRegexp) {
+		return fmt.Errorf("certificate issuer %q not accepted", issuer)
+	}
+
+	identities := cryptoutils.GetSubjectAlternateNames(cert)
+	if !slices.ContainsFunc(identities, func(identity string) bool {
+		return matches(identity, k.Identity, k.IdentityRegexp)
+	}) {
+		return fmt.Errorf("certificate identities %v not accepted", identities)
+	}
+	return nil
+}
+
+// verifyCertificate checks that cert was issued by Fulcio and valid at the given time; roots given by the signature
+// are never trusted
+func (k *KeylessOptions) verifyCertificate(cert *x509.Certificate, chain []byte, at time.Time) error {
+	trusted, err := cryptoutils.UnmarshalCertificatesFromPEM([]byte(k.FulcioRoots))
+	if err != nil {
+		return fmt.Errorf("parsing Fulcio roots: %w", err)
+	}
+	if len(trusted) == 0 {
+		return fmt.Errorf("no Fulcio roots given")
+	}
+
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	for _, c := range trusted {
+		if bytes.Equal(c.RawIssuer, c.RawSubject) {
+			roots.AddCert(c)
+		} else {
+			intermediates.AddCert(c)
+		}
+	}
+	if len(chain) > 0 {
+		chainCerts, err := cryptoutils.UnmarshalCertificatesFromPEM(chain)
+		if err != nil {
+			return fmt.Errorf("parsing certificate chain: %w", err)
+		}
+		for _, c := range chainCerts {
+			if !bytes.Equal(c.RawIssuer, c.RawSubject) {
+				intermediates.AddCert(c)
+			}
+		}
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return fmt.Errorf("verifying certificate: %w", err)
+	}
+	return nil
+}
+
+// rekorBundle is the proof of inclusion in Rekor stored by cosign, see
+// https://github.com/sigstore/cosign/blob/v2.2.4/pkg/cosign/bundle/rekor.go
+type rekorBundle struct {
+	SignedEntryTimestamp []byte       `json:"SignedEntryTimestamp"`
+	Payload              rekorPayload `json:"Payload"`
+}
+
+// rekorPayload is signed by Rekor in its canonical JSON form, i.e. with sorted keys
+type rekorPayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// hashedRekord is the entry cosign adds to Rekor for a signature
+type hashedRekord struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// verifyBundle checks that the bundle was signed by Rekor and that its entry matches the signature, returning the
+// time the entry was added to the log
+func (k *KeylessOptions) verifyBundle(bundleBytes []byte, sig *cosignSignature, payload []byte) (time.Time, error) {
+	bundle := &rekorBundle{}
+	if err := json.Unmarshal(bundleBytes, bundle); err != nil {
+		return time.Time{}, fmt.Errorf("decoding bundle: %w", err)
+	}
+
+	rekorKey, err := cryptoutils.UnmarshalPEMToPublicKey([]byte(k.RekorPublicKey))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing Rekor public key: %w", err)
+	}
+	der, err := cryptoutils.MarshalPublicKeyToDER(rekorKey)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("encoding Rekor public key: %w", err)
+	}
+	logID := sha256.Sum256(der)
+	if bundle.Payload.LogID != hex.EncodeToString(logID[:]) {
+		return time.Time{}, fmt.Errorf("bundle is from an unknown log %q", bundle.Payload.LogID)
+	}
+
+	verifier, err := signature.LoadVerifier(rekorKey, crypto.SHA256)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("loading Rekor verifier: %w", err)
+	}
+	canonical, err := json.Marshal(bundle.Payload)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("encoding bundle payload: %w", err)
+	}
+	if err := verifier.VerifySignature(bytes.NewReader(bundle.SignedEntryTimestamp), bytes.NewReader(canonical)); err != nil {
+		return time.Time{}, fmt.Errorf("verifying signed entry timestamp: %w", err)
+	}
+
+	body, err := base64.StdEncoding.DecodeString(bundle.Payload.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("decoding entry: %w", err)
+	}
+	entry := &hashedRekord{}
+	if err := json.Unmarshal(body, entry); err != nil {
+		return time.Time{}, fmt.Errorf("decoding entry: %w", err)
+	}
+	payloadHash := sha256.Sum256(payload)
+	switch {
+	case entry.Kind != "hashedrekord":
+		return time.Time{}, fmt.Errorf("unsupported entry kind %q", entry.Kind)
+	case entry.Spec.Data.Hash.Algorithm != "sha256" || entry.Spec.Data.Hash.Value != hex.EncodeToString(payloadHash[:]):
+		return time.Time{}, fmt.Errorf("entry doesn't match the signed payload")
+	case !bytes.Equal(entry.Spec.Signature.Content, sig.signature):
+		return time.Time{}, fmt.Errorf("entry doesn't match the signature")
+	case !bytes.Equal(bytes.TrimSpace(entry.Spec.Signature.PublicKey.Content), bytes.TrimSpace(sig.certificate)):
+		return time.Time{}, fmt.Errorf("entry doesn't match the certificate")
+	}
+
+	return time.Unix(bundle.Payload.IntegratedTime, 0), nil
+}
+
+// verifyKeyless verifies a signature made by cosign without keys
+func (k *KeylessOptions) verifyKeyless(sig *cosignSignature, payload []byte) error {
+	if err := k.Validate(); err != nil {
+		return err
+	}
+	if len(sig.certificate) == 0 {
+		return fmt.Errorf("signature has no certificate")
+	}
+	if len(sig.bundle) == 0 {
+		return fmt.Errorf("signature has no transparency log bundle")
+	}
+
+	certs, err := cryptoutils.UnmarshalCertificatesFromPEM(sig.certificate)
+	if err != nil {
+		return fmt.Errorf("parsing certificate: %w", err)
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("signature has no certificate")
+	}
+	cert := certs[0]
+
+	// Fulcio certificates are only valid for a few minutes, so check them at the time the signature was logged
+	integratedTime, err := k.verifyBundle(sig.bundle, sig, payload)
+	if err != nil {
+		return fmt.Errorf("verifying transparency log bundle: %w", err)
+	}
+	if err := k.verifyCertificate(cert, sig.chain, integratedTime); err != nil {
+		return err
+	}
+	if err := k.checkIdentity(cert); err != nil {
+		return err
+	}
+
+	verifier, err := signature.LoadVerifier(cert.PublicKey, crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("loading verifier: %w", err)
+	}
+	if err := verifier.VerifySignature(bytes.NewReader(sig.signature), bytes.NewReader(payload)); err != nil {
+		return fmt.Errorf("verifying signature: %w", err)
+	}
+	return nil
+}
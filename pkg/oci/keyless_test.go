@@ -0,0 +1,228 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testIdentity = "https://github.com/inspektor-gadget/gadgets/.github/workflows/release.yml@refs/heads/main"
+	testIssuer   = "https://token.actions.githubusercontent.com"
+)
+
+type keylessFixture struct {
+	opts      *KeylessOptions
+	sig       *cosignSignature
+	payload   []byte
+	rekorKey  *ecdsa.PrivateKey
+	signedAt  time.Time
+	leafBytes []byte
+}
+
+func mustKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+func pemCert(t *testing.T, der []byte) []byte {
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	encoded, err := cryptoutils.MarshalCertificateToPEM(cert)
+	require.NoError(t, err)
+	return encoded
+}
+
+// newKeylessFixture creates a Fulcio-like CA issuing a short-lived certificate to identity, a signature of a payload
+// made with it and a bundle of a Rekor-like log
+func newKeylessFixture(t *testing.T, identity string) *keylessFixture {
+	signedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	caKey := mustKey(t)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sigstore"},
+		NotBefore:             signedAt.Add(-24 * time.Hour),
+		NotAfter:              signedAt.Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	issuer, err := asn1.MarshalWithParams(testIssuer, "utf8")
+	require.NoError(t, err)
+	identityURI, err := url.Parse(identity)
+	require.NoError(t, err)
+
+	leafKey := mustKey(t)
+	leafTemplate := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       signedAt.Add(-time.Minute),
+		NotAfter:        signedAt.Add(9 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		URIs:            []*url.URL{identityURI},
+		ExtraExtensions: []pkix.Extension{{Id: oidIssuer, Value: issuer}},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, leafKey.Public(), caKey)
+	require.NoError(t, err)
+	leafPEM := pemCert(t, leafDER)
+
+	payload := []byte(`{"critical":{"image":{"docker-manifest-digest":"sha256:abc"}}}`)
+	payloadHash := sha256.Sum256(payload)
+	signature, err := leafKey.Sign(rand.Reader, payloadHash[:], crypto.SHA256)
+	require.NoError(t, err)
+
+	f := &keylessFixture{
+		opts: &KeylessOptions{
+			Identity:    identity,
+			Issuer:      testIssuer,
+			FulcioRoots: string(pemCert(t, caDER)),
+		},
+		sig:       &cosignSignature{signature: signature, certificate: leafPEM},
+		payload:   payload,
+		rekorKey:  mustKey(t),
+		signedAt:  signedAt,
+		leafBytes: leafPEM,
+	}
+	rekorPEM, err := cryptoutils.MarshalPublicKeyToPEM(f.rekorKey.Public())
+	require.NoError(t, err)
+	f.opts.RekorPublicKey = string(rekorPEM)
+	f.sig.bundle = f.bundle(t, payload, signature, leafPEM)
+	return f
+}
+
+// bundle creates a bundle of an entry of the given payload, signature and certificate signed by the Rekor key
+func (f *keylessFixture) bundle(t *testing.T, payload, signature, certificate []byte) []byte {
+	entry := &hashedRekord{Kind: "hashedrekord"}
+	payloadHash := sha256.Sum256(payload)
+	entry.Spec.Data.Hash.Algorithm = "sha256"
+	entry.Spec.Data.Hash.Value = hex.EncodeToString(payloadHash[:])
+	entry.Spec.Signature.Content = signature
+	entry.Spec.Signature.PublicKey.Content = certificate
+	body, err := json.Marshal(entry)
+	require.NoError(t, err)
+
+	der, err := cryptoutils.MarshalPublicKeyToDER(f.rekorKey.Public())
+	require.NoError(t, err)
+	logID := sha256.Sum256(der)
+
+	bundle := &rekorBundle{Payload: rekorPayload{
+		Body:           base64.StdEncoding.EncodeToString(body),
+		IntegratedTime: f.signedAt.Unix(),
+		LogID:          hex.EncodeToString(logID[:]),
+		LogIndex:       42,
+	}}
+	canonical, err := json.Marshal(bundle.Payload)
+	require.NoError(t, err)
+	canonicalHash := sha256.Sum256(canonical)
+	bundle.SignedEntryTimestamp, err = f.rekorKey.Sign(rand.Reader, canonicalHash[:], crypto.SHA256)
+	require.NoError(t, err)
+
+	encoded, err := json.Marshal(bundle)
+	require.NoError(t, err)
+	return encoded
+}
+
+func TestVerifyKeyless(t *testing.T) {
+	f := newKeylessFixture(t, testIdentity)
+	require.NoError(t, f.opts.verifyKeyless(f.sig, f.payload))
+
+	opts := *f.opts
+	opts.Identity = ""
+	opts.IdentityRegexp = `https://github\.com/inspektor-gadget/.*`
+	require.NoError(t, opts.verifyKeyless(f.sig, f.payload))
+}
+
+func TestVerifyKeylessRejects(t *testing.T) {
+	f := newKeylessFixture(t, testIdentity)
+	other := newKeylessFixture(t, testIdentity)
+
+	for name, tc := range map[string]struct {
+		opts    func(o *KeylessOptions)
+		sig     func(s *cosignSignature)
+		payload []byte
+	}{
+		"wrong_identity": {opts: func(o *KeylessOptions) { o.Identity = "https://github.com/attacker/gadgets" }},
+		"partial_regexp": {opts: func(o *KeylessOptions) { o.Identity = ""; o.IdentityRegexp = "https://github.com/inspektor-gadget" }},
+		"wrong_issuer":   {opts: func(o *KeylessOptions) { o.Issuer = "https://accounts.google.com" }},
+		"untrusted_ca":   {opts: func(o *KeylessOptions) { o.FulcioRoots = other.opts.FulcioRoots }},
+		"untrusted_log":  {opts: func(o *KeylessOptions) { o.RekorPublicKey = other.opts.RekorPublicKey }},
+		"no_bundle":      {sig: func(s *cosignSignature) { s.bundle = nil }},
+		"no_certificate": {sig: func(s *cosignSignature) { s.certificate = nil }},
+		"other_bundle":   {sig: func(s *cosignSignature) { s.bundle = other.sig.bundle }},
+		"other_payload":  {payload: []byte(`{"critical":{"image":{"docker-manifest-digest":"sha256:def"}}}`)},
+		"entry_mismatch": {sig: func(s *cosignSignature) {
+			s.bundle = f.bundle(t, f.payload, other.sig.signature, f.leafBytes)
+		}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts := *f.opts
+			sig := *f.sig
+			payload := f.payload
+			if tc.opts != nil {
+				tc.opts(&opts)
+			}
+			if tc.sig != nil {
+				tc.sig(&sig)
+			}
+			if tc.payload != nil {
+				payload = tc.payload
+			}
+			require.Error(t, opts.verifyKeyless(&sig, payload))
+		})
+	}
+}
+
+func TestKeylessOptionsValidate(t *testing.T) {
+	valid := KeylessOptions{Identity: "a", Issuer: "b", FulcioRoots: "c", RekorPublicKey: "d"}
+	require.NoError(t, valid.Validate())
+	require.True(t, valid.Enabled())
+	require.False(t, (&KeylessOptions{FulcioRoots: "c"}).Enabled())
+
+	for _, change := range []func(o *KeylessOptions){
+		func(o *KeylessOptions) { o.Identity = "" },
+		func(o *KeylessOptions) { o.Issuer = "" },
+		func(o *KeylessOptions) { o.IssuerRegexp = "(" },
+		func(o *KeylessOptions) { o.FulcioRoots = "" },
+		func(o *KeylessOptions) { o.RekorPublicKey = "" },
+	} {
+		opts := valid
+		change(&opts)
+		require.Error(t, opts.Validate())
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+)
+
+// Architectures returns the architectures the image in the local store is available for
+func Architectures(ctx context.Context, image string) ([]string, error) {
+	imageStore, err := getLocalOciStore()
+	if err != nil {
+		return nil, fmt.Errorf("getting local oci store: %w", err)
+	}
+	index, err := getIndex(ctx, imageStore, image)
+	if err != nil {
+		return nil, err
+	}
+	return indexArchitectures(index), nil
+}
+
+func indexArchitectures(index *ocispec.Index) []string {
+	var archs []string
+	for _, m := range index.Manifests {
+		if m.Platform != nil && !slices.Contains(archs, m.Platform.Architecture) {
+			archs = append(archs, m.Platform.Architecture)
+		}
+	}
+	slices.Sort(archs)
+	return archs
+}
+
+// selectManifest returns the descriptor of the manifest of index for the given architecture on Linux
+func selectManifest(index *ocispec.Index, arch string) (*ocispec.Descriptor, error) {
+	for i, m := range index.Manifests {
+		if m.Platform == nil || m.Platform.Architecture != arch {
+			continue
+		}
+		if m.Platform.OS != "" && m.Platform.OS != "linux" {
+			continue
+		}
+		return &index.Manifests[i], nil
+	}
+	archs := indexArchitectures(index)
+	if len(archs) == 0 {
+		return nil, fmt.Errorf("no manifest found for architecture %q", arch)
+	}
+	return nil, fmt.Errorf("no manifest found for architecture %q, the image is only available for %s",
+		arch, strings.Join(archs, ", "))
+}
+
+// checkHostArchitecture returns an error if the image can't be run on the architecture of the host
+func checkHostArchitecture(ctx context.Context, target oras.ReadOnlyTarget, image string) error {
+	index, err := getIndex(ctx, target, image)
+	if err != nil {
+		return err
+	}
+	_, err = selectManifest(index, runtime.GOARCH)
+	return err
+}
+
+// pushIndex creates an index of the given manifests and pushes it to target
+func pushIndex(ctx context.Context, target oras.Target, manifests []ocispec.Descriptor, annotations map[string]string) (ocispec.Descriptor, error) {
+	index := ocispec.Index{
+		Versioned: specs.Versioned{
+			SchemaVersion: 2, // historical value. does not pertain to OCI or docker version
+		},
+		MediaType:   ocispec.MediaTypeImageIndex,
+		Manifests:   manifests,
+		Annotations: annotations,
+	}
+	indexJson, err := json.Marshal(index)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("marshalling manifest: %w", err)
+	}
+	indexDesc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageIndex, indexJson)
+	indexDesc.Annotations = index.Annotations
+
+	err = pushDescriptorIfNotExists(ctx, target, indexDesc, bytes.NewReader(indexJson))
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("pushing manifest index: %w", err)
+	}
+	return indexDesc, nil
+}
+
+// MergeGadgetImages creates the multi-arch image dstImage in the local store, containing the architectures of all
+// srcImages. It allows building the image of each architecture separately, e.g. on a machine of that architecture.
+// The annotations of the first image are used for the new one.
+func MergeGadgetImages(ctx context.Context, dstImage string, srcImages ...string) (*GadgetImageDesc, error) {
+	ociStore, err := getLocalOciStore()
+	if err != nil {
+		return nil, fmt.Errorf("getting oci store: %w", err)
+	}
+	return mergeGadgetImages(ctx, ociStore, dstImage, srcImages...)
+}
+
+func mergeGadgetImages(ctx context.Context, target oras.Target, dstImage string, srcImages ...string) (*GadgetImageDesc, error) {
+	if len(srcImages) == 0 {
+		return nil, fmt.Errorf("no images to merge")
+	}
+	dst, err := normalizeImageName(dstImage)
+	if err != nil {
+		return nil, fmt.Errorf("normalizing dst image: %w", err)
+	}
+
+	var manifests []ocispec.Descriptor
+	var annotations map[string]string
+	sources := map[string]string{}
+
+	for _, srcImage := range srcImages {
+		index, err := getIndex(ctx, target, srcImage)
+		if err != nil {
+			return nil, fmt.Errorf("image %q: %w", srcImage, err)
+		}
+		if annotations == nil {
+			annotations = index.Annotations
+		}
+		for _, m := range index.Manifests {
+			if m.Platform == nil {
+				return nil, fmt.Errorf("image %q: manifest %s doesn't have a platform", srcImage, m.Digest)
+			}
+			arch := m.Platform.Architecture
+			if i := slices.IndexFunc(manifests, func(d ocispec.Descriptor) bool {
+				return d.Platform.Architecture == arch
+			}); i >= 0 {
+				if manifests[i].Digest == m.Digest {
+					continue
+				}
+				return nil, fmt.Errorf("architecture %q is provided by both %q and %q", arch, sources[arch], srcImage)
+			}
+			sources[arch] = srcImage
+			manifests = append(manifests, m)
+		}
+	}
+
+	// Sort, so that merging the same images always results in the same digest
+	slices.SortFunc(manifests, func(a, b ocispec.Descriptor) int {
+		return strings.Compare(a.Platform.Architecture, b.Platform.Architecture)
+	})
+
+	indexDesc, err := pushIndex(ctx, target, manifests, annotations)
+	if err != nil {
+		return nil, err
+	}
+	if err := target.Tag(ctx, indexDesc, dst.String()); err != nil {
+		return nil, fmt.Errorf("tagging index: %w", err)
+	}
+
+	imageDesc := &GadgetImageDesc{
+		Repository: dst.Name(),
+		Digest:     indexDesc.Digest.String(),
+		Created:    getTimeFromAnnotations(indexDesc.Annotations),
+	}
+	if ref, ok := dst.(reference.Tagged); ok {
+		imageDesc.Tag = ref.Tag()
+	}
+	return imageDesc, nil
+}
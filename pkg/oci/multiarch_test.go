@@ -0,0 +1,97 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/memory"
+)
+
+// buildTestImage builds an image with an eBPF object of the given content for each architecture and tags it
+func buildTestImage(t *testing.T, store oras.Target, image string, objects map[string]string) {
+	dir := t.TempDir()
+	opts := &BuildGadgetImageOpts{
+		ObjectPaths:  map[string]*ObjectPath{},
+		MetadataPath: filepath.Join(dir, "gadget.yaml"),
+		CreatedDate:  "2024-01-01T00:00:00Z",
+	}
+	for arch, content := range objects {
+		path := filepath.Join(dir, arch+".bpf.o")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		opts.ObjectPaths[arch] = &ObjectPath{EBPF: path}
+	}
+
+	desc, err := createImageIndex(context.Background(), store, opts)
+	require.NoError(t, err)
+	named, err := normalizeImageName(image)
+	require.NoError(t, err)
+	require.NoError(t, store.Tag(context.Background(), desc, named.String()))
+}
+
+func TestMergeGadgetImages(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+
+	buildTestImage(t, store, "mygadget:amd64", map[string]string{ArchAmd64: "amd64 program"})
+	buildTestImage(t, store, "mygadget:arm64", map[string]string{ArchArm64: "arm64 program"})
+	buildTestImage(t, store, "other:amd64", map[string]string{ArchAmd64: "other program"})
+
+	desc, err := mergeGadgetImages(ctx, store, "mygadget:latest", "mygadget:arm64", "mygadget:amd64")
+	require.NoError(t, err)
+	require.Equal(t, "ghcr.io/inspektor-gadget/gadget/mygadget", desc.Repository)
+	require.Equal(t, "latest", desc.Tag)
+
+	index, err := getIndex(ctx, store, "mygadget:latest")
+	require.NoError(t, err)
+	require.Equal(t, []string{ArchAmd64, ArchArm64}, indexArchitectures(index))
+	for _, arch := range []string{ArchAmd64, ArchArm64} {
+		m, err := selectManifest(index, arch)
+		require.NoError(t, err)
+		require.Equal(t, arch, m.Platform.Architecture)
+	}
+
+	// Merging is deterministic and images with the same manifests can be merged
+	again, err := mergeGadgetImages(ctx, store, "mygadget:again", "mygadget:amd64", "mygadget:latest", "mygadget:arm64")
+	require.NoError(t, err)
+	require.Equal(t, desc.Digest, again.Digest)
+
+	_, err = mergeGadgetImages(ctx, store, "mygadget:conflict", "mygadget:amd64", "other:amd64")
+	require.ErrorContains(t, err, `architecture "amd64" is provided by both`)
+
+	_, err = mergeGadgetImages(ctx, store, "mygadget:missing", "mygadget:amd64", "missing:latest")
+	require.Error(t, err)
+
+	_, err = mergeGadgetImages(ctx, store, "mygadget:empty")
+	require.Error(t, err)
+}
+
+func TestSelectManifest(t *testing.T) {
+	store := memory.New()
+	buildTestImage(t, store, "mygadget:amd64", map[string]string{ArchAmd64: "amd64 program"})
+
+	index, err := getIndex(context.Background(), store, "mygadget:amd64")
+	require.NoError(t, err)
+
+	_, err = selectManifest(index, ArchAmd64)
+	require.NoError(t, err)
+	_, err = selectManifest(index, ArchArm64)
+	require.ErrorContains(t, err, `no manifest found for architecture "arm64", the image is only available for amd64`)
+}
@@ -15,7 +15,6 @@
 package oci
 
 import (
-	"archive/tar"
 	"bytes"
 	"context"
 	"crypto"
@@ -26,6 +25,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -50,11 +50,18 @@ type AuthOptions struct {
 	AuthFile    string
 	SecretBytes []byte
 	Insecure    bool
+
+	// Registries configures mirrors and certificate authorities of registries; the config at
+	// DefaultRegistriesFile is used if it's nil
+	Registries *RegistriesConfig
 }
 
 type VerifyOptions struct {
 	VerifyPublicKey bool
 	PublicKey       string
+
+	// Keyless replaces the verification using PublicKey if enabled
+	Keyless KeylessOptions
 }
 
 type ImageOptions struct {
@@ -122,12 +129,12 @@ func pullGadgetImageToStore(ctx context.Context, imageStore oras.Target, image s
 	if err != nil {
 		return nil, fmt.Errorf("normalizing image: %w", err)
 	}
-	repo, err := newRepository(targetImage, authOpts)
-	if err != nil {
-		return nil, fmt.Errorf("creating remote repository: %w", err)
-	}
-	desc, err := oras.Copy(ctx, repo, targetImage.String(), imageStore,
-		targetImage.String(), oras.DefaultCopyOptions)
+	var desc ocispec.Descriptor
+	err = pullRepositories(targetImage, authOpts, func(repo *remote.Repository, ref string) error {
+		var err error
+		desc, err = oras.Copy(ctx, repo, ref, imageStore, targetImage.String(), oras.DefaultCopyOptions)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("copying to remote repository: %w", err)
 	}
@@ -158,11 +165,18 @@ func pullIfNotExist(ctx context.Context, imageStore oras.Target, authOpts *AuthO
 		return fmt.Errorf("resolving image %q: %w", image, err)
 	}
 
-	repo, err := newRepository(targetImage, authOpts)
-	if err != nil {
-		return fmt.Errorf("creating remote repository: %w", err)
+	// Images pinned by digest might have been pulled under another name already
+	if digested, ok := targetImage.(reference.Digested); ok {
+		desc, err := imageStore.Resolve(ctx, digested.Digest().String())
+		if err == nil && (desc.MediaType == ocispec.MediaTypeImageIndex || desc.MediaType == ocispec.MediaTypeImageManifest) {
+			return imageStore.Tag(ctx, desc, targetImage.String())
+		}
 	}
-	_, err = oras.Copy(ctx, repo, targetImage.String(), imageStore, targetImage.String(), oras.DefaultCopyOptions)
+
+	err = pullRepositories(targetImage, authOpts, func(repo *remote.Repository, ref string) error {
+		_, err := oras.Copy(ctx, repo, ref, imageStore, targetImage.String(), oras.DefaultCopyOptions)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("downloading to local repository: %w", err)
 	}
@@ -235,117 +249,6 @@ func TagGadgetImage(ctx context.Context, srcImage, dstImage string) (*GadgetImag
 	return imageDesc, nil
 }
 
-func ExportGadgetImages(ctx context.Context, dstFile string, images ...string) error {
-	ociStore, err := getLocalOciStore()
-	if err != nil {
-		return fmt.Errorf("getting oci store: %w", err)
-	}
-
-	tmpDir, err := os.MkdirTemp("", "gadget-export-")
-	if err != nil {
-		return fmt.Errorf("creating temp dir: %w", err)
-	}
-	defer os.RemoveAll(tmpDir)
-
-	dstStore, err := oci.New(tmpDir)
-	if err != nil {
-		return fmt.Errorf("creating oci storage: %w", err)
-	}
-
-	for _, image := range images {
-		targetImage, err := normalizeImageName(image)
-		if err != nil {
-			return fmt.Errorf("normalizing image: %w", err)
-		}
-		_, err = oras.Copy(ctx, ociStore, targetImage.String(), dstStore,
-			targetImage.String(), oras.DefaultCopyOptions)
-		if err != nil {
-			return fmt.Errorf("copying to remote repository: %w", err)
-		}
-	}
-
-	if err := tarFolderToFile(tmpDir, dstFile); err != nil {
-		return fmt.Errorf("creating tar for gadget image: %w", err)
-	}
-
-	return nil
-}
-
-// ImportGadgetImages imports all the tagged gadget images from the src file.
-func ImportGadgetImages(ctx context.Context, srcFile string) ([]string, error) {
-	src, err := oci.NewFromTar(ctx, srcFile)
-	if err != nil {
-		return nil, fmt.Errorf("loading src bundle: %w", err)
-	}
-
-	ociStore, err := getLocalOciStore()
-	if err != nil {
-		return nil, fmt.Errorf("getting oci store: %w", err)
-	}
-
-	ret := []string{}
-
-	err = src.Tags(ctx, "", func(tags []string) error {
-		for _, tag := range tags {
-			_, err := oras.Copy(ctx, src, tag, ociStore, tag, oras.DefaultCopyOptions)
-			if err != nil {
-				return fmt.Errorf("copying to local repository: %w", err)
-			}
-
-			ret = append(ret, tag)
-		}
-		return nil
-	})
-
-	return ret, err
-}
-
-// based on https://medium.com/@skdomino/taring-untaring-files-in-go-6b07cf56bc07
-func tarFolderToFile(src, filePath string) error {
-	file, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("opening file: %w", err)
-	}
-
-	tw := tar.NewWriter(file)
-	defer tw.Close()
-
-	return filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if !fi.Mode().IsRegular() {
-			return nil
-		}
-
-		header, err := tar.FileInfoHeader(fi, fi.Name())
-		if err != nil {
-			return err
-		}
-
-		// update the name to correctly reflect the desired destination when untaring
-		header.Name = strings.TrimPrefix(strings.Replace(file, src, "", -1), string(filepath.Separator))
-
-		if err := tw.WriteHeader(header); err != nil {
-			return err
-		}
-
-		f, err := os.Open(file)
-		if err != nil {
-			return err
-		}
-
-		if _, err := io.Copy(tw, f); err != nil {
-			return err
-		}
-
-		f.Close()
-
-		return nil
-	})
-}
-
 func listGadgetImages(ctx context.Context, store *oci.Store) ([]*GadgetImageDesc, error) {
 	images := []*GadgetImageDesc{}
 	err := store.Tags(ctx, "", func(tags []string) error {
@@ -569,23 +472,33 @@ func craftSignatureTag(digest string) (string, error) {
 	return fmt.Sprintf("%s-%s.sig", parts[0], parts[1]), nil
 }
 
-func getSignature(ctx context.Context, repo *remote.Repository, signatureTag string) ([]byte, string, error) {
+// cosignSignature is the signature of an image stored by cosign; certificate, chain and bundle are only set for
+// signatures made without keys
+type cosignSignature struct {
+	signature   []byte
+	payloadTag  string
+	certificate []byte
+	chain       []byte
+	bundle      []byte
+}
+
+func getSignature(ctx context.Context, repo *remote.Repository, signatureTag string) (*cosignSignature, error) {
 	_, signatureManifestBytes, err := oras.FetchBytes(ctx, repo, signatureTag, oras.DefaultFetchBytesOptions)
 	if err != nil {
-		return nil, "", fmt.Errorf("getting signature bytes: %w", err)
+		return nil, fmt.Errorf("getting signature bytes: %w", err)
 	}
 
 	signatureManifest := &ocispec.Manifest{}
 	err = json.Unmarshal(signatureManifestBytes, signatureManifest)
 	if err != nil {
-		return nil, "", fmt.Errorf("decoding signature manifest: %w", err)
+		return nil, fmt.Errorf("decoding signature manifest: %w", err)
 	}
 
 	layers := signatureManifest.Layers
 	expectedLen := 1
 	layersLen := len(layers)
 	if layersLen != expectedLen {
-		return nil, "", fmt.Errorf("wrong number of signature manifest layers: expected %d, got %d", expectedLen, layersLen)
+		return nil, fmt.Errorf("wrong number of signature manifest layers: expected %d, got %d", expectedLen, layersLen)
 	}
 
 	layer := layers[0]
@@ -593,22 +506,26 @@ func getSignature(ctx context.Context, repo *remote.Repository, signatureTag str
 	// https://github.com/sigstore/cosign/blob/e23dcd11f24b729f6ff9300ab7a61b09d71da12a/pkg/types/media.go#L28
 	expectedMediaType := "application/vnd.dev.cosign.simplesigning.v1+json"
 	if layer.MediaType != expectedMediaType {
-		return nil, "", fmt.Errorf("wrong layer media type: expected %s, got %s", expectedMediaType, layer.MediaType)
+		return nil, fmt.Errorf("wrong layer media type: expected %s, got %s", expectedMediaType, layer.MediaType)
 	}
 
 	signature, ok := layer.Annotations["dev.cosignproject.cosign/signature"]
 	if !ok {
-		return nil, "", fmt.Errorf("no signature in layer")
+		return nil, fmt.Errorf("no signature in layer")
 	}
 
 	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
 	if err != nil {
-		return nil, "", fmt.Errorf("decoding signature: %w", err)
+		return nil, fmt.Errorf("decoding signature: %w", err)
 	}
 
-	payloadTag := layer.Digest.String()
-
-	return signatureBytes, payloadTag, nil
+	return &cosignSignature{
+		signature:   signatureBytes,
+		payloadTag:  layer.Digest.String(),
+		certificate: []byte(layer.Annotations[certificateAnnotation]),
+		chain:       []byte(layer.Annotations[chainAnnotation]),
+		bundle:      []byte(layer.Annotations[bundleAnnotation]),
+	}, nil
 }
 
 func getPayload(ctx context.Context, repo *remote.Repository, payloadTag string) ([]byte, error) {
@@ -631,18 +548,33 @@ func getImageDigest(ctx context.Context, store *oci.Store, imageRef string) (str
 	return desc.Digest.String(), nil
 }
 
-func getSigningInformation(ctx context.Context, repo *remote.Repository, imageDigest string, authOpts *AuthOptions) ([]byte, []byte, error) {
+// GetImageDigest returns the digest of the image in the local store
+func GetImageDigest(ctx context.Context, image string) (string, error) {
+	imageStore, err := getLocalOciStore()
+	if err != nil {
+		return "", fmt.Errorf("getting local oci store: %w", err)
+	}
+
+	imageRef, err := normalizeImageName(image)
+	if err != nil {
+		return "", fmt.Errorf("normalizing image name: %w", err)
+	}
+
+	return getImageDigest(ctx, imageStore, imageRef.String())
+}
+
+func getSigningInformation(ctx context.Context, repo *remote.Repository, imageDigest string, authOpts *AuthOptions) (*cosignSignature, []byte, error) {
 	signatureTag, err := craftSignatureTag(imageDigest)
 	if err != nil {
 		return nil, nil, fmt.Errorf("crafting signature tag: %w", err)
 	}
 
-	signature, payloadTag, err := getSignature(ctx, repo, signatureTag)
+	signature, err := getSignature(ctx, repo, signatureTag)
 	if err != nil {
 		return nil, nil, fmt.Errorf("getting signature: %w", err)
 	}
 
-	payload, err := getPayload(ctx, repo, payloadTag)
+	payload, err := getPayload(ctx, repo, signature.payloadTag)
 	if err != nil {
 		return nil, nil, fmt.Errorf("getting payload: %w", err)
 	}
@@ -699,24 +631,42 @@ func verifyImage(ctx context.Context, image string, imgOpts *ImageOptions) error
 		return fmt.Errorf("getting image digest: %w", err)
 	}
 
-	verifier, err := newVerifier([]byte(imgOpts.PublicKey))
-	if err != nil {
-		return fmt.Errorf("creating verifier: %w", err)
-	}
+	keyless := imgOpts.Keyless.Enabled()
 
-	repo, err := newRepository(imageRef, &imgOpts.AuthOptions)
-	if err != nil {
-		return fmt.Errorf("creating repository: %w", err)
+	var verifier signature.Verifier
+	if keyless {
+		if err := imgOpts.Keyless.Validate(); err != nil {
+			return err
+		}
+	} else {
+		verifier, err = newVerifier([]byte(imgOpts.PublicKey))
+		if err != nil {
+			return fmt.Errorf("creating verifier: %w", err)
+		}
 	}
 
-	signatureBytes, payloadBytes, err := getSigningInformation(ctx, repo, imageDigest, &imgOpts.AuthOptions)
+	// The signature is fetched from where the image could have been pulled from
+	var sig *cosignSignature
+	var payloadBytes []byte
+	err = pullRepositories(imageRef, &imgOpts.AuthOptions, func(repo *remote.Repository, ref string) error {
+		var err error
+		sig, payloadBytes, err = getSigningInformation(ctx, repo, imageDigest, &imgOpts.AuthOptions)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("getting signing information: %w", err)
 	}
 
-	err = verifier.VerifySignature(bytes.NewReader(signatureBytes), bytes.NewReader(payloadBytes))
-	if err != nil {
-		return fmt.Errorf("verifying signature: %w", err)
+	if keyless {
+		err = imgOpts.Keyless.verifyKeyless(sig, payloadBytes)
+		if err != nil {
+			return fmt.Errorf("verifying keyless signature: %w", err)
+		}
+	} else {
+		err = verifier.VerifySignature(bytes.NewReader(sig.signature), bytes.NewReader(payloadBytes))
+		if err != nil {
+			return fmt.Errorf("verifying signature: %w", err)
+		}
 	}
 
 	// We should not read the payload before confirming it was signed, so let's
@@ -733,19 +683,45 @@ func verifyImage(ctx context.Context, image string, imgOpts *ImageOptions) error
 // newRepository creates a client to the remote repository identified by
 // image using the given auth options.
 func newRepository(image reference.Named, authOpts *AuthOptions) (*remote.Repository, error) {
+	config, err := registriesConfig(authOpts)
+	if err != nil {
+		return nil, err
+	}
+	return newConfiguredRepository(image, authOpts, config)
+}
+
+// newConfiguredRepository creates a client to the remote repository identified by image using the given auth options
+// and the settings of its registry in config.
+func newConfiguredRepository(image reference.Named, authOpts *AuthOptions, config *RegistriesConfig) (*remote.Repository, error) {
 	repo, err := remote.NewRepository(image.Name())
 	if err != nil {
 		return nil, fmt.Errorf("creating remote repository: %w", err)
 	}
-	repo.PlainHTTP = authOpts.Insecure
-	if !authOpts.Insecure {
-		client, err := newAuthClient(image.Name(), authOpts)
+	registry := config.registry(image)
+	repo.PlainHTTP = authOpts.Insecure || (registry != nil && registry.Insecure)
+	if repo.PlainHTTP {
+		return repo, nil
+	}
+
+	client, err := newAuthClient(image.Name(), authOpts)
+	if err != nil {
+		return nil, fmt.Errorf("creating auth client: %w", err)
+	}
+	var caFiles []string
+	if config.CAFile != "" {
+		caFiles = append(caFiles, config.CAFile)
+	}
+	if registry != nil && registry.CAFile != "" {
+		caFiles = append(caFiles, registry.CAFile)
+	}
+	if len(caFiles) > 0 {
+		transport, err := newTransport(caFiles...)
 		if err != nil {
-			return nil, fmt.Errorf("creating auth client: %w", err)
+			return nil, fmt.Errorf("configuring TLS of %s: %w", reference.Domain(image), err)
 		}
-		repo.Client = client
+		client.Client = &http.Client{Transport: transport}
 	}
-
+	repo.Client = client
 	return repo, nil
 }
 
@@ -806,6 +782,11 @@ func ensureImage(ctx context.Context, imageStore oras.Target, image string, imgO
 		}
 	}
 
+	// Fail early instead of when running the image
+	if err := checkHostArchitecture(ctx, imageStore, image); err != nil {
+		return fmt.Errorf("image %q: %w", image, err)
+	}
+
 	if !imgOpts.VerifyPublicKey {
 		log.Warnf("you set --verify-image=false, image will not be verified")
 
@@ -831,21 +812,23 @@ func EnsureImage(ctx context.Context, image string, imgOpts *ImageOptions, pullP
 }
 
 func getManifestForHost(ctx context.Context, target oras.ReadOnlyTarget, image string) (*ocispec.Manifest, error) {
-	index, err := getIndex(ctx, target, image)
+	imageRef, err := normalizeImageName(image)
 	if err != nil {
-		return nil, fmt.Errorf("getting index: %w", err)
+		return nil, fmt.Errorf("normalizing image: %w", err)
 	}
+	return getManifestForHostByReference(ctx, target, imageRef.String())
+}
 
-	var manifestDesc *ocispec.Descriptor
-	for _, indexManifest := range index.Manifests {
-		// TODO: Check docker code
-		if indexManifest.Platform.Architecture == runtime.GOARCH {
-			manifestDesc = &indexManifest
-			break
-		}
+// getManifestForHostByReference gets the manifest for the host's architecture of the image ref refers to in target
+func getManifestForHostByReference(ctx context.Context, target oras.ReadOnlyTarget, ref string) (*ocispec.Manifest, error) {
+	index, err := getImageListDescriptor(ctx, target, ref)
+	if err != nil {
+		return nil, fmt.Errorf("getting index: %w", err)
 	}
-	if manifestDesc == nil {
-		return nil, fmt.Errorf("no manifest found for architecture %q", runtime.GOARCH)
+
+	manifestDesc, err := selectManifest(&index, runtime.GOARCH)
+	if err != nil {
+		return nil, err
 	}
 
 	manifestBytes, err := getContentBytesFromDescriptor(ctx, target, *manifestDesc)
@@ -896,3 +879,50 @@ func GetContentFromDescriptor(ctx context.Context, desc ocispec.Descriptor) (io.
 	}
 	return reader, nil
 }
+
+// GadgetImageContent holds the parts of a gadget image that describe what it does
+type GadgetImageContent struct {
+	Metadata    []byte
+	EBPFProgram []byte
+}
+
+// FetchGadgetImageContent fetches the metadata and the eBPF program of the image for the host's architecture
+// directly from its registry, without storing it in the local store.
+func FetchGadgetImageContent(ctx context.Context, image string, authOpts *AuthOptions) (*GadgetImageContent, error) {
+	targetImage, err := normalizeImageName(image)
+	if err != nil {
+		return nil, fmt.Errorf("normalizing image: %w", err)
+	}
+	res := &GadgetImageContent{}
+	err = pullRepositories(targetImage, authOpts, func(repo *remote.Repository, ref string) error {
+		manifest, err := getManifestForHostByReference(ctx, repo, ref)
+		if err != nil {
+			return fmt.Errorf("getting manifest: %w", err)
+		}
+		return res.fetch(ctx, repo, manifest)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// fetch fetches the metadata and the eBPF program of manifest
+func (res *GadgetImageContent) fetch(ctx context.Context, repo *remote.Repository, manifest *ocispec.Manifest) error {
+	var err error
+	res.Metadata, err = getContentBytesFromDescriptor(ctx, repo, manifest.Config)
+	if err != nil {
+		return fmt.Errorf("getting metadata: %w", err)
+	}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != eBPFObjectMediaType {
+			continue
+		}
+		res.EBPFProgram, err = getContentBytesFromDescriptor(ctx, repo, layer)
+		if err != nil {
+			return fmt.Errorf("getting eBPF program: %w", err)
+		}
+		break
+	}
+	return nil
+}
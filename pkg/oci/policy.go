@@ -0,0 +1,366 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/resources"
+)
+
+const admissionWebhookTimeout = 10 * time.Second
+
+// ImagePolicy restricts which gadget images may be run. It is configured by whoever runs the gadgets (e.g. the
+// daemon) and can't be changed by clients.
+type ImagePolicy struct {
+	// AllowedRegistries, if not empty, are the only registries or repository prefixes images may be run from, e.g.
+	// "ghcr.io" or "ghcr.io/inspektor-gadget"
+	AllowedRegistries []string `yaml:"allowedRegistries"`
+
+	// DeniedRegistries are registries or repository prefixes images may not be run from; they take precedence over
+	// AllowedRegistries
+	DeniedRegistries []string `yaml:"deniedRegistries"`
+
+	// AllowedDigests, if not empty, are the only digests of images that may be run, e.g. "sha256:..."
+	AllowedDigests []string `yaml:"allowedDigests"`
+
+	// DeniedDigests are digests of images that may not be run
+	DeniedDigests []string `yaml:"deniedDigests"`
+
+	// RequireSignature only allows running images that are signed; clients can't disable the verification
+	RequireSignature bool `yaml:"requireSignature"`
+
+	// PublicKey is used to verify images if RequireSignature is set, instead of the key given by the client; defaults
+	// to the key of the official images
+	PublicKey string `yaml:"publicKey"`
+
+	// Keyless, if set, verifies images signed without keys instead of using PublicKey if RequireSignature is set
+	Keyless *KeylessOptions `yaml:"keyless"`
+
+	// AllowedOperatorImages, if not empty, are the only images run by operators on their own instead of being pulled
+	// that may be run, e.g. "operator:audit"; entries ending with ":" or "/", like "legacy:", match all images starting
+	// with them
+	AllowedOperatorImages []string `yaml:"allowedOperatorImages"`
+
+	// DeniedOperatorImages are images run by operators on their own that may not be run; they take precedence over
+	// AllowedOperatorImages
+	DeniedOperatorImages []string `yaml:"deniedOperatorImages"`
+
+	// AdmissionWebhook is an URL that gets a POST request with an AdmissionRequest for every image that is about to be
+	// run; it has to answer with an AdmissionResponse
+	AdmissionWebhook string `yaml:"admissionWebhook"`
+}
+
+// AdmissionRequest describes an image that is about to be run
+type AdmissionRequest struct {
+	// Image is the normalized name of the image, e.g. "ghcr.io/inspektor-gadget/gadget/trace_exec:latest"
+	Image string `json:"image"`
+
+	// Digest of the image in the local store
+	Digest string `json:"digest"`
+
+	// Operator is set for images run by operators on their own, like "operator:audit"; Image is their name as given
+	// and Digest is empty
+	Operator bool `json:"operator,omitempty"`
+}
+
+// AdmissionResponse is the answer of an admission webhook
+type AdmissionResponse struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// AdmissionHook decides whether an image may be run; returning an error denies it
+type AdmissionHook func(ctx context.Context, req *AdmissionRequest) error
+
+var (
+	policyLock     sync.RWMutex
+	imagePolicy    *ImagePolicy
+	admissionHooks = map[string]AdmissionHook{}
+)
+
+// SetImagePolicy sets the policy that is enforced for all images that are run; nil allows all images
+func SetImagePolicy(policy *ImagePolicy) {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+	imagePolicy = policy
+}
+
+// GetImagePolicy returns the policy set by SetImagePolicy
+func GetImagePolicy() *ImagePolicy {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+	return imagePolicy
+}
+
+// RegisterAdmissionHook registers a hook that is asked before any image is run, in addition to the image policy
+func RegisterAdmissionHook(name string, hook AdmissionHook) error {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+	if _, ok := admissionHooks[name]; ok {
+		return fmt.Errorf("admission hook %q already registered", name)
+	}
+	admissionHooks[name] = hook
+	return nil
+}
+
+// UnregisterAdmissionHook removes a hook registered by RegisterAdmissionHook
+func UnregisterAdmissionHook(name string) {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+	delete(admissionHooks, name)
+}
+
+// Validate checks the policy for errors
+func (p *ImagePolicy) Validate() error {
+	for _, registry := range slices.Concat(p.AllowedRegistries, p.DeniedRegistries) {
+		if registry == "" || strings.HasSuffix(registry, "/") {
+			return fmt.Errorf("invalid registry %q", registry)
+		}
+	}
+	for _, image := range slices.Concat(p.AllowedOperatorImages, p.DeniedOperatorImages) {
+		if image == "" {
+			return fmt.Errorf("invalid operator image %q", image)
+		}
+	}
+	for _, digest := range slices.Concat(p.AllowedDigests, p.DeniedDigests) {
+		algorithm, hex, ok := strings.Cut(digest, ":")
+		if !ok || algorithm == "" || hex == "" {
+			return fmt.Errorf("invalid digest %q: expected <algorithm>:<hex>", digest)
+		}
+	}
+	if p.Keyless != nil {
+		if err := p.Keyless.Validate(); err != nil {
+			return fmt.Errorf("invalid keyless verification: %w", err)
+		}
+	}
+	if p.AdmissionWebhook != "" {
+		u, err := url.Parse(p.AdmissionWebhook)
+		if err != nil {
+			return fmt.Errorf("invalid admission webhook: %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("invalid admission webhook %q: only http and https are supported", p.AdmissionWebhook)
+		}
+	}
+	return nil
+}
+
+// matchesRegistry returns whether the image is stored in the given registry or below the given repository prefix
+func matchesRegistry(image reference.Named, registry string) bool {
+	return reference.Domain(image) == registry || image.Name() == registry ||
+		strings.HasPrefix(image.Name(), registry+"/")
+}
+
+// CheckRegistry returns an error if the policy doesn't allow running images from the registry of image. It is meant
+// to be called before pulling the image.
+func (p *ImagePolicy) CheckRegistry(image string) error {
+	if p == nil {
+		return nil
+	}
+	named, err := normalizeImageName(image)
+	if err != nil {
+		return fmt.Errorf("normalizing image name: %w", err)
+	}
+	for _, registry := range p.DeniedRegistries {
+		if matchesRegistry(named, registry) {
+			return fmt.Errorf("image %q: registry %q is denied by policy", named.String(), registry)
+		}
+	}
+	if len(p.AllowedRegistries) == 0 {
+		return nil
+	}
+	for _, registry := range p.AllowedRegistries {
+		if matchesRegistry(named, registry) {
+			return nil
+		}
+	}
+	return fmt.Errorf("image %q: registry is not allowed by policy", named.String())
+}
+
+// ApplyVerifyOptions enforces the verification of signatures, if required by the policy
+func (p *ImagePolicy) ApplyVerifyOptions(opts *VerifyOptions) error {
+	if p == nil || !p.RequireSignature {
+		return nil
+	}
+	if !opts.VerifyPublicKey {
+		return fmt.Errorf("policy requires signed images: verification can't be disabled")
+	}
+	// Don't trust keys and identities given by the client, they could have signed the image themselves
+	opts.Keyless = KeylessOptions{}
+	if p.Keyless != nil {
+		opts.Keyless = *p.Keyless
+	}
+	opts.PublicKey = p.PublicKey
+	if opts.PublicKey == "" {
+		opts.PublicKey = resources.InspektorGadgetPublicKey
+	}
+	return nil
+}
+
+func (p *ImagePolicy) checkDigest(digest string) error {
+	if slices.Contains(p.DeniedDigests, digest) {
+		return fmt.Errorf("digest %q is denied by policy", digest)
+	}
+	if len(p.AllowedDigests) > 0 && !slices.Contains(p.AllowedDigests, digest) {
+		return fmt.Errorf("digest %q is not allowed by policy", digest)
+	}
+	return nil
+}
+
+// Admit returns an error if the policy, its admission webhook or any registered admission hook denies running image.
+// It is meant to be called once the image is available in the local store. Registered hooks are also asked if p is
+// nil.
+func (p *ImagePolicy) Admit(ctx context.Context, image string) error {
+	hooks := registeredAdmissionHooks()
+	if p == nil && len(hooks) == 0 {
+		return nil
+	}
+
+	named, err := normalizeImageName(image)
+	if err != nil {
+		return fmt.Errorf("normalizing image name: %w", err)
+	}
+	digest, err := GetImageDigest(ctx, image)
+	if err != nil {
+		return err
+	}
+	req := &AdmissionRequest{Image: named.String(), Digest: digest}
+
+	if p != nil {
+		if err := p.checkDigest(digest); err != nil {
+			return fmt.Errorf("image %q: %w", req.Image, err)
+		}
+	}
+	return p.ask(ctx, req, hooks)
+}
+
+// matchesOperatorImage returns whether image is the given entry or, if the entry ends with ":" or "/", starts with it
+func matchesOperatorImage(image string, entry string) bool {
+	if strings.HasSuffix(entry, ":") || strings.HasSuffix(entry, "/") {
+		return strings.HasPrefix(image, entry)
+	}
+	return image == entry
+}
+
+func (p *ImagePolicy) checkOperatorImage(image string) error {
+	for _, entry := range p.DeniedOperatorImages {
+		if matchesOperatorImage(image, entry) {
+			return fmt.Errorf("operator image %q is denied by policy", image)
+		}
+	}
+	if len(p.AllowedOperatorImages) == 0 {
+		return nil
+	}
+	for _, entry := range p.AllowedOperatorImages {
+		if matchesOperatorImage(image, entry) {
+			return nil
+		}
+	}
+	return fmt.Errorf("operator image %q is not allowed by policy", image)
+}
+
+// AdmitOperatorImage returns an error if the policy, its admission webhook or any registered admission hook denies
+// running image, which is run by an operator on its own instead of being pulled. Registered hooks are also asked if p
+// is nil.
+func (p *ImagePolicy) AdmitOperatorImage(ctx context.Context, image string) error {
+	hooks := registeredAdmissionHooks()
+	if p == nil && len(hooks) == 0 {
+		return nil
+	}
+
+	if p != nil {
+		if err := p.checkOperatorImage(image); err != nil {
+			return err
+		}
+	}
+	return p.ask(ctx, &AdmissionRequest{Image: image, Operator: true}, hooks)
+}
+
+func registeredAdmissionHooks() map[string]AdmissionHook {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+	hooks := make(map[string]AdmissionHook, len(admissionHooks))
+	for name, hook := range admissionHooks {
+		hooks[name] = hook
+	}
+	return hooks
+}
+
+// ask asks the admission webhook of the policy, if any, and hooks whether the image of req may be run
+func (p *ImagePolicy) ask(ctx context.Context, req *AdmissionRequest, hooks map[string]AdmissionHook) error {
+	if p != nil && p.AdmissionWebhook != "" {
+		if err := callAdmissionWebhook(ctx, p.AdmissionWebhook, req); err != nil {
+			return fmt.Errorf("image %q: admission webhook: %w", req.Image, err)
+		}
+	}
+
+	for name, hook := range hooks {
+		if err := hook(ctx, req); err != nil {
+			return fmt.Errorf("image %q: denied by admission hook %q: %w", req.Image, name, err)
+		}
+	}
+	return nil
+}
+
+func callAdmissionWebhook(ctx context.Context, webhook string, req *AdmissionRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshaling request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, admissionWebhookTimeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %q", res.Status)
+	}
+
+	resp := &AdmissionResponse{}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(resp); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	if !resp.Allowed {
+		if resp.Reason != "" {
+			return fmt.Errorf("denied: %s", resp.Reason)
+		}
+		return fmt.Errorf("denied")
+	}
+	return nil
+}
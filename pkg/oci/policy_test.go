@@ -0,0 +1,166 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/resources"
+)
+
+func TestImagePolicyCheckRegistry(t *testing.T) {
+	var nilPolicy *ImagePolicy
+	require.NoError(t, nilPolicy.CheckRegistry("trace_exec"))
+
+	policy := &ImagePolicy{
+		AllowedRegistries: []string{"ghcr.io/inspektor-gadget", "registry.example.com"},
+		DeniedRegistries:  []string{"ghcr.io/inspektor-gadget/gadget/trace_exec", "registry.example.com/untrusted"},
+	}
+	for image, allowed := range map[string]bool{
+		"trace_open": true,
+		"ghcr.io/inspektor-gadget/gadget/trace_open": true,
+		"registry.example.com/gadgets/trace_dns:v1":  true,
+		"trace_exec": false,
+		"ghcr.io/inspektor-gadget/gadget/trace_exec:v0.30.0": false,
+		"registry.example.com/untrusted/trace_dns":           false,
+		"ghcr.io/inspektor-gadget-fork/trace_open":           false,
+		"docker.io/library/trace_open":                       false,
+	} {
+		err := policy.CheckRegistry(image)
+		if allowed {
+			require.NoError(t, err, image)
+		} else {
+			require.Error(t, err, image)
+		}
+	}
+}
+
+func TestImagePolicyApplyVerifyOptions(t *testing.T) {
+	opts := &VerifyOptions{VerifyPublicKey: false, PublicKey: "client key"}
+	require.NoError(t, (&ImagePolicy{}).ApplyVerifyOptions(opts))
+	require.Equal(t, "client key", opts.PublicKey)
+
+	policy := &ImagePolicy{RequireSignature: true}
+	require.Error(t, policy.ApplyVerifyOptions(opts))
+
+	opts.VerifyPublicKey = true
+	require.NoError(t, policy.ApplyVerifyOptions(opts))
+	require.Equal(t, resources.InspektorGadgetPublicKey, opts.PublicKey)
+
+	policy.PublicKey = "policy key"
+	require.NoError(t, policy.ApplyVerifyOptions(opts))
+	require.Equal(t, "policy key", opts.PublicKey)
+
+	// Clients can't choose which identities they trust
+	opts.Keyless = KeylessOptions{Identity: "attacker@example.com"}
+	require.NoError(t, policy.ApplyVerifyOptions(opts))
+	require.False(t, opts.Keyless.Enabled())
+
+	policy.Keyless = &KeylessOptions{Identity: "release@example.com", Issuer: "https://accounts.example.com"}
+	require.NoError(t, policy.ApplyVerifyOptions(opts))
+	require.Equal(t, "release@example.com", opts.Keyless.Identity)
+}
+
+func TestImagePolicyCheckDigest(t *testing.T) {
+	policy := &ImagePolicy{DeniedDigests: []string{"sha256:bad"}}
+	require.NoError(t, policy.checkDigest("sha256:good"))
+	require.Error(t, policy.checkDigest("sha256:bad"))
+
+	policy.AllowedDigests = []string{"sha256:good"}
+	require.NoError(t, policy.checkDigest("sha256:good"))
+	require.Error(t, policy.checkDigest("sha256:other"))
+}
+
+func TestImagePolicyAdmitOperatorImage(t *testing.T) {
+	ctx := context.Background()
+	policy := &ImagePolicy{DeniedOperatorImages: []string{"operator:audit", "legacy:"}}
+	require.ErrorContains(t, policy.AdmitOperatorImage(ctx, "operator:audit"), "denied by policy")
+	require.Error(t, policy.AdmitOperatorImage(ctx, "legacy:trace/exec"))
+	require.NoError(t, policy.AdmitOperatorImage(ctx, "operator:snapshot_gpu"))
+
+	policy.AllowedOperatorImages = []string{"operator:snapshot_gpu", "fallback:"}
+	require.NoError(t, policy.AdmitOperatorImage(ctx, "operator:snapshot_gpu"))
+	require.NoError(t, policy.AdmitOperatorImage(ctx, "fallback:trace_exec"))
+	require.ErrorContains(t, policy.AdmitOperatorImage(ctx, "operator:snapshot_netns"), "not allowed by policy")
+
+	var got *AdmissionRequest
+	require.NoError(t, RegisterAdmissionHook("test", func(_ context.Context, req *AdmissionRequest) error {
+		got = req
+		if req.Image == "operator:top_interfaces" {
+			return errors.New("not today")
+		}
+		return nil
+	}))
+	defer UnregisterAdmissionHook("test")
+
+	var noPolicy *ImagePolicy
+	require.NoError(t, noPolicy.AdmitOperatorImage(ctx, "operator:snapshot_netns"))
+	require.Equal(t, &AdmissionRequest{Image: "operator:snapshot_netns", Operator: true}, got)
+	require.ErrorContains(t, noPolicy.AdmitOperatorImage(ctx, "operator:top_interfaces"), "not today")
+}
+
+func TestImagePolicyValidate(t *testing.T) {
+	require.NoError(t, (&ImagePolicy{
+		AllowedRegistries: []string{"ghcr.io"},
+		AllowedDigests:    []string{"sha256:abc"},
+		AdmissionWebhook:  "https://admission.example.com/gadgets",
+	}).Validate())
+
+	for _, policy := range []*ImagePolicy{
+		{AllowedRegistries: []string{""}},
+		{DeniedRegistries: []string{"ghcr.io/"}},
+		{AllowedDigests: []string{"abc"}},
+		{DeniedDigests: []string{"sha256:"}},
+		{DeniedOperatorImages: []string{""}},
+		{AdmissionWebhook: "unix:///run/admission.sock"},
+	} {
+		require.Error(t, policy.Validate(), policy)
+	}
+}
+
+func TestAdmissionWebhook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &AdmissionRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(req))
+		switch req.Image {
+		case "allowed":
+			json.NewEncoder(w).Encode(&AdmissionResponse{Allowed: true})
+		case "denied":
+			json.NewEncoder(w).Encode(&AdmissionResponse{Reason: "not today"})
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	require.NoError(t, callAdmissionWebhook(ctx, server.URL, &AdmissionRequest{Image: "allowed", Digest: "sha256:abc"}))
+	require.ErrorContains(t, callAdmissionWebhook(ctx, server.URL, &AdmissionRequest{Image: "denied"}), "not today")
+	require.Error(t, callAdmissionWebhook(ctx, server.URL, &AdmissionRequest{Image: "broken"}))
+}
+
+func TestRegisterAdmissionHook(t *testing.T) {
+	hook := func(context.Context, *AdmissionRequest) error { return nil }
+	require.NoError(t, RegisterAdmissionHook("test", hook))
+	defer UnregisterAdmissionHook("test")
+	require.Error(t, RegisterAdmissionHook("test", hook))
+}
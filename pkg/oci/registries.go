@@ -0,0 +1,254 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/distribution/reference"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+// DefaultRegistriesFile is where the registries config is read from if no other file is given
+const DefaultRegistriesFile = "/var/lib/ig/registries.yaml"
+
+// RegistriesConfig configures how registries are reached when pulling gadget images, e.g. through mirrors or
+// pull-through proxies for clusters without direct internet access. HTTP proxies are taken from the HTTPS_PROXY,
+// HTTP_PROXY and NO_PROXY environment variables.
+type RegistriesConfig struct {
+	// CAFile is a PEM file with certificate authorities trusted for all registries and mirrors, in addition to the
+	// ones of the system
+	CAFile string `yaml:"caFile"`
+
+	Registries []RegistryConfig `yaml:"registries"`
+}
+
+// RegistryConfig configures a registry or the repositories below a prefix
+type RegistryConfig struct {
+	// Prefix is the registry or repository prefix the settings apply to, e.g. "ghcr.io" or
+	// "ghcr.io/inspektor-gadget"; the longest matching prefix is used
+	Prefix string `yaml:"prefix"`
+
+	// Mirrors are tried in order before the registry itself when pulling images. The part of the image name below
+	// Prefix is appended to them, e.g. "ghcr.io/inspektor-gadget/gadget/trace_exec" is pulled from
+	// "mirror.example.com/ghcr/inspektor-gadget/gadget/trace_exec" for the mirror "mirror.example.com/ghcr" of
+	// "ghcr.io". Their own settings are looked up by their name.
+	Mirrors []string `yaml:"mirrors"`
+
+	// MirrorsOnly doesn't fall back to the registry itself if the image can't be pulled from any mirror
+	MirrorsOnly bool `yaml:"mirrorsOnly"`
+
+	// Insecure uses plain HTTP to connect to the registry
+	Insecure bool `yaml:"insecure"`
+
+	// CAFile is a PEM file with certificate authorities trusted for the registry
+	CAFile string `yaml:"caFile"`
+}
+
+// ReadRegistriesConfig reads and validates a registries config
+func ReadRegistriesConfig(r io.Reader) (*RegistriesConfig, error) {
+	config := &RegistriesConfig{}
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("decoding registries config: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// LoadRegistriesConfig reads the registries config at path; if there's no file at DefaultRegistriesFile, an empty
+// config is returned
+func LoadRegistriesConfig(path string) (*RegistriesConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && path == DefaultRegistriesFile {
+			return &RegistriesConfig{}, nil
+		}
+		return nil, fmt.Errorf("opening registries config: %w", err)
+	}
+	defer f.Close()
+	return ReadRegistriesConfig(f)
+}
+
+// Validate checks the config for errors
+func (c *RegistriesConfig) Validate() error {
+	prefixes := make(map[string]struct{})
+	for _, r := range c.Registries {
+		if err := validatePrefix(r.Prefix); err != nil {
+			return err
+		}
+		if _, ok := prefixes[r.Prefix]; ok {
+			return fmt.Errorf("duplicate registry %q", r.Prefix)
+		}
+		prefixes[r.Prefix] = struct{}{}
+		for _, mirror := range r.Mirrors {
+			if err := validatePrefix(mirror); err != nil {
+				return fmt.Errorf("registry %q: invalid mirror: %w", r.Prefix, err)
+			}
+		}
+		if r.MirrorsOnly && len(r.Mirrors) == 0 {
+			return fmt.Errorf("registry %q: mirrorsOnly requires mirrors", r.Prefix)
+		}
+	}
+	return nil
+}
+
+func validatePrefix(prefix string) error {
+	if prefix == "" || strings.HasSuffix(prefix, "/") || strings.Contains(prefix, "://") {
+		return fmt.Errorf("invalid registry %q: expected a registry or repository prefix like ghcr.io/inspektor-gadget", prefix)
+	}
+	return nil
+}
+
+// AddMirrors adds mirrors of a registry or repository prefix, which are tried before the ones that were already
+// configured for it
+func (c *RegistriesConfig) AddMirrors(prefix string, mirrors ...string) {
+	for i := range c.Registries {
+		if c.Registries[i].Prefix == prefix {
+			c.Registries[i].Mirrors = append(mirrors, c.Registries[i].Mirrors...)
+			return
+		}
+	}
+	c.Registries = append(c.Registries, RegistryConfig{Prefix: prefix, Mirrors: mirrors})
+}
+
+// ParseMirrors parses mirrors given as "<registry>=<mirror>" joined by ',' and adds them to the config
+func (c *RegistriesConfig) ParseMirrors(mirrors string) error {
+	for _, m := range strings.Split(mirrors, ",") {
+		if m = strings.TrimSpace(m); m == "" {
+			continue
+		}
+		prefix, mirror, ok := strings.Cut(m, "=")
+		if !ok {
+			return fmt.Errorf("invalid mirror %q: expected <registry>=<mirror>", m)
+		}
+		c.AddMirrors(prefix, mirror)
+	}
+	return c.Validate()
+}
+
+// registry returns the settings of the longest prefix matching the repository name, if any
+func (c *RegistriesConfig) registry(name reference.Named) *RegistryConfig {
+	var match *RegistryConfig
+	for i := range c.Registries {
+		r := &c.Registries[i]
+		if matchesRegistry(name, r.Prefix) && (match == nil || len(r.Prefix) > len(match.Prefix)) {
+			match = r
+		}
+	}
+	return match
+}
+
+// mirrorRepositories returns the names of the repositories on the mirrors of the registry of image, followed by
+// the repository of the image itself unless only mirrors are to be used
+func (c *RegistriesConfig) mirrorRepositories(image reference.Named) []string {
+	r := c.registry(image)
+	if r == nil {
+		return []string{image.Name()}
+	}
+	var names []string
+	below := strings.TrimPrefix(image.Name(), r.Prefix)
+	if reference.Domain(image) == r.Prefix {
+		below = "/" + reference.Path(image)
+	}
+	for _, mirror := range r.Mirrors {
+		names = append(names, mirror+below)
+	}
+	if !r.MirrorsOnly {
+		names = append(names, image.Name())
+	}
+	return names
+}
+
+// newTransport returns a transport trusting the certificate authorities of caFiles in addition to the ones of the
+// system. Like the default transport, it uses the proxies configured in the environment.
+func newTransport(caFiles ...string) (http.RoundTripper, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, caFile := range caFiles {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %q", caFile)
+		}
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return retry.NewTransport(t), nil
+}
+
+// registriesConfig returns the registries config of authOpts or the one at DefaultRegistriesFile
+func registriesConfig(authOpts *AuthOptions) (*RegistriesConfig, error) {
+	if authOpts.Registries != nil {
+		return authOpts.Registries, nil
+	}
+	return LoadRegistriesConfig(DefaultRegistriesFile)
+}
+
+// imageReference returns the digest or tag of image, which is how it's referred to in any of its repositories
+func imageReference(image reference.Named) string {
+	if digested, ok := image.(reference.Digested); ok {
+		return digested.Digest().String()
+	}
+	if tagged, ok := image.(reference.Tagged); ok {
+		return tagged.Tag()
+	}
+	return "latest"
+}
+
+// pullRepositories calls pull with the repositories image can be pulled from, the mirrors first, until it succeeds.
+// pull gets the reference of the image within the repository.
+func pullRepositories(image reference.Named, authOpts *AuthOptions, pull func(repo *remote.Repository, ref string) error) error {
+	config, err := registriesConfig(authOpts)
+	if err != nil {
+		return err
+	}
+	names := config.mirrorRepositories(image)
+	for i, name := range names {
+		named, err := reference.ParseNormalizedNamed(name)
+		if err != nil {
+			return fmt.Errorf("parsing mirror repository %q: %w", name, err)
+		}
+		repo, err := newConfiguredRepository(named, authOpts, config)
+		if err == nil {
+			err = pull(repo, imageReference(image))
+		}
+		if err == nil {
+			return nil
+		}
+		if i == len(names)-1 {
+			return err
+		}
+		log.Warnf("pulling %s from %s: %v; trying %s", image, name, err, names[i+1])
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+func TestReadRegistriesConfig(t *testing.T) {
+	config, err := ReadRegistriesConfig(strings.NewReader(`
+caFile: /etc/ssl/corp-ca.pem
+registries:
+- prefix: ghcr.io
+  mirrors:
+  - mirror.example.com/ghcr
+  - mirror2.example.com
+- prefix: ghcr.io/inspektor-gadget
+  mirrors:
+  - gadgets.example.com
+  mirrorsOnly: true
+- prefix: mirror2.example.com
+  insecure: true
+`))
+	require.NoError(t, err)
+	require.Equal(t, "/etc/ssl/corp-ca.pem", config.CAFile)
+	require.Len(t, config.Registries, 3)
+
+	invalid := []string{
+		"registries:\n- mirrors: [mirror.example.com]",
+		"registries:\n- prefix: ghcr.io/\n",
+		"registries:\n- prefix: https://ghcr.io\n",
+		"registries:\n- prefix: ghcr.io\n- prefix: ghcr.io\n",
+		"registries:\n- prefix: ghcr.io\n  mirrors: ['']\n",
+		"registries:\n- prefix: ghcr.io\n  mirrorsOnly: true\n",
+		"unknown: true",
+	}
+	for _, c := range invalid {
+		_, err := ReadRegistriesConfig(strings.NewReader(c))
+		require.Error(t, err, c)
+	}
+
+	// Only a missing file at the default path is fine
+	_, err = LoadRegistriesConfig(filepath.Join(t.TempDir(), "registries.yaml"))
+	require.Error(t, err)
+}
+
+func TestMirrorRepositories(t *testing.T) {
+	config := &RegistriesConfig{
+		Registries: []RegistryConfig{
+			{Prefix: "ghcr.io", Mirrors: []string{"mirror.example.com/ghcr", "mirror2.example.com"}},
+			{Prefix: "ghcr.io/inspektor-gadget", Mirrors: []string{"gadgets.example.com"}, MirrorsOnly: true},
+		},
+	}
+	tests := map[string][]string{
+		"trace_exec": {"gadgets.example.com/gadget/trace_exec"},
+		"ghcr.io/other/gadget:v1": {
+			"mirror.example.com/ghcr/other/gadget",
+			"mirror2.example.com/other/gadget",
+			"ghcr.io/other/gadget",
+		},
+		"docker.io/library/gadget": {"docker.io/library/gadget"},
+	}
+	for image, expected := range tests {
+		named, err := normalizeImageName(image)
+		require.NoError(t, err)
+		require.Equal(t, expected, config.mirrorRepositories(named), image)
+	}
+
+	// Mirrors given as params are tried first
+	require.NoError(t, config.ParseMirrors("ghcr.io=proxy.example.com:5000/ghcr, registry.example.com=mirror3.example.com"))
+	require.Equal(t, []string{"proxy.example.com:5000/ghcr", "mirror.example.com/ghcr", "mirror2.example.com"},
+		config.Registries[0].Mirrors)
+	require.Equal(t, RegistryConfig{Prefix: "registry.example.com", Mirrors: []string{"mirror3.example.com"}},
+		config.Registries[2])
+	require.Error(t, config.ParseMirrors("mirror.example.com"))
+}
+
+func TestImageReference(t *testing.T) {
+	for image, expected := range map[string]string{
+		"trace_exec":        "latest",
+		"trace_exec:v0.1.0": "v0.1.0",
+		"trace_exec@sha256:0123456789012345678901234567890123456789012345678901234567890123": "sha256:0123456789012345678901234567890123456789012345678901234567890123",
+	} {
+		named, err := normalizeImageName(image)
+		require.NoError(t, err)
+		require.Equal(t, expected, imageReference(named), image)
+	}
+}
+
+func TestPullRepositories(t *testing.T) {
+	authOpts := &AuthOptions{
+		Insecure: true,
+		Registries: &RegistriesConfig{
+			Registries: []RegistryConfig{{Prefix: "ghcr.io", Mirrors: []string{"mirror.example.com", "mirror2.example.com"}}},
+		},
+	}
+	image, err := normalizeImageName("trace_exec:v1")
+	require.NoError(t, err)
+
+	// The mirrors are tried in order until the image could be pulled
+	var tried []string
+	err = pullRepositories(image, authOpts, func(repo *remote.Repository, ref string) error {
+		require.Equal(t, "v1", ref)
+		tried = append(tried, repo.Reference.Registry)
+		if repo.Reference.Registry == "mirror.example.com" {
+			return errors.New("not found")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"mirror.example.com", "mirror2.example.com"}, tried)
+
+	// The error of the registry itself is returned if it can't be pulled from anywhere
+	tried = nil
+	err = pullRepositories(image, authOpts, func(repo *remote.Repository, ref string) error {
+		tried = append(tried, repo.Reference.Registry)
+		return errors.New(repo.Reference.Registry)
+	})
+	require.EqualError(t, err, "ghcr.io")
+	require.Equal(t, []string{"mirror.example.com", "mirror2.example.com", "ghcr.io"}, tried)
+}
+
+func TestNewTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o644))
+
+	transport, err := newTransport(caFile)
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// The certificate of the server isn't trusted without the CA file
+	transport, err = newTransport()
+	require.NoError(t, err)
+	_, err = (&http.Client{Transport: transport}).Get(server.URL)
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(caFile, []byte("no certificate"), 0o644))
+	_, err = newTransport(caFile)
+	require.Error(t, err)
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// ImageUpdate tells that the tag of an image that's run refers to another image in its registry
+type ImageUpdate struct {
+	// Tag is the normalized name of the tag, e.g. "ghcr.io/inspektor-gadget/gadget/trace_exec:latest"
+	Tag string
+
+	// Current is the digest of the image that's run
+	Current string
+
+	// Latest is the digest the tag refers to in the registry
+	Latest string
+}
+
+func (u *ImageUpdate) String() string {
+	return fmt.Sprintf("%s refers to %s in the registry, but %s is run", u.Tag, u.Latest, u.Current)
+}
+
+// CheckForUpdate resolves the tag of image in its registry, without pulling it, and returns an update if the tag
+// refers to another image than the one that's run: for images pinned by tag and digest, like
+// "trace_exec:v0.30.0@sha256:...", that's the pinned digest, otherwise the digest of the local copy. nil is returned
+// if the image is up to date, if there's no local copy of it or if it's only referred to by digest.
+func CheckForUpdate(ctx context.Context, image string, authOpts *AuthOptions) (*ImageUpdate, error) {
+	imageStore, err := getLocalOciStore()
+	if err != nil {
+		return nil, fmt.Errorf("getting local oci store: %w", err)
+	}
+	return checkForUpdate(ctx, imageStore, image, authOpts)
+}
+
+func checkForUpdate(ctx context.Context, imageStore oras.ReadOnlyTarget, image string, authOpts *AuthOptions) (*ImageUpdate, error) {
+	targetImage, err := normalizeImageName(image)
+	if err != nil {
+		return nil, fmt.Errorf("normalizing image: %w", err)
+	}
+	tagged, ok := targetImage.(reference.Tagged)
+	if !ok {
+		// There's no tag that could have moved
+		return nil, nil
+	}
+	tag, err := reference.WithTag(reference.TrimNamed(targetImage), tagged.Tag())
+	if err != nil {
+		return nil, fmt.Errorf("getting tag of image: %w", err)
+	}
+
+	var current string
+	if digested, ok := targetImage.(reference.Digested); ok {
+		current = digested.Digest().String()
+	} else {
+		desc, err := imageStore.Resolve(ctx, targetImage.String())
+		if errors.Is(err, errdef.ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("resolving image %q: %w", image, err)
+		}
+		current = desc.Digest.String()
+	}
+
+	var latest ocispec.Descriptor
+	err = pullRepositories(tag, authOpts, func(repo *remote.Repository, ref string) error {
+		var err error
+		latest, err = repo.Resolve(ctx, ref)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s in registry: %w", tag, err)
+	}
+	if latest.Digest.String() == current {
+		return nil, nil
+	}
+	return &ImageUpdate{Tag: tag.String(), Current: current, Latest: latest.Digest.String()}, nil
+}
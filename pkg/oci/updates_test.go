@@ -0,0 +1,95 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/stretchr/testify/require"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/memory"
+	"oras.land/oras-go/v2/content/oci"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// pushTestImage builds an image with the given program and pushes it to the registry as repository:tag; it returns
+// the digest of the image
+func pushTestImage(t *testing.T, repository, tag, program string) string {
+	ctx := context.Background()
+	src := memory.New()
+	buildTestImage(t, src, repository+":"+tag, map[string]string{ArchAmd64: program})
+
+	repo, err := remote.NewRepository(repository)
+	require.NoError(t, err)
+	repo.PlainHTTP = true
+	desc, err := oras.Copy(ctx, src, repository+":"+tag, repo, tag, oras.DefaultCopyOptions)
+	require.NoError(t, err)
+	return desc.Digest.String()
+}
+
+func TestPinnedImages(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+
+	repository := strings.TrimPrefix(server.URL, "http://") + "/gadgets/mygadget"
+	authOpts := &AuthOptions{Insecure: true, Registries: &RegistriesConfig{}}
+	store, err := oci.New(t.TempDir())
+	require.NoError(t, err)
+
+	v1 := pushTestImage(t, repository, "v1", "first program")
+
+	// Images can be pulled by digest, with or without tag
+	desc, err := pullGadgetImageToStore(ctx, store, repository+"@"+v1, authOpts)
+	require.NoError(t, err)
+	require.Equal(t, v1, desc.Digest)
+	_, err = getIndex(ctx, store, repository+"@"+v1)
+	require.NoError(t, err)
+	_, err = pullGadgetImageToStore(ctx, store, repository+":v1", authOpts)
+	require.NoError(t, err)
+
+	// Nothing is reported while the tag refers to the image that's run
+	for _, image := range []string{repository + ":v1", repository + ":v1@" + v1, repository + "@" + v1} {
+		update, err := checkForUpdate(ctx, store, image, authOpts)
+		require.NoError(t, err)
+		require.Nil(t, update, image)
+	}
+
+	v2 := pushTestImage(t, repository, "v1", "second program")
+	require.NotEqual(t, v1, v2)
+
+	// Moving the tag is reported, but the image isn't pulled
+	for _, image := range []string{repository + ":v1", repository + ":v1@" + v1} {
+		update, err := checkForUpdate(ctx, store, image, authOpts)
+		require.NoError(t, err)
+		require.Equal(t, &ImageUpdate{Tag: repository + ":v1", Current: v1, Latest: v2}, update, image)
+	}
+	local, err := store.Resolve(ctx, repository+":v1")
+	require.NoError(t, err)
+	require.Equal(t, v1, local.Digest.String())
+
+	// Images pinned by digest that are available locally under another name don't need the registry
+	server.Close()
+	require.NoError(t, pullIfNotExist(ctx, store, authOpts, repository+":pinned@"+v1))
+	_, err = getIndex(ctx, store, repository+":pinned@"+v1)
+	require.NoError(t, err)
+	require.Error(t, pullIfNotExist(ctx, store, authOpts, repository+"@"+v2))
+}
@@ -0,0 +1,251 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package aggregate provides an operator that turns the events of a data source into periodic summaries: the events
+// are grouped by a set of fields and the number of events and statistics of a numeric field are emitted for each
+// group on a derived data source. This gives a "top"-like view of any tracer gadget.
+package aggregate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/filter"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	OperatorName = "aggregate"
+
+	ParamSource     = "aggregate-source"
+	ParamKeys       = "aggregate-keys"
+	ParamValue      = "aggregate-value"
+	ParamInterval   = "aggregate-interval"
+	ParamMaxGroups  = "aggregate-max-groups"
+	ParamKeepEvents = "aggregate-keep-events"
+
+	// Priority is chosen so that only data that passed the filter operator is aggregated, and that the aggregated
+	// events can be dropped before they reach any sink
+	Priority = filter.Priority + 50
+
+	// DataSourceSuffix is appended to the name of the aggregated data source to get the name of the data source
+	// the summaries are emitted to
+	DataSourceSuffix = "_aggregate"
+)
+
+type aggregateOperator struct{}
+
+func (o *aggregateOperator) Name() string {
+	return OperatorName
+}
+
+func (o *aggregateOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *aggregateOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *aggregateOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:         ParamSource,
+			Title:       "Aggregated data source",
+			Description: "Data source to aggregate; can be omitted if the gadget only has one",
+			TypeHint:    api.TypeString,
+		},
+		{
+			Key:   ParamKeys,
+			Title: "Aggregation keys",
+			Description: "Comma-separated fields to group events by, e.g. 'comm,fname'; setting this or " +
+				ParamValue + " enables the aggregation",
+			TypeHint: api.TypeString,
+		},
+		{
+			Key:         ParamValue,
+			Title:       "Aggregated value",
+			Description: "Numeric field to compute the sum, minimum, maximum and percentiles (p50, p95, p99) of for each group",
+			TypeHint:    api.TypeString,
+		},
+		{
+			Key:          ParamInterval,
+			Title:        "Aggregation interval",
+			Description:  "Interval at which the summaries are emitted",
+			DefaultValue: "1s",
+			TypeHint:     api.TypeDuration,
+		},
+		{
+			Key:          ParamMaxGroups,
+			Title:        "Maximum number of groups",
+			Description:  "Maximum number of groups per interval; events of further groups are dropped",
+			DefaultValue: "1024",
+			TypeHint:     api.TypeUint32,
+		},
+		{
+			Key:          ParamKeepEvents,
+			Title:        "Keep aggregated events",
+			Description:  "Also emit the aggregated events themselves instead of only the summaries",
+			DefaultValue: "false",
+			TypeHint:     api.TypeBool,
+		},
+	}
+}
+
+func (o *aggregateOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &aggregateOperatorInstance{}
+
+	var keys []string
+	for _, key := range strings.Split(params.Get(ParamKeys).AsString(), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	value := strings.TrimSpace(params.Get(ParamValue).AsString())
+	if len(keys) == 0 && value == "" {
+		return inst, nil
+	}
+
+	inst.interval = params.Get(ParamInterval).AsDuration()
+	if inst.interval <= 0 {
+		return nil, fmt.Errorf("%s must be greater than 0", ParamInterval)
+	}
+	maxGroups := params.Get(ParamMaxGroups).AsUint32()
+	if maxGroups == 0 {
+		return nil, fmt.Errorf("%s must be greater than 0", ParamMaxGroups)
+	}
+	inst.keepEvents = params.Get(ParamKeepEvents).AsBool()
+
+	source, err := sourceDataSource(gadgetCtx, strings.TrimSpace(params.Get(ParamSource).AsString()))
+	if err != nil {
+		return nil, err
+	}
+
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, source.Name()+DataSourceSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("adding aggregate data source: %w", err)
+	}
+	inst.aggregator, err = newAggregator(source, ds, keys, value, int(maxGroups))
+	if err != nil {
+		return nil, fmt.Errorf("aggregating data source %q: %w", source.Name(), err)
+	}
+	return inst, nil
+}
+
+// sourceDataSource returns the data source called name or, if name is empty, the only data source of the gadget
+func sourceDataSource(gadgetCtx operators.GadgetContext, name string) (datasource.DataSource, error) {
+	dataSources := gadgetCtx.GetDataSources()
+	if name != "" {
+		ds, ok := dataSources[name]
+		if !ok {
+			return nil, fmt.Errorf("data source %q not found", name)
+		}
+		return ds, nil
+	}
+	if len(dataSources) != 1 {
+		return nil, fmt.Errorf("%s needs to be set, as the gadget has %d data sources", ParamSource, len(dataSources))
+	}
+	for _, ds := range dataSources {
+		return ds, nil
+	}
+	return nil, nil
+}
+
+func (o *aggregateOperator) Priority() int {
+	return Priority
+}
+
+type aggregateOperatorInstance struct {
+	// aggregator is nil if aggregation isn't enabled
+	aggregator *aggregator
+	interval   time.Duration
+	keepEvents bool
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func (o *aggregateOperatorInstance) Name() string {
+	return OperatorName
+}
+
+func (o *aggregateOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error {
+	if o.aggregator == nil {
+		return nil
+	}
+	o.aggregator.source.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		o.aggregator.add(data)
+		if !o.keepEvents {
+			return datasource.ErrDiscard
+		}
+		return nil
+	}, Priority)
+	return nil
+}
+
+func (o *aggregateOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	if o.aggregator == nil {
+		return nil
+	}
+
+	o.done = make(chan struct{})
+	o.stopped = make(chan struct{})
+	go func() {
+		defer close(o.stopped)
+		ticker := time.NewTicker(o.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-o.done:
+				return
+			case <-gadgetCtx.Context().Done():
+				return
+			case <-ticker.C:
+				dropped, err := o.aggregator.flush()
+				if err != nil {
+					gadgetCtx.Logger().Warnf("emitting aggregates: %v", err)
+				}
+				if dropped > 0 {
+					gadgetCtx.Logger().Warnf("dropped %d events exceeding %s", dropped, ParamMaxGroups)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (o *aggregateOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	if o.done == nil {
+		return nil
+	}
+	close(o.done)
+	<-o.stopped
+	o.done = nil
+	return nil
+}
+
+func init() {
+	operators.RegisterDataOperator(&aggregateOperator{})
+}
@@ -0,0 +1,166 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+type testSource struct {
+	ds      datasource.DataSource
+	comm    datasource.FieldAccessor
+	latency datasource.FieldAccessor
+}
+
+func newTestSource(t *testing.T, gadgetCtx *gadgetcontext.GadgetContext) *testSource {
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+	proc, err := ds.AddField("proc", datasource.WithFlags(datasource.FieldFlagEmpty))
+	require.NoError(t, err)
+	comm, err := proc.AddSubField("comm", datasource.WithKind(api.Kind_String))
+	require.NoError(t, err)
+	latency, err := ds.AddField("latency", datasource.WithKind(api.Kind_Uint32))
+	require.NoError(t, err)
+	return &testSource{ds: ds, comm: comm, latency: latency}
+}
+
+func (s *testSource) emit(t *testing.T, comm string, latency uint32) {
+	data := s.ds.NewData()
+	require.NoError(t, s.comm.Set(data, []byte(comm)))
+	require.NoError(t, s.latency.Set(data, make([]byte, 4)))
+	s.latency.PutUint32(data, latency)
+	require.NoError(t, s.ds.EmitAndRelease(data))
+}
+
+type summary struct {
+	comm  string
+	count uint64
+	stats []float64
+}
+
+func TestAggregateOperator(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	src := newTestSource(t, gadgetCtx)
+
+	op := &aggregateOperator{}
+	inst, err := op.InstantiateDataOperator(gadgetCtx, api.ParamValues{
+		ParamKeys:      "proc.comm",
+		ParamValue:     "latency",
+		ParamMaxGroups: "2",
+	})
+	require.NoError(t, err)
+	a := inst.(*aggregateOperatorInstance).aggregator
+	require.NotNil(t, a)
+
+	ds := gadgetCtx.GetDataSources()["events"+DataSourceSuffix]
+	require.NotNil(t, ds)
+	comm := ds.GetField("proc_comm")
+	require.NotNil(t, comm)
+	require.Equal(t, api.Kind_String, comm.Type())
+	count := ds.GetField("count")
+	require.NotNil(t, count)
+	var stats []datasource.FieldAccessor
+	for _, name := range []string{"sum", "min", "max", "p50", "p95", "p99"} {
+		f := ds.GetField("latency_" + name)
+		require.NotNil(t, f, name)
+		stats = append(stats, f)
+	}
+
+	var summaries []summary
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		s := summary{comm: comm.String(data), count: count.Uint64(data)}
+		for _, f := range stats {
+			s.stats = append(s.stats, f.Float64(data))
+		}
+		summaries = append(summaries, s)
+		return nil
+	}, 0)
+
+	// Aggregated events are dropped by default
+	var passed int
+	src.ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		passed++
+		return nil
+	}, Priority+1)
+
+	require.NoError(t, inst.(*aggregateOperatorInstance).PreStart(gadgetCtx))
+
+	for i := uint32(1); i <= 100; i++ {
+		src.emit(t, "curl", i)
+	}
+	src.emit(t, "bash", 7)
+	src.emit(t, "bash", 3)
+	// Exceeds the maximum number of groups
+	src.emit(t, "nginx", 1)
+
+	dropped, err := a.flush()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), dropped)
+	require.Zero(t, passed)
+
+	// The biggest group comes first
+	require.Equal(t, []summary{
+		{comm: "curl", count: 100, stats: []float64{5050, 1, 100, 50, 95, 99}},
+		{comm: "bash", count: 2, stats: []float64{10, 3, 7, 3, 7, 7}},
+	}, summaries)
+
+	// A new interval starts after flushing
+	summaries = nil
+	src.emit(t, "nginx", 1)
+	dropped, err = a.flush()
+	require.NoError(t, err)
+	require.Zero(t, dropped)
+	require.Equal(t, []summary{{comm: "nginx", count: 1, stats: []float64{1, 1, 1, 1, 1, 1}}}, summaries)
+}
+
+func TestAggregateOperatorParams(t *testing.T) {
+	for name, tc := range map[string]struct {
+		values  api.ParamValues
+		enabled bool
+		err     bool
+	}{
+		"disabled":           {values: api.ParamValues{}},
+		"count only":         {values: api.ParamValues{ParamKeys: "proc.comm"}, enabled: true},
+		"value only":         {values: api.ParamValues{ParamValue: "latency"}, enabled: true},
+		"unknown source":     {values: api.ParamValues{ParamKeys: "proc.comm", ParamSource: "foo"}, err: true},
+		"unknown key":        {values: api.ParamValues{ParamKeys: "foo"}, err: true},
+		"key without value":  {values: api.ParamValues{ParamKeys: "proc"}, err: true},
+		"non-numeric value":  {values: api.ParamValues{ParamValue: "proc.comm"}, err: true},
+		"zero interval":      {values: api.ParamValues{ParamKeys: "proc.comm", ParamInterval: "0s"}, err: true},
+		"zero maximum group": {values: api.ParamValues{ParamKeys: "proc.comm", ParamMaxGroups: "0"}, err: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			gadgetCtx := gadgetcontext.New(context.Background(), "test")
+			newTestSource(t, gadgetCtx)
+
+			inst, err := (&aggregateOperator{}).InstantiateDataOperator(gadgetCtx, tc.values)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.enabled, inst.(*aggregateOperatorInstance).aggregator != nil)
+			_, ok := gadgetCtx.GetDataSources()["events"+DataSourceSuffix]
+			require.Equal(t, tc.enabled, ok)
+		})
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregate
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+// maxSamples limits the number of values kept per group and interval to estimate percentiles; groups with more
+// values use a uniform sample of them
+const maxSamples = 1024
+
+var percentiles = []struct {
+	name string
+	p    float64
+}{
+	{"p50", 50},
+	{"p95", 95},
+	{"p99", 99},
+}
+
+type keyField struct {
+	in  datasource.FieldAccessor
+	out datasource.FieldAccessor
+}
+
+type group struct {
+	keys    [][]byte
+	count   uint64
+	sum     float64
+	min     float64
+	max     float64
+	samples []float64
+}
+
+// aggregator groups the data of a source data source by its key fields and emits a summary of each group to a derived
+// data source on every flush
+type aggregator struct {
+	source datasource.DataSource
+	ds     datasource.DataSource
+
+	keys  []keyField
+	count datasource.FieldAccessor
+
+	// value and the fields derived from it are nil if only events are counted
+	value       datasource.FieldAccessor
+	sum         datasource.FieldAccessor
+	min         datasource.FieldAccessor
+	max         datasource.FieldAccessor
+	percentiles []datasource.FieldAccessor
+
+	maxGroups int
+
+	mu      sync.Mutex
+	rng     *rand.Rand
+	groups  map[string]*group
+	dropped uint64
+}
+
+// fieldName returns a name for a field of the derived data source; sub-fields are flattened
+func fieldName(name string) string {
+	return strings.ReplaceAll(name, ".", "_")
+}
+
+func isNumeric(kind api.Kind) bool {
+	switch kind {
+	case api.Kind_Int8, api.Kind_Int16, api.Kind_Int32, api.Kind_Int64,
+		api.Kind_Uint8, api.Kind_Uint16, api.Kind_Uint32, api.Kind_Uint64,
+		api.Kind_Float32, api.Kind_Float64:
+		return true
+	}
+	return false
+}
+
+func numericValue(f datasource.FieldAccessor, data datasource.Data) float64 {
+	switch f.Type() {
+	case api.Kind_Int8:
+		return float64(f.Int8(data))
+	case api.Kind_Int16:
+		return float64(f.Int16(data))
+	case api.Kind_Int32:
+		return float64(f.Int32(data))
+	case api.Kind_Int64:
+		return float64(f.Int64(data))
+	case api.Kind_Uint8:
+		return float64(f.Uint8(data))
+	case api.Kind_Uint16:
+		return float64(f.Uint16(data))
+	case api.Kind_Uint32:
+		return float64(f.Uint32(data))
+	case api.Kind_Uint64:
+		return float64(f.Uint64(data))
+	case api.Kind_Float32:
+		return float64(f.Float32(data))
+	case api.Kind_Float64:
+		return f.Float64(data)
+	}
+	return 0
+}
+
+// newAggregator adds the fields of the summaries to ds: the key fields with the kind of the original ones, the number
+// of events and, if valueName is set, statistics of that field
+func newAggregator(source, ds datasource.DataSource, keyNames []string, valueName string, maxGroups int) (*aggregator, error) {
+	a := &aggregator{
+		source:    source,
+		ds:        ds,
+		maxGroups: maxGroups,
+		rng:       rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		groups:    make(map[string]*group),
+	}
+
+	for _, name := range keyNames {
+		in := source.GetField(name)
+		if in == nil {
+			return nil, fmt.Errorf("field %q not found", name)
+		}
+		if datasource.FieldFlagEmpty.In(in.Flags()) {
+			return nil, fmt.Errorf("field %q doesn't have a value", name)
+		}
+		out, err := ds.AddField(fieldName(name),
+			datasource.WithKind(in.Type()),
+			datasource.WithAnnotations(in.Annotations()),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("adding key field %q: %w", name, err)
+		}
+		a.keys = append(a.keys, keyField{in: in, out: out})
+	}
+
+	var err error
+	a.count, err = ds.AddField("count", datasource.WithKind(api.Kind_Uint64), datasource.WithAnnotations(map[string]string{
+		"description": "Number of events during the interval",
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("adding field %q: %w", "count", err)
+	}
+
+	if valueName == "" {
+		return a, nil
+	}
+
+	a.value = source.GetField(valueName)
+	if a.value == nil {
+		return nil, fmt.Errorf("field %q not found", valueName)
+	}
+	if !isNumeric(a.value.Type()) {
+		return nil, fmt.Errorf("field %q is not numeric", valueName)
+	}
+
+	for _, f := range []struct {
+		acc         *datasource.FieldAccessor
+		suffix      string
+		description string
+	}{
+		{&a.sum, "sum", "Sum of %s during the interval"},
+		{&a.min, "min", "Minimum of %s during the interval"},
+		{&a.max, "max", "Maximum of %s during the interval"},
+	} {
+		*f.acc, err = a.addValueField(valueName, f.suffix, fmt.Sprintf(f.description, valueName))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range percentiles {
+		acc, err := a.addValueField(valueName, p.name, fmt.Sprintf("%gth percentile of %s during the interval", p.p, valueName))
+		if err != nil {
+			return nil, err
+		}
+		a.percentiles = append(a.percentiles, acc)
+	}
+	return a, nil
+}
+
+func (a *aggregator) addValueField(valueName, suffix, description string) (datasource.FieldAccessor, error) {
+	name := fieldName(valueName) + "_" + suffix
+	acc, err := a.ds.AddField(name, datasource.WithKind(api.Kind_Float64), datasource.WithAnnotations(map[string]string{
+		"description": description,
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("adding field %q: %w", name, err)
+	}
+	return acc, nil
+}
+
+// add accounts data to its group
+func (a *aggregator) add(data datasource.Data) {
+	var key []byte
+	for _, k := range a.keys {
+		b := k.in.Get(data)
+		key = binary.LittleEndian.AppendUint32(key, uint32(len(b)))
+		key = append(key, b...)
+	}
+
+	var value float64
+	if a.value != nil {
+		value = numericValue(a.value, data)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	g, ok := a.groups[string(key)]
+	if !ok {
+		if len(a.groups) >= a.maxGroups {
+			a.dropped++
+			return
+		}
+		g = &group{min: value, max: value}
+		for _, k := range a.keys {
+			// The memory of data is reused, so keys have to be copied
+			g.keys = append(g.keys, slices.Clone(k.in.Get(data)))
+		}
+		a.groups[string(key)] = g
+	}
+
+	g.count++
+	if a.value == nil {
+		return
+	}
+	g.sum += value
+	g.min = min(g.min, value)
+	g.max = max(g.max, value)
+
+	// Reservoir sampling keeps a uniform sample of all values of the group
+	if len(g.samples) < maxSamples {
+		g.samples = append(g.samples, value)
+	} else if i := a.rng.Uint64N(g.count); i < maxSamples {
+		g.samples[i] = value
+	}
+}
+
+// flush emits the summaries of all groups, the biggest first, and starts a new interval. It returns the number of
+// events that were dropped because of maxGroups during the interval.
+func (a *aggregator) flush() (uint64, error) {
+	a.mu.Lock()
+	groups := a.groups
+	dropped := a.dropped
+	a.groups = make(map[string]*group, len(groups))
+	a.dropped = 0
+	a.mu.Unlock()
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(x, y string) int {
+		if c := cmp.Compare(groups[y].count, groups[x].count); c != 0 {
+			return c
+		}
+		return strings.Compare(x, y)
+	})
+
+	for _, key := range keys {
+		if err := a.emit(groups[key]); err != nil {
+			return dropped, err
+		}
+	}
+	return dropped, nil
+}
+
+func (a *aggregator) emit(g *group) error {
+	data := a.ds.NewData()
+	for i, k := range a.keys {
+		if err := k.out.Set(data, g.keys[i]); err != nil {
+			return fmt.Errorf("setting key %q: %w", k.out.Name(), err)
+		}
+	}
+	if err := a.count.Set(data, a.uint64Bytes(g.count)); err != nil {
+		return err
+	}
+
+	if a.value != nil {
+		slices.Sort(g.samples)
+		values := []float64{g.sum, g.min, g.max}
+		for _, p := range percentiles {
+			values = append(values, percentile(g.samples, p.p))
+		}
+		for i, acc := range append([]datasource.FieldAccessor{a.sum, a.min, a.max}, a.percentiles...) {
+			if err := acc.Set(data, a.uint64Bytes(math.Float64bits(values[i]))); err != nil {
+				return err
+			}
+		}
+	}
+	return a.ds.EmitAndRelease(data)
+}
+
+func (a *aggregator) uint64Bytes(v uint64) []byte {
+	b := make([]byte, 8)
+	a.ds.ByteOrder().PutUint64(b, v)
+	return b
+}
+
+// percentile returns the p-th percentile of the sorted samples using the nearest-rank method
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
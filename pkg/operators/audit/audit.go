@@ -0,0 +1,432 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit provides a data operator that emits the records of the Linux audit subsystem on a data source, so
+// that environments relying on audit rules can view and export them through the operators and sinks of Inspektor
+// Gadget, including the enrichment with container metadata. It's used by running the image "operator:audit". Rules in the
+// format of auditctl can be added while it's running; they're removed when it stops.
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+
+	containerutils "github.com/inspektor-gadget/inspektor-gadget/pkg/container-utils"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource/compat"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/formatters"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/syscalls"
+)
+
+const (
+	OperatorName = "audit"
+
+	// ImageName is the name of the image emitting the audit records
+	ImageName = operators.OperatorImagePrefix + "audit"
+
+	ParamRules = "rules"
+
+	// DataSourceName is the name of the data source the records are emitted on
+	DataSourceName = "audit"
+
+	// maxRecordSize is the maximum size of an audit record, including the netlink header
+	maxRecordSize = unix.NLMSG_HDRLEN + unix.AUDIT_MESSAGE_TEXT_MAX
+)
+
+// IsAuditImage tells whether imageName refers to the audit records
+var IsAuditImage = operators.MatchImageNames(ImageName)
+
+type auditOperator struct{}
+
+func (o *auditOperator) Name() string {
+	return OperatorName
+}
+
+func (o *auditOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *auditOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *auditOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:   ParamRules,
+			Title: "Audit rules",
+			Description: "Audit rules in the format of auditctl separated by ';' or newlines to add while running, e.g. " +
+				"\"-w /etc/passwd -p wa -k passwd; -a always,exit -S execve -k exec\"",
+			TypeHint: api.TypeString,
+		},
+	}
+}
+
+func (o *auditOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	if !IsAuditImage(gadgetCtx.ImageName()) {
+		return nil, nil
+	}
+
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &auditOperatorInstance{logger: gadgetCtx.Logger()}
+	inst.rules, err = parseRules(params.Get(ParamRules).AsString())
+	if err != nil {
+		return nil, err
+	}
+
+	inst.ds, err = gadgetCtx.RegisterDataSource(datasource.TypeEvent, DataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("adding data source %q: %w", DataSourceName, err)
+	}
+	if err := inst.addFields(); err != nil {
+		return nil, fmt.Errorf("adding fields to data source %q: %w", DataSourceName, err)
+	}
+	return inst, nil
+}
+
+func (o *auditOperator) Priority() int {
+	return operators.OperatorImagePriority
+}
+
+type auditOperatorInstance struct {
+	ds     datasource.DataSource
+	logger logger.Logger
+	rules  []*rule
+
+	timestamp datasource.FieldAccessor
+	serial    datasource.FieldAccessor
+	typ       datasource.FieldAccessor
+	pid       datasource.FieldAccessor
+	uid       datasource.FieldAccessor
+	auid      datasource.FieldAccessor
+	comm      datasource.FieldAccessor
+	exe       datasource.FieldAccessor
+	syscall   datasource.FieldAccessor
+	success   datasource.FieldAccessor
+	key       datasource.FieldAccessor
+	mntns     datasource.FieldAccessor
+	message   datasource.FieldAccessor
+
+	// mntnsFilter holds the mount namespaces of the selected containers, if any
+	mntnsFilter *ebpf.Map
+
+	// bootOffset converts the wall clock timestamps of the records to the clock used by eBPF programs
+	bootOffset int64
+
+	// last is the process of the last event; records of the same event after the SYSCALL record don't have it
+	last process
+
+	// added are the rules that were added and need to be removed when stopping
+	added []*rule
+
+	file *os.File
+	wg   sync.WaitGroup
+}
+
+// process is the process that caused an audit event
+type process struct {
+	serial uint64
+	pid    uint32
+	uid    uint32
+	auid   uint32
+	comm   string
+	exe    string
+	key    string
+	mntns  uint64
+}
+
+func (o *auditOperatorInstance) addFields() error {
+	hidden := datasource.WithFlags(datasource.FieldFlagHidden)
+	var err error
+	if o.timestamp, err = o.ds.AddField("timestamp", datasource.WithKind(api.Kind_Uint64),
+		datasource.WithAnnotations(map[string]string{"columns.template": "timestamp"}),
+		datasource.WithTags("type:"+formatters.TimestampTypeName)); err != nil {
+		return err
+	}
+	for _, f := range []struct {
+		acc         *datasource.FieldAccessor
+		name        string
+		kind        api.Kind
+		annotations map[string]string
+		opts        []datasource.FieldOption
+	}{
+		{&o.serial, "serial", api.Kind_Uint64, map[string]string{"description": "Serial number of the audit event the record belongs to"}, []datasource.FieldOption{hidden}},
+		{&o.typ, "type", api.Kind_String, map[string]string{"description": "Type of the record", "columns.width": "12"}, nil},
+		{&o.pid, "pid", api.Kind_Uint32, map[string]string{"columns.template": "pid"}, nil},
+		{&o.uid, "uid", api.Kind_Uint32, map[string]string{"columns.template": "uid"}, nil},
+		{&o.auid, "auid", api.Kind_Uint32, map[string]string{"description": "Login uid of the process", "columns.template": "uid"}, []datasource.FieldOption{hidden}},
+		{&o.comm, "comm", api.Kind_String, map[string]string{"columns.template": "comm"}, nil},
+		{&o.exe, "exe", api.Kind_String, map[string]string{"description": "Executable of the process"}, []datasource.FieldOption{hidden}},
+		{&o.syscall, "syscall", api.Kind_String, map[string]string{"description": "Name of the syscall", "columns.width": "16"}, nil},
+		{&o.success, "success", api.Kind_Bool, map[string]string{"description": "Whether the syscall succeeded", "columns.width": "7"}, []datasource.FieldOption{hidden}},
+		{&o.key, "key", api.Kind_String, map[string]string{"description": "Key of the rule that matched", "columns.width": "16"}, nil},
+		{&o.mntns, "mntns_id", api.Kind_Uint64, map[string]string{"description": "Mount namespace inode id", "columns.template": "ns"}, []datasource.FieldOption{datasource.WithTags(compat.MntNsIdType)}},
+		{&o.message, "message", api.Kind_String, map[string]string{"description": "Fields of the record as written to the audit log"}, nil},
+	} {
+		opts := append([]datasource.FieldOption{datasource.WithKind(f.kind), datasource.WithAnnotations(f.annotations)}, f.opts...)
+		acc, err := o.ds.AddField(f.name, opts...)
+		if err != nil {
+			return err
+		}
+		*f.acc = acc
+	}
+	return nil
+}
+
+func (o *auditOperatorInstance) Name() string {
+	return OperatorName
+}
+
+func (o *auditOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	// The records can't be filtered in the kernel; the map of the mount namespaces of the selected containers is
+	// used if it's available
+	if filter, ok := gadgetCtx.GetVar(gadgets.FilterByMntNsName); ok && filter == true {
+		if m, ok := gadgetCtx.GetVar(gadgets.MntNsFilterMapName); ok {
+			o.mntnsFilter, _ = m.(*ebpf.Map)
+		}
+	}
+
+	var realtime, boot unix.Timespec
+	if unix.ClockGettime(unix.CLOCK_REALTIME, &realtime) == nil && unix.ClockGettime(unix.CLOCK_BOOTTIME, &boot) == nil {
+		o.bootOffset = realtime.Nano() - boot.Nano()
+	}
+
+	// Subscribing first makes sure the records of the rules are seen
+	f, err := openRecords()
+	if err != nil {
+		return err
+	}
+	if err := o.addRules(); err != nil {
+		f.Close()
+		return err
+	}
+	o.file = f
+
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		if err := o.read(f); err != nil {
+			o.logger.Errorf("reading audit records: %v", err)
+		}
+	}()
+	return nil
+}
+
+// addRules adds the rules to the kernel; rules that already exist are kept when stopping
+func (o *auditOperatorInstance) addRules() error {
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if enabled, err := c.enabled(); err != nil {
+		o.logger.Warnf("%v", err)
+	} else if !enabled {
+		o.logger.Warnf("the audit subsystem is disabled, so no records will be emitted; enable it with \"auditctl -e 1\"")
+	}
+
+	for _, r := range o.rules {
+		err := c.addRule(r)
+		if errors.Is(err, syscall.EEXIST) {
+			o.logger.Debugf("audit rule %q already exists", r.text)
+			continue
+		}
+		if err != nil {
+			o.deleteRules(c)
+			return fmt.Errorf("adding audit rule %q: %w", r.text, err)
+		}
+		o.added = append(o.added, r)
+	}
+	return nil
+}
+
+func (o *auditOperatorInstance) deleteRules(c *client) {
+	for _, r := range o.added {
+		if err := c.deleteRule(r); err != nil {
+			o.logger.Warnf("removing audit rule %q: %v", r.text, err)
+		}
+	}
+	o.added = nil
+}
+
+// read emits the records read from r until it's closed
+func (o *auditOperatorInstance) read(r io.Reader) error {
+	buf := make([]byte, maxRecordSize)
+	for {
+		n, err := r.Read(buf)
+		switch {
+		case errors.Is(err, syscall.ENOBUFS):
+			// The socket overflowed; reading continues with the next records
+			o.logger.Warnf("audit records were lost")
+			continue
+		case errors.Is(err, io.EOF), errors.Is(err, os.ErrClosed):
+			return nil
+		case err != nil:
+			return err
+		}
+		typ, payload, err := splitRecord(buf[:n])
+		if err != nil {
+			o.logger.Debugf("%v", err)
+			continue
+		}
+		if typ == unix.AUDIT_EOE {
+			// Only marks the end of an event
+			continue
+		}
+		rec, err := parseRecord(typ, payload)
+		if err != nil {
+			o.logger.Debugf("%v", err)
+			continue
+		}
+		if err := o.emit(rec); err != nil {
+			o.logger.Debugf("emitting audit record: %v", err)
+		}
+	}
+}
+
+// processOf returns the process of a record, which is the one of the SYSCALL record for the other records of the
+// same event
+func (o *auditOperatorInstance) processOf(rec *record) process {
+	pid, ok := parseUint32(rec.fields["pid"])
+	if !ok {
+		if o.last.serial == rec.serial {
+			return o.last
+		}
+		return process{serial: rec.serial}
+	}
+	p := process{
+		serial: rec.serial,
+		pid:    pid,
+		comm:   rec.fields["comm"],
+		exe:    rec.fields["exe"],
+		key:    rec.fields["key"],
+	}
+	p.uid, _ = parseUint32(rec.fields["uid"])
+	p.auid, _ = parseUint32(rec.fields["auid"])
+	p.mntns, _ = containerutils.GetMntNs(int(pid))
+	o.last = p
+	return p
+}
+
+func parseUint32(s string) (uint32, bool) {
+	v, err := strconv.ParseUint(s, 10, 32)
+	return uint32(v), err == nil
+}
+
+func (o *auditOperatorInstance) emit(rec *record) error {
+	p := o.processOf(rec)
+	if o.mntnsFilter != nil {
+		var v uint32
+		if o.mntnsFilter.Lookup(p.mntns, &v) != nil {
+			return nil
+		}
+	}
+
+	syscallName := rec.fields["syscall"]
+	if nr, err := strconv.Atoi(syscallName); err == nil {
+		if name, ok := syscalls.GetSyscallNameByNumber(nr); ok {
+			syscallName = name
+		}
+	}
+	key := rec.fields["key"]
+	if key == "" {
+		key = p.key
+	}
+
+	bo := o.ds.ByteOrder()
+	u32 := func(v uint32) []byte {
+		b := make([]byte, 4)
+		bo.PutUint32(b, v)
+		return b
+	}
+	u64 := func(v uint64) []byte {
+		b := make([]byte, 8)
+		bo.PutUint64(b, v)
+		return b
+	}
+	success := []byte{0}
+	if rec.fields["success"] == "yes" {
+		success[0] = 1
+	}
+
+	data := o.ds.NewData()
+	for _, f := range []struct {
+		acc   datasource.FieldAccessor
+		value []byte
+	}{
+		{o.timestamp, u64(uint64(max(rec.timestamp.UnixNano()-o.bootOffset, 0)))},
+		{o.serial, u64(rec.serial)},
+		{o.typ, []byte(recordTypeName(rec.typ))},
+		{o.pid, u32(p.pid)},
+		{o.uid, u32(p.uid)},
+		{o.auid, u32(p.auid)},
+		{o.comm, []byte(p.comm)},
+		{o.exe, []byte(p.exe)},
+		{o.syscall, []byte(syscallName)},
+		{o.success, success},
+		{o.key, []byte(key)},
+		{o.mntns, u64(p.mntns)},
+		{o.message, []byte(rec.text)},
+	} {
+		if err := f.acc.Set(data, f.value); err != nil {
+			o.ds.Release(data)
+			return fmt.Errorf("setting field %q: %w", f.acc.Name(), err)
+		}
+	}
+	return o.ds.EmitAndRelease(data)
+}
+
+func (o *auditOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	if o.file == nil {
+		return nil
+	}
+	if len(o.added) > 0 {
+		c, err := newClient()
+		if err != nil {
+			o.logger.Warnf("removing audit rules: %v", err)
+		} else {
+			o.deleteRules(c)
+			c.close()
+		}
+	}
+	o.file.Close()
+	o.wg.Wait()
+	o.file = nil
+	return nil
+}
+
+func init() {
+	operators.RegisterOperatorImages(IsAuditImage)
+	operators.RegisterDataOperator(&auditOperator{})
+}
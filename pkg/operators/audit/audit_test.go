@@ -0,0 +1,167 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/syscalls"
+)
+
+func TestParseRule(t *testing.T) {
+	r, err := parseRule("-w /etc/passwd/ -p wa -k passwd")
+	require.NoError(t, err)
+	require.Equal(t, uint32(unix.AUDIT_FILTER_EXIT), r.list)
+	require.Equal(t, uint32(unix.AUDIT_ALWAYS), r.action)
+	require.Equal(t, []uint32{unix.AUDIT_WATCH, unix.AUDIT_FILTERKEY, unix.AUDIT_PERM}, r.fields)
+	require.Equal(t, []uint32{uint32(len("/etc/passwd")), uint32(len("passwd")), unix.AUDIT_PERM_WRITE | unix.AUDIT_PERM_ATTR}, r.values)
+	require.Equal(t, "/etc/passwdpasswd", string(r.buf))
+	for _, m := range r.mask {
+		require.Equal(t, uint32(math.MaxUint32), m)
+	}
+
+	r, err = parseRule("-a exit,always -F arch=b64 -S execve,openat -F uid!=0 -F auid>=1000 -k exec")
+	require.NoError(t, err)
+	arch, err := hostArch()
+	require.NoError(t, err)
+	require.Equal(t, []uint32{unix.AUDIT_ARCH, unix.AUDIT_UID, unix.AUDIT_LOGINUID, unix.AUDIT_FILTERKEY}, r.fields)
+	require.Equal(t, []uint32{arch, 0, 1000, 4}, r.values)
+	require.Equal(t, []uint32{unix.AUDIT_EQUAL, unix.AUDIT_NOT_EQUAL, unix.AUDIT_GREATER_THAN_OR_EQUAL, unix.AUDIT_EQUAL}, r.fieldflags)
+	execve, _ := syscalls.GetSyscallNumberByName("execve")
+	require.NotZero(t, r.mask[execve/32]&(1<<(execve%32)))
+
+	// The rule is encoded as struct audit_rule_data
+	b := r.marshal()
+	require.Len(t, b, 4*(4+unix.AUDIT_BITMASK_SIZE+3*unix.AUDIT_MAX_FIELDS)+len("exec"))
+	require.Equal(t, uint32(4), binary.NativeEndian.Uint32(b[8:]))
+	require.Equal(t, uint32(unix.AUDIT_ARCH), binary.NativeEndian.Uint32(b[4*(3+unix.AUDIT_BITMASK_SIZE):]))
+	require.Equal(t, "exec", string(b[len(b)-4:]))
+
+	invalid := []string{
+		"",
+		"-w /etc/passwd -p z",
+		"-w /etc/passwd -a always,exit",
+		"-a always,task -S execve",
+		"-a sometimes,exit -S execve",
+		"-a always,exit -S nosuchsyscall",
+		"-a always,exit -F unknown=1",
+		"-a always,exit -F uid~1",
+		"-a always,exit -F exe>/bin/sh",
+		"-a always,exit -S execve -p w",
+		"-a always,exit -k",
+		"-D",
+		"-k " + strings.Repeat("k", unix.AUDIT_MAX_KEY_LEN+1) + " -w /etc",
+	}
+	for _, text := range invalid {
+		_, err := parseRule(text)
+		require.Error(t, err, text)
+	}
+
+	// Directories are watched with their contents
+	r, err = parseRule("-w " + t.TempDir())
+	require.NoError(t, err)
+	require.Equal(t, uint32(unix.AUDIT_DIR), r.fields[0])
+
+	rules, err := parseRules("# watch the users\n-w /etc/passwd -p wa\n\n-a always,exit -S execve; -w /etc/shadow\n")
+	require.NoError(t, err)
+	require.Len(t, rules, 3)
+
+	// Errors tell the line of the invalid rule, but not its text
+	_, err = parseRules("-w /etc/passwd\n-w /etc/shadow -p secret")
+	require.ErrorContains(t, err, "line 2")
+	require.NotContains(t, err.Error(), "/etc/shadow")
+}
+
+func TestParseRecord(t *testing.T) {
+	r, err := parseRecord(unix.AUDIT_SYSCALL, []byte(`audit(1712345678.123:456): arch=c000003e syscall=59 success=yes exit=0 `+
+		`pid=1234 auid=1000 uid=0 comm="cat" exe=2F7573722F62696E2F6D7920636174 key=(null)`+"\x00"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(456), r.serial)
+	require.Equal(t, time.Unix(1712345678, 123000000), r.timestamp)
+	require.Equal(t, "cat", r.fields["comm"])
+	require.Equal(t, "/usr/bin/my cat", r.fields["exe"])
+	require.Equal(t, "", r.fields["key"])
+	require.Equal(t, "1234", r.fields["pid"])
+
+	r, err = parseRecord(unix.AUDIT_EXECVE, []byte(`audit(1712345678.123:456): argc=3 a0="ls" a1=2D6C2061 a2="/tmp"`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"argc": "3", "a0": "ls", "a1": "-l a", "a2": "/tmp"}, r.fields)
+
+	r, err = parseRecord(1105, []byte(`audit(1712345678.123:457): pid=1 uid=0 msg='op=PAM:session_open acct="root" res=success'`))
+	require.NoError(t, err)
+	require.Equal(t, `op=PAM:session_open acct="root" res=success`, r.fields["msg"])
+	require.Equal(t, "USER_START", recordTypeName(r.typ))
+
+	_, err = parseRecord(unix.AUDIT_SYSCALL, []byte("not a record"))
+	require.Error(t, err)
+}
+
+func TestAuditOperator(t *testing.T) {
+	op := &auditOperator{}
+
+	// Other images aren't handled
+	inst, err := op.InstantiateDataOperator(gadgetcontext.New(context.Background(), "trace_exec"), api.ParamValues{})
+	require.NoError(t, err)
+	require.Nil(t, inst)
+
+	_, err = op.InstantiateDataOperator(gadgetcontext.New(context.Background(), ImageName), api.ParamValues{ParamRules: "-w"})
+	require.Error(t, err)
+
+	gadgetCtx := gadgetcontext.New(context.Background(), ImageName)
+	inst, err = op.InstantiateDataOperator(gadgetCtx, api.ParamValues{ParamRules: "-w /etc/passwd -p wa -k passwd; -a always,exit -S execve"})
+	require.NoError(t, err)
+	auditInst := inst.(*auditOperatorInstance)
+	require.Len(t, auditInst.rules, 2)
+
+	ds := gadgetCtx.GetDataSources()[DataSourceName]
+	require.NotNil(t, ds)
+	require.Len(t, ds.GetFieldsWithTag("type:gadget_timestamp"), 1)
+	typ := ds.GetField("type")
+	pid := ds.GetField("pid")
+	comm := ds.GetField("comm")
+	sc := ds.GetField("syscall")
+	key := ds.GetField("key")
+	var events []string
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		events = append(events, strings.Join([]string{typ.String(data), comm.String(data), sc.String(data), key.String(data)}, " "))
+		require.Equal(t, uint32(4294967), pid.Uint32(data))
+		return nil
+	}, 0)
+
+	// Records of the same event get the process of the SYSCALL record
+	for _, rec := range []struct {
+		typ  uint16
+		text string
+	}{
+		{unix.AUDIT_SYSCALL, `audit(1712345678.123:456): arch=c000003e syscall=59 success=yes pid=4294967 uid=0 comm="cat" key="exec"`},
+		{unix.AUDIT_PATH, `audit(1712345678.123:456): item=0 name="/usr/bin/cat"`},
+	} {
+		r, err := parseRecord(rec.typ, []byte(rec.text))
+		require.NoError(t, err)
+		require.NoError(t, auditInst.emit(r))
+	}
+	require.Equal(t, []string{"SYSCALL cat execve exec", "PATH cat  exec"}, events)
+}
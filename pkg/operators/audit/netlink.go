@@ -0,0 +1,305 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// requestTimeout limits how long to wait for the kernel to answer a request
+	requestTimeout = 5 * time.Second
+
+	// offset of the enabled flag in struct audit_status
+	statusEnabledOffset = 4
+)
+
+// recordTypes are the names of the types of audit records, like ausearch shows them
+var recordTypes = map[uint16]string{
+	unix.AUDIT_SYSCALL:          "SYSCALL",
+	unix.AUDIT_PATH:             "PATH",
+	unix.AUDIT_IPC:              "IPC",
+	unix.AUDIT_SOCKETCALL:       "SOCKETCALL",
+	unix.AUDIT_CONFIG_CHANGE:    "CONFIG_CHANGE",
+	unix.AUDIT_SOCKADDR:         "SOCKADDR",
+	unix.AUDIT_CWD:              "CWD",
+	unix.AUDIT_EXECVE:           "EXECVE",
+	unix.AUDIT_EOE:              "EOE",
+	unix.AUDIT_BPRM_FCAPS:       "BPRM_FCAPS",
+	unix.AUDIT_CAPSET:           "CAPSET",
+	unix.AUDIT_MMAP:             "MMAP",
+	unix.AUDIT_NETFILTER_PKT:    "NETFILTER_PKT",
+	unix.AUDIT_NETFILTER_CFG:    "NETFILTER_CFG",
+	unix.AUDIT_SECCOMP:          "SECCOMP",
+	unix.AUDIT_PROCTITLE:        "PROCTITLE",
+	unix.AUDIT_FEATURE_CHANGE:   "FEATURE_CHANGE",
+	unix.AUDIT_KERN_MODULE:      "KERN_MODULE",
+	unix.AUDIT_BPF:              "BPF",
+	unix.AUDIT_AVC:              "AVC",
+	unix.AUDIT_ANOM_PROMISCUOUS: "ANOM_PROMISCUOUS",
+	unix.AUDIT_ANOM_ABEND:       "ANOM_ABEND",
+	unix.AUDIT_ANOM_LINK:        "ANOM_LINK",
+	unix.AUDIT_USER_AVC:         "USER_AVC",
+	unix.AUDIT_LOGIN:            "LOGIN",
+	1100:                        "USER_AUTH",
+	1101:                        "USER_ACCT",
+	1103:                        "CRED_ACQ",
+	1104:                        "CRED_DISP",
+	1105:                        "USER_START",
+	1106:                        "USER_END",
+	1112:                        "USER_LOGIN",
+	1123:                        "USER_CMD",
+}
+
+func recordTypeName(t uint16) string {
+	if name, ok := recordTypes[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN[%d]", t)
+}
+
+// record is an audit record, like one line of the audit log
+type record struct {
+	typ       uint16
+	timestamp time.Time
+	serial    uint64
+
+	// text is the message of the record without the header, e.g. "arch=c000003e syscall=59 ..."
+	text string
+
+	// fields are the values of the record by their name; encoded values are decoded
+	fields map[string]string
+}
+
+var recordHeader = regexp.MustCompile(`^audit\((\d+)\.(\d+):(\d+)\): ?`)
+
+// parseRecord parses the payload of a netlink message of the audit subsystem
+func parseRecord(typ uint16, payload []byte) (*record, error) {
+	text := string(bytes.TrimRight(payload, "\x00\n"))
+	m := recordHeader.FindStringSubmatch(text)
+	if m == nil {
+		return nil, fmt.Errorf("invalid audit record %q", text)
+	}
+	sec, _ := strconv.ParseInt(m[1], 10, 64)
+	msec, _ := strconv.ParseInt(m[2], 10, 64)
+	serial, _ := strconv.ParseUint(m[3], 10, 64)
+	r := &record{
+		typ:       typ,
+		timestamp: time.Unix(sec, msec*int64(time.Millisecond)),
+		serial:    serial,
+		text:      text[len(m[0]):],
+	}
+	r.fields = parseFields(r.text, typ == unix.AUDIT_EXECVE)
+	return r, nil
+}
+
+// encodedFields are the fields holding strings that the kernel encodes in hex if they contain spaces, quotes or
+// control characters
+var encodedFields = map[string]struct{}{
+	"comm": {}, "exe": {}, "name": {}, "cwd": {}, "proctitle": {}, "key": {}, "path": {},
+}
+
+// parseFields parses the key=value pairs of a record. execve tells that the arguments of an EXECVE record, which are
+// encoded like the strings of encodedFields, are parsed.
+func parseFields(text string, execve bool) map[string]string {
+	fields := make(map[string]string)
+	for text != "" {
+		text = strings.TrimLeft(text, " ")
+		key, rest, ok := strings.Cut(text, "=")
+		if !ok || strings.Contains(key, " ") {
+			// Not a field, e.g. text of a user space message
+			i := strings.IndexByte(text, ' ')
+			if i < 0 {
+				break
+			}
+			text = text[i:]
+			continue
+		}
+
+		var value string
+		quoted := false
+		switch {
+		case strings.HasPrefix(rest, `"`), strings.HasPrefix(rest, `'`):
+			end := strings.IndexByte(rest[1:], rest[0])
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			value, text, quoted = rest[1:end+1], rest[min(end+2, len(rest)):], true
+		default:
+			end := strings.IndexByte(rest, ' ')
+			if end < 0 {
+				end = len(rest)
+			}
+			value, text = rest[:end], rest[end:]
+		}
+
+		_, encoded := encodedFields[key]
+		if execve && len(key) > 1 && key[0] == 'a' {
+			_, err := strconv.Atoi(key[1:])
+			encoded = err == nil
+		}
+		if !quoted && encoded {
+			value = decodeValue(value)
+		}
+		fields[key] = value
+	}
+	return fields
+}
+
+// decodeValue decodes a string encoded in hex by the kernel
+func decodeValue(value string) string {
+	if value == "(null)" {
+		return ""
+	}
+	b, err := hex.DecodeString(value)
+	if err != nil {
+		return value
+	}
+	// The arguments of proctitle are separated by null bytes
+	return strings.ReplaceAll(string(bytes.TrimRight(b, "\x00")), "\x00", " ")
+}
+
+// openRecords opens a socket receiving the records of the audit subsystem. It uses the read-only multicast group,
+// so that it works next to auditd, and requires CAP_AUDIT_READ. The socket is non-blocking, so that reading is
+// interrupted when it's closed.
+func openRecords() (*os.File, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, unix.NETLINK_AUDIT)
+	if err != nil {
+		return nil, fmt.Errorf("creating audit socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: 1 << (unix.AUDIT_NLGRP_READLOG - 1)}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("subscribing to audit records: %w", err)
+	}
+	return os.NewFile(uintptr(fd), "audit"), nil
+}
+
+// splitRecord returns the type and payload of a message received from the audit socket. The kernel doesn't include
+// the header in the length of the messages, so they can't be parsed with syscall.ParseNetlinkMessage; there's one
+// message per datagram.
+func splitRecord(buf []byte) (uint16, []byte, error) {
+	if len(buf) < unix.NLMSG_HDRLEN {
+		return 0, nil, fmt.Errorf("audit message too short: %d bytes", len(buf))
+	}
+	return binary.NativeEndian.Uint16(buf[4:6]), buf[unix.NLMSG_HDRLEN:], nil
+}
+
+// client sends requests to the audit subsystem; it requires CAP_AUDIT_CONTROL
+type client struct {
+	fd  int
+	seq uint32
+}
+
+func newClient() (*client, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_AUDIT)
+	if err != nil {
+		return nil, fmt.Errorf("creating audit socket: %w", err)
+	}
+	tv := unix.NsecToTimeval(requestTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("setting timeout of audit socket: %w", err)
+	}
+	return &client{fd: fd}, nil
+}
+
+func (c *client) close() {
+	unix.Close(c.fd)
+}
+
+// request sends a message and waits for the kernel to acknowledge it; the payload of a reply of the same type is
+// returned
+func (c *client) request(typ uint16, payload []byte) ([]byte, error) {
+	c.seq++
+	msg := make([]byte, unix.NLMSG_HDRLEN, unix.NLMSG_HDRLEN+len(payload))
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(unix.NLMSG_HDRLEN+len(payload)))
+	binary.NativeEndian.PutUint16(msg[4:6], typ)
+	binary.NativeEndian.PutUint16(msg[6:8], unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(msg[8:12], c.seq)
+	msg = append(msg, payload...)
+	if err := unix.Sendto(c.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	var reply []byte
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := unix.Recvfrom(c.fd, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("parsing audit reply: %w", err)
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != c.seq {
+				continue
+			}
+			switch m.Header.Type {
+			case unix.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return nil, errors.New("invalid audit reply")
+				}
+				if errno := -int32(binary.NativeEndian.Uint32(m.Data[:4])); errno != 0 {
+					return nil, syscall.Errno(errno)
+				}
+				// Replies come before the acknowledgement, except for requests without reply
+				if typ != unix.AUDIT_GET || reply != nil {
+					return reply, nil
+				}
+			case typ:
+				reply = m.Data
+				if typ == unix.AUDIT_GET {
+					return reply, nil
+				}
+			}
+		}
+	}
+}
+
+// enabled tells whether the audit subsystem is enabled
+func (c *client) enabled() (bool, error) {
+	status, err := c.request(unix.AUDIT_GET, nil)
+	if err != nil {
+		return false, fmt.Errorf("getting audit status: %w", err)
+	}
+	if len(status) < statusEnabledOffset+4 {
+		return false, errors.New("invalid audit status")
+	}
+	return binary.NativeEndian.Uint32(status[statusEnabledOffset:]) != 0, nil
+}
+
+func (c *client) addRule(r *rule) error {
+	_, err := c.request(unix.AUDIT_ADD_RULE, r.marshal())
+	return err
+}
+
+func (c *client) deleteRule(r *rule) error {
+	_, err := c.request(unix.AUDIT_DEL_RULE, r.marshal())
+	return err
+}
@@ -0,0 +1,329 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/syscalls"
+)
+
+// rule is an audit rule in the format of the kernel (struct audit_rule_data)
+type rule struct {
+	// text is the rule as given by the user
+	text string
+
+	list       uint32
+	action     uint32
+	mask       [unix.AUDIT_BITMASK_SIZE]uint32
+	fields     []uint32
+	values     []uint32
+	fieldflags []uint32
+	buf        []byte
+}
+
+// operators of rule fields, by how they're written in rules
+var ruleOperators = map[string]uint32{
+	"=":  unix.AUDIT_EQUAL,
+	"!=": unix.AUDIT_NOT_EQUAL,
+	"<":  unix.AUDIT_LESS_THAN,
+	">":  unix.AUDIT_GREATER_THAN,
+	"<=": unix.AUDIT_LESS_THAN_OR_EQUAL,
+	">=": unix.AUDIT_GREATER_THAN_OR_EQUAL,
+}
+
+// numericFields are the fields of rules with numeric values
+var numericFields = map[string]uint32{
+	"pid":      unix.AUDIT_PID,
+	"ppid":     unix.AUDIT_PPID,
+	"uid":      unix.AUDIT_UID,
+	"euid":     unix.AUDIT_EUID,
+	"auid":     unix.AUDIT_LOGINUID,
+	"loginuid": unix.AUDIT_LOGINUID,
+	"gid":      unix.AUDIT_GID,
+	"egid":     unix.AUDIT_EGID,
+	"success":  unix.AUDIT_SUCCESS,
+	"exit":     unix.AUDIT_EXIT,
+	"a0":       unix.AUDIT_ARG0,
+	"a1":       unix.AUDIT_ARG1,
+	"a2":       unix.AUDIT_ARG2,
+	"a3":       unix.AUDIT_ARG3,
+}
+
+// stringFields are the fields of rules with string values
+var stringFields = map[string]uint32{
+	"path": unix.AUDIT_WATCH,
+	"dir":  unix.AUDIT_DIR,
+	"exe":  unix.AUDIT_EXE,
+	"key":  unix.AUDIT_FILTERKEY,
+}
+
+// permissions of watches, by how they're written in rules
+var watchPermissions = map[rune]uint32{
+	'r': unix.AUDIT_PERM_READ,
+	'w': unix.AUDIT_PERM_WRITE,
+	'x': unix.AUDIT_PERM_EXEC,
+	'a': unix.AUDIT_PERM_ATTR,
+}
+
+// hostArch returns the audit architecture of the host
+func hostArch() (uint32, error) {
+	switch runtime.GOARCH {
+	case "amd64":
+		return unix.AUDIT_ARCH_X86_64, nil
+	case "arm64":
+		return unix.AUDIT_ARCH_AARCH64, nil
+	}
+	return 0, fmt.Errorf("audit rules aren't supported on %s", runtime.GOARCH)
+}
+
+// parseRules parses rules in the format of auditctl, separated by ';' or newlines; empty rules and lines with comments
+// starting with '#' are ignored. Errors only tell the line of the invalid rule, not its text.
+func parseRules(text string) ([]*rule, error) {
+	var rules []*rule
+	for i, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, ruleText := range strings.Split(line, ";") {
+			if ruleText = strings.TrimSpace(ruleText); ruleText == "" {
+				continue
+			}
+			rule, err := parseRule(ruleText)
+			if err != nil {
+				return nil, fmt.Errorf("audit rule in line %d: %w", i+1, err)
+			}
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// parseRule parses a rule in the format of auditctl. Supported are file watches like "-w /etc/passwd -p wa -k
+// passwd" and syscall rules of the exit list like "-a always,exit -S execve -F uid=1000 -k exec". Syscall rules
+// apply to the architecture of the host.
+func parseRule(text string) (*rule, error) {
+	r := &rule{text: text}
+	args := strings.Fields(text)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty rule")
+	}
+	arg := func(i int) (string, error) {
+		if i+1 >= len(args) {
+			return "", fmt.Errorf("missing value of %s", args[i])
+		}
+		return args[i+1], nil
+	}
+
+	var watch, syscallRule, allSyscalls bool
+	var perm uint32
+	for i := 0; i < len(args); i += 2 {
+		value, err := arg(i)
+		if err != nil {
+			return nil, err
+		}
+		switch args[i] {
+		case "-w":
+			watch = true
+			// Like auditctl, the contents of directories are watched
+			field := uint32(unix.AUDIT_WATCH)
+			if fi, err := os.Stat(filepath.Join(host.HostRoot, value)); err == nil && fi.IsDir() {
+				field = unix.AUDIT_DIR
+			}
+			r.addString(field, unix.AUDIT_EQUAL, strings.TrimSuffix(value, "/"))
+		case "-p":
+			for _, c := range value {
+				p, ok := watchPermissions[c]
+				if !ok {
+					return nil, fmt.Errorf("invalid permission %q, expected r, w, x or a", c)
+				}
+				perm |= p
+			}
+		case "-a":
+			syscallRule = true
+			if err := r.parseListAction(value); err != nil {
+				return nil, err
+			}
+		case "-S":
+			for _, name := range strings.Split(value, ",") {
+				if name == "all" {
+					allSyscalls = true
+					continue
+				}
+				nr, ok := syscalls.GetSyscallNumberByName(name)
+				if !ok {
+					if nr, err = strconv.Atoi(name); err != nil {
+						return nil, fmt.Errorf("unknown syscall %q", name)
+					}
+				}
+				if nr < 0 || nr >= unix.AUDIT_BITMASK_SIZE*32 {
+					return nil, fmt.Errorf("invalid syscall %q", name)
+				}
+				r.mask[nr/32] |= 1 << (nr % 32)
+			}
+		case "-F":
+			if err := r.parseField(value); err != nil {
+				return nil, err
+			}
+		case "-k":
+			if len(value) > unix.AUDIT_MAX_KEY_LEN {
+				return nil, fmt.Errorf("key longer than %d characters", unix.AUDIT_MAX_KEY_LEN)
+			}
+			r.addString(unix.AUDIT_FILTERKEY, unix.AUDIT_EQUAL, value)
+		default:
+			return nil, fmt.Errorf("unsupported option %s", args[i])
+		}
+	}
+
+	switch {
+	case watch == syscallRule:
+		return nil, fmt.Errorf("expected either a watch (-w) or a syscall rule (-a)")
+	case watch:
+		// Watches apply to all syscalls of the exit list
+		r.list = unix.AUDIT_FILTER_EXIT
+		r.action = unix.AUDIT_ALWAYS
+		allSyscalls = true
+		if perm == 0 {
+			perm = unix.AUDIT_PERM_READ | unix.AUDIT_PERM_WRITE | unix.AUDIT_PERM_EXEC | unix.AUDIT_PERM_ATTR
+		}
+		r.add(unix.AUDIT_PERM, unix.AUDIT_EQUAL, perm)
+	case perm != 0:
+		return nil, fmt.Errorf("permissions (-p) require a watch (-w)")
+	default:
+		arch, err := hostArch()
+		if err != nil {
+			return nil, err
+		}
+		// Like auditctl, the architecture comes first, so that the syscall numbers are interpreted correctly
+		r.fields = append([]uint32{unix.AUDIT_ARCH}, r.fields...)
+		r.values = append([]uint32{arch}, r.values...)
+		r.fieldflags = append([]uint32{unix.AUDIT_EQUAL}, r.fieldflags...)
+	}
+	if allSyscalls {
+		for i := range r.mask {
+			r.mask[i] = math.MaxUint32
+		}
+	}
+	if len(r.fields) > unix.AUDIT_MAX_FIELDS {
+		return nil, fmt.Errorf("more than %d fields", unix.AUDIT_MAX_FIELDS)
+	}
+	return r, nil
+}
+
+func (r *rule) parseListAction(value string) error {
+	list, action, ok := strings.Cut(value, ",")
+	if !ok {
+		return fmt.Errorf("invalid list and action %q, expected e.g. always,exit", value)
+	}
+	if list == "always" || list == "never" {
+		list, action = action, list
+	}
+	if list != "exit" {
+		return fmt.Errorf("unsupported list %q, only exit is supported", list)
+	}
+	r.list = unix.AUDIT_FILTER_EXIT
+	switch action {
+	case "always":
+		r.action = unix.AUDIT_ALWAYS
+	case "never":
+		r.action = unix.AUDIT_NEVER
+	default:
+		return fmt.Errorf("invalid action %q, expected always or never", action)
+	}
+	return nil
+}
+
+func (r *rule) parseField(value string) error {
+	// Operators with two characters need to be tried first
+	var name, op, v string
+	for _, o := range []string{"!=", "<=", ">=", "=", "<", ">"} {
+		if i := strings.Index(value, o); i > 0 {
+			name, op, v = value[:i], o, value[i+len(o):]
+			break
+		}
+	}
+	if op == "" {
+		return fmt.Errorf("invalid field %q, expected e.g. uid=1000", value)
+	}
+	if name == "arch" {
+		// Rules always apply to the architecture of the host
+		return nil
+	}
+	if field, ok := stringFields[name]; ok {
+		if op != "=" && op != "!=" {
+			return fmt.Errorf("field %q only supports = and !=", name)
+		}
+		r.addString(field, ruleOperators[op], v)
+		return nil
+	}
+	field, ok := numericFields[name]
+	if !ok {
+		return fmt.Errorf("unsupported field %q", name)
+	}
+	n, err := strconv.ParseInt(v, 0, 64)
+	if err != nil || n < math.MinInt32 || n > math.MaxUint32 {
+		return fmt.Errorf("invalid value %q of field %q", v, name)
+	}
+	r.add(field, ruleOperators[op], uint32(n))
+	return nil
+}
+
+func (r *rule) add(field, op, value uint32) {
+	r.fields = append(r.fields, field)
+	r.values = append(r.values, value)
+	r.fieldflags = append(r.fieldflags, op)
+}
+
+// addString adds a field with a string value, whose length is the value of the field
+func (r *rule) addString(field, op uint32, value string) {
+	r.add(field, op, uint32(len(value)))
+	r.buf = append(r.buf, value...)
+}
+
+// marshal encodes the rule as struct audit_rule_data
+func (r *rule) marshal() []byte {
+	const maxFields = unix.AUDIT_MAX_FIELDS
+	b := make([]byte, 0, 4*(4+len(r.mask)+3*maxFields)+len(r.buf))
+	put := func(v uint32) {
+		b = binary.NativeEndian.AppendUint32(b, v)
+	}
+	put(r.list)
+	put(r.action)
+	put(uint32(len(r.fields)))
+	for _, m := range r.mask {
+		put(m)
+	}
+	for _, values := range [][]uint32{r.fields, r.values, r.fieldflags} {
+		for i := 0; i < maxFields; i++ {
+			if i < len(values) {
+				put(values[i])
+			} else {
+				put(0)
+			}
+		}
+	}
+	put(uint32(len(r.buf)))
+	return append(b, r.buf...)
+}
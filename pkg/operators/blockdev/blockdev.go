@@ -0,0 +1,165 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blockdev provides an operator that enriches events containing the major and minor numbers of a block
+// device with its device-mapper or kernel name, the mount point on the host and, on Kubernetes nodes, the
+// PersistentVolume mounted from it and the PersistentVolumeClaim bound to that, so that "dm-3" becomes the volume of
+// a workload.
+package blockdev
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/k8sutil"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+)
+
+const (
+	OperatorName = "BlockDevResolver"
+
+	sysPath    = "/sys"
+	devicesTTL = 10 * time.Second
+	apiTimeout = 2 * time.Second
+)
+
+type BlockDevResolverInterface interface {
+	GetDevice() (major uint32, minor uint32)
+	SetBlockDevice(name, mountPoint, pv, pvc string)
+}
+
+type BlockDevResolver struct {
+	once     sync.Once
+	resolver *resolver
+}
+
+func (k *BlockDevResolver) Name() string {
+	return OperatorName
+}
+
+func (k *BlockDevResolver) Description() string {
+	return "BlockDevResolver resolves block devices to their names, mount points and Kubernetes volumes"
+}
+
+func (k *BlockDevResolver) GlobalParamDescs() params.ParamDescs {
+	return nil
+}
+
+func (k *BlockDevResolver) ParamDescs() params.ParamDescs {
+	return nil
+}
+
+func (k *BlockDevResolver) Dependencies() []string {
+	return nil
+}
+
+func (k *BlockDevResolver) CanOperateOn(gadget gadgets.GadgetDesc) bool {
+	_, hasBlockDevResolverInterface := gadget.EventPrototype().(BlockDevResolverInterface)
+	return hasBlockDevResolverInterface
+}
+
+func (k *BlockDevResolver) Init(params *params.Params) error {
+	return nil
+}
+
+func (k *BlockDevResolver) Close() error {
+	return nil
+}
+
+// kubernetesClaims looks up the claims of PersistentVolumes using the API server; the client is only created once a
+// PersistentVolume is found, i.e. when running on a Kubernetes node
+func kubernetesClaims() claimFunc {
+	var once sync.Once
+	var clientset *kubernetes.Clientset
+	var clientErr error
+	return func(pv string) (string, error) {
+		once.Do(func() {
+			clientset, clientErr = k8sutil.NewClientset("")
+		})
+		if clientErr != nil {
+			return "", clientErr
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+		defer cancel()
+		vol, err := clientset.CoreV1().PersistentVolumes().Get(ctx, pv, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		if ref := vol.Spec.ClaimRef; ref != nil {
+			return ref.Namespace + "/" + ref.Name, nil
+		}
+		return "", nil
+	}
+}
+
+func (k *BlockDevResolver) Instantiate(gadgetCtx operators.GadgetContext, gadgetInstance any, params *params.Params) (operators.OperatorInstance, error) {
+	// The resolver is shared between all gadget instances; host.HostProcFs is only final once the gadget runs
+	k.once.Do(func() {
+		k.resolver = newResolver(sysPath, filepath.Join(host.HostProcFs, "1", "mountinfo"), kubernetesClaims(), devicesTTL)
+	})
+
+	return &BlockDevResolverInstance{
+		gadgetCtx:      gadgetCtx,
+		gadgetInstance: gadgetInstance,
+		resolver:       k.resolver,
+	}, nil
+}
+
+type BlockDevResolverInstance struct {
+	gadgetCtx      operators.GadgetContext
+	gadgetInstance any
+	resolver       *resolver
+}
+
+func (m *BlockDevResolverInstance) Name() string {
+	return "BlockDevResolverInstance"
+}
+
+func (m *BlockDevResolverInstance) PreGadgetRun() error {
+	return nil
+}
+
+func (m *BlockDevResolverInstance) PostGadgetRun() error {
+	return nil
+}
+
+func (m *BlockDevResolverInstance) enrich(ev any) {
+	resolver, ok := ev.(BlockDevResolverInterface)
+	if !ok {
+		return
+	}
+	d, err := m.resolver.Get(resolver.GetDevice())
+	if err != nil {
+		m.gadgetCtx.Logger().Debugf("blockdev: resolving device: %v", err)
+		return
+	}
+	resolver.SetBlockDevice(d.name, d.mountPoint, d.pv, d.pvc)
+}
+
+func (m *BlockDevResolverInstance) EnrichEvent(ev any) error {
+	m.enrich(ev)
+	return nil
+}
+
+func init() {
+	operators.Register(&BlockDevResolver{})
+}
@@ -0,0 +1,266 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blockdev
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// device describes a block device in terms users recognize
+type device struct {
+	// name is the device-mapper name of the device, like "vg0-data", or its kernel name, like "sda1"
+	name string
+	// mountPoint is where the device is mounted on the host; if it's mounted several times, the shortest mount point
+	// of a PersistentVolume is used, if any, otherwise the shortest one
+	mountPoint string
+	// pv is the Kubernetes PersistentVolume mounted from the device, pvc the PersistentVolumeClaim bound to it as
+	// "namespace/name"
+	pv  string
+	pvc string
+
+	loaded time.Time
+}
+
+type devKey struct {
+	major, minor uint32
+}
+
+// kubeletVolume matches the mount points of volumes of pods created by the kubelet; for volumes of
+// PersistentVolumeClaims, the last component is the name of the PersistentVolume
+var kubeletVolume = regexp.MustCompile(`/pods/[^/]+/volumes/([^/]+)/([^/]+)(/mount)?$`)
+
+// kubeletStaging matches the global mount points of CSI volumes used by older versions of the kubelet
+var kubeletStaging = regexp.MustCompile(`/plugins/kubernetes\.io/csi/pv/([^/]+)/globalmount$`)
+
+// podVolumePlugins are the plugins of volumes that aren't backed by a PersistentVolume
+var podVolumePlugins = map[string]struct{}{
+	"kubernetes.io~empty-dir":    {},
+	"kubernetes.io~configmap":    {},
+	"kubernetes.io~secret":       {},
+	"kubernetes.io~projected":    {},
+	"kubernetes.io~downward-api": {},
+	"kubernetes.io~git-repo":     {},
+}
+
+// persistentVolume returns the name of the PersistentVolume mounted at mountPoint by the kubelet, if any
+func persistentVolume(mountPoint string) string {
+	if m := kubeletVolume.FindStringSubmatch(mountPoint); m != nil {
+		if _, ok := podVolumePlugins[m[1]]; ok {
+			return ""
+		}
+		return m[2]
+	}
+	if m := kubeletStaging.FindStringSubmatch(mountPoint); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// unescapeMountPoint replaces the octal escapes like "\040" the kernel uses for spaces and other special characters
+// in mount points
+func unescapeMountPoint(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseMountinfo returns the mount points of block devices from the content of /proc/<pid>/mountinfo
+func parseMountinfo(r io.Reader) (map[devKey][]string, error) {
+	mounts := make(map[devKey][]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		majorStr, minorStr, ok := strings.Cut(fields[2], ":")
+		if !ok {
+			continue
+		}
+		major, err1 := strconv.ParseUint(majorStr, 10, 32)
+		minor, err2 := strconv.ParseUint(minorStr, 10, 32)
+		if err1 != nil || err2 != nil || major == 0 {
+			// Pseudo file systems like proc and tmpfs use major 0
+			continue
+		}
+		key := devKey{uint32(major), uint32(minor)}
+		mounts[key] = append(mounts[key], unescapeMountPoint(fields[4]))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// claimFunc returns the PersistentVolumeClaim bound to the PersistentVolume pv as "namespace/name", or an empty
+// string if it isn't bound
+type claimFunc func(pv string) (string, error)
+
+// resolver resolves block devices by their major and minor numbers; entries are reloaded once they're older than ttl,
+// as devices get mounted and unmounted
+type resolver struct {
+	sysPath       string
+	mountinfoPath string
+	claim         claimFunc
+	ttl           time.Duration
+	now           func() time.Time
+
+	mu      sync.Mutex
+	devices map[devKey]*device
+	// claims caches the claims of PersistentVolumes, which don't change while a volume is mounted
+	claims map[string]string
+}
+
+func newResolver(sysPath, mountinfoPath string, claim claimFunc, ttl time.Duration) *resolver {
+	return &resolver{
+		sysPath:       sysPath,
+		mountinfoPath: mountinfoPath,
+		claim:         claim,
+		ttl:           ttl,
+		now:           time.Now,
+		devices:       make(map[devKey]*device),
+		claims:        make(map[string]string),
+	}
+}
+
+// Get returns the device with the given major and minor numbers
+func (r *resolver) Get(major, minor uint32) (*device, error) {
+	now := r.now()
+	key := devKey{major, minor}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if d, ok := r.devices[key]; ok && now.Sub(d.loaded) < r.ttl {
+		return d, nil
+	}
+
+	// All devices are reloaded at once, as mountinfo has to be read anyway
+	if err := r.load(now); err != nil {
+		return nil, err
+	}
+	d, ok := r.devices[key]
+	if !ok {
+		d = &device{loaded: now}
+		if name, err := r.deviceName(key); err == nil {
+			d.name = name
+		}
+		r.devices[key] = d
+	}
+	return d, nil
+}
+
+// deviceName returns the device-mapper or kernel name of a device from sysfs
+func (r *resolver) deviceName(key devKey) (string, error) {
+	dir := filepath.Join(r.sysPath, "dev", "block", fmt.Sprintf("%d:%d", key.major, key.minor))
+	if name, err := os.ReadFile(filepath.Join(dir, "dm", "name")); err == nil {
+		if name := strings.TrimSpace(string(name)); name != "" {
+			return name, nil
+		}
+	}
+	target, err := os.Readlink(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(target), nil
+}
+
+func (r *resolver) load(now time.Time) error {
+	f, err := os.Open(r.mountinfoPath)
+	if err != nil {
+		return fmt.Errorf("reading mounts: %w", err)
+	}
+	mounts, err := parseMountinfo(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("reading mounts: %w", err)
+	}
+
+	devices := make(map[devKey]*device, len(mounts))
+	for key, mountPoints := range mounts {
+		d := &device{loaded: now}
+		if name, err := r.deviceName(key); err == nil {
+			d.name = name
+		}
+		for _, mp := range mountPoints {
+			// Mount points of PersistentVolumes are preferred over staging paths like CSI global mounts
+			pv := persistentVolume(mp)
+			switch {
+			case d.pv == "" && pv != "":
+				d.mountPoint, d.pv = mp, pv
+			case (d.pv == "") == (pv == "") && (d.mountPoint == "" || len(mp) < len(d.mountPoint)):
+				d.mountPoint, d.pv = mp, pv
+			}
+		}
+		d.pvc = r.claimOf(d.pv)
+		devices[key] = d
+	}
+	r.devices = devices
+
+	// Forget the claims of volumes that were unmounted
+	for pv := range r.claims {
+		found := false
+		for _, d := range devices {
+			if d.pv == pv {
+				found = true
+				break
+			}
+		}
+		if !found {
+			delete(r.claims, pv)
+		}
+	}
+	return nil
+}
+
+// claimOf returns the claim of the PersistentVolume pv. Failed lookups, e.g. because the API server isn't reachable,
+// aren't retried until the volume is mounted again, so that they don't slow down every load.
+func (r *resolver) claimOf(pv string) string {
+	if pv == "" || r.claim == nil {
+		return ""
+	}
+	if pvc, ok := r.claims[pv]; ok {
+		return pvc
+	}
+	pvc, err := r.claim(pv)
+	if err != nil {
+		log.Debugf("blockdev: looking up claim of persistent volume %q: %v", pv, err)
+	}
+	r.claims[pv] = pvc
+	return pvc
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blockdev
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const podVolume = "/var/lib/kubelet/pods/0d6f0c2e-1c3a-4c52-9d1e-3f0e1c9c2a11/volumes/kubernetes.io~csi/pvc-42/mount"
+
+const mountinfo = `25 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+26 25 0:22 / /proc rw,nosuid shared:12 - proc proc rw
+30 25 253:3 / /var/lib/kubelet/plugins/kubernetes.io/csi/ebs.csi.aws.com/abc/globalmount rw shared:40 - ext4 /dev/mapper/vg0-data rw
+31 25 253:3 / ` + podVolume + ` rw shared:40 - ext4 /dev/mapper/vg0-data rw
+32 25 8:2 / /mnt/my\040disk rw shared:41 - ext4 /dev/sda2 rw
+33 25 0:40 / /var/lib/kubelet/pods/0d6f0c2e-1c3a-4c52-9d1e-3f0e1c9c2a11/volumes/kubernetes.io~empty-dir/cache rw - tmpfs tmpfs rw
+`
+
+// addDevice adds a block device to the fake sysfs below sys, with a device-mapper name if dmName isn't empty
+func addDevice(t *testing.T, sys, dev, kernelName, dmName string) {
+	dir := filepath.Join(sys, "devices", "virtual", "block", kernelName)
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	if dmName != "" {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "dm"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "dm", "name"), []byte(dmName+"\n"), 0o644))
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(sys, "dev", "block"), 0o755))
+	require.NoError(t, os.Symlink(filepath.Join("..", "..", "devices", "virtual", "block", kernelName),
+		filepath.Join(sys, "dev", "block", dev)))
+}
+
+func TestParseMountinfo(t *testing.T) {
+	mounts, err := parseMountinfo(strings.NewReader(mountinfo))
+	require.NoError(t, err)
+	require.Equal(t, map[devKey][]string{
+		{8, 1}: {"/"},
+		{8, 2}: {"/mnt/my disk"},
+		{253, 3}: {
+			"/var/lib/kubelet/plugins/kubernetes.io/csi/ebs.csi.aws.com/abc/globalmount",
+			podVolume,
+		},
+	}, mounts)
+}
+
+func TestPersistentVolume(t *testing.T) {
+	for mountPoint, pv := range map[string]string{
+		podVolume: "pvc-42",
+		"/var/lib/kubelet/pods/abc/volumes/kubernetes.io~aws-ebs/pv-ebs":             "pv-ebs",
+		"/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-43/globalmount":           "pvc-43",
+		"/var/lib/kubelet/pods/abc/volumes/kubernetes.io~empty-dir/cache":            "",
+		"/var/lib/kubelet/plugins/kubernetes.io/csi/ebs.csi.aws.com/abc/globalmount": "",
+		"/": "",
+	} {
+		require.Equal(t, pv, persistentVolume(mountPoint), mountPoint)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	sys := t.TempDir()
+	addDevice(t, sys, "8:1", "sda1", "")
+	addDevice(t, sys, "253:3", "dm-3", "vg0-data")
+	addDevice(t, sys, "253:4", "dm-4", "vg0-swap")
+	mountinfoPath := filepath.Join(t.TempDir(), "mountinfo")
+	require.NoError(t, os.WriteFile(mountinfoPath, []byte(mountinfo), 0o644))
+
+	lookups := 0
+	claim := func(pv string) (string, error) {
+		lookups++
+		if pv == "pvc-42" {
+			return "default/data", nil
+		}
+		return "", errors.New("not found")
+	}
+
+	now := time.Now()
+	r := newResolver(sys, mountinfoPath, claim, time.Minute)
+	r.now = func() time.Time { return now }
+
+	d, err := r.Get(253, 3)
+	require.NoError(t, err)
+	require.Equal(t, "vg0-data", d.name)
+	require.Equal(t, podVolume, d.mountPoint)
+	require.Equal(t, "pvc-42", d.pv)
+	require.Equal(t, "default/data", d.pvc)
+
+	d, err = r.Get(8, 1)
+	require.NoError(t, err)
+	require.Equal(t, &device{name: "sda1", mountPoint: "/", loaded: now}, d)
+
+	// Devices that aren't mounted only have a name
+	d, err = r.Get(253, 4)
+	require.NoError(t, err)
+	require.Equal(t, &device{name: "vg0-swap", loaded: now}, d)
+
+	// Claims are only looked up once
+	now = now.Add(time.Minute)
+	d, err = r.Get(253, 3)
+	require.NoError(t, err)
+	require.Equal(t, "default/data", d.pvc)
+	require.Equal(t, 1, lookups)
+
+	// Unmounted volumes are forgotten
+	require.NoError(t, os.WriteFile(mountinfoPath, []byte(strings.SplitAfter(mountinfo, "\n")[0]), 0o644))
+	now = now.Add(time.Minute)
+	d, err = r.Get(253, 3)
+	require.NoError(t, err)
+	require.Equal(t, &device{name: "vg0-data", loaded: now}, d)
+	require.Empty(t, r.claims)
+}
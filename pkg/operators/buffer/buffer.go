@@ -0,0 +1,310 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package buffer provides an operator that keeps the most recent events of each data source in memory. Consumers
+// that subscribe using Subscribe while the gadget is already running first receive the buffered events and then all
+// new ones, e.g. clients attaching to a detached gadget instance of the daemon.
+package buffer
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/filter"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	OperatorName = "buffer"
+
+	ParamMaxEvents = "max-events"
+	ParamMaxAge    = "max-age"
+	ParamMaxBytes  = "max-bytes"
+
+	// Priority is chosen so that filtered events are not buffered
+	Priority = filter.Priority + 100
+
+	// varName is the name of the gadget context variable holding the operator instance
+	varName = "operator.buffer"
+)
+
+// ErrNotEnabled is returned by Subscribe if buffering is not enabled for the gadget
+var ErrNotEnabled = errors.New("buffering is not enabled for this gadget")
+
+type bufferOperator struct{}
+
+func (o *bufferOperator) Name() string {
+	return OperatorName
+}
+
+func (o *bufferOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *bufferOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *bufferOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:          ParamMaxEvents,
+			Title:        "Maximum buffered events",
+			Description:  "Number of the most recent events of each data source to keep for late subscribers; 0 disables buffering unless max-age is set",
+			DefaultValue: "0",
+			TypeHint:     api.TypeUint32,
+		},
+		{
+			Key:          ParamMaxAge,
+			Title:        "Maximum age of buffered events",
+			Description:  "Keep the events of the given duration for late subscribers; 0 disables buffering unless max-events is set",
+			DefaultValue: "0",
+			TypeHint:     api.TypeDuration,
+		},
+		{
+			Key:          ParamMaxBytes,
+			Title:        "Maximum buffered bytes",
+			Description:  "Maximum size of the buffered events of each data source; the oldest events are dropped first",
+			DefaultValue: "16777216",
+			TypeHint:     api.TypeUint64,
+		},
+	}
+}
+
+func (o *bufferOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	maxEvents := params.Get(ParamMaxEvents).AsUint32()
+	maxAge := params.Get(ParamMaxAge).AsDuration()
+	maxBytes := params.Get(ParamMaxBytes).AsUint64()
+	if maxAge < 0 {
+		return nil, fmt.Errorf("invalid %s: %s", ParamMaxAge, maxAge)
+	}
+
+	inst := &bufferOperatorInstance{
+		buffers: make(map[datasource.DataSource]*dataSourceBuffer),
+	}
+	// The instance is kept even if buffering is disabled, so that its params are exposed
+	if (maxEvents == 0 && maxAge == 0) || maxBytes == 0 {
+		return inst, nil
+	}
+	for _, ds := range gadgetCtx.GetDataSources() {
+		inst.buffers[ds] = &dataSourceBuffer{
+			maxEvents: int(maxEvents),
+			maxAge:    maxAge,
+			maxBytes:  int(maxBytes),
+			now:       time.Now,
+		}
+	}
+	gadgetCtx.SetVar(varName, inst)
+	return inst, nil
+}
+
+func (o *bufferOperator) Priority() int {
+	return Priority
+}
+
+type bufferedEvent struct {
+	time    time.Time
+	payload []byte
+}
+
+type lateSubscriber struct {
+	fn datasource.DataFunc
+}
+
+// dataSourceBuffer keeps the events of a single data source
+type dataSourceBuffer struct {
+	maxEvents int
+	maxAge    time.Duration
+	maxBytes  int
+	now       func() time.Time
+
+	mu     sync.Mutex
+	events []bufferedEvent
+	// size is the sum of the sizes of the payloads of events
+	size        int
+	subscribers []*lateSubscriber
+}
+
+// prune removes the events exceeding the limits; mu must be held
+func (b *dataSourceBuffer) prune() {
+	drop := 0
+	if b.maxEvents > 0 && len(b.events) > b.maxEvents {
+		drop = len(b.events) - b.maxEvents
+	}
+	if b.maxAge > 0 {
+		oldest := b.now().Add(-b.maxAge)
+		for drop < len(b.events) && b.events[drop].time.Before(oldest) {
+			drop++
+		}
+	}
+	size := b.size
+	for i := 0; i < drop; i++ {
+		size -= len(b.events[i].payload)
+	}
+	for drop < len(b.events) && size > b.maxBytes {
+		size -= len(b.events[drop].payload)
+		drop++
+	}
+	if drop > 0 {
+		b.events = slices.Delete(b.events, 0, drop)
+		b.size = size
+	}
+}
+
+func (b *dataSourceBuffer) add(ds datasource.DataSource, data datasource.Data) error {
+	payload, err := proto.Marshal(datasource.Serializable(data))
+	if err != nil {
+		return fmt.Errorf("marshaling data: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.events = append(b.events, bufferedEvent{time: b.now(), payload: payload})
+	b.size += len(payload)
+	b.prune()
+
+	for _, sub := range b.subscribers {
+		if err := sub.fn(ds, data); err != nil && !errors.Is(err, datasource.ErrDiscard) {
+			return err
+		}
+	}
+	return nil
+}
+
+// subscribe replays the buffered events to fn and adds it to the subscribers of new events; holding mu makes sure
+// that no event is missed or sent twice
+func (b *dataSourceBuffer) subscribe(ds datasource.DataSource, fn datasource.DataFunc) (*lateSubscriber, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.prune()
+	for _, ev := range b.events {
+		data := ds.NewData()
+		if err := proto.Unmarshal(ev.payload, data.Raw()); err != nil {
+			return nil, fmt.Errorf("unmarshaling buffered data: %w", err)
+		}
+		err := fn(ds, data)
+		ds.Release(data)
+		if err != nil && !errors.Is(err, datasource.ErrDiscard) {
+			return nil, err
+		}
+	}
+
+	sub := &lateSubscriber{fn: fn}
+	b.subscribers = append(b.subscribers, sub)
+	return sub, nil
+}
+
+func (b *dataSourceBuffer) unsubscribe(sub *lateSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if idx := slices.Index(b.subscribers, sub); idx >= 0 {
+		b.subscribers = slices.Delete(b.subscribers, idx, idx+1)
+	}
+}
+
+func (b *dataSourceBuffer) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune()
+	return len(b.events)
+}
+
+type bufferOperatorInstance struct {
+	buffers map[datasource.DataSource]*dataSourceBuffer
+}
+
+func (o *bufferOperatorInstance) Name() string {
+	return OperatorName
+}
+
+func (o *bufferOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error {
+	for ds, buffer := range o.buffers {
+		ds.Subscribe(buffer.add, Priority)
+	}
+	return nil
+}
+
+func (o *bufferOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+func (o *bufferOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+// Subscribe calls fn with the buffered events of ds, oldest first, and afterwards with every new event of ds until
+// the returned function is called. Unlike DataSource.Subscribe, it can be used while the gadget is running. Data
+// sent to fn has to be consumed synchronously. It returns ErrNotEnabled if the buffer operator is not enabled for
+// the gadget.
+func Subscribe(gadgetCtx operators.GadgetContext, ds datasource.DataSource, fn datasource.DataFunc) (func(), error) {
+	buffer, err := getBuffer(gadgetCtx, ds)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := buffer.subscribe(ds, fn)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		buffer.unsubscribe(sub)
+	}, nil
+}
+
+// Enabled returns whether the buffer operator buffers the events of gadgetCtx
+func Enabled(gadgetCtx operators.GadgetContext) bool {
+	_, ok := gadgetCtx.GetVar(varName)
+	return ok
+}
+
+// Len returns the number of events of ds currently buffered
+func Len(gadgetCtx operators.GadgetContext, ds datasource.DataSource) (int, error) {
+	buffer, err := getBuffer(gadgetCtx, ds)
+	if err != nil {
+		return 0, err
+	}
+	return buffer.len(), nil
+}
+
+func getBuffer(gadgetCtx operators.GadgetContext, ds datasource.DataSource) (*dataSourceBuffer, error) {
+	v, ok := gadgetCtx.GetVar(varName)
+	if !ok {
+		return nil, ErrNotEnabled
+	}
+	buffer, ok := v.(*bufferOperatorInstance).buffers[ds]
+	if !ok {
+		return nil, fmt.Errorf("data source %q is not buffered", ds.Name())
+	}
+	return buffer, nil
+}
+
+func init() {
+	operators.RegisterDataOperator(&bufferOperator{})
+}
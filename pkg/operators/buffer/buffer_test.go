@@ -0,0 +1,154 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buffer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+)
+
+func newTestContext(t *testing.T, paramValues api.ParamValues) (*gadgetcontext.GadgetContext, datasource.DataSource, datasource.FieldAccessor) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+	pid, err := ds.AddField("pid", datasource.WithKind(api.Kind_Uint32))
+	require.NoError(t, err)
+
+	inst, err := (&bufferOperator{}).InstantiateDataOperator(gadgetCtx, paramValues)
+	require.NoError(t, err)
+	require.NotNil(t, inst)
+	require.NoError(t, inst.(operators.PreStart).PreStart(gadgetCtx))
+	return gadgetCtx, ds, pid
+}
+
+func emit(t *testing.T, ds datasource.DataSource, pid datasource.FieldAccessor, value uint32) {
+	data := ds.NewData()
+	require.NoError(t, pid.Set(data, make([]byte, 4)))
+	pid.PutUint32(data, value)
+	require.NoError(t, ds.EmitAndRelease(data))
+}
+
+func collect(pid datasource.FieldAccessor, pids *[]uint32) datasource.DataFunc {
+	return func(ds datasource.DataSource, data datasource.Data) error {
+		*pids = append(*pids, pid.Uint32(data))
+		return nil
+	}
+}
+
+func TestBufferReplay(t *testing.T) {
+	gadgetCtx, ds, pid := newTestContext(t, api.ParamValues{ParamMaxEvents: "3"})
+
+	for i := uint32(1); i <= 5; i++ {
+		emit(t, ds, pid, i)
+	}
+	n, err := Len(gadgetCtx, ds)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	var pids []uint32
+	unsubscribe, err := Subscribe(gadgetCtx, ds, collect(pid, &pids))
+	require.NoError(t, err)
+	require.Equal(t, []uint32{3, 4, 5}, pids)
+
+	emit(t, ds, pid, 6)
+	require.Equal(t, []uint32{3, 4, 5, 6}, pids)
+
+	unsubscribe()
+	emit(t, ds, pid, 7)
+	require.Equal(t, []uint32{3, 4, 5, 6}, pids)
+
+	var replayed []uint32
+	_, err = Subscribe(gadgetCtx, ds, collect(pid, &replayed))
+	require.NoError(t, err)
+	require.Equal(t, []uint32{5, 6, 7}, replayed)
+}
+
+func TestBufferMaxAge(t *testing.T) {
+	gadgetCtx, ds, pid := newTestContext(t, api.ParamValues{ParamMaxAge: "10s"})
+
+	now := time.Now()
+	buffer, err := getBuffer(gadgetCtx, ds)
+	require.NoError(t, err)
+	buffer.now = func() time.Time { return now }
+
+	emit(t, ds, pid, 1)
+	now = now.Add(5 * time.Second)
+	emit(t, ds, pid, 2)
+	now = now.Add(6 * time.Second)
+	emit(t, ds, pid, 3)
+
+	var pids []uint32
+	_, err = Subscribe(gadgetCtx, ds, collect(pid, &pids))
+	require.NoError(t, err)
+	require.Equal(t, []uint32{2, 3}, pids)
+
+	now = now.Add(time.Minute)
+	n, err := Len(gadgetCtx, ds)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+}
+
+func TestBufferMaxBytes(t *testing.T) {
+	gadgetCtx, ds, pid := newTestContext(t, api.ParamValues{ParamMaxAge: "1h", ParamMaxBytes: "1"})
+	require.True(t, Enabled(gadgetCtx))
+
+	buffer, err := getBuffer(gadgetCtx, ds)
+	require.NoError(t, err)
+	// Events larger than the limit aren't buffered at all
+	emit(t, ds, pid, 1)
+	require.Empty(t, buffer.events)
+	require.Zero(t, buffer.size)
+
+	buffer.maxBytes = 1 << 20
+	emit(t, ds, pid, 1)
+	eventSize := buffer.size
+	require.NotZero(t, eventSize)
+
+	// Leave room for two events
+	buffer.maxBytes = 2 * eventSize
+	emit(t, ds, pid, 2)
+	emit(t, ds, pid, 3)
+	require.Len(t, buffer.events, 2)
+	require.Equal(t, 2*eventSize, buffer.size)
+
+	var pids []uint32
+	_, err = Subscribe(gadgetCtx, ds, collect(pid, &pids))
+	require.NoError(t, err)
+	require.Equal(t, []uint32{2, 3}, pids)
+}
+
+func TestBufferDisabled(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+
+	// The instance is returned anyway, so that the params of the operator are exposed
+	inst, err := (&bufferOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+	require.NoError(t, err)
+	require.NotNil(t, inst)
+	require.NoError(t, inst.(operators.PreStart).PreStart(gadgetCtx))
+	require.False(t, Enabled(gadgetCtx))
+
+	_, err = Subscribe(gadgetCtx, ds, func(datasource.DataSource, datasource.Data) error { return nil })
+	require.ErrorIs(t, err, ErrNotEnabled)
+}
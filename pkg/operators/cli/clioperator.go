@@ -16,11 +16,14 @@ package clioperator
 
 import (
 	"fmt"
+	"os"
 	"sort"
 	"strings"
 
+	"golang.org/x/term"
 	"sigs.k8s.io/yaml"
 
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/columns/formatter/textcolumns"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource/formatters/json"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
@@ -34,8 +37,13 @@ const (
 	// to have happened before the operator becomes active
 	Priority = 10000
 
-	ParamFields = "fields"
-	ParamMode   = "output"
+	ParamFields       = "fields"
+	ParamMode         = "output"
+	ParamWidthSamples = "width-samples"
+	ParamInteractive  = "interactive"
+
+	// DefaultWidthSamples is the number of events whose values are used to adapt the widths of columns
+	DefaultWidthSamples = 100
 
 	ModeJSON       = "json"
 	ModeJSONPretty = "jsonpretty"
@@ -77,6 +85,43 @@ func (o *cliOperator) Priority() int {
 type cliOperatorInstance struct {
 	mode        string
 	paramValues api.ParamValues
+	labelWidth  int
+	interactive *interactive
+}
+
+// label returns the image of the gadget ds belongs to, padded to the width of the label column, if the gadget shares
+// its output with other gadgets
+func (o *cliOperatorInstance) label(ds datasource.DataSource) string {
+	image, ok := ds.Annotations()[datasource.AnnotationImage]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%-*s ", o.labelWidth, image)
+}
+
+// labelJSON adds the image of the gadget ds belongs to as "gadget" field to a JSON object, if the gadget shares its
+// output with other gadgets
+func (o *cliOperatorInstance) labelJSON(ds datasource.DataSource, b []byte) []byte {
+	image, ok := ds.Annotations()[datasource.AnnotationImage]
+	if !ok || len(b) < 2 || b[0] != '{' {
+		return b
+	}
+	label := fmt.Sprintf("{%q:%q", "gadget", image)
+	if o.mode == ModeJSONPretty {
+		label = fmt.Sprintf("{\n  %q: %q", "gadget", image)
+	}
+	if strings.TrimSpace(string(b[1:])) != "}" {
+		label += ","
+	}
+	return append([]byte(label), b[1:]...)
+}
+
+// labelHeader prefixes the header of the columns with the title of the label column, if label isn't empty
+func labelHeader(label, s string) string {
+	if label == "" {
+		return s
+	}
+	return fmt.Sprintf("%-*s", len(label), "GADGET") + s
 }
 
 func (o *cliOperatorInstance) Name() string {
@@ -95,6 +140,34 @@ func getNamesFromFields(fields []*api.Field) []string {
 	return res
 }
 
+// selectableFields returns the fields of ds that can be shown, sorted by name, and the ones shown by default, sorted by
+// their order
+func selectableFields(ds datasource.DataSource) (availableFields []*api.Field, defaultFields []*api.Field) {
+	for _, f := range ds.Fields() {
+		if datasource.FieldFlagUnreferenced.In(f.Flags) ||
+			datasource.FieldFlagContainer.In(f.Flags) ||
+			datasource.FieldFlagEmpty.In(f.Flags) {
+			continue
+		}
+		availableFields = append(availableFields, f)
+		if datasource.FieldFlagHidden.In(f.Flags) {
+			continue
+		}
+		defaultFields = append(defaultFields, f)
+	}
+
+	// Sort available fields by name
+	sort.Slice(availableFields, func(i, j int) bool {
+		return availableFields[i].FullName < availableFields[j].FullName
+	})
+
+	// Sort default fields by order value
+	sort.SliceStable(defaultFields, func(i, j int) bool {
+		return defaultFields[i].Order < defaultFields[j].Order
+	})
+	return availableFields, defaultFields
+}
+
 func (o *cliOperatorInstance) ExtraParams(gadgetCtx operators.GadgetContext) api.Params {
 	dataSources := gadgetCtx.GetDataSources()
 
@@ -109,31 +182,7 @@ func (o *cliOperatorInstance) ExtraParams(gadgetCtx operators.GadgetContext) api
 	fieldsDescriptions := make([]string, 0, len(dataSources)+1)
 	fieldsDescriptions = append(fieldsDescriptions, "Available data sources / fields")
 	for _, ds := range dataSources {
-		fields := ds.Fields()
-		availableFields := make([]*api.Field, 0, len(fields))
-		defaultFields := make([]*api.Field, 0)
-		for _, f := range fields {
-			if datasource.FieldFlagUnreferenced.In(f.Flags) ||
-				datasource.FieldFlagContainer.In(f.Flags) ||
-				datasource.FieldFlagEmpty.In(f.Flags) {
-				continue
-			}
-			availableFields = append(availableFields, f)
-			if datasource.FieldFlagHidden.In(f.Flags) {
-				continue
-			}
-			defaultFields = append(defaultFields, f)
-		}
-
-		// Sort available fields by name
-		sort.Slice(availableFields, func(i, j int) bool {
-			return availableFields[i].FullName < availableFields[j].FullName
-		})
-
-		// Sort default fields by order value
-		sort.SliceStable(defaultFields, func(i, j int) bool {
-			return defaultFields[i].Order < defaultFields[j].Order
-		})
+		availableFields, defaultFields := selectableFields(ds)
 
 		fieldsDefaultValue := strings.Join(getNamesFromFields(defaultFields), ",")
 		if nameDS {
@@ -168,7 +217,23 @@ func (o *cliOperatorInstance) ExtraParams(gadgetCtx operators.GadgetContext) api
 		PossibleValues: []string{ModeJSON, ModeJSONPretty, ModeColumns, ModeYAML},
 	}
 
-	return api.Params{fields, mode}
+	widthSamples := &api.Param{
+		Key:          ParamWidthSamples,
+		DefaultValue: fmt.Sprintf("%d", DefaultWidthSamples),
+		Description: "Number of recent events used to adapt the widths of columns to their values; widths stay as" +
+			" computed from the types of the fields if 0",
+		TypeHint: api.TypeUint32,
+	}
+
+	interactive := &api.Param{
+		Key:          ParamInteractive,
+		DefaultValue: "false",
+		Description: "Pick the fields to show and the field to sort events by using the keyboard while the gadget is" +
+			" running; only for the columns output mode on terminals",
+		TypeHint: api.TypeBool,
+	}
+
+	return api.Params{fields, mode, widthSamples, interactive}
 }
 
 func (o *cliOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error {
@@ -189,6 +254,23 @@ func (o *cliOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error
 	}
 
 	o.mode = params.Get(ParamMode).AsString()
+	widthSamples := int(params.Get(ParamWidthSamples).AsUint32())
+
+	if params.Get(ParamInteractive).AsBool() {
+		switch {
+		case o.mode != ModeColumns:
+			gadgetCtx.Logger().Warnf("interactive mode is only supported for the %q output mode", ModeColumns)
+		case !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())):
+			gadgetCtx.Logger().Warnf("interactive mode requires a terminal")
+		default:
+			o.interactive = newInteractive(os.Stdout, gadgetCtx.Cancel)
+		}
+	}
+
+	o.labelWidth = len("GADGET")
+	for _, ds := range gadgetCtx.GetDataSources() {
+		o.labelWidth = max(o.labelWidth, len(ds.Annotations()[datasource.AnnotationImage]))
+	}
 
 	for _, ds := range gadgetCtx.GetDataSources() {
 		gadgetCtx.Logger().Debugf("subscribing to %s", ds.Name())
@@ -200,6 +282,8 @@ func (o *cliOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error
 
 		switch o.mode {
 		case ModeColumns:
+			label := o.label(ds)
+
 			p, err := ds.Parser()
 			if err != nil {
 				gadgetCtx.Logger().Debugf("failed to get parser: %v", err)
@@ -208,19 +292,29 @@ func (o *cliOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error
 
 			defCols := p.GetDefaultColumns()
 			gadgetCtx.Logger().Debugf("default fields: %s", defCols)
-			formatter := p.GetTextColumnsFormatter()
+			formatter := p.GetTextColumnsFormatter(textcolumns.WithAdaptiveWidths(widthSamples))
 
+			shown := defCols
 			if hasFields {
-				err := formatter.SetShowColumns(strings.Split(fields, ","))
+				shown = strings.Split(fields, ",")
+				err := formatter.SetShowColumns(shown)
 				if err != nil {
 					return fmt.Errorf("setting fields: %w", err)
 				}
 			}
 
-			formatter.SetEventCallback(func(s string) {
-				fmt.Print(s)
-				fmt.Print("\n")
-			})
+			var view *view
+			if o.interactive != nil {
+				// The interactive mode takes over the callbacks of the formatter
+				view = o.interactive.addView(ds, formatter, shown, label)
+			} else {
+				formatter.SetEventCallback(func(s string) {
+					fmt.Print(label + s + "\n")
+				})
+				formatter.SetHeaderCallback(func(s string) {
+					fmt.Println(labelHeader(label, s))
+				})
+			}
 
 			p.SetEventCallback(formatter.EventHandlerFunc())
 			handler, ok := p.EventHandlerFunc().(func(data *datasource.DataTuple))
@@ -230,7 +324,15 @@ func (o *cliOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error
 				continue
 			}
 
-			fmt.Println(formatter.FormatHeader())
+			fmt.Println(labelHeader(label, formatter.FormatHeader()))
+
+			if view != nil {
+				ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+					o.interactive.handle(view, data, handler)
+					return nil
+				}, Priority)
+				continue
+			}
 
 			ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
 				handler(datasource.NewDataTuple(ds, data))
@@ -252,7 +354,7 @@ func (o *cliOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error
 			}
 
 			df := func(ds datasource.DataSource, data datasource.Data) error {
-				fmt.Println(string(jsonFormatter.Marshal(data)))
+				fmt.Println(string(o.labelJSON(ds, jsonFormatter.Marshal(data))))
 				return nil
 			}
 
@@ -260,7 +362,7 @@ func (o *cliOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error
 				// For the time being, this uses a slow approach to marshal to YAML, by first
 				// converting to JSON and then to YAML. This should get a dedicated formatter sooner or later.
 				df = func(ds datasource.DataSource, data datasource.Data) error {
-					yml, err := yaml.JSONToYAML(jsonFormatter.Marshal(data))
+					yml, err := yaml.JSONToYAML(o.labelJSON(ds, jsonFormatter.Marshal(data)))
 					if err != nil {
 						return fmt.Errorf("serializing yaml: %w", err)
 					}
@@ -276,10 +378,18 @@ func (o *cliOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error
 }
 
 func (o *cliOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	if o.interactive != nil {
+		if err := o.interactive.run(); err != nil {
+			gadgetCtx.Logger().Warnf("disabling interactive mode: %v", err)
+		}
+	}
 	return nil
 }
 
 func (o *cliOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	if o.interactive != nil {
+		o.interactive.stop()
+	}
 	return nil
 }
 
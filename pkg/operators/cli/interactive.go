@@ -0,0 +1,509 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clioperator
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/parser"
+)
+
+const (
+	// refreshInterval is the interval in which sorted events are redrawn
+	refreshInterval = time.Second
+
+	// maxHeldLines limits the number of lines kept while a picker is open or until sorted events are redrawn
+	maxHeldLines = 10000
+
+	clearScreen = "\033[H\033[2J"
+
+	interactiveHelp = "keys: f = pick fields, s = sort by field, r = reverse order, d = next data source, q = quit"
+)
+
+type picker int
+
+const (
+	pickerNone picker = iota
+	pickerFields
+	pickerSort
+)
+
+// interactive lets users change the fields that are shown and the order of events using single key presses while a
+// gadget is running. Unsorted events are printed as they arrive; sorted events are collected and redrawn every
+// refreshInterval, like a top view.
+type interactive struct {
+	mu   sync.Mutex
+	out  io.Writer
+	quit func()
+
+	views  []*view
+	active int
+
+	picker       picker
+	input        string
+	heldHeader   string
+	held         []string
+	droppedLines int
+
+	stopped bool
+	done    chan struct{}
+}
+
+// view is the columns output of a single data source
+type view struct {
+	ds        datasource.DataSource
+	formatter parser.TextColumnsFormatter
+	fields    []*api.Field
+	shown     []string
+	label     string
+
+	// lines receives the output of formatter for the current event
+	lines []string
+
+	sortName  string
+	sortField datasource.FieldAccessor
+	sortDesc  bool
+	batch     []sortedLines
+}
+
+type sortedLines struct {
+	num   float64
+	str   string
+	lines []string
+}
+
+func newInteractive(out io.Writer, quit func()) *interactive {
+	return &interactive{
+		out:  out,
+		quit: quit,
+		done: make(chan struct{}),
+	}
+}
+
+// addView takes over the output of formatter, which has to show the given fields of ds; the lines of the view are
+// prefixed with label
+func (in *interactive) addView(ds datasource.DataSource, formatter parser.TextColumnsFormatter, shown []string, label string) *view {
+	fields, _ := selectableFields(ds)
+	v := &view{
+		ds:        ds,
+		formatter: formatter,
+		fields:    fields,
+		shown:     shown,
+		label:     label,
+	}
+	formatter.SetEventCallback(func(s string) {
+		v.lines = append(v.lines, v.label+s)
+	})
+	// Called while holding the lock, when the widths of the columns changed
+	formatter.SetHeaderCallback(func(s string) {
+		if v.sortField == nil && in.picker == pickerNone {
+			fmt.Fprintln(in.out, labelHeader(v.label, s))
+		}
+	})
+	in.views = append(in.views, v)
+	return v
+}
+
+// handle formats data using handler and prints or collects the resulting lines
+func (in *interactive) handle(v *view, data datasource.Data, handler func(*datasource.DataTuple)) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	v.lines = v.lines[:0]
+	handler(datasource.NewDataTuple(v.ds, data))
+	if len(v.lines) == 0 {
+		return
+	}
+	lines := slices.Clone(v.lines)
+
+	if v.sortField != nil {
+		if len(v.batch) >= maxHeldLines {
+			in.droppedLines += len(lines)
+			return
+		}
+		entry := sortedLines{lines: lines}
+		switch kind := v.sortField.Type(); {
+		case isNumeric(kind):
+			entry.num = numericValue(v.sortField, data)
+		case kind == api.Kind_CString:
+			entry.str = v.sortField.CString(data)
+		default:
+			entry.str = v.sortField.String(data)
+		}
+		v.batch = append(v.batch, entry)
+		return
+	}
+
+	if in.picker != pickerNone {
+		if len(in.held) >= maxHeldLines {
+			in.droppedLines += len(lines)
+			return
+		}
+		in.held = append(in.held, lines...)
+		return
+	}
+
+	for _, line := range lines {
+		fmt.Fprintln(in.out, line)
+	}
+}
+
+// printHeader prints the header of the columns of v
+func (in *interactive) printHeader(v *view) {
+	fmt.Fprintln(in.out, labelHeader(v.label, v.formatter.FormatHeader()))
+}
+
+// refresh redraws the sorted events collected since the last refresh
+func (in *interactive) refresh() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if in.picker != pickerNone {
+		return
+	}
+
+	cleared := false
+	for _, v := range in.views {
+		if v.sortField == nil || len(v.batch) == 0 {
+			continue
+		}
+		if !cleared {
+			fmt.Fprint(in.out, clearScreen)
+			cleared = true
+		}
+		slices.SortStableFunc(v.batch, func(a, b sortedLines) int {
+			res := cmp.Compare(a.num, b.num)
+			if res == 0 {
+				res = cmp.Compare(a.str, b.str)
+			}
+			if v.sortDesc {
+				return -res
+			}
+			return res
+		})
+		in.printHeader(v)
+		for _, entry := range v.batch {
+			for _, line := range entry.lines {
+				fmt.Fprintln(in.out, line)
+			}
+		}
+		v.batch = nil
+	}
+	in.printDropped()
+}
+
+func (in *interactive) printDropped() {
+	if in.droppedLines > 0 {
+		fmt.Fprintf(in.out, "(%d lines dropped)\n", in.droppedLines)
+		in.droppedLines = 0
+	}
+}
+
+// sortableFields returns the fields of v that events can be sorted by
+func (v *view) sortableFields() []*api.Field {
+	var res []*api.Field
+	for _, f := range v.fields {
+		if isNumeric(f.Kind) || f.Kind == api.Kind_String || f.Kind == api.Kind_CString {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+func (in *interactive) printFieldPicker(v *view) {
+	fmt.Fprintf(in.out, "fields of data source %q (number + enter toggles a field, enter closes):\n", v.ds.Name())
+	for i, f := range v.fields {
+		mark := " "
+		if slices.Contains(v.shown, f.FullName) {
+			mark = "x"
+		}
+		fmt.Fprintf(in.out, "%4d [%s] %s\n", i+1, mark, f.FullName)
+	}
+	fmt.Fprint(in.out, "> ")
+}
+
+func (in *interactive) printSortPicker(v *view) {
+	fmt.Fprintf(in.out, "sort events of data source %q by (number + enter, enter closes):\n", v.ds.Name())
+	fmt.Fprintf(in.out, "%4d %s\n", 0, "(arrival)")
+	for i, f := range v.sortableFields() {
+		mark := ""
+		if f.FullName == v.sortName {
+			mark = " (current)"
+		}
+		fmt.Fprintf(in.out, "%4d %s%s\n", i+1, f.FullName, mark)
+	}
+	fmt.Fprint(in.out, "> ")
+}
+
+func (in *interactive) openPicker(p picker) {
+	v := in.views[in.active]
+	in.picker = p
+	in.input = ""
+	in.heldHeader = labelHeader(v.label, v.formatter.FormatHeader())
+	fmt.Fprintln(in.out)
+	if p == pickerFields {
+		in.printFieldPicker(v)
+	} else {
+		in.printSortPicker(v)
+	}
+}
+
+// closePicker prints the lines held while the picker was open and the header of the new columns
+func (in *interactive) closePicker() {
+	in.picker = pickerNone
+	in.input = ""
+	fmt.Fprintln(in.out)
+	if len(in.held) > 0 {
+		fmt.Fprintln(in.out, in.heldHeader)
+		for _, line := range in.held {
+			fmt.Fprintln(in.out, line)
+		}
+		in.held = nil
+	}
+	in.printDropped()
+	if v := in.views[in.active]; v.sortField == nil {
+		in.printHeader(v)
+	}
+}
+
+// toggleField shows or hides the field with the given index of the field picker
+func (in *interactive) toggleField(v *view, idx int) error {
+	if idx < 0 || idx >= len(v.fields) {
+		return fmt.Errorf("no field %d", idx+1)
+	}
+	name := v.fields[idx].FullName
+	shown := slices.Clone(v.shown)
+	if i := slices.Index(shown, name); i >= 0 {
+		if len(shown) == 1 {
+			return fmt.Errorf("at least one field has to be shown")
+		}
+		shown = slices.Delete(shown, i, i+1)
+	} else {
+		shown = append(shown, name)
+	}
+	if err := v.formatter.SetShowColumns(shown); err != nil {
+		return err
+	}
+	v.shown = shown
+	// Lines collected for sorting have been formatted for the previous fields
+	v.batch = nil
+	return nil
+}
+
+// setSort sorts the events of v by the field with the given index of the sort picker; 0 stops sorting
+func (in *interactive) setSort(v *view, idx int) error {
+	if idx == 0 {
+		v.sortName, v.sortField, v.sortDesc = "", nil, false
+		v.batch = nil
+		return nil
+	}
+	fields := v.sortableFields()
+	if idx < 1 || idx > len(fields) {
+		return fmt.Errorf("no field %d", idx)
+	}
+	name := fields[idx-1].FullName
+	if name != v.sortName {
+		v.sortDesc = false
+	}
+	v.sortName = name
+	v.sortField = v.ds.GetField(name)
+	v.batch = nil
+	return nil
+}
+
+func (in *interactive) commitInput() {
+	v := in.views[in.active]
+	if in.input == "" {
+		in.closePicker()
+		return
+	}
+	idx, err := strconv.Atoi(in.input)
+	in.input = ""
+	fmt.Fprintln(in.out)
+	if err != nil {
+		fmt.Fprintf(in.out, "invalid number: %v\n> ", err)
+		return
+	}
+	switch in.picker {
+	case pickerFields:
+		if err := in.toggleField(v, idx-1); err != nil {
+			fmt.Fprintf(in.out, "%v\n> ", err)
+			return
+		}
+		in.printFieldPicker(v)
+	case pickerSort:
+		if err := in.setSort(v, idx); err != nil {
+			fmt.Fprintf(in.out, "%v\n> ", err)
+			return
+		}
+		in.closePicker()
+	}
+}
+
+// handleKey reacts to a key pressed by the user
+func (in *interactive) handleKey(key byte) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if in.stopped || len(in.views) == 0 {
+		return
+	}
+
+	if in.picker != pickerNone {
+		switch {
+		case key >= '0' && key <= '9':
+			in.input += string(key)
+			fmt.Fprint(in.out, string(key))
+		case key == 0x7f || key == '\b':
+			if in.input != "" {
+				in.input = in.input[:len(in.input)-1]
+				fmt.Fprint(in.out, "\b \b")
+			}
+		case key == '\r' || key == '\n':
+			in.commitInput()
+		case key == 0x1b || key == 'q':
+			in.closePicker()
+		}
+		return
+	}
+
+	v := in.views[in.active]
+	switch key {
+	case 'f':
+		in.openPicker(pickerFields)
+	case 's':
+		in.openPicker(pickerSort)
+	case 'r':
+		if v.sortField == nil {
+			fmt.Fprintln(in.out, "events aren't sorted, press s to pick a field")
+			return
+		}
+		v.sortDesc = !v.sortDesc
+		order := "ascending"
+		if v.sortDesc {
+			order = "descending"
+		}
+		fmt.Fprintf(in.out, "sorting by %s in %s order\n", v.sortName, order)
+	case 'd':
+		in.active = (in.active + 1) % len(in.views)
+		fmt.Fprintf(in.out, "picking fields of data source %q\n", in.views[in.active].ds.Name())
+	case 'h', '?':
+		fmt.Fprintln(in.out, interactiveHelp)
+	case 'q':
+		in.quit()
+	}
+}
+
+// run reads keys from stdin and redraws sorted events until stop is called
+func (in *interactive) run() error {
+	restore, err := setCbreakMode(int(os.Stdin.Fd()))
+	if err != nil {
+		return fmt.Errorf("setting up terminal: %w", err)
+	}
+
+	go func() {
+		<-in.done
+		restore()
+	}()
+
+	// The reader is left blocked on stdin once the gadget stopped; keys are ignored from then on
+	go func() {
+		r := bufio.NewReader(os.Stdin)
+		for {
+			key, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			in.handleKey(key)
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-in.done:
+				return
+			case <-ticker.C:
+				in.refresh()
+			}
+		}
+	}()
+
+	in.mu.Lock()
+	fmt.Fprintln(in.out, interactiveHelp)
+	in.mu.Unlock()
+	return nil
+}
+
+// stop prints the remaining sorted events and restores the terminal
+func (in *interactive) stop() {
+	in.refresh()
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.stopped {
+		return
+	}
+	in.stopped = true
+	close(in.done)
+}
+
+func isNumeric(kind api.Kind) bool {
+	switch kind {
+	case api.Kind_Int8, api.Kind_Int16, api.Kind_Int32, api.Kind_Int64,
+		api.Kind_Uint8, api.Kind_Uint16, api.Kind_Uint32, api.Kind_Uint64,
+		api.Kind_Float32, api.Kind_Float64:
+		return true
+	}
+	return false
+}
+
+func numericValue(f datasource.FieldAccessor, data datasource.Data) float64 {
+	switch f.Type() {
+	case api.Kind_Int8:
+		return float64(f.Int8(data))
+	case api.Kind_Int16:
+		return float64(f.Int16(data))
+	case api.Kind_Int32:
+		return float64(f.Int32(data))
+	case api.Kind_Int64:
+		return float64(f.Int64(data))
+	case api.Kind_Uint8:
+		return float64(f.Uint8(data))
+	case api.Kind_Uint16:
+		return float64(f.Uint16(data))
+	case api.Kind_Uint32:
+		return float64(f.Uint32(data))
+	case api.Kind_Uint64:
+		return float64(f.Uint64(data))
+	case api.Kind_Float32:
+		return float64(f.Float32(data))
+	case api.Kind_Float64:
+		return f.Float64(data)
+	}
+	return 0
+}
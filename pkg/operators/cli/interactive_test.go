@@ -0,0 +1,187 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clioperator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/columns/formatter/textcolumns"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+type interactiveTest struct {
+	in      *interactive
+	out     *bytes.Buffer
+	view    *view
+	emit    func(comm string, pid uint32)
+	quitted bool
+}
+
+func newInteractiveTest(t *testing.T) *interactiveTest {
+	ds := datasource.New(datasource.TypeEvent, "exec")
+	comm, err := ds.AddField("comm", datasource.WithKind(api.Kind_String))
+	require.NoError(t, err)
+	pid, err := ds.AddField("pid", datasource.WithKind(api.Kind_Uint32))
+	require.NoError(t, err)
+	_, err = ds.AddField("uid", datasource.WithKind(api.Kind_Uint32), datasource.WithFlags(datasource.FieldFlagHidden))
+	require.NoError(t, err)
+
+	p, err := ds.Parser()
+	require.NoError(t, err)
+	formatter := p.GetTextColumnsFormatter(textcolumns.WithAdaptiveWidths(0))
+	require.NoError(t, formatter.SetShowColumns([]string{"comm", "pid"}))
+
+	it := &interactiveTest{out: &bytes.Buffer{}}
+	it.in = newInteractive(it.out, func() { it.quitted = true })
+	it.view = it.in.addView(ds, formatter, []string{"comm", "pid"}, "")
+	p.SetEventCallback(formatter.EventHandlerFunc())
+	handler := p.EventHandlerFunc().(func(data *datasource.DataTuple))
+
+	it.emit = func(commName string, pidValue uint32) {
+		data := ds.NewData()
+		require.NoError(t, comm.Set(data, []byte(commName)))
+		require.NoError(t, pid.Set(data, make([]byte, 4)))
+		pid.PutUint32(data, pidValue)
+		it.in.handle(it.view, data, handler)
+		ds.Release(data)
+	}
+	return it
+}
+
+func (it *interactiveTest) keys(keys string) {
+	for _, key := range []byte(keys) {
+		it.in.handleKey(key)
+	}
+}
+
+// lines returns the non-empty lines written since the last call
+func (it *interactiveTest) lines() []string {
+	var res []string
+	for _, line := range strings.Split(it.out.String(), "\n") {
+		line = strings.TrimPrefix(line, clearScreen)
+		if strings.TrimSpace(line) != "" {
+			res = append(res, strings.Join(strings.Fields(line), " "))
+		}
+	}
+	it.out.Reset()
+	return res
+}
+
+func TestInteractiveFieldPicker(t *testing.T) {
+	it := newInteractiveTest(t)
+
+	it.emit("cat", 1)
+	require.Equal(t, []string{"cat 1"}, it.lines())
+
+	// Available fields are listed by name, hidden ones included
+	it.keys("f")
+	require.Equal(t, []string{
+		`fields of data source "exec" (number + enter toggles a field, enter closes):`,
+		"1 [x] comm",
+		"2 [x] pid",
+		"3 [ ] uid",
+		">",
+	}, it.lines())
+
+	// Events are held while picking
+	it.emit("ls", 2)
+	require.Empty(t, it.lines())
+
+	it.keys("3\r")
+	require.Contains(t, it.lines(), "3 [x] uid")
+	it.keys("1\r")
+	require.Contains(t, it.lines(), "1 [ ] comm")
+
+	// Closing prints the held events using the previous columns, then the new header
+	it.keys("\r")
+	require.Equal(t, []string{"COMM PID", "ls 2", "PID UID"}, it.lines())
+
+	it.emit("sh", 3)
+	require.Equal(t, []string{"3 0"}, it.lines())
+	require.Equal(t, []string{"pid", "uid"}, it.view.shown)
+}
+
+func TestInteractiveSort(t *testing.T) {
+	it := newInteractiveTest(t)
+
+	it.keys("s")
+	require.Equal(t, []string{
+		`sort events of data source "exec" by (number + enter, enter closes):`,
+		"0 (arrival)",
+		"1 comm",
+		"2 pid",
+		"3 uid",
+		">",
+	}, it.lines())
+	it.keys("2\r")
+	require.Equal(t, []string{"2"}, it.lines())
+
+	// Sorted events are collected until the next refresh
+	it.emit("b", 20)
+	it.emit("a", 3)
+	it.emit("c", 100)
+	require.Empty(t, it.lines())
+	it.in.refresh()
+	require.Equal(t, []string{"COMM PID", "a 3", "b 20", "c 100"}, it.lines())
+
+	it.keys("r")
+	require.Equal(t, []string{"sorting by pid in descending order"}, it.lines())
+	it.emit("b", 20)
+	it.emit("a", 3)
+	it.in.refresh()
+	require.Equal(t, []string{"COMM PID", "b 20", "a 3"}, it.lines())
+
+	// Sorting by strings
+	it.keys("s1\r")
+	it.lines()
+	it.emit("b", 1)
+	it.emit("a", 2)
+	it.in.refresh()
+	require.Equal(t, []string{"COMM PID", "a 2", "b 1"}, it.lines())
+
+	// Back to the order of arrival
+	it.keys("s0\r")
+	it.lines()
+	it.emit("b", 1)
+	require.Equal(t, []string{"b 1"}, it.lines())
+}
+
+func TestInteractiveKeys(t *testing.T) {
+	it := newInteractiveTest(t)
+
+	it.keys("r")
+	require.Equal(t, []string{"events aren't sorted, press s to pick a field"}, it.lines())
+
+	it.keys("f9\r")
+	require.Contains(t, it.lines(), "no field 9")
+
+	// Hiding the last field isn't possible
+	it.keys("1\r2\r")
+	require.Contains(t, it.lines(), "at least one field has to be shown")
+	it.keys("\x1b")
+	it.lines()
+
+	it.keys("q")
+	require.True(t, it.quitted)
+
+	it.in.stop()
+	it.keys("f")
+	require.Empty(t, it.lines())
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+)
+
+// CapabilitiesDataSourceName is the name of the data source telling whether the containers a gadget runs on can be
+// traced
+const CapabilitiesDataSourceName = "capabilities"
+
+// ContainerCapabilities reports containers whose events can't be traced, e.g. because they run in a gVisor sandbox,
+// so that they don't silently show no events. Untraceable containers are logged; if enabled, all containers are
+// also emitted on the data source CapabilitiesDataSourceName.
+type ContainerCapabilities struct {
+	gadgetCtx operators.GadgetContext
+
+	// ds is nil if the data source isn't enabled
+	ds          datasource.DataSource
+	namespace   datasource.FieldAccessor
+	pod         datasource.FieldAccessor
+	container   datasource.FieldAccessor
+	containerID datasource.FieldAccessor
+	sandbox     datasource.FieldAccessor
+	traceable   datasource.FieldAccessor
+	reason      datasource.FieldAccessor
+	k8s         bool
+}
+
+// NewContainerCapabilities returns a ContainerCapabilities logging to gadgetCtx; the data source is registered if
+// emit is set. k8s adds the namespace and pod of containers to the data source.
+func NewContainerCapabilities(gadgetCtx operators.GadgetContext, emit bool, k8s bool) (*ContainerCapabilities, error) {
+	c := &ContainerCapabilities{gadgetCtx: gadgetCtx, k8s: k8s}
+	if !emit {
+		return c, nil
+	}
+
+	var err error
+	c.ds, err = gadgetCtx.RegisterDataSource(datasource.TypeEvent, CapabilitiesDataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("adding data source %q: %w", CapabilitiesDataSourceName, err)
+	}
+	if err := c.addFields(); err != nil {
+		return nil, fmt.Errorf("adding fields to data source %q: %w", CapabilitiesDataSourceName, err)
+	}
+	return c, nil
+}
+
+func (c *ContainerCapabilities) addFields() error {
+	field := func(name, description string, kind api.Kind, flags datasource.FieldFlag) (datasource.FieldAccessor, error) {
+		return c.ds.AddField(name, datasource.WithKind(kind), datasource.WithFlags(flags),
+			datasource.WithAnnotations(map[string]string{"description": description}))
+	}
+	var err error
+	if c.k8s {
+		if c.namespace, err = field("namespace", "Namespace of the pod", api.Kind_String, 0); err != nil {
+			return err
+		}
+		if c.pod, err = field("pod", "Name of the pod", api.Kind_String, 0); err != nil {
+			return err
+		}
+	}
+	if c.container, err = field("container", "Name of the container", api.Kind_String, 0); err != nil {
+		return err
+	}
+	if c.containerID, err = field("containerID", "ID of the container", api.Kind_String, datasource.FieldFlagHidden); err != nil {
+		return err
+	}
+	if c.sandbox, err = field("sandbox", "User-mode kernel sandbox the container runs in", api.Kind_String, 0); err != nil {
+		return err
+	}
+	if c.traceable, err = field("traceable", "Whether the events of the container can be traced", api.Kind_Bool, 0); err != nil {
+		return err
+	}
+	c.reason, err = field("reason", "Why the events of the container can't be traced", api.Kind_String, 0)
+	return err
+}
+
+// Report logs the container if it can't be traced and emits it on the data source
+func (c *ContainerCapabilities) Report(container *containercollection.Container) error {
+	name := container.K8s.ContainerName
+	if name == "" {
+		name = container.Runtime.ContainerName
+	}
+	reason := container.UntraceableReason()
+	if reason != "" {
+		c.gadgetCtx.Logger().Warnf("container %q %s: no events will be shown for it", name, reason)
+	}
+	if c.ds == nil {
+		return nil
+	}
+
+	data := c.ds.NewData()
+	traceable := []byte{1}
+	if reason != "" {
+		traceable = []byte{0}
+	}
+	fields := []struct {
+		acc   datasource.FieldAccessor
+		value []byte
+	}{
+		{c.namespace, []byte(container.K8s.Namespace)},
+		{c.pod, []byte(container.K8s.PodName)},
+		{c.container, []byte(name)},
+		{c.containerID, []byte(container.Runtime.ContainerID)},
+		{c.sandbox, []byte(container.Sandbox)},
+		{c.traceable, traceable},
+		{c.reason, []byte(reason)},
+	}
+	for _, f := range fields {
+		if f.acc == nil {
+			continue
+		}
+		if err := f.acc.Set(data, f.value); err != nil {
+			c.ds.Release(data)
+			return fmt.Errorf("setting field %q: %w", f.acc.Name(), err)
+		}
+	}
+	return c.ds.EmitAndRelease(data)
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/types"
+)
+
+func TestContainerCapabilities(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+
+	// Without the data source, untraceable containers are only logged
+	c, err := NewContainerCapabilities(gadgetCtx, false, true)
+	require.NoError(t, err)
+	require.Empty(t, gadgetCtx.GetDataSources())
+	require.NoError(t, c.Report(&containercollection.Container{Sandbox: containercollection.SandboxGVisor}))
+
+	c, err = NewContainerCapabilities(gadgetCtx, true, true)
+	require.NoError(t, err)
+	ds := gadgetCtx.GetDataSources()[CapabilitiesDataSourceName]
+	require.NotNil(t, ds)
+
+	pod := ds.GetField("pod")
+	container := ds.GetField("container")
+	sandbox := ds.GetField("sandbox")
+	traceable := ds.GetField("traceable")
+	reason := ds.GetField("reason")
+	type row struct {
+		pod, container, sandbox string
+		traceable               bool
+		reason                  bool
+	}
+	var rows []row
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		rows = append(rows, row{pod.String(data), container.String(data), sandbox.String(data), traceable.Bool(data), reason.String(data) != ""})
+		return nil
+	}, 0)
+
+	newContainer := func(name, sandbox string) *containercollection.Container {
+		return &containercollection.Container{
+			K8s:     containercollection.K8sMetadata{BasicK8sMetadata: types.BasicK8sMetadata{PodName: "pod", ContainerName: name}},
+			Sandbox: sandbox,
+		}
+	}
+	require.NoError(t, c.Report(newContainer("sandboxed", containercollection.SandboxGVisor)))
+	require.NoError(t, c.Report(newContainer("plain", "")))
+	require.Equal(t, []row{
+		{"pod", "sandboxed", containercollection.SandboxGVisor, false, true},
+		{"pod", "plain", "", true, false},
+	}, rows)
+}
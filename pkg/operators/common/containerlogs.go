@@ -0,0 +1,426 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource/compat"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/formatters"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/types"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+)
+
+// ContainerLogsDataSourceName is the name of the data source the log lines of containers are emitted on
+const ContainerLogsDataSourceName = "container_logs"
+
+const (
+	// logPollInterval is how often log files are checked for new lines
+	logPollInterval = 250 * time.Millisecond
+
+	// maxLogLineSize limits the size of a log line; longer ones are split
+	maxLogLineSize = 64 * 1024
+
+	// annotation of CRI-O holding the log file of the container
+	crioLogPathAnnotation = "io.kubernetes.cri-o.LogPath"
+)
+
+// Where the runtimes write the logs of containers, relative to the root of the host
+var (
+	podLogsDir          = "/var/log/pods"
+	dockerContainersDir = "/var/lib/docker/containers"
+)
+
+// ContainerLogs emits the lines containers write to stdout and stderr on the data source ContainerLogsDataSourceName,
+// so that they can be correlated with the events of the gadget. The logs are read from the files written by CRI
+// runtimes for Kubernetes and by the json-file logging driver of Docker; only lines written after a container is
+// added are emitted.
+type ContainerLogs struct {
+	gadgetCtx operators.GadgetContext
+
+	ds          datasource.DataSource
+	timestamp   datasource.FieldAccessor
+	namespace   datasource.FieldAccessor
+	pod         datasource.FieldAccessor
+	container   datasource.FieldAccessor
+	containerID datasource.FieldAccessor
+	mntns       datasource.FieldAccessor
+	stream      datasource.FieldAccessor
+	message     datasource.FieldAccessor
+	k8s         bool
+
+	// bootOffset converts the wall clock time of log lines to the clock used by eBPF programs
+	bootOffset int64
+
+	mu    sync.Mutex
+	tails map[string]*logTail
+	wg    sync.WaitGroup
+}
+
+// NewContainerLogs returns a ContainerLogs emitting on a data source registered in gadgetCtx. k8s adds the namespace
+// and pod of containers to the data source.
+func NewContainerLogs(gadgetCtx operators.GadgetContext, k8s bool) (*ContainerLogs, error) {
+	c := &ContainerLogs{
+		gadgetCtx:  gadgetCtx,
+		k8s:        k8s,
+		bootOffset: bootOffset(),
+		tails:      make(map[string]*logTail),
+	}
+
+	var err error
+	c.ds, err = gadgetCtx.RegisterDataSource(datasource.TypeEvent, ContainerLogsDataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("adding data source %q: %w", ContainerLogsDataSourceName, err)
+	}
+	if err := c.addFields(); err != nil {
+		return nil, fmt.Errorf("adding fields to data source %q: %w", ContainerLogsDataSourceName, err)
+	}
+	return c, nil
+}
+
+// bootOffset returns the difference between the wall clock and the clock used by eBPF programs
+func bootOffset() int64 {
+	var real, boot unix.Timespec
+	if unix.ClockGettime(unix.CLOCK_REALTIME, &real) != nil || unix.ClockGettime(unix.CLOCK_BOOTTIME, &boot) != nil {
+		return 0
+	}
+	return real.Nano() - boot.Nano()
+}
+
+func (c *ContainerLogs) addFields() error {
+	field := func(name, description string, kind api.Kind, flags datasource.FieldFlag, opts ...datasource.FieldOption) (datasource.FieldAccessor, error) {
+		opts = append(opts, datasource.WithKind(kind), datasource.WithFlags(flags),
+			datasource.WithAnnotations(map[string]string{"description": description}))
+		return c.ds.AddField(name, opts...)
+	}
+	var err error
+	if c.timestamp, err = field("timestamp", "Time the line was logged", api.Kind_Uint64, 0,
+		datasource.WithTags("type:"+formatters.TimestampTypeName)); err != nil {
+		return err
+	}
+	if c.k8s {
+		if c.namespace, err = field("namespace", "Namespace of the pod", api.Kind_String, 0); err != nil {
+			return err
+		}
+		if c.pod, err = field("pod", "Name of the pod", api.Kind_String, 0); err != nil {
+			return err
+		}
+	}
+	if c.container, err = field("container", "Name of the container", api.Kind_String, 0); err != nil {
+		return err
+	}
+	if c.containerID, err = field("containerID", "ID of the container", api.Kind_String, datasource.FieldFlagHidden); err != nil {
+		return err
+	}
+	if c.mntns, err = field("mntns_id", "Mount namespace inode id of the container", api.Kind_Uint64, datasource.FieldFlagHidden,
+		datasource.WithTags(compat.MntNsIdType)); err != nil {
+		return err
+	}
+	if c.stream, err = field("stream", "Stream the line was written to: stdout or stderr", api.Kind_String, 0); err != nil {
+		return err
+	}
+	c.message, err = field("message", "Line written by the container", api.Kind_String, 0)
+	return err
+}
+
+// Add starts emitting the log lines of the container; it's a no-op if its logs are already read
+func (c *ContainerLogs) Add(container *containercollection.Container) {
+	id := container.Runtime.ContainerID
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.tails[id]; ok || c.tails == nil {
+		return
+	}
+
+	path := containerLogPath(container)
+	if path == "" {
+		c.gadgetCtx.Logger().Warnf("no log file found for container %q: its logs won't be shown", containerName(container))
+		return
+	}
+	t := &logTail{path: path, done: make(chan struct{})}
+	c.tails[id] = t
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		err := t.run(func(line *logLine) {
+			if err := c.emit(container, line); err != nil {
+				c.gadgetCtx.Logger().Warnf("emitting log line of container %q: %v", containerName(container), err)
+			}
+		})
+		if err != nil {
+			c.gadgetCtx.Logger().Warnf("reading logs of container %q: %v", containerName(container), err)
+		}
+	}()
+}
+
+// Remove stops emitting the log lines of the container once the lines written so far are emitted
+func (c *ContainerLogs) Remove(container *containercollection.Container) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.tails[container.Runtime.ContainerID]; ok {
+		close(t.done)
+		delete(c.tails, container.Runtime.ContainerID)
+	}
+}
+
+// Close stops emitting the log lines of all containers and waits until it's done
+func (c *ContainerLogs) Close() {
+	c.mu.Lock()
+	for _, t := range c.tails {
+		close(t.done)
+	}
+	c.tails = nil
+	c.mu.Unlock()
+	c.wg.Wait()
+}
+
+func (c *ContainerLogs) emit(container *containercollection.Container, line *logLine) error {
+	bo := c.ds.ByteOrder()
+	ts := make([]byte, 8)
+	bo.PutUint64(ts, uint64(max(line.timestamp.UnixNano()-c.bootOffset, 0)))
+	mntns := make([]byte, 8)
+	bo.PutUint64(mntns, container.Mntns)
+
+	data := c.ds.NewData()
+	fields := []struct {
+		acc   datasource.FieldAccessor
+		value []byte
+	}{
+		{c.timestamp, ts},
+		{c.namespace, []byte(container.K8s.Namespace)},
+		{c.pod, []byte(container.K8s.PodName)},
+		{c.container, []byte(containerName(container))},
+		{c.containerID, []byte(container.Runtime.ContainerID)},
+		{c.mntns, mntns},
+		{c.stream, []byte(line.stream)},
+		{c.message, []byte(line.message)},
+	}
+	for _, f := range fields {
+		if f.acc == nil {
+			continue
+		}
+		if err := f.acc.Set(data, f.value); err != nil {
+			c.ds.Release(data)
+			return fmt.Errorf("setting field %q: %w", f.acc.Name(), err)
+		}
+	}
+	return c.ds.EmitAndRelease(data)
+}
+
+func containerName(container *containercollection.Container) string {
+	if container.K8s.ContainerName != "" {
+		return container.K8s.ContainerName
+	}
+	return container.Runtime.ContainerName
+}
+
+// containerLogPath returns the file the runtime writes the logs of the container to, or "" if it's unknown
+func containerLogPath(container *containercollection.Container) string {
+	if container.OciConfig != nil {
+		if path := container.OciConfig.Annotations[crioLogPathAnnotation]; path != "" {
+			return filepath.Join(host.HostRoot, path)
+		}
+	}
+
+	k8s := container.K8s
+	if k8s.Namespace != "" && k8s.PodName != "" && k8s.ContainerName != "" {
+		// CRI runtimes write to <namespace>_<pod>_<pod uid>/<container>/<restart count>.log; the newest file
+		// belongs to the running container
+		pattern := filepath.Join(host.HostRoot, podLogsDir, k8s.Namespace+"_"+k8s.PodName+"_*", k8s.ContainerName, "*.log")
+		matches, _ := filepath.Glob(pattern)
+		var newest string
+		var newestTime time.Time
+		for _, m := range matches {
+			fi, err := os.Stat(m)
+			if err == nil && fi.ModTime().After(newestTime) {
+				newest, newestTime = m, fi.ModTime()
+			}
+		}
+		if newest != "" {
+			return newest
+		}
+	}
+
+	if container.Runtime.RuntimeName == types.RuntimeNameDocker && container.Runtime.ContainerID != "" {
+		id := container.Runtime.ContainerID
+		path := filepath.Join(host.HostRoot, dockerContainersDir, id, id+"-json.log")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// logLine is a line written by a container
+type logLine struct {
+	timestamp time.Time
+	stream    string
+	message   string
+}
+
+// logTail reads the lines appended to a log file until done is closed, following it when it's rotated
+type logTail struct {
+	path string
+	done chan struct{}
+
+	// partial holds the beginning of lines split by the runtime, by stream
+	partial map[string]string
+}
+
+func (t *logTail) run(emit func(*logLine)) error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+	// Only lines written from now on are emitted
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(logPollInterval)
+	defer ticker.Stop()
+
+	parse := parseCRILogLine
+	if strings.HasSuffix(t.path, "-json.log") {
+		parse = parseDockerLogLine
+	}
+	t.partial = make(map[string]string)
+	var pending []byte
+	buf := make([]byte, 32*1024)
+	done := false
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			pending = append(pending, buf[:n]...)
+			pending = t.emitLines(pending, parse, emit)
+			continue
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		// Everything was read; the runtime rotates the file by renaming it and creating a new one
+		if rotated(f, t.path) {
+			nf, err := os.Open(t.path)
+			if err == nil {
+				f.Close()
+				f = nf
+				pending = nil
+				continue
+			}
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-t.done:
+			// Lines written until now are still emitted
+			done = true
+		case <-ticker.C:
+		}
+	}
+}
+
+// emitLines emits the complete lines of buf and returns the rest
+func (t *logTail) emitLines(buf []byte, parse func(string) (*logLine, bool, error), emit func(*logLine)) []byte {
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			if len(buf) < maxLogLineSize {
+				return buf
+			}
+			i = len(buf)
+		}
+		text := string(buf[:i])
+		buf = buf[min(i+1, len(buf)):]
+
+		line, partial, err := parse(text)
+		if err != nil {
+			continue
+		}
+		if partial {
+			t.partial[line.stream] += line.message
+			continue
+		}
+		line.message = t.partial[line.stream] + line.message
+		delete(t.partial, line.stream)
+		emit(line)
+	}
+}
+
+// rotated tells whether path refers to another file than f
+func rotated(f *os.File, path string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	pi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	fs, ok1 := fi.Sys().(*syscall.Stat_t)
+	ps, ok2 := pi.Sys().(*syscall.Stat_t)
+	return ok1 && ok2 && (fs.Ino != ps.Ino || fs.Dev != ps.Dev)
+}
+
+// parseCRILogLine parses a line of the CRI logging format, e.g. "2024-01-02T03:04:05.123456789Z stdout F message";
+// the tag P marks lines split by the runtime
+func parseCRILogLine(text string) (*logLine, bool, error) {
+	parts := strings.SplitN(text, " ", 4)
+	if len(parts) < 3 {
+		return nil, false, fmt.Errorf("invalid CRI log line %q", text)
+	}
+	ts, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid timestamp of CRI log line: %w", err)
+	}
+	line := &logLine{timestamp: ts, stream: parts[1]}
+	if len(parts) == 4 {
+		line.message = parts[3]
+	}
+	return line, strings.HasPrefix(parts[2], "P"), nil
+}
+
+// parseDockerLogLine parses a line of the json-file logging driver of Docker; lines not ending with a newline were
+// split by Docker
+func parseDockerLogLine(text string) (*logLine, bool, error) {
+	var entry struct {
+		Log    string    `json:"log"`
+		Stream string    `json:"stream"`
+		Time   time.Time `json:"time"`
+	}
+	if err := json.Unmarshal([]byte(text), &entry); err != nil {
+		return nil, false, fmt.Errorf("invalid docker log line: %w", err)
+	}
+	message, complete := strings.CutSuffix(entry.Log, "\n")
+	return &logLine{timestamp: entry.Time, stream: entry.Stream, message: message}, !complete, nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	ocispec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/types"
+)
+
+func TestParseLogLines(t *testing.T) {
+	line, partial, err := parseCRILogLine("2024-01-02T03:04:05.123456789Z stderr F something failed: x")
+	require.NoError(t, err)
+	require.False(t, partial)
+	require.Equal(t, "stderr", line.stream)
+	require.Equal(t, "something failed: x", line.message)
+	require.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC), line.timestamp)
+
+	_, partial, err = parseCRILogLine("2024-01-02T03:04:05Z stdout P part")
+	require.NoError(t, err)
+	require.True(t, partial)
+
+	_, _, err = parseCRILogLine("not a log line")
+	require.Error(t, err)
+
+	line, partial, err = parseDockerLogLine(`{"log":"hello world\n","stream":"stdout","time":"2024-01-02T03:04:05.5Z"}`)
+	require.NoError(t, err)
+	require.False(t, partial)
+	require.Equal(t, "hello world", line.message)
+
+	_, partial, err = parseDockerLogLine(`{"log":"hello","stream":"stdout","time":"2024-01-02T03:04:05.5Z"}`)
+	require.NoError(t, err)
+	require.True(t, partial)
+}
+
+func TestContainerLogs(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	c, err := NewContainerLogs(gadgetCtx, true)
+	require.NoError(t, err)
+	ds := gadgetCtx.GetDataSources()[ContainerLogsDataSourceName]
+	require.NotNil(t, ds)
+
+	pod := ds.GetField("pod")
+	stream := ds.GetField("stream")
+	message := ds.GetField("message")
+	var mu sync.Mutex
+	var lines []string
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, pod.String(data)+" "+stream.String(data)+" "+message.String(data))
+		return nil
+	}, 0)
+
+	path := filepath.Join(t.TempDir(), "0.log")
+	require.NoError(t, os.WriteFile(path, []byte("2024-01-02T03:04:05Z stdout F before\n"), 0o644))
+	container := &containercollection.Container{
+		Runtime:   containercollection.RuntimeMetadata{BasicRuntimeMetadata: types.BasicRuntimeMetadata{ContainerID: "abc"}},
+		K8s:       containercollection.K8sMetadata{BasicK8sMetadata: types.BasicK8sMetadata{PodName: "pod", ContainerName: "app"}},
+		OciConfig: &ocispec.Spec{Annotations: map[string]string{crioLogPathAnnotation: path}},
+	}
+	c.Add(container)
+	// Lines are only emitted once the tail reached the end of the file
+	time.Sleep(2 * logPollInterval)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("2024-01-02T03:04:06Z stdout P hello \n2024-01-02T03:04:06Z stderr F oops\n2024-01-02T03:04:06Z stdout F world\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Lines written before the container is removed are still emitted
+	c.Remove(container)
+	c.Close()
+	require.Equal(t, []string{"pod stderr oops", "pod stdout hello world"}, lines)
+}
+
+func TestContainerLogPath(t *testing.T) {
+	dir := t.TempDir()
+	oldPodLogsDir, oldDockerContainersDir := podLogsDir, dockerContainersDir
+	podLogsDir, dockerContainersDir = filepath.Join(dir, "pods"), filepath.Join(dir, "docker")
+	t.Cleanup(func() { podLogsDir, dockerContainersDir = oldPodLogsDir, oldDockerContainersDir })
+
+	// The newest file belongs to the running container
+	logDir := filepath.Join(podLogsDir, "default_pod_1234", "app")
+	require.NoError(t, os.MkdirAll(logDir, 0o755))
+	for i, name := range []string{"0.log", "1.log"} {
+		path := filepath.Join(logDir, name)
+		require.NoError(t, os.WriteFile(path, nil, 0o644))
+		mtime := time.Now().Add(time.Duration(i-2) * time.Minute)
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+	require.Equal(t, filepath.Join(logDir, "1.log"), containerLogPath(&containercollection.Container{
+		K8s: containercollection.K8sMetadata{BasicK8sMetadata: types.BasicK8sMetadata{Namespace: "default", PodName: "pod", ContainerName: "app"}},
+	}))
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dockerContainersDir, "abc"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dockerContainersDir, "abc", "abc-json.log"), nil, 0o644))
+	docker := &containercollection.Container{
+		Runtime: containercollection.RuntimeMetadata{BasicRuntimeMetadata: types.BasicRuntimeMetadata{
+			RuntimeName: types.RuntimeNameDocker,
+			ContainerID: "abc",
+		}},
+	}
+	require.Equal(t, filepath.Join(dockerContainersDir, "abc", "abc-json.log"), containerLogPath(docker))
+
+	docker.Runtime.ContainerID = "unknown"
+	require.Empty(t, containerLogPath(docker))
+}
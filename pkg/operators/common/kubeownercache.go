@@ -0,0 +1,258 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/k8sutil"
+)
+
+// K8sOwnerCache resolves the workload owning a pod, e.g. the Deployment of a pod created by a ReplicaSet. It uses
+// shared informers that only keep the metadata of pods, ReplicaSets and Jobs.
+type K8sOwnerCache interface {
+	Start()
+	Stop()
+
+	// GetPodOwner returns the kind and name of the top-level controller of the pod, or empty strings if the pod
+	// isn't known or has no controller
+	GetPodOwner(namespace string, name string) (kind string, ownerName string)
+}
+
+type podOwner struct {
+	kind string
+	name string
+}
+
+type ownerCache struct {
+	client metadata.Interface
+
+	factory metadatainformer.SharedInformerFactory
+	exit    chan struct{}
+
+	// mu protects the listers and owners
+	mu          sync.Mutex
+	pods        k8sCache.GenericLister
+	replicaSets k8sCache.GenericLister
+	jobs        k8sCache.GenericLister
+
+	// owners caches the resolved owners of pods by namespace/name; it's cleared when it reaches maxOwners entries
+	owners    map[string]podOwner
+	maxOwners int
+
+	useCount      int
+	useCountMutex sync.Mutex
+}
+
+const (
+	maxPodOwners = 8192
+
+	// ownerSyncTimeout limits the time Start waits for the informers, e.g. if listing ReplicaSets isn't allowed;
+	// owners of unknown pods are simply left empty
+	ownerSyncTimeout = 10 * time.Second
+)
+
+var (
+	podsResource        = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	replicaSetsResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}
+	jobsResource        = schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}
+)
+
+var (
+	k8sOwnerSingleton *ownerCache
+	k8sOwnerErr       error
+	k8sOwnerOnce      sync.Once
+)
+
+func GetK8sOwnerCache() (K8sOwnerCache, error) {
+	k8sOwnerOnce.Do(func() {
+		k8sOwnerSingleton, k8sOwnerErr = newOwnerCache()
+	})
+	return k8sOwnerSingleton, k8sOwnerErr
+}
+
+func newOwnerCache() (*ownerCache, error) {
+	config, err := k8sutil.NewKubeConfig("")
+	if err != nil {
+		return nil, fmt.Errorf("creating new k8s config: %w", err)
+	}
+	client, err := metadata.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating new k8s metadata client: %w", err)
+	}
+
+	return &ownerCache{
+		client:    client,
+		owners:    make(map[string]podOwner),
+		maxOwners: maxPodOwners,
+	}, nil
+}
+
+// stripMetadata only keeps the parts of the metadata needed to resolve owners, to keep the memory usage of the
+// informers low on big clusters
+func stripMetadata(obj any) (any, error) {
+	m, ok := obj.(*metav1.PartialObjectMetadata)
+	if !ok {
+		return obj, nil
+	}
+	return &metav1.PartialObjectMetadata{
+		TypeMeta: m.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            m.Name,
+			Namespace:       m.Namespace,
+			UID:             m.UID,
+			ResourceVersion: m.ResourceVersion,
+			OwnerReferences: m.OwnerReferences,
+		},
+	}, nil
+}
+
+func (cache *ownerCache) Start() {
+	cache.useCountMutex.Lock()
+	defer cache.useCountMutex.Unlock()
+
+	// No uses before us, we are the first one
+	if cache.useCount == 0 {
+		cache.factory = metadatainformer.NewSharedInformerFactoryWithOptions(cache.client, informerResync,
+			metadatainformer.WithTransform(stripMetadata))
+		pods := cache.factory.ForResource(podsResource)
+		pods.Informer().AddEventHandler(k8sCache.ResourceEventHandlerFuncs{
+			DeleteFunc: cache.onPodDelete,
+		})
+		replicaSets := cache.factory.ForResource(replicaSetsResource)
+		jobs := cache.factory.ForResource(jobsResource)
+
+		cache.mu.Lock()
+		cache.pods = pods.Lister()
+		cache.replicaSets = replicaSets.Lister()
+		cache.jobs = jobs.Lister()
+		clear(cache.owners)
+		cache.mu.Unlock()
+
+		cache.exit = make(chan struct{})
+		cache.factory.Start(cache.exit)
+
+		ctx, cancel := context.WithTimeout(context.Background(), ownerSyncTimeout)
+		for gvr, synced := range cache.factory.WaitForCacheSync(ctx.Done()) {
+			if !synced {
+				log.Warnf("k8s owner cache: timed out waiting for %s", gvr.Resource)
+			}
+		}
+		cancel()
+	}
+	cache.useCount++
+}
+
+func (cache *ownerCache) Stop() {
+	cache.useCountMutex.Lock()
+	defer cache.useCountMutex.Unlock()
+
+	// We are the last user, stop everything
+	if cache.useCount == 1 {
+		close(cache.exit)
+		cache.factory.Shutdown()
+		cache.factory = nil
+	}
+	cache.useCount--
+}
+
+func (cache *ownerCache) onPodDelete(obj any) {
+	if d, ok := obj.(k8sCache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	pod, ok := obj.(*metav1.PartialObjectMetadata)
+	if !ok {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	delete(cache.owners, pod.Namespace+"/"+pod.Name)
+}
+
+func (cache *ownerCache) GetPodOwner(namespace string, name string) (string, string) {
+	if namespace == "" || name == "" {
+		return "", ""
+	}
+
+	key := namespace + "/" + name
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if owner, ok := cache.owners[key]; ok {
+		return owner.kind, owner.name
+	}
+	if cache.pods == nil {
+		return "", ""
+	}
+
+	owner, ok := cache.resolve(namespace, name)
+	if !ok {
+		// Don't cache pods or owners the informers don't know (yet)
+		return owner.kind, owner.name
+	}
+	if len(cache.owners) >= cache.maxOwners {
+		clear(cache.owners)
+	}
+	cache.owners[key] = owner
+	return owner.kind, owner.name
+}
+
+// resolve follows the controller references of the pod up to the top-level workload. ReplicaSets and Jobs are looked
+// up to find the Deployment or CronJob controlling them; other kinds are reported as they are. It returns false if an
+// object couldn't be found.
+func (cache *ownerCache) resolve(namespace, name string) (podOwner, bool) {
+	obj, err := cache.pods.ByNamespace(namespace).Get(name)
+	if err != nil {
+		return podOwner{}, false
+	}
+
+	var owner podOwner
+	for {
+		meta, ok := obj.(*metav1.PartialObjectMetadata)
+		if !ok {
+			return owner, false
+		}
+		ref := metav1.GetControllerOfNoCopy(meta)
+		if ref == nil {
+			return owner, true
+		}
+		owner = podOwner{kind: ref.Kind, name: ref.Name}
+
+		var lister k8sCache.GenericLister
+		switch ref.Kind {
+		case "ReplicaSet":
+			lister = cache.replicaSets
+		case "Job":
+			lister = cache.jobs
+		default:
+			return owner, true
+		}
+		obj, err = lister.ByNamespace(namespace).Get(ref.Name)
+		if err != nil {
+			return owner, false
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8sCache "k8s.io/client-go/tools/cache"
+)
+
+func newTestLister(t *testing.T, gvr schema.GroupVersionResource, objs ...*metav1.PartialObjectMetadata) k8sCache.GenericLister {
+	indexer := k8sCache.NewIndexer(k8sCache.MetaNamespaceKeyFunc, k8sCache.Indexers{
+		k8sCache.NamespaceIndex: k8sCache.MetaNamespaceIndexFunc,
+	})
+	for _, obj := range objs {
+		require.NoError(t, indexer.Add(obj))
+	}
+	return k8sCache.NewGenericLister(indexer, gvr.GroupResource())
+}
+
+func newTestObject(name string, owners ...metav1.OwnerReference) *metav1.PartialObjectMetadata {
+	return &metav1.PartialObjectMetadata{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "default",
+			OwnerReferences: owners,
+		},
+	}
+}
+
+func controllerRef(kind, name string) metav1.OwnerReference {
+	controller := true
+	return metav1.OwnerReference{Kind: kind, Name: name, Controller: &controller}
+}
+
+func TestOwnerCache(t *testing.T) {
+	cache := &ownerCache{
+		owners:    make(map[string]podOwner),
+		maxOwners: 3,
+		pods: newTestLister(t, podsResource,
+			newTestObject("web-5d8f9-abcde", controllerRef("ReplicaSet", "web-5d8f9")),
+			newTestObject("agent-xyz", controllerRef("DaemonSet", "agent")),
+			newTestObject("backup-28000-abc", controllerRef("Job", "backup-28000")),
+			newTestObject("migrate-abc", controllerRef("Job", "migrate")),
+			newTestObject("bare-rs-abc", controllerRef("ReplicaSet", "bare-rs")),
+			newTestObject("orphan-abc", controllerRef("ReplicaSet", "deleted")),
+			newTestObject("standalone"),
+			// Only controllers are taken into account
+			newTestObject("owned", metav1.OwnerReference{Kind: "ConfigMap", Name: "cm"}),
+		),
+		replicaSets: newTestLister(t, replicaSetsResource,
+			newTestObject("web-5d8f9", controllerRef("Deployment", "web")),
+			newTestObject("bare-rs"),
+		),
+		jobs: newTestLister(t, jobsResource,
+			newTestObject("backup-28000", controllerRef("CronJob", "backup")),
+			newTestObject("migrate"),
+		),
+	}
+
+	for _, tc := range []struct {
+		pod  string
+		kind string
+		name string
+	}{
+		{"web-5d8f9-abcde", "Deployment", "web"},
+		{"agent-xyz", "DaemonSet", "agent"},
+		{"backup-28000-abc", "CronJob", "backup"},
+		{"migrate-abc", "Job", "migrate"},
+		{"bare-rs-abc", "ReplicaSet", "bare-rs"},
+		{"orphan-abc", "ReplicaSet", "deleted"},
+		{"standalone", "", ""},
+		{"owned", "", ""},
+		{"unknown", "", ""},
+	} {
+		t.Run(tc.pod, func(t *testing.T) {
+			kind, name := cache.GetPodOwner("default", tc.pod)
+			require.Equal(t, tc.kind, kind)
+			require.Equal(t, tc.name, name)
+		})
+	}
+
+	// The cache is bounded and unknown objects aren't cached
+	require.LessOrEqual(t, len(cache.owners), cache.maxOwners)
+	require.NotContains(t, cache.owners, "default/unknown")
+	require.NotContains(t, cache.owners, "default/orphan-abc")
+
+	// Deleted pods are dropped from the cache
+	cache.GetPodOwner("default", "agent-xyz")
+	require.Contains(t, cache.owners, "default/agent-xyz")
+	cache.onPodDelete(k8sCache.DeletedFinalStateUnknown{Obj: newTestObject("agent-xyz")})
+	require.NotContains(t, cache.owners, "default/agent-xyz")
+}
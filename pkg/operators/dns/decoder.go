@@ -0,0 +1,79 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dns
+
+import (
+	"fmt"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Message contains the decoded parts of a DNS message that are relevant for tracing
+type Message struct {
+	ID       uint16
+	Response bool
+
+	// Name and QType are taken from the first question
+	Name  string
+	QType string
+
+	// Rcode is only set for responses
+	Rcode string
+
+	// Answers contains the data of all answers: addresses for A and AAAA records, the target name for CNAME, PTR and
+	// NS records and the type for everything else
+	Answers []string
+}
+
+// Decode decodes a DNS message as sent over UDP (without the length prefix used by TCP). Compressed names are
+// resolved; truncated or otherwise malformed messages result in an error. Trailing bytes after the message (e.g.
+// when the packet has been copied to a fixed size buffer) are ignored.
+func Decode(packet []byte) (*Message, error) {
+	dns := layers.DNS{}
+	if err := dns.DecodeFromBytes(packet, gopacket.NilDecodeFeedback); err != nil {
+		return nil, fmt.Errorf("decoding dns message: %w", err)
+	}
+
+	msg := &Message{
+		ID:       dns.ID,
+		Response: dns.QR,
+	}
+	if dns.QR {
+		msg.Rcode = dns.ResponseCode.String()
+	}
+	if len(dns.Questions) > 0 {
+		msg.Name = string(dns.Questions[0].Name) + "."
+		msg.QType = dns.Questions[0].Type.String()
+	}
+
+	for _, answer := range dns.Answers {
+		switch answer.Type {
+		case layers.DNSTypeA, layers.DNSTypeAAAA:
+			if answer.IP != nil {
+				msg.Answers = append(msg.Answers, answer.IP.String())
+			}
+		case layers.DNSTypeCNAME:
+			msg.Answers = append(msg.Answers, string(answer.CNAME)+".")
+		case layers.DNSTypePTR:
+			msg.Answers = append(msg.Answers, string(answer.PTR)+".")
+		case layers.DNSTypeNS:
+			msg.Answers = append(msg.Answers, string(answer.NS)+".")
+		default:
+			msg.Answers = append(msg.Answers, answer.Type.String())
+		}
+	}
+	return msg, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+var (
+	// Query for example.com, type A
+	query = []byte{
+		0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'c', 'o', 'm', 0x00,
+		0x00, 0x01, 0x00, 0x01,
+	}
+
+	// Response for www.example.com with a CNAME to example.com and an A record; all names after the question
+	// use compression pointers
+	response = []byte{
+		0x12, 0x34, 0x81, 0x80, 0x00, 0x01, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00,
+		// question at offset 12: www.example.com (example.com starts at offset 16)
+		0x03, 'w', 'w', 'w', 0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'c', 'o', 'm', 0x00,
+		0x00, 0x01, 0x00, 0x01,
+		// CNAME www.example.com -> example.com
+		0xc0, 0x0c, 0x00, 0x05, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x02, 0xc0, 0x10,
+		// A example.com -> 93.184.216.34
+		0xc0, 0x10, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x04, 93, 184, 216, 34,
+	}
+
+	// Response whose answer name points to itself
+	pointerLoop = []byte{
+		0x12, 0x34, 0x81, 0x80, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0xc0, 0x0c, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x04, 1, 2, 3, 4,
+	}
+)
+
+func TestDecode(t *testing.T) {
+	msg, err := Decode(query)
+	require.NoError(t, err)
+	require.Equal(t, &Message{ID: 0x1234, Name: "example.com.", QType: "A"}, msg)
+
+	// Trailing bytes of fixed size buffers must be ignored
+	msg, err = Decode(append(response, make([]byte, 64)...))
+	require.NoError(t, err)
+	require.Equal(t, &Message{
+		ID:       0x1234,
+		Response: true,
+		Name:     "www.example.com.",
+		QType:    "A",
+		Rcode:    "No Error",
+		Answers:  []string{"example.com.", "93.184.216.34"},
+	}, msg)
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	for name, packet := range map[string][]byte{
+		"empty":        nil,
+		"header only":  query[:12],
+		"truncated":    query[:20],
+		"no answers":   response[:len(response)-10],
+		"pointer loop": pointerLoop,
+	} {
+		_, err := Decode(packet)
+		require.Error(t, err, name)
+	}
+}
+
+func TestDecodedFields(t *testing.T) {
+	ds := datasource.New(datasource.TypeEvent, "dns")
+	packet, err := ds.AddField("dns")
+	require.NoError(t, err)
+	require.NoError(t, addDecodedFields(ds, packet))
+
+	data := ds.NewData()
+	require.NoError(t, packet.Set(data, response))
+	require.Equal(t, "www.example.com.", ds.GetField("dns_name").String(data))
+	require.Equal(t, "R", ds.GetField("dns_qr").String(data))
+	require.Equal(t, "example.com.,93.184.216.34", ds.GetField("dns_answers").String(data))
+	require.Equal(t, uint16(2), ds.GetField("dns_num_answers").Uint16(data))
+	require.Equal(t, uint16(0x1234), ds.GetField("dns_id").Uint16(data))
+	require.Empty(t, ds.GetField("dns_error").String(data))
+	require.Equal(t, api.Kind_String, ds.GetField("dns_rcode").Type())
+
+	data = ds.NewData()
+	require.NoError(t, packet.Set(data, query[:20]))
+	require.Empty(t, ds.GetField("dns_name").String(data))
+	require.NotEmpty(t, ds.GetField("dns_error").String(data))
+}
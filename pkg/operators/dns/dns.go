@@ -0,0 +1,259 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dns provides an operator that decodes raw DNS messages captured by gadgets on the host, so gadgets don't
+// have to parse names, types and answers in eBPF or wasm. Decoding is lazy: messages are only parsed if one of the
+// resulting fields is read.
+package dns
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/ioc"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	OperatorName = "dns"
+
+	// AnnotationPacket marks a field containing a raw DNS message
+	AnnotationPacket = "dns.packet"
+
+	// Priority is chosen so that the decoded name is available before the ioc operator runs
+	Priority = ioc.Priority - 100
+)
+
+type dnsOperator struct{}
+
+func (o *dnsOperator) Name() string {
+	return OperatorName
+}
+
+func (o *dnsOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *dnsOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *dnsOperator) InstanceParams() api.Params {
+	return nil
+}
+
+// decodedField describes a field that is filled from a decoded message
+type decodedField struct {
+	name        string
+	kind        api.Kind
+	annotations map[string]string
+	hidden      bool
+	value       func(msg *Message, err error, bo binary.ByteOrder) []byte
+}
+
+func uint16Bytes(bo binary.ByteOrder, v uint16) []byte {
+	b := make([]byte, 2)
+	bo.PutUint16(b, v)
+	return b
+}
+
+var decodedFields = []decodedField{
+	{
+		name:        "id",
+		kind:        api.Kind_Uint16,
+		annotations: map[string]string{"description": "ID of the DNS message"},
+		hidden:      true,
+		value: func(msg *Message, err error, bo binary.ByteOrder) []byte {
+			if err != nil {
+				return uint16Bytes(bo, 0)
+			}
+			return uint16Bytes(bo, msg.ID)
+		},
+	},
+	{
+		name:        "qr",
+		kind:        api.Kind_String,
+		annotations: map[string]string{"description": "Q for queries, R for responses", "columns.width": "2"},
+		value: func(msg *Message, err error, bo binary.ByteOrder) []byte {
+			switch {
+			case err != nil:
+				return nil
+			case msg.Response:
+				return []byte("R")
+			default:
+				return []byte("Q")
+			}
+		},
+	},
+	{
+		name: "name",
+		kind: api.Kind_String,
+		annotations: map[string]string{
+			"description":      "Queried name",
+			"columns.width":    "30",
+			ioc.AnnotationType: string(ioc.KindDomain),
+		},
+		value: func(msg *Message, err error, bo binary.ByteOrder) []byte {
+			if err != nil {
+				return nil
+			}
+			return []byte(msg.Name)
+		},
+	},
+	{
+		name:        "qtype",
+		kind:        api.Kind_String,
+		annotations: map[string]string{"description": "Type of the query", "columns.width": "8"},
+		value: func(msg *Message, err error, bo binary.ByteOrder) []byte {
+			if err != nil {
+				return nil
+			}
+			return []byte(msg.QType)
+		},
+	},
+	{
+		name:        "rcode",
+		kind:        api.Kind_String,
+		annotations: map[string]string{"description": "Response code", "columns.width": "8"},
+		value: func(msg *Message, err error, bo binary.ByteOrder) []byte {
+			if err != nil {
+				return nil
+			}
+			return []byte(msg.Rcode)
+		},
+	},
+	{
+		name:        "answers",
+		kind:        api.Kind_String,
+		annotations: map[string]string{"description": "Comma-separated list of answers", "columns.width": "32"},
+		value: func(msg *Message, err error, bo binary.ByteOrder) []byte {
+			if err != nil {
+				return nil
+			}
+			return []byte(strings.Join(msg.Answers, ","))
+		},
+	},
+	{
+		name:        "num_answers",
+		kind:        api.Kind_Uint16,
+		annotations: map[string]string{"description": "Number of answers"},
+		hidden:      true,
+		value: func(msg *Message, err error, bo binary.ByteOrder) []byte {
+			if err != nil {
+				return uint16Bytes(bo, 0)
+			}
+			return uint16Bytes(bo, uint16(len(msg.Answers)))
+		},
+	},
+	{
+		name:        "error",
+		kind:        api.Kind_String,
+		annotations: map[string]string{"description": "Error decoding the DNS message"},
+		hidden:      true,
+		value: func(msg *Message, err error, bo binary.ByteOrder) []byte {
+			if err != nil {
+				return []byte(err.Error())
+			}
+			return nil
+		},
+	},
+}
+
+// addDecodedFields adds the fields for the DNS message in packet to ds, e.g. "dns" -> "dns_name"
+func addDecodedFields(ds datasource.DataSource, packet datasource.FieldAccessor) error {
+	accessors := make([]datasource.FieldAccessor, len(decodedFields))
+	for i, df := range decodedFields {
+		options := []datasource.FieldOption{
+			datasource.WithKind(df.kind),
+			datasource.WithAnnotations(df.annotations),
+		}
+		if df.hidden {
+			options = append(options, datasource.WithFlags(datasource.FieldFlagHidden))
+		}
+		acc, err := ds.AddField(packet.Name()+"_"+df.name, options...)
+		if err != nil {
+			return err
+		}
+		accessors[i] = acc
+	}
+
+	// All fields are filled once the first one of them is read, so the message is only decoded once per Data
+	for i, acc := range accessors {
+		err := acc.SetDecoder(func(ds datasource.DataSource, data datasource.Data) []byte {
+			msg, err := Decode(packet.Get(data))
+			for j, other := range accessors {
+				if j != i {
+					other.Set(data, decodedFields[j].value(msg, err, ds.ByteOrder()))
+				}
+			}
+			return decodedFields[i].value(msg, err, ds.ByteOrder())
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *dnsOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	found := false
+	for _, ds := range gadgetCtx.GetDataSources() {
+		for _, f := range ds.Accessors(false) {
+			if v, ok := f.Annotations()[AnnotationPacket]; !ok || v != "true" {
+				continue
+			}
+			if err := addDecodedFields(ds, f); err != nil {
+				return nil, err
+			}
+			// The raw message is not useful for users
+			f.SetHidden(true, false)
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return &dnsOperatorInstance{}, nil
+}
+
+func (o *dnsOperator) Priority() int {
+	return Priority
+}
+
+// dnsOperatorInstance doesn't need to subscribe to anything, as fields are decoded when they're read
+type dnsOperatorInstance struct{}
+
+func (o *dnsOperatorInstance) Name() string {
+	return OperatorName
+}
+
+func (o *dnsOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+func (o *dnsOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+func (o *dnsOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+func init() {
+	operators.RegisterDataOperator(&dnsOperator{})
+}
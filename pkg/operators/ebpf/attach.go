@@ -23,7 +23,6 @@ import (
 	"github.com/cilium/ebpf/link"
 
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
-	"github.com/inspektor-gadget/inspektor-gadget/pkg/uprobetracer"
 )
 
 const (
@@ -47,18 +46,17 @@ func (i *ebpfInstance) attachProgram(gadgetCtx operators.GadgetContext, p *ebpf.
 		case strings.HasPrefix(p.SectionName, kretprobePrefix):
 			i.logger.Debugf("Attaching kretprobe %q to %q", p.Name, p.AttachTo)
 			return link.Kretprobe(p.AttachTo, prog, nil)
-		case strings.HasPrefix(p.SectionName, uprobePrefix) ||
-			strings.HasPrefix(p.SectionName, uretprobePrefix) ||
-			strings.HasPrefix(p.SectionName, usdtPrefix):
-			uprobeTracer := i.uprobeTracers[p.Name]
-			switch strings.Split(p.SectionName, "/")[0] {
-			case "uprobe":
-				return nil, uprobeTracer.AttachProg(p.Name, uprobetracer.ProgUprobe, p.AttachTo, prog)
-			case "uretprobe":
-				return nil, uprobeTracer.AttachProg(p.Name, uprobetracer.ProgUretprobe, p.AttachTo, prog)
-			case "usdt":
-				return nil, uprobeTracer.AttachProg(p.Name, uprobetracer.ProgUSDT, p.AttachTo, prog)
+		default:
+			progType, ok := uprobeProgType(p)
+			if !ok {
+				break
 			}
+			target := p.AttachTo
+			if t, ok := i.uprobeTargets[p.Name]; ok {
+				target = t
+			}
+			i.logger.Debugf("Attaching uprobe %q to %q", p.Name, target)
+			return nil, i.uprobeTracers[p.Name].AttachProg(p.Name, progType, target, prog)
 		}
 		return nil, fmt.Errorf("unsupported section name %q for program %q", p.SectionName, p.Name)
 	case ebpf.TracePoint:
@@ -51,6 +51,18 @@ func byteSliceAsUint64(in []byte, signed bool, ds datasource.DataSource) uint64
 	return 0
 }
 
+// enumNames returns the names of the values of enum
+func enumNames(enum *btf.Enum) map[uint64]string {
+	names := make(map[uint64]string, len(enum.Values))
+	for _, v := range enum.Values {
+		// Keep the first name of values that have aliases
+		if _, ok := names[v.Value]; !ok {
+			names[v.Value] = v.Name
+		}
+	}
+	return names
+}
+
 func (i *ebpfInstance) initEnumConverter(gadgetCtx operators.GadgetContext) error {
 	btfSpec, err := btf.LoadKernelSpec()
 	if err != nil {
@@ -58,8 +70,6 @@ func (i *ebpfInstance) initEnumConverter(gadgetCtx operators.GadgetContext) erro
 	}
 
 	for _, ds := range gadgetCtx.GetDataSources() {
-		var converters []func(ds datasource.DataSource, data datasource.Data) error
-
 		for name, enum := range i.enums {
 			in := ds.GetField(name)
 			if in == nil {
@@ -75,30 +85,48 @@ func (i *ebpfInstance) initEnumConverter(gadgetCtx operators.GadgetContext) erro
 				}
 			}
 
+			names := enumNames(enum)
+			decode := func(val uint64) string {
+				if name, ok := names[val]; ok {
+					return name
+				}
+				return "UNKNOWN"
+			}
+			var dropReasons *dropReasonDecoder
+			if enum.Name == dropReasonEnum {
+				dropReasons = newDropReasonDecoder(enum, btfSpec)
+				decode = dropReasons.name
+			}
+
 			out, err := ds.AddField(name + "_str")
 			if err != nil {
 				return err
 			}
 
-			converter := func(ds datasource.DataSource, data datasource.Data) error {
-				// TODO: lookup table?
-				inBytes := in.Get(data)
-				val := byteSliceAsUint64(inBytes, enum.Signed, ds)
-				for _, v := range enum.Values {
-					if val == v.Value {
-						out.Set(data, []byte(v.Name))
-						return nil
-					}
-				}
-				out.Set(data, []byte("UNKNOWN"))
-				return nil
+			// Resolve the name only if somebody reads the field
+			err = out.SetDecoder(func(ds datasource.DataSource, data datasource.Data) []byte {
+				return []byte(decode(byteSliceAsUint64(in.Get(data), enum.Signed, ds)))
+			})
+			if err != nil {
+				return fmt.Errorf("setting decoder for %q: %w", out.Name(), err)
 			}
 
-			converters = append(converters, converter)
-		}
-
-		if len(converters) > 0 {
-			i.converters[ds] = converters
+			if dropReasons == nil {
+				continue
+			}
+			desc, err := ds.AddField(name+"_desc",
+				datasource.WithAnnotations(map[string]string{
+					"description": "Description of the reason the packet was dropped for",
+				}))
+			if err != nil {
+				return err
+			}
+			err = desc.SetDecoder(func(ds datasource.DataSource, data datasource.Data) []byte {
+				return []byte(dropReasons.description(byteSliceAsUint64(in.Get(data), enum.Signed, ds)))
+			})
+			if err != nil {
+				return fmt.Errorf("setting decoder for %q: %w", desc.Name(), err)
+			}
 		}
 	}
 
@@ -0,0 +1,180 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpfoperator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+const (
+	// dropReasonEnum is the enum of the reasons the kernel drops packets for, as reported by the skb/kfree_skb
+	// tracepoint; see include/gadget/drop_reason.h
+	dropReasonEnum = "skb_drop_reason"
+
+	// dropReasonSubsysEnum is the enum of the subsystems that define reasons of their own in the upper bits of the
+	// reason, e.g. mac80211 or openvswitch
+	dropReasonSubsysEnum  = "skb_drop_reason_subsys"
+	dropReasonSubsysShift = 16
+
+	dropReasonPrefix       = "SKB_DROP_REASON_"
+	dropReasonSubsysPrefix = "SKB_DROP_REASON_SUBSYS_"
+)
+
+// dropReasonDescriptions describe the reasons of the kernel to drop packets, keyed by their names without the
+// SKB_DROP_REASON_ prefix. They're taken from include/net/dropreason-core.h of the kernel.
+var dropReasonDescriptions = map[string]string{
+	"NOT_SPECIFIED":            "drop reason is not specified",
+	"NO_SOCKET":                "no socket matches the packet",
+	"PKT_TOO_SMALL":            "packet size is too small",
+	"TCP_CSUM":                 "TCP checksum error",
+	"SOCKET_FILTER":            "dropped by a socket filter",
+	"UDP_CSUM":                 "UDP checksum error",
+	"NETFILTER_DROP":           "dropped by netfilter",
+	"OTHERHOST":                "packet doesn't belong to the current host (interface is in promiscuous mode)",
+	"IP_CSUM":                  "IP checksum error",
+	"IP_INHDR":                 "invalid IP header",
+	"IP_RPFILTER":              "reverse path filter validation failed",
+	"UNICAST_IN_L2_MULTICAST":  "L2 destination is multicast but L3 destination is unicast",
+	"XFRM_POLICY":              "xfrm policy check failed",
+	"IP_NOPROTO":               "IP protocol isn't supported",
+	"SOCKET_RCVBUFF":           "receive buffer of the socket is full",
+	"PROTO_MEM":                "memory limit of the protocol reached, e.g. tcp_mem",
+	"TCP_AUTH_HDR":             "TCP-MD5 or TCP-AO hashes are missing or wrong",
+	"TCP_MD5NOTFOUND":          "no MD5 hash but one was expected",
+	"TCP_MD5UNEXPECTED":        "MD5 hash but none was expected",
+	"TCP_MD5FAILURE":           "MD5 hash is wrong",
+	"SOCKET_BACKLOG":           "failed to add the packet to the backlog of the socket",
+	"TCP_FLAGS":                "invalid TCP flags",
+	"TCP_ZEROWINDOW":           "TCP receive window is zero",
+	"TCP_OLD_DATA":             "TCP data was already received",
+	"TCP_OVERWINDOW":           "TCP data is out of the receive window",
+	"TCP_OFOMERGE":             "TCP data is already in the out of order queue",
+	"TCP_RFC7323_PAWS":         "TCP PAWS check failed",
+	"TCP_OLD_SEQUENCE":         "TCP SACK is old",
+	"TCP_INVALID_SEQUENCE":     "TCP sequence number isn't acceptable",
+	"TCP_INVALID_ACK_SEQUENCE": "TCP acknowledgment number isn't acceptable",
+	"TCP_RESET":                "invalid TCP RST packet",
+	"TCP_INVALID_SYN":          "unexpected TCP SYN flag",
+	"TCP_CLOSE":                "TCP socket is closed",
+	"TCP_FASTOPEN":             "dropped by a TCP Fast Open request socket",
+	"TCP_OLD_ACK":              "TCP ACK is old",
+	"TCP_TOO_OLD_ACK":          "TCP ACK is too old",
+	"TCP_ACK_UNSENT_DATA":      "TCP ACK for data that wasn't sent yet",
+	"TCP_OFO_QUEUE_PRUNE":      "pruned from the TCP out of order queue",
+	"TCP_OFO_DROP":             "TCP data is already in the receive queue",
+	"TCP_MINTTL":               "TTL or hop limit below the minimum of the socket",
+	"IP_OUTNOROUTES":           "no route for outgoing packet",
+	"BPF_CGROUP_EGRESS":        "dropped by a cgroup eBPF program on egress",
+	"IPV6DISABLED":             "IPv6 is disabled on the device",
+	"NEIGH_CREATEFAIL":         "failed to create a neighbour entry",
+	"NEIGH_FAILED":             "neighbour entry is in failed state",
+	"NEIGH_QUEUEFULL":          "queue of the neighbour entry is full",
+	"NEIGH_DEAD":               "neighbour entry is dead",
+	"TC_EGRESS":                "dropped by a TC egress hook",
+	"SECURITY_HOOK":            "dropped by a security hook",
+	"QDISC_DROP":               "dropped by a qdisc",
+	"CPU_BACKLOG":              "failed to add the packet to the backlog queue of the CPU",
+	"XDP":                      "dropped by XDP",
+	"TC_INGRESS":               "dropped by a TC ingress hook",
+	"UNHANDLED_PROTO":          "protocol isn't implemented or supported",
+	"SKB_CSUM":                 "checksum computation error",
+	"SKB_GSO_SEG":              "GSO segmentation error",
+	"SKB_UCOPY_FAULT":          "failed to copy data from user space",
+	"DEV_HDR":                  "invalid device specific header",
+	"DEV_READY":                "device isn't ready",
+	"FULL_RING":                "ring buffer is full",
+	"NOMEM":                    "out of memory",
+	"HDR_TRUNC":                "failed to extract the header",
+	"TAP_FILTER":               "dropped by the eBPF filter of a tun/tap device",
+	"TAP_TXFILTER":             "dropped by the TX filter of a tun/tap device",
+	"ICMP_CSUM":                "ICMP checksum error",
+	"INVALID_PROTO":            "packet violates the protocol",
+	"IP_INADDRERRORS":          "host unreachable",
+	"IP_INNOROUTES":            "network unreachable",
+	"IP_LOCAL_SOURCE":          "source address is local",
+	"IP_INVALID_SOURCE":        "invalid source address",
+	"IP_LOCALNET":              "source or destination address is in the loopback network",
+	"IP_INVALID_DEST":          "invalid destination address",
+	"PKT_TOO_BIG":              "packet is too big, e.g. exceeds the MTU",
+	"DUP_FRAG":                 "duplicate fragment",
+	"FRAG_REASM_TIMEOUT":       "fragment reassembly timed out",
+	"FRAG_TOO_FAR":             "IPv4 fragment is too far",
+	"IPV6_BAD_EXTHDR":          "invalid IPv6 extension header",
+	"IPV6_NDISC_FRAG":          "fragmented neighbour discovery packet",
+	"IPV6_NDISC_HOP_LIMIT":     "invalid hop limit of neighbour discovery packet",
+	"IPV6_NDISC_BAD_CODE":      "invalid code of neighbour discovery packet",
+	"IPV6_NDISC_BAD_OPTIONS":   "invalid options of neighbour discovery packet",
+	"IPV6_NDISC_NS_OTHERHOST":  "neighbour solicitation for another host",
+	"QUEUE_PURGE":              "queue was purged",
+	"TC_COOKIE_ERROR":          "TC cookie isn't valid",
+	"PACKET_SOCK_ERROR":        "error of a packet socket",
+	"TC_CHAIN_NOTFOUND":        "TC chain wasn't found",
+	"TC_RECLASSIFY_LOOP":       "TC reclassification loop",
+	"VXLAN_INVALID_HDR":        "invalid VXLAN header",
+	"VXLAN_VNI_NOT_FOUND":      "no VXLAN device for the VNI",
+	"MAC_INVALID_SOURCE":       "invalid source MAC address",
+	"VXLAN_ENTRY_EXISTS":       "VXLAN forwarding entry already exists",
+	"NO_TX_TARGET":             "no target to transmit the packet to",
+	"IP_TUNNEL_ECN":            "ECN of the tunnel isn't valid",
+	"TUNNEL_TXINFO":            "no tunnel metadata to transmit the packet",
+	"LOCAL_MAC":                "source MAC address is local",
+	"ARP_PVLAN_DISABLE":        "ARP proxy for private VLANs is disabled",
+}
+
+// dropReasonDecoder resolves values of enum skb_drop_reason. Values of reasons defined by subsystems aren't part of
+// the enum; they're resolved to the name of the subsystem and the code of the reason within it.
+type dropReasonDecoder struct {
+	names      map[uint64]string
+	subsystems map[uint64]string
+}
+
+func newDropReasonDecoder(enum *btf.Enum, kernelSpec *btf.Spec) *dropReasonDecoder {
+	d := &dropReasonDecoder{
+		names:      enumNames(enum),
+		subsystems: make(map[uint64]string),
+	}
+	if kernelSpec != nil {
+		subsys := &btf.Enum{}
+		if err := kernelSpec.TypeByName(dropReasonSubsysEnum, &subsys); err == nil {
+			for _, v := range subsys.Values {
+				d.subsystems[v.Value] = strings.TrimPrefix(v.Name, dropReasonSubsysPrefix)
+			}
+		}
+	}
+	return d
+}
+
+// name returns the name of the reason, like the names of other enums
+func (d *dropReasonDecoder) name(val uint64) string {
+	if name, ok := d.names[val]; ok {
+		return name
+	}
+	if subsys := val >> dropReasonSubsysShift; subsys != 0 {
+		name, ok := d.subsystems[subsys]
+		if !ok {
+			name = fmt.Sprintf("%d", subsys)
+		}
+		return fmt.Sprintf("%s%s:%d", dropReasonSubsysPrefix, name, val&(1<<dropReasonSubsysShift-1))
+	}
+	return "UNKNOWN"
+}
+
+// description returns the description of the reason; it's empty for unknown reasons
+func (d *dropReasonDecoder) description(val uint64) string {
+	return dropReasonDescriptions[strings.TrimPrefix(d.names[val], dropReasonPrefix)]
+}
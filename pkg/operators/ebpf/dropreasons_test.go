@@ -0,0 +1,56 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpfoperator
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDropReasonDecoder(t *testing.T) {
+	enum := &btf.Enum{
+		Name: dropReasonEnum,
+		Values: []btf.EnumValue{
+			{Name: "SKB_NOT_DROPPED_YET", Value: 0},
+			{Name: "SKB_DROP_REASON_NOT_SPECIFIED", Value: 2},
+			{Name: "SKB_DROP_REASON_NETFILTER_DROP", Value: 8},
+			{Name: "SKB_DROP_REASON_SOMETHING_NEW", Value: 100},
+		},
+	}
+	d := newDropReasonDecoder(enum, nil)
+	d.subsystems[3] = "OPENVSWITCH"
+
+	require.Equal(t, "SKB_DROP_REASON_NETFILTER_DROP", d.name(8))
+	require.Equal(t, "dropped by netfilter", d.description(8))
+
+	// Reasons unknown to this version are still resolved by the kernel BTF
+	require.Equal(t, "SKB_DROP_REASON_SOMETHING_NEW", d.name(100))
+	require.Empty(t, d.description(100))
+
+	require.Equal(t, "UNKNOWN", d.name(200))
+	require.Equal(t, "SKB_DROP_REASON_SUBSYS_OPENVSWITCH:5", d.name(3<<dropReasonSubsysShift|5))
+	require.Equal(t, "SKB_DROP_REASON_SUBSYS_4:1", d.name(4<<dropReasonSubsysShift|1))
+}
+
+func TestEnumNames(t *testing.T) {
+	names := enumNames(&btf.Enum{Values: []btf.EnumValue{
+		{Name: "A", Value: 1},
+		{Name: "B", Value: 2},
+		{Name: "B_ALIAS", Value: 2},
+	}})
+	require.Equal(t, map[uint64]string{1: "A", 2: "B"}, names)
+}
@@ -19,12 +19,14 @@ package ebpfoperator
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/btf"
@@ -45,6 +47,7 @@ import (
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/socketenricher"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/tchandler"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/uprobetracer"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/capabilities"
 )
 
 const (
@@ -54,6 +57,8 @@ const (
 
 	ParamIface       = "iface"
 	ParamTraceKernel = "trace-pipe"
+	ParamPinMaps     = "pin-maps"
+	ParamBatchSize   = "batch-size"
 
 	kernelTypesVar = "kernelTypes"
 )
@@ -116,6 +121,8 @@ func (o *ebpfOperator) InstantiateImageOperator(
 		networkTracers: make(map[string]*networktracer.Tracer[api.GadgetData]),
 		tcHandlers:     make(map[string]*tchandler.Handler),
 		uprobeTracers:  make(map[string]*uprobetracer.Tracer[api.GadgetData]),
+		uprobeParams:   make(map[string][]string),
+		usdtProbes:     make(map[string]string),
 
 		paramValues: paramValues,
 	}
@@ -148,6 +155,9 @@ type ebpfInstance struct {
 	collectionSpec *ebpf.CollectionSpec
 	collection     *ebpf.Collection
 
+	// collectionMu guards collection against being closed while other operators read its maps
+	collectionMu sync.RWMutex
+
 	tracers      map[string]*Tracer
 	structs      map[string]*Struct
 	snapshotters map[string]*Snapshotter
@@ -158,6 +168,13 @@ type ebpfInstance struct {
 	tcHandlers     map[string]*tchandler.Handler
 	uprobeTracers  map[string]*uprobetracer.Tracer[api.GadgetData]
 
+	// usdtProbes maps programs declared with GADGET_USDT_PROBE() to their <provider>:<probe>
+	usdtProbes map[string]string
+	// uprobeParams maps the key of each param overriding uprobe targets to the programs it applies to
+	uprobeParams map[string][]string
+	// uprobeTargets holds the targets of uprobe programs overridden by params
+	uprobeTargets map[string]string
+
 	// map from ebpf variable name to ebpfVar struct
 	vars map[string]*ebpfVar
 
@@ -168,6 +185,13 @@ type ebpfInstance struct {
 	enums      map[string]*btf.Enum
 	converters map[datasource.DataSource][]func(ds datasource.DataSource, data datasource.Data) error
 
+	programStats *programStats
+	watchdog     *userspaceWatchdog
+	limitEvents  *limitEvents
+
+	// processing accumulates the time the tracers spent processing events in user space, if a CPU budget needs it
+	processing atomic.Int64
+
 	gadgetCtx operators.GadgetContext
 }
 
@@ -198,6 +222,11 @@ func (i *ebpfInstance) analyze() error {
 			validator:    i.validateGlobalConstVoidPtrVar,
 			populateFunc: i.populateParam,
 		},
+		{
+			prefixFunc:   hasPrefix(usdtProbePrefix),
+			validator:    i.validateGlobalConstVoidPtrVar,
+			populateFunc: i.populateUsdtProbe,
+		},
 		// {
 		// 	prefixFunc:   hasPrefix(tracerMapPrefix),
 		// 	validator:    i.validateGlobalConstVoidPtrVar,
@@ -292,6 +321,11 @@ func (i *ebpfInstance) init(gadgetCtx operators.GadgetContext) error {
 		return fmt.Errorf("initializing formatters: %w", err)
 	}
 
+	err = i.initPairings(gadgetCtx)
+	if err != nil {
+		return fmt.Errorf("initializing pairings: %w", err)
+	}
+
 	return nil
 }
 
@@ -308,6 +342,9 @@ func (i *ebpfInstance) addDataSource(
 	if err != nil {
 		return nil, nil, fmt.Errorf("adding tracer datasource: %w", err)
 	}
+	for k, v := range i.config.GetStringMapString("datasources." + name + ".annotations") {
+		ds.AddAnnotation(k, v)
+	}
 	staticFields := make([]datasource.StaticField, 0, len(fields))
 	for _, field := range fields {
 		staticFields = append(staticFields, field)
@@ -329,6 +366,7 @@ func (i *ebpfInstance) register(gadgetCtx operators.GadgetContext) error {
 		m.accessor = accessor
 		m.ds = ds
 	}
+	snapshotFuncs := make(map[string]operators.SnapshotFunc, len(i.snapshotters))
 	for name, m := range i.snapshotters {
 		ds, accessor, err := i.addDataSource(gadgetCtx, datasource.TypeEvent, name, i.structs[m.StructName].Size, i.structs[m.StructName].Fields)
 		if err != nil {
@@ -345,8 +383,24 @@ func (i *ebpfInstance) register(gadgetCtx operators.GadgetContext) error {
 
 		m.accessor = accessor
 		m.ds = ds
+
+		// Allow other operators to take snapshots periodically
+		snapshotFuncs[name] = func() error {
+			return i.runSnapshotter(name, m)
+		}
 	}
-	return nil
+	if len(snapshotFuncs) > 0 {
+		gadgetCtx.SetVar(operators.SnapshottersVar, snapshotFuncs)
+	}
+
+	// Allow other operators to read the state the gadget keeps in its maps
+	if readers := i.mapReaders(); len(readers) > 0 {
+		gadgetCtx.SetVar(operators.MapsVar, readers)
+	}
+	if err := i.registerProgramStats(gadgetCtx); err != nil {
+		return err
+	}
+	return i.registerResourceLimits(gadgetCtx)
 }
 
 func (i *ebpfInstance) Name() string {
@@ -376,9 +430,7 @@ func (i *ebpfInstance) Prepare(gadgetCtx operators.GadgetContext) error {
 	for _, p := range i.collectionSpec.Programs {
 		switch p.Type {
 		case ebpf.Kprobe:
-			if strings.HasPrefix(p.SectionName, "uprobe/") ||
-				strings.HasPrefix(p.SectionName, "uretprobe/") ||
-				strings.HasPrefix(p.SectionName, "usdt/") {
+			if _, ok := uprobeProgType(p); ok {
 				uprobeTracer, err := uprobetracer.NewTracer[api.GadgetData](gadgetCtx.Logger())
 				if err != nil {
 					i.Close()
@@ -425,6 +477,11 @@ func (i *ebpfInstance) Prepare(gadgetCtx operators.GadgetContext) error {
 		}
 	}
 
+	if err := i.addUprobeParams(); err != nil {
+		i.Close()
+		return err
+	}
+
 	if len(i.tcHandlers) > 0 {
 		// For now, override enrichment
 		gadgetCtx.SetVar("NeedContainerEvents", true)
@@ -443,6 +500,62 @@ func (i *ebpfInstance) Prepare(gadgetCtx operators.GadgetContext) error {
 			TypeHint:     api.TypeBool,
 		},
 	}
+
+	i.params[ParamBatchSize] = &param{
+		Param: &api.Param{
+			Key:          ParamBatchSize,
+			Description:  "Maximum number of events read from the tracers that are handed to the operators at once; batching reduces the overhead of high event rates. 1 disables batching",
+			DefaultValue: "1",
+			TypeHint:     api.TypeUint32,
+		},
+	}
+
+	i.params[ParamPinMaps] = &param{
+		Param: &api.Param{
+			Key:         ParamPinMaps,
+			Title:       "Pin maps",
+			Description: "Keep the state of maps marked for pinning across runs by pinning them to " + pinBasePath + "/<key> using this key",
+			TypeHint:    api.TypeString,
+		},
+	}
+
+	i.params[ParamProgramStatsInterval] = &param{
+		Param: &api.Param{
+			Key:          ParamProgramStatsInterval,
+			Description:  "Emit the run time statistics of the eBPF programs to the " + ProgramStatsDataSourceName + " data source at this interval; 0 disables them. The CPU budget is checked at the same interval (default 1s)",
+			DefaultValue: "0",
+			TypeHint:     api.TypeDuration,
+		},
+	}
+
+	i.params[ParamCPUBudget] = &param{
+		Param: &api.Param{
+			Key:          ParamCPUBudget,
+			Description:  "Stop the gadget if its eBPF programs and processing its events in user space use more CPU than this, in percent of a single CPU; 0 disables the budget",
+			DefaultValue: "0",
+			TypeHint:     api.TypeFloat64,
+		},
+	}
+
+	i.params[ParamCPUBudgetIntervals] = &param{
+		Param: &api.Param{
+			Key:          ParamCPUBudgetIntervals,
+			Description:  "Number of consecutive intervals the CPU budget has to be exceeded before the gadget is stopped",
+			DefaultValue: "3",
+			TypeHint:     api.TypeUint32,
+		},
+	}
+
+	i.params[ParamIgnoreCPUBudget] = &param{
+		Param: &api.Param{
+			Key:          ParamIgnoreCPUBudget,
+			Description:  "Don't stop the gadget when it exceeds its CPU budget, e.g. for debugging",
+			DefaultValue: "false",
+			TypeHint:     api.TypeBool,
+		},
+	}
+
+	i.addLimitParams()
 	return nil
 }
 
@@ -471,6 +584,7 @@ func (i *ebpfInstance) Start(gadgetCtx operators.GadgetContext) error {
 	i.logger.Debugf("starting ebpfInstance")
 
 	gadgets.FixBpfKtimeGetBootNs(i.collectionSpec.Programs)
+	i.useRingbufFallback(isRingbufAvailable())
 
 	parameters := params.Params{}              // used to CopyFromMap
 	paramMap := make(map[string]*params.Param) // used for second iteration
@@ -491,6 +605,10 @@ func (i *ebpfInstance) Start(gadgetCtx operators.GadgetContext) error {
 		}
 	}
 
+	if err := i.resolveUprobeTargets(paramMap); err != nil {
+		return err
+	}
+
 	mapReplacements := make(map[string]*ebpf.Map)
 	constReplacements := make(map[string]any)
 
@@ -535,6 +653,18 @@ func (i *ebpfInstance) Start(gadgetCtx operators.GadgetContext) error {
 		MapReplacements: mapReplacements,
 	}
 
+	if key := paramMap[ParamPinMaps].AsString(); key != "" {
+		opts.Maps.PinPath, err = i.preparePinning(key)
+		if err != nil {
+			return fmt.Errorf("preparing map pinning: %w", err)
+		}
+	} else {
+		// Pinning is opt-in; without a key, maps are always created from scratch
+		for _, m := range i.collectionSpec.Maps {
+			m.Pinning = ebpf.PinNone
+		}
+	}
+
 	// check if the btfgen operator has stored the kernel types in the context
 	if btfSpecI, ok := gadgetCtx.GetVar(kernelTypesVar); ok {
 		gadgetCtx.Logger().Debugf("using kernel types from BTFHub")
@@ -544,13 +674,58 @@ func (i *ebpfInstance) Start(gadgetCtx operators.GadgetContext) error {
 		}
 		opts.Programs.KernelTypes = btfSpec
 	}
+
+	maxMapMemory, err := parseMaxMapMemory(paramMap[ParamMaxMapMemory].AsString())
+	if err != nil {
+		return err
+	}
+	if maxMapMemory > 0 {
+		cpus, err := ebpf.PossibleCPU()
+		if err != nil {
+			return fmt.Errorf("getting number of CPUs: %w", err)
+		}
+		if err := i.limitMapMemory(maxMapMemory, cpus, mapReplacements); err != nil {
+			return err
+		}
+	}
+
+	// Explain which capabilities are missing instead of failing with EPERM while loading or attaching
+	if err := capabilities.Check(i.collectionSpec); err != nil {
+		var missingErr *capabilities.MissingError
+		if errors.As(err, &missingErr) {
+			return fmt.Errorf("gadget %q can't be run: %w", gadgetCtx.ImageName(), err)
+		}
+		i.logger.Debugf("checking capabilities: %v", err)
+	}
+
+	lsm := newLSMProbe(func() (*btf.Spec, error) {
+		if opts.Programs.KernelTypes != nil {
+			// Types from BTFHub are reduced to the ones used by the gadget
+			return nil, errors.New("kernel types are incomplete")
+		}
+		return btf.LoadKernelSpec()
+	})
+	if err := lsm.check(i.collectionSpec); err != nil {
+		return fmt.Errorf("gadget %q can't be run: %w", gadgetCtx.ImageName(), err)
+	}
+
+	if err := i.checkKernelFeatures(); err != nil {
+		return fmt.Errorf("gadget %q can't be run: %w", gadgetCtx.ImageName(), err)
+	}
+
 	collection, err := ebpf.NewCollectionWithOptions(i.collectionSpec, opts)
 	if err != nil {
 		return fmt.Errorf("creating eBPF collection: %w", err)
 	}
+	i.collectionMu.Lock()
 	i.collection = collection
+	i.collectionMu.Unlock()
+
+	i.startLimits(gadgetCtx, paramMap)
 
+	batchSize := int(paramMap[ParamBatchSize].AsUint32())
 	for _, tracer := range i.tracers {
+		tracer.batchSize = batchSize
 		i.logger.Debugf("starting tracer %q", tracer.MapName)
 		go func(tracer *Tracer) {
 			err := i.runTracer(gadgetCtx, tracer)
@@ -560,6 +735,13 @@ func (i *ebpfInstance) Start(gadgetCtx operators.GadgetContext) error {
 		}(tracer)
 	}
 
+	if m, ok := i.collection.Maps[uprobetracer.UsdtSpecsMapName]; ok {
+		specs := uprobetracer.NewUsdtSpecs(m)
+		for _, uprobeTracer := range i.uprobeTracers {
+			uprobeTracer.SetUsdtSpecs(specs)
+		}
+	}
+
 	// Attach programs
 	for progName, p := range i.collectionSpec.Programs {
 		l, err := i.attachProgram(gadgetCtx, p, i.collection.Programs[progName])
@@ -580,6 +762,7 @@ func (i *ebpfInstance) Start(gadgetCtx operators.GadgetContext) error {
 				return fmt.Errorf("link is not an iterator")
 			}
 
+			// The same iterator can be used by several snapshotters
 			found := false
 			for _, snapshotter := range i.snapshotters {
 				if _, ok := snapshotter.iterators[progName]; ok {
@@ -588,7 +771,6 @@ func (i *ebpfInstance) Start(gadgetCtx operators.GadgetContext) error {
 						typ:  p.AttachTo,
 					}
 					found = true
-					break
 				}
 			}
 			if !found {
@@ -603,6 +785,11 @@ func (i *ebpfInstance) Start(gadgetCtx operators.GadgetContext) error {
 		return fmt.Errorf("running snapshotters: %w", err)
 	}
 
+	if err := i.startProgramStats(gadgetCtx, paramMap); err != nil {
+		i.Close()
+		return err
+	}
+
 	return nil
 }
 
@@ -612,10 +799,13 @@ func (i *ebpfInstance) Stop(gadgetCtx operators.GadgetContext) error {
 }
 
 func (i *ebpfInstance) Close() {
+	i.stopProgramStats()
+	i.collectionMu.Lock()
 	if i.collection != nil {
 		i.collection.Close()
 		i.collection = nil
 	}
+	i.collectionMu.Unlock()
 	for _, l := range i.links {
 		gadgets.CloseLink(l)
 	}
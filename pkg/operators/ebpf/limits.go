@@ -0,0 +1,365 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpfoperator
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	ParamMaxMapMemory        = "max-map-memory"
+	ParamMaxEventsPerSecond  = "max-events-per-second"
+	ParamUserspaceCPUBudget  = "userspace-cpu-budget"
+	ResourceLimitsDataSource = "resource_limits"
+)
+
+func (i *ebpfInstance) addLimitParams() {
+	i.params[ParamMaxMapMemory] = &param{
+		Param: &api.Param{
+			Key: ParamMaxMapMemory,
+			Description: "Maximum memory used by the maps of the gadget, e.g. '64Mi'; hash maps and ring buffers are " +
+				"shrunk to fit. Empty means no limit",
+			TypeHint: api.TypeString,
+		},
+	}
+
+	i.params[ParamMaxEventsPerSecond] = &param{
+		Param: &api.Param{
+			Key:          ParamMaxEventsPerSecond,
+			Description:  "Maximum number of events per second read from the tracers of the gadget; additional events are dropped and reported as lost. 0 means no limit",
+			DefaultValue: "0",
+			TypeHint:     api.TypeUint64,
+		},
+	}
+
+	i.params[ParamUserspaceCPUBudget] = &param{
+		Param: &api.Param{
+			Key:          ParamUserspaceCPUBudget,
+			Description:  "Stop the gadget if processing its events in user space uses more CPU than this, in percent of a single CPU; 0 disables the budget",
+			DefaultValue: "0",
+			TypeHint:     api.TypeFloat64,
+		},
+	}
+}
+
+// mapMemory estimates the memory used by the entries of a map
+func mapMemory(m *ebpf.MapSpec, cpus int) uint64 {
+	switch m.Type {
+	case ebpf.RingBuf:
+		return uint64(m.MaxEntries)
+	case ebpf.PerCPUHash, ebpf.PerCPUArray, ebpf.LRUCPUHash:
+		return uint64(m.KeySize+m.ValueSize) * uint64(m.MaxEntries) * uint64(cpus)
+	case ebpf.PerfEventArray:
+		// The perf buffers are allocated by the reader
+		return 0
+	}
+	return uint64(m.KeySize+m.ValueSize) * uint64(m.MaxEntries)
+}
+
+// resizableMap tells whether the number of entries of a map can be reduced without changing how the gadget works,
+// i.e. it only holds fewer entries at the same time. Arrays are indexed by the gadget and data sections must keep
+// their size.
+func resizableMap(m *ebpf.MapSpec) bool {
+	if strings.HasPrefix(m.Name, ".") || m.Pinning != ebpf.PinNone {
+		return false
+	}
+	switch m.Type {
+	case ebpf.Hash, ebpf.LRUHash, ebpf.PerCPUHash, ebpf.LRUCPUHash, ebpf.LPMTrie, ebpf.RingBuf:
+		return true
+	}
+	return false
+}
+
+// limitMapMemory reduces the entries of resizable maps proportionally so that all maps fit into maxMemory. Maps in
+// skip are replaced by other operators and aren't created by the gadget.
+func (i *ebpfInstance) limitMapMemory(maxMemory uint64, cpus int, skip map[string]*ebpf.Map) error {
+	if maxMemory == 0 {
+		return nil
+	}
+
+	var fixed, resizable uint64
+	var names []string
+	for name, m := range i.collectionSpec.Maps {
+		if _, ok := skip[name]; ok {
+			continue
+		}
+		if resizableMap(m) {
+			resizable += mapMemory(m, cpus)
+			names = append(names, name)
+		} else {
+			fixed += mapMemory(m, cpus)
+		}
+	}
+	if fixed+resizable <= maxMemory {
+		return nil
+	}
+	if fixed >= maxMemory {
+		return fmt.Errorf("maps of the gadget that can't be shrunk need %d bytes, more than the %s of %d bytes",
+			fixed, ParamMaxMapMemory, maxMemory)
+	}
+
+	factor := float64(maxMemory-fixed) / float64(resizable)
+	sort.Strings(names)
+	pageSize := uint32(os.Getpagesize())
+	for _, name := range names {
+		m := i.collectionSpec.Maps[name]
+		entries := max(uint32(float64(m.MaxEntries)*factor), 1)
+		if m.Type == ebpf.RingBuf {
+			// The size of ring buffers is a power of 2 multiple of the page size
+			entries = max(uint32(1)<<(31-bits.LeadingZeros32(entries)), pageSize)
+		}
+		if entries >= m.MaxEntries {
+			continue
+		}
+		i.logger.Warnf("reducing map %q from %d to %d entries to stay within %s", name, m.MaxEntries, entries,
+			ParamMaxMapMemory)
+		m.MaxEntries = entries
+	}
+	return nil
+}
+
+// parseMaxMapMemory returns the memory limit in bytes, 0 meaning no limit
+func parseMaxMapMemory(v string) (uint64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	q, err := resource.ParseQuantity(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", ParamMaxMapMemory, err)
+	}
+	if q.Sign() < 0 {
+		return 0, fmt.Errorf("%s must not be negative", ParamMaxMapMemory)
+	}
+	return uint64(q.Value()), nil
+}
+
+// eventLimiter allows up to max events per second, shared by all tracers of a gadget
+type eventLimiter struct {
+	max    uint64
+	mu     sync.Mutex
+	second int64
+	count  uint64
+}
+
+func (l *eventLimiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s := now.Unix(); s != l.second {
+		l.second = s
+		l.count = 0
+	}
+	if l.count >= l.max {
+		return false
+	}
+	l.count++
+	return true
+}
+
+// userspaceWatchdog stops the gadget when processing its events in user space takes more CPU time than its budget.
+// The time is measured from reading an event to the return of its emission, which includes the operators
+// subscribed to the data source.
+type userspaceWatchdog struct {
+	budget    float64
+	intervals uint32
+	exceeded  uint32
+}
+
+// limitEvents emits an event telling why a gadget is stopped when it exceeds one of its limits
+type limitEvents struct {
+	ds      datasource.DataSource
+	limit   datasource.FieldAccessor
+	usage   datasource.FieldAccessor
+	budget  datasource.FieldAccessor
+	message datasource.FieldAccessor
+}
+
+// parseBudget returns the budget set by the param key, or 0 if it isn't set. Like parseProgramStatsInterval, it's
+// needed before the params are parsed in Start.
+func parseBudget(paramValues map[string]string, key string) (float64, error) {
+	v := paramValues[key]
+	if v == "" {
+		return 0, nil
+	}
+	budget, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	if budget < 0 {
+		return 0, fmt.Errorf("%s must not be negative", key)
+	}
+	return budget, nil
+}
+
+// registerResourceLimits registers the data source telling why a gadget was stopped for exceeding one of its CPU
+// budgets. Like registerProgramStats, it's called before the params are parsed in Start.
+func (i *ebpfInstance) registerResourceLimits(gadgetCtx operators.GadgetContext) error {
+	userspaceBudget, err := parseBudget(i.paramValues, ParamUserspaceCPUBudget)
+	if err != nil {
+		return err
+	}
+	budget, err := parseBudget(i.paramValues, ParamCPUBudget)
+	if err != nil {
+		return err
+	}
+	if userspaceBudget > 0 {
+		i.watchdog = &userspaceWatchdog{}
+	}
+	if userspaceBudget == 0 && budget == 0 {
+		return nil
+	}
+
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, ResourceLimitsDataSource)
+	if err != nil {
+		return fmt.Errorf("adding resource limits datasource: %w", err)
+	}
+	e := &limitEvents{ds: ds}
+	for _, f := range []struct {
+		acc         *datasource.FieldAccessor
+		name        string
+		kind        api.Kind
+		description string
+	}{
+		{&e.limit, "limit", api.Kind_String, "Name of the limit that was exceeded"},
+		{&e.usage, "usage", api.Kind_Float64, "Usage during the last interval"},
+		{&e.budget, "budget", api.Kind_Float64, "Configured limit"},
+		{&e.message, "message", api.Kind_String, "What happened to the gadget"},
+	} {
+		*f.acc, err = ds.AddField(f.name, datasource.WithKind(f.kind), datasource.WithAnnotations(map[string]string{
+			"description": f.description,
+		}))
+		if err != nil {
+			return fmt.Errorf("adding field %q: %w", f.name, err)
+		}
+	}
+	i.limitEvents = e
+	return nil
+}
+
+// startLimits applies the limits to the tracers and starts the watchdog
+func (i *ebpfInstance) startLimits(gadgetCtx operators.GadgetContext, paramMap map[string]*params.Param) {
+	if maxEvents := paramMap[ParamMaxEventsPerSecond].AsUint64(); maxEvents > 0 {
+		limiter := &eventLimiter{max: maxEvents}
+		for _, tracer := range i.tracers {
+			tracer.limiter = limiter
+		}
+	}
+
+	ignoreBudgets := paramMap[ParamIgnoreCPUBudget].AsBool()
+
+	// The time spent in user space is needed by both the watchdog and the CPU budget of the program stats
+	w := i.watchdog
+	if w != nil && ignoreBudgets {
+		i.logger.Warnf("ignoring user space CPU budget of %.2f%%", paramMap[ParamUserspaceCPUBudget].AsFloat64())
+		w = nil
+	}
+	if w != nil || (paramMap[ParamCPUBudget].AsFloat64() > 0 && !ignoreBudgets) {
+		for _, tracer := range i.tracers {
+			tracer.processing = &i.processing
+		}
+	}
+	if w == nil {
+		return
+	}
+	w.budget = paramMap[ParamUserspaceCPUBudget].AsFloat64()
+	w.intervals = max(paramMap[ParamCPUBudgetIntervals].AsUint32(), 1)
+
+	go func() {
+		ticker := time.NewTicker(budgetInterval)
+		defer ticker.Stop()
+		last := time.Now()
+		var lastProcessing int64
+		for {
+			select {
+			case <-gadgetCtx.Context().Done():
+				return
+			case now := <-ticker.C:
+				processing := i.processing.Load()
+				usage := cpuUsage(time.Duration(processing-lastProcessing), now.Sub(last))
+				last, lastProcessing = now, processing
+
+				if !w.overBudget(usage) {
+					continue
+				}
+				i.stopForLimit(gadgetCtx, ParamUserspaceCPUBudget, usage, w.budget, fmt.Sprintf(
+					"gadget %q used %.2f%% CPU in user space, exceeding its budget of %.2f%% for %d consecutive "+
+						"intervals; stopping it (use --%s to run it anyway)",
+					gadgetCtx.ImageName(), usage, w.budget, w.exceeded, ParamIgnoreCPUBudget))
+				return
+			}
+		}
+	}()
+}
+
+// overBudget records the CPU usage of the last interval and returns whether the budget has been exceeded for too long
+func (w *userspaceWatchdog) overBudget(usage float64) bool {
+	if usage <= w.budget {
+		w.exceeded = 0
+		return false
+	}
+	w.exceeded++
+	return w.exceeded >= w.intervals
+}
+
+// stopForLimit stops the gadget because it exceeded limit; msg is logged and emitted to the resource limits data
+// source first
+func (i *ebpfInstance) stopForLimit(gadgetCtx operators.GadgetContext, limit string, usage, budget float64, msg string) {
+	i.logger.Error(msg)
+	if i.limitEvents != nil {
+		if err := i.limitEvents.emit(limit, usage, budget, msg); err != nil {
+			i.logger.Warnf("emitting resource limits event: %v", err)
+		}
+	}
+	gadgetCtx.Cancel()
+}
+
+func (e *limitEvents) emit(limit string, usage, budget float64, msg string) error {
+	data := e.ds.NewData()
+	if err := e.limit.Set(data, []byte(limit)); err != nil {
+		return err
+	}
+	if err := e.message.Set(data, []byte(msg)); err != nil {
+		return err
+	}
+	for _, f := range []struct {
+		acc datasource.FieldAccessor
+		val float64
+	}{
+		{e.usage, usage},
+		{e.budget, budget},
+	} {
+		// The fields have no fixed size, so they need to be allocated before their values can be put
+		if err := f.acc.Set(data, make([]byte, 8)); err != nil {
+			return err
+		}
+		f.acc.PutUint64(data, math.Float64bits(f.val))
+	}
+	return e.ds.EmitAndRelease(data)
+}
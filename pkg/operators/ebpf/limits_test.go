@@ -0,0 +1,165 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpfoperator
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+)
+
+func TestLimitMapMemory(t *testing.T) {
+	pageSize := uint32(os.Getpagesize())
+	newInstance := func() *ebpfInstance {
+		return &ebpfInstance{
+			collectionSpec: &ebpf.CollectionSpec{
+				Maps: map[string]*ebpf.MapSpec{
+					// 16 KiB that can't be shrunk
+					"counters": {Name: "counters", Type: ebpf.Array, KeySize: 4, ValueSize: 12, MaxEntries: 1024},
+					".rodata":  {Name: ".rodata", Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1},
+					// 64 KiB each
+					"starts":      {Name: "starts", Type: ebpf.Hash, KeySize: 8, ValueSize: 8, MaxEntries: 4096},
+					"per_cpu":     {Name: "per_cpu", Type: ebpf.PerCPUHash, KeySize: 4, ValueSize: 4, MaxEntries: 2048, Pinning: ebpf.PinNone},
+					"events":      {Name: "events", Type: ebpf.RingBuf, MaxEntries: 64 * 1024},
+					"replaced":    {Name: "replaced", Type: ebpf.Hash, KeySize: 8, ValueSize: 8, MaxEntries: 1 << 20},
+					"perf_events": {Name: "perf_events", Type: ebpf.PerfEventArray},
+				},
+			},
+			logger: logger.DefaultLogger(),
+		}
+	}
+	skip := map[string]*ebpf.Map{"replaced": nil}
+
+	// Everything fits
+	i := newInstance()
+	require.NoError(t, i.limitMapMemory(256*1024, 4, skip))
+	require.Equal(t, uint32(4096), i.collectionSpec.Maps["starts"].MaxEntries)
+	require.NoError(t, i.limitMapMemory(0, 4, skip))
+
+	// Resizable maps get half of their memory
+	i = newInstance()
+	require.NoError(t, i.limitMapMemory(16*1024+8+96*1024, 4, skip))
+	require.Equal(t, uint32(1024), i.collectionSpec.Maps["counters"].MaxEntries)
+	require.Equal(t, uint32(2048), i.collectionSpec.Maps["starts"].MaxEntries)
+	require.Equal(t, uint32(1024), i.collectionSpec.Maps["per_cpu"].MaxEntries)
+	require.Equal(t, max(32*1024, pageSize), i.collectionSpec.Maps["events"].MaxEntries)
+	require.Equal(t, uint32(1<<20), i.collectionSpec.Maps["replaced"].MaxEntries)
+
+	// Ring buffers keep at least a page
+	i = newInstance()
+	require.NoError(t, i.limitMapMemory(16*1024+8+1024, 4, skip))
+	require.Equal(t, pageSize, i.collectionSpec.Maps["events"].MaxEntries)
+	require.Equal(t, uint32(10), i.collectionSpec.Maps["per_cpu"].MaxEntries)
+
+	i = newInstance()
+	require.ErrorContains(t, i.limitMapMemory(8*1024, 4, skip), "can't be shrunk")
+}
+
+func TestParseMaxMapMemory(t *testing.T) {
+	v, err := parseMaxMapMemory("")
+	require.NoError(t, err)
+	require.Zero(t, v)
+
+	v, err = parseMaxMapMemory("64Mi")
+	require.NoError(t, err)
+	require.Equal(t, uint64(64*1024*1024), v)
+
+	_, err = parseMaxMapMemory("-1")
+	require.Error(t, err)
+	_, err = parseMaxMapMemory("lots")
+	require.Error(t, err)
+}
+
+func TestEventLimiter(t *testing.T) {
+	l := &eventLimiter{max: 2}
+	now := time.Unix(1000, 0)
+	require.True(t, l.allow(now))
+	require.True(t, l.allow(now.Add(100*time.Millisecond)))
+	require.False(t, l.allow(now.Add(900*time.Millisecond)))
+	// The budget is renewed every second
+	require.True(t, l.allow(now.Add(time.Second)))
+}
+
+func TestUserspaceWatchdogOverBudget(t *testing.T) {
+	w := &userspaceWatchdog{budget: 10, intervals: 2}
+	require.False(t, w.overBudget(20))
+	require.False(t, w.overBudget(5))
+	require.False(t, w.overBudget(20))
+	require.True(t, w.overBudget(30))
+}
+
+func TestRegisterResourceLimits(t *testing.T) {
+	for name, tc := range map[string]struct {
+		paramValues map[string]string
+		dataSource  bool
+		watchdog    bool
+		err         bool
+	}{
+		"none":      {paramValues: map[string]string{ParamCPUBudget: "0", ParamUserspaceCPUBudget: "0"}},
+		"cpu":       {paramValues: map[string]string{ParamCPUBudget: "5"}, dataSource: true},
+		"userspace": {paramValues: map[string]string{ParamUserspaceCPUBudget: "5"}, dataSource: true, watchdog: true},
+		"invalid":   {paramValues: map[string]string{ParamCPUBudget: "-1"}, err: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			gadgetCtx := gadgetcontext.New(context.Background(), "test")
+			i := &ebpfInstance{paramValues: tc.paramValues}
+			err := i.registerResourceLimits(gadgetCtx)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			_, ok := gadgetCtx.GetDataSources()[ResourceLimitsDataSource]
+			require.Equal(t, tc.dataSource, ok)
+			require.Equal(t, tc.dataSource, i.limitEvents != nil)
+			require.Equal(t, tc.watchdog, i.watchdog != nil)
+		})
+	}
+}
+
+func TestStopForLimit(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	i := &ebpfInstance{
+		paramValues: map[string]string{ParamCPUBudget: "5"},
+		logger:      logger.DefaultLogger(),
+	}
+	require.NoError(t, i.registerResourceLimits(gadgetCtx))
+
+	ds := gadgetCtx.GetDataSources()[ResourceLimitsDataSource]
+	var limit, message string
+	var usage, budget float64
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		limit = i.limitEvents.limit.String(data)
+		message = i.limitEvents.message.String(data)
+		usage = i.limitEvents.usage.Float64(data)
+		budget = i.limitEvents.budget.Float64(data)
+		return nil
+	}, 0)
+
+	i.stopForLimit(gadgetCtx, ParamCPUBudget, 7.5, 5, "too much")
+	require.Equal(t, ParamCPUBudget, limit)
+	require.Equal(t, "too much", message)
+	require.Equal(t, 7.5, usage)
+	require.Equal(t, 5.0, budget)
+	require.Error(t, gadgetCtx.Context().Err())
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpfoperator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/features"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+)
+
+// ErrLSMUnavailable is returned when a gadget has LSM programs but the kernel can't run them
+var ErrLSMUnavailable = errors.New("BPF LSM is not available")
+
+// lsmHookPrefix is the prefix of the kernel functions LSM programs are attached to
+const lsmHookPrefix = "bpf_lsm_"
+
+// lsmProbe tells whether the kernel can run LSM programs
+type lsmProbe struct {
+	// haveProgramType returns an error if the kernel doesn't support LSM programs
+	haveProgramType func() error
+	// activeLSMs returns the comma-separated list of enabled LSMs
+	activeLSMs func() (string, error)
+	// kernelTypes returns the types used to check the hooks; they aren't checked if it fails
+	kernelTypes func() (*btf.Spec, error)
+}
+
+func newLSMProbe(kernelTypes func() (*btf.Spec, error)) *lsmProbe {
+	return &lsmProbe{
+		haveProgramType: func() error {
+			return features.HaveProgramType(ebpf.LSM)
+		},
+		activeLSMs: func() (string, error) {
+			// securityfs might only be mounted on the host when running in a container
+			paths := []string{"/sys/kernel/security/lsm", filepath.Join(host.HostRoot, "sys/kernel/security/lsm")}
+			var err error
+			for _, path := range paths {
+				var buf []byte
+				if buf, err = os.ReadFile(path); err == nil {
+					return strings.TrimSpace(string(buf)), nil
+				}
+			}
+			return "", err
+		},
+		kernelTypes: kernelTypes,
+	}
+}
+
+// check returns an error wrapping ErrLSMUnavailable explaining why the LSM programs of spec can't run. It's nil if
+// there are no LSM programs or they can run as far as it can tell.
+func (p *lsmProbe) check(spec *ebpf.CollectionSpec) error {
+	var hooks []string
+	for _, prog := range spec.Programs {
+		if prog.Type == ebpf.LSM {
+			hooks = append(hooks, prog.AttachTo)
+		}
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+	sort.Strings(hooks)
+
+	if err := p.haveProgramType(); err != nil {
+		if errors.Is(err, ebpf.ErrNotSupported) {
+			return fmt.Errorf("%w: the kernel doesn't support LSM programs, it needs to be built with CONFIG_BPF_LSM",
+				ErrLSMUnavailable)
+		}
+		return fmt.Errorf("probing LSM programs: %w", err)
+	}
+
+	// The program type is supported even if the bpf LSM isn't enabled, but then programs are never called
+	if lsms, err := p.activeLSMs(); err == nil {
+		enabled := false
+		for _, lsm := range strings.Split(lsms, ",") {
+			if lsm == "bpf" {
+				enabled = true
+				break
+			}
+		}
+		if !enabled {
+			return fmt.Errorf("%w: the bpf LSM isn't enabled, add it to the lsm= kernel command line (enabled LSMs: %s)",
+				ErrLSMUnavailable, lsms)
+		}
+	}
+
+	if kernelTypes, err := p.kernelTypes(); err == nil {
+		for _, hook := range hooks {
+			var fn *btf.Func
+			if err := kernelTypes.TypeByName(lsmHookPrefix+hook, &fn); err != nil {
+				return fmt.Errorf("%w: LSM hook %q not found in the kernel", ErrLSMUnavailable, hook)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpfoperator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLSMProbe(t *testing.T) {
+	kernelTypes := &btf.Builder{}
+	_, err := kernelTypes.Add(&btf.Func{Name: "bpf_lsm_file_open", Type: &btf.FuncProto{Return: &btf.Void{}}})
+	require.NoError(t, err)
+	buf, err := kernelTypes.Marshal(nil, nil)
+	require.NoError(t, err)
+	kernelSpec, err := btf.LoadSpecFromReader(bytes.NewReader(buf))
+	require.NoError(t, err)
+
+	lsmSpec := func(hooks ...string) *ebpf.CollectionSpec {
+		spec := &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{
+			"kprobe": {Name: "kprobe", Type: ebpf.Kprobe, AttachTo: "do_sys_open"},
+		}}
+		for _, hook := range hooks {
+			spec.Programs[hook] = &ebpf.ProgramSpec{Name: hook, Type: ebpf.LSM, AttachTo: hook}
+		}
+		return spec
+	}
+	probe := func(haveErr error, lsms string, lsmsErr error) *lsmProbe {
+		return &lsmProbe{
+			haveProgramType: func() error { return haveErr },
+			activeLSMs:      func() (string, error) { return lsms, lsmsErr },
+			kernelTypes:     func() (*btf.Spec, error) { return kernelSpec, nil },
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		probe       *lsmProbe
+		spec        *ebpf.CollectionSpec
+		unavailable bool
+		err         bool
+	}{
+		"no LSM programs": {
+			probe: probe(ebpf.ErrNotSupported, "", nil),
+			spec:  lsmSpec(),
+		},
+		"enabled": {
+			probe: probe(nil, "lockdown,capability,landlock,yama,bpf", nil),
+			spec:  lsmSpec("file_open"),
+		},
+		"not supported": {
+			probe:       probe(fmt.Errorf("probing: %w", ebpf.ErrNotSupported), "", nil),
+			spec:        lsmSpec("file_open"),
+			unavailable: true,
+		},
+		"probe failed": {
+			probe: probe(errors.New("permission denied"), "", nil),
+			spec:  lsmSpec("file_open"),
+			err:   true,
+		},
+		"not enabled": {
+			probe:       probe(nil, "lockdown,capability,apparmor", nil),
+			spec:        lsmSpec("file_open"),
+			unavailable: true,
+		},
+		"unknown LSMs": {
+			probe: probe(nil, "", errors.New("securityfs not mounted")),
+			spec:  lsmSpec("file_open"),
+		},
+		"unknown hook": {
+			probe:       probe(nil, "bpf", nil),
+			spec:        lsmSpec("file_open", "file_teleport"),
+			unavailable: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := tc.probe.check(tc.spec)
+			switch {
+			case tc.unavailable:
+				require.ErrorIs(t, err, ErrLSMUnavailable)
+			case tc.err:
+				require.Error(t, err)
+				require.NotErrorIs(t, err, ErrLSMUnavailable)
+			default:
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpfoperator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cilium/ebpf"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+)
+
+// readableMapTypes are the types of maps that hold key/value pairs other operators can read
+var readableMapTypes = map[ebpf.MapType]bool{
+	ebpf.Hash:        true,
+	ebpf.Array:       true,
+	ebpf.LRUHash:     true,
+	ebpf.LPMTrie:     true,
+	ebpf.PerCPUHash:  true,
+	ebpf.PerCPUArray: true,
+	ebpf.LRUCPUHash:  true,
+	ebpf.StackTrace:  true,
+}
+
+// mapReader implements operators.MapReader; it looks up the map in the collection on each access, so it can be handed
+// out before the collection is loaded and can't touch the map after the collection has been closed
+type mapReader struct {
+	instance *ebpfInstance
+	spec     *ebpf.MapSpec
+}
+
+func (i *ebpfInstance) mapReaders() map[string]operators.MapReader {
+	readers := make(map[string]operators.MapReader)
+	for name, spec := range i.collectionSpec.Maps {
+		// Skip maps holding global variables like .rodata and .bss
+		if strings.HasPrefix(name, ".") || !readableMapTypes[spec.Type] {
+			continue
+		}
+		readers[name] = &mapReader{instance: i, spec: spec}
+	}
+	return readers
+}
+
+func (r *mapReader) Name() string {
+	return r.spec.Name
+}
+
+func (r *mapReader) KeySize() uint32 {
+	return r.spec.KeySize
+}
+
+func (r *mapReader) ValueSize() uint32 {
+	return r.spec.ValueSize
+}
+
+func (r *mapReader) MaxEntries() uint32 {
+	return r.spec.MaxEntries
+}
+
+func (r *mapReader) PerCPU() bool {
+	switch r.spec.Type {
+	case ebpf.PerCPUHash, ebpf.PerCPUArray, ebpf.LRUCPUHash:
+		return true
+	}
+	return false
+}
+
+// withMap calls fn with the loaded map while preventing the collection from being closed
+func (r *mapReader) withMap(fn func(m *ebpf.Map) error) error {
+	r.instance.collectionMu.RLock()
+	defer r.instance.collectionMu.RUnlock()
+
+	if r.instance.collection == nil {
+		return fmt.Errorf("reading map %q: %w", r.spec.Name, operators.ErrMapUnavailable)
+	}
+	m, ok := r.instance.collection.Maps[r.spec.Name]
+	if !ok {
+		return fmt.Errorf("reading map %q: %w", r.spec.Name, operators.ErrMapUnavailable)
+	}
+	return fn(m)
+}
+
+func (r *mapReader) Lookup(key []byte) ([][]byte, error) {
+	if len(key) != int(r.spec.KeySize) {
+		return nil, fmt.Errorf("key of map %q has size %d, expected %d", r.spec.Name, len(key), r.spec.KeySize)
+	}
+
+	var values [][]byte
+	err := r.withMap(func(m *ebpf.Map) error {
+		if r.PerCPU() {
+			err := m.Lookup(key, &values)
+			if errors.Is(err, ebpf.ErrKeyNotExist) {
+				values = nil
+				return nil
+			}
+			return err
+		}
+		value, err := m.LookupBytes(key)
+		if value != nil {
+			values = [][]byte{value}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("looking up map %q: %w", r.spec.Name, err)
+	}
+	return values, nil
+}
+
+func (r *mapReader) Iterate(fn func(key []byte, values [][]byte) error) error {
+	return r.withMap(func(m *ebpf.Map) error {
+		var key []byte
+		it := m.Iterate()
+		if r.PerCPU() {
+			var values [][]byte
+			for it.Next(&key, &values) {
+				if err := fn(key, values); err != nil {
+					return err
+				}
+				key, values = nil, nil
+			}
+		} else {
+			var value []byte
+			for it.Next(&key, &value) {
+				if err := fn(key, [][]byte{value}); err != nil {
+					return err
+				}
+				key, value = nil, nil
+			}
+		}
+		if err := it.Err(); err != nil {
+			return fmt.Errorf("iterating map %q: %w", r.spec.Name, err)
+		}
+		return nil
+	})
+}
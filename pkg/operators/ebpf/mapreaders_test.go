@@ -0,0 +1,102 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpfoperator
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/stretchr/testify/require"
+
+	utilstest "github.com/inspektor-gadget/inspektor-gadget/internal/test"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+)
+
+func TestMapReaders(t *testing.T) {
+	utilstest.RequireRoot(t)
+
+	i := &ebpfInstance{
+		collectionSpec: &ebpf.CollectionSpec{
+			Maps: map[string]*ebpf.MapSpec{
+				"state":    {Name: "state", Type: ebpf.Hash, KeySize: 4, ValueSize: 8, MaxEntries: 16},
+				"counters": {Name: "counters", Type: ebpf.PerCPUArray, KeySize: 4, ValueSize: 8, MaxEntries: 1},
+				"events":   {Name: "events", Type: ebpf.RingBuf, MaxEntries: 4096},
+			},
+		},
+	}
+	readers := i.mapReaders()
+	require.Len(t, readers, 2)
+	state := readers["state"]
+	counters := readers["counters"]
+	require.False(t, state.PerCPU())
+	require.True(t, counters.PerCPU())
+
+	key := binary.NativeEndian.AppendUint32(nil, 1)
+
+	// Maps can't be read before the gadget has been started
+	_, err := state.Lookup(key)
+	require.ErrorIs(t, err, operators.ErrMapUnavailable)
+
+	collection, err := ebpf.NewCollection(i.collectionSpec)
+	require.NoError(t, err)
+	i.collection = collection
+
+	require.NoError(t, collection.Maps["state"].Put(uint32(1), uint64(42)))
+	cpus, err := ebpf.PossibleCPU()
+	require.NoError(t, err)
+	perCPU := make([]uint64, cpus)
+	for cpu := range perCPU {
+		perCPU[cpu] = uint64(cpu + 1)
+	}
+	require.NoError(t, collection.Maps["counters"].Put(uint32(0), perCPU))
+
+	values, err := state.Lookup(key)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{binary.NativeEndian.AppendUint64(nil, 42)}, values)
+
+	values, err = state.Lookup(binary.NativeEndian.AppendUint32(nil, 2))
+	require.NoError(t, err)
+	require.Nil(t, values)
+
+	_, err = state.Lookup([]byte{1})
+	require.Error(t, err)
+
+	values, err = counters.Lookup(make([]byte, 4))
+	require.NoError(t, err)
+	require.Len(t, values, cpus)
+	sum, err := operators.SumPerCPU(values)
+	require.NoError(t, err)
+	require.Equal(t, uint64(cpus*(cpus+1)/2), binary.NativeEndian.Uint64(sum))
+
+	var keys [][]byte
+	require.NoError(t, state.Iterate(func(key []byte, values [][]byte) error {
+		keys = append(keys, key)
+		require.Len(t, values, 1)
+		return nil
+	}))
+	require.Equal(t, [][]byte{key}, keys)
+
+	errStop := errors.New("stop")
+	require.ErrorIs(t, counters.Iterate(func(key []byte, values [][]byte) error {
+		return errStop
+	}), errStop)
+
+	// Maps can't be read after the gadget has been stopped
+	i.Close()
+	_, err = state.Lookup(key)
+	require.ErrorIs(t, err, operators.ErrMapUnavailable)
+}
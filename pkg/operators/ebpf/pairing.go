@@ -0,0 +1,240 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpfoperator
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	metadatav1 "github.com/inspektor-gadget/inspektor-gadget/pkg/metadata/v1"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+)
+
+const (
+	defaultPairingTimestampField = "timestamp"
+	defaultPairingOutput         = "latency_ns"
+	defaultPairingTimeout        = 10 * time.Second
+	defaultPairingMaxEntries     = 16384
+)
+
+// pairer matches start and end events of a data source and writes the time between them to the end events; this
+// way, gadgets don't need to keep timestamps of requests in eBPF maps to compute latencies.
+type pairer struct {
+	keys       []datasource.FieldAccessor
+	role       datasource.FieldAccessor
+	startValue string
+	endValue   string
+	timestamp  datasource.FieldAccessor
+	output     datasource.FieldAccessor
+	timeout    uint64
+	maxEntries int
+
+	mu      sync.Mutex
+	pending map[string]uint64
+}
+
+// pairingTimeout returns the timeout of a pairing, or the default if none is set
+func pairingTimeout(cfg *metadatav1.Pairing) (time.Duration, error) {
+	if cfg.Timeout == "" {
+		return defaultPairingTimeout, nil
+	}
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("parsing timeout: %w", err)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("timeout must be positive")
+	}
+	return timeout, nil
+}
+
+func newPairer(ds datasource.DataSource, cfg *metadatav1.Pairing) (*pairer, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, fmt.Errorf("no keys given")
+	}
+	if cfg.StartValue == cfg.EndValue {
+		return nil, fmt.Errorf("start and end values must differ")
+	}
+
+	timeout, err := pairingTimeout(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &pairer{
+		startValue: cfg.StartValue,
+		endValue:   cfg.EndValue,
+		timeout:    uint64(timeout),
+		maxEntries: cfg.MaxEntries,
+		pending:    make(map[string]uint64),
+	}
+	if p.maxEntries <= 0 {
+		p.maxEntries = defaultPairingMaxEntries
+	}
+
+	for _, key := range cfg.Keys {
+		f := ds.GetField(key)
+		if f == nil {
+			return nil, fmt.Errorf("key field %q not found", key)
+		}
+		p.keys = append(p.keys, f)
+	}
+
+	p.role = ds.GetField(cfg.RoleField)
+	if p.role == nil {
+		return nil, fmt.Errorf("role field %q not found", cfg.RoleField)
+	}
+
+	timestampField := cfg.TimestampField
+	if timestampField == "" {
+		timestampField = defaultPairingTimestampField
+	}
+	p.timestamp = ds.GetField(timestampField)
+	if p.timestamp == nil {
+		return nil, fmt.Errorf("timestamp field %q not found", timestampField)
+	}
+	if p.timestamp.Size() != 8 {
+		return nil, fmt.Errorf("timestamp field %q must be a 64 bit integer", timestampField)
+	}
+
+	output := cfg.Output
+	if output == "" {
+		output = defaultPairingOutput
+	}
+	p.output, err = ds.AddField(output,
+		datasource.WithKind(api.Kind_Uint64),
+		datasource.WithAnnotations(map[string]string{
+			"description": "Time between the start and end event in nanoseconds",
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("adding output field: %w", err)
+	}
+
+	return p, nil
+}
+
+// fieldString returns the value of a field as it would be written in the metadata
+func fieldString(f datasource.FieldAccessor, data datasource.Data) string {
+	switch f.Type() {
+	case api.Kind_Bool:
+		return strconv.FormatBool(f.Bool(data))
+	case api.Kind_Uint8:
+		return strconv.FormatUint(uint64(f.Uint8(data)), 10)
+	case api.Kind_Uint16:
+		return strconv.FormatUint(uint64(f.Uint16(data)), 10)
+	case api.Kind_Uint32:
+		return strconv.FormatUint(uint64(f.Uint32(data)), 10)
+	case api.Kind_Uint64:
+		return strconv.FormatUint(f.Uint64(data), 10)
+	case api.Kind_Int8:
+		return strconv.FormatInt(int64(f.Int8(data)), 10)
+	case api.Kind_Int16:
+		return strconv.FormatInt(int64(f.Int16(data)), 10)
+	case api.Kind_Int32:
+		return strconv.FormatInt(int64(f.Int32(data)), 10)
+	case api.Kind_Int64:
+		return strconv.FormatInt(f.Int64(data), 10)
+	}
+	return f.CString(data)
+}
+
+func (p *pairer) key(data datasource.Data) string {
+	var key []byte
+	for _, f := range p.keys {
+		// Prefix values with their length, so dynamically sized fields can't produce ambiguous keys
+		val := f.Get(data)
+		key = binary.LittleEndian.AppendUint32(key, uint32(len(val)))
+		key = append(key, val...)
+	}
+	return string(key)
+}
+
+// expire removes all start events older than the timeout
+func (p *pairer) expire(now uint64) {
+	for key, ts := range p.pending {
+		if now-ts > p.timeout {
+			delete(p.pending, key)
+		}
+	}
+}
+
+func (p *pairer) handle(ds datasource.DataSource, data datasource.Data) error {
+	ts := p.timestamp.Uint64(data)
+
+	switch fieldString(p.role, data) {
+	case p.startValue:
+		key := p.key(data)
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if len(p.pending) >= p.maxEntries {
+			p.expire(ts)
+			if len(p.pending) >= p.maxEntries {
+				// Too many outstanding events; it's better to lose this one than to grow without bounds
+				return nil
+			}
+		}
+		p.pending[key] = ts
+	case p.endValue:
+		key := p.key(data)
+
+		p.mu.Lock()
+		start, ok := p.pending[key]
+		delete(p.pending, key)
+		p.mu.Unlock()
+
+		if !ok || ts < start || ts-start > p.timeout {
+			return nil
+		}
+		latency := make([]byte, 8)
+		ds.ByteOrder().PutUint64(latency, ts-start)
+		return p.output.Set(data, latency)
+	}
+	return nil
+}
+
+func (i *ebpfInstance) initPairings(gadgetCtx operators.GadgetContext) error {
+	for name, ds := range gadgetCtx.GetDataSources() {
+		cfg := i.config.Sub("datasources." + name + ".pairing")
+		if cfg == nil {
+			continue
+		}
+
+		// viper lowercases keys, so they're read one by one instead of unmarshaling the whole section
+		pairing := &metadatav1.Pairing{
+			Keys:           cfg.GetStringSlice("keys"),
+			RoleField:      cfg.GetString("roleField"),
+			StartValue:     cfg.GetString("startValue"),
+			EndValue:       cfg.GetString("endValue"),
+			TimestampField: cfg.GetString("timestampField"),
+			Output:         cfg.GetString("output"),
+			Timeout:        cfg.GetString("timeout"),
+			MaxEntries:     cfg.GetInt("maxEntries"),
+		}
+
+		p, err := newPairer(ds, pairing)
+		if err != nil {
+			return fmt.Errorf("pairing events of datasource %q: %w", name, err)
+		}
+		i.converters[ds] = append(i.converters[ds], p.handle)
+	}
+	return nil
+}
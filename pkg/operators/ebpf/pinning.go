@@ -0,0 +1,87 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpfoperator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// pinBasePath is the directory below which maps of gadget instances are pinned to keep their state across restarts
+// and upgrades of ig
+var pinBasePath = "/sys/fs/bpf/ig"
+
+// PinPath returns the directory used to pin the maps of the gadget instance identified by key
+func PinPath(key string) (string, error) {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return "", fmt.Errorf("invalid pin key %q", key)
+	}
+	return filepath.Join(pinBasePath, key), nil
+}
+
+// preparePinning makes sure that the pin directory for key exists and that maps pinned there by previous runs can be
+// re-adopted. Maps that are no longer compatible with the current spec (because the gadget was updated in the meantime,
+// for example) are removed, so they'll be re-created and their state is lost.
+func (i *ebpfInstance) preparePinning(key string) (string, error) {
+	dir, err := PinPath(key)
+	if err != nil {
+		return "", err
+	}
+
+	pinned := 0
+	for name, m := range i.collectionSpec.Maps {
+		if m.Pinning != ebpf.PinByName {
+			continue
+		}
+		pinned++
+
+		path := filepath.Join(dir, m.Name)
+		old, err := ebpf.LoadPinnedMap(path, nil)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				i.logger.Debugf("map %q will be pinned to %q", name, path)
+				continue
+			}
+			return "", fmt.Errorf("loading pinned map %q: %w", path, err)
+		}
+
+		err = m.Compatible(old)
+		old.Close()
+		if err == nil {
+			i.logger.Debugf("re-adopting pinned map %q", path)
+			continue
+		}
+
+		i.logger.Warnf("pinned map %q is incompatible and will be re-created, its state is lost: %v", path, err)
+		if err := os.Remove(path); err != nil {
+			return "", fmt.Errorf("removing incompatible pinned map %q: %w", path, err)
+		}
+	}
+
+	if pinned == 0 {
+		i.logger.Warnf("no maps are marked for pinning (use __uint(pinning, LIBBPF_PIN_BY_NAME))")
+		return "", nil
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("creating pin directory %q: %w", dir, err)
+	}
+	return dir, nil
+}
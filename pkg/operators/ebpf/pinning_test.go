@@ -0,0 +1,109 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpfoperator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+)
+
+func TestPinPath(t *testing.T) {
+	dir, err := PinPath("exec")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(pinBasePath, "exec"), dir)
+
+	// Keys must not escape the base path
+	for _, key := range []string{"", ".", "..", "a/b", "../exec", `a\b`} {
+		_, err := PinPath(key)
+		require.Error(t, err, "key %q", key)
+	}
+}
+
+func setPinBasePath(t *testing.T, path string) {
+	old := pinBasePath
+	pinBasePath = path
+	t.Cleanup(func() { pinBasePath = old })
+}
+
+func newPinningInstance(maps map[string]*ebpf.MapSpec) *ebpfInstance {
+	return &ebpfInstance{
+		collectionSpec: &ebpf.CollectionSpec{Maps: maps},
+		logger:         logger.DefaultLogger(),
+	}
+}
+
+func TestPreparePinning(t *testing.T) {
+	setPinBasePath(t, filepath.Join(t.TempDir(), "ig"))
+
+	// Nothing to pin
+	i := newPinningInstance(map[string]*ebpf.MapSpec{
+		"events": {Name: "events", Type: ebpf.RingBuf, MaxEntries: 4096},
+	})
+	dir, err := i.preparePinning("exec")
+	require.NoError(t, err)
+	require.Empty(t, dir)
+	require.NoDirExists(t, filepath.Join(pinBasePath, "exec"))
+
+	_, err = i.preparePinning("../exec")
+	require.Error(t, err)
+
+	// The directory is created for maps that are pinned for the first time
+	i = newPinningInstance(map[string]*ebpf.MapSpec{
+		"events":    {Name: "events", Type: ebpf.RingBuf, MaxEntries: 4096},
+		"baselines": {Name: "baselines", Type: ebpf.Hash, KeySize: 4, ValueSize: 8, MaxEntries: 16, Pinning: ebpf.PinByName},
+	})
+	dir, err = i.preparePinning("exec")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(pinBasePath, "exec"), dir)
+	require.DirExists(t, dir)
+}
+
+func TestPreparePinningExistingMaps(t *testing.T) {
+	// Pinned maps only exist on bpffs
+	base, err := os.MkdirTemp("/sys/fs/bpf", "ig-test-")
+	if err != nil {
+		t.Skipf("bpffs not available: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(base) })
+	setPinBasePath(t, base)
+
+	spec := &ebpf.MapSpec{Name: "baselines", Type: ebpf.Hash, KeySize: 4, ValueSize: 8, MaxEntries: 16, Pinning: ebpf.PinByName}
+	pin := func(spec *ebpf.MapSpec) {
+		m, err := ebpf.NewMapWithOptions(spec, ebpf.MapOptions{PinPath: filepath.Join(base, "exec")})
+		require.NoError(t, err)
+		m.Close()
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(base, "exec"), 0o700))
+	pin(spec)
+	path := filepath.Join(base, "exec", "baselines")
+
+	// Compatible maps are kept
+	_, err = newPinningInstance(map[string]*ebpf.MapSpec{"baselines": spec.Copy()}).preparePinning("exec")
+	require.NoError(t, err)
+	require.FileExists(t, path)
+
+	// Incompatible ones are removed, so they're created again
+	changed := spec.Copy()
+	changed.ValueSize = 16
+	_, err = newPinningInstance(map[string]*ebpf.MapSpec{"baselines": changed}).preparePinning("exec")
+	require.NoError(t, err)
+	require.NoFileExists(t, path)
+}
@@ -0,0 +1,44 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpfoperator
+
+import (
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/kernelfeatures"
+	metadatav1 "github.com/inspektor-gadget/inspektor-gadget/pkg/metadata/v1"
+)
+
+// requirements returns the kernel features the gadget declares in its metadata, or nil if there are none
+func (i *ebpfInstance) requirements() *metadatav1.Requirements {
+	if i.config == nil || !i.config.IsSet("requirements") {
+		return nil
+	}
+	// viper is case-insensitive, so read the keys one by one instead of unmarshalling them
+	return &metadatav1.Requirements{
+		KernelVersion: i.config.GetString("requirements.kernelVersion"),
+		BTF:           i.config.GetBool("requirements.btf"),
+		ProgramTypes:  i.config.GetStringSlice("requirements.programTypes"),
+		MapTypes:      i.config.GetStringSlice("requirements.mapTypes"),
+		Helpers:       i.config.GetStringMapStringSlice("requirements.helpers"),
+	}
+}
+
+// checkKernelFeatures makes sure the kernel provides what the gadget needs, so users get an error telling what's
+// missing instead of a verifier or loader error
+func (i *ebpfInstance) checkKernelFeatures() error {
+	if err := kernelfeatures.CheckRequirements(i.requirements()); err != nil {
+		return err
+	}
+	return kernelfeatures.CheckSpec(i.collectionSpec)
+}
@@ -19,10 +19,12 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/btf"
 	"github.com/cilium/ebpf/link"
+	"github.com/hashicorp/go-multierror"
 
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
 	metadatav1 "github.com/inspektor-gadget/inspektor-gadget/pkg/metadata/v1"
@@ -136,75 +138,96 @@ func (i *ebpfInstance) populateSnapshotter(t btf.Type, varName string) error {
 	return nil
 }
 
+// runSnapshotters runs all snapshotters concurrently and waits for them to
+// finish.
 func (i *ebpfInstance) runSnapshotters() error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var result error
+
 	for sName, snapshotter := range i.snapshotters {
-		i.logger.Debugf("Running snapshotter %q", sName)
+		wg.Add(1)
+		go func(sName string, snapshotter *Snapshotter) {
+			defer wg.Done()
+			if err := i.runSnapshotter(sName, snapshotter); err != nil {
+				mu.Lock()
+				result = multierror.Append(result, fmt.Errorf("running snapshotter %q: %w", sName, err))
+				mu.Unlock()
+			}
+		}(sName, snapshotter)
+	}
+	wg.Wait()
 
-		for pName, l := range snapshotter.links {
-			i.logger.Debugf("Running iterator %q", pName)
-			switch l.typ {
-			case "task":
-				buf, err := bpfiterns.Read(l.link)
-				if err != nil {
-					return fmt.Errorf("reading iterator %q: %w", pName, err)
-				}
+	return result
+}
 
-				size := snapshotter.accessor.Size()
-				if uint32(len(buf))%size != 0 {
-					return fmt.Errorf("iter %q returned an invalid buffer's size %d, expected multiple of %d",
-						pName, len(buf), size)
-				}
+func (i *ebpfInstance) runSnapshotter(sName string, snapshotter *Snapshotter) error {
+	i.logger.Debugf("Running snapshotter %q", sName)
+
+	for pName, l := range snapshotter.links {
+		i.logger.Debugf("Running iterator %q", pName)
+		switch l.typ {
+		case "task":
+			buf, err := bpfiterns.Read(l.link)
+			if err != nil {
+				return fmt.Errorf("reading iterator %q: %w", pName, err)
+			}
 
-				for i := uint32(0); i < uint32(len(buf)); i += size {
-					data := snapshotter.ds.NewData()
-					snapshotter.accessor.Set(data, buf[i:i+size])
-					snapshotter.ds.EmitAndRelease(data)
+			size := snapshotter.accessor.Size()
+			if uint32(len(buf))%size != 0 {
+				return fmt.Errorf("iter %q returned an invalid buffer's size %d, expected multiple of %d",
+					pName, len(buf), size)
+			}
+
+			for i := uint32(0); i < uint32(len(buf)); i += size {
+				data := snapshotter.ds.NewData()
+				snapshotter.accessor.Set(data, buf[i:i+size])
+				snapshotter.ds.EmitAndRelease(data)
+			}
+		case "tcp", "udp":
+			visitedNetNs := make(map[uint64]struct{})
+			for _, container := range i.containers {
+				_, visited := visitedNetNs[container.Netns]
+				if visited {
+					continue
 				}
-			case "tcp", "udp":
-				visitedNetNs := make(map[uint64]struct{})
-				for _, container := range i.containers {
-					_, visited := visitedNetNs[container.Netns]
-					if visited {
-						continue
+				visitedNetNs[container.Netns] = struct{}{}
+
+				err := netnsenter.NetnsEnter(int(container.Pid), func() error {
+					reader, err := l.link.Open()
+					if err != nil {
+						return err
 					}
-					visitedNetNs[container.Netns] = struct{}{}
-
-					err := netnsenter.NetnsEnter(int(container.Pid), func() error {
-						reader, err := l.link.Open()
-						if err != nil {
-							return err
-						}
-						defer reader.Close()
-
-						buf, err := io.ReadAll(reader)
-						if err != nil {
-							return fmt.Errorf("reading iterator %q: %w", pName, err)
-						}
-
-						size := snapshotter.accessor.Size()
-						if uint32(len(buf))%size != 0 {
-							return fmt.Errorf("iter %q returned an invalid buffer's size %d, expected multiple of %d",
-								pName, len(buf), size)
-						}
-
-						for i := uint32(0); i < uint32(len(buf)); i += size {
-							data := snapshotter.ds.NewData()
-							snapshotter.accessor.Set(data, buf[i:i+size])
-
-							// TODO: this isn't ideal; make DS reserve memory / clean on demand
-							// instead of allocating in here - or: reserve those 8 bytes in eBPF
-							snapshotter.netns.Set(data, make([]byte, 8))
-							snapshotter.netns.PutUint64(data, container.Netns)
-
-							snapshotter.ds.EmitAndRelease(data)
-						}
-
-						return nil
-					})
+					defer reader.Close()
+
+					buf, err := io.ReadAll(reader)
 					if err != nil {
-						return fmt.Errorf("entering container %q's netns to run iterator %q: %w",
-							container.Runtime.RuntimeName, pName, err)
+						return fmt.Errorf("reading iterator %q: %w", pName, err)
 					}
+
+					size := snapshotter.accessor.Size()
+					if uint32(len(buf))%size != 0 {
+						return fmt.Errorf("iter %q returned an invalid buffer's size %d, expected multiple of %d",
+							pName, len(buf), size)
+					}
+
+					for i := uint32(0); i < uint32(len(buf)); i += size {
+						data := snapshotter.ds.NewData()
+						snapshotter.accessor.Set(data, buf[i:i+size])
+
+						// TODO: this isn't ideal; make DS reserve memory / clean on demand
+						// instead of allocating in here - or: reserve those 8 bytes in eBPF
+						snapshotter.netns.Set(data, make([]byte, 8))
+						snapshotter.netns.PutUint64(data, container.Netns)
+
+						snapshotter.ds.EmitAndRelease(data)
+					}
+
+					return nil
+				})
+				if err != nil {
+					return fmt.Errorf("entering container %q's netns to run iterator %q: %w",
+						container.Runtime.RuntimeName, pName, err)
 				}
 			}
 		}
@@ -0,0 +1,335 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpfoperator
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/cilium/ebpf"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/bpfstats"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	ParamProgramStatsInterval = "program-stats-interval"
+	ParamCPUBudget            = "cpu-budget"
+	ParamCPUBudgetIntervals   = "cpu-budget-intervals"
+	ParamIgnoreCPUBudget      = "ignore-cpu-budget"
+
+	// ProgramStatsDataSourceName is the name of the data source the run-time statistics of the programs are emitted to
+	ProgramStatsDataSourceName = "ebpf_stats"
+
+	// ProgramStatsTotal is used as program name for the statistics of all programs of the gadget
+	ProgramStatsTotal = "*"
+
+	// budgetInterval is used to check the CPU budget if no interval for the statistics is given
+	budgetInterval = time.Second
+)
+
+// programSample is a snapshot of the statistics the kernel keeps for a program
+type programSample struct {
+	runCount uint64
+	runtime  time.Duration
+}
+
+// programStats periodically samples the run-time statistics of the programs of the gadget, emits them to ds and
+// stops the gadget if its programs and the processing of its events in user space exceed its CPU budget; the kernel
+// only collects the statistics while enabled using bpfstats
+type programStats struct {
+	interval time.Duration
+
+	// ds is nil if the statistics are only sampled to enforce the budget
+	ds datasource.DataSource
+
+	program   datasource.FieldAccessor
+	runCount  datasource.FieldAccessor
+	runtime   datasource.FieldAccessor
+	nsPerRun  datasource.FieldAccessor
+	cpuUsage  datasource.FieldAccessor
+	intervalF datasource.FieldAccessor
+
+	// budget is the maximum CPU usage of all programs in percent of a single CPU; it may be exceeded for
+	// budgetIntervals-1 consecutive intervals. A budget of 0 disables the check.
+	budget          float64
+	budgetIntervals uint32
+	exceeded        uint32
+
+	enabled bool
+	last    map[string]programSample
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// parseProgramStatsInterval returns the interval to emit program statistics at, or 0 if they're disabled. It's
+// needed before the params are parsed in Start, as the data source has to be registered early.
+func parseProgramStatsInterval(paramValues map[string]string) (time.Duration, error) {
+	v := paramValues[ParamProgramStatsInterval]
+	if v == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", ParamProgramStatsInterval, err)
+	}
+	if interval < 0 {
+		return 0, fmt.Errorf("%s must not be negative", ParamProgramStatsInterval)
+	}
+	return interval, nil
+}
+
+func (i *ebpfInstance) registerProgramStats(gadgetCtx operators.GadgetContext) error {
+	interval, err := parseProgramStatsInterval(i.paramValues)
+	if err != nil {
+		return err
+	}
+	if interval == 0 {
+		return nil
+	}
+
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, ProgramStatsDataSourceName)
+	if err != nil {
+		return fmt.Errorf("adding program stats datasource: %w", err)
+	}
+	s := &programStats{
+		interval: interval,
+		ds:       ds,
+		last:     make(map[string]programSample),
+	}
+
+	for _, f := range []struct {
+		acc         *datasource.FieldAccessor
+		name        string
+		kind        api.Kind
+		description string
+	}{
+		{&s.program, "program", api.Kind_String, fmt.Sprintf("Name of the eBPF program, %q for all programs of the gadget", ProgramStatsTotal)},
+		{&s.runCount, "run_count", api.Kind_Uint64, "Number of times the program ran since the gadget started"},
+		{&s.runtime, "runtime_ns", api.Kind_Uint64, "Total time the program ran since the gadget started"},
+		{&s.nsPerRun, "ns_per_run", api.Kind_Uint64, "Average run time of the program during the last interval"},
+		{&s.cpuUsage, "cpu_usage", api.Kind_Float64, "CPU time used by the program during the last interval, in percent of a single CPU"},
+		{&s.intervalF, "interval_ns", api.Kind_Uint64, "Length of the last interval"},
+	} {
+		*f.acc, err = ds.AddField(f.name, datasource.WithKind(f.kind), datasource.WithAnnotations(map[string]string{
+			"description": f.description,
+		}))
+		if err != nil {
+			return fmt.Errorf("adding field %q: %w", f.name, err)
+		}
+	}
+	s.intervalF.SetHidden(true, false)
+
+	i.programStats = s
+	return nil
+}
+
+// startProgramStats enables the collection of statistics if they're emitted or a CPU budget is set, and samples them
+// until Close
+func (i *ebpfInstance) startProgramStats(gadgetCtx operators.GadgetContext, paramMap map[string]*params.Param) error {
+	budget := paramMap[ParamCPUBudget].AsFloat64()
+	if budget < 0 {
+		return fmt.Errorf("%s must not be negative", ParamCPUBudget)
+	}
+	if budget > 0 && paramMap[ParamIgnoreCPUBudget].AsBool() {
+		i.logger.Warnf("ignoring CPU budget of %.2f%%", budget)
+		budget = 0
+	}
+
+	s := i.programStats
+	if s == nil {
+		if budget == 0 {
+			return nil
+		}
+		s = &programStats{
+			interval: budgetInterval,
+			last:     make(map[string]programSample),
+		}
+		i.programStats = s
+	}
+	s.budget = budget
+	s.budgetIntervals = max(paramMap[ParamCPUBudgetIntervals].AsUint32(), 1)
+
+	if err := bpfstats.EnableBPFStats(); err != nil {
+		return fmt.Errorf("enabling program stats: %w", err)
+	}
+	s.enabled = true
+
+	// The first samples are the baseline for the first interval
+	programs := make(map[string]*ebpf.Program, len(i.collection.Programs))
+	for name, p := range i.collection.Programs {
+		programs[name] = p
+	}
+	s.sample(programs)
+
+	s.done = make(chan struct{})
+	s.stopped = make(chan struct{})
+	go func() {
+		defer close(s.stopped)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		last := time.Now()
+		lastProcessing := i.processing.Load()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-gadgetCtx.Context().Done():
+				return
+			case now := <-ticker.C:
+				usage, err := s.update(programs, now.Sub(last))
+				if err != nil {
+					i.logger.Warnf("emitting program stats: %v", err)
+				}
+				processing := i.processing.Load()
+				usage += cpuUsage(time.Duration(processing-lastProcessing), now.Sub(last))
+				last, lastProcessing = now, processing
+
+				if s.overBudget(usage) {
+					i.stopForLimit(gadgetCtx, ParamCPUBudget, usage, s.budget, fmt.Sprintf(
+						"gadget %q used %.2f%% CPU in its eBPF programs and in user space, exceeding its budget of "+
+							"%.2f%% for %d consecutive intervals; stopping it (use --%s to run it anyway)",
+						gadgetCtx.ImageName(), usage, s.budget, s.exceeded, ParamIgnoreCPUBudget))
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// overBudget records the CPU usage of the last interval and returns whether the budget has been exceeded for too long
+func (s *programStats) overBudget(usage float64) bool {
+	if s.budget <= 0 {
+		return false
+	}
+	if usage <= s.budget {
+		s.exceeded = 0
+		return false
+	}
+	s.exceeded++
+	return s.exceeded >= s.budgetIntervals
+}
+
+func (i *ebpfInstance) stopProgramStats() {
+	s := i.programStats
+	if s == nil || !s.enabled {
+		return
+	}
+	close(s.done)
+	<-s.stopped
+	s.enabled = false
+	if err := bpfstats.DisableBPFStats(); err != nil {
+		i.logger.Warnf("disabling program stats: %v", err)
+	}
+}
+
+// sample reads the current statistics of programs; programs the kernel doesn't report statistics for are skipped
+func (s *programStats) sample(programs map[string]*ebpf.Program) map[string]programSample {
+	samples := make(map[string]programSample, len(programs))
+	for name, p := range programs {
+		info, err := p.Info()
+		if err != nil {
+			continue
+		}
+		runCount, ok := info.RunCount()
+		if !ok {
+			continue
+		}
+		runtime, ok := info.Runtime()
+		if !ok {
+			continue
+		}
+		samples[name] = programSample{runCount: runCount, runtime: runtime}
+	}
+	previous := s.last
+	s.last = samples
+	return previous
+}
+
+// update samples the statistics and emits an event for each program and one for the total of the gadget, containing
+// the statistics of the interval since the last call. It returns the CPU usage of all programs during the interval.
+func (s *programStats) update(programs map[string]*ebpf.Program, interval time.Duration) (float64, error) {
+	previous := s.sample(programs)
+
+	names := make([]string, 0, len(s.last))
+	for name := range s.last {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var total, totalPrevious programSample
+	for _, name := range names {
+		current := s.last[name]
+		total.runCount += current.runCount
+		total.runtime += current.runtime
+		totalPrevious.runCount += previous[name].runCount
+		totalPrevious.runtime += previous[name].runtime
+		if err := s.emitSample(name, current, previous[name], interval); err != nil {
+			return 0, err
+		}
+	}
+	return cpuUsage(total.runtime-totalPrevious.runtime, interval), s.emitSample(ProgramStatsTotal, total, totalPrevious, interval)
+}
+
+// cpuUsage returns the share of interval spent in runtime, in percent of a single CPU
+func cpuUsage(runtime, interval time.Duration) float64 {
+	if interval <= 0 {
+		return 0
+	}
+	return float64(runtime) / float64(interval) * 100
+}
+
+func (s *programStats) emitSample(name string, current, previous programSample, interval time.Duration) error {
+	if s.ds == nil {
+		return nil
+	}
+
+	runs := current.runCount - previous.runCount
+	runtime := current.runtime - previous.runtime
+
+	var nsPerRun uint64
+	if runs > 0 {
+		nsPerRun = uint64(runtime.Nanoseconds()) / runs
+	}
+	usage := cpuUsage(runtime, interval)
+
+	data := s.ds.NewData()
+	if err := s.program.Set(data, []byte(name)); err != nil {
+		return err
+	}
+	for _, f := range []struct {
+		acc datasource.FieldAccessor
+		val uint64
+	}{
+		{s.runCount, current.runCount},
+		{s.runtime, uint64(current.runtime.Nanoseconds())},
+		{s.nsPerRun, nsPerRun},
+		{s.cpuUsage, math.Float64bits(usage)},
+		{s.intervalF, uint64(interval.Nanoseconds())},
+	} {
+		// The fields have no fixed size, so they need to be allocated before their values can be put
+		if err := f.acc.Set(data, make([]byte, 8)); err != nil {
+			return err
+		}
+		f.acc.PutUint64(data, f.val)
+	}
+	return s.ds.EmitAndRelease(data)
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpfoperator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+)
+
+func TestCPUUsage(t *testing.T) {
+	require.Equal(t, 50.0, cpuUsage(500*time.Millisecond, time.Second))
+	require.Equal(t, 200.0, cpuUsage(2*time.Second, time.Second))
+	require.Equal(t, 0.0, cpuUsage(time.Second, 0))
+}
+
+func TestOverBudget(t *testing.T) {
+	s := &programStats{budget: 10, budgetIntervals: 3}
+
+	// The budget must be exceeded for consecutive intervals
+	require.False(t, s.overBudget(20))
+	require.False(t, s.overBudget(20))
+	require.False(t, s.overBudget(5))
+	require.False(t, s.overBudget(20))
+	require.False(t, s.overBudget(10))
+	require.False(t, s.overBudget(20))
+	require.False(t, s.overBudget(20))
+	require.True(t, s.overBudget(20))
+
+	// No budget
+	s = &programStats{budgetIntervals: 1}
+	require.False(t, s.overBudget(1000))
+}
+
+func TestParseProgramStatsInterval(t *testing.T) {
+	interval, err := parseProgramStatsInterval(map[string]string{})
+	require.NoError(t, err)
+	require.Zero(t, interval)
+
+	interval, err = parseProgramStatsInterval(map[string]string{ParamProgramStatsInterval: "5s"})
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Second, interval)
+
+	_, err = parseProgramStatsInterval(map[string]string{ParamProgramStatsInterval: "-1s"})
+	require.Error(t, err)
+	_, err = parseProgramStatsInterval(map[string]string{ParamProgramStatsInterval: "often"})
+	require.Error(t, err)
+}
+
+func TestProgramStatsEmitSample(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	i := &ebpfInstance{paramValues: map[string]string{ParamProgramStatsInterval: "1s"}}
+	require.NoError(t, i.registerProgramStats(gadgetCtx))
+	s := i.programStats
+
+	var program string
+	var runCount, runtime, nsPerRun, interval uint64
+	var usage float64
+	s.ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		program = s.program.String(data)
+		runCount = s.runCount.Uint64(data)
+		runtime = s.runtime.Uint64(data)
+		nsPerRun = s.nsPerRun.Uint64(data)
+		usage = s.cpuUsage.Float64(data)
+		interval = s.intervalF.Uint64(data)
+		return nil
+	}, 0)
+
+	current := programSample{runCount: 30, runtime: 300 * time.Millisecond}
+	previous := programSample{runCount: 10, runtime: 100 * time.Millisecond}
+	require.NoError(t, s.emitSample("prog", current, previous, time.Second))
+	require.Equal(t, "prog", program)
+	require.Equal(t, uint64(30), runCount)
+	require.Equal(t, uint64(300*time.Millisecond), runtime)
+	require.Equal(t, uint64(10*time.Millisecond), nsPerRun)
+	require.Equal(t, 20.0, usage)
+	require.Equal(t, uint64(time.Second), interval)
+}
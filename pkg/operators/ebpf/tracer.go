@@ -18,6 +18,8 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/btf"
@@ -40,6 +42,29 @@ type Tracer struct {
 	eventSize     uint32 // needed to trim trailing bytes when reading for perf event array
 	ringbufReader *ringbuf.Reader
 	perfReader    *perf.Reader
+
+	// limiter drops events above the rate allowed for the gadget, if set
+	limiter *eventLimiter
+	// processing accumulates the time spent processing events in user space, if set
+	processing *atomic.Int64
+	// batchSize is the maximum number of events emitted at once; events are emitted one by one if it's 1 or less
+	batchSize int
+}
+
+// admit tells whether an event read at now should be processed, reporting it as lost otherwise
+func (t *Tracer) admit(now time.Time) bool {
+	if t.limiter == nil || t.limiter.allow(now) {
+		return true
+	}
+	t.ds.ReportLostDataReason(1, datasource.LostReasonRateLimit)
+	return false
+}
+
+// processed records the time spent processing an event read at start
+func (t *Tracer) processed(start time.Time) {
+	if t.processing != nil {
+		t.processing.Add(int64(time.Since(start)))
+	}
 }
 
 func validateTracerMap(traceMap *ebpf.MapSpec) error {
@@ -50,6 +75,40 @@ func validateTracerMap(traceMap *ebpf.MapSpec) error {
 	return nil
 }
 
+// useRingbufFallback turns the ring buffers used to send events to user space into perf event arrays when the kernel
+// doesn't support ring buffers. The helpers of include/gadget/buffer.h switch to perf buffers as well, so the same
+// gadget works on older kernels. Maps marked with GADGET_TRACER_MAP() are converted even if no tracer uses them.
+func (i *ebpfInstance) useRingbufFallback(ringbufAvailable bool) {
+	if ringbufAvailable {
+		return
+	}
+
+	mapNames := make(map[string]struct{})
+	for _, tracer := range i.tracers {
+		mapNames[tracer.MapName] = struct{}{}
+	}
+	it := i.collectionSpec.Types.Iterate()
+	for it.Next() {
+		if mapName, ok := hasPrefix(tracerMapPrefix)(it.Type.TypeName()); ok {
+			mapNames[mapName] = struct{}{}
+		}
+	}
+
+	for mapName := range mapNames {
+		m, ok := i.collectionSpec.Maps[mapName]
+		if !ok || m.Type != ebpf.RingBuf {
+			continue
+		}
+		i.logger.Debugf("ring buffers aren't supported, using a perf event array for map %q", mapName)
+		m.Type = ebpf.PerfEventArray
+		m.KeySize = 4
+		m.ValueSize = 4
+		// The number of CPUs is used by default
+		m.MaxEntries = 0
+		m.Flags = 0
+	}
+}
+
 func (i *ebpfInstance) populateTracer(t btf.Type, varName string) error {
 	i.logger.Debugf("populating tracer %q", varName)
 
@@ -127,82 +186,102 @@ func (t *Tracer) receiveEvents(gadgetCtx operators.GadgetContext) error {
 }
 
 func (t *Tracer) receiveEventsFromRingReader(gadgetCtx operators.GadgetContext) error {
-	slowBuf := make([]byte, t.eventSize)
-	lastSlowLen := 0
-	for {
+	return t.receiveEventsFrom(gadgetCtx, func() ([]byte, error) {
 		rec, err := t.ringbufReader.Read()
 		if err != nil {
-			return err
+			return nil, err
 		}
-		data := t.ds.NewData()
-		sample := rec.RawSample
-		if uint32(len(rec.RawSample)) < t.eventSize {
-			// event is truncated; we need to copy
-			copy(slowBuf, rec.RawSample)
-
-			// zero difference; TODO: improve
-			if len(rec.RawSample) < lastSlowLen {
-				for i := len(rec.RawSample); i < lastSlowLen; i++ {
-					slowBuf[i] = 0
-				}
-			}
-			lastSlowLen = len(rec.RawSample)
-			sample = slowBuf
-		}
-		err = t.accessor.Set(data, sample)
+		return rec.RawSample, nil
+	}, t.ringbufReader.SetDeadline)
+}
+
+func (t *Tracer) receiveEventsFromPerfReader(gadgetCtx operators.GadgetContext) error {
+	return t.receiveEventsFrom(gadgetCtx, func() ([]byte, error) {
+		rec, err := t.perfReader.Read()
 		if err != nil {
-			gadgetCtx.Logger().Warnf("error setting buffer: %v", err)
-			t.ds.Release(data)
-			continue
+			return nil, err
 		}
-		err = t.ds.EmitAndRelease(data)
-		if err != nil {
-			gadgetCtx.Logger().Warnf("error emitting data: %v", err)
+		if rec.LostSamples > 0 {
+			t.ds.ReportLostDataReason(rec.LostSamples, datasource.LostReasonBufferFull)
+			// Records reporting lost samples don't carry an event
+			return nil, nil
 		}
-	}
+		return rec.RawSample, nil
+	}, t.perfReader.SetDeadline)
 }
 
-func (t *Tracer) receiveEventsFromPerfReader(gadgetCtx operators.GadgetContext) error {
+// receiveEventsFrom emits the events returned by read until it fails. With a batch size above 1, the events already
+// waiting in the buffer are read as well, using setDeadline to not block, and emitted together using EmitBatch.
+func (t *Tracer) receiveEventsFrom(gadgetCtx operators.GadgetContext, read func() ([]byte, error), setDeadline func(time.Time)) error {
 	slowBuf := make([]byte, t.eventSize)
-	lastSlowLen := 0
+	batch := make([]datasource.Data, 0, max(t.batchSize, 1))
 	for {
-		rec, err := t.perfReader.Read()
+		sample, err := read()
 		if err != nil {
 			return err
 		}
-		data := t.ds.NewData()
-		sample := rec.RawSample
-		sampleLen := len(rec.RawSample)
-		if uint32(sampleLen) < t.eventSize {
-			// event is truncated; we need to copy
-			copy(slowBuf, rec.RawSample)
-
-			// zero difference; TODO: improve
-			if sampleLen < lastSlowLen {
-				for i := sampleLen; i < lastSlowLen; i++ {
-					slowBuf[i] = 0
+		start := time.Now()
+		if sample == nil || !t.admit(start) {
+			continue
+		}
+
+		if t.batchSize <= 1 {
+			if data := t.newData(gadgetCtx, sample, slowBuf); data != nil {
+				if err := t.ds.EmitAndRelease(data); err != nil {
+					gadgetCtx.Logger().Warnf("error emitting data: %v", err)
 				}
 			}
-			lastSlowLen = sampleLen
-			sample = slowBuf
-		} else if uint32(sampleLen) > t.eventSize {
-			// event has trailing garbage, remove it
-			sample = sample[:t.eventSize]
-		}
-		err = t.accessor.Set(data, sample)
-		if err != nil {
-			gadgetCtx.Logger().Warnf("error setting buffer: %v", err)
-			t.ds.Release(data)
+			t.processed(start)
 			continue
 		}
-		err = t.ds.EmitAndRelease(data)
-		if err != nil {
+
+		// The Data of a batch keep referencing their samples, so truncated ones can't share a buffer
+		batch = batch[:0]
+		if data := t.newData(gadgetCtx, sample, nil); data != nil {
+			batch = append(batch, data)
+		}
+		setDeadline(start)
+		for len(batch) < t.batchSize {
+			sample, err := read()
+			if err != nil {
+				// Either no events are waiting or the reader was closed, which the next read reports again
+				break
+			}
+			if sample == nil || !t.admit(time.Now()) {
+				continue
+			}
+			if data := t.newData(gadgetCtx, sample, nil); data != nil {
+				batch = append(batch, data)
+			}
+		}
+		setDeadline(time.Time{})
+		if err := t.ds.EmitBatch(batch); err != nil {
 			gadgetCtx.Logger().Warnf("error emitting data: %v", err)
 		}
-		if rec.LostSamples > 0 {
-			t.ds.ReportLostData(rec.LostSamples)
+		t.processed(start)
+	}
+}
+
+// newData returns Data holding sample, or nil if it couldn't be set. Truncated samples are padded with zeros using
+// buf, or a new buffer if buf is nil; trailing garbage, e.g. added by perf event arrays, is removed.
+func (t *Tracer) newData(gadgetCtx operators.GadgetContext, sample []byte, buf []byte) datasource.Data {
+	if uint32(len(sample)) < t.eventSize {
+		if buf == nil {
+			buf = make([]byte, t.eventSize)
 		}
+		n := copy(buf, sample)
+		clear(buf[n:])
+		sample = buf
+	} else if uint32(len(sample)) > t.eventSize {
+		sample = sample[:t.eventSize]
+	}
+	data := t.ds.NewData()
+	if err := t.accessor.Set(data, sample); err != nil {
+		gadgetCtx.Logger().Warnf("error setting buffer: %v", err)
+		t.ds.Release(data)
+		return nil
 	}
+	return data
 }
 
 func (i *ebpfInstance) runTracer(gadgetCtx operators.GadgetContext, tracer *Tracer) error {
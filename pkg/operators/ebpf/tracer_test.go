@@ -0,0 +1,159 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpfoperator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+	metadatav1 "github.com/inspektor-gadget/inspektor-gadget/pkg/metadata/v1"
+)
+
+func TestUseRingbufFallback(t *testing.T) {
+	newInstance := func() *ebpfInstance {
+		// "marked" is declared with GADGET_TRACER_MAP() but not used by a tracer
+		b := &btf.Builder{}
+		_, err := b.Add(&btf.Var{
+			Name:    tracerMapPrefix + "marked",
+			Type:    &btf.Pointer{Target: &btf.Const{Type: &btf.Void{}}},
+			Linkage: btf.GlobalVar,
+		})
+		require.NoError(t, err)
+		buf, err := b.Marshal(nil, nil)
+		require.NoError(t, err)
+		types, err := btf.LoadSpecFromReader(bytes.NewReader(buf))
+		require.NoError(t, err)
+
+		return &ebpfInstance{
+			collectionSpec: &ebpf.CollectionSpec{
+				Maps: map[string]*ebpf.MapSpec{
+					"events": {Name: "events", Type: ebpf.RingBuf, MaxEntries: 256 * 1024},
+					"marked": {Name: "marked", Type: ebpf.RingBuf, MaxEntries: 256 * 1024},
+					"other":  {Name: "other", Type: ebpf.RingBuf, MaxEntries: 4096},
+				},
+				Types: types,
+			},
+			tracers: map[string]*Tracer{
+				"open": {Tracer: metadatav1.Tracer{MapName: "events", StructName: "event"}},
+			},
+			logger: logger.DefaultLogger(),
+		}
+	}
+
+	i := newInstance()
+	i.useRingbufFallback(true)
+	for _, m := range i.collectionSpec.Maps {
+		require.Equal(t, ebpf.RingBuf, m.Type)
+	}
+
+	i = newInstance()
+	i.useRingbufFallback(false)
+	for _, name := range []string{"events", "marked"} {
+		m := i.collectionSpec.Maps[name]
+		require.Equal(t, ebpf.PerfEventArray, m.Type, name)
+		require.Equal(t, uint32(4), m.KeySize, name)
+		require.Equal(t, uint32(4), m.ValueSize, name)
+		require.Zero(t, m.MaxEntries, name)
+	}
+	// Other ring buffers aren't read by the tracers
+	require.Equal(t, ebpf.RingBuf, i.collectionSpec.Maps["other"].Type)
+}
+
+// fakeBuffer returns the samples of a wave per blocking read; with a deadline set, only the ones of the current wave
+type fakeBuffer struct {
+	waves    [][][]byte
+	deadline bool
+}
+
+var errBufferDone = errors.New("done")
+
+func (b *fakeBuffer) read() ([]byte, error) {
+	for len(b.waves) > 0 && len(b.waves[0]) == 0 {
+		if b.deadline {
+			return nil, os.ErrDeadlineExceeded
+		}
+		b.waves = b.waves[1:]
+	}
+	if len(b.waves) == 0 {
+		return nil, errBufferDone
+	}
+	sample := b.waves[0][0]
+	b.waves[0] = b.waves[0][1:]
+	return sample, nil
+}
+
+func (b *fakeBuffer) setDeadline(t time.Time) {
+	b.deadline = !t.IsZero()
+}
+
+func TestReceiveEventsBatched(t *testing.T) {
+	for _, tc := range []struct {
+		batchSize int
+		expected  []string
+	}{
+		{1, []string{"aaaa", "bb\x00\x00", "dddd"}},
+		{2, []string{"aaaa,bb\x00\x00", "dddd"}},
+	} {
+		gadgetCtx := gadgetcontext.New(context.Background(), "test")
+		ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+		require.NoError(t, err)
+		accessor, err := ds.AddField("event", datasource.WithKind(api.Kind_String))
+		require.NoError(t, err)
+		tracer := &Tracer{ds: ds, accessor: accessor, eventSize: 4, batchSize: tc.batchSize}
+
+		// The first subscriber discards "cccc", which never reaches the second one
+		ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+			if string(accessor.Get(data)) == "cccc" {
+				return datasource.ErrDiscard
+			}
+			return nil
+		}, 0)
+		var batches []string
+		ds.SubscribeBatch(func(ds datasource.DataSource, batch []datasource.Data) error {
+			var samples []string
+			for _, data := range batch {
+				samples = append(samples, string(accessor.Get(data)))
+			}
+			batches = append(batches, strings.Join(samples, ","))
+			return nil
+		}, 10)
+
+		// A nil sample is a record reporting lost samples; trailing bytes are removed
+		buf := &fakeBuffer{waves: [][][]byte{
+			{[]byte("aaaa"), []byte("bb"), []byte("cccc")},
+			{nil, []byte("dddddd")},
+		}}
+		err = tracer.receiveEventsFrom(gadgetCtx, buf.read, buf.setDeadline)
+		require.ErrorIs(t, err, errBufferDone)
+		require.Equal(t, tc.expected, batches, "batch size %d", tc.batchSize)
+
+		stats := ds.Stats()
+		require.Equal(t, uint64(4), stats.Emitted)
+		require.Equal(t, uint64(1), stats.Discarded)
+	}
+}
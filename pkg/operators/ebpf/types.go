@@ -31,4 +31,7 @@ const (
 
 	// Prefix used to mark variables used by operators
 	varPrefix = "gadget_var_"
+
+	// Prefix used to declare USDT probes with GADGET_USDT_PROBE() defined in include/gadget/usdt.h
+	usdtProbePrefix = "gadget_usdt_probe_"
 )
@@ -0,0 +1,170 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpfoperator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/uprobetracer"
+)
+
+// ParamUprobeTargetPrefix followed by the name of a uprobe, uretprobe or USDT program is the key of the param
+// overriding the target of that program, unless the gadget metadata sets another key in uprobes.<program>.key
+const ParamUprobeTargetPrefix = "uprobe-target-"
+
+// usdtSection is the section of programs attached to probes declared with GADGET_USDT_PROBE()
+const usdtSection = "usdt"
+
+func uprobeProgType(p *ebpf.ProgramSpec) (uprobetracer.ProgType, bool) {
+	switch {
+	case strings.HasPrefix(p.SectionName, uprobePrefix):
+		return uprobetracer.ProgUprobe, true
+	case strings.HasPrefix(p.SectionName, uretprobePrefix):
+		return uprobetracer.ProgUretprobe, true
+	case strings.HasPrefix(p.SectionName, usdtPrefix), p.SectionName == usdtSection:
+		return uprobetracer.ProgUSDT, true
+	}
+	return 0, false
+}
+
+func (i *ebpfInstance) populateUsdtProbe(t btf.Type, varName string) error {
+	i.logger.Debugf("populating USDT probe %q", varName)
+
+	parts := strings.Split(varName, typeSplitter)
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid USDT probe definition, expected format: <program>___<provider>___<probe>, got %q",
+			varName)
+	}
+	progName, provider, probe := parts[0], parts[1], parts[2]
+
+	p, ok := i.collectionSpec.Programs[progName]
+	if !ok {
+		return fmt.Errorf("program %q of USDT probe %s:%s not found", progName, provider, probe)
+	}
+	if p.SectionName != usdtSection {
+		return fmt.Errorf("program %q of USDT probe %s:%s must be in section %q, got %q", progName, provider,
+			probe, usdtSection, p.SectionName)
+	}
+
+	i.usdtProbes[progName] = provider + ":" + probe
+	return nil
+}
+
+// defaultUprobeTarget returns the file and symbol a program is attached to without params, as set by its section
+// name or GADGET_USDT_PROBE(). The file of USDT probes declared with the latter is empty, it has to be set by the
+// param.
+func (i *ebpfInstance) defaultUprobeTarget(p *ebpf.ProgramSpec, progType uprobetracer.ProgType) (string, string, error) {
+	if probe, ok := i.usdtProbes[p.Name]; ok {
+		return "", probe, nil
+	}
+	return uprobetracer.ParseTarget(progType, p.AttachTo)
+}
+
+// addUprobeParams validates the targets of the uprobe programs and adds a param for each of them to attach them
+// to another binary, library or symbol. Programs sharing the same key in the metadata share the param, e.g. to
+// choose the TLS library for all of its functions at once.
+func (i *ebpfInstance) addUprobeParams() error {
+	names := make([]string, 0, len(i.collectionSpec.Programs))
+	for name := range i.collectionSpec.Programs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := i.collectionSpec.Programs[name]
+		if p.Type != ebpf.Kprobe {
+			continue
+		}
+		progType, ok := uprobeProgType(p)
+		if !ok {
+			continue
+		}
+		file, symbol, err := i.defaultUprobeTarget(p, progType)
+		if err != nil {
+			return fmt.Errorf("program %q: %w", name, err)
+		}
+
+		newParam := &api.Param{
+			Key: ParamUprobeTargetPrefix + name,
+			Description: fmt.Sprintf("Attach %s to this library name or absolute path in the containers, "+
+				"optionally followed by \":<symbol>\", instead of %q", name, p.AttachTo),
+		}
+		if file == "" {
+			newParam.Description = fmt.Sprintf("Library name or absolute path in the containers of the binary "+
+				"providing the USDT probe %s traced by %s", symbol, name)
+		}
+		if info := i.config.Sub("uprobes." + name); info != nil {
+			if s := info.GetString("key"); s != "" {
+				newParam.Key = s
+			}
+			if s := info.GetString("description"); s != "" {
+				newParam.Description = s
+			}
+			newParam.DefaultValue = info.GetString("defaultValue")
+		}
+		newParam.IsMandatory = file == "" && newParam.DefaultValue == ""
+
+		key := newParam.Key
+		if _, ok := i.uprobeParams[key]; !ok {
+			if _, ok := i.params[key]; ok {
+				return fmt.Errorf("uprobe param %q of program %q collides with another param", key, name)
+			}
+			i.params[key] = &param{Param: newParam}
+		}
+		i.uprobeParams[key] = append(i.uprobeParams[key], name)
+	}
+	return nil
+}
+
+// resolveUprobeTargets applies the values of the uprobe params to the targets of the programs. A value without
+// ":" only replaces the binary or library, keeping the symbol of each program.
+func (i *ebpfInstance) resolveUprobeTargets(paramMap map[string]*params.Param) error {
+	i.uprobeTargets = make(map[string]string)
+	for key, programs := range i.uprobeParams {
+		value := paramMap[key].AsString()
+		for _, name := range programs {
+			p := i.collectionSpec.Programs[name]
+			progType, _ := uprobeProgType(p)
+			file, symbol, err := i.defaultUprobeTarget(p, progType)
+			if err != nil {
+				return fmt.Errorf("program %q: %w", name, err)
+			}
+
+			var target string
+			switch {
+			case value == "" && file == "":
+				return fmt.Errorf("param %q is required to attach %s to USDT probe %s", key, name, symbol)
+			case value == "":
+				continue
+			case strings.Contains(value, ":"):
+				target = value
+			default:
+				target = value + ":" + symbol
+			}
+			if _, _, err := uprobetracer.ParseTarget(progType, target); err != nil {
+				return fmt.Errorf("param %q: %w", key, err)
+			}
+			i.uprobeTargets[name] = target
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpfoperator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const uprobeMetadata = `
+uprobes:
+  ssl_read:
+    key: ssl-library
+    description: TLS library to trace
+  ssl_write:
+    key: ssl-library
+`
+
+func newUprobeInstance(t *testing.T, metadata string, programs ...*ebpf.ProgramSpec) *ebpfInstance {
+	config := viper.New()
+	config.SetConfigType("yaml")
+	require.NoError(t, config.ReadConfig(strings.NewReader(metadata)))
+
+	i := &ebpfInstance{
+		config:         config,
+		collectionSpec: &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{}},
+		params:         make(map[string]*param),
+		uprobeParams:   make(map[string][]string),
+		usdtProbes:     make(map[string]string),
+		logger:         logger.DefaultLogger(),
+	}
+	for _, p := range programs {
+		i.collectionSpec.Programs[p.Name] = p
+	}
+	return i
+}
+
+func uprobeProgram(name, sectionName string) *ebpf.ProgramSpec {
+	return &ebpf.ProgramSpec{
+		Name:        name,
+		Type:        ebpf.Kprobe,
+		SectionName: sectionName,
+		AttachTo:    sectionName[strings.Index(sectionName, "/")+1:],
+	}
+}
+
+func resolveUprobes(t *testing.T, i *ebpfInstance, values map[string]string) error {
+	paramMap := make(map[string]*params.Param)
+	parameters := params.Params{}
+	for name, p := range i.params {
+		param := apihelpers.ParamToParamDesc(p.Param).ToParam()
+		paramMap[name] = param
+		parameters = append(parameters, param)
+	}
+	require.NoError(t, parameters.CopyFromMap(values, ""))
+	return i.resolveUprobeTargets(paramMap)
+}
+
+func TestUprobeParams(t *testing.T) {
+	i := newUprobeInstance(t, uprobeMetadata,
+		uprobeProgram("ssl_read", "uprobe/libssl:SSL_read"),
+		uprobeProgram("ssl_write", "uprobe/libssl:SSL_write"),
+		uprobeProgram("malloc", "uretprobe/libc:malloc"),
+		uprobeProgram("query", "usdt/libc:provider:probe"),
+		&ebpf.ProgramSpec{Name: "open", Type: ebpf.Kprobe, SectionName: "kprobe/do_sys_open", AttachTo: "do_sys_open"},
+	)
+	require.NoError(t, i.addUprobeParams())
+
+	require.Len(t, i.params, 3)
+	require.Equal(t, "TLS library to trace", i.params["ssl-library"].Description)
+	require.Contains(t, i.params, ParamUprobeTargetPrefix+"malloc")
+	require.Contains(t, i.params, ParamUprobeTargetPrefix+"query")
+	require.Equal(t, []string{"ssl_read", "ssl_write"}, i.uprobeParams["ssl-library"])
+
+	// Without values, the targets of the section names are used
+	require.NoError(t, resolveUprobes(t, i, map[string]string{}))
+	require.Empty(t, i.uprobeTargets)
+
+	// A path or library name keeps the symbol of each program, a full target replaces both
+	require.NoError(t, resolveUprobes(t, i, map[string]string{
+		"ssl-library":                      "/usr/lib/libgnutls.so.30",
+		ParamUprobeTargetPrefix + "malloc": "/usr/bin/app:my_malloc",
+		ParamUprobeTargetPrefix + "query":  "libpthread",
+	}))
+	require.Equal(t, map[string]string{
+		"ssl_read":  "/usr/lib/libgnutls.so.30:SSL_read",
+		"ssl_write": "/usr/lib/libgnutls.so.30:SSL_write",
+		"malloc":    "/usr/bin/app:my_malloc",
+		"query":     "libpthread:provider:probe",
+	}, i.uprobeTargets)
+
+	// Relative paths are ambiguous in containers
+	require.Error(t, resolveUprobes(t, i, map[string]string{"ssl-library": "lib/libssl.so"}))
+	// USDT targets need both the provider and the probe
+	require.Error(t, resolveUprobes(t, i, map[string]string{ParamUprobeTargetPrefix + "query": "libc:probe"}))
+}
+
+func TestUprobeParamsInvalid(t *testing.T) {
+	for name, tc := range map[string]struct {
+		metadata string
+		programs []*ebpf.ProgramSpec
+	}{
+		"missing symbol": {
+			programs: []*ebpf.ProgramSpec{uprobeProgram("read", "uprobe/libssl")},
+		},
+		"relative path": {
+			programs: []*ebpf.ProgramSpec{uprobeProgram("read", "uprobe/lib/libssl.so:SSL_read")},
+		},
+		"invalid usdt": {
+			programs: []*ebpf.ProgramSpec{uprobeProgram("query", "usdt/libc:probe")},
+		},
+		"colliding key": {
+			metadata: "uprobes:\n  read:\n    key: " + ParamPinMaps,
+			programs: []*ebpf.ProgramSpec{uprobeProgram("read", "uprobe/libssl:SSL_read")},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			i := newUprobeInstance(t, tc.metadata, tc.programs...)
+			i.params[ParamPinMaps] = &param{Param: &api.Param{Key: ParamPinMaps}}
+			require.Error(t, i.addUprobeParams())
+		})
+	}
+}
+
+func TestUsdtProbeDeclaration(t *testing.T) {
+	usdtProgram := func(name string) *ebpf.ProgramSpec {
+		return &ebpf.ProgramSpec{Name: name, Type: ebpf.Kprobe, SectionName: "usdt"}
+	}
+	i := newUprobeInstance(t, `
+uprobes:
+  query_start:
+    key: postgres
+    defaultValue: /usr/lib/postgresql/16/bin/postgres
+`,
+		usdtProgram("function_entry"),
+		usdtProgram("query_start"),
+	)
+	require.NoError(t, i.populateUsdtProbe(nil, "function_entry___python___function__entry"))
+	require.NoError(t, i.populateUsdtProbe(nil, "query_start___postgresql___query__start"))
+	require.NoError(t, i.addUprobeParams())
+
+	// The binary has to be set unless the metadata sets a default
+	python := i.params[ParamUprobeTargetPrefix+"function_entry"]
+	require.True(t, python.IsMandatory)
+	require.False(t, i.params["postgres"].IsMandatory)
+	require.Error(t, resolveUprobes(t, i, map[string]string{}))
+
+	require.NoError(t, resolveUprobes(t, i, map[string]string{
+		ParamUprobeTargetPrefix + "function_entry": "/usr/bin/python3.12",
+	}))
+	require.Equal(t, map[string]string{
+		"function_entry": "/usr/bin/python3.12:python:function__entry",
+		"query_start":    "/usr/lib/postgresql/16/bin/postgres:postgresql:query__start",
+	}, i.uprobeTargets)
+
+	// Declarations must match a program in the usdt section
+	require.Error(t, i.populateUsdtProbe(nil, "missing___python___function__entry"))
+	require.Error(t, i.populateUsdtProbe(nil, "function_entry___python"))
+	i.collectionSpec.Programs["malloc"] = uprobeProgram("malloc", "uprobe/libc:malloc")
+	require.Error(t, i.populateUsdtProbe(nil, "malloc___libc___malloc"))
+
+	// Programs in the usdt section need a declaration
+	i = newUprobeInstance(t, "", usdtProgram("function_entry"))
+	require.Error(t, i.addUprobeParams())
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exechash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// fileKey identifies a specific version of a file; as long as neither the inode nor its modification time or size
+// change, the contents are assumed to be the same
+type fileKey struct {
+	dev   uint64
+	ino   uint64
+	mtime int64
+	size  int64
+}
+
+// hashCache computes SHA256 hashes of files and caches them by fileKey
+type hashCache struct {
+	mu         sync.Mutex
+	entries    map[fileKey]string
+	maxEntries int
+}
+
+func newHashCache(maxEntries int) *hashCache {
+	return &hashCache{
+		entries:    make(map[fileKey]string),
+		maxEntries: maxEntries,
+	}
+}
+
+// Hash returns the hex-encoded SHA256 hash of the file at path; files larger than maxSize are rejected unless
+// maxSize is 0
+func (c *hashCache) Hash(path string, maxSize int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("%q is not a regular file", path)
+	}
+	if maxSize > 0 && fi.Size() > maxSize {
+		return "", fmt.Errorf("%q exceeds maximum size (%d > %d)", path, fi.Size(), maxSize)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("unsupported stat for %q", path)
+	}
+
+	key := fileKey{
+		dev:   uint64(st.Dev),
+		ino:   st.Ino,
+		mtime: fi.ModTime().UnixNano(),
+		size:  fi.Size(),
+	}
+
+	c.mu.Lock()
+	hash, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok {
+		return hash, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("reading %q: %w", path, err)
+	}
+	hash = hex.EncodeToString(h.Sum(nil))
+
+	c.mu.Lock()
+	if len(c.entries) >= c.maxEntries {
+		// Simply start over instead of tracking usage; binaries that are executed often will quickly be cached again
+		clear(c.entries)
+	}
+	c.entries[key] = hash
+	c.mu.Unlock()
+
+	return hash, nil
+}
+
+// Len returns the number of cached hashes
+func (c *hashCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exechash
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "binary")
+	require.NoError(t, os.WriteFile(path, []byte("hello"), 0o755))
+
+	cache := newHashCache(2)
+
+	hash, err := cache.Hash(path, 0)
+	require.NoError(t, err)
+	require.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", hash)
+	require.Equal(t, 1, cache.Len())
+
+	// Same file, served from the cache
+	hash, err = cache.Hash(path, 0)
+	require.NoError(t, err)
+	require.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", hash)
+	require.Equal(t, 1, cache.Len())
+
+	// Changing the file must invalidate the cached hash
+	require.NoError(t, os.WriteFile(path, []byte("world"), 0o755))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	hash, err = cache.Hash(path, 0)
+	require.NoError(t, err)
+	require.Equal(t, "486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7", hash)
+	require.Equal(t, 2, cache.Len())
+
+	// Exceeding maxEntries starts over
+	other := filepath.Join(t.TempDir(), "other")
+	require.NoError(t, os.WriteFile(other, []byte("other"), 0o755))
+	_, err = cache.Hash(other, 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, cache.Len())
+}
+
+func TestHashCacheErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "binary")
+	require.NoError(t, os.WriteFile(path, []byte("hello"), 0o755))
+
+	cache := newHashCache(10)
+	_, err := cache.Hash(path, 4)
+	require.Error(t, err)
+	_, err = cache.Hash(dir, 0)
+	require.Error(t, err)
+	_, err = cache.Hash(filepath.Join(dir, "missing"), 0)
+	require.Error(t, err)
+	require.Equal(t, 0, cache.Len())
+}
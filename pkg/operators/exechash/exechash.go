@@ -0,0 +1,201 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package exechash provides an operator that computes the SHA256 hash of the binaries executed by the processes
+// referenced in events. Binaries are read through /proc/<pid>/exe, so they're resolved inside the mount namespace of
+// the container the process is running in. Hashes are cached by inode and modification time.
+package exechash
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/ioc"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+)
+
+const (
+	OperatorName = "exechash"
+
+	ParamEnable  = "enable"
+	ParamMaxSize = "max-size"
+
+	// AnnotationPid marks a field containing the (host) pid of a process whose binary should be hashed
+	AnnotationPid = "exechash.pid"
+
+	// Priority is chosen so that the hash is available before the ioc operator runs
+	Priority = ioc.Priority - 100
+
+	defaultMaxSize  = 256 * 1024 * 1024
+	maxCacheEntries = 4096
+)
+
+type exechashOperator struct {
+	cache *hashCache
+}
+
+func (o *exechashOperator) Name() string {
+	return OperatorName
+}
+
+func (o *exechashOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *exechashOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *exechashOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:          ParamEnable,
+			Title:        "Hash executables",
+			Description:  "Compute the SHA256 hash of executed binaries; binaries that aren't cached yet are read completely, which can slow down gadgets emitting many events",
+			DefaultValue: "false",
+			TypeHint:     api.TypeBool,
+		},
+		{
+			Key:          ParamMaxSize,
+			Title:        "Maximum size",
+			Description:  "Binaries larger than this (in bytes) are not hashed; use 0 to disable the limit",
+			DefaultValue: strconv.Itoa(defaultMaxSize),
+			TypeHint:     api.TypeInt64,
+		},
+	}
+}
+
+type hashField struct {
+	pid  datasource.FieldAccessor
+	hash datasource.FieldAccessor
+}
+
+func (o *exechashOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &exechashOperatorInstance{
+		cache:   o.cache,
+		maxSize: params.Get(ParamMaxSize).AsInt64(),
+		fields:  make(map[datasource.DataSource][]hashField),
+	}
+
+	if !params.Get(ParamEnable).AsBool() {
+		return inst, nil
+	}
+
+	for _, ds := range gadgetCtx.GetDataSources() {
+		for _, f := range ds.Accessors(false) {
+			if v, ok := f.Annotations()[AnnotationPid]; !ok || v != "true" {
+				continue
+			}
+			switch f.Type() {
+			case api.Kind_Uint32, api.Kind_Int32:
+			default:
+				return nil, fmt.Errorf("field %q annotated with %q must be of type uint32 or int32", f.Name(), AnnotationPid)
+			}
+
+			// Place the hash next to the pid field, e.g. "pid" -> "pid_exe_sha256"
+			hash, err := ds.AddField(f.Name()+"_exe_sha256",
+				datasource.WithKind(api.Kind_String),
+				datasource.WithAnnotations(map[string]string{
+					"description":      "SHA256 hash of the executable",
+					ioc.AnnotationType: string(ioc.KindHash),
+					"columns.width":    "64",
+				}),
+				datasource.WithFlags(datasource.FieldFlagHidden),
+			)
+			if err != nil {
+				return nil, fmt.Errorf("adding hash field: %w", err)
+			}
+			inst.fields[ds] = append(inst.fields[ds], hashField{pid: f, hash: hash})
+		}
+	}
+
+	return inst, nil
+}
+
+func (o *exechashOperator) Priority() int {
+	return Priority
+}
+
+type exechashOperatorInstance struct {
+	cache   *hashCache
+	maxSize int64
+	fields  map[datasource.DataSource][]hashField
+}
+
+func (o *exechashOperatorInstance) Name() string {
+	return OperatorName
+}
+
+func (o *exechashOperatorInstance) hash(gadgetCtx operators.GadgetContext, fields []hashField, data datasource.Data) error {
+	for _, f := range fields {
+		var pid uint32
+		switch f.pid.Type() {
+		case api.Kind_Uint32:
+			pid = f.pid.Uint32(data)
+		case api.Kind_Int32:
+			pid = uint32(f.pid.Int32(data))
+		}
+		if pid == 0 {
+			continue
+		}
+
+		// The process might already be gone; in that case the field is left empty
+		hash, err := o.cache.Hash(filepath.Join(host.HostProcFs, strconv.FormatUint(uint64(pid), 10), "exe"), o.maxSize)
+		if err != nil {
+			gadgetCtx.Logger().Debugf("exechash: hashing executable of pid %d: %v", pid, err)
+			continue
+		}
+		if err := f.hash.Set(data, []byte(hash)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *exechashOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error {
+	for ds, fields := range o.fields {
+		fields := fields
+		ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+			return o.hash(gadgetCtx, fields, data)
+		}, Priority)
+	}
+	return nil
+}
+
+func (o *exechashOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+func (o *exechashOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+func init() {
+	// The cache is shared between all gadget instances
+	operators.RegisterDataOperator(&exechashOperator{
+		cache: newHashCache(maxCacheEntries),
+	})
+}
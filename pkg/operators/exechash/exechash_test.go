@@ -0,0 +1,86 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exechash
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+)
+
+func newTestDataSource(t *testing.T) (*gadgetcontext.GadgetContext, datasource.DataSource, datasource.FieldAccessor) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+	pid, err := ds.AddField("pid", datasource.WithKind(api.Kind_Uint32),
+		datasource.WithAnnotations(map[string]string{AnnotationPid: "true"}))
+	require.NoError(t, err)
+	return gadgetCtx, ds, pid
+}
+
+func TestExechashDisabledByDefault(t *testing.T) {
+	gadgetCtx, ds, _ := newTestDataSource(t)
+
+	// The instance is returned anyway, so that the params of the operator are exposed
+	inst, err := (&exechashOperator{cache: newHashCache(1)}).InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+	require.NoError(t, err)
+	require.NotNil(t, inst)
+	require.Nil(t, ds.GetField("pid_exe_sha256"))
+}
+
+func TestExechash(t *testing.T) {
+	procFs := host.HostProcFs
+	host.HostProcFs = "/proc"
+	t.Cleanup(func() { host.HostProcFs = procFs })
+
+	executable, err := os.Executable()
+	require.NoError(t, err)
+	cache := newHashCache(1)
+	expected, err := cache.Hash(executable, 0)
+	require.NoError(t, err)
+
+	gadgetCtx, ds, pid := newTestDataSource(t)
+	inst, err := (&exechashOperator{cache: cache}).InstantiateDataOperator(gadgetCtx, api.ParamValues{
+		ParamEnable: "true",
+	})
+	require.NoError(t, err)
+	hash := ds.GetField("pid_exe_sha256")
+	require.NotNil(t, hash)
+
+	require.NoError(t, inst.(operators.PreStart).PreStart(gadgetCtx))
+	var hashes []string
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		hashes = append(hashes, hash.String(data))
+		return nil
+	}, Priority+1)
+
+	// Events without pid and of processes that are gone are left alone
+	for _, p := range []uint32{uint32(os.Getpid()), 0, 1 << 30} {
+		data := ds.NewData()
+		require.NoError(t, pid.Set(data, binary.NativeEndian.AppendUint32(nil, p)))
+		require.NoError(t, ds.EmitAndRelease(data))
+	}
+	require.Equal(t, []string{expected, "", ""}, hashes)
+	require.Equal(t, 1, cache.Len())
+}
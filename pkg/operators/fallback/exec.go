@@ -0,0 +1,220 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fallback
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+)
+
+// Constants of the proc connector, see include/uapi/linux/connector.h and include/uapi/linux/cn_proc.h
+const (
+	cnIdxProc          = 1
+	cnValProc          = 1
+	procCnMcastListen  = 1
+	procEventExec      = 0x00000002
+	cnMsgSize          = 20
+	procEventHdrSize   = 16
+	procEventExecSize  = 8
+	subscriptionLength = 4
+)
+
+// execEvent is a PROC_EVENT_EXEC event of the proc connector
+type execEvent struct {
+	// timestamp is taken from CLOCK_MONOTONIC
+	timestamp uint64
+	tgid      uint32
+}
+
+// execSource emits the executions of processes reported by the proc connector. Only successful executions are
+// reported, after the new program was loaded, so that the details of the process are read from procfs.
+type execSource struct {
+	timestamp datasource.FieldAccessor
+	pid       datasource.FieldAccessor
+	ppid      datasource.FieldAccessor
+	comm      datasource.FieldAccessor
+	uid       datasource.FieldAccessor
+	gid       datasource.FieldAccessor
+	loginuid  datasource.FieldAccessor
+	sessionid datasource.FieldAccessor
+	retval    datasource.FieldAccessor
+	argsCount datasource.FieldAccessor
+	argsSize  datasource.FieldAccessor
+	args      datasource.FieldAccessor
+	mntns     datasource.FieldAccessor
+	upper     datasource.FieldAccessor
+
+	bootOffset uint64
+}
+
+func newExecSource() source {
+	return &execSource{}
+}
+
+func (s *execSource) dataSourceName() string {
+	return "exec"
+}
+
+func (s *execSource) addFields(ds datasource.DataSource) error {
+	var err error
+	if s.timestamp, err = addTimestampField(ds); err != nil {
+		return err
+	}
+	hidden := datasource.WithFlags(datasource.FieldFlagHidden)
+	for _, f := range []struct {
+		acc         *datasource.FieldAccessor
+		name        string
+		kind        api.Kind
+		annotations map[string]string
+		opts        []datasource.FieldOption
+	}{
+		{&s.pid, "pid", api.Kind_Uint32, templateAnnotations("pid"), nil},
+		{&s.ppid, "ppid", api.Kind_Uint32, templateAnnotations("pid"), nil},
+		{&s.comm, "comm", api.Kind_String, templateAnnotations("comm"), nil},
+		{&s.uid, "uid", api.Kind_Uint32, templateAnnotations("uid"), nil},
+		{&s.gid, "gid", api.Kind_Uint32, templateAnnotations("uid"), nil},
+		{&s.loginuid, "loginuid", api.Kind_Uint32, nil, []datasource.FieldOption{hidden}},
+		{&s.sessionid, "sessionid", api.Kind_Uint32, nil, []datasource.FieldOption{hidden}},
+		{&s.retval, "retval", api.Kind_Int32, map[string]string{"columns.width": "3", "columns.alignment": "left"}, nil},
+		{&s.argsCount, "args_count", api.Kind_Int32, nil, []datasource.FieldOption{hidden}},
+		{&s.argsSize, "args_size", api.Kind_Uint32, nil, []datasource.FieldOption{hidden}},
+		{&s.args, "args", api.Kind_String, nil, []datasource.FieldOption{hidden}},
+	} {
+		acc, err := addField(ds, f.name, f.kind, f.annotations, f.opts...)
+		if err != nil {
+			return err
+		}
+		*f.acc = acc
+	}
+	if s.mntns, err = addMntNsField(ds); err != nil {
+		return err
+	}
+	s.upper, err = addField(ds, "upper_layer", api.Kind_Bool, map[string]string{
+		"description":       "Whether the executable is in the upper layer of the overlay filesystem",
+		"columns.width":     "5",
+		"columns.alignment": "left",
+	})
+	return err
+}
+
+// open subscribes to the events of the proc connector, which requires CAP_NET_ADMIN
+func (s *execSource) open(logger logger.Logger) (*os.File, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, unix.NETLINK_CONNECTOR)
+	if err != nil {
+		return nil, fmt.Errorf("creating proc connector socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: cnIdxProc}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("binding proc connector socket: %w", err)
+	}
+	if err := unix.Sendto(fd, subscriptionMessage(procCnMcastListen), 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("subscribing to proc connector: %w", err)
+	}
+	s.bootOffset = bootOffset()
+	return os.NewFile(uintptr(fd), "proc-connector"), nil
+}
+
+func (s *execSource) refresh(logger logger.Logger) {}
+
+// subscriptionMessage returns the netlink message (un)subscribing from the events of the proc connector
+func subscriptionMessage(op uint32) []byte {
+	msg := make([]byte, unix.NLMSG_HDRLEN+cnMsgSize+subscriptionLength)
+	bo := binary.NativeEndian
+	// struct nlmsghdr
+	bo.PutUint32(msg[0:], uint32(len(msg)))
+	bo.PutUint16(msg[4:], unix.NLMSG_DONE)
+	bo.PutUint32(msg[12:], uint32(os.Getpid()))
+	// struct cn_msg
+	cn := msg[unix.NLMSG_HDRLEN:]
+	bo.PutUint32(cn[0:], cnIdxProc)
+	bo.PutUint32(cn[4:], cnValProc)
+	bo.PutUint16(cn[16:], subscriptionLength)
+	bo.PutUint32(cn[cnMsgSize:], op)
+	return msg
+}
+
+// parseExecEvents returns the exec events in the netlink messages of buf and ignores the other events
+func parseExecEvents(buf []byte) ([]execEvent, error) {
+	msgs, err := syscall.ParseNetlinkMessage(buf)
+	if err != nil {
+		return nil, fmt.Errorf("parsing netlink messages: %w", err)
+	}
+	var events []execEvent
+	for _, msg := range msgs {
+		if msg.Header.Type != unix.NLMSG_DONE {
+			continue
+		}
+		if len(msg.Data) < cnMsgSize+procEventHdrSize {
+			return events, errors.New("proc connector message too short")
+		}
+		// struct proc_event
+		ev := msg.Data[cnMsgSize:]
+		bo := binary.NativeEndian
+		if bo.Uint32(ev[0:]) != procEventExec {
+			continue
+		}
+		if len(ev) < procEventHdrSize+procEventExecSize {
+			return events, errors.New("exec event too short")
+		}
+		events = append(events, execEvent{
+			timestamp: bo.Uint64(ev[8:]),
+			// process_pid is the thread, process_tgid the process
+			tgid: bo.Uint32(ev[procEventHdrSize+4:]),
+		})
+	}
+	return events, nil
+}
+
+func (s *execSource) handle(e *emitter, buf []byte) error {
+	events, err := parseExecEvents(buf)
+	for _, ev := range events {
+		p := readProcess(ev.tgid)
+		if !e.selected(p.mntns) {
+			continue
+		}
+		args, argsSize := readCmdline(ev.tgid)
+		if err := e.emit([]fieldValue{
+			{s.timestamp, e.u64(ev.timestamp + s.bootOffset)},
+			{s.pid, e.u32(p.pid)},
+			{s.ppid, e.u32(p.ppid)},
+			{s.comm, []byte(p.comm)},
+			{s.uid, e.u32(p.uid)},
+			{s.gid, e.u32(p.gid)},
+			{s.loginuid, e.u32(p.loginuid)},
+			{s.sessionid, e.u32(p.sessionid)},
+			{s.retval, e.u32(0)},
+			{s.argsCount, e.u32(uint32(len(args)))},
+			{s.argsSize, e.u32(argsSize)},
+			{s.args, []byte(strings.Join(args, " "))},
+			{s.mntns, e.u64(p.mntns)},
+			// Telling whether the executable comes from the upper layer requires the inode of the kernel
+			{s.upper, e.bool(false)},
+		}); err != nil {
+			e.logger.Warnf("emitting exec event: %v", err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,281 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fallback provides a data operator that emits basic process executions and file opens without eBPF, for
+// kernels or environments where eBPF is disabled. Executions are read from the proc connector and file opens from
+// fanotify. The data sources have the same fields as the ones of the trace_exec and trace_open gadgets, so that
+// they can be consumed by the same operators and downstream pipelines. It's used by running an image called
+// "fallback:<gadget>", e.g. "fallback:trace_exec".
+package fallback
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cilium/ebpf"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	OperatorName = "fallback"
+
+	// ImagePrefix is the prefix of the image names referring to fallback gadgets
+	ImagePrefix = "fallback:"
+
+	// readBufferSize is big enough for several events of the proc connector and fanotify per read
+	readBufferSize = 64 * 1024
+
+	// refreshInterval is how often sources look for new things to watch, like filesystems of new containers
+	refreshInterval = 5 * time.Second
+)
+
+// source reads the events of a fallback gadget from a file descriptor provided by the kernel
+type source interface {
+	// dataSourceName returns the name of the data source, which is the one of the image-based gadget
+	dataSourceName() string
+	addFields(ds datasource.DataSource) error
+	// open returns the file the events are read from; it must be non-blocking, so that reading is interrupted when
+	// it's closed
+	open(logger logger.Logger) (*os.File, error)
+	// refresh is called periodically while the gadget is running
+	refresh(logger logger.Logger)
+	// handle emits the events in buf, which was read from the file
+	handle(e *emitter, buf []byte) error
+}
+
+// sources are the fallback gadgets, by the name of the image-based gadgets they stand in for
+var sources = map[string]func() source{
+	"trace_exec": newExecSource,
+	"trace_open": newOpenSource,
+}
+
+// IsFallbackImage tells whether imageName refers to a fallback gadget
+var IsFallbackImage = operators.MatchImagePrefix(ImagePrefix)
+
+func newSource(imageName string) (source, error) {
+	name := strings.TrimPrefix(imageName, ImagePrefix)
+	newSrc, ok := sources[name]
+	if !ok {
+		names := make([]string, 0, len(sources))
+		for n := range sources {
+			names = append(names, ImagePrefix+n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("fallback gadget %q not found, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return newSrc(), nil
+}
+
+type fallbackOperator struct{}
+
+func (o *fallbackOperator) Name() string {
+	return OperatorName
+}
+
+func (o *fallbackOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *fallbackOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *fallbackOperator) InstanceParams() api.Params {
+	return nil
+}
+
+func (o *fallbackOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	if !IsFallbackImage(gadgetCtx.ImageName()) {
+		return nil, nil
+	}
+
+	src, err := newSource(gadgetCtx.ImageName())
+	if err != nil {
+		return nil, err
+	}
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, src.dataSourceName())
+	if err != nil {
+		return nil, fmt.Errorf("adding data source %q: %w", src.dataSourceName(), err)
+	}
+	if err := src.addFields(ds); err != nil {
+		return nil, fmt.Errorf("adding fields to data source %q: %w", src.dataSourceName(), err)
+	}
+	return &fallbackOperatorInstance{src: src, emitter: &emitter{ds: ds, logger: gadgetCtx.Logger()}}, nil
+}
+
+func (o *fallbackOperator) Priority() int {
+	return operators.OperatorImagePriority
+}
+
+type fallbackOperatorInstance struct {
+	src     source
+	emitter *emitter
+
+	file *os.File
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (o *fallbackOperatorInstance) Name() string {
+	return OperatorName
+}
+
+func (o *fallbackOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	// Without eBPF, the events can't be filtered in the kernel; the map of the mount namespaces of the selected
+	// containers is still used if it's available
+	if filter, ok := gadgetCtx.GetVar(gadgets.FilterByMntNsName); ok && filter == true {
+		if m, ok := gadgetCtx.GetVar(gadgets.MntNsFilterMapName); ok {
+			o.emitter.mntnsFilter, _ = m.(*ebpf.Map)
+		}
+	}
+
+	f, err := o.src.open(gadgetCtx.Logger())
+	if err != nil {
+		return err
+	}
+	o.file = f
+	o.done = make(chan struct{})
+
+	o.wg.Add(2)
+	go func() {
+		defer o.wg.Done()
+		if err := o.read(f); err != nil {
+			gadgetCtx.Logger().Errorf("reading events: %v", err)
+		}
+	}()
+	go func() {
+		defer o.wg.Done()
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-o.done:
+				return
+			case <-ticker.C:
+				o.src.refresh(gadgetCtx.Logger())
+			}
+		}
+	}()
+	return nil
+}
+
+// read emits the events read from r until it's closed
+func (o *fallbackOperatorInstance) read(r io.Reader) error {
+	buf := make([]byte, readBufferSize)
+	for {
+		n, err := r.Read(buf)
+		switch {
+		case errors.Is(err, syscall.ENOBUFS):
+			// The socket of the proc connector overflowed; reading continues with the next events
+			o.emitter.logger.Warnf("events were lost")
+			continue
+		case errors.Is(err, io.EOF), errors.Is(err, os.ErrClosed):
+			return nil
+		case err != nil:
+			return err
+		}
+		if err := o.src.handle(o.emitter, buf[:n]); err != nil {
+			o.emitter.logger.Debugf("handling events: %v", err)
+		}
+	}
+}
+
+func (o *fallbackOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	if o.file == nil {
+		return nil
+	}
+	close(o.done)
+	o.file.Close()
+	o.wg.Wait()
+	o.file = nil
+	return nil
+}
+
+// fieldValue is the value of a field of an event, encoded with the byte order of the data source
+type fieldValue struct {
+	acc   datasource.FieldAccessor
+	value []byte
+}
+
+// emitter emits the events of a source to the data source
+type emitter struct {
+	ds     datasource.DataSource
+	logger logger.Logger
+
+	// mntnsFilter holds the mount namespaces of the selected containers, if any
+	mntnsFilter *ebpf.Map
+}
+
+// selected tells whether the events of mntns are to be emitted
+func (e *emitter) selected(mntns uint64) bool {
+	if e.mntnsFilter == nil {
+		return true
+	}
+	var v uint32
+	return e.mntnsFilter.Lookup(mntns, &v) == nil
+}
+
+func (e *emitter) emit(values []fieldValue) error {
+	data := e.ds.NewData()
+	for _, f := range values {
+		if err := f.acc.Set(data, f.value); err != nil {
+			e.ds.Release(data)
+			return fmt.Errorf("setting field %q: %w", f.acc.Name(), err)
+		}
+	}
+	return e.ds.EmitAndRelease(data)
+}
+
+func (e *emitter) u16(v uint16) []byte {
+	b := make([]byte, 2)
+	e.ds.ByteOrder().PutUint16(b, v)
+	return b
+}
+
+func (e *emitter) u32(v uint32) []byte {
+	b := make([]byte, 4)
+	e.ds.ByteOrder().PutUint32(b, v)
+	return b
+}
+
+func (e *emitter) u64(v uint64) []byte {
+	b := make([]byte, 8)
+	e.ds.ByteOrder().PutUint64(b, v)
+	return b
+}
+
+func (e *emitter) bool(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+func init() {
+	operators.RegisterOperatorImages(IsFallbackImage)
+	operators.RegisterDataOperator(&fallbackOperator{})
+}
@@ -0,0 +1,192 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fallback
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+)
+
+// procConnectorMessage returns a netlink message of the proc connector with an event of type what
+func procConnectorMessage(what uint32, timestamp uint64, pid, tgid uint32) []byte {
+	msg := make([]byte, unix.NLMSG_HDRLEN+cnMsgSize+procEventHdrSize+procEventExecSize)
+	bo := binary.NativeEndian
+	bo.PutUint32(msg[0:], uint32(len(msg)))
+	bo.PutUint16(msg[4:], unix.NLMSG_DONE)
+	ev := msg[unix.NLMSG_HDRLEN+cnMsgSize:]
+	bo.PutUint32(ev[0:], what)
+	bo.PutUint64(ev[8:], timestamp)
+	bo.PutUint32(ev[procEventHdrSize:], pid)
+	bo.PutUint32(ev[procEventHdrSize+4:], tgid)
+	return msg
+}
+
+func TestParseExecEvents(t *testing.T) {
+	// A fork event is ignored
+	buf := append(procConnectorMessage(0x00000001, 1000, 42, 42), procConnectorMessage(procEventExec, 2000, 43, 42)...)
+	events, err := parseExecEvents(buf)
+	require.NoError(t, err)
+	require.Equal(t, []execEvent{{timestamp: 2000, tgid: 42}}, events)
+
+	_, err = parseExecEvents(procConnectorMessage(procEventExec, 2000, 43, 42)[:unix.NLMSG_HDRLEN+cnMsgSize+4])
+	require.Error(t, err)
+
+	msg := subscriptionMessage(procCnMcastListen)
+	require.Len(t, msg, unix.NLMSG_HDRLEN+cnMsgSize+subscriptionLength)
+	require.Equal(t, uint32(procCnMcastListen), binary.NativeEndian.Uint32(msg[unix.NLMSG_HDRLEN+cnMsgSize:]))
+}
+
+func TestParseOpenEvents(t *testing.T) {
+	event := func(mask uint64, fd, pid int32) []byte {
+		buf := make([]byte, fanotifyMetadataSize)
+		bo := binary.NativeEndian
+		bo.PutUint32(buf[0:], fanotifyMetadataSize)
+		buf[4] = unix.FANOTIFY_METADATA_VERSION
+		bo.PutUint16(buf[6:], fanotifyMetadataSize)
+		bo.PutUint64(buf[8:], mask)
+		bo.PutUint32(buf[16:], uint32(fd))
+		bo.PutUint32(buf[20:], uint32(pid))
+		return buf
+	}
+
+	events, err := parseOpenEvents(append(event(unix.FAN_OPEN, 5, 42), event(unix.FAN_Q_OVERFLOW, -1, 0)...))
+	require.NoError(t, err)
+	require.Equal(t, []openEvent{
+		{mask: unix.FAN_OPEN, fd: 5, pid: 42},
+		{mask: unix.FAN_Q_OVERFLOW, fd: -1, pid: 0},
+	}, events)
+
+	invalid := event(unix.FAN_OPEN, 5, 42)
+	invalid[4] = 1
+	_, err = parseOpenEvents(invalid)
+	require.Error(t, err)
+}
+
+func TestParseMountInfo(t *testing.T) {
+	mounts := parseMountInfo("22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw\n" +
+		"23 22 0:21 / /proc rw,nosuid shared:12 - proc proc rw\n" +
+		"24 22 0:45 / /run/containerd/io.containerd.runtime.v2.task/k8s.io/abc/rootfs rw - overlay overlay rw\n" +
+		"25 22 8:2 / /mnt/my\\040disk rw - xfs /dev/sda2 rw\n")
+	require.Equal(t, []mount{
+		{device: "8:1", mountPoint: "/", fsType: "ext4"},
+		{device: "0:21", mountPoint: "/proc", fsType: "proc"},
+		{device: "0:45", mountPoint: "/run/containerd/io.containerd.runtime.v2.task/k8s.io/abc/rootfs", fsType: "overlay"},
+		{device: "8:2", mountPoint: "/mnt/my disk", fsType: "xfs"},
+	}, mounts)
+}
+
+func TestReadProcess(t *testing.T) {
+	oldProcFs := host.HostProcFs
+	t.Cleanup(func() { host.HostProcFs = oldProcFs })
+	host.HostProcFs = t.TempDir()
+
+	dir := filepath.Join(host.HostProcFs, "42")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	for name, content := range map[string]string{
+		"status":    "Name:\tcat\nPid:\t42\nPPid:\t1\nUid:\t1000\t1000\t1000\t1000\nGid:\t100\t100\t100\t100\n",
+		"comm":      "cat\n",
+		"loginuid":  "1000",
+		"sessionid": "4294967295",
+		"cmdline":   "cat\x00/etc/hosts\x00",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+
+	require.Equal(t, &process{
+		pid:       42,
+		ppid:      1,
+		uid:       1000,
+		gid:       100,
+		loginuid:  1000,
+		sessionid: 4294967295,
+		comm:      "cat",
+	}, readProcess(42))
+	args, size := readCmdline(42)
+	require.Equal(t, []string{"cat", "/etc/hosts"}, args)
+	require.Equal(t, uint32(15), size)
+
+	// The details of processes that are gone are left empty
+	require.Equal(t, &process{pid: 43}, readProcess(43))
+}
+
+func TestFallbackOperator(t *testing.T) {
+	op := &fallbackOperator{}
+
+	// Other images are left to other operators
+	inst, err := op.InstantiateDataOperator(gadgetcontext.New(context.Background(), "trace_exec"), api.ParamValues{})
+	require.NoError(t, err)
+	require.Nil(t, inst)
+
+	_, err = op.InstantiateDataOperator(gadgetcontext.New(context.Background(), ImagePrefix+"trace_dns"), api.ParamValues{})
+	require.Error(t, err)
+
+	// The data sources have the fields of the image-based gadgets
+	for image, fields := range map[string][]string{
+		"trace_exec": {"timestamp", "pid", "ppid", "comm", "uid", "gid", "loginuid", "sessionid", "retval", "args_count", "args_size", "args", "mntns_id", "upper_layer"},
+		"trace_open": {"timestamp", "pid", "comm", "uid", "gid", "flags", "mode", "err", "fd", "fname", "mntns_id"},
+	} {
+		gadgetCtx := gadgetcontext.New(context.Background(), ImagePrefix+image)
+		_, err := op.InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+		require.NoError(t, err)
+		require.Len(t, gadgetCtx.GetDataSources(), 1)
+		for _, ds := range gadgetCtx.GetDataSources() {
+			for _, name := range fields {
+				require.NotNil(t, ds.GetField(name), "%s: %s", image, name)
+			}
+			require.Len(t, ds.GetFieldsWithTag("type:gadget_mntns_id"), 1)
+			require.Len(t, ds.GetFieldsWithTag("type:gadget_timestamp"), 1)
+		}
+	}
+}
+
+func TestExecSourceHandle(t *testing.T) {
+	oldProcFs := host.HostProcFs
+	t.Cleanup(func() { host.HostProcFs = oldProcFs })
+	host.HostProcFs = t.TempDir()
+	dir := filepath.Join(host.HostProcFs, "42")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "comm"), []byte("ls\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cmdline"), []byte("ls\x00-l\x00"), 0o644))
+
+	gadgetCtx := gadgetcontext.New(context.Background(), ImagePrefix+"trace_exec")
+	inst, err := (&fallbackOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+	require.NoError(t, err)
+	o := inst.(*fallbackOperatorInstance)
+	src := o.src.(*execSource)
+	src.bootOffset = 500
+
+	ds := gadgetCtx.GetDataSources()["exec"]
+	var events []string
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		require.Equal(t, uint64(2500), src.timestamp.Uint64(data))
+		require.Equal(t, uint32(42), src.pid.Uint32(data))
+		events = append(events, src.comm.String(data)+" "+src.args.String(data))
+		return nil
+	}, 0)
+
+	require.NoError(t, src.handle(o.emitter, procConnectorMessage(procEventExec, 2000, 42, 42)))
+	require.Equal(t, []string{"ls ls -l"}, events)
+}
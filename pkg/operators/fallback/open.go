@@ -0,0 +1,282 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fallback
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+)
+
+// fanotifyMetadataSize is the size of struct fanotify_event_metadata
+const fanotifyMetadataSize = 24
+
+// pseudoFilesystems aren't watched: files opened there aren't interesting or can't be watched by fanotify
+var pseudoFilesystems = map[string]bool{
+	"autofs": true, "binfmt_misc": true, "bpf": true, "cgroup": true, "cgroup2": true, "configfs": true,
+	"debugfs": true, "devpts": true, "fusectl": true, "hugetlbfs": true, "mqueue": true, "nsfs": true,
+	"proc": true, "pstore": true, "securityfs": true, "sysfs": true, "tracefs": true,
+}
+
+// mount is an entry of /proc/<pid>/mountinfo
+type mount struct {
+	device     string
+	mountPoint string
+	fsType     string
+}
+
+// openEvent is an event of fanotify
+type openEvent struct {
+	mask uint64
+	fd   int32
+	pid  int32
+}
+
+// openSource emits the files opened on the filesystems of the host, including the ones of containers, as reported
+// by fanotify. Only successful opens are reported, and neither the flags nor the file descriptor of the process
+// are known.
+type openSource struct {
+	timestamp datasource.FieldAccessor
+	pid       datasource.FieldAccessor
+	comm      datasource.FieldAccessor
+	uid       datasource.FieldAccessor
+	gid       datasource.FieldAccessor
+	flags     datasource.FieldAccessor
+	mode      datasource.FieldAccessor
+	err       datasource.FieldAccessor
+	fd        datasource.FieldAccessor
+	fname     datasource.FieldAccessor
+	mntns     datasource.FieldAccessor
+
+	fanotifyFd int
+	mu         sync.Mutex
+	// watched are the devices of the filesystems watched
+	watched map[string]bool
+}
+
+func newOpenSource() source {
+	return &openSource{watched: make(map[string]bool)}
+}
+
+func (s *openSource) dataSourceName() string {
+	return "open"
+}
+
+func (s *openSource) addFields(ds datasource.DataSource) error {
+	var err error
+	if s.timestamp, err = addTimestampField(ds); err != nil {
+		return err
+	}
+	hidden := datasource.WithFlags(datasource.FieldFlagHidden)
+	for _, f := range []struct {
+		acc         *datasource.FieldAccessor
+		name        string
+		kind        api.Kind
+		annotations map[string]string
+		opts        []datasource.FieldOption
+	}{
+		{&s.pid, "pid", api.Kind_Uint32, templateAnnotations("pid"), nil},
+		{&s.comm, "comm", api.Kind_String, templateAnnotations("comm"), nil},
+		{&s.uid, "uid", api.Kind_Uint32, templateAnnotations("uid"), nil},
+		{&s.gid, "gid", api.Kind_Uint32, templateAnnotations("uid"), nil},
+		{&s.flags, "flags", api.Kind_Int32, map[string]string{"columns.width": "5"}, []datasource.FieldOption{hidden}},
+		{&s.mode, "mode", api.Kind_Uint16, map[string]string{"description": "File access mode"}, []datasource.FieldOption{hidden}},
+		{&s.err, "err", api.Kind_Int32, map[string]string{
+			"description":       "Error code",
+			"columns.width":     "3",
+			"columns.alignment": "right",
+		}, nil},
+		{&s.fd, "fd", api.Kind_Uint32, map[string]string{
+			"description":      "File descriptor. 0 in case of error",
+			"columns.minWidth": "2",
+			"columns.maxWidth": "3",
+		}, nil},
+		{&s.fname, "fname", api.Kind_String, map[string]string{"columns.width": "32", "columns.minWidth": "24"}, nil},
+	} {
+		acc, err := addField(ds, f.name, f.kind, f.annotations, f.opts...)
+		if err != nil {
+			return err
+		}
+		*f.acc = acc
+	}
+	s.mntns, err = addMntNsField(ds)
+	return err
+}
+
+// open creates a fanotify group watching the filesystems of the host, which requires CAP_SYS_ADMIN and Linux 4.20
+func (s *openSource) open(logger logger.Logger) (*os.File, error) {
+	fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK,
+		unix.O_RDONLY|unix.O_LARGEFILE|unix.O_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("initializing fanotify: %w", err)
+	}
+	s.fanotifyFd = fd
+	if n := s.watchFilesystems(logger); n == 0 {
+		unix.Close(fd)
+		return nil, errors.New("no filesystem could be watched with fanotify")
+	}
+	return os.NewFile(uintptr(fd), "fanotify"), nil
+}
+
+// refresh watches the filesystems mounted since the gadget was started, like the ones of new containers
+func (s *openSource) refresh(logger logger.Logger) {
+	s.watchFilesystems(logger)
+}
+
+// watchFilesystems adds marks for the filesystems mounted on the host that aren't watched yet and returns the
+// number of filesystems watched
+func (s *openSource) watchFilesystems(logger logger.Logger) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	content, err := os.ReadFile(filepath.Join(host.HostProcFs, "1", "mountinfo"))
+	if err != nil {
+		logger.Warnf("reading mounts of the host: %v", err)
+		return len(s.watched)
+	}
+	for _, m := range parseMountInfo(string(content)) {
+		if s.watched[m.device] || pseudoFilesystems[m.fsType] {
+			continue
+		}
+		path := filepath.Join(host.HostRoot, m.mountPoint)
+		err := unix.FanotifyMark(s.fanotifyFd, unix.FAN_MARK_ADD|unix.FAN_MARK_FILESYSTEM, unix.FAN_OPEN, unix.AT_FDCWD, path)
+		if err != nil {
+			logger.Debugf("watching filesystem %s at %s: %v", m.device, m.mountPoint, err)
+			continue
+		}
+		s.watched[m.device] = true
+	}
+	return len(s.watched)
+}
+
+// parseMountInfo returns the mounts of the content of /proc/<pid>/mountinfo, see proc(5)
+func parseMountInfo(content string) []mount {
+	var mounts []mount
+	for _, line := range strings.Split(content, "\n") {
+		pre, post, ok := strings.Cut(line, " - ")
+		if !ok {
+			continue
+		}
+		preFields := strings.Fields(pre)
+		postFields := strings.Fields(post)
+		if len(preFields) < 5 || len(postFields) < 1 {
+			continue
+		}
+		mounts = append(mounts, mount{
+			device:     preFields[2],
+			mountPoint: unescapeMountPoint(preFields[4]),
+			fsType:     postFields[0],
+		})
+	}
+	return mounts
+}
+
+// unescapeMountPoint replaces the octal escapes of spaces and other special characters in mount points
+func unescapeMountPoint(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseOpenEvents returns the events of fanotify in buf
+func parseOpenEvents(buf []byte) ([]openEvent, error) {
+	var events []openEvent
+	bo := binary.NativeEndian
+	for len(buf) >= fanotifyMetadataSize {
+		// struct fanotify_event_metadata
+		eventLen := bo.Uint32(buf[0:])
+		if eventLen < fanotifyMetadataSize || int(eventLen) > len(buf) {
+			return events, fmt.Errorf("invalid fanotify event length %d", eventLen)
+		}
+		if version := buf[4]; version != unix.FANOTIFY_METADATA_VERSION {
+			return events, fmt.Errorf("unsupported fanotify metadata version %d", version)
+		}
+		events = append(events, openEvent{
+			mask: bo.Uint64(buf[8:]),
+			fd:   int32(bo.Uint32(buf[16:])),
+			pid:  int32(bo.Uint32(buf[20:])),
+		})
+		buf = buf[eventLen:]
+	}
+	return events, nil
+}
+
+func (s *openSource) handle(e *emitter, buf []byte) error {
+	events, err := parseOpenEvents(buf)
+	self := int32(os.Getpid())
+	for _, ev := range events {
+		if ev.mask&unix.FAN_Q_OVERFLOW != 0 {
+			e.logger.Warnf("events were lost")
+		}
+		if ev.fd < 0 {
+			continue
+		}
+		fname, linkErr := os.Readlink(filepath.Join("/proc/self/fd", strconv.Itoa(int(ev.fd))))
+		unix.Close(int(ev.fd))
+		if linkErr != nil || ev.pid == self {
+			continue
+		}
+		// The path is the one in the mount namespace of ig, which might see the filesystem of the host at HostRoot
+		if host.HostRoot != "/" {
+			fname = strings.TrimPrefix(fname, host.HostRoot)
+		}
+
+		p := readProcess(uint32(ev.pid))
+		if !e.selected(p.mntns) {
+			continue
+		}
+		if err := e.emit([]fieldValue{
+			// fanotify doesn't provide the time of the event
+			{s.timestamp, e.u64(bootTime())},
+			{s.pid, e.u32(p.pid)},
+			{s.comm, []byte(p.comm)},
+			{s.uid, e.u32(p.uid)},
+			{s.gid, e.u32(p.gid)},
+			{s.flags, e.u32(0)},
+			{s.mode, e.u16(0)},
+			{s.err, e.u32(0)},
+			{s.fd, e.u32(0)},
+			{s.fname, []byte(fname)},
+			{s.mntns, e.u64(p.mntns)},
+		}); err != nil {
+			e.logger.Warnf("emitting open event: %v", err)
+		}
+	}
+	return err
+}
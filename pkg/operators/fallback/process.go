@@ -0,0 +1,153 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fallback
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	containerutils "github.com/inspektor-gadget/inspektor-gadget/pkg/container-utils"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource/compat"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/formatters"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+)
+
+// process holds what's known about the process that caused an event
+type process struct {
+	pid       uint32
+	ppid      uint32
+	uid       uint32
+	gid       uint32
+	loginuid  uint32
+	sessionid uint32
+	comm      string
+	mntns     uint64
+}
+
+// readProcess reads the details of the process pid from the procfs of the host. The process might be gone already
+// when the event is handled, so the details that can't be read are left empty.
+func readProcess(pid uint32) *process {
+	p := &process{pid: pid}
+	dir := filepath.Join(host.HostProcFs, strconv.FormatUint(uint64(pid), 10))
+	if status, err := os.ReadFile(filepath.Join(dir, "status")); err == nil {
+		parseStatus(p, string(status))
+	}
+	if comm, err := os.ReadFile(filepath.Join(dir, "comm")); err == nil {
+		p.comm = strings.TrimSuffix(string(comm), "\n")
+	}
+	p.loginuid = readProcUint32(filepath.Join(dir, "loginuid"))
+	p.sessionid = readProcUint32(filepath.Join(dir, "sessionid"))
+	p.mntns, _ = containerutils.GetMntNs(int(pid))
+	return p
+}
+
+// parseStatus sets the parent and the real user and group ids of p from the content of /proc/<pid>/status
+func parseStatus(p *process, status string) {
+	for _, line := range strings.Split(status, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "PPid":
+			p.ppid = uint32(v)
+		case "Uid":
+			p.uid = uint32(v)
+		case "Gid":
+			p.gid = uint32(v)
+		}
+	}
+}
+
+// readProcUint32 reads files like /proc/<pid>/loginuid, which hold (u32)-1 if they aren't set
+func readProcUint32(path string) uint32 {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 32)
+	if err != nil {
+		return 0
+	}
+	return uint32(v)
+}
+
+// readCmdline returns the arguments of the process pid and their size including the terminating zeros, like
+// trace_exec counts them
+func readCmdline(pid uint32) ([]string, uint32) {
+	content, err := os.ReadFile(filepath.Join(host.HostProcFs, strconv.FormatUint(uint64(pid), 10), "cmdline"))
+	if err != nil || len(content) == 0 {
+		return nil, 0
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\x00"), "\x00"), uint32(len(content))
+}
+
+// bootTime returns the current time of the clock eBPF programs use for the timestamps of events
+func bootTime() uint64 {
+	var ts unix.Timespec
+	if unix.ClockGettime(unix.CLOCK_BOOTTIME, &ts) != nil {
+		return 0
+	}
+	return uint64(ts.Nano())
+}
+
+// bootOffset returns the time the system was suspended: the proc connector uses a clock that doesn't advance while
+// the system is suspended, while eBPF programs use one that does
+func bootOffset() uint64 {
+	var boot, mono unix.Timespec
+	if unix.ClockGettime(unix.CLOCK_BOOTTIME, &boot) != nil || unix.ClockGettime(unix.CLOCK_MONOTONIC, &mono) != nil {
+		return 0
+	}
+	return uint64(max(boot.Nano()-mono.Nano(), 0))
+}
+
+// addField adds a field with the column attributes of the field of the same name of the image-based gadget
+func addField(ds datasource.DataSource, name string, kind api.Kind, annotations map[string]string, opts ...datasource.FieldOption) (datasource.FieldAccessor, error) {
+	opts = append(opts, datasource.WithKind(kind))
+	if annotations != nil {
+		opts = append(opts, datasource.WithAnnotations(annotations))
+	}
+	return ds.AddField(name, opts...)
+}
+
+func addTimestampField(ds datasource.DataSource) (datasource.FieldAccessor, error) {
+	return addField(ds, "timestamp", api.Kind_Uint64, map[string]string{"columns.template": "timestamp"},
+		datasource.WithTags("type:"+formatters.TimestampTypeName))
+}
+
+func addMntNsField(ds datasource.DataSource) (datasource.FieldAccessor, error) {
+	return addField(ds, "mntns_id", api.Kind_Uint64, map[string]string{
+		"description":      "Mount namespace inode id",
+		"columns.template": "ns",
+	}, datasource.WithTags(compat.MntNsIdType))
+}
+
+func templateAnnotations(template string) map[string]string {
+	return map[string]string{"columns.template": template}
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesink
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+// csvEncoder encodes data of a single data source as CSV records with one column per field
+type csvEncoder struct {
+	fields []datasource.FieldAccessor
+	header []byte
+}
+
+func newCSVEncoder(ds datasource.DataSource) *csvEncoder {
+	e := &csvEncoder{}
+	var names []string
+	for _, field := range ds.Fields() {
+		if datasource.FieldFlagEmpty.In(field.Flags) || datasource.FieldFlagUnreferenced.In(field.Flags) {
+			continue
+		}
+		f := ds.GetField(field.FullName)
+		if f == nil {
+			continue
+		}
+		e.fields = append(e.fields, f)
+		names = append(names, field.FullName)
+	}
+	e.header = encodeRecord(names)
+	return e
+}
+
+func encodeRecord(record []string) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	// Writing to a bytes.Buffer doesn't fail
+	w.Write(record)
+	w.Flush()
+	return buf.Bytes()
+}
+
+// fieldString returns the value of f as text; strings aren't copied, so the result must not be used after data
+func fieldString(f datasource.FieldAccessor, data datasource.Data) string {
+	switch f.Type() {
+	case api.Kind_Bool:
+		return strconv.FormatBool(f.Bool(data))
+	case api.Kind_Int8:
+		return strconv.FormatInt(int64(f.Int8(data)), 10)
+	case api.Kind_Int16:
+		return strconv.FormatInt(int64(f.Int16(data)), 10)
+	case api.Kind_Int32:
+		return strconv.FormatInt(int64(f.Int32(data)), 10)
+	case api.Kind_Int64:
+		return strconv.FormatInt(f.Int64(data), 10)
+	case api.Kind_Uint8:
+		return strconv.FormatUint(uint64(f.Uint8(data)), 10)
+	case api.Kind_Uint16:
+		return strconv.FormatUint(uint64(f.Uint16(data)), 10)
+	case api.Kind_Uint32:
+		return strconv.FormatUint(uint64(f.Uint32(data)), 10)
+	case api.Kind_Uint64:
+		return strconv.FormatUint(f.Uint64(data), 10)
+	case api.Kind_Float32:
+		return strconv.FormatFloat(float64(f.Float32(data)), 'g', -1, 32)
+	case api.Kind_Float64:
+		return strconv.FormatFloat(f.Float64(data), 'g', -1, 64)
+	case api.Kind_CString:
+		return f.CStringUnsafe(data)
+	}
+	return f.StringUnsafe(data)
+}
+
+// encode returns data as a CSV record; it must not keep references to data
+func (e *csvEncoder) encode(data datasource.Data) []byte {
+	record := make([]string, 0, len(e.fields))
+	for _, f := range e.fields {
+		record = append(record, fieldString(f, data))
+	}
+	return encodeRecord(record)
+}
@@ -0,0 +1,324 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package filesink provides an operator that writes the data emitted by gadgets to files as JSON lines or CSV,
+// rotating them by size or time and optionally encrypting rotated files to age recipients.
+package filesink
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/hashicorp/go-multierror"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource/formatters/json"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/filter"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	OperatorName = "filesink"
+
+	ParamPath           = "filesink-path"
+	ParamFormat         = "filesink-format"
+	ParamMaxSize        = "filesink-max-size"
+	ParamRotateInterval = "filesink-rotate-interval"
+	ParamMaxFiles       = "filesink-max-files"
+	ParamMaxAge         = "filesink-max-age"
+	ParamMaxTotalSize   = "filesink-max-total-size"
+	ParamCompress       = "filesink-compress"
+	ParamRecipients     = "filesink-encrypt-recipients"
+
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+
+	// Priority is chosen so that only data that passed the filter operator is written
+	Priority = filter.Priority + 100
+
+	flushInterval = time.Second
+)
+
+type fileSinkOperator struct{}
+
+func (o *fileSinkOperator) Name() string {
+	return OperatorName
+}
+
+func (o *fileSinkOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *fileSinkOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *fileSinkOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:         ParamPath,
+			Title:       "File path",
+			Description: "File to write events to; events are only written if set. With multiple data sources, the name of the data source is added to the file name",
+			TypeHint:    api.TypeString,
+		},
+		{
+			Key:            ParamFormat,
+			Title:          "File format",
+			Description:    "Write events as JSON lines or as CSV with a header line",
+			DefaultValue:   FormatJSON,
+			PossibleValues: []string{FormatJSON, FormatCSV},
+			TypeHint:       api.TypeString,
+		},
+		{
+			Key:          ParamMaxSize,
+			Title:        "Maximum file size",
+			Description:  "Rotate the file once it would exceed the given size, e.g. 100Mi; 0 disables size based rotation",
+			DefaultValue: "0",
+			TypeHint:     api.TypeString,
+		},
+		{
+			Key:          ParamRotateInterval,
+			Title:        "Rotation interval",
+			Description:  "Rotate the file after the given duration, e.g. 1h; 0 disables time based rotation",
+			DefaultValue: "0",
+			TypeHint:     api.TypeDuration,
+		},
+		{
+			Key:          ParamMaxFiles,
+			Title:        "Maximum rotated files",
+			Description:  "Number of rotated files to keep; 0 keeps all of them",
+			DefaultValue: "0",
+			TypeHint:     api.TypeUint32,
+		},
+		{
+			Key:          ParamMaxAge,
+			Title:        "Maximum age of rotated files",
+			Description:  "Delete rotated files once they were rotated longer ago than the given duration, e.g. 24h; 0 keeps them regardless of their age",
+			DefaultValue: "0",
+			TypeHint:     api.TypeDuration,
+		},
+		{
+			Key:          ParamMaxTotalSize,
+			Title:        "Maximum total size",
+			Description:  "Delete the oldest rotated files while the current and rotated files take more than the given size, e.g. 1Gi; 0 disables the limit",
+			DefaultValue: "0",
+			TypeHint:     api.TypeString,
+		},
+		{
+			Key:          ParamCompress,
+			Title:        "Compress rotated files",
+			Description:  "Compress rotated files using gzip",
+			DefaultValue: "false",
+			TypeHint:     api.TypeBool,
+		},
+		{
+			Key:         ParamRecipients,
+			Title:       "Encryption recipients",
+			Description: "Encrypt rotated files to the given age recipients separated by commas, e.g. age1...; the current file is rotated when the gadget stops, so that no events are left unencrypted",
+			TypeHint:    api.TypeString,
+		},
+	}
+}
+
+// dataSourcePath returns the path of the file for ds; the name of the data source is added before the extension if
+// the gadget has multiple data sources
+func dataSourcePath(path string, ds datasource.DataSource, multiple bool) string {
+	if !multiple {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + ds.Name() + ext
+}
+
+func (o *fileSinkOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &fileSinkOperatorInstance{
+		sinks: make(map[datasource.DataSource]*sink),
+	}
+	path := params.Get(ParamPath).AsString()
+	if path == "" {
+		return inst, nil
+	}
+
+	maxSize, err := resource.ParseQuantity(params.Get(ParamMaxSize).AsString())
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ParamMaxSize, err)
+	}
+	interval := params.Get(ParamRotateInterval).AsDuration()
+	if maxSize.Sign() < 0 || interval < 0 {
+		return nil, fmt.Errorf("%s and %s must not be negative", ParamMaxSize, ParamRotateInterval)
+	}
+	maxTotalSize, err := resource.ParseQuantity(params.Get(ParamMaxTotalSize).AsString())
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ParamMaxTotalSize, err)
+	}
+	maxAge := params.Get(ParamMaxAge).AsDuration()
+	if maxTotalSize.Sign() < 0 || maxAge < 0 {
+		return nil, fmt.Errorf("%s and %s must not be negative", ParamMaxTotalSize, ParamMaxAge)
+	}
+	var recipients []age.Recipient
+	for _, text := range strings.Split(params.Get(ParamRecipients).AsString(), ",") {
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		recipient, err := age.ParseX25519Recipient(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", ParamRecipients, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	format := params.Get(ParamFormat).AsString()
+
+	dataSources := gadgetCtx.GetDataSources()
+	for name, ds := range dataSources {
+		s := &sink{
+			writer: &rotatingWriter{
+				path:         dataSourcePath(path, ds, len(dataSources) > 1),
+				maxSize:      maxSize.Value(),
+				interval:     interval,
+				maxFiles:     int(params.Get(ParamMaxFiles).AsUint32()),
+				maxAge:       maxAge,
+				maxTotalSize: maxTotalSize.Value(),
+				compress:     params.Get(ParamCompress).AsBool(),
+				recipients:   recipients,
+				logger:       gadgetCtx.Logger(),
+				now:          time.Now,
+			},
+		}
+		switch format {
+		case FormatJSON:
+			formatter, err := json.New(ds, json.WithShowAll(true))
+			if err != nil {
+				return nil, fmt.Errorf("initializing JSON formatter for data source %q: %w", name, err)
+			}
+			s.encode = func(data datasource.Data) []byte {
+				return append(formatter.Marshal(data), '\n')
+			}
+		case FormatCSV:
+			encoder := newCSVEncoder(ds)
+			s.writer.header = encoder.header
+			s.encode = encoder.encode
+		default:
+			return nil, fmt.Errorf("invalid format %q", format)
+		}
+		inst.sinks[ds] = s
+	}
+	return inst, nil
+}
+
+func (o *fileSinkOperator) Priority() int {
+	return Priority
+}
+
+// sink writes data of a single data source
+type sink struct {
+	writer *rotatingWriter
+	encode func(datasource.Data) []byte
+}
+
+type fileSinkOperatorInstance struct {
+	sinks map[datasource.DataSource]*sink
+	done  chan struct{}
+}
+
+func (o *fileSinkOperatorInstance) Name() string {
+	return OperatorName
+}
+
+func (o *fileSinkOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error {
+	for ds, s := range o.sinks {
+		if err := s.writer.open(); err != nil {
+			o.closeWriters()
+			return fmt.Errorf("opening file for data source %q: %w", ds.Name(), err)
+		}
+		gadgetCtx.Logger().Debugf("writing data source %q to %q", ds.Name(), s.writer.path)
+		// Rotated files of previous runs might have expired
+		s.writer.enforceRetention()
+
+		s := s
+		ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+			if err := s.writer.Write(s.encode(data)); err != nil {
+				gadgetCtx.Logger().Warnf("writing to %q: %v", s.writer.path, err)
+			}
+			return nil
+		}, Priority)
+	}
+	return nil
+}
+
+func (o *fileSinkOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	if len(o.sinks) == 0 {
+		return nil
+	}
+
+	// Flush periodically, so the files can be followed while the gadget is running, and enforce the retention
+	// policies, so that rotated files expire even if nothing is written anymore
+	o.done = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		janitor := time.NewTicker(janitorInterval)
+		defer janitor.Stop()
+		for {
+			select {
+			case <-o.done:
+				return
+			case <-ticker.C:
+				for _, s := range o.sinks {
+					if err := s.writer.Flush(); err != nil {
+						gadgetCtx.Logger().Warnf("flushing %q: %v", s.writer.path, err)
+					}
+				}
+			case <-janitor.C:
+				for _, s := range o.sinks {
+					s.writer.enforceRetention()
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (o *fileSinkOperatorInstance) closeWriters() error {
+	var result error
+	for _, s := range o.sinks {
+		if err := s.writer.Close(); err != nil {
+			result = multierror.Append(result, fmt.Errorf("closing %q: %w", s.writer.path, err))
+		}
+	}
+	return result
+}
+
+func (o *fileSinkOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	if o.done != nil {
+		close(o.done)
+	}
+	return o.closeWriters()
+}
+
+func init() {
+	operators.RegisterDataOperator(&fileSinkOperator{})
+}
@@ -0,0 +1,227 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesink
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+)
+
+func newTestWriter(t *testing.T, now *time.Time) *rotatingWriter {
+	w := &rotatingWriter{
+		path:   filepath.Join(t.TempDir(), "events.jsonl"),
+		logger: logger.DefaultLogger(),
+		now:    func() time.Time { return *now },
+	}
+	return w
+}
+
+func rotatedFiles(t *testing.T, w *rotatingWriter) []string {
+	files, err := filepath.Glob(w.path + ".*")
+	require.NoError(t, err)
+	return files
+}
+
+func TestRotateBySize(t *testing.T) {
+	now := time.Now()
+	w := newTestWriter(t, &now)
+	w.maxSize = 10
+	w.maxFiles = 2
+	require.NoError(t, w.open())
+
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n", "ffff\n", "gggg\n"} {
+		now = now.Add(time.Second)
+		require.NoError(t, w.Write([]byte(line)))
+	}
+	require.NoError(t, w.Close())
+
+	content, err := os.ReadFile(w.path)
+	require.NoError(t, err)
+	require.Equal(t, "gggg\n", string(content))
+
+	// The oldest file with aaaa and bbbb was removed
+	files := rotatedFiles(t, w)
+	require.Len(t, files, 2)
+	content, err = os.ReadFile(files[0])
+	require.NoError(t, err)
+	require.Equal(t, "cccc\ndddd\n", string(content))
+	content, err = os.ReadFile(files[1])
+	require.NoError(t, err)
+	require.Equal(t, "eeee\nffff\n", string(content))
+}
+
+func TestRotateByTimeCompressed(t *testing.T) {
+	now := time.Now()
+	w := newTestWriter(t, &now)
+	w.interval = time.Minute
+	w.compress = true
+	w.header = []byte("header\n")
+	require.NoError(t, w.open())
+
+	require.NoError(t, w.Write([]byte("first\n")))
+	now = now.Add(30 * time.Second)
+	require.NoError(t, w.Write([]byte("second\n")))
+	now = now.Add(30 * time.Second)
+	require.NoError(t, w.Write([]byte("third\n")))
+	require.NoError(t, w.Close())
+
+	content, err := os.ReadFile(w.path)
+	require.NoError(t, err)
+	require.Equal(t, "header\nthird\n", string(content))
+
+	files := rotatedFiles(t, w)
+	require.Len(t, files, 1)
+	require.Equal(t, ".gz", filepath.Ext(files[0]))
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	content, err = io.ReadAll(gz)
+	require.NoError(t, err)
+	require.Equal(t, "header\nfirst\nsecond\n", string(content))
+}
+
+func TestRotateEncrypted(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	now := time.Now()
+	w := newTestWriter(t, &now)
+	w.maxSize = 10
+	w.compress = true
+	w.recipients = []age.Recipient{identity.Recipient()}
+	require.NoError(t, w.open())
+
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n"} {
+		now = now.Add(time.Second)
+		require.NoError(t, w.Write([]byte(line)))
+	}
+	now = now.Add(time.Second)
+	require.NoError(t, w.Close())
+
+	// The current file is rotated when closing, so that all events are encrypted
+	_, err = os.Stat(w.path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	files := rotatedFiles(t, w)
+	require.Len(t, files, 2)
+	var content []string
+	for _, file := range files {
+		require.True(t, strings.HasSuffix(file, ".gz.age"), file)
+		f, err := os.Open(file)
+		require.NoError(t, err)
+		defer f.Close()
+		dec, err := age.Decrypt(f, identity)
+		require.NoError(t, err)
+		gz, err := gzip.NewReader(dec)
+		require.NoError(t, err)
+		b, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		content = append(content, string(b))
+	}
+	require.Equal(t, []string{"aaaa\nbbbb\n", "cccc\n"}, content)
+}
+
+func TestFileSink(t *testing.T) {
+	for format, expected := range map[string]string{
+		FormatJSON: "{\"comm\":\"cat\",\"pid\":1234}\n{\"comm\":\"a,b\",\"pid\":5}\n",
+		FormatCSV:  "comm,pid\ncat,1234\n\"a,b\",5\n",
+	} {
+		t.Run(format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "events")
+
+			gadgetCtx := gadgetcontext.New(context.Background(), "test")
+			ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+			require.NoError(t, err)
+			comm, err := ds.AddField("comm", datasource.WithKind(api.Kind_String))
+			require.NoError(t, err)
+			pid, err := ds.AddField("pid", datasource.WithKind(api.Kind_Uint32))
+			require.NoError(t, err)
+
+			inst, err := (&fileSinkOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{
+				ParamPath:   path,
+				ParamFormat: format,
+			})
+			require.NoError(t, err)
+			require.NoError(t, inst.(operators.PreStart).PreStart(gadgetCtx))
+			require.NoError(t, inst.Start(gadgetCtx))
+
+			for _, ev := range []struct {
+				comm string
+				pid  uint32
+			}{{"cat", 1234}, {"a,b", 5}} {
+				data := ds.NewData()
+				require.NoError(t, comm.Set(data, []byte(ev.comm)))
+				require.NoError(t, pid.Set(data, make([]byte, 4)))
+				pid.PutUint32(data, ev.pid)
+				require.NoError(t, ds.EmitAndRelease(data))
+			}
+			require.NoError(t, inst.Stop(gadgetCtx))
+
+			content, err := os.ReadFile(path)
+			require.NoError(t, err)
+			if format == FormatJSON {
+				// Compare the decoded lines, the formatter might escape characters differently
+				expectedLines := strings.SplitAfter(expected, "\n")
+				lines := strings.SplitAfter(string(content), "\n")
+				require.Len(t, lines, len(expectedLines))
+				for i := range lines[:len(lines)-1] {
+					require.JSONEq(t, expectedLines[i], lines[i])
+				}
+				return
+			}
+			require.Equal(t, expected, string(content))
+		})
+	}
+}
+
+func TestFileSinkDisabled(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	_, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+
+	inst, err := (&fileSinkOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+	require.NoError(t, err)
+	require.NoError(t, inst.(operators.PreStart).PreStart(gadgetCtx))
+	require.NoError(t, inst.Start(gadgetCtx))
+	require.NoError(t, inst.Stop(gadgetCtx))
+
+	_, err = (&fileSinkOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{
+		ParamPath:    filepath.Join(t.TempDir(), "events"),
+		ParamMaxSize: "lots",
+	})
+	require.Error(t, err)
+
+	_, err = (&fileSinkOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{
+		ParamPath:       filepath.Join(t.TempDir(), "events"),
+		ParamRecipients: "age1notarecipient",
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,150 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesink
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons for deleting rotated files, used as label of the retention metrics
+const (
+	reasonMaxFiles     = "max-files"
+	reasonMaxAge       = "max-age"
+	reasonMaxTotalSize = "max-total-size"
+)
+
+// janitorInterval is how often the retention policies are enforced besides after each rotation, so that files
+// expire even if nothing is written
+const janitorInterval = time.Minute
+
+var (
+	deletedFiles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ig_filesink_deleted_files_total",
+		Help: "Number of rotated files deleted by the filesink operator to enforce its retention policies",
+	}, []string{"reason"})
+	deletedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ig_filesink_deleted_bytes_total",
+		Help: "Size of the rotated files deleted by the filesink operator to enforce its retention policies",
+	}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(deletedFiles, deletedBytes)
+}
+
+// rotatedFile is a rotated file, that might exist as is, compressed or encrypted and being processed at the same time
+type rotatedFile struct {
+	path    string
+	rotated time.Time
+	size    int64
+}
+
+// listRotated returns the rotated files of w, oldest first
+func (w *rotatingWriter) listRotated() ([]*rotatedFile, error) {
+	files, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	byPath := make(map[string]*rotatedFile)
+	for _, f := range files {
+		path := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(f, ".tmp"), ".age"), ".gz")
+		rotated, err := time.ParseInLocation(rotatedTimeFormat, strings.TrimPrefix(path, w.path+"."), time.Local)
+		if err != nil {
+			// Not written by us
+			continue
+		}
+		info, err := os.Stat(f)
+		if err != nil {
+			// Replaced by its compressed or encrypted version in the meantime
+			continue
+		}
+		rf, ok := byPath[path]
+		if !ok {
+			rf = &rotatedFile{path: path, rotated: rotated}
+			byPath[path] = rf
+		}
+		rf.size += info.Size()
+	}
+
+	res := make([]*rotatedFile, 0, len(byPath))
+	for _, rf := range byPath {
+		res = append(res, rf)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].rotated.Before(res[j].rotated)
+	})
+	return res, nil
+}
+
+// enforceRetention deletes the oldest rotated files while there are more than maxFiles of them, they were rotated
+// more than maxAge ago or they take more than maxTotalSize together with the current file. The current file is
+// never deleted.
+func (w *rotatingWriter) enforceRetention() {
+	if w.maxFiles <= 0 && w.maxAge <= 0 && w.maxTotalSize <= 0 {
+		return
+	}
+
+	// The janitor and compressions and encryptions of rotated files run concurrently
+	w.retentionMu.Lock()
+	defer w.retentionMu.Unlock()
+
+	files, err := w.listRotated()
+	if err != nil {
+		w.logger.Warnf("listing rotated files: %v", err)
+		return
+	}
+
+	var total int64
+	if info, err := os.Stat(w.path); err == nil {
+		total = info.Size()
+	}
+	for _, f := range files {
+		total += f.size
+	}
+
+	now := w.now()
+	for ; len(files) > 0; files = files[1:] {
+		f := files[0]
+		var reason string
+		switch {
+		case w.maxFiles > 0 && len(files) > w.maxFiles:
+			reason = reasonMaxFiles
+		case w.maxAge > 0 && now.Sub(f.rotated) > w.maxAge:
+			reason = reasonMaxAge
+		case w.maxTotalSize > 0 && total > w.maxTotalSize:
+			reason = reasonMaxTotalSize
+		default:
+			return
+		}
+
+		w.logger.Debugf("removing rotated file %q (%s)", f.path, reason)
+		for _, path := range []string{f.path, f.path + ".gz", f.path + ".age", f.path + ".gz.age"} {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				w.logger.Warnf("removing rotated file: %v", err)
+			}
+		}
+		total -= f.size
+		deletedFiles.WithLabelValues(reason).Inc()
+		deletedBytes.WithLabelValues(reason).Add(float64(f.size))
+	}
+}
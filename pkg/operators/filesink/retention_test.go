@@ -0,0 +1,102 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesink
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// writeRotated creates a rotated file of w that was rotated at the given time
+func writeRotated(t *testing.T, w *rotatingWriter, rotated time.Time, ext string, size int) string {
+	path := w.path + "." + rotated.Format(rotatedTimeFormat) + ext
+	require.NoError(t, os.WriteFile(path, []byte(strings.Repeat("x", size)), 0o640))
+	return path
+}
+
+func remainingFiles(t *testing.T, w *rotatingWriter) []string {
+	var names []string
+	for _, f := range rotatedFiles(t, w) {
+		names = append(names, filepath.Base(f))
+	}
+	return names
+}
+
+func TestRetentionMaxAge(t *testing.T) {
+	now := time.Now()
+	w := newTestWriter(t, &now)
+	w.maxAge = time.Hour
+
+	writeRotated(t, w, now.Add(-3*time.Hour), "", 10)
+	writeRotated(t, w, now.Add(-2*time.Hour), ".gz", 10)
+	recent := writeRotated(t, w, now.Add(-time.Minute), "", 10)
+	// Files of other writers are left alone
+	other := w.path + ".backup"
+	require.NoError(t, os.WriteFile(other, nil, 0o640))
+
+	before := testutil.ToFloat64(deletedFiles.WithLabelValues(reasonMaxAge))
+	w.enforceRetention()
+	require.ElementsMatch(t, []string{filepath.Base(recent), filepath.Base(other)}, remainingFiles(t, w))
+	require.Equal(t, before+2, testutil.ToFloat64(deletedFiles.WithLabelValues(reasonMaxAge)))
+
+	// Files expire as time passes
+	now = now.Add(time.Hour)
+	w.enforceRetention()
+	require.Equal(t, []string{filepath.Base(other)}, remainingFiles(t, w))
+}
+
+func TestRetentionMaxTotalSize(t *testing.T) {
+	now := time.Now()
+	w := newTestWriter(t, &now)
+	w.maxTotalSize = 100
+	require.NoError(t, w.open())
+	require.NoError(t, w.Write([]byte(strings.Repeat("x", 29)+"\n")))
+	require.NoError(t, w.Flush())
+
+	writeRotated(t, w, now.Add(-3*time.Minute), "", 30)
+	// A file being compressed counts with both of its versions
+	second := writeRotated(t, w, now.Add(-2*time.Minute), "", 20)
+	writeRotated(t, w, now.Add(-2*time.Minute), ".gz.tmp", 10)
+	third := writeRotated(t, w, now.Add(-time.Minute), ".gz", 20)
+
+	before := testutil.ToFloat64(deletedBytes.WithLabelValues(reasonMaxTotalSize))
+	w.enforceRetention()
+	require.Equal(t, []string{filepath.Base(second), filepath.Base(second) + ".gz.tmp", filepath.Base(third)},
+		remainingFiles(t, w))
+	require.Equal(t, before+30, testutil.ToFloat64(deletedBytes.WithLabelValues(reasonMaxTotalSize)))
+
+	// The current file is never deleted, even if it exceeds the limit alone. Files being compressed are left to the
+	// compression and deleted once they are complete.
+	w.maxTotalSize = 1
+	w.enforceRetention()
+	require.Equal(t, []string{filepath.Base(second) + ".gz.tmp"}, remainingFiles(t, w))
+	require.FileExists(t, w.path)
+	require.NoError(t, w.Close())
+}
+
+func TestRetentionDisabled(t *testing.T) {
+	now := time.Now()
+	w := newTestWriter(t, &now)
+	old := writeRotated(t, w, now.Add(-24*365*time.Hour), "", 10)
+
+	w.enforceRetention()
+	require.Equal(t, []string{filepath.Base(old)}, remainingFiles(t, w))
+}
@@ -0,0 +1,263 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+)
+
+const rotatedTimeFormat = "20060102-150405.000000000"
+
+// rotatingWriter writes lines to a file and rotates it once it exceeds maxSize bytes or has been open for longer
+// than interval. Rotated files are renamed to path.TIMESTAMP, compressed to path.TIMESTAMP.gz if compress is set,
+// encrypted to path.TIMESTAMP.age or path.TIMESTAMP.gz.age if there are recipients, and deleted by enforceRetention
+// according to maxFiles, maxAge and maxTotalSize.
+type rotatingWriter struct {
+	path         string
+	maxSize      int64
+	interval     time.Duration
+	maxFiles     int
+	maxAge       time.Duration
+	maxTotalSize int64
+	compress     bool
+
+	// recipients are the age recipients rotated files are encrypted to; with recipients, the current file is
+	// rotated when closing, so that no events are left unencrypted
+	recipients []age.Recipient
+
+	// header is written to the beginning of each file
+	header []byte
+
+	logger logger.Logger
+	now    func() time.Time
+
+	mu     sync.Mutex
+	file   *os.File
+	buf    *bufio.Writer
+	size   int64
+	opened time.Time
+
+	// processing tracks running compressions and encryptions of rotated files
+	processing sync.WaitGroup
+
+	retentionMu sync.Mutex
+}
+
+// open opens the file, appending to it if it already exists
+func (w *rotatingWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file = file
+	w.buf = bufio.NewWriter(file)
+	w.size = info.Size()
+	w.opened = w.now()
+	if w.size == 0 && len(w.header) > 0 {
+		n, err := w.buf.Write(w.header)
+		w.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// needsRotation returns whether writing n bytes has to go to a new file; mu must be held
+func (w *rotatingWriter) needsRotation(n int) bool {
+	if w.size <= int64(len(w.header)) {
+		// Don't rotate files without events, e.g. if a single line exceeds maxSize
+		return false
+	}
+	if w.maxSize > 0 && w.size+int64(n) > w.maxSize {
+		return true
+	}
+	return w.interval > 0 && w.now().Sub(w.opened) >= w.interval
+}
+
+// rotate closes the current file, renames it and opens a new one; mu must be held
+func (w *rotatingWriter) rotate() error {
+	if err := w.closeFile(); err != nil {
+		return err
+	}
+	if err := w.rename(); err != nil {
+		// Keep writing to the current file
+		if openErr := w.open(); openErr != nil {
+			return fmt.Errorf("reopening %q: %w", w.path, openErr)
+		}
+		return fmt.Errorf("renaming %q: %w", w.path, err)
+	}
+	return w.open()
+}
+
+// rename renames the closed current file to path.TIMESTAMP, compresses and encrypts it in the background if needed
+// and enforces the retention policies; mu must be held
+func (w *rotatingWriter) rename() error {
+	rotated := w.path + "." + w.now().Format(rotatedTimeFormat)
+	if err := os.Rename(w.path, rotated); err != nil {
+		return err
+	}
+	if w.compress || len(w.recipients) > 0 {
+		w.processing.Add(1)
+		go func() {
+			defer w.processing.Done()
+			if err := w.processFile(rotated); err != nil {
+				w.logger.Warnf("processing rotated file %q: %v", rotated, err)
+			}
+			w.enforceRetention()
+		}()
+	} else {
+		w.enforceRetention()
+	}
+	return nil
+}
+
+// processFile replaces path with a version compressed using gzip if compress is set and encrypted to the recipients
+// using age if there are any, named path.gz, path.age or path.gz.age
+func (w *rotatingWriter) processFile(path string) (err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	ext := ""
+	if w.compress {
+		ext += ".gz"
+	}
+	if len(w.recipients) > 0 {
+		ext += ".age"
+	}
+	tmp := path + ext + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	// Data is compressed before being encrypted; the writers are closed in reverse order
+	var dst io.Writer = out
+	var closers []io.Closer
+	if len(w.recipients) > 0 {
+		enc, err := age.Encrypt(dst, w.recipients...)
+		if err != nil {
+			return fmt.Errorf("encrypting: %w", err)
+		}
+		dst = enc
+		closers = append(closers, enc)
+	}
+	if w.compress {
+		gz := gzip.NewWriter(dst)
+		dst = gz
+		closers = append(closers, gz)
+	}
+	if _, err := io.Copy(dst, in); err != nil {
+		return err
+	}
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].Close(); err != nil {
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path+ext); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Write writes a single line, rotating the file before if needed
+func (w *rotatingWriter) Write(line []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return os.ErrClosed
+	}
+	if w.needsRotation(len(line)) {
+		if err := w.rotate(); err != nil {
+			return fmt.Errorf("rotating %q: %w", w.path, err)
+		}
+	}
+	n, err := w.buf.Write(line)
+	w.size += int64(n)
+	return err
+}
+
+// Flush writes buffered lines to the file
+func (w *rotatingWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	return w.buf.Flush()
+}
+
+// closeFile flushes and closes the current file; mu must be held
+func (w *rotatingWriter) closeFile() error {
+	var result error
+	if err := w.buf.Flush(); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := w.file.Close(); err != nil {
+		result = multierror.Append(result, err)
+	}
+	w.file = nil
+	w.buf = nil
+	return result
+}
+
+// Close closes the file and waits for the compression and encryption of rotated files
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	var err error
+	if w.file != nil {
+		err = w.closeFile()
+		if err == nil && len(w.recipients) > 0 && w.size > int64(len(w.header)) {
+			if err = w.rename(); err != nil {
+				err = fmt.Errorf("renaming %q: %w", w.path, err)
+			}
+		}
+	}
+	w.mu.Unlock()
+
+	w.processing.Wait()
+	return err
+}
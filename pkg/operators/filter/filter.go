@@ -0,0 +1,181 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package filter provides an operator that drops data not matching a filter expression before it reaches sinks
+// like the CLI or remote clients. See datasource.Filter for the syntax of expressions.
+package filter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/ioc"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	OperatorName = "filter"
+
+	ParamFilter      = "filter"
+	ParamMinSeverity = "min-severity"
+
+	// Priority is chosen so that all fields have been filled by formatters and enrichers, but data is dropped
+	// before it reaches any sink
+	Priority = ioc.Priority + 500
+)
+
+type filterOperator struct{}
+
+func (o *filterOperator) Name() string {
+	return OperatorName
+}
+
+func (o *filterOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *filterOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *filterOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:   ParamFilter,
+			Title: "Filter",
+			Description: "Only emit data matching the given expression, e.g. 'proc.comm == \"nginx\" && addr in 10.0.0.0/8'; " +
+				"prefix the expression with \"<datasource>:\" to only filter a single data source",
+			TypeHint: api.TypeString,
+		},
+		{
+			Key:   ParamMinSeverity,
+			Title: "Minimum severity",
+			Description: "Only emit data with at least the given severity, as set by the severity annotations of " +
+				"the gadget or by operators like ioc; data without a severity is dropped",
+			PossibleValues: []string{"", "debug", "info", "low", "medium", "high", "critical"},
+			TypeHint:       api.TypeString,
+		},
+	}
+}
+
+// splitExpression splits off the name of the data source an expression is meant for, if given
+func splitExpression(gadgetCtx operators.GadgetContext, expr string) (string, string) {
+	dsName, rest, ok := strings.Cut(expr, ":")
+	if !ok {
+		return "", expr
+	}
+	if _, ok := gadgetCtx.GetDataSources()[strings.TrimSpace(dsName)]; !ok {
+		// colons can also be part of values, like IPv6 addresses
+		return "", expr
+	}
+	return strings.TrimSpace(dsName), rest
+}
+
+func (o *filterOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &filterOperatorInstance{
+		filters:    make(map[datasource.DataSource]*datasource.Filter),
+		severities: make(map[datasource.DataSource]*datasource.SeverityReader),
+	}
+
+	if minSeverity := strings.TrimSpace(params.Get(ParamMinSeverity).AsString()); minSeverity != "" {
+		inst.minSeverity, err = datasource.ParseSeverity(minSeverity)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", ParamMinSeverity, err)
+		}
+		for name, ds := range gadgetCtx.GetDataSources() {
+			r, err := datasource.NewSeverityReader(ds)
+			if err != nil {
+				return nil, fmt.Errorf("reading severity of data source %q: %w", name, err)
+			}
+			inst.severities[ds] = r
+		}
+	}
+
+	expr := strings.TrimSpace(params.Get(ParamFilter).AsString())
+	if expr == "" {
+		return inst, nil
+	}
+
+	dsName, expr := splitExpression(gadgetCtx, expr)
+
+	for name, ds := range gadgetCtx.GetDataSources() {
+		if dsName != "" && name != dsName {
+			continue
+		}
+		filter, err := datasource.CompileFilter(ds, expr)
+		if err != nil {
+			return nil, fmt.Errorf("compiling filter for data source %q: %w", name, err)
+		}
+		inst.filters[ds] = filter
+	}
+	return inst, nil
+}
+
+func (o *filterOperator) Priority() int {
+	return Priority
+}
+
+type filterOperatorInstance struct {
+	filters     map[datasource.DataSource]*datasource.Filter
+	minSeverity datasource.Severity
+	severities  map[datasource.DataSource]*datasource.SeverityReader
+}
+
+func (o *filterOperatorInstance) Name() string {
+	return OperatorName
+}
+
+func (o *filterOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error {
+	for ds, filter := range o.filters {
+		filter := filter
+		ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+			if !filter.Match(data) {
+				return datasource.ErrDiscard
+			}
+			return nil
+		}, Priority)
+	}
+	for ds, r := range o.severities {
+		r := r
+		ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+			if r.Severity(data) < o.minSeverity {
+				return datasource.ErrDiscard
+			}
+			return nil
+		}, Priority)
+	}
+	return nil
+}
+
+func (o *filterOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+func (o *filterOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+func init() {
+	operators.RegisterDataOperator(&filterOperator{})
+}
@@ -0,0 +1,158 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+func newTestData(t *testing.T) (datasource.DataSource, datasource.Data) {
+	ds := datasource.New(datasource.TypeEvent, "test")
+
+	proc, err := ds.AddField("proc", datasource.WithFlags(datasource.FieldFlagEmpty))
+	require.NoError(t, err)
+	comm, err := proc.AddSubField("comm", datasource.WithKind(api.Kind_String))
+	require.NoError(t, err)
+	pid, err := proc.AddSubField("pid", datasource.WithKind(api.Kind_Uint32))
+	require.NoError(t, err)
+	ret, err := ds.AddField("ret", datasource.WithKind(api.Kind_Int32))
+	require.NoError(t, err)
+	addr, err := ds.AddField("addr", datasource.WithKind(api.Kind_String))
+	require.NoError(t, err)
+
+	data := ds.NewData()
+	require.NoError(t, comm.Set(data, []byte("nginx")))
+	require.NoError(t, pid.Set(data, make([]byte, 4)))
+	pid.PutUint32(data, 1234)
+	require.NoError(t, ret.Set(data, make([]byte, 4)))
+	ret.PutInt32(data, -2)
+	require.NoError(t, addr.Set(data, []byte("10.1.2.3")))
+	return ds, data
+}
+
+func TestFilter(t *testing.T) {
+	ds, data := newTestData(t)
+
+	for expr, expected := range map[string]bool{
+		`proc.comm == "nginx"`:                            true,
+		`proc.comm != nginx`:                              false,
+		`proc.comm > "apache"`:                            true,
+		`proc.pid == 1234 && ret < 0`:                     true,
+		`proc.pid >= 0x1000`:                              false,
+		`ret == -2`:                                       true,
+		`addr in 10.0.0.0/8`:                              true,
+		`addr in fd00::/8`:                                false,
+		`!(addr in 10.0.0.0/8) || proc.comm == "bash"`:    false,
+		`proc.comm == "bash" || proc.pid == 1234`:         true,
+		`proc.comm == "bash" || proc.pid == 1 && ret < 0`: false,
+	} {
+		filter, err := datasource.CompileFilter(ds, expr)
+		require.NoError(t, err, expr)
+		require.Equal(t, expected, filter.Match(data), expr)
+	}
+}
+
+func TestFilterInvalid(t *testing.T) {
+	ds, _ := newTestData(t)
+
+	for _, expr := range []string{
+		``,
+		`proc.comm ==`,
+		`unknown == 1`,
+		`proc.pid == nginx`,
+		`proc.pid in 10.0.0.0/8`,
+		`addr in 10.0.0.0`,
+		`(proc.pid == 1`,
+		`proc.pid == 1 proc.pid == 2`,
+		`proc.comm == "nginx`,
+	} {
+		_, err := datasource.CompileFilter(ds, expr)
+		require.Error(t, err, expr)
+	}
+}
+
+func TestSubscribeFiltered(t *testing.T) {
+	ds, data := newTestData(t)
+
+	var matched, unfiltered int
+	require.NoError(t, ds.SubscribeFiltered(func(datasource.DataSource, datasource.Data) error {
+		matched++
+		return nil
+	}, 0, `proc.comm == "bash"`))
+	ds.Subscribe(func(datasource.DataSource, datasource.Data) error {
+		unfiltered++
+		return datasource.ErrDiscard
+	}, 1)
+	ds.Subscribe(func(datasource.DataSource, datasource.Data) error {
+		t.Fatal("discarded data must not be passed on")
+		return nil
+	}, 2)
+
+	require.NoError(t, ds.EmitAndRelease(data))
+	require.Equal(t, 0, matched)
+	require.Equal(t, 1, unfiltered)
+
+	require.Error(t, ds.SubscribeFiltered(nil, 0, `proc.comm ==`))
+}
+
+func TestMinSeverity(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "alerts")
+	require.NoError(t, err)
+	ds.AddAnnotation(datasource.AnnotationSeverity, "low")
+	severity, err := ds.AddField("severity",
+		datasource.WithKind(api.Kind_String),
+		datasource.WithAnnotations(map[string]string{datasource.AnnotationSeverityField: "true"}))
+	require.NoError(t, err)
+	other, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "other")
+	require.NoError(t, err)
+
+	inst, err := (&filterOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{
+		ParamMinSeverity: "medium",
+	})
+	require.NoError(t, err)
+	require.NoError(t, inst.(*filterOperatorInstance).PreStart(gadgetCtx))
+
+	var passed []string
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		passed = append(passed, severity.String(data))
+		return nil
+	}, Priority+1)
+	other.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		t.Fatal("data without a severity must be dropped")
+		return nil
+	}, Priority+1)
+
+	// An empty or invalid severity field falls back to the severity of the data source
+	for _, s := range []string{"critical", "", "info", "Medium", "bogus", "high"} {
+		data := ds.NewData()
+		require.NoError(t, severity.Set(data, []byte(s)))
+		require.NoError(t, ds.EmitAndRelease(data))
+	}
+	require.Equal(t, []string{"critical", "Medium", "high"}, passed)
+	require.NoError(t, other.EmitAndRelease(other.NewData()))
+
+	_, err = (&filterOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{
+		ParamMinSeverity: "urgent",
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,215 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gpu
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// nvidiaDevice matches the device nodes of NVIDIA GPUs; /dev/nvidiactl and the other nodes of the driver don't refer
+// to a single GPU
+var nvidiaDevice = regexp.MustCompile(`^/dev/(nvidia[0-9]+)$`)
+
+// drmClient is the usage of a GPU by a DRM client, i.e. an open file of a DRM device, as reported in its fdinfo; see
+// Documentation/gpu/drm-usage-stats.rst in the kernel
+type drmClient struct {
+	driver string
+	device string
+	id     uint64
+	// engineNs is the time the engines of the GPU spent on work of the client
+	engineNs uint64
+	// memoryBytes is the memory of the GPU used by the client that is resident
+	memoryBytes uint64
+}
+
+// parseMemory parses values like "1024 KiB" as used for the memory of DRM clients
+func parseMemory(value string) (uint64, bool) {
+	number, unit, _ := strings.Cut(value, " ")
+	v, err := strconv.ParseUint(number, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	switch unit {
+	case "":
+		return v, true
+	case "KiB":
+		return v << 10, true
+	case "MiB":
+		return v << 20, true
+	case "GiB":
+		return v << 30, true
+	}
+	return 0, false
+}
+
+// parseFdinfo parses the fdinfo of a DRM device. It returns false if the file doesn't identify a DRM client, e.g.
+// because the kernel or driver doesn't report usage statistics.
+func parseFdinfo(r io.Reader) (drmClient, bool) {
+	var c drmClient
+	var hasID bool
+	// drm-memory-<region> is the older name of drm-resident-<region>; it's only used if the latter isn't there
+	var memory, resident uint64
+	var hasResident bool
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case key == "drm-driver":
+			c.driver = value
+		case key == "drm-pdev":
+			c.device = value
+		case key == "drm-client-id":
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return drmClient{}, false
+			}
+			c.id = id
+			hasID = true
+		case strings.HasPrefix(key, "drm-engine-capacity-"):
+		case strings.HasPrefix(key, "drm-engine-"):
+			if ns, ok := strings.CutSuffix(value, " ns"); ok {
+				if v, err := strconv.ParseUint(ns, 10, 64); err == nil {
+					c.engineNs += v
+				}
+			}
+		case strings.HasPrefix(key, "drm-resident-"):
+			if v, ok := parseMemory(value); ok {
+				resident += v
+				hasResident = true
+			}
+		case strings.HasPrefix(key, "drm-memory-"):
+			if v, ok := parseMemory(value); ok {
+				memory += v
+			}
+		}
+	}
+	if scanner.Err() != nil || c.driver == "" || !hasID {
+		return drmClient{}, false
+	}
+	c.memoryBytes = memory
+	if hasResident {
+		c.memoryBytes = resident
+	}
+	return c, true
+}
+
+// gpuUsage is the usage of a GPU by a process, summed up over its DRM clients
+type gpuUsage struct {
+	driver      string
+	device      string
+	clients     uint32
+	engineNs    uint64
+	memoryBytes uint64
+}
+
+// processUsage returns the usage of GPUs by the process with the given pid, sorted by device. GPUs of DRM drivers
+// are found by the fdinfo of their open files; NVIDIA GPUs managed by the proprietary driver are only found by their
+// open device nodes, without usage.
+func processUsage(procPath string, pid int) ([]gpuUsage, error) {
+	dir := filepath.Join(procPath, strconv.Itoa(pid))
+	fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+	if err != nil {
+		return nil, err
+	}
+
+	type clientKey struct {
+		driver, device string
+		id             uint64
+	}
+	seen := make(map[clientKey]struct{})
+	usage := make(map[string]*gpuUsage)
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join(dir, "fd", fd.Name()))
+		if err != nil {
+			// The file might have been closed in the meantime
+			continue
+		}
+
+		if m := nvidiaDevice.FindStringSubmatch(target); m != nil {
+			if _, ok := usage[m[1]]; !ok {
+				usage[m[1]] = &gpuUsage{driver: "nvidia", device: m[1]}
+			}
+			continue
+		}
+		if !strings.HasPrefix(target, "/dev/dri/") {
+			continue
+		}
+
+		f, err := os.Open(filepath.Join(dir, "fdinfo", fd.Name()))
+		if err != nil {
+			continue
+		}
+		c, ok := parseFdinfo(f)
+		f.Close()
+		if !ok {
+			continue
+		}
+		if c.device == "" {
+			c.device = filepath.Base(target)
+		}
+		// Duplicated file descriptors refer to the same client
+		key := clientKey{c.driver, c.device, c.id}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		u, ok := usage[c.device]
+		if !ok {
+			u = &gpuUsage{driver: c.driver, device: c.device}
+			usage[c.device] = u
+		}
+		u.clients++
+		u.engineNs += c.engineNs
+		u.memoryBytes += c.memoryBytes
+	}
+
+	result := make([]gpuUsage, 0, len(usage))
+	for _, u := range usage {
+		result = append(result, *u)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].device < result[j].device })
+	return result, nil
+}
+
+// pids returns the pids of all processes found in procPath
+func pids(procPath string) ([]int, error) {
+	entries, err := os.ReadDir(procPath)
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
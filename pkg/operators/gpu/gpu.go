@@ -0,0 +1,292 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gpu provides a data operator that takes snapshots of the processes of containers using GPUs. It's used by
+// running the image "operator:snapshot_gpu", which emits one entry per process and GPU with the time the engines of the
+// GPU spent on work of the process and the memory it uses, as reported by DRM drivers in the fdinfo of their files.
+// Processes using NVIDIA GPUs through the proprietary driver, which doesn't report usage there, are found by their open
+// device nodes. Like the snapshotters of gadgets, the entries are emitted once when starting, and again whenever a
+// snapshot is requested, e.g. by the snapshotdiff operator. No eBPF program is attached.
+package gpu
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource/compat"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+)
+
+const (
+	OperatorName = "gpu"
+
+	// ImageName is the name of the image taking snapshots of the processes using GPUs
+	ImageName = operators.OperatorImagePrefix + "snapshot_gpu"
+
+	// DataSourceName is the name of the data source with one entry per process and GPU
+	DataSourceName = "gpu_processes"
+)
+
+// IsGPUImage tells whether imageName refers to the snapshots of processes using GPUs
+var IsGPUImage = operators.MatchImageNames(ImageName)
+
+type gpuOperator struct {
+	// procPath is used instead of host.HostProcFs if set
+	procPath string
+}
+
+func (o *gpuOperator) Name() string {
+	return OperatorName
+}
+
+func (o *gpuOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *gpuOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *gpuOperator) InstanceParams() api.Params {
+	return nil
+}
+
+func (o *gpuOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	if !IsGPUImage(gadgetCtx.ImageName()) {
+		return nil, nil
+	}
+
+	inst := &gpuOperatorInstance{
+		logger:     gadgetCtx.Logger(),
+		procPath:   o.procPath,
+		containers: make(map[string]*containercollection.Container),
+	}
+	if inst.procPath == "" {
+		inst.procPath = host.HostProcFs
+	}
+
+	var err error
+	inst.ds, err = gadgetCtx.RegisterDataSource(datasource.TypeEvent, DataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("adding data source %q: %w", DataSourceName, err)
+	}
+	if err := inst.addFields(); err != nil {
+		return nil, fmt.Errorf("adding fields to data source %q: %w", DataSourceName, err)
+	}
+
+	// The managers attach the selected containers to the instance of the gadget
+	gadgetCtx.SetVar("ebpfInstance", inst)
+	gadgetCtx.SetVar(operators.SnapshottersVar, map[string]operators.SnapshotFunc{
+		DataSourceName: inst.snapshot,
+	})
+	return inst, nil
+}
+
+func (o *gpuOperator) Priority() int {
+	return operators.OperatorImagePriority
+}
+
+type gpuOperatorInstance struct {
+	ds       datasource.DataSource
+	logger   logger.Logger
+	procPath string
+
+	mntns       datasource.FieldAccessor
+	pid         datasource.FieldAccessor
+	comm        datasource.FieldAccessor
+	driver      datasource.FieldAccessor
+	device      datasource.FieldAccessor
+	clients     datasource.FieldAccessor
+	engineNs    datasource.FieldAccessor
+	memoryBytes datasource.FieldAccessor
+
+	mu         sync.Mutex
+	containers map[string]*containercollection.Container
+}
+
+func (o *gpuOperatorInstance) addFields() error {
+	hidden := datasource.WithFlags(datasource.FieldFlagHidden)
+	for _, f := range []struct {
+		acc         *datasource.FieldAccessor
+		name        string
+		kind        api.Kind
+		annotations map[string]string
+		opts        []datasource.FieldOption
+	}{
+		{&o.mntns, "mntns_id", api.Kind_Uint64, map[string]string{"description": "Mount namespace inode id", "columns.template": "ns"}, []datasource.FieldOption{datasource.WithTags(compat.MntNsIdType)}},
+		{&o.pid, "pid", api.Kind_Uint32, map[string]string{"columns.template": "pid"}, nil},
+		{&o.comm, "comm", api.Kind_String, map[string]string{"columns.template": "comm"}, nil},
+		{&o.driver, "driver", api.Kind_String, map[string]string{"description": "Driver of the GPU", "columns.width": "10"}, nil},
+		{&o.device, "device", api.Kind_String, map[string]string{"description": "PCI address of the GPU, or its device node if it's unknown", "columns.width": "14"}, nil},
+		{&o.clients, "clients", api.Kind_Uint32, map[string]string{"description": "Number of DRM clients, i.e. contexts, the process has on the GPU"}, []datasource.FieldOption{hidden}},
+		{&o.engineNs, "engine_ns", api.Kind_Uint64, map[string]string{"description": "Time the engines of the GPU spent on work of the process, in nanoseconds", "columns.width": "16"}, nil},
+		{&o.memoryBytes, "memory_bytes", api.Kind_Uint64, map[string]string{"description": "Memory used by the process that is resident on the GPU or, for integrated GPUs, in system memory", "columns.width": "16"}, nil},
+	} {
+		opts := append([]datasource.FieldOption{datasource.WithKind(f.kind), datasource.WithAnnotations(f.annotations)}, f.opts...)
+		acc, err := o.ds.AddField(f.name, opts...)
+		if err != nil {
+			return err
+		}
+		*f.acc = acc
+	}
+	return nil
+}
+
+func (o *gpuOperatorInstance) Name() string {
+	return OperatorName
+}
+
+func (o *gpuOperatorInstance) AttachContainer(container *containercollection.Container) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// The host is attached as a container without ID
+	o.containers[container.Runtime.ContainerID] = container
+	return nil
+}
+
+func (o *gpuOperatorInstance) DetachContainer(container *containercollection.Container) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.containers, container.Runtime.ContainerID)
+	return nil
+}
+
+func (o *gpuOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	return o.snapshot()
+}
+
+func (o *gpuOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+// mountNamespaces returns the mount namespaces of the attached containers
+func (o *gpuOperatorInstance) mountNamespaces() map[uint64]struct{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	namespaces := make(map[uint64]struct{}, len(o.containers))
+	for _, c := range o.containers {
+		mntns := c.Mntns
+		if mntns == 0 {
+			var err error
+			if mntns, err = o.mountNamespace(int(c.Pid)); err != nil {
+				o.logger.Warnf("getting mount namespace of pid %d: %v", c.Pid, err)
+				continue
+			}
+		}
+		namespaces[mntns] = struct{}{}
+	}
+	return namespaces
+}
+
+// mountNamespace returns the id of the mount namespace of the process with the given pid
+func (o *gpuOperatorInstance) mountNamespace(pid int) (uint64, error) {
+	fi, err := os.Stat(filepath.Join(o.procPath, strconv.Itoa(pid), "ns", "mnt"))
+	if err != nil {
+		return 0, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("unsupported stat for mount namespace of pid %d", pid)
+	}
+	return st.Ino, nil
+}
+
+// snapshot emits the usage of GPUs by all processes of the attached containers
+func (o *gpuOperatorInstance) snapshot() error {
+	namespaces := o.mountNamespaces()
+	if len(namespaces) == 0 {
+		return nil
+	}
+
+	pids, err := pids(o.procPath)
+	if err != nil {
+		return fmt.Errorf("listing processes: %w", err)
+	}
+	for _, pid := range pids {
+		mntns, err := o.mountNamespace(pid)
+		if err != nil {
+			// The process might have exited in the meantime
+			continue
+		}
+		if _, ok := namespaces[mntns]; !ok {
+			continue
+		}
+		usage, err := processUsage(o.procPath, pid)
+		if err != nil {
+			o.logger.Debugf("reading GPU usage of pid %d: %v", pid, err)
+			continue
+		}
+		if len(usage) == 0 {
+			continue
+		}
+		comm, _ := os.ReadFile(filepath.Join(o.procPath, strconv.Itoa(pid), "comm"))
+		for _, u := range usage {
+			if err := o.emit(mntns, uint32(pid), strings.TrimSpace(string(comm)), u); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (o *gpuOperatorInstance) emit(mntns uint64, pid uint32, comm string, u gpuUsage) error {
+	bo := o.ds.ByteOrder()
+	u32 := func(v uint32) []byte {
+		b := make([]byte, 4)
+		bo.PutUint32(b, v)
+		return b
+	}
+	u64 := func(v uint64) []byte {
+		b := make([]byte, 8)
+		bo.PutUint64(b, v)
+		return b
+	}
+
+	data := o.ds.NewData()
+	for _, f := range []struct {
+		acc   datasource.FieldAccessor
+		value []byte
+	}{
+		{o.mntns, u64(mntns)},
+		{o.pid, u32(pid)},
+		{o.comm, []byte(comm)},
+		{o.driver, []byte(u.driver)},
+		{o.device, []byte(u.device)},
+		{o.clients, u32(u.clients)},
+		{o.engineNs, u64(u.engineNs)},
+		{o.memoryBytes, u64(u.memoryBytes)},
+	} {
+		if err := f.acc.Set(data, f.value); err != nil {
+			o.ds.Release(data)
+			return fmt.Errorf("setting field %q: %w", f.acc.Name(), err)
+		}
+	}
+	return o.ds.EmitAndRelease(data)
+}
+
+func init() {
+	operators.RegisterOperatorImages(IsGPUImage)
+	operators.RegisterDataOperator(&gpuOperator{})
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gpu
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+)
+
+const amdgpuFdinfo = `pos:	0
+flags:	02100002
+drm-driver:	amdgpu
+drm-pdev:	0000:03:00.0
+drm-client-id:	42
+drm-memory-vram:	1024 KiB
+drm-memory-gtt:	2 MiB
+drm-engine-gfx:	1000 ns
+drm-engine-compute:	500 ns
+drm-engine-capacity-gfx:	2
+`
+
+const i915Fdinfo = `drm-driver:	i915
+drm-pdev:	0000:00:02.0
+drm-client-id:	7
+drm-total-system0:	8 MiB
+drm-resident-system0:	4 MiB
+drm-memory-system0:	8 MiB
+drm-engine-render:	300 ns
+drm-cycles-render:	12345
+`
+
+func TestParseFdinfo(t *testing.T) {
+	c, ok := parseFdinfo(strings.NewReader(amdgpuFdinfo))
+	require.True(t, ok)
+	require.Equal(t, drmClient{driver: "amdgpu", device: "0000:03:00.0", id: 42, engineNs: 1500, memoryBytes: 3 << 20}, c)
+
+	// drm-resident-<region> is preferred over drm-memory-<region>
+	c, ok = parseFdinfo(strings.NewReader(i915Fdinfo))
+	require.True(t, ok)
+	require.Equal(t, drmClient{driver: "i915", device: "0000:00:02.0", id: 7, engineNs: 300, memoryBytes: 4 << 20}, c)
+
+	// Files of devices without usage statistics don't identify a client
+	_, ok = parseFdinfo(strings.NewReader("pos:\t0\nflags:\t02100002\n"))
+	require.False(t, ok)
+}
+
+// addProcess adds a process with the given open files and their fdinfo to the fake procfs below procPath
+func addProcess(t *testing.T, procPath string, pid int, comm string, files map[string]string, fdinfo map[string]string) uint64 {
+	dir := filepath.Join(procPath, fmt.Sprint(pid))
+	for _, sub := range []string{"fd", "fdinfo", "ns"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, sub), 0o755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "comm"), []byte(comm+"\n"), 0o644))
+	for fd, target := range files {
+		require.NoError(t, os.Symlink(target, filepath.Join(dir, "fd", fd)))
+	}
+	for fd, content := range fdinfo {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "fdinfo", fd), []byte(content), 0o644))
+	}
+
+	// The inode of the file stands in for the id of the mount namespace
+	mnt := filepath.Join(dir, "ns", "mnt")
+	require.NoError(t, os.WriteFile(mnt, nil, 0o644))
+	fi, err := os.Stat(mnt)
+	require.NoError(t, err)
+	return fi.Sys().(*syscall.Stat_t).Ino
+}
+
+func TestProcessUsage(t *testing.T) {
+	procPath := t.TempDir()
+	addProcess(t, procPath, 100, "train", map[string]string{
+		"3": "/dev/dri/renderD128",
+		// A duplicate of the file descriptor of the same client
+		"4": "/dev/dri/renderD128",
+		"5": "/dev/nvidia0",
+		"6": "/dev/nvidiactl",
+		"7": "/tmp/data",
+		"8": "/dev/dri/card0",
+	}, map[string]string{
+		"3": amdgpuFdinfo,
+		"4": amdgpuFdinfo,
+		"8": strings.ReplaceAll(amdgpuFdinfo, "client-id:\t42", "client-id:\t43"),
+	})
+
+	usage, err := processUsage(procPath, 100)
+	require.NoError(t, err)
+	require.Equal(t, []gpuUsage{
+		{driver: "amdgpu", device: "0000:03:00.0", clients: 2, engineNs: 3000, memoryBytes: 6 << 20},
+		{driver: "nvidia", device: "nvidia0"},
+	}, usage)
+
+	_, err = processUsage(procPath, 101)
+	require.Error(t, err)
+}
+
+func TestGPUOperator(t *testing.T) {
+	op := &gpuOperator{procPath: t.TempDir()}
+
+	// Other images aren't handled
+	inst, err := op.InstantiateDataOperator(gadgetcontext.New(context.Background(), "trace_exec"), api.ParamValues{})
+	require.NoError(t, err)
+	require.Nil(t, inst)
+
+	mntns := addProcess(t, op.procPath, 100, "train", map[string]string{"3": "/dev/dri/renderD128"}, map[string]string{"3": amdgpuFdinfo})
+	addProcess(t, op.procPath, 101, "sh", map[string]string{"0": "/dev/null"}, nil)
+	// Processes of containers that aren't selected are skipped
+	addProcess(t, op.procPath, 200, "other", map[string]string{"3": "/dev/nvidia1"}, nil)
+
+	gadgetCtx := gadgetcontext.New(context.Background(), ImageName)
+	inst, err = op.InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+	require.NoError(t, err)
+	gpuInst := inst.(*gpuOperatorInstance)
+
+	v, ok := gadgetCtx.GetVar(operators.SnapshottersVar)
+	require.True(t, ok)
+	require.Contains(t, v.(map[string]operators.SnapshotFunc), DataSourceName)
+
+	ds := gadgetCtx.GetDataSources()[DataSourceName]
+	require.NotNil(t, ds)
+	var entries []string
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		entries = append(entries, fmt.Sprintf("%d %d %s %s %s %d %d",
+			ds.GetField("mntns_id").Uint64(data), ds.GetField("pid").Uint32(data), ds.GetField("comm").String(data),
+			ds.GetField("driver").String(data), ds.GetField("device").String(data),
+			ds.GetField("engine_ns").Uint64(data), ds.GetField("memory_bytes").Uint64(data)))
+		return nil
+	}, 0)
+
+	container := &containercollection.Container{Mntns: mntns}
+	container.Runtime.ContainerID = "abc"
+	require.NoError(t, gpuInst.AttachContainer(container))
+	require.NoError(t, gpuInst.Start(gadgetCtx))
+	require.Equal(t, []string{fmt.Sprintf("%d 100 train amdgpu 0000:03:00.0 1500 %d", mntns, 3<<20)}, entries)
+
+	// Nothing is emitted without containers
+	entries = nil
+	require.NoError(t, gpuInst.DetachContainer(container))
+	require.NoError(t, v.(map[string]operators.SnapshotFunc)[DataSourceName]())
+	require.Empty(t, entries)
+}
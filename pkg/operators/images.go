@@ -0,0 +1,68 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operators
+
+import (
+	"slices"
+	"strings"
+)
+
+// OperatorImagePriority is the priority of DataOperators running images on their own instead of the oci handler. It
+// makes sure their data sources are registered before other operators are instantiated, like the oci handler does for
+// image-based gadgets.
+const OperatorImagePriority = -1000
+
+// OperatorImagePrefix is the prefix of the names of images run by DataOperators on their own that aren't referring to
+// a family of images with a prefix of their own. It keeps them from shadowing images with the same name in a registry.
+const OperatorImagePrefix = "operator:"
+
+// ImageMatcher tells whether imageName refers to an image run by a DataOperator on its own
+type ImageMatcher func(imageName string) bool
+
+var operatorImages []ImageMatcher
+
+// MatchImageNames returns an ImageMatcher matching the given image names
+func MatchImageNames(names ...string) ImageMatcher {
+	return func(imageName string) bool {
+		return slices.Contains(names, imageName)
+	}
+}
+
+// MatchImagePrefix returns an ImageMatcher matching the image names starting with prefix
+func MatchImagePrefix(prefix string) ImageMatcher {
+	return func(imageName string) bool {
+		return strings.HasPrefix(imageName, prefix)
+	}
+}
+
+// RegisterOperatorImages registers the images matched by match as being run by a DataOperator on its own, so that
+// the oci handler skips them
+func RegisterOperatorImages(match ImageMatcher) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	operatorImages = append(operatorImages, match)
+}
+
+// IsOperatorImage tells whether imageName refers to an image registered using RegisterOperatorImages
+func IsOperatorImage(imageName string) bool {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	for _, match := range operatorImages {
+		if match(imageName) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ioc
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+)
+
+// Kind describes what an indicator (or a field that should be matched against indicators) contains
+type Kind string
+
+const (
+	KindAuto   Kind = ""
+	KindDomain Kind = "domain"
+	KindIP     Kind = "ip"
+	KindHash   Kind = "hash"
+)
+
+const (
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+var severities = []string{SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical}
+
+type indicator struct {
+	value    string
+	severity string
+}
+
+type cidrIndicator struct {
+	indicator
+	ipNet *net.IPNet
+}
+
+// Indicators holds a set of indicators of compromise that can be matched against values
+type Indicators struct {
+	domains map[string]indicator
+	ips     map[string]indicator
+	cidrs   []cidrIndicator
+	hashes  map[string]indicator
+}
+
+func NewIndicators() *Indicators {
+	return &Indicators{
+		domains: make(map[string]indicator),
+		ips:     make(map[string]indicator),
+		hashes:  make(map[string]indicator),
+	}
+}
+
+// Len returns the total number of indicators
+func (i *Indicators) Len() int {
+	return len(i.domains) + len(i.ips) + len(i.cidrs) + len(i.hashes)
+}
+
+func isHash(s string) bool {
+	switch len(s) {
+	case 32, 40, 64, 128: // md5, sha1, sha256, sha512
+		_, err := hex.DecodeString(s)
+		return err == nil
+	}
+	return false
+}
+
+func normalizeDomain(s string) string {
+	return strings.TrimSuffix(strings.ToLower(s), ".")
+}
+
+// Add adds a single indicator; the kind of the indicator is detected automatically
+func (i *Indicators) Add(value string, severity string) error {
+	if !slices.Contains(severities, severity) {
+		return fmt.Errorf("invalid severity %q for indicator %q", severity, value)
+	}
+	if ip := net.ParseIP(value); ip != nil {
+		i.ips[ip.String()] = indicator{value: value, severity: severity}
+		return nil
+	}
+	if _, ipNet, err := net.ParseCIDR(value); err == nil {
+		i.cidrs = append(i.cidrs, cidrIndicator{
+			indicator: indicator{value: value, severity: severity},
+			ipNet:     ipNet,
+		})
+		return nil
+	}
+	if isHash(strings.ToLower(value)) {
+		i.hashes[strings.ToLower(value)] = indicator{value: value, severity: severity}
+		return nil
+	}
+	domain := normalizeDomain(value)
+	if domain == "" || strings.ContainsAny(domain, " /:") {
+		return fmt.Errorf("unsupported indicator %q", value)
+	}
+	i.domains[domain] = indicator{value: value, severity: severity}
+	return nil
+}
+
+// Read reads indicators from r; each line contains an indicator optionally followed by its severity. Empty lines and
+// lines starting with # are ignored.
+func (i *Indicators) Read(r io.Reader, defaultSeverity string) error {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Fields(line)
+		severity := defaultSeverity
+		switch len(parts) {
+		case 1:
+		case 2:
+			severity = strings.ToLower(parts[1])
+		default:
+			return fmt.Errorf("line %d: expected indicator and optional severity, got %q", lineNo, line)
+		}
+		if err := i.Add(parts[0], severity); err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (i *Indicators) matchIP(value string) (indicator, bool) {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		// allow ip:port notation as used by l4endpoints
+		host, _, err := net.SplitHostPort(value)
+		if err != nil {
+			return indicator{}, false
+		}
+		if ip = net.ParseIP(host); ip == nil {
+			return indicator{}, false
+		}
+	}
+	if ind, ok := i.ips[ip.String()]; ok {
+		return ind, true
+	}
+	for _, c := range i.cidrs {
+		if c.ipNet.Contains(ip) {
+			return c.indicator, true
+		}
+	}
+	return indicator{}, false
+}
+
+func (i *Indicators) matchDomain(value string) (indicator, bool) {
+	domain := normalizeDomain(value)
+	// Check the domain itself and all its parent domains
+	for domain != "" {
+		if ind, ok := i.domains[domain]; ok {
+			return ind, true
+		}
+		idx := strings.IndexByte(domain, '.')
+		if idx < 0 {
+			break
+		}
+		domain = domain[idx+1:]
+	}
+	return indicator{}, false
+}
+
+func (i *Indicators) matchHash(value string) (indicator, bool) {
+	ind, ok := i.hashes[strings.ToLower(value)]
+	return ind, ok
+}
+
+// Match checks whether value matches any of the indicators of the given kind and returns the matching indicator and
+// its severity
+func (i *Indicators) Match(kind Kind, value string) (string, string, bool) {
+	if value == "" {
+		return "", "", false
+	}
+	var ind indicator
+	var ok bool
+	switch kind {
+	case KindIP:
+		ind, ok = i.matchIP(value)
+	case KindDomain:
+		ind, ok = i.matchDomain(value)
+	case KindHash:
+		ind, ok = i.matchHash(value)
+	default:
+		if ind, ok = i.matchIP(value); !ok {
+			if ind, ok = i.matchHash(value); !ok {
+				ind, ok = i.matchDomain(value)
+			}
+		}
+	}
+	return ind.value, ind.severity, ok
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ioc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testList = `
+# comment
+evil.example.com
+bad.org critical
+192.0.2.1 low
+198.51.100.0/24
+E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855 medium
+`
+
+func TestIndicators(t *testing.T) {
+	indicators := NewIndicators()
+	require.NoError(t, indicators.Read(strings.NewReader(testList), SeverityHigh))
+	require.Equal(t, 5, indicators.Len())
+
+	type testCase struct {
+		kind      Kind
+		value     string
+		match     bool
+		indicator string
+		severity  string
+	}
+	tests := []testCase{
+		{kind: KindDomain, value: "evil.example.com", match: true, indicator: "evil.example.com", severity: SeverityHigh},
+		{kind: KindDomain, value: "EVIL.example.com.", match: true, indicator: "evil.example.com", severity: SeverityHigh},
+		{kind: KindDomain, value: "sub.bad.org", match: true, indicator: "bad.org", severity: SeverityCritical},
+		{kind: KindDomain, value: "example.com", match: false},
+		{kind: KindDomain, value: "notbad.org", match: false},
+		{kind: KindIP, value: "192.0.2.1", match: true, indicator: "192.0.2.1", severity: SeverityLow},
+		{kind: KindIP, value: "192.0.2.1:443", match: true, indicator: "192.0.2.1", severity: SeverityLow},
+		{kind: KindIP, value: "198.51.100.77", match: true, indicator: "198.51.100.0/24", severity: SeverityHigh},
+		{kind: KindIP, value: "203.0.113.1", match: false},
+		{kind: KindHash, value: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", match: true, indicator: "E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855", severity: SeverityMedium},
+		{kind: KindAuto, value: "192.0.2.1", match: true, indicator: "192.0.2.1", severity: SeverityLow},
+		{kind: KindAuto, value: "www.bad.org", match: true, indicator: "bad.org", severity: SeverityCritical},
+		{kind: KindAuto, value: "", match: false},
+	}
+	for _, tc := range tests {
+		indicator, severity, ok := indicators.Match(tc.kind, tc.value)
+		require.Equal(t, tc.match, ok, "matching %q", tc.value)
+		require.Equal(t, tc.indicator, indicator, "matching %q", tc.value)
+		require.Equal(t, tc.severity, severity, "matching %q", tc.value)
+	}
+}
+
+func TestIndicatorsInvalid(t *testing.T) {
+	indicators := NewIndicators()
+	require.Error(t, indicators.Read(strings.NewReader("evil.com unknown"), SeverityHigh))
+	require.Error(t, indicators.Read(strings.NewReader("evil.com high extra"), SeverityHigh))
+	require.Error(t, indicators.Read(strings.NewReader("http://evil.com/path"), SeverityHigh))
+}
@@ -0,0 +1,367 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ioc provides an operator that loads lists of indicators of compromise (domains, IP addresses / networks
+// and file hashes) from files or URLs and flags events containing matching values with a verdict and a severity.
+package ioc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	OperatorName = "ioc"
+
+	ParamLists           = "lists"
+	ParamRefreshInterval = "refresh-interval"
+	ParamSeverity        = "severity"
+	ParamFields          = "fields"
+
+	// AnnotationType can be used on fields to mark them as containing values that should be matched against the
+	// indicators; valid values are "domain", "ip" and "hash"
+	AnnotationType = "ioc.type"
+
+	VerdictMatch = "match"
+
+	// Priority is chosen so that formatters and enrichers have already been run
+	Priority = 9000
+
+	fetchTimeout = 30 * time.Second
+)
+
+type iocOperator struct{}
+
+func (o *iocOperator) Name() string {
+	return OperatorName
+}
+
+func (o *iocOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *iocOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *iocOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:         ParamLists,
+			Title:       "Indicator lists",
+			Description: "Comma-separated list of files or http(s) URLs containing indicators of compromise (one per line, optionally followed by a severity)",
+			TypeHint:    api.TypeString,
+		},
+		{
+			Key:          ParamRefreshInterval,
+			Title:        "Refresh interval",
+			Description:  "Interval in which indicator lists are reloaded; use 0 to disable",
+			DefaultValue: "1h",
+			TypeHint:     api.TypeDuration,
+		},
+		{
+			Key:            ParamSeverity,
+			Title:          "Default severity",
+			Description:    "Severity of indicators that don't specify one",
+			DefaultValue:   SeverityHigh,
+			PossibleValues: severities,
+			TypeHint:       api.TypeString,
+		},
+		{
+			Key:         ParamFields,
+			Title:       "Fields",
+			Description: "Comma-separated list of additional string fields to match against the indicators",
+			TypeHint:    api.TypeString,
+		},
+	}
+}
+
+type watchedField struct {
+	kind     Kind
+	accessor datasource.FieldAccessor
+}
+
+type dsMatcher struct {
+	fields    []watchedField
+	verdict   datasource.FieldAccessor
+	severity  datasource.FieldAccessor
+	indicator datasource.FieldAccessor
+}
+
+func (o *iocOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	lists := params.Get(ParamLists).AsStringSlice()
+
+	inst := &iocOperatorInstance{
+		lists:           lists,
+		refreshInterval: params.Get(ParamRefreshInterval).AsDuration(),
+		defaultSeverity: params.Get(ParamSeverity).AsString(),
+		matchers:        make(map[datasource.DataSource]*dsMatcher),
+		done:            make(chan struct{}),
+	}
+	if len(lists) == 0 {
+		return inst, nil
+	}
+
+	indicators, err := inst.load(gadgetCtx.Context())
+	if err != nil {
+		return nil, fmt.Errorf("loading indicators: %w", err)
+	}
+	inst.indicators.Store(indicators)
+	gadgetCtx.Logger().Debugf("loaded %d indicators", indicators.Len())
+
+	extraFields := params.Get(ParamFields).AsStringSlice()
+
+	for _, ds := range gadgetCtx.GetDataSources() {
+		var fields []watchedField
+		addField := func(f datasource.FieldAccessor, kind Kind) {
+			if !isStringKind(f.Type()) {
+				gadgetCtx.Logger().Warnf("ioc: ignoring field %q of datasource %q: only string fields can be matched",
+					f.Name(), ds.Name())
+				return
+			}
+			fields = append(fields, watchedField{kind: kind, accessor: f})
+		}
+		for _, f := range ds.Accessors(false) {
+			if datasource.FieldFlagEmpty.In(f.Flags()) || datasource.FieldFlagContainer.In(f.Flags()) {
+				continue
+			}
+			if t, ok := f.Annotations()[AnnotationType]; ok {
+				addField(f, Kind(t))
+			}
+		}
+		// IP addresses generated by the formatters operator
+		for _, f := range ds.GetFieldsWithTag("l3string") {
+			addField(f, KindIP)
+		}
+		for _, name := range extraFields {
+			if f := ds.GetField(name); f != nil {
+				addField(f, KindAuto)
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		gadgetCtx.Logger().Debugf("ioc: matching %d fields of datasource %q", len(fields), ds.Name())
+
+		m := &dsMatcher{fields: fields}
+		iocField, err := ds.AddField("ioc", datasource.WithFlags(datasource.FieldFlagEmpty))
+		if err != nil {
+			return nil, fmt.Errorf("adding ioc field: %w", err)
+		}
+		m.verdict, err = iocField.AddSubField("verdict", datasource.WithKind(api.Kind_String))
+		if err != nil {
+			return nil, fmt.Errorf("adding verdict field: %w", err)
+		}
+		m.severity, err = iocField.AddSubField("severity",
+			datasource.WithKind(api.Kind_String),
+			datasource.WithAnnotations(map[string]string{datasource.AnnotationSeverityField: "true"}))
+		if err != nil {
+			return nil, fmt.Errorf("adding severity field: %w", err)
+		}
+		m.indicator, err = iocField.AddSubField("indicator",
+			datasource.WithKind(api.Kind_String),
+			datasource.WithFlags(datasource.FieldFlagHidden))
+		if err != nil {
+			return nil, fmt.Errorf("adding indicator field: %w", err)
+		}
+		inst.matchers[ds] = m
+	}
+
+	if len(inst.matchers) == 0 {
+		gadgetCtx.Logger().Warnf("ioc: no fields to match indicators against")
+	}
+
+	return inst, nil
+}
+
+func isStringKind(kind api.Kind) bool {
+	return kind == api.Kind_String || kind == api.Kind_CString
+}
+
+func (o *iocOperator) Priority() int {
+	return Priority
+}
+
+type iocOperatorInstance struct {
+	lists           []string
+	refreshInterval time.Duration
+	defaultSeverity string
+	indicators      atomic.Pointer[Indicators]
+	matchers        map[datasource.DataSource]*dsMatcher
+	done            chan struct{}
+	doneOnce        sync.Once
+}
+
+func (o *iocOperatorInstance) Name() string {
+	return OperatorName
+}
+
+func fetch(ctx context.Context, source string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.Open(source)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("unexpected status %q", resp.Status)
+	}
+	return &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}, nil
+}
+
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelReadCloser) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+func (o *iocOperatorInstance) load(ctx context.Context) (*Indicators, error) {
+	indicators := NewIndicators()
+	for _, source := range o.lists {
+		r, err := fetch(ctx, source)
+		if err != nil {
+			return nil, fmt.Errorf("fetching %q: %w", source, err)
+		}
+		err = indicators.Read(r, o.defaultSeverity)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %q: %w", source, err)
+		}
+	}
+	return indicators, nil
+}
+
+func severityRank(severity string) int {
+	return slices.Index(severities, severity)
+}
+
+func (o *iocOperatorInstance) match(m *dsMatcher, data datasource.Data) error {
+	indicators := o.indicators.Load()
+
+	var matchedIndicator, matchedSeverity string
+	for _, f := range m.fields {
+		var value string
+		if f.accessor.Type() == api.Kind_CString {
+			value = f.accessor.CStringUnsafe(data)
+		} else {
+			value = f.accessor.StringUnsafe(data)
+		}
+		indicator, severity, ok := indicators.Match(f.kind, value)
+		if !ok {
+			continue
+		}
+		if matchedIndicator == "" || severityRank(severity) > severityRank(matchedSeverity) {
+			matchedIndicator = indicator
+			matchedSeverity = severity
+		}
+	}
+	if matchedIndicator == "" {
+		return nil
+	}
+
+	if err := m.verdict.Set(data, []byte(VerdictMatch)); err != nil {
+		return err
+	}
+	if err := m.severity.Set(data, []byte(matchedSeverity)); err != nil {
+		return err
+	}
+	return m.indicator.Set(data, []byte(matchedIndicator))
+}
+
+func (o *iocOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error {
+	for ds, m := range o.matchers {
+		m := m
+		ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+			return o.match(m, data)
+		}, Priority)
+	}
+	return nil
+}
+
+func (o *iocOperatorInstance) refresh(gadgetCtx operators.GadgetContext) {
+	ticker := time.NewTicker(o.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-gadgetCtx.Context().Done():
+			return
+		case <-o.done:
+			return
+		case <-ticker.C:
+			indicators, err := o.load(gadgetCtx.Context())
+			if err != nil {
+				// Keep using the previous set of indicators
+				gadgetCtx.Logger().Warnf("ioc: refreshing indicators: %v", err)
+				continue
+			}
+			o.indicators.Store(indicators)
+			gadgetCtx.Logger().Debugf("ioc: refreshed %d indicators", indicators.Len())
+		}
+	}
+}
+
+func (o *iocOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	if o.refreshInterval > 0 && len(o.matchers) > 0 {
+		go o.refresh(gadgetCtx)
+	}
+	return nil
+}
+
+func (o *iocOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	o.doneOnce.Do(func() {
+		close(o.done)
+	})
+	return nil
+}
+
+func init() {
+	operators.RegisterDataOperator(&iocOperator{})
+}
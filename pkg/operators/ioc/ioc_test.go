@@ -0,0 +1,86 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ioc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+)
+
+func TestIocOperatorWithoutLists(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+	_, err = ds.AddField("name", datasource.WithKind(api.Kind_String))
+	require.NoError(t, err)
+
+	// The instance is returned anyway, so that the params of the operator are exposed
+	inst, err := (&iocOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{ParamFields: "name"})
+	require.NoError(t, err)
+	require.NotNil(t, inst)
+	require.Nil(t, ds.GetField("ioc"))
+}
+
+func TestIocOperator(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "iocs.txt")
+	require.NoError(t, os.WriteFile(list, []byte(testList), 0o644))
+
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+	name, err := ds.AddField("name", datasource.WithKind(api.Kind_String))
+	require.NoError(t, err)
+	_, err = ds.AddField("port", datasource.WithKind(api.Kind_Uint16))
+	require.NoError(t, err)
+
+	inst, err := (&iocOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{
+		ParamLists:           list,
+		ParamFields:          "name,port",
+		ParamRefreshInterval: "0",
+	})
+	require.NoError(t, err)
+	iocInst := inst.(*iocOperatorInstance)
+
+	// Only string fields are matched
+	m := iocInst.matchers[ds]
+	require.NotNil(t, m)
+	require.Len(t, m.fields, 1)
+	require.Equal(t, "name", m.fields[0].accessor.Name())
+
+	require.NoError(t, inst.(operators.PreStart).PreStart(gadgetCtx))
+	var verdicts, severities []string
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		verdicts = append(verdicts, m.verdict.String(data))
+		severities = append(severities, m.severity.String(data))
+		return nil
+	}, Priority+1)
+
+	for _, value := range []string{"www.bad.org", "example.com"} {
+		data := ds.NewData()
+		require.NoError(t, name.Set(data, []byte(value)))
+		require.NoError(t, ds.EmitAndRelease(data))
+	}
+	require.Equal(t, []string{VerdictMatch, ""}, verdicts)
+	require.Equal(t, []string{SeverityCritical, ""}, severities)
+}
@@ -0,0 +1,301 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kafka provides an operator that publishes the data emitted by gadgets as JSON messages to Kafka topics.
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource/formatters/json"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/filter"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	OperatorName = "kafka"
+
+	ParamBrokers      = "kafka-brokers"
+	ParamTopic        = "kafka-topic"
+	ParamKeyField     = "kafka-key-field"
+	ParamBatchSize    = "kafka-batch-size"
+	ParamBatchTimeout = "kafka-batch-timeout"
+	ParamTLS          = "kafka-tls"
+
+	// Priority is chosen so that only data that passed the filter operator is published
+	Priority = filter.Priority + 100
+)
+
+type kafkaOperator struct{}
+
+func (o *kafkaOperator) Name() string {
+	return OperatorName
+}
+
+func (o *kafkaOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *kafkaOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *kafkaOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:         ParamBrokers,
+			Title:       "Kafka brokers",
+			Description: "Comma-separated list of brokers to publish events to, e.g. kafka-0:9092,kafka-1:9092; events are only published if set",
+			TypeHint:    api.TypeString,
+		},
+		{
+			Key:         ParamTopic,
+			Title:       "Kafka topic",
+			Description: "Topic to publish events to; the name of the data source is used if empty",
+			TypeHint:    api.TypeString,
+		},
+		{
+			Key:         ParamKeyField,
+			Title:       "Kafka key field",
+			Description: "Field used as the key of the messages, so that events with the same value end up in the same partition; messages are distributed round-robin if empty",
+			TypeHint:    api.TypeString,
+		},
+		{
+			Key:          ParamBatchSize,
+			Title:        "Kafka batch size",
+			Description:  "Maximum number of messages sent to a partition at once",
+			DefaultValue: "100",
+			TypeHint:     api.TypeUint32,
+		},
+		{
+			Key:          ParamBatchTimeout,
+			Title:        "Kafka batch timeout",
+			Description:  "Maximum time to wait for a batch to fill up before sending it",
+			DefaultValue: "1s",
+			TypeHint:     api.TypeDuration,
+		},
+		{
+			Key:          ParamTLS,
+			Title:        "Kafka TLS",
+			Description:  "Use TLS when connecting to the brokers",
+			DefaultValue: "false",
+			TypeHint:     api.TypeBool,
+		},
+	}
+}
+
+// keyKinds are the kinds of fields that can be used as the key of messages
+var keyKinds = []api.Kind{
+	api.Kind_String, api.Kind_CString,
+	api.Kind_Int8, api.Kind_Int16, api.Kind_Int32, api.Kind_Int64,
+	api.Kind_Uint8, api.Kind_Uint16, api.Kind_Uint32, api.Kind_Uint64,
+}
+
+// keyString returns the value of f as text, to be used as key of a message
+func keyString(f datasource.FieldAccessor, data datasource.Data) string {
+	switch f.Type() {
+	case api.Kind_Int8:
+		return strconv.FormatInt(int64(f.Int8(data)), 10)
+	case api.Kind_Int16:
+		return strconv.FormatInt(int64(f.Int16(data)), 10)
+	case api.Kind_Int32:
+		return strconv.FormatInt(int64(f.Int32(data)), 10)
+	case api.Kind_Int64:
+		return strconv.FormatInt(f.Int64(data), 10)
+	case api.Kind_Uint8:
+		return strconv.FormatUint(uint64(f.Uint8(data)), 10)
+	case api.Kind_Uint16:
+		return strconv.FormatUint(uint64(f.Uint16(data)), 10)
+	case api.Kind_Uint32:
+		return strconv.FormatUint(uint64(f.Uint32(data)), 10)
+	case api.Kind_Uint64:
+		return strconv.FormatUint(f.Uint64(data), 10)
+	case api.Kind_CString:
+		return f.CString(data)
+	}
+	return f.String(data)
+}
+
+func (o *kafkaOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &kafkaOperatorInstance{
+		batchSize:    int(params.Get(ParamBatchSize).AsUint32()),
+		batchTimeout: params.Get(ParamBatchTimeout).AsDuration(),
+		tls:          params.Get(ParamTLS).AsBool(),
+		encoders:     make(map[datasource.DataSource]*encoder),
+	}
+	for _, broker := range strings.Split(params.Get(ParamBrokers).AsString(), ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			inst.brokers = append(inst.brokers, broker)
+		}
+	}
+	if len(inst.brokers) == 0 {
+		return inst, nil
+	}
+	if inst.batchSize == 0 || inst.batchTimeout <= 0 {
+		return nil, fmt.Errorf("%s and %s must be positive", ParamBatchSize, ParamBatchTimeout)
+	}
+
+	topic := params.Get(ParamTopic).AsString()
+	keyField := params.Get(ParamKeyField).AsString()
+	hasKey := false
+	for name, ds := range gadgetCtx.GetDataSources() {
+		formatter, err := json.New(ds, json.WithShowAll(true))
+		if err != nil {
+			return nil, fmt.Errorf("initializing JSON formatter for data source %q: %w", name, err)
+		}
+		enc := &encoder{topic: topic, formatter: formatter}
+		if enc.topic == "" {
+			enc.topic = name
+		}
+		if keyField != "" {
+			// Data sources without the field are published without key
+			enc.key = ds.GetField(keyField)
+			if enc.key != nil && !slices.Contains(keyKinds, enc.key.Type()) {
+				return nil, fmt.Errorf("field %q of data source %q can't be used as key: unsupported kind %s",
+					keyField, name, enc.key.Type())
+			}
+			hasKey = hasKey || enc.key != nil
+		}
+		inst.encoders[ds] = enc
+	}
+	if keyField != "" && !hasKey {
+		return nil, fmt.Errorf("key field %q not found in any data source", keyField)
+	}
+	return inst, nil
+}
+
+func (o *kafkaOperator) Priority() int {
+	return Priority
+}
+
+// encoder turns the data of a single data source into messages
+type encoder struct {
+	topic     string
+	key       datasource.FieldAccessor
+	formatter *json.Formatter
+}
+
+func (e *encoder) message(data datasource.Data) kafka.Message {
+	msg := kafka.Message{
+		Topic: e.topic,
+		// The buffer of the formatter is reused, but messages are sent asynchronously
+		Value: bytes.Clone(e.formatter.Marshal(data)),
+	}
+	if e.key != nil {
+		msg.Key = []byte(keyString(e.key, data))
+	}
+	return msg
+}
+
+// messageWriter is implemented by *kafka.Writer
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+type kafkaOperatorInstance struct {
+	brokers      []string
+	batchSize    int
+	batchTimeout time.Duration
+	tls          bool
+	encoders     map[datasource.DataSource]*encoder
+
+	writer  messageWriter
+	dropped atomic.Uint64
+}
+
+func (o *kafkaOperatorInstance) Name() string {
+	return OperatorName
+}
+
+// newWriter returns a writer sending messages in the background, so that slow or unavailable brokers don't slow down
+// the gadget; messages that can't be delivered are dropped
+func (o *kafkaOperatorInstance) newWriter(logger logger.Logger) *kafka.Writer {
+	w := &kafka.Writer{
+		Addr: kafka.TCP(o.brokers...),
+		// Messages with the same key go to the same partition, the ones without key are distributed round-robin
+		Balancer:     &kafka.Hash{},
+		BatchSize:    o.batchSize,
+		BatchTimeout: o.batchTimeout,
+		Async:        true,
+		Completion: func(messages []kafka.Message, err error) {
+			if err == nil {
+				return
+			}
+			dropped := o.dropped.Add(uint64(len(messages)))
+			logger.Warnf("kafka: publishing %d messages: %v (%d dropped in total)", len(messages), err, dropped)
+		},
+	}
+	if o.tls {
+		w.Transport = &kafka.Transport{TLS: &tls.Config{}}
+	}
+	return w
+}
+
+func (o *kafkaOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error {
+	if len(o.encoders) == 0 {
+		return nil
+	}
+	if o.writer == nil {
+		o.writer = o.newWriter(gadgetCtx.Logger())
+	}
+
+	for ds, enc := range o.encoders {
+		gadgetCtx.Logger().Debugf("publishing data source %q to topic %q", ds.Name(), enc.topic)
+		enc := enc
+		ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+			// Errors are reported using the completion of the writer
+			o.writer.WriteMessages(gadgetCtx.Context(), enc.message(data))
+			return nil
+		}, Priority)
+	}
+	return nil
+}
+
+func (o *kafkaOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+func (o *kafkaOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	if o.writer == nil {
+		return nil
+	}
+	// Closing flushes the pending messages
+	return o.writer.Close()
+}
+
+func init() {
+	operators.RegisterDataOperator(&kafkaOperator{})
+}
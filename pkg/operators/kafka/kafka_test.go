@@ -0,0 +1,130 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+)
+
+type fakeWriter struct {
+	messages []kafka.Message
+	closed   bool
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestKafkaOperator(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+	comm, err := ds.AddField("comm", datasource.WithKind(api.Kind_String))
+	require.NoError(t, err)
+	pid, err := ds.AddField("pid", datasource.WithKind(api.Kind_Uint32))
+	require.NoError(t, err)
+
+	inst, err := (&kafkaOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{
+		ParamBrokers:  "kafka-0:9092, kafka-1:9092",
+		ParamKeyField: "pid",
+	})
+	require.NoError(t, err)
+	kafkaInst := inst.(*kafkaOperatorInstance)
+	require.Equal(t, []string{"kafka-0:9092", "kafka-1:9092"}, kafkaInst.brokers)
+	require.Equal(t, 100, kafkaInst.batchSize)
+	require.Equal(t, time.Second, kafkaInst.batchTimeout)
+
+	w := &fakeWriter{}
+	kafkaInst.writer = w
+	require.NoError(t, inst.(operators.PreStart).PreStart(gadgetCtx))
+	require.NoError(t, inst.Start(gadgetCtx))
+
+	for _, ev := range []struct {
+		comm string
+		pid  uint32
+	}{{"cat", 1234}, {"ls", 5}} {
+		data := ds.NewData()
+		require.NoError(t, comm.Set(data, []byte(ev.comm)))
+		require.NoError(t, pid.Set(data, make([]byte, 4)))
+		pid.PutUint32(data, ev.pid)
+		require.NoError(t, ds.EmitAndRelease(data))
+	}
+	require.NoError(t, inst.Stop(gadgetCtx))
+	require.True(t, w.closed)
+
+	require.Len(t, w.messages, 2)
+	require.Equal(t, "events", w.messages[0].Topic)
+	require.Equal(t, "1234", string(w.messages[0].Key))
+	require.JSONEq(t, `{"comm":"cat","pid":1234}`, string(w.messages[0].Value))
+	require.Equal(t, "5", string(w.messages[1].Key))
+	require.JSONEq(t, `{"comm":"ls","pid":5}`, string(w.messages[1].Value))
+}
+
+func TestKafkaOperatorParams(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+	_, err = ds.AddField("comm", datasource.WithKind(api.Kind_String))
+	require.NoError(t, err)
+	_, err = ds.AddField("ratio", datasource.WithKind(api.Kind_Float64))
+	require.NoError(t, err)
+
+	// Nothing is published without brokers
+	inst, err := (&kafkaOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+	require.NoError(t, err)
+	require.NoError(t, inst.(operators.PreStart).PreStart(gadgetCtx))
+	require.NoError(t, inst.Stop(gadgetCtx))
+
+	inst, err = (&kafkaOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{
+		ParamBrokers: "localhost:9092",
+		ParamTopic:   "gadgets",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "gadgets", inst.(*kafkaOperatorInstance).encoders[ds].topic)
+
+	_, err = (&kafkaOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{
+		ParamBrokers:  "localhost:9092",
+		ParamKeyField: "missing",
+	})
+	require.ErrorContains(t, err, "not found")
+
+	_, err = (&kafkaOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{
+		ParamBrokers:  "localhost:9092",
+		ParamKeyField: "ratio",
+	})
+	require.ErrorContains(t, err, "can't be used as key")
+
+	_, err = (&kafkaOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{
+		ParamBrokers:   "localhost:9092",
+		ParamBatchSize: "0",
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,297 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kmsg provides an operator that emits the messages of the kernel log (dmesg) on a data source of their own
+// while a gadget is running, so that kernel warnings caused by traced workloads show up next to the events of the
+// gadget. The messages carry a timestamp of the same clock as the events of eBPF programs.
+package kmsg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/formatters"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	OperatorName = "kmsg"
+
+	ParamEnable  = "kmsg"
+	ParamLevel   = "kmsg-level"
+	ParamHistory = "kmsg-history"
+
+	// DataSourceName is the name of the data source the messages are emitted on
+	DataSourceName = "kmsg"
+
+	// Priority makes sure the data source is registered before the formatters operator converts the timestamps and
+	// before sinks subscribe to the data sources
+	Priority = -500
+
+	// maxRecordSize is the maximum size of a record returned by /dev/kmsg
+	maxRecordSize = 8192
+)
+
+// kmsgPath is where the kernel log is read from
+var kmsgPath = "/dev/kmsg"
+
+type kmsgOperator struct{}
+
+func (o *kmsgOperator) Name() string {
+	return OperatorName
+}
+
+func (o *kmsgOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *kmsgOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *kmsgOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:          ParamEnable,
+			Title:        "Kernel log",
+			Description:  "Emit the messages of the kernel log (dmesg) on the data source " + DataSourceName,
+			DefaultValue: "false",
+			TypeHint:     api.TypeBool,
+		},
+		{
+			Key:            ParamLevel,
+			Title:          "Kernel log level",
+			Description:    "Least severe level of the kernel messages to emit",
+			DefaultValue:   "warning",
+			PossibleValues: levels,
+			TypeHint:       api.TypeString,
+		},
+		{
+			Key:          ParamHistory,
+			Title:        "Kernel log history",
+			Description:  "Also emit the messages logged before the gadget was started that are still in the kernel log",
+			DefaultValue: "false",
+			TypeHint:     api.TypeBool,
+		},
+	}
+}
+
+func (o *kmsgOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &kmsgOperatorInstance{}
+	if !params.Get(ParamEnable).AsBool() {
+		return inst, nil
+	}
+
+	inst.maxLevel, err = parseLevel(params.Get(ParamLevel).AsString())
+	if err != nil {
+		return nil, err
+	}
+	inst.history = params.Get(ParamHistory).AsBool()
+
+	inst.ds, err = gadgetCtx.RegisterDataSource(datasource.TypeEvent, DataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("adding data source %q: %w", DataSourceName, err)
+	}
+	if err := inst.addFields(); err != nil {
+		return nil, fmt.Errorf("adding fields to data source %q: %w", DataSourceName, err)
+	}
+	return inst, nil
+}
+
+func (o *kmsgOperator) Priority() int {
+	return Priority
+}
+
+type kmsgOperatorInstance struct {
+	// ds is nil if the operator isn't enabled
+	ds       datasource.DataSource
+	maxLevel uint8
+	history  bool
+
+	timestamp datasource.FieldAccessor
+	level     datasource.FieldAccessor
+	facility  datasource.FieldAccessor
+	subsystem datasource.FieldAccessor
+	device    datasource.FieldAccessor
+	message   datasource.FieldAccessor
+	seq       datasource.FieldAccessor
+
+	// bootOffset converts the timestamps of the kernel log to the clock used by eBPF programs
+	bootOffset uint64
+
+	file *os.File
+	wg   sync.WaitGroup
+}
+
+func (o *kmsgOperatorInstance) addFields() error {
+	var err error
+	if o.timestamp, err = o.ds.AddField("timestamp", datasource.WithKind(api.Kind_Uint64),
+		datasource.WithTags("type:"+formatters.TimestampTypeName)); err != nil {
+		return err
+	}
+	stringField := func(name, description string, flags datasource.FieldFlag) (datasource.FieldAccessor, error) {
+		return o.ds.AddField(name, datasource.WithKind(api.Kind_String), datasource.WithFlags(flags),
+			datasource.WithAnnotations(map[string]string{"description": description}))
+	}
+	if o.level, err = stringField("level", "Log level of the message", 0); err != nil {
+		return err
+	}
+	if o.facility, err = stringField("facility", "Syslog facility of the message", datasource.FieldFlagHidden); err != nil {
+		return err
+	}
+	if o.subsystem, err = stringField("subsystem", "Subsystem or driver that logged the message", 0); err != nil {
+		return err
+	}
+	if o.device, err = stringField("device", "Device the message is about", datasource.FieldFlagHidden); err != nil {
+		return err
+	}
+	if o.message, err = stringField("message", "Message", 0); err != nil {
+		return err
+	}
+	o.seq, err = o.ds.AddField("seq", datasource.WithKind(api.Kind_Uint64), datasource.WithFlags(datasource.FieldFlagHidden),
+		datasource.WithAnnotations(map[string]string{"description": "Sequence number of the message in the kernel log"}))
+	return err
+}
+
+func (o *kmsgOperatorInstance) Name() string {
+	return OperatorName
+}
+
+func (o *kmsgOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	if o.ds == nil {
+		return nil
+	}
+
+	// Opened non-blocking, so that reading is interrupted when the file is closed
+	f, err := os.OpenFile(kmsgPath, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return fmt.Errorf("opening kernel log: %w", err)
+	}
+	if !o.history {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return fmt.Errorf("skipping messages of the kernel log: %w", err)
+		}
+	}
+	o.bootOffset = bootOffset()
+	o.file = f
+
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		if err := o.read(gadgetCtx, f); err != nil {
+			gadgetCtx.Logger().Errorf("reading kernel log: %v", err)
+		}
+	}()
+	return nil
+}
+
+// bootOffset returns the time the system was suspended: the kernel log uses a clock that doesn't advance while the
+// system is suspended, while eBPF programs use one that does
+func bootOffset() uint64 {
+	var boot, mono unix.Timespec
+	if unix.ClockGettime(unix.CLOCK_BOOTTIME, &boot) != nil || unix.ClockGettime(unix.CLOCK_MONOTONIC, &mono) != nil {
+		return 0
+	}
+	return uint64(max(boot.Nano()-mono.Nano(), 0))
+}
+
+// read emits the messages read from r until it's closed
+func (o *kmsgOperatorInstance) read(gadgetCtx operators.GadgetContext, r io.Reader) error {
+	buf := make([]byte, maxRecordSize)
+	for {
+		n, err := r.Read(buf)
+		switch {
+		case errors.Is(err, syscall.EPIPE):
+			// Messages were overwritten before they could be read; reading continues with the oldest one left
+			gadgetCtx.Logger().Debugf("messages of the kernel log were lost")
+			continue
+		case errors.Is(err, io.EOF), errors.Is(err, os.ErrClosed):
+			return nil
+		case err != nil:
+			return err
+		}
+
+		records, err := parseRecords(buf[:n])
+		if err != nil {
+			gadgetCtx.Logger().Debugf("parsing kernel log: %v", err)
+		}
+		for _, r := range records {
+			if r.level > o.maxLevel {
+				continue
+			}
+			if err := o.emit(r); err != nil {
+				gadgetCtx.Logger().Warnf("emitting kernel message: %v", err)
+			}
+		}
+	}
+}
+
+func (o *kmsgOperatorInstance) emit(r *record) error {
+	data := o.ds.NewData()
+	bo := o.ds.ByteOrder()
+	ts := make([]byte, 8)
+	bo.PutUint64(ts, r.usec*1000+o.bootOffset)
+	seq := make([]byte, 8)
+	bo.PutUint64(seq, r.seq)
+
+	for _, f := range []struct {
+		acc   datasource.FieldAccessor
+		value []byte
+	}{
+		{o.timestamp, ts},
+		{o.level, []byte(levelName(r.level))},
+		{o.facility, []byte(facilityName(r.facility))},
+		{o.subsystem, []byte(r.subsystem)},
+		{o.device, []byte(r.device)},
+		{o.message, []byte(r.message)},
+		{o.seq, seq},
+	} {
+		if err := f.acc.Set(data, f.value); err != nil {
+			o.ds.Release(data)
+			return fmt.Errorf("setting field %q: %w", f.acc.Name(), err)
+		}
+	}
+	return o.ds.EmitAndRelease(data)
+}
+
+func (o *kmsgOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	if o.file == nil {
+		return nil
+	}
+	o.file.Close()
+	o.wg.Wait()
+	o.file = nil
+	return nil
+}
+
+func init() {
+	operators.RegisterDataOperator(&kmsgOperator{})
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kmsg
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+func TestParseRecords(t *testing.T) {
+	records, err := parseRecords([]byte("4,1234,5678901,-;EXT4-fs (sda1): warning: mounting fs with errors\n" +
+		" SUBSYSTEM=block\n" +
+		" DEVICE=b8:1\n" +
+		"30,1235,5678902,c;systemd[1]: tab\\x09separated\n" +
+		"3,1236,5678903,-;Out of memory: Killed process 1234 (stress)\n" +
+		"6,1237,5678904,-;oom-kill:constraint=CONSTRAINT_NONE,task=stress\n"))
+	require.NoError(t, err)
+	require.Equal(t, []*record{
+		{level: 4, facility: 0, seq: 1234, usec: 5678901, subsystem: "block", device: "b8:1", message: "EXT4-fs (sda1): warning: mounting fs with errors"},
+		{level: 6, facility: 3, seq: 1235, usec: 5678902, subsystem: "systemd[1]", message: "systemd[1]: tab\tseparated"},
+		{level: 3, facility: 0, seq: 1236, usec: 5678903, message: "Out of memory: Killed process 1234 (stress)"},
+		{level: 6, facility: 0, seq: 1237, usec: 5678904, subsystem: "oom-kill", message: "oom-kill:constraint=CONSTRAINT_NONE,task=stress"},
+	}, records)
+
+	require.Equal(t, "warning", levelName(4))
+	require.Equal(t, "daemon", facilityName(3))
+
+	_, err = parseRecords([]byte("invalid record\n"))
+	require.Error(t, err)
+	_, err = parseRecords([]byte("x,1,2,-;message\n"))
+	require.Error(t, err)
+}
+
+func TestKmsgOperator(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	op := &kmsgOperator{}
+
+	// Disabled by default
+	inst, err := op.InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+	require.NoError(t, err)
+	require.Nil(t, inst.(*kmsgOperatorInstance).ds)
+	require.Empty(t, gadgetCtx.GetDataSources())
+
+	_, err = op.InstantiateDataOperator(gadgetCtx, api.ParamValues{ParamEnable: "true", ParamLevel: "verbose"})
+	require.Error(t, err)
+
+	inst, err = op.InstantiateDataOperator(gadgetCtx, api.ParamValues{ParamEnable: "true", ParamLevel: "err"})
+	require.NoError(t, err)
+	ds := gadgetCtx.GetDataSources()[DataSourceName]
+	require.NotNil(t, ds)
+	require.Len(t, ds.GetFieldsWithTag("type:gadget_timestamp"), 1)
+
+	timestamp := ds.GetField("timestamp")
+	level := ds.GetField("level")
+	subsystem := ds.GetField("subsystem")
+	message := ds.GetField("message")
+	var messages []string
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		require.Equal(t, uint64(2000000000), timestamp.Uint64(data))
+		messages = append(messages, level.String(data)+" "+subsystem.String(data)+" "+message.String(data))
+		return nil
+	}, 0)
+
+	// Only messages at least as severe as the configured level are emitted
+	r := strings.NewReader("6,1,2000000,-;usb 1-1: new device\n" +
+		"3,2,2000000,-;nvme nvme0: I/O timeout\n" +
+		"0,3,2000000,-;Kernel panic - not syncing: test\n")
+	require.NoError(t, inst.(*kmsgOperatorInstance).read(gadgetCtx, r))
+	require.Equal(t, []string{
+		"err nvme nvme nvme0: I/O timeout",
+		"emerg  Kernel panic - not syncing: test",
+	}, messages)
+}
@@ -0,0 +1,171 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kmsg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// levels are the names of the log levels of the kernel, indexed by their value
+var levels = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// facilities are the names of the syslog facilities, indexed by their value
+var facilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// maxSubsystemPrefix limits how far into a message a "subsystem: " prefix is looked for
+const maxSubsystemPrefix = 32
+
+// record is an entry of the kernel ring buffer as read from /dev/kmsg, see
+// https://www.kernel.org/doc/Documentation/ABI/testing/dev-kmsg
+type record struct {
+	level     uint8
+	facility  uint8
+	seq       uint64
+	usec      uint64
+	subsystem string
+	device    string
+	message   string
+}
+
+func levelName(level uint8) string {
+	if int(level) < len(levels) {
+		return levels[level]
+	}
+	return strconv.Itoa(int(level))
+}
+
+func facilityName(facility uint8) string {
+	if int(facility) < len(facilities) {
+		return facilities[facility]
+	}
+	return strconv.Itoa(int(facility))
+}
+
+// parseLevel returns the value of the log level called name
+func parseLevel(name string) (uint8, error) {
+	for i, level := range levels {
+		if level == name {
+			return uint8(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid level %q, expected one of %s", name, strings.Join(levels, ", "))
+}
+
+// parseRecords parses the records in buf: each one consists of "prio,seq,usec,flags;message" and optional
+// continuation lines starting with a space holding "KEY=value" pairs. /dev/kmsg returns a single record per read.
+func parseRecords(buf []byte) ([]*record, error) {
+	var records []*record
+	var cur *record
+	for _, line := range strings.Split(strings.TrimRight(string(buf), "\n"), "\n") {
+		if strings.HasPrefix(line, " ") {
+			key, value, _ := strings.Cut(line[1:], "=")
+			if cur == nil {
+				continue
+			}
+			switch key {
+			case "SUBSYSTEM":
+				cur.subsystem = value
+			case "DEVICE":
+				cur.device = value
+			}
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		r, err := parseRecord(line)
+		if err != nil {
+			return records, err
+		}
+		records = append(records, r)
+		cur = r
+	}
+	for _, r := range records {
+		if r.subsystem == "" {
+			r.subsystem = messageSubsystem(r.message)
+		}
+	}
+	return records, nil
+}
+
+func parseRecord(line string) (*record, error) {
+	header, message, ok := strings.Cut(line, ";")
+	if !ok {
+		return nil, fmt.Errorf("invalid record %q: no message", line)
+	}
+	fields := strings.Split(header, ",")
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("invalid record header %q", header)
+	}
+	prio, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid priority in %q: %w", header, err)
+	}
+	seq, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sequence number in %q: %w", header, err)
+	}
+	usec, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp in %q: %w", header, err)
+	}
+	return &record{
+		level:    uint8(prio & 7),
+		facility: uint8(prio >> 3),
+		seq:      seq,
+		usec:     usec,
+		message:  unescape(message),
+	}, nil
+}
+
+// unescape replaces the \xXX escapes the kernel uses for non-printable characters
+func unescape(s string) string {
+	if !strings.Contains(s, `\x`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if v, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// messageSubsystem returns the subsystem a message is prefixed with by convention, like "EXT4-fs" in
+// "EXT4-fs (sda1): mounted filesystem" or "oom-kill" in "oom-kill:constraint=CONSTRAINT_NONE,..."
+func messageSubsystem(message string) string {
+	i := strings.IndexByte(message, ':')
+	if i <= 0 || i > maxSubsystemPrefix {
+		return ""
+	}
+	prefix := strings.Fields(message[:i])
+	// Longer prefixes are usually part of a sentence, like "Out of memory: Killed process"
+	if len(prefix) == 0 || len(prefix) > 2 {
+		return ""
+	}
+	return prefix[0]
+}
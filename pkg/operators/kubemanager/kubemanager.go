@@ -31,6 +31,7 @@ import (
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadgettracermanager"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/common"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/types"
 )
@@ -42,6 +43,8 @@ const (
 	ParamAllNamespaces = "all-namespaces"
 	ParamPodName       = "podname"
 	ParamNamespace     = "namespace"
+	ParamCapabilities  = "capabilities"
+	ParamContainerLogs = "container-logs"
 )
 
 type MountNsMapSetter interface {
@@ -125,6 +128,24 @@ func (k *KubeManager) ParamDescs() params.ParamDescs {
 	}
 }
 
+// dataParamDescs returns the parameters of image-based gadgets
+func (k *KubeManager) dataParamDescs() params.ParamDescs {
+	return append(k.ParamDescs(),
+		&params.ParamDesc{
+			Key:          ParamCapabilities,
+			Description:  "Emit whether the events of the containers can be traced on the data source " + common.CapabilitiesDataSourceName,
+			DefaultValue: "false",
+			TypeHint:     params.TypeBool,
+		},
+		&params.ParamDesc{
+			Key:          ParamContainerLogs,
+			Description:  "Emit the lines the containers write to stdout and stderr on the data source " + common.ContainerLogsDataSourceName,
+			DefaultValue: "false",
+			TypeHint:     params.TypeBool,
+		},
+	)
+}
+
 func (k *KubeManager) Dependencies() []string {
 	return nil
 }
@@ -198,6 +219,12 @@ type KubeManagerInstance struct {
 	gadgetCtx          operators.GadgetContext
 
 	eventWrappers map[datasource.DataSource]*compat.EventWrapperBase
+	ownerCache    common.K8sOwnerCache
+
+	containerSelector containercollection.ContainerSelector
+	capabilities      *common.ContainerCapabilities
+	containerLogs     *common.ContainerLogs
+	containersKey     string
 }
 
 func (m *KubeManagerInstance) Name() string {
@@ -348,13 +375,13 @@ func (k *KubeManager) GlobalParams() api.Params {
 }
 
 func (k *KubeManager) InstanceParams() api.Params {
-	return apihelpers.ParamDescsToParams(k.ParamDescs())
+	return apihelpers.ParamDescsToParams(k.dataParamDescs())
 }
 
 func (k *KubeManager) InstantiateDataOperator(gadgetCtx operators.GadgetContext, paramValues api.ParamValues) (
 	operators.DataOperatorInstance, error,
 ) {
-	params := k.ParamDescs().ToParams()
+	params := k.dataParamDescs().ToParams()
 	err := params.CopyFromMap(paramValues, "")
 	if err != nil {
 		return nil, err
@@ -371,12 +398,9 @@ func (k *KubeManager) InstantiateDataOperator(gadgetCtx operators.GadgetContext,
 		eventWrappers: make(map[datasource.DataSource]*compat.EventWrapperBase),
 	}
 
-	// hack - this makes it possible to use the Attacher interface
-	var ok bool
-	traceInstance.gadgetInstance, ok = gadgetCtx.GetVar("ebpfInstance")
-	if !ok {
-		return nil, fmt.Errorf("getting ebpfInstance")
-	}
+	// hack - this makes it possible to use the Attacher interface; gadgets that don't use eBPF, like the fallback
+	// gadgets, don't provide an instance
+	traceInstance.gadgetInstance, _ = gadgetCtx.GetVar("ebpfInstance")
 
 	activate := false
 
@@ -403,12 +427,36 @@ func (k *KubeManager) InstantiateDataOperator(gadgetCtx operators.GadgetContext,
 	traceInstance.eventWrappers = wrappers
 	if len(wrappers) > 0 {
 		activate = true
+
+		// The owner of pods is only additional information, so don't fail if it's not available
+		ownerCache, err := common.GetK8sOwnerCache()
+		if err != nil {
+			gadgetCtx.Logger().Warnf("creating k8s owner cache: %v", err)
+		} else {
+			for _, wrapper := range wrappers {
+				if err := wrapper.SetOwnerResolver(ownerCache.GetPodOwner); err != nil {
+					return nil, fmt.Errorf("setting owner resolver: %w", err)
+				}
+			}
+			traceInstance.ownerCache = ownerCache
+		}
 	}
 
 	if !activate {
 		return nil, nil
 	}
 
+	traceInstance.capabilities, err = common.NewContainerCapabilities(gadgetCtx, params.Get(ParamCapabilities).AsBool(), true)
+	if err != nil {
+		return nil, err
+	}
+	if params.Get(ParamContainerLogs).AsBool() {
+		traceInstance.containerLogs, err = common.NewContainerLogs(gadgetCtx, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return traceInstance, nil
 }
 
@@ -417,7 +465,7 @@ func (k *KubeManager) Priority() int {
 }
 
 func (m *KubeManagerInstance) ParamDescs(gadgetCtx operators.GadgetContext) params.ParamDescs {
-	return m.manager.ParamDescs()
+	return m.manager.dataParamDescs()
 }
 
 func (m *KubeManagerInstance) PreStart(gadgetCtx operators.GadgetContext) error {
@@ -446,6 +494,8 @@ func (m *KubeManagerInstance) PreStart(gadgetCtx operators.GadgetContext) error
 		},
 	}
 
+	m.containerSelector = containerSelector
+
 	if m.manager.gadgetTracerManager == nil {
 		return fmt.Errorf("container-collection isn't available")
 	}
@@ -467,16 +517,57 @@ func (m *KubeManagerInstance) PreStart(gadgetCtx operators.GadgetContext) error
 	gadgetCtx.SetVar(gadgets.FilterByMntNsName, true)
 
 	m.mountnsmap = mountnsmap
+
+	if m.ownerCache != nil {
+		m.ownerCache.Start()
+	}
+
 	// using PreGadgetRun() for the time being to register attacher funcs
 	return m.PreGadgetRun()
 }
 
 func (m *KubeManagerInstance) Start(gadgetCtx operators.GadgetContext) error {
+	// Containers are reported once running, so that they can be emitted
+	add := func(container *containercollection.Container) {
+		if err := m.capabilities.Report(container); err != nil {
+			gadgetCtx.Logger().Warnf("reporting capabilities of container %q: %v", container.K8s.ContainerName, err)
+		}
+		if m.containerLogs != nil {
+			m.containerLogs.Add(container)
+		}
+	}
+	m.containersKey = m.id + "/containers"
+	containers := m.manager.gadgetTracerManager.Subscribe(
+		m.containersKey,
+		m.containerSelector,
+		func(event containercollection.PubSubEvent) {
+			switch event.Type {
+			case containercollection.EventTypeAddContainer:
+				add(event.Container)
+			case containercollection.EventTypeRemoveContainer:
+				if m.containerLogs != nil {
+					m.containerLogs.Remove(event.Container)
+				}
+			}
+		},
+	)
+	for _, container := range containers {
+		add(container)
+	}
 	return nil
 }
 
 func (m *KubeManagerInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	if m.containersKey != "" {
+		m.manager.gadgetTracerManager.Unsubscribe(m.containersKey)
+	}
+	if m.containerLogs != nil {
+		m.containerLogs.Close()
+	}
 	m.manager.gadgetTracerManager.RemoveTracer(m.id)
+	if m.ownerCache != nil {
+		m.ownerCache.Stop()
+	}
 	return nil
 }
 
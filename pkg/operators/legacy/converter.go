@@ -0,0 +1,254 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package legacy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/columns"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource/compat"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/parser"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/types"
+)
+
+// skippedColumnTags are the tags of the columns of types.CommonData; their values are emitted using the standard
+// fields of the container metadata instead, see compat.CommonFields
+var skippedColumnTags = []string{"kubernetes", "runtime"}
+
+// nsColumnTypes tags the namespace columns so that operators can enrich the events
+var nsColumnTypes = map[string]string{
+	"mntns": compat.MntNsIdType,
+	"netns": compat.NetNsIdType,
+}
+
+type convertedField struct {
+	acc  datasource.FieldAccessor
+	kind api.Kind
+	get  func(any) reflect.Value
+}
+
+// converter emits the events of a built-in gadget to a data source with a field per column
+type converter struct {
+	gadgetCtx operators.GadgetContext
+	ds        datasource.DataSource
+	fields    []convertedField
+	common    *compat.EventWrapperBase
+
+	// totals and maxRows are set for top gadgets emitting totals: the gadget then hands over all rows of an
+	// interval, of which only maxRows are emitted, while the totals are computed over all of them
+	totals  *totals
+	maxRows int
+}
+
+func newConverter(gadgetCtx operators.GadgetContext, name string, p parser.Parser) (*converter, error) {
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, name)
+	if err != nil {
+		return nil, fmt.Errorf("adding datasource %q: %w", name, err)
+	}
+
+	c := &converter{gadgetCtx: gadgetCtx, ds: ds}
+	for _, col := range p.ColumnGetters() {
+		if slices.ContainsFunc(col.Tags, func(tag string) bool { return slices.Contains(skippedColumnTags, tag) }) {
+			continue
+		}
+
+		kind := apiKind(col.Kind)
+		opts := []datasource.FieldOption{
+			datasource.WithKind(kind),
+			datasource.WithAnnotations(columnAnnotations(col.Attributes)),
+		}
+		if t, ok := nsColumnTypes[col.Name]; ok && kind == api.Kind_Uint64 {
+			opts = append(opts, datasource.WithTags(t))
+		}
+		if !col.Visible {
+			opts = append(opts, datasource.WithFlags(datasource.FieldFlagHidden))
+		}
+		acc, err := ds.AddField(col.Name, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("adding field %q: %w", col.Name, err)
+		}
+		c.fields = append(c.fields, convertedField{acc: acc, kind: kind, get: col.Get})
+	}
+
+	c.common, err = compat.WrapAccessors(ds, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("adding container metadata fields: %w", err)
+	}
+	return c, nil
+}
+
+// apiKind returns the kind of the field holding values of kind k; kinds without a matching field kind are
+// converted to strings
+func apiKind(k reflect.Kind) api.Kind {
+	switch k {
+	case reflect.Bool:
+		return api.Kind_Bool
+	case reflect.Int8:
+		return api.Kind_Int8
+	case reflect.Int16:
+		return api.Kind_Int16
+	case reflect.Int32:
+		return api.Kind_Int32
+	case reflect.Int, reflect.Int64:
+		return api.Kind_Int64
+	case reflect.Uint8:
+		return api.Kind_Uint8
+	case reflect.Uint16:
+		return api.Kind_Uint16
+	case reflect.Uint32:
+		return api.Kind_Uint32
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return api.Kind_Uint64
+	case reflect.Float32:
+		return api.Kind_Float32
+	case reflect.Float64:
+		return api.Kind_Float64
+	}
+	return api.Kind_String
+}
+
+func columnAnnotations(attrs columns.Attributes) map[string]string {
+	annotations := map[string]string{}
+	if attrs.Description != "" {
+		annotations["description"] = attrs.Description
+	}
+	if attrs.Width > 0 {
+		annotations["columns.width"] = fmt.Sprintf("%d", attrs.Width)
+	}
+	if attrs.MinWidth > 0 {
+		annotations["columns.minWidth"] = fmt.Sprintf("%d", attrs.MinWidth)
+	}
+	if attrs.MaxWidth > 0 {
+		annotations["columns.maxWidth"] = fmt.Sprintf("%d", attrs.MaxWidth)
+	}
+	if attrs.Alignment == columns.AlignRight {
+		annotations["columns.alignment"] = "right"
+	}
+	return annotations
+}
+
+// handle is the event callback of the parser of the built-in gadget; top gadgets hand over arrays of events
+func (c *converter) handle(ev any) {
+	v := reflect.ValueOf(ev)
+	if v.Kind() != reflect.Slice {
+		c.emit(ev)
+		return
+	}
+	if c.totals == nil {
+		for i := 0; i < v.Len(); i++ {
+			c.emit(v.Index(i).Interface())
+		}
+		return
+	}
+
+	rows := make([]any, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		ev := v.Index(i).Interface()
+		if logEvent(c.gadgetCtx, ev) {
+			continue
+		}
+		rows = append(rows, ev)
+	}
+	// The gadget sorts the rows before handing them over
+	n := len(rows)
+	if c.maxRows > 0 && n > c.maxRows {
+		n = c.maxRows
+	}
+	for _, ev := range rows[:n] {
+		c.emit(ev)
+	}
+	c.totals.emit(rows)
+}
+
+func (c *converter) emit(ev any) {
+	if logEvent(c.gadgetCtx, ev) {
+		return
+	}
+	data := c.ds.NewData()
+	for _, f := range c.fields {
+		if err := f.acc.Set(data, encode(f.kind, c.ds.ByteOrder(), f.get(ev))); err != nil {
+			c.ds.Release(data)
+			c.gadgetCtx.Logger().Warnf("setting field %q: %v", f.acc.Name(), err)
+			return
+		}
+	}
+	// Metadata set by the gadget itself; operators enriching the events by their namespaces might overwrite it
+	if base, ok := ev.(interface{ GetBaseEvent() *types.Event }); ok {
+		wr := &compat.EventWrapper{EventWrapperBase: c.common, Data: data}
+		wr.SetCommonData(&base.GetBaseEvent().CommonData)
+	}
+	if err := c.ds.EmitAndRelease(data); err != nil {
+		c.gadgetCtx.Logger().Warnf("emitting event: %v", err)
+	}
+}
+
+// encode returns the representation of v in a field of the given kind
+func encode(kind api.Kind, bo binary.ByteOrder, v reflect.Value) []byte {
+	if !v.IsValid() {
+		return nil
+	}
+	switch kind {
+	case api.Kind_String:
+		if v.Kind() == reflect.String {
+			return []byte(v.String())
+		}
+		return []byte(fmt.Sprint(v.Interface()))
+	case api.Kind_Bool:
+		if v.Bool() {
+			return []byte{1}
+		}
+		return []byte{0}
+	case api.Kind_Int8:
+		return []byte{uint8(v.Int())}
+	case api.Kind_Uint8:
+		return []byte{uint8(v.Uint())}
+	}
+
+	var b []byte
+	switch kind {
+	case api.Kind_Int16:
+		b = make([]byte, 2)
+		bo.PutUint16(b, uint16(v.Int()))
+	case api.Kind_Uint16:
+		b = make([]byte, 2)
+		bo.PutUint16(b, uint16(v.Uint()))
+	case api.Kind_Int32:
+		b = make([]byte, 4)
+		bo.PutUint32(b, uint32(v.Int()))
+	case api.Kind_Uint32:
+		b = make([]byte, 4)
+		bo.PutUint32(b, uint32(v.Uint()))
+	case api.Kind_Float32:
+		b = make([]byte, 4)
+		bo.PutUint32(b, math.Float32bits(float32(v.Float())))
+	case api.Kind_Int64:
+		b = make([]byte, 8)
+		bo.PutUint64(b, uint64(v.Int()))
+	case api.Kind_Uint64:
+		b = make([]byte, 8)
+		bo.PutUint64(b, v.Uint())
+	case api.Kind_Float64:
+		b = make([]byte, 8)
+		bo.PutUint64(b, math.Float64bits(v.Float()))
+	}
+	return b
+}
@@ -0,0 +1,276 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package legacy provides a data operator that runs the built-in gadgets (trace/*, top/*, ...) and emits their
+// events to a data source, so that they can be used with the operators of image-based gadgets while they're migrated.
+// It's used by running an image called "legacy:<category>/<name>", e.g. "legacy:trace/exec".
+package legacy
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	gadgetregistry "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-registry"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/parser"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/types"
+)
+
+const (
+	OperatorName = "legacy"
+
+	// ImagePrefix is the prefix of the image names referring to built-in gadgets
+	ImagePrefix = "legacy:"
+)
+
+// IsLegacyImage tells whether imageName refers to a built-in gadget
+var IsLegacyImage = operators.MatchImagePrefix(ImagePrefix)
+
+// gadgetDesc returns the built-in gadget referred to by imageName
+func gadgetDesc(imageName string) (gadgets.GadgetDesc, error) {
+	category, name, ok := strings.Cut(strings.TrimPrefix(imageName, ImagePrefix), "/")
+	if !ok || category == "" || name == "" {
+		return nil, fmt.Errorf("invalid built-in gadget %q, expected %s<category>/<name>", imageName, ImagePrefix)
+	}
+	desc := gadgetregistry.Get(category, name)
+	if desc == nil {
+		return nil, fmt.Errorf("built-in gadget %s/%s not found", category, name)
+	}
+	return desc, nil
+}
+
+// paramDescs returns the params of the built-in gadget, including the ones specific to its type, like the interval
+// and the sorting of top gadgets
+func paramDescs(desc gadgets.GadgetDesc, p parser.Parser) params.ParamDescs {
+	descs := desc.ParamDescs()
+	descs.Add(gadgets.GadgetParams(desc, desc.Type(), p)...)
+	if desc.Type() == gadgets.TypeTraceIntervals {
+		descs.Add(&params.ParamDesc{
+			Key:         ParamNodeTotals,
+			Title:       "Node totals",
+			Description: "Fields to sum up over all rows of each interval. The sums are emitted together with the CPUs and memory of the node on the data source <gadget>" + totalsSuffix + ". Join multiple fields with ','.",
+		})
+	}
+	return descs
+}
+
+type legacyOperator struct{}
+
+func (o *legacyOperator) Name() string {
+	return OperatorName
+}
+
+func (o *legacyOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *legacyOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *legacyOperator) InstanceParams() api.Params {
+	return nil
+}
+
+func (o *legacyOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	if !IsLegacyImage(gadgetCtx.ImageName()) {
+		return nil, nil
+	}
+
+	desc, err := gadgetDesc(gadgetCtx.ImageName())
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := desc.(gadgets.GadgetInstantiate); !ok {
+		return nil, fmt.Errorf("built-in gadget %s/%s can't be instantiated", desc.Category(), desc.Name())
+	}
+	switch desc.Type() {
+	case gadgets.TypeTrace, gadgets.TypeTraceIntervals:
+	default:
+		return nil, fmt.Errorf("built-in gadget %s/%s of type %q isn't supported, only %q and %q gadgets are",
+			desc.Category(), desc.Name(), desc.Type(), gadgets.TypeTrace, gadgets.TypeTraceIntervals)
+	}
+
+	p := desc.Parser()
+	if p == nil {
+		return nil, fmt.Errorf("built-in gadget %s/%s has no columns", desc.Category(), desc.Name())
+	}
+	conv, err := newConverter(gadgetCtx, desc.Name(), p)
+	if err != nil {
+		return nil, fmt.Errorf("converting built-in gadget %s/%s: %w", desc.Category(), desc.Name(), err)
+	}
+	if fields := instanceParamValues[ParamNodeTotals]; fields != "" && desc.Type() == gadgets.TypeTraceIntervals {
+		conv.totals, err = newTotals(gadgetCtx, desc.Name(), conv, strings.Split(fields, ","))
+		if err != nil {
+			return nil, fmt.Errorf("adding totals of built-in gadget %s/%s: %w", desc.Category(), desc.Name(), err)
+		}
+	}
+
+	return &legacyOperatorInstance{
+		desc:        desc,
+		parser:      p,
+		converter:   conv,
+		paramValues: instanceParamValues,
+	}, nil
+}
+
+func (o *legacyOperator) Priority() int {
+	return operators.OperatorImagePriority
+}
+
+type legacyOperatorInstance struct {
+	desc        gadgets.GadgetDesc
+	parser      parser.Parser
+	converter   *converter
+	paramValues api.ParamValues
+
+	gadget any
+	done   chan struct{}
+}
+
+func (o *legacyOperatorInstance) Name() string {
+	return OperatorName
+}
+
+// ExtraParams exposes the params of the built-in gadget as params of this operator
+func (o *legacyOperatorInstance) ExtraParams(gadgetCtx operators.GadgetContext) api.Params {
+	return apihelpers.ParamDescsToParams(paramDescs(o.desc, o.parser))
+}
+
+func (o *legacyOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	gadgetParams := paramDescs(o.desc, o.parser).ToParams()
+	err := gadgetParams.CopyFromMap(o.paramValues, "")
+	if err != nil {
+		return err
+	}
+	if o.converter.totals != nil {
+		// The totals need all rows, so the gadget mustn't drop any; only the converter limits the rows it emits
+		o.converter.maxRows = gadgetParams.Get(gadgets.ParamMaxRows).AsInt()
+		if err := gadgetParams.Set(gadgets.ParamMaxRows, strconv.Itoa(math.MaxInt32)); err != nil {
+			return fmt.Errorf("setting max rows: %w", err)
+		}
+	}
+
+	gadget, err := o.desc.(gadgets.GadgetInstantiate).NewInstance()
+	if err != nil {
+		return fmt.Errorf("instantiating gadget: %w", err)
+	}
+
+	legacyCtx := &legacyContext{GadgetContext: gadgetCtx, gadgetParams: gadgetParams}
+	if initClose, ok := gadget.(gadgets.InitCloseGadget); ok {
+		if err := initClose.Init(legacyCtx); err != nil {
+			return fmt.Errorf("initializing gadget: %w", err)
+		}
+	}
+	o.gadget = gadget
+
+	// The callback handles both single events and the arrays of top gadgets
+	o.parser.SetEventCallback(o.converter.handle)
+	if setter, ok := gadget.(gadgets.EventHandlerSetter); ok {
+		setter.SetEventHandler(o.parser.EventHandlerFunc())
+	}
+	if setter, ok := gadget.(gadgets.EventHandlerArraySetter); ok {
+		setter.SetEventHandlerArray(o.parser.EventHandlerFuncArray())
+	}
+
+	run, ok := gadget.(gadgets.RunGadget)
+	if !ok {
+		return fmt.Errorf("built-in gadget %s/%s doesn't implement Run()", o.desc.Category(), o.desc.Name())
+	}
+
+	o.done = make(chan struct{})
+	go func() {
+		defer close(o.done)
+		if err := run.Run(legacyCtx); err != nil {
+			gadgetCtx.Logger().Errorf("running gadget: %v", err)
+		}
+		// Built-in gadgets run until the context is done; if they return earlier, the whole run is over
+		gadgetCtx.Cancel()
+	}()
+	return nil
+}
+
+func (o *legacyOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	if o.done != nil {
+		// The run might have ended because of its timeout, which doesn't cancel the context the gadget is using
+		gadgetCtx.Cancel()
+		<-o.done
+		o.done = nil
+	}
+	if initClose, ok := o.gadget.(gadgets.InitCloseGadget); ok {
+		initClose.Close()
+	}
+	o.gadget = nil
+	return nil
+}
+
+// legacyContext provides what built-in gadgets expect from gadgets.GadgetContext on top of the context of the
+// image-based gadget
+type legacyContext struct {
+	operators.GadgetContext
+	gadgetParams *params.Params
+}
+
+func (c *legacyContext) GadgetParams() *params.Params {
+	return c.gadgetParams
+}
+
+func (c *legacyContext) RuntimeParams() *params.Params {
+	return &params.Params{}
+}
+
+func (c *legacyContext) Args() []string {
+	return nil
+}
+
+func (c *legacyContext) Timeout() time.Duration {
+	// The timeout is handled by the gadget context
+	return 0
+}
+
+// logEvent logs events of built-in gadgets that only carry a message, like errors and warnings, and tells whether
+// ev was such an event
+func logEvent(gadgetCtx operators.GadgetContext, ev any) bool {
+	base, ok := ev.(interface{ GetBaseEvent() *types.Event })
+	if !ok {
+		return false
+	}
+	e := base.GetBaseEvent()
+	switch e.Type {
+	case types.ERR:
+		gadgetCtx.Logger().Error(e.Message)
+	case types.WARN:
+		gadgetCtx.Logger().Warn(e.Message)
+	case types.INFO:
+		gadgetCtx.Logger().Info(e.Message)
+	case types.DEBUG:
+		gadgetCtx.Logger().Debug(e.Message)
+	case types.READY:
+	default:
+		return false
+	}
+	return true
+}
+
+func init() {
+	operators.RegisterOperatorImages(IsLegacyImage)
+	operators.RegisterDataOperator(&legacyOperator{})
+}
@@ -0,0 +1,280 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package legacy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/columns"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	gadgetregistry "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-registry"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/parser"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/types"
+)
+
+type testEvent struct {
+	types.Event
+	types.WithMountNsID
+
+	Pid     uint32  `json:"pid" column:"pid,template:pid"`
+	Comm    string  `json:"comm" column:"comm,template:comm"`
+	Retval  int     `json:"ret" column:"ret,width:3,hide"`
+	Latency float64 `json:"latency" column:"latency"`
+}
+
+type testGadgetDesc struct {
+	gadgetType gadgets.GadgetType
+}
+
+func (d *testGadgetDesc) Name() string             { return string(d.gadgetType) }
+func (d *testGadgetDesc) Description() string      { return "test gadget" }
+func (d *testGadgetDesc) Category() string         { return "test" }
+func (d *testGadgetDesc) Type() gadgets.GadgetType { return d.gadgetType }
+func (d *testGadgetDesc) EventPrototype() any      { return &testEvent{} }
+func (d *testGadgetDesc) NewInstance() (gadgets.Gadget, error) {
+	return &testGadget{array: d.gadgetType == gadgets.TypeTraceIntervals}, nil
+}
+
+func (d *testGadgetDesc) ParamDescs() params.ParamDescs {
+	return params.ParamDescs{
+		{
+			Key:          "count",
+			DefaultValue: "1",
+			TypeHint:     params.TypeUint32,
+		},
+	}
+}
+
+func (d *testGadgetDesc) Parser() parser.Parser {
+	return parser.NewParser[testEvent](columns.MustCreateColumns[testEvent]())
+}
+
+// testGadget emits count events, plus a warning, and returns; like top gadgets, it emits them at once if array is set
+type testGadget struct {
+	array        bool
+	handler      func(*testEvent)
+	arrayHandler func([]*testEvent)
+}
+
+func (g *testGadget) SetEventHandler(handler any) {
+	g.handler = handler.(func(*testEvent))
+}
+
+func (g *testGadget) SetEventHandlerArray(handler any) {
+	g.arrayHandler = handler.(func([]*testEvent))
+}
+
+func (g *testGadget) Run(gadgetCtx gadgets.GadgetContext) error {
+	count := gadgetCtx.GadgetParams().Get("count").AsUint32()
+	events := make([]*testEvent, 0, count)
+	for i := uint32(0); i < count; i++ {
+		ev := &testEvent{
+			Event: types.Event{
+				Type: types.NORMAL,
+				CommonData: types.CommonData{
+					K8s:     types.K8sMetadata{Node: "node-1", BasicK8sMetadata: types.BasicK8sMetadata{PodName: "pod"}},
+					Runtime: types.BasicRuntimeMetadata{ContainerName: "container"},
+				},
+			},
+			WithMountNsID: types.WithMountNsID{MountNsID: 4026531840},
+			Pid:           100 + i,
+			Comm:          "cat",
+			Retval:        -1,
+		}
+		events = append(events, ev)
+	}
+	warning := types.Warn("something happened")
+	if g.array {
+		g.arrayHandler(append(events, &testEvent{Event: warning}))
+		return nil
+	}
+	for _, ev := range events {
+		g.handler(ev)
+	}
+	g.handler(&testEvent{Event: warning})
+	return nil
+}
+
+func init() {
+	gadgetregistry.Register(&testGadgetDesc{gadgetType: gadgets.TypeTrace})
+	gadgetregistry.Register(&testGadgetDesc{gadgetType: gadgets.TypeTraceIntervals})
+	gadgetregistry.Register(&testGadgetDesc{gadgetType: gadgets.TypeOneShot})
+}
+
+func TestLegacyOperator(t *testing.T) {
+	for _, gadgetType := range []gadgets.GadgetType{gadgets.TypeTrace, gadgets.TypeTraceIntervals} {
+		t.Run(string(gadgetType), func(t *testing.T) {
+			gadgetCtx := gadgetcontext.New(context.Background(), ImagePrefix+"test/"+string(gadgetType))
+			inst, err := (&legacyOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{"count": "2"})
+			require.NoError(t, err)
+			require.NotNil(t, inst)
+
+			ds := gadgetCtx.GetDataSources()[string(gadgetType)]
+			require.NotNil(t, ds)
+
+			// The metadata of built-in gadgets is emitted using the standard fields
+			node := ds.GetField("k8s.node")
+			pod := ds.GetField("k8s.pod")
+			containerName := ds.GetField("runtime.containerName")
+			require.NotNil(t, node)
+			require.NotNil(t, pod)
+			require.NotNil(t, containerName)
+			require.Nil(t, ds.GetField("k8s.podName"))
+
+			mntns := ds.GetField("mntns")
+			require.NotNil(t, mntns)
+			require.Equal(t, api.Kind_Uint64, mntns.Type())
+			require.Len(t, ds.GetFieldsWithTag("type:gadget_mntns_id"), 1)
+
+			pid := ds.GetField("pid")
+			comm := ds.GetField("comm")
+			ret := ds.GetField("ret")
+			latency := ds.GetField("latency")
+			require.Equal(t, api.Kind_Uint32, pid.Type())
+			require.Equal(t, api.Kind_String, comm.Type())
+			require.Equal(t, api.Kind_Int64, ret.Type())
+			require.Equal(t, api.Kind_Float64, latency.Type())
+			require.True(t, datasource.FieldFlagHidden.In(ret.Flags()))
+			require.Equal(t, "3", ret.Annotations()["columns.width"])
+
+			type event struct {
+				pid  uint32
+				comm string
+				ret  int64
+			}
+			var events []event
+			ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+				require.Equal(t, uint64(4026531840), mntns.Uint64(data))
+				require.Equal(t, "node-1", string(node.Get(data)))
+				require.Equal(t, "pod", string(pod.Get(data)))
+				require.Equal(t, "container", string(containerName.Get(data)))
+				events = append(events, event{pid.Uint32(data), comm.String(data), ret.Int64(data)})
+				return nil
+			}, 0)
+
+			require.NoError(t, inst.Start(gadgetCtx))
+
+			// The run is over once the gadget returns
+			<-gadgetCtx.Context().Done()
+			require.NoError(t, inst.Stop(gadgetCtx))
+
+			require.Equal(t, []event{{100, "cat", -1}, {101, "cat", -1}}, events)
+		})
+	}
+}
+
+func TestLegacyOperatorSkipsImages(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "ghcr.io/inspektor-gadget/gadget/trace_exec")
+	inst, err := (&legacyOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+	require.NoError(t, err)
+	require.Nil(t, inst)
+	require.Empty(t, gadgetCtx.GetDataSources())
+}
+
+func TestLegacyOperatorErrors(t *testing.T) {
+	for name, imageName := range map[string]string{
+		"invalid":     ImagePrefix + "exec",
+		"not_found":   ImagePrefix + "test/missing",
+		"unsupported": ImagePrefix + "test/" + string(gadgets.TypeOneShot),
+	} {
+		t.Run(name, func(t *testing.T) {
+			gadgetCtx := gadgetcontext.New(context.Background(), imageName)
+			_, err := (&legacyOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestLegacyOperatorTotals(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), ImagePrefix+"test/"+string(gadgets.TypeTraceIntervals))
+	inst, err := (&legacyOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{
+		"count":              "3",
+		gadgets.ParamMaxRows: "1",
+		ParamNodeTotals:      "pid,ret,latency",
+	})
+	require.NoError(t, err)
+
+	ds := gadgetCtx.GetDataSources()[string(gadgets.TypeTraceIntervals)]
+	totalsDs := gadgetCtx.GetDataSources()[string(gadgets.TypeTraceIntervals)+totalsSuffix]
+	require.NotNil(t, ds)
+	require.NotNil(t, totalsDs)
+
+	pid := totalsDs.GetField("pid")
+	ret := totalsDs.GetField("ret")
+	latency := totalsDs.GetField("latency")
+	count := totalsDs.GetField("count")
+	cpus := totalsDs.GetField("node_cpus")
+	require.Equal(t, api.Kind_Uint64, pid.Type())
+	require.Equal(t, api.Kind_Int64, ret.Type())
+	require.Equal(t, api.Kind_Float64, latency.Type())
+	require.NotNil(t, totalsDs.GetField("node_memory"))
+
+	rows := 0
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		rows++
+		return nil
+	}, 0)
+	totals := 0
+	totalsDs.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		totals++
+		require.Equal(t, uint64(100+101+102), pid.Uint64(data))
+		require.Equal(t, int64(-3), ret.Int64(data))
+		require.Equal(t, uint64(3), count.Uint64(data))
+		require.NotZero(t, cpus.Uint32(data))
+		return nil
+	}, 0)
+
+	require.NoError(t, inst.Start(gadgetCtx))
+	<-gadgetCtx.Context().Done()
+	require.NoError(t, inst.Stop(gadgetCtx))
+
+	// Only max-rows rows are emitted, but the totals are computed over all of them
+	require.Equal(t, 1, rows)
+	require.Equal(t, 1, totals)
+}
+
+func TestLegacyOperatorTotalsErrors(t *testing.T) {
+	for name, fields := range map[string]string{
+		"not_found":   "missing",
+		"not_numeric": "comm",
+	} {
+		t.Run(name, func(t *testing.T) {
+			gadgetCtx := gadgetcontext.New(context.Background(), ImagePrefix+"test/"+string(gadgets.TypeTraceIntervals))
+			_, err := (&legacyOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{ParamNodeTotals: fields})
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestReadMemTotal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "meminfo")
+	require.NoError(t, os.WriteFile(path, []byte("MemTotal:       16314260 kB\nMemFree:         1234 kB\n"), 0o644))
+	mem, err := readMemTotal(path)
+	require.NoError(t, err)
+	require.Equal(t, uint64(16314260*1024), mem)
+
+	require.NoError(t, os.WriteFile(path, []byte("MemFree:         1234 kB\n"), 0o644))
+	_, err = readMemTotal(path)
+	require.Error(t, err)
+}
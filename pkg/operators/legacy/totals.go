@@ -0,0 +1,186 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package legacy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+)
+
+const (
+	// ParamNodeTotals are the fields of top gadgets to sum up per interval
+	ParamNodeTotals = "node-totals"
+
+	// totalsSuffix is appended to the name of the data source of the gadget to get the one of the totals
+	totalsSuffix = "_totals"
+
+	meminfoPath = "/proc/meminfo"
+)
+
+// totals emits the sums of numeric fields over all rows of an interval of a top gadget, together with the resources
+// of the node, so that consumers can put the rows in relation to the whole node
+type totals struct {
+	gadgetCtx operators.GadgetContext
+	ds        datasource.DataSource
+	fields    []convertedField
+	count     datasource.FieldAccessor
+	cpus      datasource.FieldAccessor
+	memory    datasource.FieldAccessor
+
+	nodeCPUs   uint32
+	nodeMemory uint64
+}
+
+// sumKind returns the kind of the field holding the sum of a field of the given kind
+func sumKind(kind api.Kind) (api.Kind, bool) {
+	switch kind {
+	case api.Kind_Int8, api.Kind_Int16, api.Kind_Int32, api.Kind_Int64:
+		return api.Kind_Int64, true
+	case api.Kind_Uint8, api.Kind_Uint16, api.Kind_Uint32, api.Kind_Uint64:
+		return api.Kind_Uint64, true
+	case api.Kind_Float32, api.Kind_Float64:
+		return api.Kind_Float64, true
+	}
+	return api.Kind_Invalid, false
+}
+
+func newTotals(gadgetCtx operators.GadgetContext, name string, c *converter, fieldNames []string) (*totals, error) {
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, name+totalsSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("adding datasource %q: %w", name+totalsSuffix, err)
+	}
+	t := &totals{gadgetCtx: gadgetCtx, ds: ds}
+
+	for _, fieldName := range fieldNames {
+		i := -1
+		for j, f := range c.fields {
+			if f.acc.Name() == fieldName {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			return nil, fmt.Errorf("field %q not found", fieldName)
+		}
+		kind, ok := sumKind(c.fields[i].kind)
+		if !ok {
+			return nil, fmt.Errorf("field %q isn't numeric", fieldName)
+		}
+		acc, err := ds.AddField(fieldName, datasource.WithKind(kind), datasource.WithAnnotations(map[string]string{
+			"description": fmt.Sprintf("Sum of %s over all rows of the interval", fieldName),
+		}))
+		if err != nil {
+			return nil, fmt.Errorf("adding field %q: %w", fieldName, err)
+		}
+		t.fields = append(t.fields, convertedField{acc: acc, kind: kind, get: c.fields[i].get})
+	}
+
+	t.count, err = ds.AddField("count", datasource.WithKind(api.Kind_Uint64), datasource.WithAnnotations(map[string]string{
+		"description": "Number of rows of the interval",
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("adding field count: %w", err)
+	}
+	t.cpus, err = ds.AddField("node_cpus", datasource.WithKind(api.Kind_Uint32), datasource.WithAnnotations(map[string]string{
+		"description": "Number of CPUs of the node",
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("adding field node_cpus: %w", err)
+	}
+	t.memory, err = ds.AddField("node_memory", datasource.WithKind(api.Kind_Uint64), datasource.WithAnnotations(map[string]string{
+		"description": "Total memory of the node in bytes",
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("adding field node_memory: %w", err)
+	}
+
+	t.nodeCPUs = uint32(runtime.NumCPU())
+	t.nodeMemory, err = readMemTotal(meminfoPath)
+	if err != nil {
+		gadgetCtx.Logger().Warnf("getting memory of the node: %v", err)
+	}
+	return t, nil
+}
+
+// readMemTotal returns the total memory in bytes given in a meminfo file
+func readMemTotal(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "MemTotal:")
+		if !ok {
+			continue
+		}
+		kb, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parsing MemTotal: %w", err)
+		}
+		return kb * 1024, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("MemTotal not found in %s", path)
+}
+
+// emit emits the totals of the given rows
+func (t *totals) emit(rows []any) {
+	data := t.ds.NewData()
+	bo := t.ds.ByteOrder()
+	for _, f := range t.fields {
+		var sum reflect.Value
+		switch f.kind {
+		case api.Kind_Int64:
+			var s int64
+			for _, row := range rows {
+				s += f.get(row).Int()
+			}
+			sum = reflect.ValueOf(s)
+		case api.Kind_Uint64:
+			var s uint64
+			for _, row := range rows {
+				s += f.get(row).Uint()
+			}
+			sum = reflect.ValueOf(s)
+		case api.Kind_Float64:
+			var s float64
+			for _, row := range rows {
+				s += f.get(row).Float()
+			}
+			sum = reflect.ValueOf(s)
+		}
+		f.acc.Set(data, encode(f.kind, bo, sum))
+	}
+	t.count.Set(data, encode(api.Kind_Uint64, bo, reflect.ValueOf(uint64(len(rows)))))
+	t.cpus.Set(data, encode(api.Kind_Uint32, bo, reflect.ValueOf(t.nodeCPUs)))
+	t.memory.Set(data, encode(api.Kind_Uint64, bo, reflect.ValueOf(t.nodeMemory)))
+	if err := t.ds.EmitAndRelease(data); err != nil {
+		t.gadgetCtx.Logger().Warnf("emitting totals: %v", err)
+	}
+}
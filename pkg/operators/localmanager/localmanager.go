@@ -36,6 +36,7 @@ import (
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets"
 	igmanager "github.com/inspektor-gadget/inspektor-gadget/pkg/ig-manager"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/common"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/types"
 )
@@ -45,6 +46,9 @@ const (
 	Runtimes             = "runtimes"
 	ContainerName        = "containername"
 	Host                 = "host"
+	Origin               = "origin"
+	Capabilities         = "capabilities"
+	ContainerLogs        = "container-logs"
 	DockerSocketPath     = "docker-socketpath"
 	ContainerdSocketPath = "containerd-socketpath"
 	CrioSocketPath       = "crio-socketpath"
@@ -116,8 +120,12 @@ func (l *LocalManager) GlobalParamDescs() params.ParamDescs {
 		},
 		{
 			Key:          RuntimeProtocol,
-			DefaultValue: "internal",
-			Description:  "Container runtime protocol. Supported values are: internal, cri",
+			DefaultValue: strings.Join(containerutils.AvailableRuntimeProtocols, ","),
+			Description:  "Container runtime protocols separated by comma, in the order they are tried. Supported values are: internal, cri",
+			Validator: func(value string) error {
+				_, err := containerutils.ParseRuntimeProtocols(value)
+				return err
+			},
 		},
 	}
 }
@@ -267,6 +275,7 @@ type localManagerTrace struct {
 	gadgetCtx          operators.GadgetContext
 
 	eventWrappers map[datasource.DataSource]*compat.EventWrapperBase
+	originFields  map[datasource.DataSource]datasource.FieldAccessor
 }
 
 func (l *localManagerTrace) Name() string {
@@ -430,6 +439,10 @@ func (l *localManagerTrace) EnrichEvent(ev any) error {
 type localManagerTraceWrapper struct {
 	localManagerTrace
 	runID string
+
+	capabilities  *common.ContainerCapabilities
+	containerLogs *common.ContainerLogs
+	containersKey string
 }
 
 func (l *LocalManager) GlobalParams() api.Params {
@@ -437,13 +450,37 @@ func (l *LocalManager) GlobalParams() api.Params {
 }
 
 func (l *LocalManager) InstanceParams() api.Params {
-	return apihelpers.ParamDescsToParams(l.ParamDescs())
+	return apihelpers.ParamDescsToParams(l.dataParamDescs())
+}
+
+// dataParamDescs returns the parameters of image-based gadgets
+func (l *LocalManager) dataParamDescs() params.ParamDescs {
+	return append(l.ParamDescs(),
+		&params.ParamDesc{
+			Key:            Origin,
+			Description:    "Show only data from the host or only data from containers when --host is set",
+			DefaultValue:   OriginAll,
+			PossibleValues: []string{OriginAll, OriginHost, OriginContainer},
+		},
+		&params.ParamDesc{
+			Key:          Capabilities,
+			Description:  "Emit whether the events of the containers can be traced on the data source " + common.CapabilitiesDataSourceName,
+			DefaultValue: "false",
+			TypeHint:     params.TypeBool,
+		},
+		&params.ParamDesc{
+			Key:          ContainerLogs,
+			Description:  "Emit the lines the containers write to stdout and stderr on the data source " + common.ContainerLogsDataSourceName,
+			DefaultValue: "false",
+			TypeHint:     params.TypeBool,
+		},
+	)
 }
 
 func (l *LocalManager) InstantiateDataOperator(gadgetCtx operators.GadgetContext, paramValues api.ParamValues) (
 	operators.DataOperatorInstance, error,
 ) {
-	params := l.ParamDescs().ToParams()
+	params := l.dataParamDescs().ToParams()
 	err := params.CopyFromMap(paramValues, "")
 	if err != nil {
 		return nil, err
@@ -487,10 +524,30 @@ func (l *LocalManager) InstantiateDataOperator(gadgetCtx operators.GadgetContext
 		activate = true
 	}
 
+	if params.Get(Host).AsBool() && l.igManager != nil {
+		traceInstance.originFields, err = addOriginFields(wrappers)
+		if err != nil {
+			return nil, fmt.Errorf("adding origin fields: %w", err)
+		}
+	}
+
 	if !activate {
 		return nil, nil
 	}
 
+	if l.igManager != nil {
+		traceInstance.capabilities, err = common.NewContainerCapabilities(gadgetCtx, params.Get(Capabilities).AsBool(), false)
+		if err != nil {
+			return nil, err
+		}
+		if params.Get(ContainerLogs).AsBool() {
+			traceInstance.containerLogs, err = common.NewContainerLogs(gadgetCtx, false)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	return traceInstance, nil
 }
 
@@ -512,7 +569,7 @@ func (l *localManagerTrace) ParamDescs() params.ParamDescs {
 }
 
 func (l *localManagerTraceWrapper) ParamDescs(gadgetCtx operators.GadgetContext) params.ParamDescs {
-	return l.localManagerTrace.ParamDescs()
+	return l.manager.dataParamDescs()
 }
 
 func (l *LocalManager) Priority() int {
@@ -520,22 +577,33 @@ func (l *LocalManager) Priority() int {
 }
 
 func (l *localManagerTraceWrapper) PreStart(gadgetCtx operators.GadgetContext) error {
-	// hack - this makes it possible to use the Attacher interface
-	var ok bool
-	l.gadgetInstance, ok = gadgetCtx.GetVar("ebpfInstance")
-	if !ok {
-		return fmt.Errorf("getting ebpfInstance")
-	}
-
-	compat.Subscribe(
-		l.eventWrappers,
-		l.manager.igManager.ContainerCollection.EnrichEventByMntNs,
-		l.manager.igManager.ContainerCollection.EnrichEventByNetNs,
-		0,
-	)
+	// hack - this makes it possible to use the Attacher interface; gadgets that don't use eBPF, like the fallback
+	// gadgets, don't provide an instance
+	l.gadgetInstance, _ = gadgetCtx.GetVar("ebpfInstance")
 
 	id := uuid.New()
 	host := l.params.Get(Host).AsBool()
+	origin := l.params.Get(Origin).AsString()
+
+	if origin == OriginHost && !host {
+		return fmt.Errorf("showing only data from the host requires --%s", Host)
+	}
+
+	switch {
+	case l.manager.igManager == nil:
+		if origin != OriginAll {
+			return fmt.Errorf("container-collection isn't available: can't tell data from the host and containers apart")
+		}
+	case host:
+		l.subscribeOrigin(origin)
+	default:
+		compat.Subscribe(
+			l.eventWrappers,
+			l.manager.igManager.ContainerCollection.EnrichEventByMntNs,
+			l.manager.igManager.ContainerCollection.EnrichEventByNetNs,
+			0,
+		)
+	}
 
 	containerSelector := containercollection.ContainerSelector{
 		Runtime: containercollection.RuntimeSelector{
@@ -567,11 +635,76 @@ func (l *localManagerTraceWrapper) PreStart(gadgetCtx operators.GadgetContext) e
 	return l.PreGadgetRun()
 }
 
+// subscribeOrigin enriches events of the host and containers, setting their origin and dropping those that don't
+// come from origin
+func (l *localManagerTraceWrapper) subscribeOrigin(origin string) {
+	cc := &l.manager.igManager.ContainerCollection
+	o := &originEnricher{
+		show:         origin,
+		enrichMntNs:  cc.EnrichEventByMntNs,
+		enrichNetNs:  cc.EnrichEventByNetNs,
+		enrichNode:   cc.EnrichEventNode,
+		originFields: l.originFields,
+	}
+
+	var err error
+	if o.hostMntNs, err = containerutils.GetMntNs(1); err != nil {
+		l.gadgetCtx.Logger().Warnf("getting mount namespace of the host: %v", err)
+	}
+	if o.hostNetNs, err = containerutils.GetNetNs(1); err != nil {
+		l.gadgetCtx.Logger().Warnf("getting network namespace of the host: %v", err)
+	}
+
+	o.subscribe(l.eventWrappers, 0)
+}
+
 func (l *localManagerTraceWrapper) Start(gadgetCtx operators.GadgetContext) error {
+	if l.capabilities == nil {
+		return nil
+	}
+
+	// Containers are reported once running, so that they can be emitted
+	add := func(container *containercollection.Container) {
+		if err := l.capabilities.Report(container); err != nil {
+			gadgetCtx.Logger().Warnf("reporting capabilities of container %q: %v", container.Runtime.ContainerName, err)
+		}
+		if l.containerLogs != nil {
+			l.containerLogs.Add(container)
+		}
+	}
+	containerSelector := containercollection.ContainerSelector{
+		Runtime: containercollection.RuntimeSelector{
+			ContainerName: l.params.Get(ContainerName).AsString(),
+		},
+	}
+	l.containersKey = uuid.New().String()
+	containers := l.manager.igManager.Subscribe(
+		l.containersKey,
+		containerSelector,
+		func(event containercollection.PubSubEvent) {
+			switch event.Type {
+			case containercollection.EventTypeAddContainer:
+				add(event.Container)
+			case containercollection.EventTypeRemoveContainer:
+				if l.containerLogs != nil {
+					l.containerLogs.Remove(event.Container)
+				}
+			}
+		},
+	)
+	for _, container := range containers {
+		add(container)
+	}
 	return nil
 }
 
 func (l *localManagerTraceWrapper) Stop(gadgetCtx operators.GadgetContext) error {
+	if l.containersKey != "" {
+		l.manager.igManager.Unsubscribe(l.containersKey)
+	}
+	if l.containerLogs != nil {
+		l.containerLogs.Close()
+	}
 	return l.PostGadgetRun()
 }
 
@@ -0,0 +1,127 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localmanager
+
+import (
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource/compat"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/types"
+)
+
+const (
+	OriginAll       = "all"
+	OriginHost      = "host"
+	OriginContainer = "container"
+
+	originField = "origin"
+)
+
+// addOriginFields adds a field telling whether an event comes from the host or from a container to all data sources
+// that get enriched
+func addOriginFields(wrappers map[datasource.DataSource]*compat.EventWrapperBase) (map[datasource.DataSource]datasource.FieldAccessor, error) {
+	res := make(map[datasource.DataSource]datasource.FieldAccessor, len(wrappers))
+	for ds := range wrappers {
+		acc, err := ds.AddField(originField,
+			datasource.WithAnnotations(map[string]string{
+				"description":   "Whether the event comes from the host or from a container",
+				"columns.width": "9",
+			}),
+			datasource.WithOrder(-31),
+		)
+		if err != nil {
+			return nil, err
+		}
+		res[ds] = acc
+	}
+	return res, nil
+}
+
+// originEvent records whether the enrichment found the container an event comes from
+type originEvent struct {
+	*compat.EventWrapper
+	fromContainer bool
+}
+
+func (ev *originEvent) SetPodMetadata(container types.Container) {
+	ev.fromContainer = true
+	ev.EventWrapper.SetPodMetadata(container)
+}
+
+func (ev *originEvent) SetContainerMetadata(container types.Container) {
+	ev.fromContainer = true
+	ev.EventWrapper.SetContainerMetadata(container)
+}
+
+// originEnricher enriches events when --host is set. Events in the namespaces of the host skip the container lookup
+// and all events get their origin set, so that host events can be told apart from container events that couldn't be
+// enriched. Events not coming from the origin to show are dropped.
+type originEnricher struct {
+	// hostMntNs and hostNetNs are the namespaces of the host; 0 if unknown
+	hostMntNs uint64
+	hostNetNs uint64
+
+	// show is one of OriginAll, OriginHost and OriginContainer
+	show string
+
+	enrichMntNs compat.MntNsEnrichFunc
+	enrichNetNs compat.NetNsEnrichFunc
+	enrichNode  func(event operators.NodeSetter)
+
+	originFields map[datasource.DataSource]datasource.FieldAccessor
+}
+
+// origin enriches ev and returns where it comes from
+func (o *originEnricher) origin(ev *originEvent) string {
+	switch {
+	case ev.MntnsidAccessor != nil:
+		if o.hostMntNs != 0 && ev.GetMountNSID() == o.hostMntNs {
+			o.enrichNode(ev)
+			return OriginHost
+		}
+		o.enrichMntNs(ev)
+		if ev.NetnsidAccessor != nil && !ev.fromContainer {
+			o.enrichNetNs(ev)
+		}
+	case ev.NetnsidAccessor != nil:
+		if o.hostNetNs != 0 && ev.GetNetNSID() == o.hostNetNs {
+			o.enrichNode(ev)
+			return OriginHost
+		}
+		o.enrichNetNs(ev)
+	}
+	if ev.fromContainer {
+		return OriginContainer
+	}
+	// Processes of the host can use namespaces of their own, e.g. systemd services with a private /tmp
+	return OriginHost
+}
+
+func (o *originEnricher) subscribe(eventWrappers map[datasource.DataSource]*compat.EventWrapperBase, priority int) {
+	for ds, wrapper := range eventWrappers {
+		originField := o.originFields[ds]
+		ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+			ev := &originEvent{EventWrapper: &compat.EventWrapper{EventWrapperBase: wrapper, Data: data}}
+			origin := o.origin(ev)
+			if o.show != OriginAll && origin != o.show {
+				return datasource.ErrDiscard
+			}
+			if originField != nil && originField.IsRequested() {
+				originField.Set(data, []byte(origin))
+			}
+			return nil
+		}, priority)
+	}
+}
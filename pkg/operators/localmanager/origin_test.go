@@ -0,0 +1,115 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localmanager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource/compat"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/types"
+)
+
+const (
+	testHostMntNs      = 4026531841
+	testContainerMntNs = 4026532000
+	testServiceMntNs   = 4026532100
+)
+
+type originTest struct {
+	ds      datasource.DataSource
+	mntns   datasource.FieldAccessor
+	origin  datasource.FieldAccessor
+	lookups int
+	emitted []string
+}
+
+func newOriginTest(t *testing.T, show string) *originTest {
+	ot := &originTest{ds: datasource.New(datasource.TypeEvent, "exec")}
+	var err error
+	ot.mntns, err = ot.ds.AddField("mntns_id", datasource.WithKind(api.Kind_Uint64))
+	require.NoError(t, err)
+
+	wrapper, err := compat.WrapAccessors(ot.ds, ot.mntns, nil)
+	require.NoError(t, err)
+	wrappers := map[datasource.DataSource]*compat.EventWrapperBase{ot.ds: wrapper}
+	originFields, err := addOriginFields(wrappers)
+	require.NoError(t, err)
+	ot.origin = originFields[ot.ds]
+
+	o := &originEnricher{
+		hostMntNs: testHostMntNs,
+		show:      show,
+		enrichMntNs: func(event operators.ContainerInfoFromMountNSID) {
+			ot.lookups++
+			if event.GetMountNSID() == testContainerMntNs {
+				event.SetContainerMetadata(&containercollection.Container{
+					Runtime: containercollection.RuntimeMetadata{
+						BasicRuntimeMetadata: types.BasicRuntimeMetadata{ContainerName: "nginx"},
+					},
+				})
+			}
+		},
+		enrichNetNs:  func(event operators.ContainerInfoFromNetNSID) {},
+		enrichNode:   func(event operators.NodeSetter) {},
+		originFields: originFields,
+	}
+	o.subscribe(wrappers, 0)
+	ot.ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		ot.emitted = append(ot.emitted, string(ot.origin.Get(data)))
+		return nil
+	}, 1)
+	return ot
+}
+
+func (ot *originTest) emit(t *testing.T, mntns uint64) {
+	data := ot.ds.NewData()
+	require.NoError(t, ot.mntns.Set(data, make([]byte, 8)))
+	ot.mntns.PutUint64(data, mntns)
+	require.NoError(t, ot.ds.EmitAndRelease(data))
+}
+
+func TestOrigin(t *testing.T) {
+	ot := newOriginTest(t, OriginAll)
+
+	// Host events skip the container lookup
+	ot.emit(t, testHostMntNs)
+	require.Equal(t, 0, ot.lookups)
+
+	ot.emit(t, testContainerMntNs)
+	ot.emit(t, testServiceMntNs)
+	require.Equal(t, 2, ot.lookups)
+	require.Equal(t, []string{OriginHost, OriginContainer, OriginHost}, ot.emitted)
+}
+
+func TestOriginFilter(t *testing.T) {
+	for show, expected := range map[string][]string{
+		OriginHost:      {OriginHost, OriginHost},
+		OriginContainer: {OriginContainer},
+	} {
+		t.Run(show, func(t *testing.T) {
+			ot := newOriginTest(t, show)
+			ot.emit(t, testHostMntNs)
+			ot.emit(t, testContainerMntNs)
+			ot.emit(t, testServiceMntNs)
+			require.Equal(t, expected, ot.emitted)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operators
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// MapsVar is the name of the gadget context variable holding a map[string]MapReader of the eBPF maps of a gadget,
+// keyed by the name of the map
+const MapsVar = "maps"
+
+// ErrMapUnavailable is returned by MapReader when the gadget hasn't been started yet or has already been stopped
+var ErrMapUnavailable = errors.New("map not available")
+
+// MapReader gives read-only access to an eBPF map of a gadget. Keys and values are the raw bytes in native byte
+// order, as seen by the eBPF programs. Maps can only be read while the gadget is running.
+type MapReader interface {
+	Name() string
+	KeySize() uint32
+	ValueSize() uint32
+	MaxEntries() uint32
+
+	// PerCPU returns whether the map holds one value per possible CPU for each key
+	PerCPU() bool
+
+	// Lookup returns the values stored for key, or nil if the map doesn't contain key. Per-CPU maps return one value
+	// per possible CPU, other maps a single one.
+	Lookup(key []byte) ([][]byte, error)
+
+	// Iterate calls fn for each entry of the map with the same values Lookup would return. Iteration stops at the
+	// first error returned by fn, which is then returned by Iterate.
+	Iterate(fn func(key []byte, values [][]byte) error) error
+}
+
+// SumPerCPU aggregates the values of a per-CPU map by summing them up as arrays of unsigned 64-bit integers, which is
+// how eBPF programs usually keep counters in per-CPU maps
+func SumPerCPU(values [][]byte) ([]byte, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	size := len(values[0])
+	if size%8 != 0 {
+		return nil, fmt.Errorf("value size %d is not a multiple of 8", size)
+	}
+	sum := make([]byte, size)
+	for cpu, value := range values {
+		if len(value) != size {
+			return nil, fmt.Errorf("value of cpu %d has size %d, expected %d", cpu, len(value), size)
+		}
+		for off := 0; off < size; off += 8 {
+			binary.NativeEndian.PutUint64(sum[off:], binary.NativeEndian.Uint64(sum[off:])+binary.NativeEndian.Uint64(value[off:]))
+		}
+	}
+	return sum, nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operators
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func uint64s(values ...uint64) []byte {
+	buf := make([]byte, 0, len(values)*8)
+	for _, v := range values {
+		buf = binary.NativeEndian.AppendUint64(buf, v)
+	}
+	return buf
+}
+
+func TestSumPerCPU(t *testing.T) {
+	sum, err := SumPerCPU([][]byte{
+		uint64s(1, 10),
+		uint64s(2, 20),
+		uint64s(3, 30),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint64s(6, 60), sum)
+
+	sum, err = SumPerCPU(nil)
+	require.NoError(t, err)
+	assert.Nil(t, sum)
+
+	_, err = SumPerCPU([][]byte{{1, 2, 3, 4}})
+	assert.Error(t, err)
+
+	_, err = SumPerCPU([][]byte{uint64s(1), uint64s(1, 2)})
+	assert.Error(t, err)
+}
@@ -0,0 +1,267 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nettopology
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+	netnsig "github.com/inspektor-gadget/inspektor-gadget/pkg/utils/netns"
+)
+
+// iface is a network interface of a network namespace
+type iface struct {
+	name  string
+	index int
+	typ   string
+	up    bool
+	mtu   int
+	mac   string
+	// master is the name of the bridge or bond the interface is attached to
+	master string
+	// peerIndex is the index of the other end of a veth pair, which is usually in another network namespace
+	peerIndex int
+	addresses []string
+}
+
+// route is a route of any routing table but the local one, which only holds the addresses of the namespace
+type route struct {
+	dst      string
+	gateway  string
+	dev      string
+	src      string
+	table    int
+	protocol string
+}
+
+// topology is the network configuration of a network namespace
+type topology struct {
+	interfaces []iface
+	routes     []route
+	// iptables are the tables of the legacy iptables, e.g. "filter" or "ip6:nat"
+	iptables []string
+	// nftables are the tables of nftables with their family, e.g. "inet filter"; they include the ones created by
+	// iptables-nft
+	nftables []string
+	// nftablesErr is set if the tables of nftables couldn't be read, e.g. because of missing capabilities
+	nftablesErr error
+}
+
+// collectTopology returns the network configuration of the network namespace of the process with the given pid
+func collectTopology(pid int) (*topology, error) {
+	ns, err := netnsig.GetFromPidWithAltProcfs(pid, host.HostProcFs)
+	if err != nil {
+		return nil, fmt.Errorf("getting network namespace of pid %d: %w", pid, err)
+	}
+	defer ns.Close()
+
+	h, err := netlink.NewHandleAt(ns, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("opening netlink socket: %w", err)
+	}
+	defer h.Close()
+
+	t := &topology{}
+	if t.interfaces, err = listInterfaces(h); err != nil {
+		return nil, err
+	}
+	if t.routes, err = listRoutes(h, t.interfaces); err != nil {
+		return nil, err
+	}
+	t.iptables = listIPTables(pid)
+	t.nftables, t.nftablesErr = listNFTables(ns)
+	return t, nil
+}
+
+func listInterfaces(h *netlink.Handle) ([]iface, error) {
+	links, err := h.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("listing interfaces: %w", err)
+	}
+	names := make(map[int]string, len(links))
+	for _, l := range links {
+		names[l.Attrs().Index] = l.Attrs().Name
+	}
+
+	ifaces := make([]iface, 0, len(links))
+	for _, l := range links {
+		attrs := l.Attrs()
+		i := iface{
+			name:   attrs.Name,
+			index:  attrs.Index,
+			typ:    l.Type(),
+			up:     attrs.Flags&unix.IFF_UP != 0,
+			mtu:    attrs.MTU,
+			mac:    attrs.HardwareAddr.String(),
+			master: names[attrs.MasterIndex],
+		}
+		if _, ok := l.(*netlink.Veth); ok {
+			// The kernel reports the index of the peer as the parent of a veth
+			i.peerIndex = attrs.ParentIndex
+		}
+		addrs, err := h.AddrList(l, netlink.FAMILY_ALL)
+		if err != nil {
+			return nil, fmt.Errorf("listing addresses of interface %q: %w", attrs.Name, err)
+		}
+		for _, a := range addrs {
+			i.addresses = append(i.addresses, a.IPNet.String())
+		}
+		ifaces = append(ifaces, i)
+	}
+	return ifaces, nil
+}
+
+// routeProtocols are the names of the origins of routes, like "ip route" shows them
+var routeProtocols = map[netlink.RouteProtocol]string{
+	unix.RTPROT_UNSPEC:   "unspec",
+	unix.RTPROT_REDIRECT: "redirect",
+	unix.RTPROT_KERNEL:   "kernel",
+	unix.RTPROT_BOOT:     "boot",
+	unix.RTPROT_STATIC:   "static",
+	unix.RTPROT_DHCP:     "dhcp",
+	unix.RTPROT_BIRD:     "bird",
+	unix.RTPROT_BGP:      "bgp",
+}
+
+func listRoutes(h *netlink.Handle, ifaces []iface) ([]route, error) {
+	routes, err := h.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: unix.RT_TABLE_UNSPEC}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("listing routes: %w", err)
+	}
+	names := make(map[int]string, len(ifaces))
+	for _, i := range ifaces {
+		names[i.index] = i.name
+	}
+
+	res := make([]route, 0, len(routes))
+	for _, r := range routes {
+		if r.Table == unix.RT_TABLE_LOCAL {
+			continue
+		}
+		rt := route{
+			dst:      "default",
+			dev:      names[r.LinkIndex],
+			table:    r.Table,
+			protocol: routeProtocols[r.Protocol],
+		}
+		if r.Dst != nil {
+			rt.dst = r.Dst.String()
+		}
+		if r.Gw != nil {
+			rt.gateway = r.Gw.String()
+		}
+		if r.Src != nil {
+			rt.src = r.Src.String()
+		}
+		if rt.protocol == "" {
+			rt.protocol = strconv.Itoa(int(r.Protocol))
+		}
+		res = append(res, rt)
+	}
+	return res, nil
+}
+
+// listIPTables returns the tables of the legacy iptables of the network namespace of pid. The kernel only lists
+// tables that are in use.
+func listIPTables(pid int) []string {
+	var tables []string
+	for _, f := range []struct{ file, prefix string }{
+		{"ip_tables_names", ""},
+		{"ip6_tables_names", "ip6:"},
+	} {
+		file, err := os.Open(filepath.Join(host.HostProcFs, strconv.Itoa(pid), "net", f.file))
+		if err != nil {
+			// The file only exists if the module of iptables is loaded
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if name := strings.TrimSpace(scanner.Text()); name != "" {
+				tables = append(tables, f.prefix+name)
+			}
+		}
+		file.Close()
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// nftablesFamilies are the names of the families of nftables tables, like "nft list tables" shows them
+var nftablesFamilies = map[uint8]string{
+	unix.NFPROTO_INET:   "inet",
+	unix.NFPROTO_IPV4:   "ip",
+	unix.NFPROTO_ARP:    "arp",
+	unix.NFPROTO_NETDEV: "netdev",
+	unix.NFPROTO_BRIDGE: "bridge",
+	unix.NFPROTO_IPV6:   "ip6",
+}
+
+// listNFTables returns the tables of nftables of the network namespace ns; reading them requires CAP_NET_ADMIN
+func listNFTables(ns netns.NsHandle) ([]string, error) {
+	s, err := nl.GetNetlinkSocketAt(ns, netns.None(), unix.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, fmt.Errorf("opening netfilter socket: %w", err)
+	}
+	defer s.Close()
+	if err := s.SetReceiveTimeout(&nl.SocketTimeoutTv); err != nil {
+		return nil, fmt.Errorf("setting timeout of netfilter socket: %w", err)
+	}
+
+	req := nl.NewNetlinkRequest(unix.NFNL_SUBSYS_NFTABLES<<8|unix.NFT_MSG_GETTABLE, unix.NLM_F_DUMP)
+	req.AddData(&nl.Nfgenmsg{NfgenFamily: unix.NFPROTO_UNSPEC, Version: unix.NFNETLINK_V0})
+	req.Sockets = map[int]*nl.SocketHandle{unix.NETLINK_NETFILTER: {Socket: s}}
+	msgs, err := req.Execute(unix.NETLINK_NETFILTER, unix.NFNL_SUBSYS_NFTABLES<<8|unix.NFT_MSG_NEWTABLE)
+	if err != nil {
+		if errors.Is(err, unix.EPERM) {
+			return nil, fmt.Errorf("listing nftables tables requires CAP_NET_ADMIN: %w", err)
+		}
+		return nil, fmt.Errorf("listing nftables tables: %w", err)
+	}
+
+	var tables []string
+	for _, msg := range msgs {
+		if len(msg) < nl.SizeofNfgenmsg {
+			continue
+		}
+		attrs, err := nl.ParseRouteAttr(msg[nl.SizeofNfgenmsg:])
+		if err != nil {
+			return nil, fmt.Errorf("parsing nftables table: %w", err)
+		}
+		for _, a := range attrs {
+			if a.Attr.Type != unix.NFTA_TABLE_NAME {
+				continue
+			}
+			family, ok := nftablesFamilies[msg[0]]
+			if !ok {
+				family = strconv.Itoa(int(msg[0]))
+			}
+			tables = append(tables, family+" "+strings.TrimRight(string(a.Value), "\x00"))
+		}
+	}
+	sort.Strings(tables)
+	return tables, nil
+}
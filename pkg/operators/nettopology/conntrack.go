@@ -0,0 +1,136 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nettopology
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+	netnsig "github.com/inspektor-gadget/inspektor-gadget/pkg/utils/netns"
+)
+
+func addConntrackFields(s *entrySource) error {
+	for _, f := range []struct {
+		name, description string
+		kind              api.Kind
+		flags             datasource.FieldFlag
+	}{
+		{"proto", "Protocol of the connection", api.Kind_String, 0},
+		{"src", "Source address of the original direction", api.Kind_String, 0},
+		{"sport", "Source port of the original direction", api.Kind_Uint16, 0},
+		{"dst", "Destination address of the original direction", api.Kind_String, 0},
+		{"dport", "Destination port of the original direction", api.Kind_Uint16, 0},
+		{"reply_src", "Source address of the reply direction; it differs from dst if the destination is translated", api.Kind_String, 0},
+		{"reply_sport", "Source port of the reply direction", api.Kind_Uint16, 0},
+		{"reply_dst", "Destination address of the reply direction; it differs from src if the source is translated", api.Kind_String, 0},
+		{"reply_dport", "Destination port of the reply direction", api.Kind_Uint16, 0},
+		{"nat", "Network address translation applied to the connection: snat, dnat or both", api.Kind_String, 0},
+		{"packets", "Packets of the original direction, if accounting is enabled", api.Kind_Uint64, datasource.FieldFlagHidden},
+		{"bytes", "Bytes of the original direction, if accounting is enabled", api.Kind_Uint64, datasource.FieldFlagHidden},
+		{"reply_packets", "Packets of the reply direction, if accounting is enabled", api.Kind_Uint64, datasource.FieldFlagHidden},
+		{"reply_bytes", "Bytes of the reply direction, if accounting is enabled", api.Kind_Uint64, datasource.FieldFlagHidden},
+		{"mark", "Mark of the connection", api.Kind_Uint32, datasource.FieldFlagHidden},
+		{"timeout", "Seconds until the entry expires", api.Kind_Uint32, 0},
+	} {
+		if err := s.add(f.name, f.description, f.kind, datasource.WithFlags(f.flags)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listConntrack returns the entries of the conntrack table of the network namespace of the process with the given
+// pid; it requires CAP_NET_ADMIN
+func listConntrack(pid int) ([]*netlink.ConntrackFlow, error) {
+	ns, err := netnsig.GetFromPidWithAltProcfs(pid, host.HostProcFs)
+	if err != nil {
+		return nil, fmt.Errorf("getting network namespace of pid %d: %w", pid, err)
+	}
+	defer ns.Close()
+
+	h, err := netlink.NewHandleAt(ns, unix.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, fmt.Errorf("opening netlink socket: %w", err)
+	}
+	defer h.Close()
+
+	var flows []*netlink.ConntrackFlow
+	for _, family := range []netlink.InetFamily{unix.AF_INET, unix.AF_INET6} {
+		f, err := h.ConntrackTableList(netlink.ConntrackTable, family)
+		switch {
+		case errors.Is(err, unix.EPERM):
+			return nil, fmt.Errorf("listing conntrack entries requires CAP_NET_ADMIN: %w", err)
+		case err != nil:
+			return nil, fmt.Errorf("listing conntrack entries: %w", err)
+		}
+		flows = append(flows, f...)
+	}
+	return flows, nil
+}
+
+// natTypes tells which addresses of a connection are translated, by comparing the reply direction to the original
+// one
+func natTypes(flow *netlink.ConntrackFlow) string {
+	var types []string
+	if !flow.Reverse.DstIP.Equal(flow.Forward.SrcIP) || flow.Reverse.DstPort != flow.Forward.SrcPort {
+		types = append(types, "snat")
+	}
+	if !flow.Reverse.SrcIP.Equal(flow.Forward.DstIP) || flow.Reverse.SrcPort != flow.Forward.DstPort {
+		types = append(types, "dnat")
+	}
+	return strings.Join(types, ",")
+}
+
+func conntrackEntries(n *netnsState) ([]map[string]any, error) {
+	flows, err := listConntrack(n.pid)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]map[string]any, 0, len(flows))
+	for _, flow := range flows {
+		proto, ok := nl.L4ProtoMap[flow.Forward.Protocol]
+		if !ok {
+			proto = strconv.Itoa(int(flow.Forward.Protocol))
+		}
+		res = append(res, map[string]any{
+			"proto":         proto,
+			"src":           flow.Forward.SrcIP.String(),
+			"sport":         flow.Forward.SrcPort,
+			"dst":           flow.Forward.DstIP.String(),
+			"dport":         flow.Forward.DstPort,
+			"reply_src":     flow.Reverse.SrcIP.String(),
+			"reply_sport":   flow.Reverse.SrcPort,
+			"reply_dst":     flow.Reverse.DstIP.String(),
+			"reply_dport":   flow.Reverse.DstPort,
+			"nat":           natTypes(flow),
+			"packets":       flow.Forward.Packets,
+			"bytes":         flow.Forward.Bytes,
+			"reply_packets": flow.Reverse.Packets,
+			"reply_bytes":   flow.Reverse.Bytes,
+			"mark":          flow.Mark,
+			"timeout":       flow.TimeOut,
+		})
+	}
+	return res, nil
+}
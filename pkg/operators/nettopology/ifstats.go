@@ -0,0 +1,138 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nettopology
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+	netnsig "github.com/inspektor-gadget/inspektor-gadget/pkg/utils/netns"
+)
+
+// ifaceKey identifies an interface within a network namespace; the name is part of it because indexes are reused
+type ifaceKey struct {
+	index int
+	name  string
+}
+
+// ifaceCounters are the counters of an interface, in the order of the fields of the data source
+type ifaceCounters [8]uint64
+
+var ifaceCounterFields = [len(ifaceCounters{})]struct {
+	name, description string
+}{
+	{"rx_bytes", "Bytes received"},
+	{"tx_bytes", "Bytes sent"},
+	{"rx_packets", "Packets received"},
+	{"tx_packets", "Packets sent"},
+	{"rx_dropped", "Received packets dropped, e.g. because of missing buffers"},
+	{"tx_dropped", "Packets dropped while sending"},
+	{"rx_errors", "Bad packets received"},
+	{"tx_errors", "Errors while sending packets"},
+}
+
+func addInterfaceStatsFields(s *entrySource) error {
+	if err := s.addAnnotated("name", "Name of the interface", api.Kind_String,
+		map[string]string{"metrics.type": "key"}); err != nil {
+		return err
+	}
+	if err := s.add("index", "Index of the interface", api.Kind_Uint32, datasource.WithFlags(datasource.FieldFlagHidden)); err != nil {
+		return err
+	}
+	for _, f := range ifaceCounterFields {
+		if err := s.addAnnotated(f.name, f.description+" during the interval", api.Kind_Uint64,
+			map[string]string{"metrics.type": "counter"}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listInterfaceCounters returns the counters of the interfaces of the network namespace of the process with the given
+// pid
+func listInterfaceCounters(pid int) (map[ifaceKey]ifaceCounters, error) {
+	ns, err := netnsig.GetFromPidWithAltProcfs(pid, host.HostProcFs)
+	if err != nil {
+		return nil, fmt.Errorf("getting network namespace of pid %d: %w", pid, err)
+	}
+	defer ns.Close()
+
+	h, err := netlink.NewHandleAt(ns, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("opening netlink socket: %w", err)
+	}
+	defer h.Close()
+
+	links, err := h.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("listing interfaces: %w", err)
+	}
+	counters := make(map[ifaceKey]ifaceCounters, len(links))
+	for _, l := range links {
+		attrs := l.Attrs()
+		st := attrs.Statistics
+		if st == nil {
+			continue
+		}
+		counters[ifaceKey{index: attrs.Index, name: attrs.Name}] = ifaceCounters{
+			st.RxBytes, st.TxBytes, st.RxPackets, st.TxPackets,
+			st.RxDropped, st.TxDropped, st.RxErrors, st.TxErrors,
+		}
+	}
+	return counters, nil
+}
+
+// counterIncrease returns how much a counter increased from prev to cur; it was reset if it got smaller
+func counterIncrease(prev, cur uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// interfaceStatsEntries returns how much the counters of the interfaces increased since the last poll. Interfaces
+// that weren't there at the last poll are only reported from the next one on.
+func interfaceStatsEntries(n *netnsState) ([]map[string]any, error) {
+	cur, err := listInterfaceCounters(n.pid)
+	if err != nil {
+		return nil, err
+	}
+	prev := n.counters[n.id]
+	n.counters[n.id] = cur
+
+	res := make([]map[string]any, 0, len(cur))
+	for key, c := range cur {
+		p, ok := prev[key]
+		if !ok {
+			continue
+		}
+		entry := map[string]any{
+			"name":  key.name,
+			"index": uint32(key.index),
+		}
+		for i, f := range ifaceCounterFields {
+			entry[f.name] = counterIncrease(p[i], c[i])
+		}
+		res = append(res, entry)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i]["index"].(uint32) < res[j]["index"].(uint32) })
+	return res, nil
+}
@@ -0,0 +1,526 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nettopology provides a data operator that takes snapshots of the network namespaces of containers. The image
+// "operator:snapshot_netns" emits their network configuration: their interfaces, including veth pairs and bridges,
+// their routes and whether iptables or nftables are in use. The image "operator:snapshot_conntrack" emits the entries
+// of their conntrack tables. Like the snapshotters of gadgets, the entries are emitted once when starting, and again
+// whenever a snapshot is requested, e.g. by the snapshotdiff operator. The image "operator:top_interfaces" instead
+// polls the counters of their interfaces at an interval and emits how much they increased, without attaching any eBPF
+// program.
+package nettopology
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	containerutils "github.com/inspektor-gadget/inspektor-gadget/pkg/container-utils"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource/compat"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	OperatorName = "nettopology"
+
+	// ImageName is the name of the image taking snapshots of the network configuration
+	ImageName = operators.OperatorImagePrefix + "snapshot_netns"
+
+	// ConntrackImageName is the name of the image taking snapshots of the conntrack tables
+	ConntrackImageName = operators.OperatorImagePrefix + "snapshot_conntrack"
+
+	// InterfaceStatsImageName is the name of the image polling the counters of the interfaces
+	InterfaceStatsImageName = operators.OperatorImagePrefix + "top_interfaces"
+
+	// Names of the data sources: one entry per network namespace, interface, route and conntrack entry, and one per
+	// interface and interval
+	NetnsDataSourceName          = "netns"
+	InterfacesDataSourceName     = "interfaces"
+	RoutesDataSourceName         = "routes"
+	ConntrackDataSourceName      = "conntrack"
+	InterfaceStatsDataSourceName = "interface_stats"
+
+	ParamInterval = "interface-stats-interval"
+)
+
+// sourceSpec describes a data source filled by the operator
+type sourceSpec struct {
+	name      string
+	addFields func(*entrySource) error
+	// entries returns the values of the entries of a network namespace by the names of the fields
+	entries func(*netnsState) ([]map[string]any, error)
+	// polled sources are emitted at the interval given by ParamInterval instead of being snapshotted
+	polled bool
+}
+
+// images are the data sources of the images handled by the operator, by the name of the image
+var images = map[string][]sourceSpec{
+	ImageName: {
+		{NetnsDataSourceName, addNetnsFields, netnsEntries, false},
+		{InterfacesDataSourceName, addInterfaceFields, interfaceEntries, false},
+		{RoutesDataSourceName, addRouteFields, routeEntries, false},
+	},
+	ConntrackImageName: {
+		{ConntrackDataSourceName, addConntrackFields, conntrackEntries, false},
+	},
+	InterfaceStatsImageName: {
+		{InterfaceStatsDataSourceName, addInterfaceStatsFields, interfaceStatsEntries, true},
+	},
+}
+
+// IsNetTopologyImage tells whether imageName refers to the snapshots of network namespaces taken by the operator
+func IsNetTopologyImage(imageName string) bool {
+	_, ok := images[imageName]
+	return ok
+}
+
+type netTopologyOperator struct{}
+
+func (o *netTopologyOperator) Name() string {
+	return OperatorName
+}
+
+func (o *netTopologyOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *netTopologyOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *netTopologyOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:          ParamInterval,
+			Title:        "Interface statistics interval",
+			Description:  "Interval at which " + InterfaceStatsImageName + " polls the counters of the interfaces",
+			DefaultValue: "1s",
+			TypeHint:     api.TypeDuration,
+		},
+	}
+}
+
+func (o *netTopologyOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	if !IsNetTopologyImage(gadgetCtx.ImageName()) {
+		return nil, nil
+	}
+
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	if err := params.CopyFromMap(instanceParamValues, ""); err != nil {
+		return nil, err
+	}
+
+	inst := &netTopologyOperatorInstance{
+		logger:     gadgetCtx.Logger(),
+		containers: make(map[string]*containercollection.Container),
+		interval:   params.Get(ParamInterval).AsDuration(),
+		counters:   make(map[uint64]map[ifaceKey]ifaceCounters),
+	}
+	if inst.interval <= 0 {
+		return nil, fmt.Errorf("%s must be positive", ParamInterval)
+	}
+	snapshotFuncs := make(map[string]operators.SnapshotFunc)
+	for _, s := range images[gadgetCtx.ImageName()] {
+		ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, s.name)
+		if err != nil {
+			return nil, fmt.Errorf("adding data source %q: %w", s.name, err)
+		}
+		src := &entrySource{ds: ds, fields: make(map[string]datasource.FieldAccessor), entries: s.entries}
+		if err := src.add("netns_id", "Network namespace inode id", api.Kind_Uint64,
+			datasource.WithTags(compat.NetNsIdType), datasource.WithAnnotations(map[string]string{"columns.template": "ns"})); err != nil {
+			return nil, fmt.Errorf("adding fields to data source %q: %w", s.name, err)
+		}
+		if err := s.addFields(src); err != nil {
+			return nil, fmt.Errorf("adding fields to data source %q: %w", s.name, err)
+		}
+		if s.polled {
+			inst.polled = append(inst.polled, src)
+			continue
+		}
+		inst.sources = append(inst.sources, src)
+		snapshotFuncs[s.name] = func() error { return inst.snapshot(src) }
+	}
+
+	// The managers attach the selected containers to the instance of the gadget
+	gadgetCtx.SetVar("ebpfInstance", inst)
+	if len(snapshotFuncs) > 0 {
+		gadgetCtx.SetVar(operators.SnapshottersVar, snapshotFuncs)
+	}
+	return inst, nil
+}
+
+func (o *netTopologyOperator) Priority() int {
+	return operators.OperatorImagePriority
+}
+
+// entrySource is a data source with its fields by name
+type entrySource struct {
+	ds     datasource.DataSource
+	fields map[string]datasource.FieldAccessor
+	// names are the names of the fields in the order they were added
+	names   []string
+	entries func(*netnsState) ([]map[string]any, error)
+}
+
+func (s *entrySource) add(name, description string, kind api.Kind, opts ...datasource.FieldOption) error {
+	return s.addAnnotated(name, description, kind, nil, opts...)
+}
+
+func (s *entrySource) addAnnotated(name, description string, kind api.Kind, annotations map[string]string, opts ...datasource.FieldOption) error {
+	annotations = maps.Clone(annotations)
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations["description"] = description
+	opts = append(opts, datasource.WithKind(kind), datasource.WithAnnotations(annotations))
+	f, err := s.ds.AddField(name, opts...)
+	if err != nil {
+		return err
+	}
+	s.fields[name] = f
+	s.names = append(s.names, name)
+	return nil
+}
+
+func addNetnsFields(s *entrySource) error {
+	for _, f := range []struct {
+		name, description string
+		kind              api.Kind
+	}{
+		{"interfaces", "Number of interfaces", api.Kind_Uint32},
+		{"veths", "Number of veth interfaces", api.Kind_Uint32},
+		{"bridges", "Number of bridges", api.Kind_Uint32},
+		{"routes", "Number of routes, without the ones of the local table", api.Kind_Uint32},
+		{"iptables", "Tables of the legacy iptables in use; the ones of ip6tables are prefixed with ip6:", api.Kind_String},
+		{"nftables", "Tables of nftables with their family, including the ones of iptables-nft", api.Kind_String},
+	} {
+		if err := s.add(f.name, f.description, f.kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addInterfaceFields(s *entrySource) error {
+	for _, f := range []struct {
+		name, description string
+		kind              api.Kind
+		flags             datasource.FieldFlag
+	}{
+		{"name", "Name of the interface", api.Kind_String, 0},
+		{"index", "Index of the interface", api.Kind_Uint32, 0},
+		{"type", "Type of the interface, like veth, bridge or device", api.Kind_String, 0},
+		{"up", "Whether the interface is up", api.Kind_Bool, 0},
+		{"mtu", "MTU of the interface", api.Kind_Uint32, datasource.FieldFlagHidden},
+		{"mac", "MAC address of the interface", api.Kind_String, datasource.FieldFlagHidden},
+		{"master", "Bridge or bond the interface is attached to", api.Kind_String, 0},
+		{"peer_index", "Index of the other end of a veth pair, usually in another network namespace", api.Kind_Uint32, 0},
+		{"addresses", "Addresses of the interface", api.Kind_String, 0},
+	} {
+		if err := s.add(f.name, f.description, f.kind, datasource.WithFlags(f.flags)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addRouteFields(s *entrySource) error {
+	for _, f := range []struct {
+		name, description string
+		kind              api.Kind
+		flags             datasource.FieldFlag
+	}{
+		{"dst", "Destination of the route", api.Kind_String, 0},
+		{"gateway", "Gateway of the route", api.Kind_String, 0},
+		{"dev", "Interface of the route", api.Kind_String, 0},
+		{"src", "Preferred source address of the route", api.Kind_String, datasource.FieldFlagHidden},
+		{"table", "Routing table of the route", api.Kind_Uint32, 0},
+		{"protocol", "Origin of the route, like kernel, boot or static", api.Kind_String, datasource.FieldFlagHidden},
+	} {
+		if err := s.add(f.name, f.description, f.kind, datasource.WithFlags(f.flags)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type netTopologyOperatorInstance struct {
+	logger  logger.Logger
+	sources []*entrySource
+	polled  []*entrySource
+
+	interval time.Duration
+	// counters are the counters of the interfaces at the last poll, by network namespace; they are only used by the
+	// goroutine polling them
+	counters map[uint64]map[ifaceKey]ifaceCounters
+
+	mu         sync.Mutex
+	containers map[string]*containercollection.Container
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (o *netTopologyOperatorInstance) Name() string {
+	return OperatorName
+}
+
+func (o *netTopologyOperatorInstance) AttachContainer(container *containercollection.Container) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// The host is attached as a container without ID
+	o.containers[container.Runtime.ContainerID] = container
+	return nil
+}
+
+func (o *netTopologyOperatorInstance) DetachContainer(container *containercollection.Container) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.containers, container.Runtime.ContainerID)
+	return nil
+}
+
+func (o *netTopologyOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	if err := o.snapshot(o.sources...); err != nil {
+		return err
+	}
+	if len(o.polled) == 0 {
+		return nil
+	}
+
+	// The first poll only records the counters the increases are calculated from
+	if err := o.snapshot(o.polled...); err != nil {
+		return err
+	}
+	o.done = make(chan struct{})
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		ticker := time.NewTicker(o.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-o.done:
+				return
+			case <-gadgetCtx.Context().Done():
+				return
+			case <-ticker.C:
+				if err := o.snapshot(o.polled...); err != nil {
+					o.logger.Warnf("polling interface statistics: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (o *netTopologyOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	if o.done != nil {
+		close(o.done)
+		o.wg.Wait()
+		o.done = nil
+	}
+	return nil
+}
+
+// namespaces returns a pid in each network namespace of the attached containers, by the inode id of the namespace
+func (o *netTopologyOperatorInstance) namespaces() map[uint64]int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	namespaces := make(map[uint64]int)
+	for _, c := range o.containers {
+		netns := c.Netns
+		if netns == 0 {
+			var err error
+			if netns, err = containerutils.GetNetNs(int(c.Pid)); err != nil {
+				o.logger.Warnf("getting network namespace of pid %d: %v", c.Pid, err)
+				continue
+			}
+		}
+		namespaces[netns] = int(c.Pid)
+	}
+	return namespaces
+}
+
+// snapshot emits the entries of the given data sources for all network namespaces
+func (o *netTopologyOperatorInstance) snapshot(sources ...*entrySource) error {
+	namespaces := o.namespaces()
+	ids := make([]uint64, 0, len(namespaces))
+	for id := range namespaces {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		state := &netnsState{id: id, pid: namespaces[id], logger: o.logger, counters: o.counters}
+		for _, s := range sources {
+			entries, err := s.entries(state)
+			if err != nil {
+				// The container might have terminated in the meantime
+				o.logger.Warnf("taking snapshot of data source %q in network namespace %d: %v", s.ds.Name(), id, err)
+				continue
+			}
+			for _, entry := range entries {
+				entry["netns_id"] = id
+				if err := s.emit(entry); err != nil {
+					return fmt.Errorf("emitting entry of data source %q: %w", s.ds.Name(), err)
+				}
+			}
+		}
+	}
+
+	// Forget the counters of network namespaces that are gone
+	for id := range o.counters {
+		if _, ok := namespaces[id]; !ok {
+			delete(o.counters, id)
+		}
+	}
+	return nil
+}
+
+// netnsState collects the state of a network namespace for a snapshot. The network configuration is only collected
+// once, even if several data sources use it.
+type netnsState struct {
+	id     uint64
+	pid    int
+	logger logger.Logger
+	// counters are the counters of the interfaces of all network namespaces at the last poll
+	counters map[uint64]map[ifaceKey]ifaceCounters
+
+	topo    *topology
+	topoErr error
+}
+
+func (n *netnsState) topology() (*topology, error) {
+	if n.topo == nil && n.topoErr == nil {
+		n.topo, n.topoErr = collectTopology(n.pid)
+		if n.topo != nil && n.topo.nftablesErr != nil {
+			n.logger.Debugf("pid %d: %v", n.pid, n.topo.nftablesErr)
+		}
+	}
+	return n.topo, n.topoErr
+}
+
+func netnsEntries(n *netnsState) ([]map[string]any, error) {
+	t, err := n.topology()
+	if err != nil {
+		return nil, err
+	}
+	var veths, bridges uint32
+	for _, i := range t.interfaces {
+		switch i.typ {
+		case "veth":
+			veths++
+		case "bridge":
+			bridges++
+		}
+	}
+	return []map[string]any{{
+		"interfaces": uint32(len(t.interfaces)),
+		"veths":      veths,
+		"bridges":    bridges,
+		"routes":     uint32(len(t.routes)),
+		"iptables":   strings.Join(t.iptables, ","),
+		"nftables":   strings.Join(t.nftables, ","),
+	}}, nil
+}
+
+func interfaceEntries(n *netnsState) ([]map[string]any, error) {
+	t, err := n.topology()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]map[string]any, 0, len(t.interfaces))
+	for _, i := range t.interfaces {
+		res = append(res, map[string]any{
+			"name":       i.name,
+			"index":      uint32(i.index),
+			"type":       i.typ,
+			"up":         i.up,
+			"mtu":        uint32(i.mtu),
+			"mac":        i.mac,
+			"master":     i.master,
+			"peer_index": uint32(i.peerIndex),
+			"addresses":  strings.Join(i.addresses, ","),
+		})
+	}
+	return res, nil
+}
+
+func routeEntries(n *netnsState) ([]map[string]any, error) {
+	t, err := n.topology()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]map[string]any, 0, len(t.routes))
+	for _, r := range t.routes {
+		res = append(res, map[string]any{
+			"dst":      r.dst,
+			"gateway":  r.gateway,
+			"dev":      r.dev,
+			"src":      r.src,
+			"table":    uint32(r.table),
+			"protocol": r.protocol,
+		})
+	}
+	return res, nil
+}
+
+func (s *entrySource) emit(entry map[string]any) error {
+	data := s.ds.NewData()
+	bo := s.ds.ByteOrder()
+	for _, name := range s.names {
+		var b []byte
+		switch v := entry[name].(type) {
+		case string:
+			b = []byte(v)
+		case bool:
+			b = []byte{0}
+			if v {
+				b[0] = 1
+			}
+		case uint16:
+			b = make([]byte, 2)
+			bo.PutUint16(b, v)
+		case uint32:
+			b = make([]byte, 4)
+			bo.PutUint32(b, v)
+		case uint64:
+			b = make([]byte, 8)
+			bo.PutUint64(b, v)
+		default:
+			s.ds.Release(data)
+			return fmt.Errorf("unexpected value %v of field %q", v, name)
+		}
+		if err := s.fields[name].Set(data, b); err != nil {
+			s.ds.Release(data)
+			return fmt.Errorf("setting field %q: %w", name, err)
+		}
+	}
+	return s.ds.EmitAndRelease(data)
+}
+
+func init() {
+	operators.RegisterOperatorImages(IsNetTopologyImage)
+	operators.RegisterDataOperator(&netTopologyOperator{})
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nettopology
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	containerutils "github.com/inspektor-gadget/inspektor-gadget/pkg/container-utils"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/types"
+)
+
+func TestCollectTopology(t *testing.T) {
+	topo, err := collectTopology(os.Getpid())
+	require.NoError(t, err)
+
+	var lo *iface
+	for i := range topo.interfaces {
+		if topo.interfaces[i].name == "lo" {
+			lo = &topo.interfaces[i]
+		}
+	}
+	require.NotNil(t, lo, "loopback interface not found")
+	require.Equal(t, "device", lo.typ)
+	for _, r := range topo.routes {
+		require.NotEqual(t, 255, r.table, "routes of the local table must be skipped")
+	}
+}
+
+func TestNetTopologyOperator(t *testing.T) {
+	op := &netTopologyOperator{}
+
+	// Other images aren't handled
+	inst, err := op.InstantiateDataOperator(gadgetcontext.New(context.Background(), "trace_exec"), api.ParamValues{})
+	require.NoError(t, err)
+	require.Nil(t, inst)
+
+	gadgetCtx := gadgetcontext.New(context.Background(), ImageName)
+	inst, err = op.InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+	require.NoError(t, err)
+	topoInst := inst.(*netTopologyOperatorInstance)
+
+	v, ok := gadgetCtx.GetVar(operators.SnapshottersVar)
+	require.True(t, ok)
+	snapshotters := v.(map[string]operators.SnapshotFunc)
+	require.Len(t, snapshotters, 3)
+
+	netnsID, err := containerutils.GetNetNs(os.Getpid())
+	require.NoError(t, err)
+
+	dataSources := gadgetCtx.GetDataSources()
+	counts := make(map[string]int)
+	var names []string
+	for _, name := range []string{NetnsDataSourceName, InterfacesDataSourceName, RoutesDataSourceName} {
+		ds := dataSources[name]
+		require.NotNil(t, ds, name)
+		netns := ds.GetField("netns_id")
+		nameField := ds.GetField("name")
+		ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+			require.Equal(t, netnsID, netns.Uint64(data))
+			counts[ds.Name()]++
+			if ds.Name() == InterfacesDataSourceName {
+				names = append(names, nameField.String(data))
+			}
+			return nil
+		}, 0)
+	}
+
+	// Containers in the same network namespace are only reported once
+	require.NoError(t, topoInst.AttachContainer(&containercollection.Container{Pid: uint32(os.Getpid())}))
+	require.NoError(t, topoInst.AttachContainer(&containercollection.Container{
+		Runtime: containercollection.RuntimeMetadata{BasicRuntimeMetadata: types.BasicRuntimeMetadata{ContainerID: "abc"}},
+		Pid:     uint32(os.Getpid()),
+		Netns:   netnsID,
+	}))
+	require.NoError(t, topoInst.Start(gadgetCtx))
+	require.Equal(t, 1, counts[NetnsDataSourceName])
+	require.Contains(t, names, "lo")
+
+	// Snapshots can be taken again, e.g. by the snapshotdiff operator
+	require.NoError(t, snapshotters[NetnsDataSourceName]())
+	require.Equal(t, 2, counts[NetnsDataSourceName])
+	require.Equal(t, len(names), counts[InterfacesDataSourceName])
+
+	require.NoError(t, topoInst.DetachContainer(&containercollection.Container{Pid: uint32(os.Getpid())}))
+	require.NoError(t, topoInst.DetachContainer(&containercollection.Container{
+		Runtime: containercollection.RuntimeMetadata{BasicRuntimeMetadata: types.BasicRuntimeMetadata{ContainerID: "abc"}},
+	}))
+	require.NoError(t, snapshotters[NetnsDataSourceName]())
+	require.Equal(t, 2, counts[NetnsDataSourceName])
+}
+
+func TestConntrack(t *testing.T) {
+	flow := &netlink.ConntrackFlow{}
+	flow.Forward.SrcIP, flow.Forward.SrcPort = net.ParseIP("10.0.0.2"), 40000
+	flow.Forward.DstIP, flow.Forward.DstPort = net.ParseIP("10.96.0.10"), 53
+	flow.Reverse.SrcIP, flow.Reverse.SrcPort = net.ParseIP("10.0.0.2"), 40000
+	flow.Reverse.DstIP, flow.Reverse.DstPort = net.ParseIP("10.96.0.10"), 53
+	require.Equal(t, "snat,dnat", natTypes(flow))
+
+	// A service IP translated to the address of a pod
+	flow.Reverse.SrcIP, flow.Reverse.SrcPort = net.ParseIP("10.244.1.5"), 5353
+	flow.Reverse.DstIP, flow.Reverse.DstPort = net.ParseIP("10.0.0.2"), 40000
+	require.Equal(t, "dnat", natTypes(flow))
+
+	flow.Reverse.SrcIP, flow.Reverse.SrcPort = flow.Forward.DstIP, flow.Forward.DstPort
+	require.Equal(t, "", natTypes(flow))
+
+	gadgetCtx := gadgetcontext.New(context.Background(), ConntrackImageName)
+	inst, err := (&netTopologyOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+	require.NoError(t, err)
+	require.Len(t, gadgetCtx.GetDataSources(), 1)
+	require.NotNil(t, gadgetCtx.GetDataSources()[ConntrackDataSourceName])
+	require.Len(t, inst.(*netTopologyOperatorInstance).sources, 1)
+
+	// Listing the entries requires CAP_NET_ADMIN and conntrack to be available
+	if _, err := listConntrack(os.Getpid()); err != nil {
+		t.Skipf("listing conntrack entries: %v", err)
+	}
+	require.NoError(t, inst.(*netTopologyOperatorInstance).AttachContainer(&containercollection.Container{Pid: uint32(os.Getpid())}))
+	require.NoError(t, inst.(*netTopologyOperatorInstance).Start(gadgetCtx))
+}
+
+func TestInterfaceStats(t *testing.T) {
+	require.Equal(t, uint64(5), counterIncrease(10, 15))
+	// The counter was reset, e.g. because the interface was recreated
+	require.Equal(t, uint64(3), counterIncrease(10, 3))
+
+	_, err := (&netTopologyOperator{}).InstantiateDataOperator(gadgetcontext.New(context.Background(), InterfaceStatsImageName),
+		api.ParamValues{ParamInterval: "0s"})
+	require.Error(t, err)
+
+	gadgetCtx := gadgetcontext.New(context.Background(), InterfaceStatsImageName)
+	inst, err := (&netTopologyOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{ParamInterval: "1h"})
+	require.NoError(t, err)
+	statsInst := inst.(*netTopologyOperatorInstance)
+	require.Len(t, statsInst.polled, 1)
+	require.Empty(t, statsInst.sources)
+
+	// Counters aren't snapshotted
+	_, ok := gadgetCtx.GetVar(operators.SnapshottersVar)
+	require.False(t, ok)
+
+	ds := gadgetCtx.GetDataSources()[InterfaceStatsDataSourceName]
+	require.NotNil(t, ds)
+	nameField := ds.GetField("name")
+	var names []string
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		names = append(names, nameField.String(data))
+		return nil
+	}, 0)
+
+	require.NoError(t, statsInst.AttachContainer(&containercollection.Container{Pid: uint32(os.Getpid())}))
+	require.NoError(t, statsInst.Start(gadgetCtx))
+	defer statsInst.Stop(gadgetCtx)
+
+	// The first poll only records the counters
+	require.Empty(t, names)
+	require.NoError(t, statsInst.snapshot(statsInst.polled...))
+	require.Contains(t, names, "lo")
+
+	// The counters of network namespaces that are gone are forgotten
+	require.NoError(t, statsInst.DetachContainer(&containercollection.Container{Pid: uint32(os.Getpid())}))
+	require.NoError(t, statsInst.snapshot(statsInst.polled...))
+	require.Empty(t, statsInst.counters)
+}
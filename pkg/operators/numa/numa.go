@@ -0,0 +1,298 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package numa provides a data operator that adds the NUMA node of the CPU an event happened on, together with the
+// CPUs and memory nodes the process of the event is allowed to use. This helps finding latency issues caused by tasks
+// being scheduled far away from their memory.
+package numa
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/ioc"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+)
+
+// Keep this aligned with include/gadget/types.h
+const (
+	// CPUTypeName contains the name of the type that gadgets should use to store a CPU number
+	CPUTypeName = "gadget_cpu"
+)
+
+const (
+	DataOperatorName = "numa"
+
+	ParamEnrich = "enrich-numa"
+
+	// AnnotationPid marks the field containing the (host) pid of the process to add the affinity of. Fields named
+	// "pid" are used if no field is annotated.
+	AnnotationPid = "numa.pid"
+
+	// DataOperatorPriority is chosen so that the fields are available to the filter operator
+	DataOperatorPriority = ioc.Priority - 100
+
+	nodePath      = "/sys/devices/system/node"
+	maxProcesses  = 4096
+	affinitiesTTL = time.Second
+)
+
+type numaDataOperator struct {
+	nodePath string
+	// procPath is used instead of host.HostProcFs if set; the latter can still change after init()
+	procPath string
+	cache    *affinityCache
+}
+
+func (o *numaDataOperator) Name() string {
+	return DataOperatorName
+}
+
+func (o *numaDataOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *numaDataOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *numaDataOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:          ParamEnrich,
+			Title:        "Add NUMA context",
+			Description:  "Add the NUMA node of the CPU to events and the CPUs and memory nodes the process is allowed to use",
+			DefaultValue: "true",
+			TypeHint:     api.TypeBool,
+		},
+	}
+}
+
+// pidField returns the field containing the pid of the process, or nil if the data source doesn't reference one
+func pidField(ds datasource.DataSource) (datasource.FieldAccessor, error) {
+	var named datasource.FieldAccessor
+	for _, f := range ds.Accessors(false) {
+		if v, ok := f.Annotations()[AnnotationPid]; ok && v == "true" {
+			if f.Type() != api.Kind_Uint32 {
+				return nil, fmt.Errorf("field %q annotated with %q must be of type uint32", f.Name(), AnnotationPid)
+			}
+			return f, nil
+		}
+		if named == nil && f.Name() == "pid" && f.Type() == api.Kind_Uint32 {
+			named = f
+		}
+	}
+	return named, nil
+}
+
+// targetName returns the name of a field added for the CPU field f, e.g. "cpu" -> "numa_node" and "prev_cpu" ->
+// "prev_cpu_numa_node"
+func targetName(f datasource.FieldAccessor, suffix string) string {
+	if f.Name() == "cpu" {
+		return suffix
+	}
+	return f.Name() + "_" + suffix
+}
+
+type cpuField struct {
+	cpu   datasource.FieldAccessor
+	node  datasource.FieldAccessor
+	local datasource.FieldAccessor
+}
+
+type dataSourceFields struct {
+	pid         datasource.FieldAccessor
+	cpusAllowed datasource.FieldAccessor
+	memsAllowed datasource.FieldAccessor
+	cpus        []cpuField
+}
+
+func addField(ds datasource.DataSource, name string, kind api.Kind, description string, flags datasource.FieldFlag) (datasource.FieldAccessor, error) {
+	f, err := ds.AddField(name,
+		datasource.WithKind(kind),
+		datasource.WithAnnotations(map[string]string{
+			"description": description,
+		}),
+		datasource.WithFlags(flags),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("adding field %q: %w", name, err)
+	}
+	return f, nil
+}
+
+func (o *numaDataOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if !params.Get(ParamEnrich).AsBool() {
+		return nil, nil
+	}
+
+	inst := &numaDataOperatorInstance{
+		cache:    o.cache,
+		procPath: o.procPath,
+		fields:   make(map[datasource.DataSource]*dataSourceFields),
+	}
+	if inst.procPath == "" {
+		inst.procPath = host.HostProcFs
+	}
+
+	for _, ds := range gadgetCtx.GetDataSources() {
+		typed := ds.GetFieldsWithTag("type:" + CPUTypeName)
+		if len(typed) == 0 {
+			continue
+		}
+
+		pid, err := pidField(ds)
+		if err != nil {
+			return nil, err
+		}
+
+		fields := &dataSourceFields{pid: pid}
+		if pid != nil {
+			fields.cpusAllowed, err = addField(ds, "cpus_allowed", api.Kind_String,
+				"CPUs the process is allowed to run on", datasource.FieldFlagHidden)
+			if err != nil {
+				return nil, err
+			}
+			fields.memsAllowed, err = addField(ds, "mems_allowed", api.Kind_String,
+				"NUMA nodes the process is allowed to allocate memory on", datasource.FieldFlagHidden)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, f := range typed {
+			if f.Type() != api.Kind_Uint32 {
+				return nil, fmt.Errorf("CPU field %q must be of type uint32", f.Name())
+			}
+			cf := cpuField{cpu: f}
+			cf.node, err = addField(ds, targetName(f, "numa_node"), api.Kind_Uint32,
+				"NUMA node of the CPU", 0)
+			if err != nil {
+				return nil, err
+			}
+			if pid != nil {
+				cf.local, err = addField(ds, targetName(f, "numa_local"), api.Kind_Bool,
+					"Whether the process is allowed to allocate memory on the NUMA node of the CPU", datasource.FieldFlagHidden)
+				if err != nil {
+					return nil, err
+				}
+			}
+			fields.cpus = append(fields.cpus, cf)
+		}
+		inst.fields[ds] = fields
+	}
+
+	if len(inst.fields) == 0 {
+		return nil, nil
+	}
+
+	// CPUs are rarely brought online while a gadget is running, so the topology is only read once
+	inst.topology, err = loadTopology(o.nodePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading NUMA topology: %w", err)
+	}
+
+	return inst, nil
+}
+
+func (o *numaDataOperator) Priority() int {
+	return DataOperatorPriority
+}
+
+type numaDataOperatorInstance struct {
+	cache    *affinityCache
+	procPath string
+	topology map[uint32]uint32
+	fields   map[datasource.DataSource]*dataSourceFields
+}
+
+func (o *numaDataOperatorInstance) Name() string {
+	return DataOperatorName
+}
+
+func (o *numaDataOperatorInstance) enrich(gadgetCtx operators.GadgetContext, fields *dataSourceFields, data datasource.Data) error {
+	var aff *affinity
+	if fields.pid != nil {
+		if pid := fields.pid.Uint32(data); pid != 0 {
+			var err error
+			aff, err = o.cache.Get(o.procPath, pid)
+			if err != nil {
+				// The process might already be gone; in that case the fields are left empty
+				gadgetCtx.Logger().Debugf("numa: reading affinity of pid %d: %v", pid, err)
+			}
+		}
+		if aff != nil {
+			if err := fields.cpusAllowed.Set(data, []byte(aff.cpus)); err != nil {
+				return err
+			}
+			if err := fields.memsAllowed.Set(data, []byte(aff.mems)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, f := range fields.cpus {
+		// CPUs missing in the topology are on node 0, like on kernels without NUMA support
+		node := o.topology[f.cpu.Uint32(data)]
+		if err := f.node.Set(data, make([]byte, 4)); err != nil {
+			return err
+		}
+		f.node.PutUint32(data, node)
+		if f.local == nil {
+			continue
+		}
+		if err := f.local.Set(data, make([]byte, 1)); err != nil {
+			return err
+		}
+		f.local.PutBool(data, aff != nil && aff.local(node))
+	}
+	return nil
+}
+
+func (o *numaDataOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error {
+	for ds, fields := range o.fields {
+		fields := fields
+		ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+			return o.enrich(gadgetCtx, fields, data)
+		}, DataOperatorPriority)
+	}
+	return nil
+}
+
+func (o *numaDataOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+func (o *numaDataOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+func init() {
+	// The cache is shared between all gadget instances
+	operators.RegisterDataOperator(&numaDataOperator{
+		nodePath: nodePath,
+		cache:    newAffinityCache(maxProcesses, affinitiesTTL),
+	})
+}
@@ -0,0 +1,202 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package numa
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func TestParseCPUList(t *testing.T) {
+	ids, err := parseCPUList("0-2,5,7-8\n")
+	require.NoError(t, err)
+	require.Equal(t, []uint32{0, 1, 2, 5, 7, 8}, ids)
+
+	ids, err = parseCPUList("")
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	for _, s := range []string{"a", "1-", "3-1"} {
+		_, err = parseCPUList(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestLoadTopology(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "node0", "cpulist"), "0-1\n")
+	writeFile(t, filepath.Join(dir, "node1", "cpulist"), "2-3\n")
+	writeFile(t, filepath.Join(dir, "possible"), "0-1\n")
+
+	topology, err := loadTopology(dir)
+	require.NoError(t, err)
+	require.Equal(t, map[uint32]uint32{0: 0, 1: 0, 2: 1, 3: 1}, topology)
+
+	// Kernels without NUMA support don't have the directory
+	topology, err = loadTopology(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	require.Empty(t, topology)
+}
+
+func TestAffinityCache(t *testing.T) {
+	procPath := t.TempDir()
+	status := filepath.Join(procPath, "42", "status")
+	writeFile(t, status, "Name:\ttest\nCpus_allowed:\tf\nCpus_allowed_list:\t0-3\nMems_allowed_list:\t0\n")
+
+	now := time.Now()
+	c := newAffinityCache(1, time.Minute)
+	c.now = func() time.Time { return now }
+
+	a, err := c.Get(procPath, 42)
+	require.NoError(t, err)
+	require.Equal(t, "0-3", a.cpus)
+	require.Equal(t, "0", a.mems)
+	require.True(t, a.local(0))
+	require.False(t, a.local(1))
+
+	// Changes are only picked up once the entry expired
+	writeFile(t, status, "Cpus_allowed_list:\t2-3\nMems_allowed_list:\t1\n")
+	a, err = c.Get(procPath, 42)
+	require.NoError(t, err)
+	require.Equal(t, "0-3", a.cpus)
+
+	now = now.Add(time.Minute)
+	a, err = c.Get(procPath, 42)
+	require.NoError(t, err)
+	require.Equal(t, "2-3", a.cpus)
+	require.True(t, a.local(1))
+
+	_, err = c.Get(procPath, 43)
+	require.Error(t, err)
+}
+
+func TestNumaDataOperator(t *testing.T) {
+	nodePath := t.TempDir()
+	writeFile(t, filepath.Join(nodePath, "node0", "cpulist"), "0-1\n")
+	writeFile(t, filepath.Join(nodePath, "node1", "cpulist"), "2-3\n")
+	procPath := t.TempDir()
+	writeFile(t, filepath.Join(procPath, "42", "status"), "Cpus_allowed_list:\t0-1\nMems_allowed_list:\t0\n")
+
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+	pid, err := ds.AddField("pid", datasource.WithKind(api.Kind_Uint32))
+	require.NoError(t, err)
+	cpu, err := ds.AddField("cpu", datasource.WithKind(api.Kind_Uint32), datasource.WithTags("type:"+CPUTypeName))
+	require.NoError(t, err)
+	prevCPU, err := ds.AddField("prev_cpu", datasource.WithKind(api.Kind_Uint32), datasource.WithTags("type:"+CPUTypeName))
+	require.NoError(t, err)
+
+	// Data sources without CPU fields are left alone
+	other, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "other")
+	require.NoError(t, err)
+	_, err = other.AddField("pid", datasource.WithKind(api.Kind_Uint32))
+	require.NoError(t, err)
+
+	op := &numaDataOperator{nodePath: nodePath, procPath: procPath, cache: newAffinityCache(maxProcesses, affinitiesTTL)}
+	inst, err := op.InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+	require.NoError(t, err)
+	require.NotNil(t, inst)
+	require.NoError(t, inst.(operators.PreStart).PreStart(gadgetCtx))
+	require.Nil(t, other.GetField("cpus_allowed"))
+
+	node := ds.GetField("numa_node")
+	require.NotNil(t, node)
+	local := ds.GetField("numa_local")
+	require.NotNil(t, local)
+	prevNode := ds.GetField("prev_cpu_numa_node")
+	require.NotNil(t, prevNode)
+	prevLocal := ds.GetField("prev_cpu_numa_local")
+	require.NotNil(t, prevLocal)
+	cpusAllowed := ds.GetField("cpus_allowed")
+	require.NotNil(t, cpusAllowed)
+	memsAllowed := ds.GetField("mems_allowed")
+	require.NotNil(t, memsAllowed)
+
+	type result struct {
+		node, prevNode   uint32
+		local, prevLocal bool
+		cpus, mems       string
+	}
+	var got result
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		got = result{
+			node:      node.Uint32(data),
+			prevNode:  prevNode.Uint32(data),
+			local:     local.Bool(data),
+			prevLocal: prevLocal.Bool(data),
+			cpus:      cpusAllowed.String(data),
+			mems:      memsAllowed.String(data),
+		}
+		return nil
+	}, DataOperatorPriority+1)
+
+	emit := func(p, c, prev uint32) {
+		data := ds.NewData()
+		for _, f := range []datasource.FieldAccessor{pid, cpu, prevCPU} {
+			require.NoError(t, f.Set(data, make([]byte, 4)))
+		}
+		pid.PutUint32(data, p)
+		cpu.PutUint32(data, c)
+		prevCPU.PutUint32(data, prev)
+		require.NoError(t, ds.EmitAndRelease(data))
+	}
+
+	// The task migrated from its local node to a remote one
+	emit(42, 3, 1)
+	require.Equal(t, result{node: 1, prevNode: 0, local: false, prevLocal: true, cpus: "0-1", mems: "0"}, got)
+
+	// Processes that are gone only get the nodes
+	emit(43, 2, 0)
+	require.Equal(t, result{node: 1, prevNode: 0}, got)
+}
+
+func TestNumaDataOperatorDisabled(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+	_, err = ds.AddField("cpu", datasource.WithKind(api.Kind_Uint32), datasource.WithTags("type:"+CPUTypeName))
+	require.NoError(t, err)
+
+	op := &numaDataOperator{nodePath: t.TempDir(), cache: newAffinityCache(maxProcesses, affinitiesTTL)}
+	inst, err := op.InstantiateDataOperator(gadgetCtx, api.ParamValues{ParamEnrich: "false"})
+	require.NoError(t, err)
+	require.Nil(t, inst)
+	require.Nil(t, ds.GetField("numa_node"))
+
+	// A wrongly typed CPU field is an error
+	gadgetCtx = gadgetcontext.New(context.Background(), "test")
+	ds, err = gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+	_, err = ds.AddField("cpu", datasource.WithKind(api.Kind_Uint64), datasource.WithTags("type:"+CPUTypeName))
+	require.NoError(t, err)
+	_, err = op.InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+	require.Error(t, err)
+}
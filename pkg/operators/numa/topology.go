@@ -0,0 +1,179 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package numa
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parseCPUList parses lists like "0-3,8,10-11" as used by the kernel for CPUs and memory nodes
+func parseCPUList(s string) ([]uint32, error) {
+	var ids []uint32
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.ParseUint(first, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("parsing list %q: %w", s, err)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.ParseUint(last, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("parsing list %q: %w", s, err)
+			}
+			if end < start {
+				return nil, fmt.Errorf("parsing list %q: invalid range %q", s, part)
+			}
+		}
+		for id := start; id <= end; id++ {
+			ids = append(ids, uint32(id))
+		}
+	}
+	return ids, nil
+}
+
+// loadTopology returns the NUMA node of every CPU, read from the node directories below nodePath (usually
+// /sys/devices/system/node). An empty map is returned if the kernel doesn't expose NUMA information; all CPUs are
+// on node 0 then.
+func loadTopology(nodePath string) (map[uint32]uint32, error) {
+	nodes, err := filepath.Glob(filepath.Join(nodePath, "node[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+	topology := make(map[uint32]uint32)
+	for _, dir := range nodes {
+		node, err := strconv.ParseUint(strings.TrimPrefix(filepath.Base(dir), "node"), 10, 32)
+		if err != nil {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, "cpulist"))
+		if err != nil {
+			return nil, err
+		}
+		cpus, err := parseCPUList(string(content))
+		if err != nil {
+			return nil, err
+		}
+		for _, cpu := range cpus {
+			topology[cpu] = uint32(node)
+		}
+	}
+	return topology, nil
+}
+
+// affinity contains the CPUs and memory nodes a process is allowed to use, as restricted by its cpuset cgroup and
+// sched_setaffinity()
+type affinity struct {
+	cpus     string
+	mems     string
+	memNodes []uint32
+	loaded   time.Time
+}
+
+// local returns whether memory of the process can be allocated on node
+func (a *affinity) local(node uint32) bool {
+	return slices.Contains(a.memNodes, node)
+}
+
+// loadAffinity reads the allowed CPUs and memory nodes of a process from its status file
+func loadAffinity(statusPath string) (*affinity, error) {
+	file, err := os.Open(statusPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	a := &affinity{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Cpus_allowed_list":
+			a.cpus = strings.TrimSpace(value)
+		case "Mems_allowed_list":
+			a.mems = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	a.memNodes, err = parseCPUList(a.mems)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// affinityCache caches the affinity of processes by pid; entries are reloaded once they're older than ttl, so that
+// changes of the cpuset and reused pids are picked up
+type affinityCache struct {
+	mu         sync.Mutex
+	entries    map[uint32]*affinity
+	maxEntries int
+	ttl        time.Duration
+	now        func() time.Time
+}
+
+func newAffinityCache(maxEntries int, ttl time.Duration) *affinityCache {
+	return &affinityCache{
+		entries:    make(map[uint32]*affinity),
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		now:        time.Now,
+	}
+}
+
+// Get returns the affinity of the process with the given pid, loading it from procPath if needed
+func (c *affinityCache) Get(procPath string, pid uint32) (*affinity, error) {
+	now := c.now()
+
+	c.mu.Lock()
+	a, ok := c.entries[pid]
+	c.mu.Unlock()
+	if ok && now.Sub(a.loaded) < c.ttl {
+		return a, nil
+	}
+
+	a, err := loadAffinity(filepath.Join(procPath, strconv.FormatUint(uint64(pid), 10), "status"))
+	if err != nil {
+		return nil, err
+	}
+	a.loaded = now
+
+	c.mu.Lock()
+	if len(c.entries) >= c.maxEntries {
+		// Simply start over instead of tracking usage; processes with activity will quickly be cached again
+		clear(c.entries)
+	}
+	c.entries[pid] = a
+	c.mu.Unlock()
+
+	return a, nil
+}
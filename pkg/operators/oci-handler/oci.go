@@ -19,6 +19,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/spf13/viper"
 	corev1 "k8s.io/api/core/v1"
@@ -29,6 +30,12 @@ import (
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/k8sutil"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/oci"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	// Operators running images on their own
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/audit"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/fallback"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/gpu"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/legacy"
+	_ "github.com/inspektor-gadget/inspektor-gadget/pkg/operators/nettopology"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/resources"
 )
@@ -39,10 +46,23 @@ const (
 	insecureParam         = "insecure"
 	pullParam             = "pull"
 	pullSecret            = "pull-secret"
+	checkForUpdates       = "check-for-updates"
 	verifyImage           = "verify-image"
 	publicKey             = "public-key"
+	registriesConfig      = "registries-config"
+	registryMirrors       = "registry-mirrors"
+	registryCAFile        = "registry-ca-file"
+
+	certificateIdentity       = "certificate-identity"
+	certificateIdentityRegexp = "certificate-identity-regexp"
+	certificateIssuer         = "certificate-oidc-issuer"
+	certificateIssuerRegexp   = "certificate-oidc-issuer-regexp"
+	fulcioRoots               = "fulcio-roots"
+	rekorPublicKey            = "rekor-public-key"
 )
 
+const updateCheckTimeout = 10 * time.Second
+
 type ociHandler struct{}
 
 func (o *ociHandler) Name() string {
@@ -81,6 +101,26 @@ func (o *ociHandler) InstanceParams() api.Params {
 			DefaultValue: "false",
 			TypeHint:     api.TypeBool,
 		},
+		{
+			Key:          registriesConfig,
+			Title:        "Registries config",
+			Description:  "Path of the file configuring mirrors and certificate authorities of registries",
+			DefaultValue: oci.DefaultRegistriesFile,
+			TypeHint:     api.TypeString,
+		},
+		{
+			Key:   registryMirrors,
+			Title: "Registry mirrors",
+			Description: "Mirrors or pull-through proxies tried before the registry of the image when pulling, as " +
+				"<registry>=<mirror>, e.g. ghcr.io=mirror.example.com/ghcr. Join multiple mirrors with ','",
+			TypeHint: api.TypeString,
+		},
+		{
+			Key:         registryCAFile,
+			Title:       "Registry CA file",
+			Description: "PEM file with certificate authorities trusted for registries and mirrors, in addition to the ones of the system",
+			TypeHint:    api.TypeString,
+		},
 		{
 			Key:          pullParam,
 			Title:        "Pull policy",
@@ -93,6 +133,14 @@ func (o *ociHandler) InstanceParams() api.Params {
 			},
 			TypeHint: api.TypeString,
 		},
+		{
+			Key:   checkForUpdates,
+			Title: "Check for updates",
+			Description: "Report when the tag of the gadget image refers to another image in the registry than the one " +
+				"that's run, e.g. one pinned by digest, without pulling it",
+			DefaultValue: "false",
+			TypeHint:     api.TypeBool,
+		},
 		{
 			Key:         pullSecret,
 			Title:       "Pull secret",
@@ -113,6 +161,43 @@ func (o *ociHandler) InstanceParams() api.Params {
 			DefaultValue: resources.InspektorGadgetPublicKey,
 			TypeHint:     api.TypeString,
 		},
+		{
+			Key:   certificateIdentity,
+			Title: "Certificate identity",
+			Description: "Verify keyless signatures instead of using the public key: identity the signing certificate must " +
+				"be issued to, e.g. an email address or the URI of a CI workflow",
+			TypeHint: api.TypeString,
+		},
+		{
+			Key:         certificateIdentityRegexp,
+			Title:       "Certificate identity regexp",
+			Description: "Like certificate-identity, but a regular expression that must match the whole identity",
+			TypeHint:    api.TypeString,
+		},
+		{
+			Key:         certificateIssuer,
+			Title:       "Certificate OIDC issuer",
+			Description: "OIDC issuer that must have authenticated the identity of a keyless signature",
+			TypeHint:    api.TypeString,
+		},
+		{
+			Key:         certificateIssuerRegexp,
+			Title:       "Certificate OIDC issuer regexp",
+			Description: "Like certificate-oidc-issuer, but a regular expression that must match the whole issuer",
+			TypeHint:    api.TypeString,
+		},
+		{
+			Key:         fulcioRoots,
+			Title:       "Fulcio roots",
+			Description: "PEM encoded root certificates of Fulcio used to verify keyless signatures",
+			TypeHint:    api.TypeString,
+		},
+		{
+			Key:         rekorPublicKey,
+			Title:       "Rekor public key",
+			Description: "PEM encoded public key of the Rekor transparency log used to verify keyless signatures",
+			TypeHint:    api.TypeString,
+		},
 	}
 }
 
@@ -134,6 +219,12 @@ func getPullSecret(pullSecretString string, gadgetNamespace string) ([]byte, err
 func (o *ociHandler) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (
 	operators.DataOperatorInstance, error,
 ) {
+	if operators.IsOperatorImage(gadgetCtx.ImageName()) {
+		// They aren't pulled, but the policy still applies to them
+		err := oci.GetImagePolicy().AdmitOperatorImage(gadgetCtx.Context(), gadgetCtx.ImageName())
+		return nil, err
+	}
+
 	ociParams := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
 	err := ociParams.CopyFromMap(instanceParamValues, "")
 	if err != nil {
@@ -176,25 +267,69 @@ func (o *OciHandlerInstance) init(gadgetCtx operators.GadgetContext) error {
 		}
 	}
 
+	registries, err := oci.LoadRegistriesConfig(o.ociParams.Get(registriesConfig).AsString())
+	if err != nil {
+		return err
+	}
+	if err := registries.ParseMirrors(o.ociParams.Get(registryMirrors).AsString()); err != nil {
+		return fmt.Errorf("parsing registry mirrors: %w", err)
+	}
+	if caFile := o.ociParams.Get(registryCAFile).AsString(); caFile != "" {
+		registries.CAFile = caFile
+	}
+
 	imgOpts := &oci.ImageOptions{
 		AuthOptions: oci.AuthOptions{
 			AuthFile:    o.ociParams.Get(authfileParam).AsString(),
 			SecretBytes: secretBytes,
 			Insecure:    o.ociParams.Get(insecureParam).AsBool(),
+			Registries:  registries,
 		},
 		VerifyOptions: oci.VerifyOptions{
 			VerifyPublicKey: o.ociParams.Get(verifyImage).AsBool(),
 			PublicKey:       o.ociParams.Get(publicKey).AsString(),
+			Keyless: oci.KeylessOptions{
+				Identity:       o.ociParams.Get(certificateIdentity).AsString(),
+				IdentityRegexp: o.ociParams.Get(certificateIdentityRegexp).AsString(),
+				Issuer:         o.ociParams.Get(certificateIssuer).AsString(),
+				IssuerRegexp:   o.ociParams.Get(certificateIssuerRegexp).AsString(),
+				FulcioRoots:    o.ociParams.Get(fulcioRoots).AsString(),
+				RekorPublicKey: o.ociParams.Get(rekorPublicKey).AsString(),
+			},
 		},
 	}
 
+	// The policy is enforced before pulling, so that images of denied registries are never fetched
+	policy := oci.GetImagePolicy()
+	if err := policy.CheckRegistry(gadgetCtx.ImageName()); err != nil {
+		return err
+	}
+	if err := policy.ApplyVerifyOptions(&imgOpts.VerifyOptions); err != nil {
+		return err
+	}
+
 	// Make sure the image is available, either through pulling or by just accessing a local copy
-	// TODO: add security constraints (e.g. don't allow pulling - add GlobalParams for that)
-	err := oci.EnsureImage(gadgetCtx.Context(), gadgetCtx.ImageName(), imgOpts, o.ociParams.Get(pullParam).AsString())
+	err = oci.EnsureImage(gadgetCtx.Context(), gadgetCtx.ImageName(), imgOpts, o.ociParams.Get(pullParam).AsString())
 	if err != nil {
 		return fmt.Errorf("ensuring image: %w", err)
 	}
 
+	if err := policy.Admit(gadgetCtx.Context(), gadgetCtx.ImageName()); err != nil {
+		return err
+	}
+
+	if o.ociParams.Get(checkForUpdates).AsBool() {
+		// A registry that can't be reached shouldn't keep the gadget from running
+		ctx, cancel := context.WithTimeout(gadgetCtx.Context(), updateCheckTimeout)
+		update, err := oci.CheckForUpdate(ctx, gadgetCtx.ImageName(), &imgOpts.AuthOptions)
+		cancel()
+		if err != nil {
+			gadgetCtx.Logger().Warnf("checking for updates of image: %v", err)
+		} else if update != nil {
+			gadgetCtx.Logger().Warnf("image update available: %s", update)
+		}
+	}
+
 	manifest, err := oci.GetManifestForHost(gadgetCtx.Context(), gadgetCtx.ImageName())
 	if err != nil {
 		return fmt.Errorf("getting manifest: %w", err)
@@ -41,6 +41,7 @@ type GadgetContext interface {
 	ImageName() string
 	RegisterDataSource(datasource.Type, string) (datasource.DataSource, error)
 	GetDataSources() map[string]datasource.DataSource
+	Shedder() *datasource.Shedder
 	SetVar(string, any)
 	GetVar(string) (any, bool)
 	Params() []*api.Param
@@ -118,6 +119,10 @@ type DataOperator interface {
 	// InstantiateDataOperator should create a new (lightweight) instance for the operator that can read/write
 	// from and to DataSources, register Params and read/write Variables; instanceParamValues can contain values for
 	// both params defined by InstanceParams() as well as params defined by DataOperatorInstance.ExtraParams())
+	//
+	// Returning a nil instance skips the operator for the gadget, which also hides its InstanceParams() from the
+	// user. Operators that are enabled by one of their params, like sinks, therefore have to return an instance even
+	// if they're disabled, so that the params are exposed.
 	InstantiateDataOperator(gadgetCtx GadgetContext, instanceParamValues api.ParamValues) (DataOperatorInstance, error)
 
 	Priority() int
@@ -142,6 +147,14 @@ type PostStop interface {
 	PostStop(gadgetCtx GadgetContext) error
 }
 
+// SnapshotFunc takes a new snapshot of a data source filled by a snapshotter; all entries have been emitted to the
+// data source when it returns. It must only be called after the gadget has been started.
+type SnapshotFunc func() error
+
+// SnapshottersVar is the name of the gadget context variable holding a map[string]SnapshotFunc of all data sources
+// filled by snapshotters, keyed by the name of the data source
+const SnapshottersVar = "snapshotters"
+
 type OperatorInstance interface {
 	// Name returns the name of the operator instance
 	Name() string
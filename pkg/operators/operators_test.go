@@ -193,3 +193,13 @@ func Test_SortOperatorsLargeCyclicDep(t *testing.T) {
 	_, err := SortOperators(ops)
 	assert.ErrorContains(t, err, "dependency cycle detected")
 }
+
+func TestOperatorImages(t *testing.T) {
+	RegisterOperatorImages(MatchImageNames("test_image"))
+	RegisterOperatorImages(MatchImagePrefix("test:"))
+
+	assert.True(t, IsOperatorImage("test_image"))
+	assert.True(t, IsOperatorImage("test:foo"))
+	assert.False(t, IsOperatorImage("test_image:latest"))
+	assert.False(t, IsOperatorImage("ghcr.io/inspektor-gadget/gadget/test_image"))
+}
@@ -0,0 +1,230 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource/compat"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource/formatters/json"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/formatters"
+)
+
+const (
+	// AttributeDataSource holds the name of the data source an event was emitted by
+	AttributeDataSource = "gadget.datasource"
+
+	// AttributeMntNsID holds the mount namespace id of an event, independent of the name of the field
+	AttributeMntNsID = "gadget.mntns_id"
+)
+
+// resourceAttributes maps the fields added by the enrichers to OpenTelemetry semantic conventions; they describe
+// the entity an event was produced by and are exported as resource attributes
+var resourceAttributes = map[string]string{
+	"k8s.node":                     "k8s.node.name",
+	"k8s.namespace":                "k8s.namespace.name",
+	"k8s.pod":                      "k8s.pod.name",
+	"k8s.container":                "k8s.container.name",
+	"runtime.containerName":        "container.name",
+	"runtime.containerId":          "container.id",
+	"runtime.runtimeName":          "container.runtime",
+	"runtime.containerImageName":   "container.image.name",
+	"runtime.containerImageDigest": "container.image.id",
+}
+
+// Fields holding the workload owning the pod of an event; they're exported as k8s.<kind>.name resource attribute
+const (
+	fieldOwnerKind = "k8s.ownerKind"
+	fieldOwnerName = "k8s.ownerName"
+)
+
+// ownerKinds are the kinds of workloads with a resource attribute in the OpenTelemetry semantic conventions
+var ownerKinds = map[string]string{
+	"Deployment":  "k8s.deployment.name",
+	"ReplicaSet":  "k8s.replicaset.name",
+	"StatefulSet": "k8s.statefulset.name",
+	"DaemonSet":   "k8s.daemonset.name",
+	"Job":         "k8s.job.name",
+	"CronJob":     "k8s.cronjob.name",
+}
+
+type fieldAttribute struct {
+	key      string
+	accessor datasource.FieldAccessor
+}
+
+// event is the representation of a single datasource record, independent of the signal it is exported as
+type event struct {
+	name        string
+	timestamp   uint64
+	resource    []*commonpb.KeyValue
+	resourceKey string
+	attributes  []*commonpb.KeyValue
+	body        string
+	severity    datasource.Severity
+}
+
+// converter converts data of a single data source into events
+type converter struct {
+	ds         datasource.DataSource
+	formatter  *json.Formatter
+	timestamp  datasource.FieldAccessor
+	resource   []fieldAttribute
+	attributes []fieldAttribute
+	severity   *datasource.SeverityReader
+
+	// ownerKind and ownerName are nil if the data source isn't enriched with the owner of pods
+	ownerKind datasource.FieldAccessor
+	ownerName datasource.FieldAccessor
+}
+
+func newConverter(ds datasource.DataSource) (*converter, error) {
+	formatter, err := json.New(ds)
+	if err != nil {
+		return nil, err
+	}
+	severity, err := datasource.NewSeverityReader(ds)
+	if err != nil {
+		return nil, err
+	}
+	c := &converter{
+		ds:        ds,
+		formatter: formatter,
+		severity:  severity,
+	}
+
+	// The formatters operator replaces timestamp fields with a string representation and unreferences the
+	// original field, so it can only be accessed via its tag
+	if timestamps := ds.GetFieldsWithTag("type:" + formatters.TimestampTypeName); len(timestamps) > 0 {
+		c.timestamp = timestamps[0]
+	}
+
+	for _, field := range ds.Fields() {
+		if datasource.FieldFlagEmpty.In(field.Flags) || datasource.FieldFlagUnreferenced.In(field.Flags) {
+			continue
+		}
+		f := ds.GetField(field.FullName)
+		if f == nil {
+			continue
+		}
+		if slices.Contains(field.Tags, "type:"+formatters.TimestampTypeName) {
+			continue
+		}
+		switch field.FullName {
+		case fieldOwnerKind:
+			c.ownerKind = f
+			continue
+		case fieldOwnerName:
+			c.ownerName = f
+			continue
+		}
+		if key, ok := resourceAttributes[field.FullName]; ok {
+			c.resource = append(c.resource, fieldAttribute{key: key, accessor: f})
+			continue
+		}
+		key := field.FullName
+		if slices.Contains(field.Tags, compat.MntNsIdType) {
+			key = AttributeMntNsID
+		}
+		c.attributes = append(c.attributes, fieldAttribute{key: key, accessor: f})
+	}
+	return c, nil
+}
+
+func anyValue(f datasource.FieldAccessor, data datasource.Data) *commonpb.AnyValue {
+	switch f.Type() {
+	case api.Kind_Bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: f.Bool(data)}}
+	case api.Kind_Int8:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(f.Int8(data))}}
+	case api.Kind_Int16:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(f.Int16(data))}}
+	case api.Kind_Int32:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(f.Int32(data))}}
+	case api.Kind_Int64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: f.Int64(data)}}
+	case api.Kind_Uint8:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(f.Uint8(data))}}
+	case api.Kind_Uint16:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(f.Uint16(data))}}
+	case api.Kind_Uint32:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(f.Uint32(data))}}
+	case api.Kind_Uint64:
+		// OTLP doesn't have unsigned integers; values above math.MaxInt64 wrap around
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(f.Uint64(data))}}
+	case api.Kind_Float32:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: float64(f.Float32(data))}}
+	case api.Kind_Float64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: f.Float64(data)}}
+	case api.Kind_CString:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: f.CString(data)}}
+	}
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: f.String(data)}}
+}
+
+// convert creates an event from data; it must not keep references to data
+func (c *converter) convert(data datasource.Data) *event {
+	ev := &event{
+		name:     c.ds.Name(),
+		body:     string(c.formatter.Marshal(data)),
+		severity: c.severity.Severity(data),
+		attributes: append(make([]*commonpb.KeyValue, 0, len(c.attributes)+1), &commonpb.KeyValue{
+			Key:   AttributeDataSource,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: c.ds.Name()}},
+		}),
+	}
+
+	ev.timestamp = uint64(time.Now().UnixNano())
+	if c.timestamp != nil {
+		if ts := c.timestamp.Get(data); len(ts) == 8 {
+			ev.timestamp = uint64(gadgets.WallTimeFromBootTime(c.ds.ByteOrder().Uint64(ts)))
+		}
+	}
+
+	var resourceKey strings.Builder
+	for _, r := range c.resource {
+		value := anyValue(r.accessor, data)
+		if value.GetStringValue() == "" {
+			// Enrichment data is not available for all events, e.g. for processes on the host
+			continue
+		}
+		ev.resource = append(ev.resource, &commonpb.KeyValue{Key: r.key, Value: value})
+		resourceKey.WriteString(r.key + "=" + value.GetStringValue() + "\x00")
+	}
+	if c.ownerKind != nil && c.ownerName != nil {
+		if key, ok := ownerKinds[c.ownerKind.String(data)]; ok {
+			if name := c.ownerName.String(data); name != "" {
+				ev.resource = append(ev.resource, &commonpb.KeyValue{
+					Key:   key,
+					Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: name}},
+				})
+				resourceKey.WriteString(key + "=" + name + "\x00")
+			}
+		}
+	}
+	ev.resourceKey = resourceKey.String()
+
+	for _, a := range c.attributes {
+		ev.attributes = append(ev.attributes, &commonpb.KeyValue{Key: a.key, Value: anyValue(a.accessor, data)})
+	}
+	return ev
+}
@@ -0,0 +1,373 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+)
+
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http"
+
+	SignalLogs   = "logs"
+	SignalTraces = "traces"
+)
+
+const (
+	// queueSize is the number of events that can be buffered; further events are dropped until the queue has been
+	// drained, so that a slow or unavailable endpoint doesn't block the gadget
+	queueSize = 4096
+
+	maxBatchSize  = 512
+	flushInterval = time.Second
+	exportTimeout = 10 * time.Second
+)
+
+// serviceName is used as service.name resource attribute of all exported events
+const serviceName = "inspektor-gadget"
+
+type client interface {
+	exportLogs(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error
+	exportTraces(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) error
+	close() error
+}
+
+type grpcClient struct {
+	conn   *grpc.ClientConn
+	logs   collogspb.LogsServiceClient
+	traces coltracepb.TraceServiceClient
+}
+
+func newGRPCClient(endpoint string, insecureConn bool) (*grpcClient, error) {
+	// Allow the same endpoint format as for HTTP
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		endpoint = u.Host
+	}
+
+	creds := credentials.NewTLS(&tls.Config{})
+	if insecureConn {
+		creds = insecure.NewCredentials()
+	}
+	conn, err := grpc.Dial(endpoint, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %q: %w", endpoint, err)
+	}
+	return &grpcClient{
+		conn:   conn,
+		logs:   collogspb.NewLogsServiceClient(conn),
+		traces: coltracepb.NewTraceServiceClient(conn),
+	}, nil
+}
+
+func (c *grpcClient) exportLogs(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+	_, err := c.logs.Export(ctx, req)
+	return err
+}
+
+func (c *grpcClient) exportTraces(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) error {
+	_, err := c.traces.Export(ctx, req)
+	return err
+}
+
+func (c *grpcClient) close() error {
+	return c.conn.Close()
+}
+
+type httpClient struct {
+	client  *http.Client
+	baseURL string
+}
+
+func newHTTPClient(endpoint string, insecureConn bool) (*httpClient, error) {
+	if !strings.Contains(endpoint, "://") {
+		scheme := "https"
+		if insecureConn {
+			scheme = "http"
+		}
+		endpoint = scheme + "://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parsing endpoint %q: %w", endpoint, err)
+	}
+	return &httpClient{
+		client:  &http.Client{},
+		baseURL: strings.TrimSuffix(u.String(), "/"),
+	}, nil
+}
+
+func (c *httpClient) post(ctx context.Context, path string, msg proto.Message) error {
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshaling request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %q", resp.Status)
+	}
+	return nil
+}
+
+func (c *httpClient) exportLogs(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+	return c.post(ctx, "/v1/logs", req)
+}
+
+func (c *httpClient) exportTraces(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) error {
+	return c.post(ctx, "/v1/traces", req)
+}
+
+func (c *httpClient) close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// groupByResource groups events with the same resource attributes while keeping their order
+func groupByResource(events []*event) [][]*event {
+	var groups [][]*event
+	index := map[string]int{}
+	for _, ev := range events {
+		i, ok := index[ev.resourceKey]
+		if !ok {
+			i = len(groups)
+			index[ev.resourceKey] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], ev)
+	}
+	return groups
+}
+
+// newResource returns the resource of ev; static attributes are added unless the event has its own value for them
+func newResource(static []*commonpb.KeyValue, ev *event) *resourcepb.Resource {
+	attrs := append([]*commonpb.KeyValue{{
+		Key:   "service.name",
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: serviceName}},
+	}}, ev.resource...)
+	for _, kv := range static {
+		if !slices.ContainsFunc(ev.resource, func(r *commonpb.KeyValue) bool { return r.Key == kv.Key }) {
+			attrs = append(attrs, kv)
+		}
+	}
+	return &resourcepb.Resource{Attributes: attrs}
+}
+
+// severityNumbers maps severities to the log data model of OpenTelemetry; unknown severities are left unspecified
+var severityNumbers = map[datasource.Severity]logspb.SeverityNumber{
+	datasource.SeverityDebug:    logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG,
+	datasource.SeverityInfo:     logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+	datasource.SeverityLow:      logspb.SeverityNumber_SEVERITY_NUMBER_WARN,
+	datasource.SeverityMedium:   logspb.SeverityNumber_SEVERITY_NUMBER_WARN3,
+	datasource.SeverityHigh:     logspb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+	datasource.SeverityCritical: logspb.SeverityNumber_SEVERITY_NUMBER_FATAL,
+}
+
+func buildLogsRequest(scope *commonpb.InstrumentationScope, static []*commonpb.KeyValue, events []*event) *collogspb.ExportLogsServiceRequest {
+	req := &collogspb.ExportLogsServiceRequest{}
+	observed := uint64(time.Now().UnixNano())
+	for _, group := range groupByResource(events) {
+		records := make([]*logspb.LogRecord, 0, len(group))
+		for _, ev := range group {
+			record := &logspb.LogRecord{
+				TimeUnixNano:         ev.timestamp,
+				ObservedTimeUnixNano: observed,
+				Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: ev.body}},
+				Attributes:           ev.attributes,
+			}
+			if number, ok := severityNumbers[ev.severity]; ok {
+				record.SeverityNumber = number
+				record.SeverityText = ev.severity.String()
+			}
+			records = append(records, record)
+		}
+		req.ResourceLogs = append(req.ResourceLogs, &logspb.ResourceLogs{
+			Resource:  newResource(static, group[0]),
+			ScopeLogs: []*logspb.ScopeLogs{{Scope: scope, LogRecords: records}},
+		})
+	}
+	return req
+}
+
+func buildTracesRequest(scope *commonpb.InstrumentationScope, static []*commonpb.KeyValue, events []*event) *coltracepb.ExportTraceServiceRequest {
+	req := &coltracepb.ExportTraceServiceRequest{}
+	for _, group := range groupByResource(events) {
+		spans := make([]*tracepb.Span, 0, len(group))
+		for _, ev := range group {
+			// Events are independent of each other, so each of them gets its own trace
+			traceID := make([]byte, 16)
+			spanID := make([]byte, 8)
+			rand.Read(traceID)
+			rand.Read(spanID)
+			spans = append(spans, &tracepb.Span{
+				TraceId:           traceID,
+				SpanId:            spanID,
+				Name:              ev.name,
+				Kind:              tracepb.Span_SPAN_KIND_INTERNAL,
+				StartTimeUnixNano: ev.timestamp,
+				EndTimeUnixNano:   ev.timestamp,
+				Attributes:        ev.attributes,
+			})
+		}
+		req.ResourceSpans = append(req.ResourceSpans, &tracepb.ResourceSpans{
+			Resource:   newResource(static, group[0]),
+			ScopeSpans: []*tracepb.ScopeSpans{{Scope: scope, Spans: spans}},
+		})
+	}
+	return req
+}
+
+// exporter batches events and sends them to the endpoint in the background
+type exporter struct {
+	client client
+	signal string
+	scope  *commonpb.InstrumentationScope
+	logger logger.Logger
+
+	// resource holds attributes added to the resource of all events
+	resource []*commonpb.KeyValue
+
+	events  chan *event
+	stop    chan struct{}
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+func newExporter(c client, signal string, scope *commonpb.InstrumentationScope, resource []*commonpb.KeyValue, logger logger.Logger) *exporter {
+	return &exporter{
+		client:   c,
+		signal:   signal,
+		scope:    scope,
+		resource: resource,
+		logger:   logger,
+		events:   make(chan *event, queueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// enqueue queues ev for export without blocking; it is dropped if the queue is full
+func (e *exporter) enqueue(ev *event) {
+	select {
+	case e.events <- ev:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+func (e *exporter) flush(batch []*event) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	var err error
+	switch e.signal {
+	case SignalTraces:
+		err = e.client.exportTraces(ctx, buildTracesRequest(e.scope, e.resource, batch))
+	default:
+		err = e.client.exportLogs(ctx, buildLogsRequest(e.scope, e.resource, batch))
+	}
+	if err != nil {
+		e.logger.Warnf("otlp: exporting %d events: %v", len(batch), err)
+	}
+	if dropped := e.dropped.Swap(0); dropped > 0 {
+		e.logger.Warnf("otlp: dropped %d events, as the export queue was full", dropped)
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*event, 0, maxBatchSize)
+	for {
+		select {
+		case ev := <-e.events:
+			batch = append(batch, ev)
+			if len(batch) < maxBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-e.stop:
+			// Send what has been queued so far
+			for {
+				select {
+				case ev := <-e.events:
+					batch = append(batch, ev)
+					if len(batch) == maxBatchSize {
+						e.flush(batch)
+						batch = batch[:0]
+					}
+				default:
+					e.flush(batch)
+					return
+				}
+			}
+		}
+		e.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+func (e *exporter) start() {
+	go e.run()
+}
+
+// shutdown sends the remaining events and closes the connection to the endpoint
+func (e *exporter) shutdown() error {
+	close(e.stop)
+	<-e.done
+	return e.client.close()
+}
@@ -0,0 +1,249 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package otlp provides an operator that exports the data emitted by gadgets as OpenTelemetry log records or spans
+// to an OTLP endpoint.
+package otlp
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/filter"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	OperatorName = "otlp"
+
+	ParamEndpoint = "otlp-endpoint"
+	ParamProtocol = "otlp-protocol"
+	ParamSignal   = "otlp-signal"
+	ParamInsecure = "otlp-insecure"
+
+	ParamResourceAttributes = "otlp-resource-attributes"
+
+	// Priority is chosen so that only data that passed the filter operator is exported
+	Priority = filter.Priority + 100
+)
+
+type otlpOperator struct{}
+
+func (o *otlpOperator) Name() string {
+	return OperatorName
+}
+
+func (o *otlpOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *otlpOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *otlpOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:         ParamEndpoint,
+			Title:       "OTLP endpoint",
+			Description: "Endpoint to export events to, e.g. localhost:4317 for gRPC or localhost:4318 for HTTP; events are only exported if set",
+			TypeHint:    api.TypeString,
+		},
+		{
+			Key:            ParamProtocol,
+			Title:          "OTLP protocol",
+			Description:    "Protocol used to talk to the OTLP endpoint",
+			DefaultValue:   ProtocolGRPC,
+			PossibleValues: []string{ProtocolGRPC, ProtocolHTTP},
+			TypeHint:       api.TypeString,
+		},
+		{
+			Key:            ParamSignal,
+			Title:          "OTLP signal",
+			Description:    "Export events as log records or as spans",
+			DefaultValue:   SignalLogs,
+			PossibleValues: []string{SignalLogs, SignalTraces},
+			TypeHint:       api.TypeString,
+		},
+		{
+			Key:          ParamInsecure,
+			Title:        "OTLP insecure",
+			Description:  "Don't use TLS when connecting to the OTLP endpoint",
+			DefaultValue: "false",
+			TypeHint:     api.TypeBool,
+		},
+		{
+			Key:   ParamResourceAttributes,
+			Title: "OTLP resource attributes",
+			Description: "Comma-separated key=value pairs added to the resource of all exported events, " +
+				"e.g. k8s.cluster.name=prod,deployment.environment=staging",
+			TypeHint: api.TypeString,
+		},
+	}
+}
+
+// parseResourceAttributes parses a comma-separated list of key=value pairs
+func parseResourceAttributes(s string) ([]*commonpb.KeyValue, error) {
+	var attrs []*commonpb.KeyValue
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid resource attribute %q: expected key=value", pair)
+		}
+		attrs = append(attrs, &commonpb.KeyValue{
+			Key:   key,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: strings.TrimSpace(value)}},
+		})
+	}
+	return attrs, nil
+}
+
+// hostAttributes identify the node the gadget runs on, also for events that aren't enriched with Kubernetes
+// metadata, like those of processes on the host; NODE_NAME is set when running in Kubernetes
+func hostAttributes() []*commonpb.KeyValue {
+	var attrs []*commonpb.KeyValue
+	stringAttr := func(key, value string) {
+		attrs = append(attrs, &commonpb.KeyValue{
+			Key:   key,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+		})
+	}
+	if node := os.Getenv("NODE_NAME"); node != "" {
+		stringAttr("host.name", node)
+		stringAttr("k8s.node.name", node)
+	} else if hostname, err := os.Hostname(); err == nil {
+		stringAttr("host.name", hostname)
+	}
+	return attrs
+}
+
+func (o *otlpOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	resource, err := parseResourceAttributes(params.Get(ParamResourceAttributes).AsString())
+	if err != nil {
+		return nil, err
+	}
+	// Given attributes take precedence over the ones of the host
+	for _, kv := range hostAttributes() {
+		if !slices.ContainsFunc(resource, func(r *commonpb.KeyValue) bool { return r.Key == kv.Key }) {
+			resource = append(resource, kv)
+		}
+	}
+
+	inst := &otlpOperatorInstance{
+		resource:   resource,
+		endpoint:   params.Get(ParamEndpoint).AsString(),
+		protocol:   params.Get(ParamProtocol).AsString(),
+		signal:     params.Get(ParamSignal).AsString(),
+		insecure:   params.Get(ParamInsecure).AsBool(),
+		converters: make(map[datasource.DataSource]*converter),
+	}
+	if inst.endpoint == "" {
+		return inst, nil
+	}
+
+	for name, ds := range gadgetCtx.GetDataSources() {
+		c, err := newConverter(ds)
+		if err != nil {
+			return nil, fmt.Errorf("preparing data source %q for export: %w", name, err)
+		}
+		inst.converters[ds] = c
+	}
+	return inst, nil
+}
+
+func (o *otlpOperator) Priority() int {
+	return Priority
+}
+
+type otlpOperatorInstance struct {
+	resource   []*commonpb.KeyValue
+	endpoint   string
+	protocol   string
+	signal     string
+	insecure   bool
+	converters map[datasource.DataSource]*converter
+	exporter   *exporter
+}
+
+func (o *otlpOperatorInstance) Name() string {
+	return OperatorName
+}
+
+func (o *otlpOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error {
+	if o.endpoint == "" {
+		return nil
+	}
+
+	var c client
+	var err error
+	switch o.protocol {
+	case ProtocolGRPC:
+		c, err = newGRPCClient(o.endpoint, o.insecure)
+	case ProtocolHTTP:
+		c, err = newHTTPClient(o.endpoint, o.insecure)
+	default:
+		return fmt.Errorf("invalid protocol %q", o.protocol)
+	}
+	if err != nil {
+		return fmt.Errorf("creating otlp client: %w", err)
+	}
+
+	o.exporter = newExporter(c, o.signal, &commonpb.InstrumentationScope{Name: gadgetCtx.ImageName()}, o.resource,
+		gadgetCtx.Logger())
+	for ds, conv := range o.converters {
+		conv := conv
+		ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+			o.exporter.enqueue(conv.convert(data))
+			return nil
+		}, Priority)
+	}
+	return nil
+}
+
+func (o *otlpOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	if o.exporter == nil {
+		return nil
+	}
+	o.exporter.start()
+	return nil
+}
+
+func (o *otlpOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	if o.exporter == nil {
+		return nil
+	}
+	return o.exporter.shutdown()
+}
+
+func init() {
+	operators.RegisterDataOperator(&otlpOperator{})
+}
@@ -0,0 +1,187 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource/compat"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+)
+
+func attributes(kvs []*commonpb.KeyValue) map[string]any {
+	res := map[string]any{}
+	for _, kv := range kvs {
+		switch v := kv.Value.Value.(type) {
+		case *commonpb.AnyValue_StringValue:
+			res[kv.Key] = v.StringValue
+		case *commonpb.AnyValue_IntValue:
+			res[kv.Key] = v.IntValue
+		}
+	}
+	return res
+}
+
+func newTestDataSource(t *testing.T) (datasource.DataSource, func(pod, comm string) datasource.Data) {
+	ds := datasource.New(datasource.TypeEvent, "exec")
+	mntns, err := ds.AddField("mntns_id", datasource.WithKind(api.Kind_Uint64), datasource.WithTags(compat.MntNsIdType))
+	require.NoError(t, err)
+	comm, err := ds.AddField("comm", datasource.WithKind(api.Kind_String))
+	require.NoError(t, err)
+	k8s, err := ds.AddField("k8s", datasource.WithFlags(datasource.FieldFlagEmpty))
+	require.NoError(t, err)
+	pod, err := k8s.AddSubField("pod")
+	require.NoError(t, err)
+	ownerKind, err := k8s.AddSubField("ownerKind", datasource.WithFlags(datasource.FieldFlagHidden))
+	require.NoError(t, err)
+	ownerName, err := k8s.AddSubField("ownerName", datasource.WithFlags(datasource.FieldFlagHidden))
+	require.NoError(t, err)
+	severity, err := ds.AddField("severity",
+		datasource.WithKind(api.Kind_String),
+		datasource.WithAnnotations(map[string]string{datasource.AnnotationSeverityField: "true"}))
+	require.NoError(t, err)
+
+	return ds, func(podName, commName string) datasource.Data {
+		data := ds.NewData()
+		require.NoError(t, mntns.Set(data, make([]byte, 8)))
+		mntns.PutUint64(data, 4026531840)
+		require.NoError(t, comm.Set(data, []byte(commName)))
+		require.NoError(t, pod.Set(data, []byte(podName)))
+		if podName != "" {
+			require.NoError(t, ownerKind.Set(data, []byte("Deployment")))
+			require.NoError(t, ownerName.Set(data, []byte("nginx")))
+		}
+		if commName == "cat" {
+			require.NoError(t, severity.Set(data, []byte("high")))
+		}
+		return data
+	}
+}
+
+func TestConvert(t *testing.T) {
+	ds, newData := newTestDataSource(t)
+	c, err := newConverter(ds)
+	require.NoError(t, err)
+
+	ev := c.convert(newData("nginx-1", "cat"))
+	require.Equal(t, "exec", ev.name)
+	require.NotZero(t, ev.timestamp)
+	require.Equal(t, map[string]any{
+		"k8s.pod.name":        "nginx-1",
+		"k8s.deployment.name": "nginx",
+	}, attributes(ev.resource))
+	require.Equal(t, map[string]any{
+		AttributeDataSource: "exec",
+		AttributeMntNsID:    int64(4026531840),
+		"comm":              "cat",
+		"severity":          "high",
+	}, attributes(ev.attributes))
+	require.Equal(t, datasource.SeverityHigh, ev.severity)
+	var body map[string]any
+	require.NoError(t, json.Unmarshal([]byte(ev.body), &body))
+	require.Equal(t, "cat", body["comm"])
+
+	// Missing enrichment data must not end up in the resource
+	ev = c.convert(newData("", "ls"))
+	require.Empty(t, ev.resource)
+	require.Equal(t, datasource.SeverityUnknown, ev.severity)
+}
+
+func TestParseResourceAttributes(t *testing.T) {
+	attrs, err := parseResourceAttributes(" k8s.cluster.name=prod, team = a=b,")
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"k8s.cluster.name": "prod", "team": "a=b"}, attributes(attrs))
+
+	_, err = parseResourceAttributes("k8s.cluster.name")
+	require.Error(t, err)
+	_, err = parseResourceAttributes("=prod")
+	require.Error(t, err)
+}
+
+func TestExportHTTP(t *testing.T) {
+	requests := make(chan *http.Request, 2)
+	bodies := make(chan []byte, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	ds, newData := newTestDataSource(t)
+	c, err := newConverter(ds)
+	require.NoError(t, err)
+
+	resource, err := parseResourceAttributes("k8s.cluster.name=prod,k8s.pod.name=static")
+	require.NoError(t, err)
+
+	for _, signal := range []string{SignalLogs, SignalTraces} {
+		client, err := newHTTPClient(server.URL, false)
+		require.NoError(t, err)
+		e := newExporter(client, signal, &commonpb.InstrumentationScope{Name: "test"}, resource, logger.DefaultLogger())
+		e.enqueue(c.convert(newData("a", "cat")))
+		e.enqueue(c.convert(newData("b", "ls")))
+		e.enqueue(c.convert(newData("a", "sh")))
+		e.start()
+		require.NoError(t, e.shutdown())
+
+		r := <-requests
+		body := <-bodies
+		require.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+
+		switch signal {
+		case SignalLogs:
+			require.Equal(t, "/v1/logs", r.URL.Path)
+			req := &collogspb.ExportLogsServiceRequest{}
+			require.NoError(t, proto.Unmarshal(body, req))
+			require.Len(t, req.ResourceLogs, 2)
+			// Attributes of the event take precedence over static ones
+			resourceAttrs := attributes(req.ResourceLogs[0].Resource.Attributes)
+			require.Equal(t, "a", resourceAttrs["k8s.pod.name"])
+			require.Equal(t, "prod", resourceAttrs["k8s.cluster.name"])
+			records := req.ResourceLogs[0].ScopeLogs[0].LogRecords
+			require.Len(t, records, 2)
+			require.Equal(t, logspb.SeverityNumber_SEVERITY_NUMBER_ERROR, records[0].SeverityNumber)
+			require.Equal(t, "high", records[0].SeverityText)
+			require.Equal(t, logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED, records[1].SeverityNumber)
+			require.Empty(t, records[1].SeverityText)
+			require.Len(t, req.ResourceLogs[1].ScopeLogs[0].LogRecords, 1)
+			require.Equal(t, "test", req.ResourceLogs[0].ScopeLogs[0].Scope.Name)
+		case SignalTraces:
+			require.Equal(t, "/v1/traces", r.URL.Path)
+			req := &coltracepb.ExportTraceServiceRequest{}
+			require.NoError(t, proto.Unmarshal(body, req))
+			require.Len(t, req.ResourceSpans, 2)
+			spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+			require.Len(t, spans, 2)
+			require.Equal(t, "exec", spans[0].Name)
+			require.Len(t, spans[0].TraceId, 16)
+			require.NotEqual(t, spans[0].TraceId, spans[1].TraceId)
+		}
+	}
+}
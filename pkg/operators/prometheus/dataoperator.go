@@ -0,0 +1,280 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/filter"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	DataOperatorName = "prometheus"
+
+	ParamDataListenAddress = "prometheus-listen-address"
+	ParamDataMetricsPath   = "prometheus-metrics-path"
+	ParamDataMinGroupSize  = "prometheus-min-group-size"
+	ParamDataNoiseEpsilon  = "prometheus-noise-epsilon"
+
+	// DataOperatorPriority is chosen so that only data that passed the filter operator is taken into account
+	DataOperatorPriority = filter.Priority + 100
+)
+
+// metricsServer serves the metrics of all gadget runs using the same listen address and path
+type metricsServer struct {
+	server *http.Server
+	refs   int
+
+	lock      sync.Mutex
+	gatherers map[prom.Gatherer]struct{}
+}
+
+var (
+	metricsServersLock sync.Mutex
+	metricsServers     = map[string]*metricsServer{}
+)
+
+func (s *metricsServer) Gather() ([]*dto.MetricFamily, error) {
+	s.lock.Lock()
+	gatherers := make(prom.Gatherers, 0, len(s.gatherers))
+	for g := range s.gatherers {
+		gatherers = append(gatherers, g)
+	}
+	s.lock.Unlock()
+	return gatherers.Gather()
+}
+
+// acquireMetricsServer adds gatherer to the server listening on address, starting it if needed
+func acquireMetricsServer(address, path string, gatherer prom.Gatherer) error {
+	metricsServersLock.Lock()
+	defer metricsServersLock.Unlock()
+
+	key := address + path
+	s, ok := metricsServers[key]
+	if !ok {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return fmt.Errorf("listening on %q: %w", address, err)
+		}
+		s = &metricsServer{gatherers: map[prom.Gatherer]struct{}{}}
+		mux := http.NewServeMux()
+		mux.Handle(path, promhttp.HandlerFor(s, promhttp.HandlerOpts{}))
+		s.server = &http.Server{Handler: mux}
+		go func() {
+			if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Errorf("serving metrics: %v", err)
+			}
+		}()
+		metricsServers[key] = s
+	}
+
+	s.lock.Lock()
+	s.gatherers[gatherer] = struct{}{}
+	s.lock.Unlock()
+	s.refs++
+	return nil
+}
+
+// releaseMetricsServer removes gatherer from the server listening on address and stops the server if it isn't used
+// anymore
+func releaseMetricsServer(address, path string, gatherer prom.Gatherer) {
+	metricsServersLock.Lock()
+	defer metricsServersLock.Unlock()
+
+	key := address + path
+	s, ok := metricsServers[key]
+	if !ok {
+		return
+	}
+
+	s.lock.Lock()
+	delete(s.gatherers, gatherer)
+	s.lock.Unlock()
+
+	s.refs--
+	if s.refs == 0 {
+		s.server.Close()
+		delete(metricsServers, key)
+	}
+}
+
+type prometheusDataOperator struct{}
+
+func (o *prometheusDataOperator) Name() string {
+	return DataOperatorName
+}
+
+func (o *prometheusDataOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *prometheusDataOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *prometheusDataOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:         ParamDataListenAddress,
+			Title:       "Listen address",
+			Description: "Address to serve the metrics declared by the gadget on, e.g. 0.0.0.0:2224; metrics are only collected if set",
+			TypeHint:    api.TypeString,
+		},
+		{
+			Key:          ParamDataMetricsPath,
+			Title:        "Metrics path",
+			Description:  "Path to serve the metrics declared by the gadget on",
+			DefaultValue: DefaultMetricsPath,
+			TypeHint:     api.TypeString,
+		},
+		{
+			Key:          ParamDataMinGroupSize,
+			Title:        "Minimum group size",
+			Description:  "Don't export counters and histograms of groups with fewer observations than this; 0 disables suppression",
+			DefaultValue: "0",
+			TypeHint:     api.TypeUint64,
+		},
+		{
+			Key:          ParamDataNoiseEpsilon,
+			Title:        "Noise epsilon",
+			Description:  "Add Laplace noise with a scale of 1/epsilon to exported values; smaller values add more noise, 0 disables noise",
+			DefaultValue: "0",
+			TypeHint:     api.TypeFloat64,
+		},
+	}
+}
+
+func (o *prometheusDataOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &prometheusDataOperatorInstance{
+		address:      params.Get(ParamDataListenAddress).AsString(),
+		path:         params.Get(ParamDataMetricsPath).AsString(),
+		minGroupSize: params.Get(ParamDataMinGroupSize).AsUint64(),
+		epsilon:      params.Get(ParamDataNoiseEpsilon).AsFloat64(),
+		metrics:      make(map[datasource.DataSource]*dsMetrics),
+	}
+	if inst.epsilon < 0 {
+		return nil, fmt.Errorf("%s must not be negative", ParamDataNoiseEpsilon)
+	}
+	for _, ds := range gadgetCtx.GetDataSources() {
+		m, err := newDSMetrics(ds)
+		if err != nil {
+			return nil, fmt.Errorf("reading metrics of data source %q: %w", ds.Name(), err)
+		}
+		if m != nil {
+			inst.metrics[ds] = m
+		}
+	}
+
+	// Don't run, if the gadget doesn't declare any metrics
+	if len(inst.metrics) == 0 {
+		return nil, nil
+	}
+	return inst, nil
+}
+
+func (o *prometheusDataOperator) Priority() int {
+	return DataOperatorPriority
+}
+
+type prometheusDataOperatorInstance struct {
+	address      string
+	path         string
+	minGroupSize uint64
+	epsilon      float64
+	metrics      map[datasource.DataSource]*dsMetrics
+	gatherer     prom.Gatherer
+	provider     *sdkmetric.MeterProvider
+}
+
+func (o *prometheusDataOperatorInstance) Name() string {
+	return DataOperatorName
+}
+
+func (o *prometheusDataOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error {
+	if o.address == "" {
+		gadgetCtx.Logger().Debugf("prometheus: no listen address given, not collecting metrics")
+		return nil
+	}
+
+	registry := prom.NewRegistry()
+	exporter, err := prometheus.New(
+		prometheus.WithRegisterer(registry),
+		prometheus.WithoutScopeInfo(),
+		prometheus.WithoutTargetInfo(),
+	)
+	if err != nil {
+		return fmt.Errorf("initialize prometheus exporter: %w", err)
+	}
+	o.gatherer, err = newPrivacyGatherer(registry, o.minGroupSize, o.epsilon)
+	if err != nil {
+		return fmt.Errorf("initialize privacy gatherer: %w", err)
+	}
+	o.provider = sdkmetric.NewMeterProvider(sdkmetric.WithReader(exporter))
+	meter := o.provider.Meter(gadgetCtx.ImageName())
+
+	for ds, m := range o.metrics {
+		if err := m.register(meter); err != nil {
+			return fmt.Errorf("registering metrics of data source %q: %w", ds.Name(), err)
+		}
+		m := m
+		ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+			m.record(gadgetCtx.Context(), data)
+			return nil
+		}, DataOperatorPriority)
+	}
+	return nil
+}
+
+func (o *prometheusDataOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	if o.gatherer == nil {
+		return nil
+	}
+	return acquireMetricsServer(o.address, o.path, o.gatherer)
+}
+
+func (o *prometheusDataOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	if o.gatherer == nil {
+		return nil
+	}
+	releaseMetricsServer(o.address, o.path, o.gatherer)
+	return o.provider.Shutdown(context.Background())
+}
+
+func init() {
+	operators.RegisterDataOperator(&prometheusDataOperator{})
+}
@@ -0,0 +1,310 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelmetric "go.opentelemetry.io/otel/metric"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+// Annotations used to map fields of data sources to metrics
+const (
+	// AnnotationMetricsType marks a field as a label ("key") or as the value of a "counter", "gauge" or "histogram"
+	AnnotationMetricsType = "metrics.type"
+
+	// AnnotationMetricsName overrides the name of the metric or label; it defaults to the name of the data source
+	// and field
+	AnnotationMetricsName = "metrics.name"
+
+	// AnnotationMetricsDescription overrides the description of the metric; it defaults to the description of the
+	// field
+	AnnotationMetricsDescription = "metrics.description"
+
+	// AnnotationMetricsUnit sets the unit of the metric
+	AnnotationMetricsUnit = "metrics.unit"
+
+	// AnnotationMetricsBuckets sets the comma separated bucket boundaries of histograms
+	AnnotationMetricsBuckets = "metrics.buckets"
+
+	// AnnotationMetricsCount can be set on a data source to export a counter with the given name that counts its
+	// events
+	AnnotationMetricsCount = "metrics.count"
+)
+
+const (
+	MetricsTypeKey       = "key"
+	MetricsTypeCounter   = "counter"
+	MetricsTypeGauge     = "gauge"
+	MetricsTypeHistogram = "histogram"
+)
+
+type metricsKey struct {
+	name     string
+	accessor datasource.FieldAccessor
+}
+
+type metricsValue struct {
+	typ         string
+	name        string
+	description string
+	unit        string
+	buckets     []float64
+	accessor    datasource.FieldAccessor
+
+	// record is set when registering the instrument
+	record func(ctx context.Context, value float64, attrs attribute.Set)
+}
+
+// dsMetrics holds the metrics of a single data source
+type dsMetrics struct {
+	keys   []metricsKey
+	values []*metricsValue
+	count  string
+
+	counter otelmetric.Int64Counter
+
+	gaugesLock sync.Mutex
+	gauges     map[*metricsValue]map[attribute.Distinct]gaugeValue
+}
+
+type gaugeValue struct {
+	attrs attribute.Set
+	value float64
+}
+
+// metricName builds a valid Prometheus metric or label name
+func metricName(parts ...string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.Join(parts, "_"))
+}
+
+func isNumeric(kind api.Kind) bool {
+	switch kind {
+	case api.Kind_Int8, api.Kind_Int16, api.Kind_Int32, api.Kind_Int64,
+		api.Kind_Uint8, api.Kind_Uint16, api.Kind_Uint32, api.Kind_Uint64,
+		api.Kind_Float32, api.Kind_Float64:
+		return true
+	}
+	return false
+}
+
+// newDSMetrics reads the metrics annotations of ds; it returns nil if ds doesn't declare any metrics
+func newDSMetrics(ds datasource.DataSource) (*dsMetrics, error) {
+	m := &dsMetrics{
+		count:  ds.Annotations()[AnnotationMetricsCount],
+		gauges: make(map[*metricsValue]map[attribute.Distinct]gaugeValue),
+	}
+
+	for _, field := range ds.Fields() {
+		typ, ok := field.Annotations[AnnotationMetricsType]
+		if !ok {
+			continue
+		}
+		f := ds.GetField(field.FullName)
+		if f == nil {
+			continue
+		}
+		annotations := field.Annotations
+		name := annotations[AnnotationMetricsName]
+
+		switch typ {
+		case MetricsTypeKey:
+			if name == "" {
+				name = metricName(field.FullName)
+			}
+			m.keys = append(m.keys, metricsKey{name: name, accessor: f})
+		case MetricsTypeCounter, MetricsTypeGauge, MetricsTypeHistogram:
+			if !isNumeric(f.Type()) {
+				return nil, fmt.Errorf("field %q: %s needs a numeric field", field.FullName, typ)
+			}
+			if name == "" {
+				name = metricName(ds.Name(), field.FullName)
+			}
+			value := &metricsValue{
+				typ:         typ,
+				name:        name,
+				description: annotations[AnnotationMetricsDescription],
+				unit:        annotations[AnnotationMetricsUnit],
+				accessor:    f,
+			}
+			if value.description == "" {
+				value.description = annotations["description"]
+			}
+			if buckets := annotations[AnnotationMetricsBuckets]; buckets != "" {
+				if typ != MetricsTypeHistogram {
+					return nil, fmt.Errorf("field %q: buckets can only be set for histograms", field.FullName)
+				}
+				for _, b := range strings.Split(buckets, ",") {
+					boundary, err := strconv.ParseFloat(strings.TrimSpace(b), 64)
+					if err != nil {
+						return nil, fmt.Errorf("field %q: invalid bucket %q", field.FullName, b)
+					}
+					value.buckets = append(value.buckets, boundary)
+				}
+			}
+			m.values = append(m.values, value)
+		default:
+			return nil, fmt.Errorf("field %q: invalid metrics type %q", field.FullName, typ)
+		}
+	}
+
+	if len(m.values) == 0 && m.count == "" {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// register creates the instruments for the metrics of the data source
+func (m *dsMetrics) register(meter otelmetric.Meter) error {
+	var err error
+	if m.count != "" {
+		m.counter, err = meter.Int64Counter(metricName(m.count))
+		if err != nil {
+			return fmt.Errorf("creating counter %q: %w", m.count, err)
+		}
+	}
+
+	for _, v := range m.values {
+		v := v
+		switch v.typ {
+		case MetricsTypeCounter:
+			counter, err := meter.Float64Counter(v.name,
+				otelmetric.WithDescription(v.description), otelmetric.WithUnit(v.unit))
+			if err != nil {
+				return fmt.Errorf("creating counter %q: %w", v.name, err)
+			}
+			v.record = func(ctx context.Context, value float64, attrs attribute.Set) {
+				counter.Add(ctx, value, otelmetric.WithAttributeSet(attrs))
+			}
+		case MetricsTypeHistogram:
+			opts := []otelmetric.Float64HistogramOption{
+				otelmetric.WithDescription(v.description), otelmetric.WithUnit(v.unit),
+			}
+			if len(v.buckets) > 0 {
+				opts = append(opts, otelmetric.WithExplicitBucketBoundaries(v.buckets...))
+			}
+			histogram, err := meter.Float64Histogram(v.name, opts...)
+			if err != nil {
+				return fmt.Errorf("creating histogram %q: %w", v.name, err)
+			}
+			v.record = func(ctx context.Context, value float64, attrs attribute.Set) {
+				histogram.Record(ctx, value, otelmetric.WithAttributeSet(attrs))
+			}
+		case MetricsTypeGauge:
+			// gauges are asynchronous: the last value of each label combination is reported when collecting
+			m.gauges[v] = make(map[attribute.Distinct]gaugeValue)
+			_, err := meter.Float64ObservableGauge(v.name,
+				otelmetric.WithDescription(v.description), otelmetric.WithUnit(v.unit),
+				otelmetric.WithFloat64Callback(func(ctx context.Context, obs otelmetric.Float64Observer) error {
+					m.gaugesLock.Lock()
+					defer m.gaugesLock.Unlock()
+					for _, g := range m.gauges[v] {
+						obs.Observe(g.value, otelmetric.WithAttributeSet(g.attrs))
+					}
+					return nil
+				}))
+			if err != nil {
+				return fmt.Errorf("creating gauge %q: %w", v.name, err)
+			}
+			v.record = func(ctx context.Context, value float64, attrs attribute.Set) {
+				m.gaugesLock.Lock()
+				defer m.gaugesLock.Unlock()
+				m.gauges[v][attrs.Equivalent()] = gaugeValue{attrs: attrs, value: value}
+			}
+		}
+	}
+	return nil
+}
+
+func keyValue(name string, f datasource.FieldAccessor, data datasource.Data) attribute.KeyValue {
+	switch f.Type() {
+	case api.Kind_Bool:
+		return attribute.Bool(name, f.Bool(data))
+	case api.Kind_Int8:
+		return attribute.Int64(name, int64(f.Int8(data)))
+	case api.Kind_Int16:
+		return attribute.Int64(name, int64(f.Int16(data)))
+	case api.Kind_Int32:
+		return attribute.Int64(name, int64(f.Int32(data)))
+	case api.Kind_Int64:
+		return attribute.Int64(name, f.Int64(data))
+	case api.Kind_Uint8:
+		return attribute.Int64(name, int64(f.Uint8(data)))
+	case api.Kind_Uint16:
+		return attribute.Int64(name, int64(f.Uint16(data)))
+	case api.Kind_Uint32:
+		return attribute.Int64(name, int64(f.Uint32(data)))
+	case api.Kind_Uint64:
+		return attribute.String(name, strconv.FormatUint(f.Uint64(data), 10))
+	case api.Kind_CString:
+		return attribute.String(name, f.CString(data))
+	}
+	return attribute.String(name, f.String(data))
+}
+
+func numericValue(f datasource.FieldAccessor, data datasource.Data) float64 {
+	switch f.Type() {
+	case api.Kind_Int8:
+		return float64(f.Int8(data))
+	case api.Kind_Int16:
+		return float64(f.Int16(data))
+	case api.Kind_Int32:
+		return float64(f.Int32(data))
+	case api.Kind_Int64:
+		return float64(f.Int64(data))
+	case api.Kind_Uint8:
+		return float64(f.Uint8(data))
+	case api.Kind_Uint16:
+		return float64(f.Uint16(data))
+	case api.Kind_Uint32:
+		return float64(f.Uint32(data))
+	case api.Kind_Uint64:
+		return float64(f.Uint64(data))
+	case api.Kind_Float32:
+		return float64(f.Float32(data))
+	case api.Kind_Float64:
+		return f.Float64(data)
+	}
+	return 0
+}
+
+// record updates the metrics with the values of data
+func (m *dsMetrics) record(ctx context.Context, data datasource.Data) {
+	kvs := make([]attribute.KeyValue, 0, len(m.keys))
+	for _, k := range m.keys {
+		kvs = append(kvs, keyValue(k.name, k.accessor, data))
+	}
+	attrs := attribute.NewSet(kvs...)
+
+	if m.counter != nil {
+		m.counter.Add(ctx, 1, otelmetric.WithAttributeSet(attrs))
+	}
+	for _, v := range m.values {
+		v.record(ctx, numericValue(v.accessor, data), attrs)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"testing"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+func TestDSMetrics(t *testing.T) {
+	ds := datasource.New(datasource.TypeEvent, "open")
+	ds.AddAnnotation(AnnotationMetricsCount, "open_events_total")
+
+	comm, err := ds.AddField("comm", datasource.WithKind(api.Kind_String),
+		datasource.WithAnnotations(map[string]string{AnnotationMetricsType: MetricsTypeKey}))
+	require.NoError(t, err)
+	size, err := ds.AddField("size", datasource.WithKind(api.Kind_Uint32),
+		datasource.WithAnnotations(map[string]string{
+			AnnotationMetricsType:    MetricsTypeHistogram,
+			AnnotationMetricsBuckets: "10,100",
+		}))
+	require.NoError(t, err)
+	_, err = ds.AddField("fds", datasource.WithKind(api.Kind_Int64),
+		datasource.WithAnnotations(map[string]string{
+			AnnotationMetricsType: MetricsTypeGauge,
+			AnnotationMetricsName: "open_fds",
+		}))
+	require.NoError(t, err)
+
+	m, err := newDSMetrics(ds)
+	require.NoError(t, err)
+	require.NotNil(t, m)
+
+	registry := prom.NewRegistry()
+	exporter, err := prometheus.New(prometheus.WithRegisterer(registry),
+		prometheus.WithoutScopeInfo(), prometheus.WithoutTargetInfo())
+	require.NoError(t, err)
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(exporter))
+	defer provider.Shutdown(context.Background())
+	require.NoError(t, m.register(provider.Meter("test")))
+
+	for _, s := range []uint32{5, 50, 500} {
+		data := ds.NewData()
+		require.NoError(t, comm.Set(data, []byte("cat")))
+		require.NoError(t, size.Set(data, make([]byte, 4)))
+		size.PutUint32(data, s)
+		m.record(context.Background(), data)
+	}
+
+	mfs, err := registry.Gather()
+	require.NoError(t, err)
+
+	found := map[string]bool{}
+	for _, mf := range mfs {
+		found[mf.GetName()] = true
+		require.Len(t, mf.Metric, 1, mf.GetName())
+		labels := mf.Metric[0].Label
+		require.Len(t, labels, 1, mf.GetName())
+		require.Equal(t, "comm", labels[0].GetName())
+		require.Equal(t, "cat", labels[0].GetValue())
+
+		switch mf.GetName() {
+		case "open_events_total":
+			require.Equal(t, 3.0, mf.Metric[0].Counter.GetValue())
+		case "open_size":
+			h := mf.Metric[0].Histogram
+			require.Equal(t, uint64(3), h.GetSampleCount())
+			require.Len(t, h.Bucket, 2)
+			require.Equal(t, uint64(1), h.Bucket[0].GetCumulativeCount())
+			require.Equal(t, uint64(2), h.Bucket[1].GetCumulativeCount())
+		}
+	}
+	require.Equal(t, map[string]bool{"open_events_total": true, "open_size": true, "open_fds": true}, found)
+}
+
+func TestDSMetricsInvalid(t *testing.T) {
+	for name, annotations := range map[string]map[string]string{
+		"unknown type":    {AnnotationMetricsType: "summary"},
+		"not numeric":     {AnnotationMetricsType: MetricsTypeCounter},
+		"buckets no hist": {AnnotationMetricsType: MetricsTypeGauge, AnnotationMetricsBuckets: "1"},
+		"invalid buckets": {AnnotationMetricsType: MetricsTypeHistogram, AnnotationMetricsBuckets: "a"},
+	} {
+		ds := datasource.New(datasource.TypeEvent, "test")
+		kind := api.Kind_String
+		if typ := annotations[AnnotationMetricsType]; typ == MetricsTypeHistogram || typ == MetricsTypeGauge {
+			kind = api.Kind_Uint32
+		}
+		_, err := ds.AddField("field", datasource.WithKind(kind), datasource.WithAnnotations(annotations))
+		require.NoError(t, err)
+		_, err = newDSMetrics(ds)
+		require.Error(t, err, name)
+	}
+
+	// Data sources without metrics are ignored
+	ds := datasource.New(datasource.TypeEvent, "test")
+	_, err := ds.AddField("field", datasource.WithKind(api.Kind_String))
+	require.NoError(t, err)
+	m, err := newDSMetrics(ds)
+	require.NoError(t, err)
+	require.Nil(t, m)
+}
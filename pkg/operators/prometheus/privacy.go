@@ -0,0 +1,139 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// privacyGatherer post-processes metrics before they're exported, so that statistics can be shared without exposing
+// individual workloads:
+//   - groups (label combinations) of counters and histograms with fewer than minGroupSize observations are suppressed
+//   - Laplace noise with a scale of 1/epsilon is added to the remaining counts and to gauges. The sums of histograms
+//     get noise scaled by their mean observation, i.e. the same relative noise as their counts.
+//
+// Noise is derived from the series and its current value using a random per-process key instead of being drawn on
+// every scrape; otherwise the true value could be recovered by averaging multiple scrapes of the same value.
+type privacyGatherer struct {
+	gatherer     prom.Gatherer
+	minGroupSize uint64
+	epsilon      float64
+	key          []byte
+}
+
+func newPrivacyGatherer(gatherer prom.Gatherer, minGroupSize uint64, epsilon float64) (*privacyGatherer, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &privacyGatherer{
+		gatherer:     gatherer,
+		minGroupSize: minGroupSize,
+		epsilon:      epsilon,
+		key:          key,
+	}, nil
+}
+
+func (g *privacyGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.gatherer.Gather()
+	if err != nil || (g.minGroupSize == 0 && g.epsilon <= 0) {
+		return mfs, err
+	}
+
+	res := mfs[:0]
+	for _, mf := range mfs {
+		metrics := mf.Metric[:0]
+		for _, m := range mf.Metric {
+			if g.apply(mf.GetName(), mf.GetType(), m) {
+				metrics = append(metrics, m)
+			}
+		}
+		if len(metrics) == 0 {
+			continue
+		}
+		mf.Metric = metrics
+		res = append(res, mf)
+	}
+	return res, nil
+}
+
+// apply suppresses or adds noise to m and returns whether it should be exported
+func (g *privacyGatherer) apply(name string, typ dto.MetricType, m *dto.Metric) bool {
+	switch {
+	case typ == dto.MetricType_COUNTER && m.Counter != nil:
+		value := m.Counter.GetValue()
+		if value < float64(g.minGroupSize) {
+			return false
+		}
+		m.Counter.Value = proto.Float64(g.noisyCount(name, m, 0, value))
+	case typ == dto.MetricType_GAUGE && m.Gauge != nil:
+		m.Gauge.Value = proto.Float64(m.Gauge.GetValue() + g.noise(name, m, 0, m.Gauge.GetValue()))
+	case typ == dto.MetricType_HISTOGRAM && m.Histogram != nil:
+		count := m.Histogram.GetSampleCount()
+		if count < g.minGroupSize {
+			return false
+		}
+		// Cumulative bucket counts must not decrease after adding noise
+		var last float64
+		for i, b := range m.Histogram.Bucket {
+			last = math.Max(last, g.noisyCount(name, m, i+1, float64(b.GetCumulativeCount())))
+			b.CumulativeCount = proto.Uint64(uint64(last))
+		}
+		m.Histogram.SampleCount = proto.Uint64(uint64(math.Max(last, g.noisyCount(name, m, 0, float64(count)))))
+		if count > 0 {
+			sum := m.Histogram.GetSampleSum()
+			mean := math.Abs(sum) / float64(count)
+			m.Histogram.SampleSum = proto.Float64(sum + mean*g.noise(name, m, len(m.Histogram.Bucket)+1, sum))
+		}
+	}
+	return true
+}
+
+// noisyCount returns value with Laplace noise added, rounded and clamped to non-negative integers
+func (g *privacyGatherer) noisyCount(name string, m *dto.Metric, idx int, value float64) float64 {
+	return math.Max(0, math.Round(value+g.noise(name, m, idx, value)))
+}
+
+// noise returns Laplace noise with a scale of 1/epsilon for value; idx distinguishes multiple values of the same
+// series
+func (g *privacyGatherer) noise(name string, m *dto.Metric, idx int, value float64) float64 {
+	if g.epsilon <= 0 {
+		return 0
+	}
+
+	h := hmac.New(sha256.New, g.key)
+	h.Write([]byte(name))
+	for _, l := range m.Label {
+		h.Write([]byte{0})
+		h.Write([]byte(l.GetName()))
+		h.Write([]byte{0})
+		h.Write([]byte(l.GetValue()))
+	}
+	h.Write(binary.LittleEndian.AppendUint64(nil, uint64(idx)))
+	h.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(value)))
+	sum := h.Sum(nil)
+
+	// uniform value in (-0.5, 0.5), transformed into a Laplace distributed one
+	u := (float64(binary.LittleEndian.Uint64(sum)>>11)+0.5)/(1<<53) - 0.5
+	return -math.Copysign(1/g.epsilon, u) * math.Log(1-2*math.Abs(u))
+}
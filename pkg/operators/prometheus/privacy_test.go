@@ -0,0 +1,115 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"testing"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRegistry(t *testing.T) *prom.Registry {
+	registry := prom.NewRegistry()
+
+	counter := prom.NewCounterVec(prom.CounterOpts{Name: "events_total"}, []string{"comm"})
+	counter.WithLabelValues("rare").Add(2)
+	counter.WithLabelValues("common").Add(1000)
+
+	histogram := prom.NewHistogramVec(prom.HistogramOpts{Name: "latency", Buckets: []float64{1, 10}}, []string{"comm"})
+	histogram.WithLabelValues("rare").Observe(5)
+	for i := 0; i < 500; i++ {
+		histogram.WithLabelValues("common").Observe(float64(i % 20))
+	}
+
+	gauge := prom.NewGauge(prom.GaugeOpts{Name: "tracked"})
+	gauge.Set(1)
+
+	registry.MustRegister(counter, histogram, gauge)
+	return registry
+}
+
+func TestPrivacyGathererSuppression(t *testing.T) {
+	g, err := newPrivacyGatherer(newTestRegistry(t), 10, 0)
+	require.NoError(t, err)
+
+	mfs, err := g.Gather()
+	require.NoError(t, err)
+
+	values := map[string]int{}
+	for _, mf := range mfs {
+		values[mf.GetName()] = len(mf.Metric)
+		for _, m := range mf.Metric {
+			for _, l := range m.Label {
+				require.NotEqual(t, "rare", l.GetValue(), mf.GetName())
+			}
+		}
+	}
+	require.Equal(t, map[string]int{"events_total": 1, "latency": 1, "tracked": 1}, values)
+}
+
+func TestPrivacyGathererNoise(t *testing.T) {
+	g, err := newPrivacyGatherer(newTestRegistry(t), 0, 0.5)
+	require.NoError(t, err)
+
+	first, err := g.Gather()
+	require.NoError(t, err)
+	second, err := g.Gather()
+	require.NoError(t, err)
+
+	// The same values must get the same noise, otherwise it could be averaged out
+	require.Equal(t, first[0].String(), second[0].String())
+
+	for _, mf := range first {
+		for _, m := range mf.Metric {
+			if h := m.Histogram; h != nil {
+				var last uint64
+				for _, b := range h.Bucket {
+					require.GreaterOrEqual(t, b.GetCumulativeCount(), last)
+					last = b.GetCumulativeCount()
+				}
+				require.GreaterOrEqual(t, h.GetSampleCount(), last)
+			}
+			if c := m.Counter; c != nil {
+				require.GreaterOrEqual(t, c.GetValue(), 0.0)
+				require.Equal(t, float64(int64(c.GetValue())), c.GetValue())
+			}
+		}
+	}
+}
+
+func TestPrivacyGathererNoiseValues(t *testing.T) {
+	g, err := newPrivacyGatherer(newTestRegistry(t), 0, 0.5)
+	require.NoError(t, err)
+
+	mfs, err := g.Gather()
+	require.NoError(t, err)
+
+	// Neither sums nor gauges must leak the true values
+	checked := 0
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			if h := m.Histogram; h != nil && h.GetSampleCount() > 100 {
+				require.NotEqual(t, 4750.0, h.GetSampleSum())
+				checked++
+			}
+			if gauge := m.Gauge; gauge != nil {
+				require.NotEqual(t, 1.0, gauge.GetValue())
+				checked++
+			}
+		}
+	}
+	require.Equal(t, 2, checked)
+}
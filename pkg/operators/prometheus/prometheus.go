@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"net/http"
 
+	prom "github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/exporters/prometheus"
@@ -39,6 +40,8 @@ const (
 	// ParamEnableMetrics = "enable-metrics"
 	ParamListenAddress = "metrics-listen-address"
 	ParamMetricsPath   = "metrics-path"
+	ParamMinGroupSize  = "metrics-min-group-size"
+	ParamNoiseEpsilon  = "metrics-noise-epsilon"
 	// keep aligned with values in pkg/resources/manifests/deploy.yaml
 	DefaultListenAddr  = "0.0.0.0:2223"
 	DefaultMetricsPath = "/metrics"
@@ -81,7 +84,7 @@ func (p *Prometheus) GlobalParamDescs() params.ParamDescs {
 			Key:          ParamListenAddress,
 			Title:        "Listen address",
 			DefaultValue: DefaultListenAddr,
-			Description:  "Address to serve prometheus metrics on",
+			Description:  "Address to serve prometheus metrics on; metrics aren't served if empty",
 		},
 		{
 			Key:          ParamMetricsPath,
@@ -89,6 +92,20 @@ func (p *Prometheus) GlobalParamDescs() params.ParamDescs {
 			DefaultValue: DefaultMetricsPath,
 			Description:  "Path to export prometheus metrics on",
 		},
+		{
+			Key:          ParamMinGroupSize,
+			Title:        "Minimum group size",
+			DefaultValue: "0",
+			Description:  "Don't export counters and histograms of groups with fewer observations than this; 0 disables suppression",
+			TypeHint:     params.TypeUint64,
+		},
+		{
+			Key:          ParamNoiseEpsilon,
+			Title:        "Noise epsilon",
+			DefaultValue: "0",
+			Description:  "Add Laplace noise with a scale of 1/epsilon to exported values; smaller values add more noise, 0 disables noise",
+			TypeHint:     params.TypeFloat64,
+		},
 	}
 }
 
@@ -101,19 +118,38 @@ func (p *Prometheus) Init(globalParams *params.Params) error {
 	//	return nil
 	//}
 
-	exporter, err := prometheus.New()
+	// Gadget metrics use their own registry, so only they are subject to suppression and noise
+	registry := prom.NewRegistry()
+	exporter, err := prometheus.New(prometheus.WithRegisterer(registry))
 	if err != nil {
 		return fmt.Errorf("initialize prometheus exporter: %w", err)
 	}
 	p.exporter = exporter
 	p.meterProvider = sdkmetric.NewMeterProvider(sdkmetric.WithReader(exporter), sdkmetric.WithView(p.histogramViewFunc()))
 
+	minGroupSize := globalParams.Get(ParamMinGroupSize).AsUint64()
+	epsilon := globalParams.Get(ParamNoiseEpsilon).AsFloat64()
+	if epsilon < 0 {
+		return fmt.Errorf("%s must not be negative", ParamNoiseEpsilon)
+	}
+	gadgetGatherer, err := newPrivacyGatherer(registry, minGroupSize, epsilon)
+	if err != nil {
+		return fmt.Errorf("initialize privacy gatherer: %w", err)
+	}
+	gatherer := prom.Gatherers{prom.DefaultGatherer, gadgetGatherer}
+
 	listenAddress := globalParams.Get(ParamListenAddress).AsString()
 	metricsPath := globalParams.Get(ParamMetricsPath).AsString()
 
+	if listenAddress == "" {
+		return nil
+	}
+
 	go func() {
 		mux := http.NewServeMux()
-		mux.Handle(metricsPath, promhttp.Handler())
+		mux.Handle(metricsPath, promhttp.InstrumentMetricHandler(
+			prom.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}),
+		))
 		err := http.ListenAndServe(listenAddress, mux)
 		if err != nil {
 			log.Errorf("serving http: %s", err)
@@ -0,0 +1,125 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reversedns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lookupFunc returns the name of ip or an empty string if it has none
+type lookupFunc func(ctx context.Context, ip string) (string, error)
+
+// lookupAddr resolves ip using reverse DNS
+func lookupAddr(ctx context.Context, ip string) (string, error) {
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	return strings.TrimSuffix(names[0], "."), nil
+}
+
+type cacheEntry struct {
+	name    string
+	expires time.Time
+}
+
+// nameCache caches the names of IP addresses for ttl. Names are resolved in the background, so looking them up never
+// blocks the processing of events; until a name is resolved, an empty name (or the expired one) is returned.
+type nameCache struct {
+	ctx        context.Context
+	lookup     lookupFunc
+	ttl        time.Duration
+	timeout    time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	// sem limits the number of concurrent lookups
+	sem chan struct{}
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	pending map[string]struct{}
+}
+
+func newNameCache(ctx context.Context, lookup lookupFunc, ttl time.Duration) *nameCache {
+	return &nameCache{
+		ctx:        ctx,
+		lookup:     lookup,
+		ttl:        ttl,
+		timeout:    lookupTimeout,
+		maxEntries: maxCacheEntries,
+		now:        time.Now,
+		sem:        make(chan struct{}, maxConcurrentLookups),
+		entries:    make(map[string]cacheEntry),
+		pending:    make(map[string]struct{}),
+	}
+}
+
+// Get returns the cached name of ip and starts resolving it if it's not cached or expired
+func (c *nameCache) Get(ip string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[ip]
+	if ok && c.now().Before(entry.expires) {
+		return entry.name
+	}
+	if _, ok := c.pending[ip]; ok {
+		return entry.name
+	}
+
+	// Drop the lookup instead of queueing it if too many are running already; it's retried with the next event
+	select {
+	case c.sem <- struct{}{}:
+	default:
+		return entry.name
+	}
+	c.pending[ip] = struct{}{}
+	go c.resolve(ip)
+	return entry.name
+}
+
+func (c *nameCache) resolve(ip string) {
+	defer func() { <-c.sem }()
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	name, err := c.lookup(ctx, ip)
+	cancel()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, ip)
+	if err != nil {
+		// Keep a previous name, it's likely still valid; failures are cached as well to not retry for every event
+		name = c.entries[ip].name
+	}
+	if len(c.entries) >= c.maxEntries {
+		// Simply start over instead of tracking usage; active addresses will quickly be cached again
+		clear(c.entries)
+	}
+	c.entries[ip] = cacheEntry{name: name, expires: c.now().Add(c.ttl)}
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reversedns
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeResolver resolves addresses using names and counts the lookups
+type fakeResolver struct {
+	mu      sync.Mutex
+	names   map[string]string
+	err     error
+	lookups atomic.Int32
+}
+
+func (r *fakeResolver) set(ip, name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.names[ip] = name
+	r.err = err
+}
+
+func (r *fakeResolver) lookup(ctx context.Context, ip string) (string, error) {
+	r.lookups.Add(1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.names[ip], r.err
+}
+
+func TestNameCache(t *testing.T) {
+	r := &fakeResolver{names: map[string]string{"10.0.0.1": "one.example.com"}}
+
+	var nowMu sync.Mutex
+	now := time.Now()
+	c := newNameCache(context.Background(), r.lookup, time.Minute)
+	c.now = func() time.Time {
+		nowMu.Lock()
+		defer nowMu.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		nowMu.Lock()
+		defer nowMu.Unlock()
+		now = now.Add(d)
+	}
+
+	// The name is resolved in the background
+	require.Equal(t, "", c.Get("10.0.0.1"))
+	require.Eventually(t, func() bool { return c.Get("10.0.0.1") == "one.example.com" }, time.Second, time.Millisecond)
+	require.EqualValues(t, 1, r.lookups.Load())
+
+	// Cached names aren't looked up again until they expire; expired names are returned until refreshed
+	r.set("10.0.0.1", "new.example.com", nil)
+	require.Equal(t, "one.example.com", c.Get("10.0.0.1"))
+	advance(time.Minute)
+	require.Equal(t, "one.example.com", c.Get("10.0.0.1"))
+	require.Eventually(t, func() bool { return c.Get("10.0.0.1") == "new.example.com" }, time.Second, time.Millisecond)
+	require.EqualValues(t, 2, r.lookups.Load())
+
+	// Failed lookups keep the previous name
+	r.set("10.0.0.1", "", errors.New("timeout"))
+	advance(time.Minute)
+	c.Get("10.0.0.1")
+	require.Eventually(t, func() bool { return r.lookups.Load() == 3 }, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.pending) == 0
+	}, time.Second, time.Millisecond)
+	require.Equal(t, "new.example.com", c.Get("10.0.0.1"))
+	require.EqualValues(t, 3, r.lookups.Load())
+}
+
+func TestNameCacheFull(t *testing.T) {
+	r := &fakeResolver{names: map[string]string{}}
+	c := newNameCache(context.Background(), r.lookup, time.Minute)
+	c.maxEntries = 2
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		c.Get(ip)
+		require.Eventually(t, func() bool {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			_, ok := c.entries[ip]
+			return ok
+		}, time.Second, time.Millisecond)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	require.Len(t, c.entries, 1)
+}
@@ -0,0 +1,275 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package reversedns provides an operator that adds the names of the IP addresses of L3 and L4 endpoints to events,
+// using reverse DNS and optionally the pods and services of the Kubernetes cluster. Names are only looked up if the
+// resulting fields are read, and reverse DNS lookups are cached and done in the background.
+package reversedns
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/common"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/formatters"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/ioc"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	OperatorName = "reversedns"
+
+	ParamEnable     = "reversedns-enable"
+	ParamTTL        = "reversedns-ttl"
+	ParamKubernetes = "reversedns-kubernetes"
+
+	// Priority is chosen so that the names are available before the ioc operator runs
+	Priority = ioc.Priority - 100
+
+	lookupTimeout        = 2 * time.Second
+	maxConcurrentLookups = 16
+	maxCacheEntries      = 16384
+)
+
+type reverseDNSOperator struct {
+	lookup lookupFunc
+}
+
+func (o *reverseDNSOperator) Name() string {
+	return OperatorName
+}
+
+func (o *reverseDNSOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *reverseDNSOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *reverseDNSOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:          ParamEnable,
+			Title:        "Resolve endpoint names",
+			Description:  "Add the names of the IP addresses of endpoints, e.g. dst_name; disable for high-throughput runs",
+			DefaultValue: "true",
+			TypeHint:     api.TypeBool,
+		},
+		{
+			Key:          ParamTTL,
+			Title:        "Name cache TTL",
+			Description:  "Time names resolved using reverse DNS are cached for",
+			DefaultValue: "5m",
+			TypeHint:     api.TypeDuration,
+		},
+		{
+			Key:          ParamKubernetes,
+			Title:        "Resolve Kubernetes names",
+			Description:  "Look up pods and services by IP address before using reverse DNS; names are shown as p/namespace/pod and s/namespace/service",
+			DefaultValue: "false",
+			TypeHint:     api.TypeBool,
+		},
+	}
+}
+
+// endpoint references the address of an L3 endpoint; owner is the field the name is added for, i.e. the L4 endpoint
+// if the L3 endpoint is part of one
+type endpoint struct {
+	owner   datasource.FieldAccessor
+	addr    datasource.FieldAccessor
+	version datasource.FieldAccessor
+}
+
+// findEndpoints returns the endpoints of ds
+func findEndpoints(ds datasource.DataSource) []endpoint {
+	var endpoints []endpoint
+
+	l4Names := make(map[string]struct{})
+	for _, l4 := range ds.GetFieldsWithTag("type:" + formatters.L4EndpointTypeName) {
+		l3 := l4.GetSubFieldsWithTag("type:" + formatters.L3EndpointTypeName)
+		if len(l3) != 1 {
+			continue
+		}
+		l4Names[l4.Name()] = struct{}{}
+		if ep, ok := newEndpoint(l4, l3[0]); ok {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	for _, l3 := range ds.GetFieldsWithTag("type:" + formatters.L3EndpointTypeName) {
+		if parent := l3.Parent(); parent != nil {
+			if _, ok := l4Names[parent.Name()]; ok {
+				continue
+			}
+		}
+		if ep, ok := newEndpoint(l3, l3); ok {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	return endpoints
+}
+
+func newEndpoint(owner, l3 datasource.FieldAccessor) (endpoint, bool) {
+	addrs := l3.GetSubFieldsWithTag("type:gadget_ip_addr_t")
+	versions := l3.GetSubFieldsWithTag("name:version")
+	if len(addrs) != 1 || len(versions) != 1 {
+		return endpoint{}, false
+	}
+	return endpoint{owner: owner, addr: addrs[0], version: versions[0]}, true
+}
+
+// ip returns the address of the endpoint as string, or an empty string if it's not set
+func (e *endpoint) ip(data datasource.Data) string {
+	addr := e.addr.Get(data)
+	version := e.version.Get(data)
+	if len(addr) != 16 || len(version) != 1 {
+		return ""
+	}
+	var ip net.IP
+	switch version[0] {
+	case 4:
+		ip = net.IP(addr[:4])
+	case 6:
+		ip = net.IP(addr)
+	default:
+		return ""
+	}
+	if ip.IsUnspecified() {
+		return ""
+	}
+	return ip.String()
+}
+
+func (o *reverseDNSOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if !params.Get(ParamEnable).AsBool() {
+		return nil, nil
+	}
+
+	type dsEndpoint struct {
+		ds datasource.DataSource
+		endpoint
+	}
+	var endpoints []dsEndpoint
+	for _, ds := range gadgetCtx.GetDataSources() {
+		for _, ep := range findEndpoints(ds) {
+			endpoints = append(endpoints, dsEndpoint{ds: ds, endpoint: ep})
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil, nil
+	}
+
+	inst := &reverseDNSOperatorInstance{
+		cache: newNameCache(gadgetCtx.Context(), o.lookup, params.Get(ParamTTL).AsDuration()),
+	}
+	if params.Get(ParamKubernetes).AsBool() {
+		inst.k8sInventory, err = common.GetK8sInventoryCache()
+		if err != nil {
+			return nil, fmt.Errorf("creating k8s inventory cache: %w", err)
+		}
+	}
+
+	for _, ep := range endpoints {
+		// e.g. "dst" -> "dst_name"
+		name := ep.owner.Name() + "_name"
+		if ep.ds.GetField(name) != nil {
+			gadgetCtx.Logger().Debugf("reversedns: field %q already exists", name)
+			continue
+		}
+		acc, err := ep.ds.AddField(name,
+			datasource.WithKind(api.Kind_String),
+			datasource.WithAnnotations(map[string]string{
+				"description":   "Name of the address of " + ep.owner.Name(),
+				"columns.width": "32",
+			}),
+			datasource.WithFlags(datasource.FieldFlagHidden),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("adding field %q: %w", name, err)
+		}
+		ep := ep.endpoint
+		err = acc.SetDecoder(func(ds datasource.DataSource, data datasource.Data) []byte {
+			return []byte(inst.name(ep.ip(data)))
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return inst, nil
+}
+
+func (o *reverseDNSOperator) Priority() int {
+	return Priority
+}
+
+// reverseDNSOperatorInstance doesn't need to subscribe to anything, as names are looked up when they're read
+type reverseDNSOperatorInstance struct {
+	cache        *nameCache
+	k8sInventory common.K8sInventoryCache
+}
+
+func (o *reverseDNSOperatorInstance) Name() string {
+	return OperatorName
+}
+
+// name returns the name of ip, preferring Kubernetes resources over reverse DNS
+func (o *reverseDNSOperatorInstance) name(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	if o.k8sInventory != nil {
+		// Pods using the host network share the IP of the node, so they don't identify the endpoint
+		if pod := o.k8sInventory.GetPodByIp(ip); pod != nil && !pod.Spec.HostNetwork {
+			return "p/" + pod.Namespace + "/" + pod.Name
+		}
+		if svc := o.k8sInventory.GetSvcByIp(ip); svc != nil {
+			return "s/" + svc.Namespace + "/" + svc.Name
+		}
+	}
+	return o.cache.Get(ip)
+}
+
+func (o *reverseDNSOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error {
+	if o.k8sInventory != nil {
+		o.k8sInventory.Start()
+	}
+	return nil
+}
+
+func (o *reverseDNSOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+func (o *reverseDNSOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	if o.k8sInventory != nil {
+		o.k8sInventory.Stop()
+	}
+	return nil
+}
+
+func init() {
+	operators.RegisterDataOperator(&reverseDNSOperator{lookup: lookupAddr})
+}
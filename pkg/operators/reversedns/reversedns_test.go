@@ -0,0 +1,133 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reversedns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/formatters"
+)
+
+type testEndpoint struct {
+	addr    datasource.FieldAccessor
+	version datasource.FieldAccessor
+}
+
+func addL3Endpoint(t *testing.T, parent datasource.FieldAccessor, ds datasource.DataSource, name string) testEndpoint {
+	var l3 datasource.FieldAccessor
+	var err error
+	opts := []datasource.FieldOption{
+		datasource.WithTags("type:" + formatters.L3EndpointTypeName),
+		datasource.WithFlags(datasource.FieldFlagEmpty),
+	}
+	if parent != nil {
+		l3, err = parent.AddSubField(name, opts...)
+	} else {
+		l3, err = ds.AddField(name, opts...)
+	}
+	require.NoError(t, err)
+
+	addr, err := l3.AddSubField("addr", datasource.WithTags("type:gadget_ip_addr_t"))
+	require.NoError(t, err)
+	version, err := l3.AddSubField("version", datasource.WithKind(api.Kind_Uint8), datasource.WithTags("name:version"))
+	require.NoError(t, err)
+	return testEndpoint{addr: addr, version: version}
+}
+
+func (e testEndpoint) set(t *testing.T, data datasource.Data, ip string) {
+	parsed := net.ParseIP(ip)
+	addr := make([]byte, 16)
+	version := byte(6)
+	if v4 := parsed.To4(); v4 != nil {
+		copy(addr, v4)
+		version = 4
+	} else {
+		copy(addr, parsed)
+	}
+	require.NoError(t, e.addr.Set(data, addr))
+	require.NoError(t, e.version.Set(data, []byte{version}))
+}
+
+func TestReverseDNSOperator(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+
+	src := addL3Endpoint(t, nil, ds, "src")
+	l4, err := ds.AddField("dst", datasource.WithTags("type:"+formatters.L4EndpointTypeName), datasource.WithFlags(datasource.FieldFlagEmpty))
+	require.NoError(t, err)
+	dst := addL3Endpoint(t, l4, ds, "addr_raw")
+
+	r := &fakeResolver{names: map[string]string{
+		"10.0.0.1":    "src.example.com",
+		"2001:db8::1": "dst.example.com",
+	}}
+	op := &reverseDNSOperator{lookup: r.lookup}
+	inst, err := op.InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+	require.NoError(t, err)
+	require.NotNil(t, inst)
+
+	srcName := ds.GetField("src_name")
+	require.NotNil(t, srcName)
+	dstName := ds.GetField("dst_name")
+	require.NotNil(t, dstName)
+	// The L3 endpoint of dst doesn't get its own name
+	require.Nil(t, ds.GetField("addr_raw_name"))
+
+	var names []string
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		names = []string{string(srcName.Get(data)), string(dstName.Get(data))}
+		return nil
+	}, Priority+1)
+
+	emit := func(srcIP, dstIP string) {
+		data := ds.NewData()
+		src.set(t, data, srcIP)
+		dst.set(t, data, dstIP)
+		require.NoError(t, ds.EmitAndRelease(data))
+	}
+
+	require.Eventually(t, func() bool {
+		emit("10.0.0.1", "2001:db8::1")
+		return names[0] == "src.example.com" && names[1] == "dst.example.com"
+	}, time.Second, time.Millisecond)
+
+	// Unspecified addresses aren't looked up
+	lookups := r.lookups.Load()
+	emit("0.0.0.0", "::")
+	require.Equal(t, []string{"", ""}, names)
+	require.Equal(t, lookups, r.lookups.Load())
+}
+
+func TestReverseDNSOperatorDisabled(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+	addL3Endpoint(t, nil, ds, "src")
+
+	op := &reverseDNSOperator{lookup: (&fakeResolver{}).lookup}
+	inst, err := op.InstantiateDataOperator(gadgetCtx, api.ParamValues{ParamEnable: "false"})
+	require.NoError(t, err)
+	require.Nil(t, inst)
+	require.Nil(t, ds.GetField("src_name"))
+}
@@ -0,0 +1,233 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sample provides an operator that samples the events of a data source by novelty: only the first N events
+// of each unique key (e.g. the first opens of each path per container) are emitted, capturing the breadth of what
+// happened without its volume.
+package sample
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/filter"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	OperatorName = "sample"
+
+	ParamSource  = "sample-source"
+	ParamFirst   = "sample-first"
+	ParamKeys    = "sample-keys"
+	ParamExpiry  = "sample-key-expiry"
+	ParamMaxKeys = "sample-max-keys"
+
+	// Priority is chosen so that only events that passed the filter operator are sampled, while the aggregate
+	// operator still sees all of them
+	Priority = filter.Priority + 60
+)
+
+type sampleOperator struct{}
+
+func (o *sampleOperator) Name() string {
+	return OperatorName
+}
+
+func (o *sampleOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *sampleOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *sampleOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:         ParamSource,
+			Title:       "Sampled data source",
+			Description: "Data source to sample; can be omitted if the gadget only has one",
+			TypeHint:    api.TypeString,
+		},
+		{
+			Key:          ParamFirst,
+			Title:        "Sample first events",
+			Description:  "Only emit the first N events of each unique key; 0 disables sampling",
+			DefaultValue: "0",
+			TypeHint:     api.TypeUint32,
+		},
+		{
+			Key:         ParamKeys,
+			Title:       "Sample keys",
+			Description: "Comma-separated fields identifying unique events, e.g. 'runtime.containerName,fname'",
+			TypeHint:    api.TypeString,
+		},
+		{
+			Key:   ParamExpiry,
+			Title: "Sample key expiry",
+			Description: "Forget keys after this time, so that their events are emitted again; 0 remembers keys " +
+				"for as long as the gadget runs",
+			DefaultValue: "5m",
+			TypeHint:     api.TypeDuration,
+		},
+		{
+			Key:          ParamMaxKeys,
+			Title:        "Maximum number of sample keys",
+			Description:  "Maximum number of keys to remember; events of further keys are dropped until keys expire",
+			DefaultValue: "10240",
+			TypeHint:     api.TypeUint32,
+		},
+	}
+}
+
+func (o *sampleOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &sampleOperatorInstance{}
+
+	first := params.Get(ParamFirst).AsUint32()
+	if first == 0 {
+		return inst, nil
+	}
+
+	var keys []string
+	for _, key := range strings.Split(params.Get(ParamKeys).AsString(), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s needs to be set to use %s", ParamKeys, ParamFirst)
+	}
+
+	expiry := params.Get(ParamExpiry).AsDuration()
+	if expiry < 0 {
+		return nil, fmt.Errorf("%s must not be negative", ParamExpiry)
+	}
+	maxKeys := params.Get(ParamMaxKeys).AsUint32()
+	if maxKeys == 0 {
+		return nil, fmt.Errorf("%s must be greater than 0", ParamMaxKeys)
+	}
+
+	ds, err := sourceDataSource(gadgetCtx, strings.TrimSpace(params.Get(ParamSource).AsString()))
+	if err != nil {
+		return nil, err
+	}
+	inst.sampler, err = newSampler(ds, keys, first, expiry, int(maxKeys))
+	if err != nil {
+		return nil, fmt.Errorf("sampling data source %q: %w", ds.Name(), err)
+	}
+	return inst, nil
+}
+
+// sourceDataSource returns the data source called name or, if name is empty, the only data source of the gadget
+func sourceDataSource(gadgetCtx operators.GadgetContext, name string) (datasource.DataSource, error) {
+	dataSources := gadgetCtx.GetDataSources()
+	if name != "" {
+		ds, ok := dataSources[name]
+		if !ok {
+			return nil, fmt.Errorf("data source %q not found", name)
+		}
+		return ds, nil
+	}
+	if len(dataSources) != 1 {
+		return nil, fmt.Errorf("%s needs to be set, as the gadget has %d data sources", ParamSource, len(dataSources))
+	}
+	for _, ds := range dataSources {
+		return ds, nil
+	}
+	return nil, nil
+}
+
+func (o *sampleOperator) Priority() int {
+	return Priority
+}
+
+type sampleOperatorInstance struct {
+	// sampler is nil if sampling isn't enabled
+	sampler *sampler
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func (o *sampleOperatorInstance) Name() string {
+	return OperatorName
+}
+
+func (o *sampleOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error {
+	if o.sampler == nil {
+		return nil
+	}
+	o.sampler.ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		if !o.sampler.keep(data, time.Now()) {
+			return datasource.ErrDiscard
+		}
+		return nil
+	}, Priority)
+	return nil
+}
+
+func (o *sampleOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	if o.sampler == nil || o.sampler.expiry == 0 {
+		return nil
+	}
+
+	// Expired keys are reset when they are seen again; pruning them periodically frees the memory of keys that
+	// aren't seen anymore
+	o.done = make(chan struct{})
+	o.stopped = make(chan struct{})
+	go func() {
+		defer close(o.stopped)
+		ticker := time.NewTicker(o.sampler.expiry)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-o.done:
+				return
+			case <-gadgetCtx.Context().Done():
+				return
+			case now := <-ticker.C:
+				if dropped := o.sampler.prune(now); dropped > 0 {
+					gadgetCtx.Logger().Warnf("dropped %d events exceeding %s", dropped, ParamMaxKeys)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (o *sampleOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	if o.done == nil {
+		return nil
+	}
+	close(o.done)
+	<-o.stopped
+	o.done = nil
+	return nil
+}
+
+func init() {
+	operators.RegisterDataOperator(&sampleOperator{})
+}
@@ -0,0 +1,147 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sample
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+type testSource struct {
+	ds        datasource.DataSource
+	container datasource.FieldAccessor
+	fname     datasource.FieldAccessor
+}
+
+func newTestSource(t *testing.T, gadgetCtx *gadgetcontext.GadgetContext) *testSource {
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "open")
+	require.NoError(t, err)
+	container, err := ds.AddField("container", datasource.WithKind(api.Kind_String))
+	require.NoError(t, err)
+	fname, err := ds.AddField("fname", datasource.WithKind(api.Kind_String))
+	require.NoError(t, err)
+	return &testSource{ds: ds, container: container, fname: fname}
+}
+
+func (s *testSource) newData(t *testing.T, container, fname string) datasource.Data {
+	data := s.ds.NewData()
+	require.NoError(t, s.container.Set(data, []byte(container)))
+	require.NoError(t, s.fname.Set(data, []byte(fname)))
+	return data
+}
+
+func TestSampleOperator(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	src := newTestSource(t, gadgetCtx)
+
+	inst, err := (&sampleOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{
+		ParamFirst: "2",
+		ParamKeys:  "container, fname",
+	})
+	require.NoError(t, err)
+	require.NoError(t, inst.(*sampleOperatorInstance).PreStart(gadgetCtx))
+
+	type event struct{ container, fname string }
+	var events []event
+	src.ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		events = append(events, event{src.container.String(data), src.fname.String(data)})
+		return nil
+	}, Priority+1)
+
+	for _, e := range []event{
+		{"a", "/etc/passwd"},
+		{"a", "/etc/passwd"},
+		{"a", "/etc/passwd"},
+		{"b", "/etc/passwd"},
+		{"a", "/etc/hosts"},
+		{"b", "/etc/passwd"},
+		{"b", "/etc/passwd"},
+	} {
+		require.NoError(t, src.ds.EmitAndRelease(src.newData(t, e.container, e.fname)))
+	}
+	require.Equal(t, []event{
+		{"a", "/etc/passwd"},
+		{"a", "/etc/passwd"},
+		{"b", "/etc/passwd"},
+		{"a", "/etc/hosts"},
+		{"b", "/etc/passwd"},
+	}, events)
+}
+
+func TestSamplerExpiry(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	src := newTestSource(t, gadgetCtx)
+
+	s, err := newSampler(src.ds, []string{"fname"}, 1, time.Minute, 2)
+	require.NoError(t, err)
+
+	now := time.Now()
+	passwd := src.newData(t, "a", "/etc/passwd")
+	hosts := src.newData(t, "a", "/etc/hosts")
+	shadow := src.newData(t, "a", "/etc/shadow")
+
+	require.True(t, s.keep(passwd, now))
+	require.False(t, s.keep(passwd, now.Add(30*time.Second)))
+	require.True(t, s.keep(hosts, now.Add(30*time.Second)))
+
+	// Events of new keys are dropped once too many keys are remembered
+	require.False(t, s.keep(shadow, now.Add(30*time.Second)))
+
+	// Expired keys are emitted again
+	require.True(t, s.keep(passwd, now.Add(time.Minute)))
+	require.False(t, s.keep(passwd, now.Add(time.Minute)))
+
+	// Pruning frees the keys that haven't been seen since they expired
+	require.Equal(t, uint64(1), s.prune(now.Add(90*time.Second)))
+	require.Len(t, s.seen, 1)
+	require.True(t, s.keep(shadow, now.Add(90*time.Second)))
+}
+
+func TestSampleOperatorParams(t *testing.T) {
+	for name, tc := range map[string]struct {
+		values  api.ParamValues
+		enabled bool
+		err     bool
+	}{
+		"disabled":        {values: api.ParamValues{}},
+		"enabled":         {values: api.ParamValues{ParamFirst: "5", ParamKeys: "fname"}, enabled: true},
+		"no expiry":       {values: api.ParamValues{ParamFirst: "5", ParamKeys: "fname", ParamExpiry: "0"}, enabled: true},
+		"missing keys":    {values: api.ParamValues{ParamFirst: "5"}, err: true},
+		"unknown key":     {values: api.ParamValues{ParamFirst: "5", ParamKeys: "foo"}, err: true},
+		"unknown source":  {values: api.ParamValues{ParamFirst: "5", ParamKeys: "fname", ParamSource: "foo"}, err: true},
+		"negative expiry": {values: api.ParamValues{ParamFirst: "5", ParamKeys: "fname", ParamExpiry: "-1s"}, err: true},
+		"no max keys":     {values: api.ParamValues{ParamFirst: "5", ParamKeys: "fname", ParamMaxKeys: "0"}, err: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			gadgetCtx := gadgetcontext.New(context.Background(), "test")
+			newTestSource(t, gadgetCtx)
+
+			inst, err := (&sampleOperator{}).InstantiateDataOperator(gadgetCtx, tc.values)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.enabled, inst.(*sampleOperatorInstance).sampler != nil)
+		})
+	}
+}
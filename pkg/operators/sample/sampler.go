@@ -0,0 +1,113 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sample
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+)
+
+type keyState struct {
+	count     uint32
+	firstSeen time.Time
+}
+
+// sampler counts the events of each key to only keep the first ones
+type sampler struct {
+	ds      datasource.DataSource
+	keys    []datasource.FieldAccessor
+	first   uint32
+	expiry  time.Duration
+	maxKeys int
+
+	mu      sync.Mutex
+	seen    map[string]*keyState
+	dropped uint64
+}
+
+func newSampler(ds datasource.DataSource, keyNames []string, first uint32, expiry time.Duration, maxKeys int) (*sampler, error) {
+	s := &sampler{
+		ds:      ds,
+		first:   first,
+		expiry:  expiry,
+		maxKeys: maxKeys,
+		seen:    make(map[string]*keyState),
+	}
+	for _, name := range keyNames {
+		f := ds.GetField(name)
+		if f == nil {
+			return nil, fmt.Errorf("field %q not found", name)
+		}
+		s.keys = append(s.keys, f)
+	}
+	return s, nil
+}
+
+// key concatenates the lengths and contents of the key fields of data, so that it can't be ambiguous
+func (s *sampler) key(data datasource.Data) string {
+	var key []byte
+	for _, f := range s.keys {
+		value := f.Get(data)
+		key = binary.LittleEndian.AppendUint32(key, uint32(len(value)))
+		key = append(key, value...)
+	}
+	return string(key)
+}
+
+func (s *sampler) expired(state *keyState, now time.Time) bool {
+	return s.expiry > 0 && now.Sub(state.firstSeen) >= s.expiry
+}
+
+// keep returns whether data is one of the first events of its key
+func (s *sampler) keep(data datasource.Data, now time.Time) bool {
+	key := s.key(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.seen[key]
+	if !ok || s.expired(state, now) {
+		if !ok && len(s.seen) >= s.maxKeys {
+			s.dropped++
+			return false
+		}
+		state = &keyState{firstSeen: now}
+		s.seen[key] = state
+	}
+	if state.count >= s.first {
+		return false
+	}
+	state.count++
+	return true
+}
+
+// prune forgets expired keys and returns the number of events dropped because of too many keys since the last call
+func (s *sampler) prune(now time.Time) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, state := range s.seen {
+		if s.expired(state, now) {
+			delete(s.seen, key)
+		}
+	}
+	dropped := s.dropped
+	s.dropped = 0
+	return dropped
+}
@@ -0,0 +1,361 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package snapshotdiff provides an operator that takes the snapshots of snapshotter gadgets periodically and only
+// emits the entries that have been added, removed or changed since the previous snapshot, with an action field
+// telling which one it was.
+package snapshotdiff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/filter"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	OperatorName = "snapshotdiff"
+
+	ParamInterval = "snapshot-diff-interval"
+	ParamKeys     = "snapshot-diff-keys"
+
+	// Priority is chosen so that only entries that passed the filter operator are compared, and that entries can be
+	// dropped before they reach any sink
+	Priority = filter.Priority + 40
+
+	// ActionField is the name of the field added to the data sources of snapshotters
+	ActionField = "action"
+
+	ActionAdded   = "added"
+	ActionRemoved = "removed"
+	ActionChanged = "changed"
+)
+
+type snapshotDiffOperator struct{}
+
+func (o *snapshotDiffOperator) Name() string {
+	return OperatorName
+}
+
+func (o *snapshotDiffOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *snapshotDiffOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *snapshotDiffOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:   ParamInterval,
+			Title: "Snapshot diff interval",
+			Description: "Take snapshots at the given interval and only emit entries that have been added, removed or " +
+				"changed since the previous one; 0 takes a single snapshot",
+			DefaultValue: "0",
+			TypeHint:     api.TypeDuration,
+		},
+		{
+			Key:   ParamKeys,
+			Title: "Snapshot diff keys",
+			Description: "Comma-separated fields identifying an entry, e.g. 'pid,tid'; entries with the same keys but " +
+				"other differing fields are reported as changed. By default, all fields identify an entry",
+			TypeHint: api.TypeString,
+		},
+	}
+}
+
+func (o *snapshotDiffOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &snapshotDiffOperatorInstance{
+		interval: params.Get(ParamInterval).AsDuration(),
+	}
+	if inst.interval < 0 {
+		return nil, fmt.Errorf("%s must not be negative", ParamInterval)
+	}
+	if inst.interval == 0 {
+		return inst, nil
+	}
+
+	v, ok := gadgetCtx.GetVar(operators.SnapshottersVar)
+	if !ok {
+		return nil, fmt.Errorf("%s is only supported by gadgets with snapshotters", ParamInterval)
+	}
+	snapshotFuncs, ok := v.(map[string]operators.SnapshotFunc)
+	if !ok {
+		return nil, fmt.Errorf("invalid snapshotters: expected map[string]operators.SnapshotFunc, got %T", v)
+	}
+
+	var keys []string
+	for _, key := range strings.Split(params.Get(ParamKeys).AsString(), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	dataSources := gadgetCtx.GetDataSources()
+	names := make([]string, 0, len(snapshotFuncs))
+	for name := range snapshotFuncs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		ds, ok := dataSources[name]
+		if !ok {
+			return nil, fmt.Errorf("data source %q of snapshotter not found", name)
+		}
+		s, err := newSnapshot(ds, snapshotFuncs[name], keys)
+		if err != nil {
+			return nil, fmt.Errorf("preparing snapshot diffs of data source %q: %w", name, err)
+		}
+		inst.snapshots = append(inst.snapshots, s)
+	}
+	return inst, nil
+}
+
+func (o *snapshotDiffOperator) Priority() int {
+	return Priority
+}
+
+type entry struct {
+	data        datasource.Data
+	fingerprint uint64
+}
+
+// snapshot keeps the entries of the last snapshot of a data source to compare them to the next one
+type snapshot struct {
+	ds       datasource.DataSource
+	take     operators.SnapshotFunc
+	action   datasource.FieldAccessor
+	keys     []datasource.FieldAccessor
+	seed     maphash.Seed
+	emitting atomic.Bool
+
+	// mu protects current, which collects the entries of the running snapshot
+	mu      sync.Mutex
+	current map[string]entry
+
+	previous map[string]entry
+}
+
+func newSnapshot(ds datasource.DataSource, take operators.SnapshotFunc, keyNames []string) (*snapshot, error) {
+	s := &snapshot{
+		ds:       ds,
+		take:     take,
+		seed:     maphash.MakeSeed(),
+		current:  make(map[string]entry),
+		previous: make(map[string]entry),
+	}
+	for _, name := range keyNames {
+		f := ds.GetField(name)
+		if f == nil {
+			return nil, fmt.Errorf("field %q not found", name)
+		}
+		s.keys = append(s.keys, f)
+	}
+
+	var err error
+	s.action, err = ds.AddField(ActionField, datasource.WithKind(api.Kind_String), datasource.WithAnnotations(map[string]string{
+		"description":   "Whether the entry has been added, removed or changed since the previous snapshot",
+		"columns.width": "7",
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("adding field %q: %w", ActionField, err)
+	}
+	return s, nil
+}
+
+// appendBytes appends the length and content of b to key, so that concatenated values can't be ambiguous
+func appendBytes(key []byte, b []byte) []byte {
+	key = binary.LittleEndian.AppendUint32(key, uint32(len(b)))
+	return append(key, b...)
+}
+
+// collect records data as part of the running snapshot; data emitted by the operator itself is passed on
+func (s *snapshot) collect(ds datasource.DataSource, data datasource.Data) error {
+	if s.emitting.Load() {
+		return nil
+	}
+
+	var content []byte
+	for _, p := range data.Raw().Payload {
+		content = appendBytes(content, p)
+	}
+	key := content
+	if len(s.keys) > 0 {
+		key = nil
+		for _, f := range s.keys {
+			key = appendBytes(key, f.Get(data))
+		}
+	}
+
+	s.mu.Lock()
+	s.current[string(key)] = entry{data: data, fingerprint: maphash.Bytes(s.seed, content)}
+	s.mu.Unlock()
+	return datasource.ErrDiscard
+}
+
+// diff emits the differences between the snapshot collected since the last call and the previous one
+func (s *snapshot) diff() error {
+	s.mu.Lock()
+	current := s.current
+	s.current = make(map[string]entry, len(current))
+	s.mu.Unlock()
+
+	previous := s.previous
+	s.previous = current
+
+	s.emitting.Store(true)
+	defer s.emitting.Store(false)
+
+	for _, key := range sortedKeys(current) {
+		e := current[key]
+		old, ok := previous[key]
+		switch {
+		case !ok:
+			if err := s.emit(e.data, ActionAdded); err != nil {
+				return err
+			}
+		case old.fingerprint != e.fingerprint:
+			if err := s.emit(e.data, ActionChanged); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range sortedKeys(previous) {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		if err := s.emit(previous[key].data, ActionRemoved); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// update takes a new snapshot and emits the differences to the previous one
+func (s *snapshot) update() error {
+	if err := s.take(); err != nil {
+		// Don't report the entries missing from an incomplete snapshot as removed
+		s.mu.Lock()
+		clear(s.current)
+		s.mu.Unlock()
+		return fmt.Errorf("taking snapshot: %w", err)
+	}
+	if err := s.diff(); err != nil {
+		return fmt.Errorf("emitting differences: %w", err)
+	}
+	return nil
+}
+
+func sortedKeys(entries map[string]entry) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (s *snapshot) emit(data datasource.Data, action string) error {
+	if err := s.action.Set(data, []byte(action)); err != nil {
+		return err
+	}
+	return s.ds.EmitAndRelease(data)
+}
+
+type snapshotDiffOperatorInstance struct {
+	interval  time.Duration
+	snapshots []*snapshot
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func (o *snapshotDiffOperatorInstance) Name() string {
+	return OperatorName
+}
+
+func (o *snapshotDiffOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error {
+	for _, s := range o.snapshots {
+		s.ds.Subscribe(s.collect, Priority)
+	}
+	return nil
+}
+
+func (o *snapshotDiffOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	if len(o.snapshots) == 0 {
+		return nil
+	}
+
+	// The gadget took the first snapshot when it was started; all its entries are reported as added
+	for _, s := range o.snapshots {
+		if err := s.diff(); err != nil {
+			return fmt.Errorf("emitting snapshot of %q: %w", s.ds.Name(), err)
+		}
+	}
+
+	o.done = make(chan struct{})
+	o.stopped = make(chan struct{})
+	go func() {
+		defer close(o.stopped)
+		ticker := time.NewTicker(o.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-o.done:
+				return
+			case <-gadgetCtx.Context().Done():
+				return
+			case <-ticker.C:
+				for _, s := range o.snapshots {
+					if err := s.update(); err != nil {
+						gadgetCtx.Logger().Warnf("updating snapshot of %q: %v", s.ds.Name(), err)
+					}
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (o *snapshotDiffOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	if o.done == nil {
+		return nil
+	}
+	close(o.done)
+	<-o.stopped
+	o.done = nil
+	return nil
+}
+
+func init() {
+	operators.RegisterDataOperator(&snapshotDiffOperator{})
+}
@@ -0,0 +1,237 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshotdiff
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+)
+
+type process struct {
+	pid   uint32
+	state string
+}
+
+// testSnapshotter emits its processes to the data source each time a snapshot is taken
+type testSnapshotter struct {
+	ds        datasource.DataSource
+	pid       datasource.FieldAccessor
+	state     datasource.FieldAccessor
+	processes []process
+	err       error
+}
+
+func newTestSnapshotter(t *testing.T, gadgetCtx *gadgetcontext.GadgetContext) *testSnapshotter {
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "processes")
+	require.NoError(t, err)
+	pid, err := ds.AddField("pid", datasource.WithKind(api.Kind_Uint32))
+	require.NoError(t, err)
+	state, err := ds.AddField("state", datasource.WithKind(api.Kind_String))
+	require.NoError(t, err)
+	s := &testSnapshotter{ds: ds, pid: pid, state: state}
+	gadgetCtx.SetVar(operators.SnapshottersVar, map[string]operators.SnapshotFunc{"processes": s.take})
+	return s
+}
+
+func (s *testSnapshotter) take() error {
+	for _, p := range s.processes {
+		data := s.ds.NewData()
+		if err := s.pid.Set(data, make([]byte, 4)); err != nil {
+			return err
+		}
+		s.pid.PutUint32(data, p.pid)
+		if err := s.state.Set(data, []byte(p.state)); err != nil {
+			return err
+		}
+		if err := s.ds.EmitAndRelease(data); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+type change struct {
+	action string
+	process
+}
+
+func TestSnapshotDiffOperator(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	src := newTestSnapshotter(t, gadgetCtx)
+
+	inst, err := (&snapshotDiffOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{
+		ParamInterval: "1h",
+		ParamKeys:     "pid",
+	})
+	require.NoError(t, err)
+	snapshots := inst.(*snapshotDiffOperatorInstance).snapshots
+	require.Len(t, snapshots, 1)
+	s := snapshots[0]
+
+	action := src.ds.GetField(ActionField)
+	require.NotNil(t, action)
+
+	var changes []change
+	src.ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		changes = append(changes, change{
+			action:  action.String(data),
+			process: process{pid: src.pid.Uint32(data), state: src.state.String(data)},
+		})
+		return nil
+	}, Priority+1)
+
+	require.NoError(t, inst.(*snapshotDiffOperatorInstance).PreStart(gadgetCtx))
+
+	// All entries of the first snapshot are added
+	src.processes = []process{{1, "S"}, {2, "R"}, {3, "S"}}
+	require.NoError(t, s.take())
+	require.Empty(t, changes)
+	require.NoError(t, s.diff())
+	require.ElementsMatch(t, []change{
+		{ActionAdded, process{1, "S"}},
+		{ActionAdded, process{2, "R"}},
+		{ActionAdded, process{3, "S"}},
+	}, changes)
+
+	// Nothing is emitted if nothing changed
+	changes = nil
+	require.NoError(t, s.take())
+	require.NoError(t, s.diff())
+	require.Empty(t, changes)
+
+	changes = nil
+	src.processes = []process{{1, "S"}, {2, "S"}, {4, "R"}}
+	require.NoError(t, s.take())
+	require.NoError(t, s.diff())
+	require.ElementsMatch(t, []change{
+		{ActionChanged, process{2, "S"}},
+		{ActionAdded, process{4, "R"}},
+		{ActionRemoved, process{3, "S"}},
+	}, changes)
+}
+
+func TestSnapshotDiffOperatorWithoutKeys(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	src := newTestSnapshotter(t, gadgetCtx)
+
+	inst, err := (&snapshotDiffOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{
+		ParamInterval: "1h",
+	})
+	require.NoError(t, err)
+	s := inst.(*snapshotDiffOperatorInstance).snapshots[0]
+	action := src.ds.GetField(ActionField)
+
+	var changes []change
+	src.ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		changes = append(changes, change{
+			action:  action.String(data),
+			process: process{pid: src.pid.Uint32(data), state: src.state.String(data)},
+		})
+		return nil
+	}, Priority+1)
+	require.NoError(t, inst.(*snapshotDiffOperatorInstance).PreStart(gadgetCtx))
+
+	src.processes = []process{{1, "S"}}
+	require.NoError(t, s.take())
+	require.NoError(t, s.diff())
+
+	// Without keys, a changed entry is a different one
+	changes = nil
+	src.processes = []process{{1, "R"}}
+	require.NoError(t, s.take())
+	require.NoError(t, s.diff())
+	require.ElementsMatch(t, []change{
+		{ActionAdded, process{1, "R"}},
+		{ActionRemoved, process{1, "S"}},
+	}, changes)
+}
+
+func TestSnapshotDiffOperatorParams(t *testing.T) {
+	for name, tc := range map[string]struct {
+		values       api.ParamValues
+		snapshotters bool
+		enabled      bool
+		err          bool
+	}{
+		"disabled":                {values: api.ParamValues{}, snapshotters: true},
+		"disabled without gadget": {values: api.ParamValues{}},
+		"enabled":                 {values: api.ParamValues{ParamInterval: "1s"}, snapshotters: true, enabled: true},
+		"keys":                    {values: api.ParamValues{ParamInterval: "1s", ParamKeys: "pid, state"}, snapshotters: true, enabled: true},
+		"unknown key":             {values: api.ParamValues{ParamInterval: "1s", ParamKeys: "foo"}, snapshotters: true, err: true},
+		"negative interval":       {values: api.ParamValues{ParamInterval: "-1s"}, snapshotters: true, err: true},
+		"no snapshotters":         {values: api.ParamValues{ParamInterval: "1s"}, err: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			gadgetCtx := gadgetcontext.New(context.Background(), "test")
+			if tc.snapshotters {
+				newTestSnapshotter(t, gadgetCtx)
+			}
+
+			inst, err := (&snapshotDiffOperator{}).InstantiateDataOperator(gadgetCtx, tc.values)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.enabled, len(inst.(*snapshotDiffOperatorInstance).snapshots) > 0)
+		})
+	}
+}
+
+func TestSnapshotDiffOperatorFailedSnapshot(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	src := newTestSnapshotter(t, gadgetCtx)
+
+	inst, err := (&snapshotDiffOperator{}).InstantiateDataOperator(gadgetCtx, api.ParamValues{
+		ParamInterval: "1h",
+		ParamKeys:     "pid",
+	})
+	require.NoError(t, err)
+	s := inst.(*snapshotDiffOperatorInstance).snapshots[0]
+	action := src.ds.GetField(ActionField)
+
+	var changes []change
+	src.ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		changes = append(changes, change{
+			action:  action.String(data),
+			process: process{pid: src.pid.Uint32(data), state: src.state.String(data)},
+		})
+		return nil
+	}, Priority+1)
+	require.NoError(t, inst.(*snapshotDiffOperatorInstance).PreStart(gadgetCtx))
+
+	src.processes = []process{{1, "S"}, {2, "S"}}
+	require.NoError(t, s.update())
+
+	// Entries missing from a failed snapshot aren't reported as removed
+	changes = nil
+	src.processes = []process{{1, "S"}}
+	src.err = errors.New("failed")
+	require.Error(t, s.update())
+	require.Empty(t, changes)
+
+	src.processes = []process{{1, "S"}, {2, "S"}}
+	src.err = nil
+	require.NoError(t, s.update())
+	require.Empty(t, changes)
+}
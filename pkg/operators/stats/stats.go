@@ -0,0 +1,346 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package stats provides an operator that periodically emits run-time statistics of a gadget on a data source of its
+// own: how much data each data source emitted, discarded, shed or lost, how long its subscribers took to handle it and
+// how full the eBPF maps of the gadget are. They help to find out where and why events get lost.
+package stats
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+const (
+	OperatorName = "stats"
+
+	ParamInterval = "stats-interval"
+
+	// DataSourceName is the name of the data source the statistics are emitted on
+	DataSourceName = "stats"
+
+	// Priority makes sure the data source is registered before sinks subscribe to the data sources
+	Priority = -500
+
+	KindDataSource = "datasource"
+	KindSubscriber = "subscriber"
+	KindLost       = "lost"
+	KindMap        = "map"
+)
+
+type statsOperator struct{}
+
+func (o *statsOperator) Name() string {
+	return OperatorName
+}
+
+func (o *statsOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *statsOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *statsOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:   ParamInterval,
+			Title: "Statistics interval",
+			Description: "Emit statistics of the data sources, their subscribers and the eBPF maps of the gadget on the " +
+				"data source " + DataSourceName + " at this interval; 0 disables them",
+			DefaultValue: "0",
+			TypeHint:     api.TypeDuration,
+		},
+	}
+}
+
+func (o *statsOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &statsOperatorInstance{}
+	inst.interval = params.Get(ParamInterval).AsDuration()
+	if inst.interval < 0 {
+		return nil, fmt.Errorf("%s must not be negative", ParamInterval)
+	}
+	if inst.interval == 0 {
+		return inst, nil
+	}
+
+	inst.ds, err = gadgetCtx.RegisterDataSource(datasource.TypeEvent, DataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("adding data source %q: %w", DataSourceName, err)
+	}
+	if err := inst.addFields(); err != nil {
+		return nil, fmt.Errorf("adding fields to data source %q: %w", DataSourceName, err)
+	}
+	return inst, nil
+}
+
+func (o *statsOperator) Priority() int {
+	return Priority
+}
+
+type statsOperatorInstance struct {
+	interval time.Duration
+
+	// ds is nil if the operator isn't enabled
+	ds datasource.DataSource
+
+	kind       datasource.FieldAccessor
+	name       datasource.FieldAccessor
+	subscriber datasource.FieldAccessor
+	reason     datasource.FieldAccessor
+	emitted    datasource.FieldAccessor
+	discarded  datasource.FieldAccessor
+	shed       datasource.FieldAccessor
+	lost       datasource.FieldAccessor
+	avgLatency datasource.FieldAccessor
+	maxLatency datasource.FieldAccessor
+	entries    datasource.FieldAccessor
+	maxEntries datasource.FieldAccessor
+
+	// last holds the counters of the subscribers at the end of the last interval, keyed by data source
+	last map[string][]datasource.SubscriberStats
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (o *statsOperatorInstance) addFields() error {
+	var err error
+	field := func(name string, kind api.Kind, description string) (datasource.FieldAccessor, error) {
+		return o.ds.AddField(name, datasource.WithKind(kind),
+			datasource.WithAnnotations(map[string]string{"description": description}))
+	}
+	for _, f := range []struct {
+		acc         *datasource.FieldAccessor
+		name        string
+		kind        api.Kind
+		description string
+	}{
+		{&o.kind, "kind", api.Kind_String, fmt.Sprintf("What the statistics are about: %q, %q, %q or %q", KindDataSource, KindSubscriber, KindLost, KindMap)},
+		{&o.name, "name", api.Kind_String, "Name of the data source or eBPF map"},
+		{&o.subscriber, "subscriber", api.Kind_String, "Priority of the subscriber of the data source"},
+		{&o.reason, "reason", api.Kind_String, "Why the events were lost, e.g. " + datasource.LostReasonBufferFull + " or " + datasource.LostReasonRateLimit},
+		{&o.emitted, "emitted", api.Kind_Uint64, "Number of events handed to the subscribers of the data source or to the subscriber since the gadget started"},
+		{&o.discarded, "discarded", api.Kind_Uint64, "Number of events discarded by subscribers, e.g. by filters, since the gadget started"},
+		{&o.shed, "shed", api.Kind_Uint64, "Number of events dropped because the data source was shed since the gadget started"},
+		{&o.lost, "lost", api.Kind_Uint64, "Number of events lost before reaching the data source, e.g. because a buffer was full, since the gadget started"},
+		{&o.avgLatency, "avg_latency_ns", api.Kind_Uint64, "Average time the subscriber took to handle an event during the last interval"},
+		{&o.maxLatency, "max_latency_ns", api.Kind_Uint64, "Longest time the subscriber took to handle an event since the gadget started"},
+		{&o.entries, "entries", api.Kind_Uint64, "Number of entries of the eBPF map; arrays always have all of their entries"},
+		{&o.maxEntries, "max_entries", api.Kind_Uint64, "Maximum number of entries of the eBPF map"},
+	} {
+		if *f.acc, err = field(f.name, f.kind, f.description); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *statsOperatorInstance) Name() string {
+	return OperatorName
+}
+
+func (o *statsOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	if o.ds == nil {
+		return nil
+	}
+
+	for _, ds := range gadgetCtx.GetDataSources() {
+		if ds != o.ds {
+			ds.MeasureSubscribers()
+		}
+	}
+	o.last = make(map[string][]datasource.SubscriberStats)
+
+	o.done = make(chan struct{})
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		ticker := time.NewTicker(o.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-o.done:
+				return
+			case <-gadgetCtx.Context().Done():
+				return
+			case <-ticker.C:
+				if err := o.emit(gadgetCtx); err != nil {
+					gadgetCtx.Logger().Warnf("emitting stats: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// emit emits the statistics of all data sources, their subscribers and the reasons they lost events for, sorted by
+// the name of the data source, and of the eBPF maps of the gadget
+func (o *statsOperatorInstance) emit(gadgetCtx operators.GadgetContext) error {
+	dataSources := gadgetCtx.GetDataSources()
+	names := make([]string, 0, len(dataSources))
+	for name, ds := range dataSources {
+		if ds != o.ds {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		stats := dataSources[name].Stats()
+		err := o.emitEntry(KindDataSource, name, func(data datasource.Data) {
+			o.emitted.PutUint64(data, stats.Emitted)
+			o.discarded.PutUint64(data, stats.Discarded)
+			o.shed.PutUint64(data, stats.Shed)
+			o.lost.PutUint64(data, stats.Lost)
+		})
+		if err != nil {
+			return err
+		}
+
+		last := o.last[name]
+		for i, sub := range stats.Subscribers {
+			var prev datasource.SubscriberStats
+			if i < len(last) && last[i].Priority == sub.Priority {
+				prev = last[i]
+			}
+			err := o.emitEntry(KindSubscriber, name, func(data datasource.Data) {
+				o.subscriber.Set(data, []byte(strconv.Itoa(sub.Priority)))
+				o.emitted.PutUint64(data, sub.Calls)
+				o.avgLatency.PutUint64(data, averageLatency(sub, prev))
+				o.maxLatency.PutUint64(data, uint64(sub.MaxTime.Nanoseconds()))
+			})
+			if err != nil {
+				return err
+			}
+		}
+		o.last[name] = stats.Subscribers
+
+		reasons := make([]string, 0, len(stats.LostByReason))
+		for reason := range stats.LostByReason {
+			reasons = append(reasons, reason)
+		}
+		slices.Sort(reasons)
+		for _, reason := range reasons {
+			err := o.emitEntry(KindLost, name, func(data datasource.Data) {
+				o.reason.Set(data, []byte(reason))
+				o.lost.PutUint64(data, stats.LostByReason[reason])
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return o.emitMaps(gadgetCtx)
+}
+
+// averageLatency returns the average time the subscriber took per call since prev was taken
+func averageLatency(cur, prev datasource.SubscriberStats) uint64 {
+	calls := cur.Calls - prev.Calls
+	if calls == 0 {
+		return 0
+	}
+	return uint64((cur.Time - prev.Time).Nanoseconds()) / calls
+}
+
+// emitMaps emits the fill levels of the eBPF maps of the gadget, if it has any
+func (o *statsOperatorInstance) emitMaps(gadgetCtx operators.GadgetContext) error {
+	v, ok := gadgetCtx.GetVar(operators.MapsVar)
+	if !ok {
+		return nil
+	}
+	maps, ok := v.(map[string]operators.MapReader)
+	if !ok {
+		return nil
+	}
+	names := make([]string, 0, len(maps))
+	for name := range maps {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		m := maps[name]
+		var entries uint64
+		err := m.Iterate(func(key []byte, values [][]byte) error {
+			entries++
+			return nil
+		})
+		if err != nil {
+			// Maps can't be read before the gadget started or after it stopped
+			continue
+		}
+		err = o.emitEntry(KindMap, name, func(data datasource.Data) {
+			o.entries.PutUint64(data, entries)
+			o.maxEntries.PutUint64(data, uint64(m.MaxEntries()))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *statsOperatorInstance) emitEntry(kind, name string, fill func(datasource.Data)) error {
+	data := o.ds.NewData()
+	if err := o.kind.Set(data, []byte(kind)); err != nil {
+		o.ds.Release(data)
+		return err
+	}
+	if err := o.name.Set(data, []byte(name)); err != nil {
+		o.ds.Release(data)
+		return err
+	}
+	// The fields have no fixed size, so they need to be allocated before their values can be put
+	for _, acc := range []datasource.FieldAccessor{
+		o.emitted, o.discarded, o.shed, o.lost, o.avgLatency, o.maxLatency, o.entries, o.maxEntries,
+	} {
+		if err := acc.Set(data, make([]byte, 8)); err != nil {
+			o.ds.Release(data)
+			return err
+		}
+	}
+	fill(data)
+	return o.ds.EmitAndRelease(data)
+}
+
+func (o *statsOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	if o.done != nil {
+		close(o.done)
+		o.wg.Wait()
+		o.done = nil
+	}
+	return nil
+}
+
+func init() {
+	operators.RegisterDataOperator(&statsOperator{})
+}
@@ -0,0 +1,122 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+)
+
+type fakeMap struct {
+	operators.MapReader
+	entries    int
+	maxEntries uint32
+}
+
+func (m *fakeMap) MaxEntries() uint32 {
+	return m.maxEntries
+}
+
+func (m *fakeMap) Iterate(fn func(key []byte, values [][]byte) error) error {
+	for i := 0; i < m.entries; i++ {
+		if err := fn(nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestStatsOperator(t *testing.T) {
+	op := &statsOperator{}
+
+	// Disabled by default
+	inst, err := op.InstantiateDataOperator(gadgetcontext.New(context.Background(), "test"), api.ParamValues{})
+	require.NoError(t, err)
+	require.Nil(t, inst.(*statsOperatorInstance).ds)
+
+	_, err = op.InstantiateDataOperator(gadgetcontext.New(context.Background(), "test"), api.ParamValues{ParamInterval: "-1s"})
+	require.Error(t, err)
+
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	events, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+	value, err := events.AddField("value", datasource.WithKind(api.Kind_Uint32))
+	require.NoError(t, err)
+	gadgetCtx.SetVar(operators.MapsVar, map[string]operators.MapReader{
+		"counts": &fakeMap{entries: 3, maxEntries: 1024},
+	})
+
+	inst, err = op.InstantiateDataOperator(gadgetCtx, api.ParamValues{ParamInterval: "1h"})
+	require.NoError(t, err)
+	statsInst := inst.(*statsOperatorInstance)
+	require.NotNil(t, gadgetCtx.GetDataSources()[DataSourceName])
+
+	// Odd values are discarded by the first subscriber and never reach the second one
+	events.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		if value.Uint32(data)%2 == 1 {
+			return datasource.ErrDiscard
+		}
+		return nil
+	}, 0)
+	events.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	}, 10)
+
+	var rows []string
+	statsInst.ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		rows = append(rows, fmt.Sprintf("%s %s %s%s %d/%d/%d/%d %d/%d",
+			statsInst.kind.String(data), statsInst.name.String(data), statsInst.subscriber.String(data), statsInst.reason.String(data),
+			statsInst.emitted.Uint64(data), statsInst.discarded.Uint64(data), statsInst.shed.Uint64(data),
+			statsInst.lost.Uint64(data), statsInst.entries.Uint64(data), statsInst.maxEntries.Uint64(data)))
+		require.Less(t, statsInst.avgLatency.Uint64(data), uint64(time.Second))
+		if statsInst.subscriber.String(data) == "10" {
+			require.GreaterOrEqual(t, statsInst.maxLatency.Uint64(data), uint64(time.Millisecond))
+		}
+		return nil
+	}, 0)
+
+	require.NoError(t, statsInst.Start(gadgetCtx))
+	defer statsInst.Stop(gadgetCtx)
+
+	for i := uint32(0); i < 4; i++ {
+		data := events.NewData()
+		require.NoError(t, value.Set(data, make([]byte, 4)))
+		value.PutUint32(data, i)
+		require.NoError(t, events.EmitAndRelease(data))
+	}
+	events.ReportLostData(5)
+	events.ReportLostDataReason(2, datasource.LostReasonRateLimit)
+	events.ReportLostDataReason(1, datasource.LostReasonBufferFull)
+
+	require.NoError(t, statsInst.emit(gadgetCtx))
+	require.Equal(t, []string{
+		"datasource events  4/2/0/8 0/0",
+		"subscriber events 0 4/0/0/0 0/0",
+		"subscriber events 10 2/0/0/0 0/0",
+		"lost events buffer_full 0/0/0/1 0/0",
+		"lost events rate_limit 0/0/0/2 0/0",
+		"map counts  0/0/0/0 3/1024",
+	}, rows)
+}
@@ -0,0 +1,335 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package symbolizer provides an operator that adds the symbolized kernel and user stacks to events of gadgets
+// storing stacks with the helpers of include/gadget/stacks.h. Kernel symbols are taken from /proc/kallsyms, user
+// symbols from the symbol tables of the ELF files mapped by the process.
+package symbolizer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/kallsyms"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/ioc"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+)
+
+// Keep this aligned with include/gadget/stacks.h
+const (
+	// KernelStackTypeName contains the name of the type that gadgets should use to store the id of a kernel stack
+	KernelStackTypeName = "gadget_kernel_stack"
+
+	// UserStackTypeName contains the name of the type that gadgets should use to store the id of a user stack
+	UserStackTypeName = "gadget_user_stack"
+
+	// StackMapName is the name of the map holding the stacks
+	StackMapName = "gadget_stack_map"
+
+	maxStackDepth = 127
+)
+
+const (
+	OperatorName = "symbolizer"
+
+	ParamSymbolize = "symbolize-stacks"
+
+	// AnnotationPid marks the field containing the (host) pid of the process user stacks belong to. Fields named
+	// "pid" are used if no field is annotated.
+	AnnotationPid = "symbolizer.pid"
+
+	// Priority is chosen so that the stacks are available to the filter operator
+	Priority = ioc.Priority - 100
+
+	// frameSeparator separates the frames of a symbolized stack, innermost first
+	frameSeparator = "; "
+)
+
+type symbolizerOperator struct {
+	user *userSymbolizer
+}
+
+func (o *symbolizerOperator) Name() string {
+	return OperatorName
+}
+
+func (o *symbolizerOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *symbolizerOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *symbolizerOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:          ParamSymbolize,
+			Title:        "Symbolize stacks",
+			Description:  "Add the function names of kernel and user stacks to events containing their ids",
+			DefaultValue: "true",
+			TypeHint:     api.TypeBool,
+		},
+	}
+}
+
+type stackField struct {
+	user bool
+	id   datasource.FieldAccessor
+	out  datasource.FieldAccessor
+}
+
+type dataSourceFields struct {
+	pid    datasource.FieldAccessor
+	stacks []stackField
+}
+
+// pidField returns the field containing the pid of the process user stacks belong to, or nil if there's none
+func pidField(ds datasource.DataSource) (datasource.FieldAccessor, error) {
+	var named datasource.FieldAccessor
+	for _, f := range ds.Accessors(false) {
+		if v, ok := f.Annotations()[AnnotationPid]; ok && v == "true" {
+			if f.Type() != api.Kind_Uint32 {
+				return nil, fmt.Errorf("field %q annotated with %q must be of type uint32", f.Name(), AnnotationPid)
+			}
+			return f, nil
+		}
+		if named == nil && f.Name() == "pid" && f.Type() == api.Kind_Uint32 {
+			named = f
+		}
+	}
+	return named, nil
+}
+
+// addStackField replaces the stack id field in with a field holding the symbolized stack; the id is kept as hidden
+// field with a "_raw" suffix
+func addStackField(ds datasource.DataSource, in datasource.FieldAccessor, user bool) (stackField, error) {
+	if in.Type() != api.Kind_Int32 {
+		return stackField{}, fmt.Errorf("stack field %q must be of type int32", in.Name())
+	}
+	name := in.Name()
+	if err := in.Rename(name + "_raw"); err != nil {
+		return stackField{}, fmt.Errorf("renaming field %q: %w", name, err)
+	}
+	in.SetHidden(true, false)
+
+	description := "Kernel stack, innermost function first"
+	if user {
+		description = "User stack, innermost function first"
+	}
+	out, err := ds.AddField(name,
+		datasource.WithKind(api.Kind_String),
+		datasource.WithAnnotations(map[string]string{
+			"description":   description,
+			"columns.width": "64",
+		}),
+	)
+	if err != nil {
+		return stackField{}, fmt.Errorf("adding field %q: %w", name, err)
+	}
+	return stackField{user: user, id: in, out: out}, nil
+}
+
+// stackMap returns the reader of the map holding the stacks of the gadget
+func stackMap(gadgetCtx operators.GadgetContext) (operators.MapReader, error) {
+	v, ok := gadgetCtx.GetVar(operators.MapsVar)
+	if !ok {
+		return nil, fmt.Errorf("stack map %q not found", StackMapName)
+	}
+	readers, ok := v.(map[string]operators.MapReader)
+	if !ok {
+		return nil, fmt.Errorf("invalid maps: expected map[string]operators.MapReader, got %T", v)
+	}
+	stacks, ok := readers[StackMapName]
+	if !ok {
+		return nil, fmt.Errorf("stack map %q not found", StackMapName)
+	}
+	return stacks, nil
+}
+
+func (o *symbolizerOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if !params.Get(ParamSymbolize).AsBool() {
+		return nil, nil
+	}
+
+	inst := &symbolizerOperatorInstance{
+		user:   o.user,
+		fields: make(map[datasource.DataSource]*dataSourceFields),
+	}
+	hasKernelStacks := false
+	for _, ds := range gadgetCtx.GetDataSources() {
+		kernelStacks := ds.GetFieldsWithTag("type:" + KernelStackTypeName)
+		userStacks := ds.GetFieldsWithTag("type:" + UserStackTypeName)
+		if len(kernelStacks) == 0 && len(userStacks) == 0 {
+			continue
+		}
+
+		if inst.stacks == nil {
+			inst.stacks, err = stackMap(gadgetCtx)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		fields := &dataSourceFields{}
+		for _, f := range kernelStacks {
+			stack, err := addStackField(ds, f, false)
+			if err != nil {
+				return nil, err
+			}
+			fields.stacks = append(fields.stacks, stack)
+			hasKernelStacks = true
+		}
+		for _, f := range userStacks {
+			stack, err := addStackField(ds, f, true)
+			if err != nil {
+				return nil, err
+			}
+			fields.stacks = append(fields.stacks, stack)
+		}
+		if len(userStacks) > 0 {
+			fields.pid, err = pidField(ds)
+			if err != nil {
+				return nil, err
+			}
+		}
+		inst.fields[ds] = fields
+	}
+
+	if len(inst.fields) == 0 {
+		return nil, nil
+	}
+
+	if hasKernelStacks {
+		inst.kernel, err = kallsyms.NewKAllSyms()
+		if err != nil {
+			return nil, fmt.Errorf("reading kernel symbols: %w", err)
+		}
+	}
+	return inst, nil
+}
+
+func (o *symbolizerOperator) Priority() int {
+	return Priority
+}
+
+type symbolizerOperatorInstance struct {
+	stacks operators.MapReader
+	kernel *kallsyms.KAllSyms
+	user   *userSymbolizer
+	fields map[datasource.DataSource]*dataSourceFields
+}
+
+func (o *symbolizerOperatorInstance) Name() string {
+	return OperatorName
+}
+
+// instructionPointers returns the addresses of the stack with the given id, innermost first
+func (o *symbolizerOperatorInstance) instructionPointers(id int32) ([]uint64, error) {
+	values, err := o.stacks.Lookup(binary.NativeEndian.AppendUint32(nil, uint32(id)))
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+	value := values[0]
+	ips := make([]uint64, 0, maxStackDepth)
+	for off := 0; off+8 <= len(value); off += 8 {
+		ip := binary.NativeEndian.Uint64(value[off:])
+		if ip == 0 {
+			break
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+func (o *symbolizerOperatorInstance) symbolize(gadgetCtx operators.GadgetContext, fields *dataSourceFields, data datasource.Data) error {
+	var pid uint32
+	if fields.pid != nil {
+		pid = fields.pid.Uint32(data)
+	}
+	now := time.Now()
+
+	for _, stack := range fields.stacks {
+		id := stack.id.Int32(data)
+		if id < 0 {
+			// The gadget failed to get the stack
+			continue
+		}
+		ips, err := o.instructionPointers(id)
+		if err != nil {
+			gadgetCtx.Logger().Debugf("symbolizer: reading stack %d: %v", id, err)
+			continue
+		}
+
+		frames := make([]string, 0, len(ips))
+		for _, ip := range ips {
+			if !stack.user {
+				frames = append(frames, o.kernel.LookupByInstructionPointer(ip))
+				continue
+			}
+			var name string
+			if pid != 0 {
+				// The process might already be gone; in that case the address is used instead
+				name, err = o.user.symbolize(pid, ip, now)
+				if err != nil {
+					gadgetCtx.Logger().Debugf("symbolizer: resolving address %#x of pid %d: %v", ip, pid, err)
+				}
+			}
+			if name == "" {
+				name = fmt.Sprintf("%#x", ip)
+			}
+			frames = append(frames, name)
+		}
+		if err := stack.out.Set(data, []byte(strings.Join(frames, frameSeparator))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *symbolizerOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error {
+	for ds, fields := range o.fields {
+		ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+			return o.symbolize(gadgetCtx, fields, data)
+		}, Priority)
+	}
+	return nil
+}
+
+func (o *symbolizerOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+func (o *symbolizerOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+func init() {
+	// The symbols of processes and files are shared between all gadget instances
+	operators.RegisterDataOperator(&symbolizerOperator{
+		user: newUserSymbolizer(),
+	})
+}
@@ -0,0 +1,191 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolizer
+
+import (
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+)
+
+// libcFunction returns the address of a function of the libc mapped by the current process, or skips the test if
+// there's no libc, e.g. in static binaries
+func libcFunction(t *testing.T, name string) uint64 {
+	f, err := os.Open("/proc/self/maps")
+	require.NoError(t, err)
+	defer f.Close()
+	mappings, err := parseMappings(f)
+	require.NoError(t, err)
+
+	for _, m := range mappings {
+		if !strings.Contains(filepath.Base(m.path), "libc.so") {
+			continue
+		}
+		ef, err := elf.Open(m.path)
+		require.NoError(t, err)
+		defer ef.Close()
+		symbols, err := ef.DynamicSymbols()
+		require.NoError(t, err)
+		for _, sym := range symbols {
+			if sym.Name != name {
+				continue
+			}
+			for _, prog := range ef.Progs {
+				if prog.Type != elf.PT_LOAD || sym.Value < prog.Vaddr || sym.Value >= prog.Vaddr+prog.Filesz {
+					continue
+				}
+				offset := sym.Value - prog.Vaddr + prog.Off
+				if offset >= m.offset && offset < m.offset+m.end-m.start {
+					return m.start + offset - m.offset
+				}
+			}
+		}
+	}
+	t.Skipf("%s of libc not mapped", name)
+	return 0
+}
+
+func TestParseMappings(t *testing.T) {
+	mappings, err := parseMappings(strings.NewReader(`55d4b6a00000-55d4b6a28000 r--p 00000000 08:01 1835403    /usr/bin/bash
+55d4b6a28000-55d4b6ad9000 r-xp 00028000 08:01 1835403    /usr/bin/bash
+7f2d3c1a5000-7f2d3c33a000 r-xp 00028000 08:01 1835404    /usr/lib/my lib.so
+7ffd2b7f1000-7ffd2b7f3000 r-xp 00000000 00:00 0          [vdso]
+7ffd2b7f4000-7ffd2b7f5000 rw-p 00000000 00:00 0
+`))
+	require.NoError(t, err)
+	require.Equal(t, []mapping{
+		{start: 0x55d4b6a28000, end: 0x55d4b6ad9000, offset: 0x28000, path: "/usr/bin/bash"},
+		{start: 0x7f2d3c1a5000, end: 0x7f2d3c33a000, offset: 0x28000, path: "/usr/lib/my lib.so"},
+	}, mappings)
+}
+
+func TestUserSymbolizer(t *testing.T) {
+	s := newUserSymbolizer()
+	pid := uint32(os.Getpid())
+
+	name, err := s.symbolize(pid, libcFunction(t, "getpid")+1, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, "getpid", name)
+
+	// Addresses outside of mapped files aren't resolved
+	name, err = s.symbolize(pid, 1, time.Now())
+	require.NoError(t, err)
+	require.Empty(t, name)
+}
+
+// testStackMap is a stack map holding a single stack
+type testStackMap struct {
+	id  uint32
+	ips []uint64
+}
+
+func (m *testStackMap) Name() string       { return StackMapName }
+func (m *testStackMap) KeySize() uint32    { return 4 }
+func (m *testStackMap) ValueSize() uint32  { return maxStackDepth * 8 }
+func (m *testStackMap) MaxEntries() uint32 { return 1 }
+func (m *testStackMap) PerCPU() bool       { return false }
+
+func (m *testStackMap) Lookup(key []byte) ([][]byte, error) {
+	if binary.NativeEndian.Uint32(key) != m.id {
+		return nil, nil
+	}
+	value := make([]byte, m.ValueSize())
+	for i, ip := range m.ips {
+		binary.NativeEndian.PutUint64(value[i*8:], ip)
+	}
+	return [][]byte{value}, nil
+}
+
+func (m *testStackMap) Iterate(fn func(key []byte, values [][]byte) error) error {
+	values, _ := m.Lookup(binary.NativeEndian.AppendUint32(nil, m.id))
+	return fn(binary.NativeEndian.AppendUint32(nil, m.id), values)
+}
+
+func TestSymbolizerOperator(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	gadgetCtx.SetVar(operators.MapsVar, map[string]operators.MapReader{
+		StackMapName: &testStackMap{id: 3, ips: []uint64{libcFunction(t, "getpid") + 1, 1}},
+	})
+
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+	pid, err := ds.AddField("pid", datasource.WithKind(api.Kind_Uint32))
+	require.NoError(t, err)
+	stackID, err := ds.AddField("ustack", datasource.WithKind(api.Kind_Int32), datasource.WithTags("type:"+UserStackTypeName))
+	require.NoError(t, err)
+
+	op := &symbolizerOperator{user: newUserSymbolizer()}
+	inst, err := op.InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+	require.NoError(t, err)
+	require.NotNil(t, inst)
+	require.NoError(t, inst.(*symbolizerOperatorInstance).PreStart(gadgetCtx))
+
+	require.Equal(t, "ustack_raw", stackID.Name())
+	stack := ds.GetField("ustack")
+	require.NotNil(t, stack)
+
+	var stacks []string
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		stacks = append(stacks, stack.String(data))
+		return nil
+	}, Priority+1)
+
+	for _, id := range []int32{3, 4, -14} {
+		data := ds.NewData()
+		require.NoError(t, pid.Set(data, make([]byte, 4)))
+		pid.PutUint32(data, uint32(os.Getpid()))
+		require.NoError(t, stackID.Set(data, make([]byte, 4)))
+		stackID.PutInt32(data, id)
+		require.NoError(t, ds.EmitAndRelease(data))
+	}
+
+	require.Len(t, stacks, 3)
+	frames := strings.Split(stacks[0], frameSeparator)
+	require.Len(t, frames, 2)
+	require.Equal(t, "getpid", frames[0])
+	require.Equal(t, "0x1", frames[1])
+	// Unknown stacks and errors of the gadget leave the field empty
+	require.Empty(t, stacks[1])
+	require.Empty(t, stacks[2])
+}
+
+func TestSymbolizerOperatorWithoutStackMap(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+	_, err = ds.AddField("kstack", datasource.WithKind(api.Kind_Int32), datasource.WithTags("type:"+KernelStackTypeName))
+	require.NoError(t, err)
+
+	op := &symbolizerOperator{user: newUserSymbolizer()}
+	_, err = op.InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+	require.Error(t, err)
+
+	// Nothing to do if disabled
+	inst, err := op.InstantiateDataOperator(gadgetCtx, api.ParamValues{ParamSymbolize: "false"})
+	require.NoError(t, err)
+	require.Nil(t, inst)
+}
@@ -0,0 +1,292 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolizer
+
+import (
+	"bufio"
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+)
+
+const (
+	// processTTL is how long the mappings of a process are cached; processes can load further libraries at any time
+	processTTL = 5 * time.Second
+
+	maxProcesses = 1024
+	maxFiles     = 256
+)
+
+// mapping is an executable memory mapping of a file as found in /proc/<pid>/maps
+type mapping struct {
+	start, end, offset uint64
+	path               string
+}
+
+type processMappings struct {
+	mappings []mapping
+	loaded   time.Time
+}
+
+type elfSymbol struct {
+	addr, size uint64
+	name       string
+}
+
+// elfFile holds the function symbols of an ELF file, sorted by address, and its loadable segments to translate file
+// offsets to addresses
+type elfFile struct {
+	symbols []elfSymbol
+	loads   []elf.ProgHeader
+}
+
+type fileKey struct {
+	dev, ino uint64
+}
+
+// userSymbolizer resolves addresses of user stacks to the function symbols of the ELF files mapped by the process
+type userSymbolizer struct {
+	procFs string
+
+	mu        sync.Mutex
+	processes map[uint32]*processMappings
+	files     map[fileKey]*elfFile
+}
+
+func newUserSymbolizer() *userSymbolizer {
+	return &userSymbolizer{
+		procFs:    host.HostProcFs,
+		processes: make(map[uint32]*processMappings),
+		files:     make(map[fileKey]*elfFile),
+	}
+}
+
+// parseMappings reads the executable mappings of files from the content of /proc/<pid>/maps
+func parseMappings(r io.Reader) ([]mapping, error) {
+	var mappings []mapping
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// 7f2d3c1a5000-7f2d3c33a000 r-xp 00028000 08:01 1835403    /usr/lib/x86_64-linux-gnu/libc.so.6
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || !strings.Contains(fields[1], "x") || !strings.HasPrefix(fields[5], "/") {
+			continue
+		}
+		start, end, ok := strings.Cut(fields[0], "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid address range %q", fields[0])
+		}
+		var m mapping
+		var err error
+		if m.start, err = strconv.ParseUint(start, 16, 64); err != nil {
+			return nil, fmt.Errorf("parsing start address: %w", err)
+		}
+		if m.end, err = strconv.ParseUint(end, 16, 64); err != nil {
+			return nil, fmt.Errorf("parsing end address: %w", err)
+		}
+		if m.offset, err = strconv.ParseUint(fields[2], 16, 64); err != nil {
+			return nil, fmt.Errorf("parsing offset: %w", err)
+		}
+		// Paths can contain spaces
+		m.path = strings.Join(fields[5:], " ")
+		mappings = append(mappings, m)
+	}
+	return mappings, scanner.Err()
+}
+
+func (s *userSymbolizer) procPath(pid uint32, elems ...string) string {
+	return filepath.Join(append([]string{s.procFs, strconv.FormatUint(uint64(pid), 10)}, elems...)...)
+}
+
+func (s *userSymbolizer) mappings(pid uint32, now time.Time) ([]mapping, error) {
+	s.mu.Lock()
+	p, ok := s.processes[pid]
+	s.mu.Unlock()
+	if ok && now.Sub(p.loaded) < processTTL {
+		return p.mappings, nil
+	}
+
+	f, err := os.Open(s.procPath(pid, "maps"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mappings, err := parseMappings(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing mappings of pid %d: %w", pid, err)
+	}
+
+	s.mu.Lock()
+	if len(s.processes) >= maxProcesses {
+		clear(s.processes)
+	}
+	s.processes[pid] = &processMappings{mappings: mappings, loaded: now}
+	s.mu.Unlock()
+	return mappings, nil
+}
+
+func leadingUnderscores(name string) int {
+	return len(name) - len(strings.TrimLeft(name, "_"))
+}
+
+func loadELFFile(f *os.File) (*elfFile, error) {
+	ef, err := elf.NewFile(f)
+	if err != nil {
+		return nil, err
+	}
+	defer ef.Close()
+
+	file := &elfFile{}
+	for _, prog := range ef.Progs {
+		if prog.Type == elf.PT_LOAD {
+			file.loads = append(file.loads, prog.ProgHeader)
+		}
+	}
+
+	// Stripped binaries only have dynamic symbols
+	symbols, _ := ef.Symbols()
+	dynSymbols, _ := ef.DynamicSymbols()
+	for _, sym := range append(symbols, dynSymbols...) {
+		if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || sym.Value == 0 {
+			continue
+		}
+		file.symbols = append(file.symbols, elfSymbol{addr: sym.Value, size: sym.Size, name: sym.Name})
+	}
+	// Of aliases like getpid and __getpid, the name with the fewest leading underscores is kept
+	slices.SortFunc(file.symbols, func(a, b elfSymbol) int {
+		switch {
+		case a.addr < b.addr:
+			return -1
+		case a.addr > b.addr:
+			return 1
+		}
+		if c := leadingUnderscores(a.name) - leadingUnderscores(b.name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.name, b.name)
+	})
+	file.symbols = slices.CompactFunc(file.symbols, func(a, b elfSymbol) bool {
+		return a.addr == b.addr
+	})
+	return file, nil
+}
+
+// file returns the symbols of the file at path, as seen by the process with the given pid
+func (s *userSymbolizer) file(pid uint32, path string) (*elfFile, error) {
+	// The root of the process is its view of the file system, i.e. the one of its container
+	f, err := os.Open(s.procPath(pid, "root", path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, fmt.Errorf("unsupported stat for %q", path)
+	}
+	key := fileKey{dev: uint64(st.Dev), ino: st.Ino}
+
+	s.mu.Lock()
+	file, ok := s.files[key]
+	s.mu.Unlock()
+	if ok {
+		return file, nil
+	}
+
+	file, err = loadELFFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading ELF file %q: %w", path, err)
+	}
+
+	s.mu.Lock()
+	if len(s.files) >= maxFiles {
+		clear(s.files)
+	}
+	s.files[key] = file
+	s.mu.Unlock()
+	return file, nil
+}
+
+// lookup returns the name of the function containing the address addr of the file, or an empty string if not found
+func (f *elfFile) lookup(addr uint64) string {
+	i, found := slices.BinarySearchFunc(f.symbols, addr, func(sym elfSymbol, addr uint64) int {
+		switch {
+		case sym.addr < addr:
+			return -1
+		case sym.addr > addr:
+			return 1
+		}
+		return 0
+	})
+	if !found {
+		// i is the first symbol after addr
+		if i == 0 {
+			return ""
+		}
+		i--
+	}
+	sym := f.symbols[i]
+	if sym.size != 0 && addr >= sym.addr+sym.size {
+		return ""
+	}
+	return sym.name
+}
+
+// fileAddress translates the offset of a mapped file to the address used by its symbols
+func (f *elfFile) fileAddress(offset uint64) (uint64, bool) {
+	for _, load := range f.loads {
+		if offset >= load.Off && offset < load.Off+load.Filesz {
+			return offset - load.Off + load.Vaddr, true
+		}
+	}
+	return 0, false
+}
+
+// symbolize returns the name of the function containing the address ip of the process with the given pid, or an
+// empty string if it couldn't be found
+func (s *userSymbolizer) symbolize(pid uint32, ip uint64, now time.Time) (string, error) {
+	mappings, err := s.mappings(pid, now)
+	if err != nil {
+		return "", err
+	}
+	for _, m := range mappings {
+		if ip < m.start || ip >= m.end {
+			continue
+		}
+		file, err := s.file(pid, m.path)
+		if err != nil {
+			return "", err
+		}
+		addr, ok := file.fileAddress(ip - m.start + m.offset)
+		if !ok {
+			return "", nil
+		}
+		return file.lookup(addr), nil
+	}
+	return "", nil
+}
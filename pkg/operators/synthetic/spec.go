@@ -0,0 +1,341 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+const (
+	DistributionConstant = "constant"
+	DistributionChoice   = "choice"
+	DistributionUniform  = "uniform"
+	DistributionNormal   = "normal"
+	DistributionSequence = "sequence"
+)
+
+// Spec describes the events generated by a synthetic gadget; it's stored in its own layer of the gadget image
+type Spec struct {
+	DataSources map[string]*DataSourceSpec `yaml:"datasources"`
+}
+
+type DataSourceSpec struct {
+	// Rate is the number of events emitted per second
+	Rate   float64      `yaml:"rate"`
+	Fields []*FieldSpec `yaml:"fields"`
+}
+
+type FieldSpec struct {
+	Name        string            `yaml:"name"`
+	Type        string            `yaml:"type"`
+	Tags        []string          `yaml:"tags"`
+	Annotations map[string]string `yaml:"annotations"`
+
+	// Distribution selects how values are generated; it defaults to choice if Values are given and to uniform
+	// otherwise
+	Distribution string `yaml:"distribution"`
+
+	// Values are the values to choose from (choice) or the single value to use (constant); Weights optionally make
+	// some values more likely than others
+	Values  []string  `yaml:"values"`
+	Weights []float64 `yaml:"weights"`
+
+	// Min and Max limit the values of uniform and normal distributions; Min is the first value of a sequence
+	Min *float64 `yaml:"min"`
+	Max *float64 `yaml:"max"`
+
+	Mean   float64 `yaml:"mean"`
+	StdDev float64 `yaml:"stddev"`
+}
+
+// kinds maps the type names used in the spec to the kinds of the fields
+var kinds = map[string]api.Kind{
+	"string":  api.Kind_String,
+	"bool":    api.Kind_Bool,
+	"int8":    api.Kind_Int8,
+	"int16":   api.Kind_Int16,
+	"int32":   api.Kind_Int32,
+	"int64":   api.Kind_Int64,
+	"uint8":   api.Kind_Uint8,
+	"uint16":  api.Kind_Uint16,
+	"uint32":  api.Kind_Uint32,
+	"uint64":  api.Kind_Uint64,
+	"float32": api.Kind_Float32,
+	"float64": api.Kind_Float64,
+}
+
+func parseSpec(b []byte) (*Spec, error) {
+	spec := &Spec{}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(spec); err != nil {
+		return nil, fmt.Errorf("decoding spec: %w", err)
+	}
+	if len(spec.DataSources) == 0 {
+		return nil, errors.New("spec doesn't contain any datasources")
+	}
+	for name, ds := range spec.DataSources {
+		if ds == nil || len(ds.Fields) == 0 {
+			return nil, fmt.Errorf("datasource %q doesn't have any fields", name)
+		}
+		if ds.Rate < 0 {
+			return nil, fmt.Errorf("datasource %q: rate must not be negative", name)
+		}
+	}
+	return spec, nil
+}
+
+// generator generates the values of a field; values are either strings or numbers
+type generator struct {
+	kind api.Kind
+
+	// next returns the next value; str is only used by string fields
+	next func(rng *rand.Rand) (num float64, str string)
+}
+
+func newGenerator(f *FieldSpec) (*generator, error) {
+	if f.Name == "" {
+		return nil, errors.New("field without name")
+	}
+	kind, ok := kinds[f.Type]
+	if !ok {
+		return nil, fmt.Errorf("field %q: unsupported type %q", f.Name, f.Type)
+	}
+	g := &generator{kind: kind}
+
+	distribution := f.Distribution
+	if distribution == "" {
+		distribution = DistributionUniform
+		if len(f.Values) > 0 {
+			distribution = DistributionChoice
+		}
+	}
+
+	if kind == api.Kind_String && distribution != DistributionChoice && distribution != DistributionConstant {
+		return nil, fmt.Errorf("field %q: distribution %q is not supported for strings", f.Name, distribution)
+	}
+
+	switch distribution {
+	case DistributionConstant, DistributionChoice:
+		if len(f.Values) == 0 {
+			return nil, fmt.Errorf("field %q: no values given", f.Name)
+		}
+		if distribution == DistributionConstant && len(f.Values) != 1 {
+			return nil, fmt.Errorf("field %q: constant needs exactly one value", f.Name)
+		}
+		nums := make([]float64, len(f.Values))
+		if kind != api.Kind_String {
+			for i, v := range f.Values {
+				num, err := parseNumber(kind, v)
+				if err != nil {
+					return nil, fmt.Errorf("field %q: %w", f.Name, err)
+				}
+				nums[i] = num
+			}
+		}
+		pick, err := newPicker(f.Values, f.Weights)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", f.Name, err)
+		}
+		g.next = func(rng *rand.Rand) (float64, string) {
+			i := pick(rng)
+			return nums[i], f.Values[i]
+		}
+	case DistributionUniform:
+		lo, hi := bounds(kind, f.Min, f.Max)
+		if lo > hi {
+			return nil, fmt.Errorf("field %q: min must not be greater than max", f.Name)
+		}
+		g.next = func(rng *rand.Rand) (float64, string) {
+			return lo + rng.Float64()*(hi-lo), ""
+		}
+	case DistributionNormal:
+		if f.StdDev < 0 {
+			return nil, fmt.Errorf("field %q: stddev must not be negative", f.Name)
+		}
+		lo, hi := bounds(kind, f.Min, f.Max)
+		if lo > hi {
+			return nil, fmt.Errorf("field %q: min must not be greater than max", f.Name)
+		}
+		g.next = func(rng *rand.Rand) (float64, string) {
+			return min(max(f.Mean+rng.NormFloat64()*f.StdDev, lo), hi), ""
+		}
+	case DistributionSequence:
+		var counter float64
+		if f.Min != nil {
+			counter = *f.Min
+		}
+		g.next = func(rng *rand.Rand) (float64, string) {
+			v := counter
+			counter++
+			return v, ""
+		}
+	default:
+		return nil, fmt.Errorf("field %q: unsupported distribution %q", f.Name, distribution)
+	}
+	return g, nil
+}
+
+// newPicker returns a function picking an index of values, taking weights into account if given
+func newPicker(values []string, weights []float64) (func(rng *rand.Rand) int, error) {
+	if len(weights) == 0 {
+		return func(rng *rand.Rand) int {
+			return rng.IntN(len(values))
+		}, nil
+	}
+	if len(weights) != len(values) {
+		return nil, fmt.Errorf("got %d weights for %d values", len(weights), len(values))
+	}
+	cumulative := make([]float64, len(weights))
+	var total float64
+	for i, w := range weights {
+		if w < 0 {
+			return nil, errors.New("weights must not be negative")
+		}
+		total += w
+		cumulative[i] = total
+	}
+	if total == 0 {
+		return nil, errors.New("weights must not all be zero")
+	}
+	return func(rng *rand.Rand) int {
+		r := rng.Float64() * total
+		for i, c := range cumulative {
+			if r < c {
+				return i
+			}
+		}
+		return len(cumulative) - 1
+	}, nil
+}
+
+// bounds returns the range of values of a field; it defaults to [0, 100] and is limited to what its kind can hold
+func bounds(kind api.Kind, lo, hi *float64) (float64, float64) {
+	var l, h float64
+	if lo != nil {
+		l = *lo
+	}
+	if hi != nil {
+		h = *hi
+	} else {
+		h = l + 100
+	}
+	kindLo, kindHi := kindRange(kind)
+	return min(max(l, kindLo), kindHi), min(max(h, kindLo), kindHi)
+}
+
+func kindRange(kind api.Kind) (float64, float64) {
+	switch kind {
+	case api.Kind_Bool:
+		return 0, 1
+	case api.Kind_Int8:
+		return math.MinInt8, math.MaxInt8
+	case api.Kind_Int16:
+		return math.MinInt16, math.MaxInt16
+	case api.Kind_Int32:
+		return math.MinInt32, math.MaxInt32
+	case api.Kind_Int64:
+		return math.MinInt64, math.MaxInt64
+	case api.Kind_Uint8:
+		return 0, math.MaxUint8
+	case api.Kind_Uint16:
+		return 0, math.MaxUint16
+	case api.Kind_Uint32:
+		return 0, math.MaxUint32
+	case api.Kind_Uint64:
+		return 0, math.MaxUint64
+	case api.Kind_Float32:
+		return -math.MaxFloat32, math.MaxFloat32
+	}
+	return -math.MaxFloat64, math.MaxFloat64
+}
+
+func parseNumber(kind api.Kind, v string) (float64, error) {
+	if kind == api.Kind_Bool {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return 0, fmt.Errorf("parsing %q as bool: %w", v, err)
+		}
+		if b {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	num, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %q as number: %w", v, err)
+	}
+	lo, hi := kindRange(kind)
+	if num < lo || num > hi {
+		return 0, fmt.Errorf("value %q out of range", v)
+	}
+	return num, nil
+}
+
+// encode returns the representation of a generated value in the payload of a field
+func encode(kind api.Kind, bo binary.ByteOrder, num float64, str string) []byte {
+	switch kind {
+	case api.Kind_String:
+		return []byte(str)
+	case api.Kind_Bool:
+		if num >= 0.5 {
+			return []byte{1}
+		}
+		return []byte{0}
+	case api.Kind_Int8:
+		return []byte{uint8(int8(math.Round(num)))}
+	case api.Kind_Uint8:
+		return []byte{uint8(math.Round(num))}
+	}
+
+	var b []byte
+	switch kind {
+	case api.Kind_Int16:
+		b = make([]byte, 2)
+		bo.PutUint16(b, uint16(int16(math.Round(num))))
+	case api.Kind_Uint16:
+		b = make([]byte, 2)
+		bo.PutUint16(b, uint16(math.Round(num)))
+	case api.Kind_Int32:
+		b = make([]byte, 4)
+		bo.PutUint32(b, uint32(int32(math.Round(num))))
+	case api.Kind_Uint32:
+		b = make([]byte, 4)
+		bo.PutUint32(b, uint32(math.Round(num)))
+	case api.Kind_Float32:
+		b = make([]byte, 4)
+		bo.PutUint32(b, math.Float32bits(float32(num)))
+	case api.Kind_Int64:
+		b = make([]byte, 8)
+		bo.PutUint64(b, uint64(int64(math.Round(num))))
+	case api.Kind_Uint64:
+		b = make([]byte, 8)
+		bo.PutUint64(b, uint64(math.Round(num)))
+	case api.Kind_Float64:
+		b = make([]byte, 8)
+		bo.PutUint64(b, math.Float64bits(num))
+	}
+	return b
+}
@@ -0,0 +1,307 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package synthetic provides an image operator that emits generated events instead of running eBPF programs. Gadget
+// images containing a synthetic layer can be used to test sinks, dashboards and the performance of the pipeline, also
+// on systems where eBPF isn't available.
+package synthetic
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/viper"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/oci"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+)
+
+const (
+	OperatorName = "synthetic"
+
+	// SpecMediaType is the media type of the layer containing the Spec
+	SpecMediaType = "application/vnd.gadget.synthetic.v1+yaml"
+
+	ParamRate      = "rate"
+	ParamMaxEvents = "max-events"
+	ParamSeed      = "seed"
+
+	// maxTickRate limits the number of wake-ups per second; higher rates emit multiple events per tick
+	maxTickRate = 1000
+)
+
+type syntheticOperator struct{}
+
+func (o *syntheticOperator) Name() string {
+	return OperatorName
+}
+
+func (o *syntheticOperator) InstantiateImageOperator(
+	gadgetCtx operators.GadgetContext,
+	desc ocispec.Descriptor,
+	paramValues api.ParamValues,
+) (
+	operators.ImageOperatorInstance, error,
+) {
+	r, err := oci.GetContentFromDescriptor(gadgetCtx.Context(), desc)
+	if err != nil {
+		return nil, fmt.Errorf("getting synthetic spec: %w", err)
+	}
+	b, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading synthetic spec: %w", err)
+	}
+
+	spec, err := parseSpec(b)
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &syntheticInstance{
+		spec:        spec,
+		paramValues: paramValues,
+	}
+
+	var config *viper.Viper
+	if cfg, ok := gadgetCtx.GetVar("config"); ok {
+		config, _ = cfg.(*viper.Viper)
+	}
+
+	err = inst.register(gadgetCtx, config)
+	if err != nil {
+		return nil, fmt.Errorf("registering datasources: %w", err)
+	}
+	return inst, nil
+}
+
+type syntheticField struct {
+	acc datasource.FieldAccessor
+	gen *generator
+}
+
+type syntheticDataSource struct {
+	ds     datasource.DataSource
+	rate   float64
+	fields []syntheticField
+}
+
+type syntheticInstance struct {
+	spec        *Spec
+	paramValues api.ParamValues
+
+	dataSources []*syntheticDataSource
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (i *syntheticInstance) Name() string {
+	return OperatorName
+}
+
+func (i *syntheticInstance) register(gadgetCtx operators.GadgetContext, config *viper.Viper) error {
+	names := make([]string, 0, len(i.spec.DataSources))
+	for name := range i.spec.DataSources {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		dsSpec := i.spec.DataSources[name]
+		ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, name)
+		if err != nil {
+			return fmt.Errorf("adding datasource %q: %w", name, err)
+		}
+		if config != nil {
+			for k, v := range config.GetStringMapString("datasources." + name + ".annotations") {
+				ds.AddAnnotation(k, v)
+			}
+		}
+
+		sds := &syntheticDataSource{ds: ds, rate: dsSpec.Rate}
+		for _, f := range dsSpec.Fields {
+			gen, err := newGenerator(f)
+			if err != nil {
+				return fmt.Errorf("datasource %q: %w", name, err)
+			}
+			acc, err := ds.AddField(f.Name,
+				datasource.WithKind(gen.kind),
+				datasource.WithTags(f.Tags...),
+				datasource.WithAnnotations(f.Annotations),
+			)
+			if err != nil {
+				return fmt.Errorf("datasource %q: adding field %q: %w", name, f.Name, err)
+			}
+			sds.fields = append(sds.fields, syntheticField{acc: acc, gen: gen})
+		}
+		i.dataSources = append(i.dataSources, sds)
+	}
+	return nil
+}
+
+func (i *syntheticInstance) ExtraParams(gadgetCtx operators.GadgetContext) api.Params {
+	return api.Params{
+		{
+			Key:         ParamRate,
+			Title:       "Rate",
+			Description: "Number of events emitted per second and datasource; defaults to the rate of the spec",
+			TypeHint:    api.TypeFloat64,
+		},
+		{
+			Key:          ParamMaxEvents,
+			Title:        "Maximum events",
+			Description:  "Stop the gadget after emitting this number of events per datasource; 0 means unlimited",
+			DefaultValue: "0",
+			TypeHint:     api.TypeUint64,
+		},
+		{
+			Key:          ParamSeed,
+			Title:        "Seed",
+			Description:  "Seed of the random numbers to generate reproducible events; 0 uses a random seed",
+			DefaultValue: "0",
+			TypeHint:     api.TypeUint64,
+		},
+	}
+}
+
+func (i *syntheticInstance) Prepare(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+func (i *syntheticInstance) Start(gadgetCtx operators.GadgetContext) error {
+	params := apihelpers.ToParamDescs(i.ExtraParams(gadgetCtx)).ToParams()
+	err := params.CopyFromMap(i.paramValues, "")
+	if err != nil {
+		return err
+	}
+
+	maxEvents := params.Get(ParamMaxEvents).AsUint64()
+	seed := params.Get(ParamSeed).AsUint64()
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	var rate float64
+	if params.Get(ParamRate).AsString() != "" {
+		rate = params.Get(ParamRate).AsFloat64()
+		if rate < 0 {
+			return fmt.Errorf("%s must not be negative", ParamRate)
+		}
+	}
+
+	i.done = make(chan struct{})
+	finished := make(chan struct{}, len(i.dataSources))
+	for idx, sds := range i.dataSources {
+		dsRate := sds.rate
+		if rate > 0 {
+			dsRate = rate
+		}
+		if dsRate == 0 {
+			gadgetCtx.Logger().Warnf("datasource %q has no rate, not emitting events", sds.ds.Name())
+			continue
+		}
+
+		// Each datasource gets its own generator, as they're used concurrently
+		rng := rand.New(rand.NewPCG(seed, uint64(idx)))
+
+		i.wg.Add(1)
+		go func() {
+			defer i.wg.Done()
+			if err := sds.run(i.done, rng, dsRate, maxEvents); err != nil {
+				gadgetCtx.Logger().Errorf("emitting events for %q: %v", sds.ds.Name(), err)
+			}
+			finished <- struct{}{}
+		}()
+	}
+
+	if maxEvents > 0 {
+		// Stop the gadget once all datasources emitted their events
+		go func() {
+			for range i.dataSources {
+				select {
+				case <-finished:
+				case <-i.done:
+					return
+				}
+			}
+			gadgetCtx.Cancel()
+		}()
+	}
+	return nil
+}
+
+// run emits events at rate until done is closed or maxEvents (if not 0) events have been emitted
+func (s *syntheticDataSource) run(done <-chan struct{}, rng *rand.Rand, rate float64, maxEvents uint64) error {
+	tick := time.Duration(float64(time.Second) / rate)
+	tick = max(tick, time.Second/maxTickRate)
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	start := time.Now()
+	var emitted uint64
+	for {
+		select {
+		case <-done:
+			return nil
+		case now := <-ticker.C:
+			// Catch up with the rate, regardless of the tick interval
+			due := uint64(now.Sub(start).Seconds() * rate)
+			if maxEvents > 0 {
+				due = min(due, maxEvents)
+			}
+			for ; emitted < due; emitted++ {
+				if err := s.emit(rng); err != nil {
+					return err
+				}
+			}
+			if maxEvents > 0 && emitted >= maxEvents {
+				return nil
+			}
+		}
+	}
+}
+
+func (s *syntheticDataSource) emit(rng *rand.Rand) error {
+	data := s.ds.NewData()
+	for _, f := range s.fields {
+		num, str := f.gen.next(rng)
+		if err := f.acc.Set(data, encode(f.gen.kind, s.ds.ByteOrder(), num, str)); err != nil {
+			s.ds.Release(data)
+			return fmt.Errorf("setting field %q: %w", f.acc.Name(), err)
+		}
+	}
+	return s.ds.EmitAndRelease(data)
+}
+
+func (i *syntheticInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	if i.done == nil {
+		return nil
+	}
+	close(i.done)
+	i.wg.Wait()
+	i.done = nil
+	return nil
+}
+
+func init() {
+	operators.RegisterOperatorForMediaType(SpecMediaType, &syntheticOperator{})
+}
@@ -0,0 +1,209 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+)
+
+const testSpec = `
+datasources:
+  events:
+    rate: 100
+    fields:
+    - name: seq
+      type: uint64
+      distribution: sequence
+      min: 10
+    - name: comm
+      type: string
+      values: [bash, curl]
+      weights: [0, 1]
+    - name: pid
+      type: int32
+      min: -5
+      max: 5
+    - name: ok
+      type: bool
+      distribution: constant
+      values: ["true"]
+`
+
+func TestParseSpec(t *testing.T) {
+	spec, err := parseSpec([]byte(testSpec))
+	require.NoError(t, err)
+	require.Len(t, spec.DataSources, 1)
+	require.Len(t, spec.DataSources["events"].Fields, 4)
+
+	for name, s := range map[string]string{
+		"empty":         ``,
+		"unknown key":   "datasources:\n  events:\n    fields:\n    - name: a\n      type: string\n      foo: bar\n",
+		"no fields":     "datasources:\n  events:\n    rate: 1\n",
+		"negative rate": "datasources:\n  events:\n    rate: -1\n    fields:\n    - name: a\n      type: uint8\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseSpec([]byte(s))
+			require.Error(t, err)
+		})
+	}
+}
+
+func ptr(f float64) *float64 {
+	return &f
+}
+
+func TestNewGeneratorErrors(t *testing.T) {
+	for name, f := range map[string]*FieldSpec{
+		"no name":                 {Type: "uint8"},
+		"unknown type":            {Name: "a", Type: "bytes"},
+		"unknown distribution":    {Name: "a", Type: "uint8", Distribution: "zipf"},
+		"uniform string":          {Name: "a", Type: "string"},
+		"constant without values": {Name: "a", Type: "uint8", Distribution: DistributionConstant},
+		"constant with values":    {Name: "a", Type: "uint8", Distribution: DistributionConstant, Values: []string{"1", "2"}},
+		"invalid number":          {Name: "a", Type: "uint8", Values: []string{"x"}},
+		"out of range":            {Name: "a", Type: "uint8", Values: []string{"256"}},
+		"invalid bool":            {Name: "a", Type: "bool", Values: []string{"maybe"}},
+		"weights mismatch":        {Name: "a", Type: "string", Values: []string{"a", "b"}, Weights: []float64{1}},
+		"negative weight":         {Name: "a", Type: "string", Values: []string{"a"}, Weights: []float64{-1}},
+		"zero weights":            {Name: "a", Type: "string", Values: []string{"a"}, Weights: []float64{0}},
+		"min greater than max":    {Name: "a", Type: "uint8", Min: ptr(10), Max: ptr(5)},
+		"negative stddev":         {Name: "a", Type: "float64", Distribution: DistributionNormal, StdDev: -1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newGenerator(f)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	seq, err := newGenerator(&FieldSpec{Name: "a", Type: "uint64", Distribution: DistributionSequence, Min: ptr(3)})
+	require.NoError(t, err)
+	for i := range 3 {
+		num, _ := seq.next(rng)
+		require.Equal(t, float64(3+i), num)
+	}
+
+	choice, err := newGenerator(&FieldSpec{Name: "a", Type: "string", Values: []string{"a", "b", "c"}, Weights: []float64{1, 0, 1}})
+	require.NoError(t, err)
+	seen := map[string]int{}
+	for range 1000 {
+		_, str := choice.next(rng)
+		seen[str]++
+	}
+	require.Zero(t, seen["b"])
+	require.InDelta(t, 500, seen["a"], 100)
+
+	// Bounds are limited by the kind of the field
+	uniform, err := newGenerator(&FieldSpec{Name: "a", Type: "uint8", Min: ptr(-100), Max: ptr(1000)})
+	require.NoError(t, err)
+	normal, err := newGenerator(&FieldSpec{Name: "a", Type: "int8", Distribution: DistributionNormal, StdDev: 1000})
+	require.NoError(t, err)
+	for range 1000 {
+		num, _ := uniform.next(rng)
+		require.GreaterOrEqual(t, num, 0.0)
+		require.LessOrEqual(t, num, 255.0)
+
+		num, _ = normal.next(rng)
+		require.GreaterOrEqual(t, num, 0.0)
+		require.LessOrEqual(t, num, 100.0)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	bo := binary.LittleEndian
+	require.Equal(t, []byte("foo"), encode(api.Kind_String, bo, 0, "foo"))
+	require.Equal(t, []byte{1}, encode(api.Kind_Bool, bo, 1, ""))
+	require.Equal(t, []byte{0xff}, encode(api.Kind_Int8, bo, -1, ""))
+	require.Equal(t, []byte{0x02, 0x01}, encode(api.Kind_Uint16, bo, 258, ""))
+	require.Equal(t, []byte{0xfe, 0xff, 0xff, 0xff}, encode(api.Kind_Int32, bo, -2, ""))
+	require.Equal(t, []byte{0x2a, 0, 0, 0, 0, 0, 0, 0}, encode(api.Kind_Uint64, bo, 41.6, ""))
+	require.Len(t, encode(api.Kind_Float32, bo, 1.5, ""), 4)
+	require.Len(t, encode(api.Kind_Float64, bo, 1.5, ""), 8)
+}
+
+func TestSyntheticInstance(t *testing.T) {
+	spec, err := parseSpec([]byte(testSpec))
+	require.NoError(t, err)
+
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	inst := &syntheticInstance{
+		spec: spec,
+		paramValues: api.ParamValues{
+			ParamRate:      "1000",
+			ParamMaxEvents: "5",
+			ParamSeed:      "1",
+		},
+	}
+	require.NoError(t, inst.register(gadgetCtx, nil))
+
+	ds := gadgetCtx.GetDataSources()["events"]
+	require.NotNil(t, ds)
+	seq := ds.GetField("seq")
+	comm := ds.GetField("comm")
+	pid := ds.GetField("pid")
+	ok := ds.GetField("ok")
+
+	type event struct {
+		seq  uint64
+		comm string
+		pid  int32
+		ok   bool
+	}
+	events := make(chan event, 10)
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		events <- event{
+			seq:  seq.Uint64(data),
+			comm: comm.String(data),
+			pid:  pid.Int32(data),
+			ok:   ok.Bool(data),
+		}
+		return nil
+	}, 0)
+
+	require.NoError(t, inst.Start(gadgetCtx))
+
+	// The gadget is stopped once all events have been emitted
+	select {
+	case <-gadgetCtx.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("gadget wasn't stopped")
+	}
+	require.NoError(t, inst.Stop(gadgetCtx))
+	close(events)
+
+	var n uint64
+	for ev := range events {
+		require.Equal(t, 10+n, ev.seq)
+		require.Equal(t, "curl", ev.comm)
+		require.GreaterOrEqual(t, ev.pid, int32(-5))
+		require.LessOrEqual(t, ev.pid, int32(5))
+		require.True(t, ev.ok)
+		n++
+	}
+	require.Equal(t, uint64(5), n)
+}
@@ -0,0 +1,361 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uidgidresolver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	apihelpers "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api-helpers"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators/ioc"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
+)
+
+// Keep this aligned with include/gadget/types.h
+const (
+	// UidTypeName contains the name of the type that gadgets should use to store a user id
+	UidTypeName = "gadget_uid"
+
+	// GidTypeName contains the name of the type that gadgets should use to store a group id
+	GidTypeName = "gadget_gid"
+)
+
+const (
+	DataOperatorName = "uidgidresolver"
+
+	ParamResolve = "resolve-uid-gid"
+
+	// AnnotationType marks a field as containing a user ("uid") or group ("gid") id
+	AnnotationType = "uidgidresolver.type"
+
+	// AnnotationTarget sets the name of the field the user or group name is written to
+	AnnotationTarget = "uidgidresolver.target"
+
+	// AnnotationPid marks the field containing the (host) pid of the process the ids belong to; its mount namespace
+	// is used to look up the names. Fields named "pid" are used if no field is annotated.
+	AnnotationPid = "uidgidresolver.pid"
+
+	// DataOperatorPriority is chosen so that the names are available to the filter operator
+	DataOperatorPriority = ioc.Priority - 100
+
+	maxNamespaces = 1024
+	namesTTL      = 5 * time.Second
+)
+
+type idKind int
+
+const (
+	idKindNone idKind = iota
+	idKindUid
+	idKindGid
+)
+
+type uidGidDataOperator struct {
+	cache *nameCache
+}
+
+func (o *uidGidDataOperator) Name() string {
+	return DataOperatorName
+}
+
+func (o *uidGidDataOperator) Init(params *params.Params) error {
+	return nil
+}
+
+func (o *uidGidDataOperator) GlobalParams() api.Params {
+	return nil
+}
+
+func (o *uidGidDataOperator) InstanceParams() api.Params {
+	return api.Params{
+		{
+			Key:          ParamResolve,
+			Title:        "Resolve user and group names",
+			Description:  "Add the names of users and groups to events containing their ids. The names are looked up in the container the process is running in",
+			DefaultValue: "true",
+			TypeHint:     api.TypeBool,
+		},
+	}
+}
+
+// fieldIdKind returns whether f contains a user or group id, based on its annotations, type or name. Names are only
+// considered for fields that aren't nested.
+func fieldIdKind(f datasource.FieldAccessor, uidTyped, gidTyped map[string]struct{}, root bool) idKind {
+	switch f.Annotations()[AnnotationType] {
+	case "uid":
+		return idKindUid
+	case "gid":
+		return idKindGid
+	}
+	if _, ok := uidTyped[f.Name()]; ok {
+		return idKindUid
+	}
+	if _, ok := gidTyped[f.Name()]; ok {
+		return idKindGid
+	}
+	if !root || f.Type() != api.Kind_Uint32 {
+		return idKindNone
+	}
+	switch {
+	case f.Name() == "uid" || strings.HasSuffix(f.Name(), "_uid"):
+		return idKindUid
+	case f.Name() == "gid" || strings.HasSuffix(f.Name(), "_gid"):
+		return idKindGid
+	}
+	return idKindNone
+}
+
+// targetName returns the name of the field containing the name for the id field f, e.g. "uid" -> "user" and
+// "owner_gid" -> "owner_group"
+func targetName(f datasource.FieldAccessor, kind idKind) string {
+	if target := f.Annotations()[AnnotationTarget]; target != "" {
+		return target
+	}
+	id, name := "uid", "user"
+	if kind == idKindGid {
+		id, name = "gid", "group"
+	}
+	switch {
+	case f.Name() == id:
+		return name
+	case strings.HasSuffix(f.Name(), "_"+id):
+		return strings.TrimSuffix(f.Name(), id) + name
+	}
+	return f.Name() + "_" + name
+}
+
+func fieldNames(fields []datasource.FieldAccessor) map[string]struct{} {
+	names := make(map[string]struct{}, len(fields))
+	for _, f := range fields {
+		names[f.Name()] = struct{}{}
+	}
+	return names
+}
+
+// pidField returns the field to take the mount namespace from, or nil if the names should be looked up on the host
+func pidField(ds datasource.DataSource) (datasource.FieldAccessor, error) {
+	var named datasource.FieldAccessor
+	for _, f := range ds.Accessors(false) {
+		if v, ok := f.Annotations()[AnnotationPid]; ok && v == "true" {
+			if f.Type() != api.Kind_Uint32 {
+				return nil, fmt.Errorf("field %q annotated with %q must be of type uint32", f.Name(), AnnotationPid)
+			}
+			return f, nil
+		}
+		if named == nil && f.Name() == "pid" && f.Type() == api.Kind_Uint32 {
+			named = f
+		}
+	}
+	return named, nil
+}
+
+type idField struct {
+	kind idKind
+	id   datasource.FieldAccessor
+	name datasource.FieldAccessor
+}
+
+type dataSourceFields struct {
+	pid   datasource.FieldAccessor
+	mntns datasource.FieldAccessor
+	ids   []idField
+}
+
+func (o *uidGidDataOperator) InstantiateDataOperator(gadgetCtx operators.GadgetContext, instanceParamValues api.ParamValues) (operators.DataOperatorInstance, error) {
+	params := apihelpers.ToParamDescs(o.InstanceParams()).ToParams()
+	err := params.CopyFromMap(instanceParamValues, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if !params.Get(ParamResolve).AsBool() {
+		return nil, nil
+	}
+
+	inst := &uidGidDataOperatorInstance{
+		cache:  o.cache,
+		fields: make(map[datasource.DataSource]*dataSourceFields),
+	}
+
+	for _, ds := range gadgetCtx.GetDataSources() {
+		uidTyped := fieldNames(ds.GetFieldsWithTag("type:" + UidTypeName))
+		gidTyped := fieldNames(ds.GetFieldsWithTag("type:" + GidTypeName))
+		rootFields := fieldNames(ds.Accessors(true))
+
+		var ids []idField
+		for _, f := range ds.Accessors(false) {
+			_, root := rootFields[f.Name()]
+			kind := fieldIdKind(f, uidTyped, gidTyped, root)
+			if kind == idKindNone {
+				continue
+			}
+			if f.Type() != api.Kind_Uint32 {
+				return nil, fmt.Errorf("user or group id field %q must be of type uint32", f.Name())
+			}
+
+			target := targetName(f, kind)
+			if ds.GetField(target) != nil {
+				// e.g. gadgets that already provide the name themselves
+				gadgetCtx.Logger().Debugf("uidgidresolver: not resolving %q, field %q already exists", f.Name(), target)
+				continue
+			}
+			description := "Name of the user"
+			if kind == idKindGid {
+				description = "Name of the group"
+			}
+			name, err := ds.AddField(target,
+				datasource.WithKind(api.Kind_String),
+				datasource.WithAnnotations(map[string]string{
+					"description":   description,
+					"columns.width": "16",
+				}),
+				datasource.WithFlags(datasource.FieldFlagHidden),
+			)
+			if err != nil {
+				return nil, fmt.Errorf("adding field %q: %w", target, err)
+			}
+			ids = append(ids, idField{kind: kind, id: f, name: name})
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		pid, err := pidField(ds)
+		if err != nil {
+			return nil, err
+		}
+		var mntns datasource.FieldAccessor
+		if mntnsFields := ds.GetFieldsWithTag("type:gadget_mntns_id"); len(mntnsFields) > 0 {
+			mntns = mntnsFields[0]
+		}
+		inst.fields[ds] = &dataSourceFields{pid: pid, mntns: mntns, ids: ids}
+	}
+
+	if len(inst.fields) == 0 {
+		return nil, nil
+	}
+
+	return inst, nil
+}
+
+func (o *uidGidDataOperator) Priority() int {
+	return DataOperatorPriority
+}
+
+type uidGidDataOperatorInstance struct {
+	cache  *nameCache
+	fields map[datasource.DataSource]*dataSourceFields
+}
+
+func (o *uidGidDataOperatorInstance) Name() string {
+	return DataOperatorName
+}
+
+// mountNamespace returns the id of the mount namespace of the process with the given pid
+func mountNamespace(pid uint32) (uint64, error) {
+	fi, err := os.Stat(filepath.Join(host.HostProcFs, strconv.FormatUint(uint64(pid), 10), "ns", "mnt"))
+	if err != nil {
+		return 0, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("unsupported stat for mount namespace of pid %d", pid)
+	}
+	return st.Ino, nil
+}
+
+// names returns the user and group names for the process referenced by data; names are looked up on the host if the
+// data source doesn't reference a process
+func (o *uidGidDataOperatorInstance) names(fields *dataSourceFields, data datasource.Data) (*names, error) {
+	var pid uint32
+	if fields.pid != nil {
+		pid = fields.pid.Uint32(data)
+	}
+	if pid == 0 {
+		// Mount namespace ids are inode numbers, so 0 is never used by a real namespace
+		return o.cache.Get(0, host.HostRoot)
+	}
+
+	var mntns uint64
+	if fields.mntns != nil {
+		mntns = fields.mntns.Uint64(data)
+	}
+	if mntns == 0 {
+		var err error
+		mntns, err = mountNamespace(pid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// The root of the process is its view of the file system, i.e. the one of its container
+	return o.cache.Get(mntns, filepath.Join(host.HostProcFs, strconv.FormatUint(uint64(pid), 10), "root"))
+}
+
+func (o *uidGidDataOperatorInstance) resolve(gadgetCtx operators.GadgetContext, fields *dataSourceFields, data datasource.Data) error {
+	n, err := o.names(fields, data)
+	if err != nil {
+		// The process might already be gone; in that case the fields are left empty
+		gadgetCtx.Logger().Debugf("uidgidresolver: looking up names: %v", err)
+		return nil
+	}
+	for _, f := range fields.ids {
+		entries := n.users
+		if f.kind == idKindGid {
+			entries = n.groups
+		}
+		name, ok := entries[f.id.Uint32(data)]
+		if !ok {
+			continue
+		}
+		if err := f.name.Set(data, []byte(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *uidGidDataOperatorInstance) PreStart(gadgetCtx operators.GadgetContext) error {
+	for ds, fields := range o.fields {
+		fields := fields
+		ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+			return o.resolve(gadgetCtx, fields, data)
+		}, DataOperatorPriority)
+	}
+	return nil
+}
+
+func (o *uidGidDataOperatorInstance) Start(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+func (o *uidGidDataOperatorInstance) Stop(gadgetCtx operators.GadgetContext) error {
+	return nil
+}
+
+func init() {
+	// The cache is shared between all gadget instances
+	operators.RegisterDataOperator(&uidGidDataOperator{
+		cache: newNameCache(maxNamespaces, namesTTL),
+	})
+}
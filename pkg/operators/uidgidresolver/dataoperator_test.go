@@ -0,0 +1,141 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uidgidresolver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	gadgetcontext "github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-context"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
+)
+
+func writeNames(t *testing.T, root, passwd, group string) {
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "etc"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "etc", "passwd"), []byte(passwd), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "etc", "group"), []byte(group), 0o644))
+}
+
+func TestNameCache(t *testing.T) {
+	root := t.TempDir()
+	writeNames(t, root, "# comment\nroot:x:0:0::/root:/bin/sh\nalice:x:1000:1000::/home/alice:/bin/sh\n", "wheel:x:10:\n")
+
+	now := time.Now()
+	c := newNameCache(2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	n, err := c.Get(1, root)
+	require.NoError(t, err)
+	require.Equal(t, map[uint32]string{0: "root", 1000: "alice"}, n.users)
+	require.Equal(t, map[uint32]string{10: "wheel"}, n.groups)
+
+	// Changes are only picked up once the entry expired
+	writeNames(t, root, "bob:x:1000:1000::/home/bob:/bin/sh\n", "")
+	n, err = c.Get(1, root)
+	require.NoError(t, err)
+	require.Equal(t, "alice", n.users[1000])
+
+	now = now.Add(time.Minute)
+	n, err = c.Get(1, root)
+	require.NoError(t, err)
+	require.Equal(t, "bob", n.users[1000])
+	require.Empty(t, n.groups)
+
+	// Images without the files have no names
+	n, err = c.Get(2, t.TempDir())
+	require.NoError(t, err)
+	require.Empty(t, n.users)
+}
+
+func TestUidGidDataOperator(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+	pid, err := ds.AddField("pid", datasource.WithKind(api.Kind_Uint32))
+	require.NoError(t, err)
+	uid, err := ds.AddField("uid", datasource.WithKind(api.Kind_Uint32))
+	require.NoError(t, err)
+	gid, err := ds.AddField("egid", datasource.WithKind(api.Kind_Uint32), datasource.WithTags("type:"+GidTypeName))
+	require.NoError(t, err)
+	owner, err := ds.AddField("owner", datasource.WithKind(api.Kind_Uint32), datasource.WithAnnotations(map[string]string{
+		AnnotationType:   "uid",
+		AnnotationTarget: "owner_name",
+	}))
+	require.NoError(t, err)
+	_, err = ds.AddField("count", datasource.WithKind(api.Kind_Uint32))
+	require.NoError(t, err)
+
+	op := &uidGidDataOperator{cache: newNameCache(maxNamespaces, namesTTL)}
+	inst, err := op.InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+	require.NoError(t, err)
+	require.NotNil(t, inst)
+	require.NoError(t, inst.(operators.PreStart).PreStart(gadgetCtx))
+
+	user := ds.GetField("user")
+	require.NotNil(t, user)
+	group := ds.GetField("egid_group")
+	require.NotNil(t, group)
+	ownerName := ds.GetField("owner_name")
+	require.NotNil(t, ownerName)
+	require.Nil(t, ds.GetField("count_user"))
+
+	var names []string
+	ds.Subscribe(func(ds datasource.DataSource, data datasource.Data) error {
+		names = []string{string(user.Get(data)), string(group.Get(data)), string(ownerName.Get(data))}
+		return nil
+	}, DataOperatorPriority+1)
+
+	// Names are looked up in the mount namespace of the test itself; root exists everywhere
+	data := ds.NewData()
+	for _, f := range []datasource.FieldAccessor{pid, uid, gid, owner} {
+		require.NoError(t, f.Set(data, make([]byte, 4)))
+	}
+	pid.PutUint32(data, uint32(os.Getpid()))
+	owner.PutUint32(data, 0xfffffff0)
+	require.NoError(t, ds.EmitAndRelease(data))
+	require.Equal(t, []string{"root", "root", ""}, names)
+}
+
+func TestUidGidDataOperatorDisabled(t *testing.T) {
+	gadgetCtx := gadgetcontext.New(context.Background(), "test")
+	ds, err := gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+	_, err = ds.AddField("uid", datasource.WithKind(api.Kind_Uint32))
+	require.NoError(t, err)
+
+	op := &uidGidDataOperator{cache: newNameCache(maxNamespaces, namesTTL)}
+	inst, err := op.InstantiateDataOperator(gadgetCtx, api.ParamValues{ParamResolve: "false"})
+	require.NoError(t, err)
+	require.Nil(t, inst)
+	require.Nil(t, ds.GetField("user"))
+
+	// A wrongly typed id field is an error
+	gadgetCtx = gadgetcontext.New(context.Background(), "test")
+	ds, err = gadgetCtx.RegisterDataSource(datasource.TypeEvent, "events")
+	require.NoError(t, err)
+	_, err = ds.AddField("id", datasource.WithKind(api.Kind_String), datasource.WithAnnotations(map[string]string{
+		AnnotationType: "uid",
+	}))
+	require.NoError(t, err)
+	_, err = op.InstantiateDataOperator(gadgetCtx, api.ParamValues{})
+	require.Error(t, err)
+}
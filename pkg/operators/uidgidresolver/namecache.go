@@ -0,0 +1,100 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uidgidresolver
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// names contains the user and group names of a single mount namespace
+type names struct {
+	users  map[uint32]string
+	groups map[uint32]string
+	loaded time.Time
+}
+
+// nameCache caches the user and group names by mount namespace; entries are reloaded once they're older than ttl
+type nameCache struct {
+	mu         sync.Mutex
+	entries    map[uint64]*names
+	maxEntries int
+	ttl        time.Duration
+	now        func() time.Time
+}
+
+func newNameCache(maxEntries int, ttl time.Duration) *nameCache {
+	return &nameCache{
+		entries:    make(map[uint64]*names),
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		now:        time.Now,
+	}
+}
+
+// loadNames reads /etc/passwd and /etc/group below root; missing files result in empty maps, as many container images
+// don't have them
+func loadNames(root string) (*names, error) {
+	n := &names{}
+	for _, f := range []struct {
+		name    string
+		entries *map[uint32]string
+	}{
+		{passwdFileName, &n.users},
+		{groupFileName, &n.groups},
+	} {
+		file, err := os.Open(filepath.Join(root, baseDirPath, f.name))
+		if os.IsNotExist(err) {
+			*f.entries = map[uint32]string{}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		*f.entries = parseEntries(file)
+		file.Close()
+	}
+	return n, nil
+}
+
+// Get returns the names of the mount namespace mntns, loading them from root if needed
+func (c *nameCache) Get(mntns uint64, root string) (*names, error) {
+	now := c.now()
+
+	c.mu.Lock()
+	n, ok := c.entries[mntns]
+	c.mu.Unlock()
+	if ok && now.Sub(n.loaded) < c.ttl {
+		return n, nil
+	}
+
+	n, err := loadNames(root)
+	if err != nil {
+		return nil, err
+	}
+	n.loaded = now
+
+	c.mu.Lock()
+	if len(c.entries) >= c.maxEntries {
+		// Simply start over instead of tracking usage; namespaces with activity will quickly be cached again
+		clear(c.entries)
+	}
+	c.entries[mntns] = n
+	c.mu.Unlock()
+
+	return n, nil
+}
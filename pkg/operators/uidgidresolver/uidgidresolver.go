@@ -16,6 +16,9 @@
 // up uid and gid resolving them to the corresponding username and groupname.
 // Only /etc/passwd and /etc/group is read on the host. Therefore the name for a
 // corresponding id could be wrong.
+//
+// For image-based gadgets, the package provides a data operator that looks up
+// the names in the container of the process referenced by the event instead.
 package uidgidresolver
 
 import (
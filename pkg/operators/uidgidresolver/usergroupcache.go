@@ -17,6 +17,7 @@ package uidgidresolver
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -212,24 +213,7 @@ func updateEntries(file *os.File, resourceCache cachedmap.CachedMap[uint32, stri
 	}
 
 	if file != nil {
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			line := strings.TrimLeft(scanner.Text(), " \t")
-			if len(line) == 0 || line[0] == '#' {
-				continue
-			}
-			split := strings.Split(line, ":")
-			// We are interested only in the first and third field
-			if len(split) < 3 {
-				continue
-			}
-			name := split[0]
-			id_u64, err := strconv.ParseUint(split[2], 10, 32)
-			if err != nil {
-				log.Warnf("UserGroupCache: convert id: %v", err)
-				continue
-			}
-			id := uint32(id_u64)
+		for id, name := range parseEntries(file) {
 			delete(oldEntries, id)
 			resourceCache.Add(id, name)
 		}
@@ -240,6 +224,31 @@ func updateEntries(file *os.File, resourceCache cachedmap.CachedMap[uint32, stri
 	}
 }
 
+// parseEntries reads the names and ids of a passwd or group file
+func parseEntries(r io.Reader) map[uint32]string {
+	entries := make(map[uint32]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimLeft(scanner.Text(), " \t")
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		split := strings.Split(line, ":")
+		// We are interested only in the first and third field
+		if len(split) < 3 {
+			continue
+		}
+		name := split[0]
+		id_u64, err := strconv.ParseUint(split[2], 10, 32)
+		if err != nil {
+			log.Warnf("UserGroupCache: convert id: %v", err)
+			continue
+		}
+		entries[uint32(id_u64)] = name
+	}
+	return entries
+}
+
 func (cache *userGroupCache) GetUsername(uid uint32) string {
 	name, _ := cache.userCache.Get(uid)
 	return name
@@ -30,6 +30,7 @@ type TextColumnsFormatter interface {
 	EventHandlerFunc() any
 	EventHandlerFuncArray(...func()) any
 	SetEventCallback(eventCallback func(string))
+	SetHeaderCallback(headerCallback func(string))
 	SetEnableExtraLines(bool)
 }
 
@@ -47,10 +48,27 @@ type outputHelper[T any] struct {
 	parser *parser[T]
 	*textcolumns.TextColumnsFormatter[T]
 	eventCallback    func(string)
+	headerCallback   func(string)
 	enableExtraLines bool
 }
 
 func (oh *outputHelper[T]) forwardEvent(ev *T) {
+	// Widths adapted to the values of the events need a new header
+	if oh.TextColumnsFormatter.ObserveEntry(ev) {
+		oh.printHeader()
+	}
+	oh.forwardEntry(ev)
+}
+
+func (oh *outputHelper[T]) printHeader() {
+	if oh.headerCallback != nil {
+		oh.headerCallback(oh.TextColumnsFormatter.FormatHeader())
+		return
+	}
+	oh.eventCallback(oh.TextColumnsFormatter.FormatHeader())
+}
+
+func (oh *outputHelper[T]) forwardEntry(ev *T) {
 	oh.eventCallback(oh.TextColumnsFormatter.FormatEntry(ev))
 	if !oh.enableExtraLines {
 		return
@@ -92,11 +110,20 @@ func (oh *outputHelper[T]) EventHandlerFuncArray(headerFuncs ...func()) any {
 		panic("set event callback before getting the EventHandlerFunc from TextColumnsFormatter")
 	}
 	return func(events []*T) {
+		// Adapt the widths to all events first, so that they are the same for the whole array
+		changed := false
+		for _, ev := range events {
+			changed = oh.TextColumnsFormatter.ObserveEntry(ev) || changed
+		}
 		for _, hf := range headerFuncs {
 			hf()
 		}
+		// Without header functions, the header is only printed once in the beginning
+		if changed && len(headerFuncs) == 0 {
+			oh.printHeader()
+		}
 		for _, ev := range events {
-			oh.forwardEvent(ev)
+			oh.forwardEntry(ev)
 		}
 	}
 }
@@ -105,6 +132,12 @@ func (oh *outputHelper[T]) SetEventCallback(eventCallback func(string)) {
 	oh.eventCallback = eventCallback
 }
 
+// SetHeaderCallback sets the function called with a new header when the widths of the columns changed; the header is
+// passed to the event callback if it isn't set
+func (oh *outputHelper[T]) SetHeaderCallback(headerCallback func(string)) {
+	oh.headerCallback = headerCallback
+}
+
 // TransformEvent takes a JSON encoded line and transforms it to columns view
 func (oh *outputHelper[T]) TransformEvent(line string) (string, error) {
 	ev := new(T)
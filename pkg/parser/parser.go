@@ -123,6 +123,21 @@ type Parser interface {
 	// ColFloatGetter returns a function that accepts an instance of type *T and returns the
 	// value of the column as an float64.
 	ColFloatGetter(colName string) (func(any) float64, error)
+
+	// ColumnGetters returns the attributes, kind and a getter for each column, in order; used to convert events to
+	// other representations like data sources
+	ColumnGetters() []ColumnGetter
+}
+
+// ColumnGetter describes a column and how to read its value from an event
+type ColumnGetter struct {
+	columns.Attributes
+
+	// Kind is the kind of the values returned by Get; reflect.String for columns with a custom extractor
+	Kind reflect.Kind
+
+	// Get accepts an instance of type *T and returns the value of the column
+	Get func(any) reflect.Value
 }
 
 type parser[T any] struct {
@@ -584,3 +599,22 @@ func (p *parser[T]) ColFloatGetter(colName string) (func(any) float64, error) {
 		return f(a.(*T))
 	}, nil
 }
+
+func (p *parser[T]) ColumnGetters() []ColumnGetter {
+	cols := p.columns.GetOrderedColumns(p.columnFilters...)
+	getters := make([]ColumnGetter, 0, len(cols))
+	for _, col := range cols {
+		kind := col.Kind()
+		if col.HasCustomExtractor() {
+			kind = reflect.String
+		}
+		getters = append(getters, ColumnGetter{
+			Attributes: col.Attributes,
+			Kind:       kind,
+			Get: func(a any) reflect.Value {
+				return col.Get(a.(*T))
+			},
+		})
+	}
+	return getters
+}
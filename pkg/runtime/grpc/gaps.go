@@ -0,0 +1,85 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcruntime
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/runtime"
+)
+
+// GapsDataSourceName is the name of the DataSource that is added to gadgets when reconnecting is enabled. It emits an
+// event for every reconnection, holding the node and the time range in which events of the gadget are missing.
+const GapsDataSourceName = "reconnect_gaps"
+
+// addGapsDataSource adds the DataSource for gaps to gi if reconnecting is enabled
+func (r *Runtime) addGapsDataSource(gi *api.GadgetInfo) error {
+	if !r.globalParams.Get(ParamReconnect).AsBool() {
+		return nil
+	}
+
+	ds := datasource.New(datasource.TypeEvent, GapsDataSourceName)
+	fields := []struct {
+		name        string
+		description string
+	}{
+		{"node", "Node the connection was lost to"},
+		{"start", "Time the connection was lost"},
+		{"end", "Time the connection was re-established"},
+	}
+	for _, f := range fields {
+		_, err := ds.AddField(f.name, datasource.WithKind(api.Kind_String), datasource.WithAnnotations(map[string]string{
+			"description": f.description,
+		}))
+		if err != nil {
+			return fmt.Errorf("adding field %q: %w", f.name, err)
+		}
+	}
+
+	gi.DataSources = append(gi.DataSources, &api.DataSource{
+		Name:   ds.Name(),
+		Fields: ds.Fields(),
+		Annotations: map[string]string{
+			datasource.AnnotationShared: "true",
+		},
+	})
+	return nil
+}
+
+// emitGap emits an event on the DataSource for gaps, telling that the events of node between start and end are
+// missing
+func emitGap(gadgetCtx runtime.GadgetContext, node string, start, end time.Time) error {
+	ds, ok := gadgetCtx.GetDataSources()[GapsDataSourceName]
+	if !ok {
+		return fmt.Errorf("data source %q not found", GapsDataSourceName)
+	}
+
+	data := ds.NewData()
+	for name, value := range map[string]string{
+		"node":  node,
+		"start": start.Format(time.RFC3339),
+		"end":   end.Format(time.RFC3339),
+	} {
+		err := ds.GetField(name).Set(data, []byte(value))
+		if err != nil {
+			ds.Release(data)
+			return fmt.Errorf("setting field %q: %w", name, err)
+		}
+	}
+	return ds.EmitAndRelease(data)
+}
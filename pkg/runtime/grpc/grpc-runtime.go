@@ -28,6 +28,7 @@ import (
 
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -40,6 +41,7 @@ import (
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/operators"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/runtime"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/spiffe"
 )
 
 type ConnectionMode int
@@ -61,6 +63,19 @@ const (
 	ParamConnectionMethod  = "connection-method"
 	ParamConnectionTimeout = "connection-timeout"
 
+	ParamFlowControlCredits = "flow-control-credits"
+	ParamFlowControlPolicy  = "flow-control-policy"
+
+	ParamReconnect            = "reconnect"
+	ParamReconnectMaxAttempts = "reconnect-max-attempts"
+	ParamReconnectMaxBackoff  = "reconnect-max-backoff"
+	reconnectInitialBackoff   = time.Second
+
+	ParamSPIFFESVID      = "spiffe-svid"
+	ParamSPIFFESVIDKey   = "spiffe-svid-key"
+	ParamSPIFFEBundle    = "spiffe-bundle"
+	ParamSPIFFEServerIDs = "spiffe-server-ids"
+
 	// ParamGadgetServiceTCPPort is only used in combination with KubernetesProxyConnectionMethodTCP
 	ParamGadgetServiceTCPPort = "tcp-port"
 
@@ -162,6 +177,52 @@ func (r *Runtime) GlobalParamDescs() params.ParamDescs {
 			DefaultValue: fmt.Sprintf("%d", ConnectTimeout),
 			TypeHint:     params.TypeUint16,
 		},
+		{
+			Key:          ParamFlowControlCredits,
+			Description:  "Number of events the remote may send before waiting for the client to request more; 0 disables flow control",
+			DefaultValue: "0",
+			TypeHint:     params.TypeUint32,
+		},
+		{
+			Key:            ParamFlowControlPolicy,
+			Description:    "Events to drop when the remote buffer is full because the client can't keep up",
+			DefaultValue:   "drop-newest",
+			PossibleValues: []string{"drop-newest", "drop-oldest"},
+		},
+		{
+			Key:          ParamReconnect,
+			Description:  "Run gadgets again when the connection to the remote is lost, e.g. because it restarted. Events emitted while disconnected are lost",
+			DefaultValue: "false",
+			TypeHint:     params.TypeBool,
+		},
+		{
+			Key:          ParamReconnectMaxAttempts,
+			Description:  "Maximum number of consecutive reconnection attempts; 0 means unlimited",
+			DefaultValue: "10",
+			TypeHint:     params.TypeUint32,
+		},
+		{
+			Key:          ParamReconnectMaxBackoff,
+			Description:  "Maximum time to wait between reconnection attempts. The time doubles after each attempt, starting at 1s",
+			DefaultValue: "30s",
+			TypeHint:     params.TypeDuration,
+		},
+		{
+			Key:         ParamSPIFFESVID,
+			Description: "Path of the X.509 SVID (PEM) to authenticate with to the remote using SPIFFE",
+		},
+		{
+			Key:         ParamSPIFFESVIDKey,
+			Description: "Path of the private key (PEM) of the X.509 SVID",
+		},
+		{
+			Key:         ParamSPIFFEBundle,
+			Description: "Path of the SPIFFE trust bundle (PEM) used to verify the remote",
+		},
+		{
+			Key:         ParamSPIFFEServerIDs,
+			Description: "Comma-separated list of SPIFFE IDs the remote may use. A trust domain (spiffe://example.org) allows all of its workloads. All remotes with a valid SVID are allowed if empty",
+		},
 	}
 	switch r.connectionMode {
 	case ConnectionModeDirect:
@@ -362,9 +423,37 @@ func (r *Runtime) runBuiltInGadgetOnTargets(
 	return results, results.Err()
 }
 
+// transportCredentials returns the credentials to connect to the remote with: mutual TLS using SPIFFE X.509 SVIDs if
+// configured, otherwise an insecure connection
+func (r *Runtime) transportCredentials() (credentials.TransportCredentials, error) {
+	svid := r.globalParams.Get(ParamSPIFFESVID).AsString()
+	svidKey := r.globalParams.Get(ParamSPIFFESVIDKey).AsString()
+	bundle := r.globalParams.Get(ParamSPIFFEBundle).AsString()
+	if svid == "" && svidKey == "" && bundle == "" {
+		return insecure.NewCredentials(), nil
+	}
+
+	source, err := spiffe.NewFileSource(svid, svidKey, bundle)
+	if err != nil {
+		return nil, fmt.Errorf("loading SPIFFE SVID: %w", err)
+	}
+	authorize := spiffe.AuthorizeAny()
+	if serverIDs := r.globalParams.Get(ParamSPIFFEServerIDs).AsStringSlice(); len(serverIDs) > 0 {
+		authorize, err = spiffe.AuthorizeIDs(serverIDs...)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SPIFFE server IDs: %w", err)
+		}
+	}
+	return credentials.NewTLS(spiffe.ClientTLSConfig(source, authorize)), nil
+}
+
 func (r *Runtime) dialContext(dialCtx context.Context, target target, timeout time.Duration) (*grpc.ClientConn, error) {
+	creds, err := r.transportCredentials()
+	if err != nil {
+		return nil, err
+	}
 	opts := []grpc.DialOption{
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithTransportCredentials(creds),
 		grpc.WithBlock(),
 	}
 
@@ -0,0 +1,290 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcruntime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"google.golang.org/grpc"
+
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/gadget-service/api"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/logger"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/params"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/runtime"
+)
+
+// GadgetInstance is a gadget running on a node that clients can attach to
+type GadgetInstance struct {
+	*api.GadgetInstance
+	Node string
+
+	target target
+}
+
+// dialTarget connects to target using the configured connection timeout
+func (r *Runtime) dialTarget(ctx context.Context, target target) (*grpc.ClientConn, error) {
+	timeout := time.Second * time.Duration(r.globalParams.Get(ParamConnectionTimeout).AsUint16())
+	dialCtx, cancelDial := context.WithTimeout(ctx, timeout)
+	defer cancelDial()
+
+	conn, err := r.dialContext(dialCtx, target, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("dialing target on node %q: %w", target.node, err)
+	}
+	return conn, nil
+}
+
+func (r *Runtime) listGadgetInstances(ctx context.Context, target target) ([]*GadgetInstance, error) {
+	conn, err := r.dialTarget(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	client := api.NewGadgetManagerClient(conn)
+
+	out, err := client.ListGadgetInstances(ctx, &api.ListGadgetInstancesRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("listing gadget instances on node %q: %w", target.node, err)
+	}
+
+	res := make([]*GadgetInstance, 0, len(out.GadgetInstances))
+	for _, instance := range out.GadgetInstances {
+		res = append(res, &GadgetInstance{
+			GadgetInstance: instance,
+			Node:           target.node,
+			target:         target,
+		})
+	}
+	return res, nil
+}
+
+// ListGadgetInstances returns the gadgets currently running on the targets selected by runtimeParams
+func (r *Runtime) ListGadgetInstances(ctx context.Context, runtimeParams *params.Params) ([]*GadgetInstance, error) {
+	if runtimeParams == nil {
+		runtimeParams = r.ParamDescs().ToParams()
+	}
+
+	targets, err := r.getTargets(ctx, runtimeParams)
+	if err != nil {
+		return nil, fmt.Errorf("getting target nodes: %w", err)
+	}
+
+	var res []*GadgetInstance
+	var result error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func(target target) {
+			defer wg.Done()
+			instances, err := r.listGadgetInstances(ctx, target)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result = multierror.Append(result, err)
+				return
+			}
+			res = append(res, instances...)
+		}(t)
+	}
+	wg.Wait()
+	return res, result
+}
+
+// GetGadgetInstance returns the gadget instance with the given ID running on one of the targets selected by
+// runtimeParams
+func (r *Runtime) GetGadgetInstance(ctx context.Context, runtimeParams *params.Params, instanceID string) (*GadgetInstance, error) {
+	instances, err := r.ListGadgetInstances(ctx, runtimeParams)
+	if err != nil {
+		return nil, err
+	}
+	for _, instance := range instances {
+		if instance.Id != instanceID {
+			continue
+		}
+		conn, err := r.dialTarget(ctx, instance.target)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		client := api.NewGadgetManagerClient(conn)
+
+		// Get up to date information like the number of buffered events
+		out, err := client.GetGadgetInstance(ctx, &api.GetGadgetInstanceRequest{Id: instanceID})
+		if err != nil {
+			return nil, fmt.Errorf("getting gadget instance %q: %w", instanceID, err)
+		}
+		instance.GadgetInstance = out
+		return instance, nil
+	}
+	return nil, fmt.Errorf("gadget instance %q not found", instanceID)
+}
+
+// DeleteGadgetInstance stops the detached gadget instance with the given ID
+func (r *Runtime) DeleteGadgetInstance(ctx context.Context, runtimeParams *params.Params, instanceID string) error {
+	instance, err := r.GetGadgetInstance(ctx, runtimeParams, instanceID)
+	if err != nil {
+		return err
+	}
+	conn, err := r.dialTarget(ctx, instance.target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client := api.NewGadgetManagerClient(conn)
+
+	_, err = client.DeleteGadgetInstance(ctx, &api.DeleteGadgetInstanceRequest{Id: instanceID})
+	if err != nil {
+		return fmt.Errorf("deleting gadget instance %q: %w", instanceID, err)
+	}
+	return nil
+}
+
+// RunDetachedGadget starts the gadget on all targets selected by runtimeParams without waiting for it to finish;
+// it keeps running until deleted using DeleteGadgetInstance or done. It returns the started instances, whose events
+// can be received using AttachGadget.
+func (r *Runtime) RunDetachedGadget(gadgetCtx runtime.GadgetContext, runtimeParams *params.Params, paramValues api.ParamValues) ([]*GadgetInstance, error) {
+	if runtimeParams == nil {
+		runtimeParams = r.ParamDescs().ToParams()
+	}
+
+	targets, err := r.getTargets(gadgetCtx.Context(), runtimeParams)
+	if err != nil {
+		return nil, fmt.Errorf("getting target nodes: %w", err)
+	}
+
+	var res []*GadgetInstance
+	var result error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func(target target) {
+			defer wg.Done()
+			instance, err := r.runDetachedGadget(gadgetCtx, target, paramValues)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result = multierror.Append(result, fmt.Errorf("node %q: %w", target.node, err))
+				return
+			}
+			res = append(res, instance)
+		}(t)
+	}
+	wg.Wait()
+	return res, result
+}
+
+func (r *Runtime) runDetachedGadget(gadgetCtx runtime.GadgetContext, target target, paramValues api.ParamValues) (*GadgetInstance, error) {
+	conn, err := r.dialTarget(gadgetCtx.Context(), target)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	client := api.NewGadgetManagerClient(conn)
+
+	runClient, err := client.RunGadget(gadgetCtx.Context())
+	if err != nil {
+		return nil, err
+	}
+	err = runClient.Send(&api.GadgetControlRequest{Event: &api.GadgetControlRequest_RunRequest{
+		RunRequest: &api.GadgetRunRequest{
+			ImageName:   gadgetCtx.ImageName(),
+			ParamValues: paramValues,
+			Args:        gadgetCtx.Args(),
+			LogLevel:    uint32(gadgetCtx.Logger().GetLevel()),
+			Timeout:     int64(gadgetCtx.Timeout()),
+			Version:     api.VersionGadgetRunProtocol,
+			Detach:      true,
+		},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	// The server sends the ID of the new instance once it's running
+	for {
+		ev, err := runClient.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("no gadget instance ID received")
+			}
+			return nil, err
+		}
+		switch {
+		case ev.Type == api.EventTypeGadgetJobID:
+			return &GadgetInstance{
+				GadgetInstance: &api.GadgetInstance{
+					Id:          string(ev.Payload),
+					ImageName:   gadgetCtx.ImageName(),
+					ParamValues: paramValues,
+					Detached:    true,
+				},
+				Node:   target.node,
+				target: target,
+			}, nil
+		case ev.Type >= 1<<api.EventLogShift:
+			gadgetCtx.Logger().Log(logger.Level(ev.Type>>api.EventLogShift), fmt.Sprintf("%-20s | %s", target.node, string(ev.Payload)))
+		}
+	}
+}
+
+// AttachGadget attaches to the running gadget instance with the given ID and emits its events using the data
+// sources of gadgetCtx until gadgetCtx is done or the instance stops. Unlike RunGadget, the gadget keeps running
+// when gadgetCtx is cancelled, so several clients can consume the events of the same instance. If history is set,
+// the events buffered by a detached instance are emitted first.
+func (r *Runtime) AttachGadget(gadgetCtx runtime.GadgetContext, runtimeParams *params.Params, instanceID string, history bool) error {
+	instances, err := r.ListGadgetInstances(gadgetCtx.Context(), runtimeParams)
+	if err != nil {
+		return err
+	}
+	for _, instance := range instances {
+		if instance.Id == instanceID {
+			return r.attachGadget(gadgetCtx, instance, history)
+		}
+	}
+	return fmt.Errorf("gadget instance %q not found", instanceID)
+}
+
+func (r *Runtime) attachGadget(gadgetCtx runtime.GadgetContext, instance *GadgetInstance, history bool) error {
+	conn, err := r.dialTarget(gadgetCtx.Context(), instance.target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client := api.NewGadgetManagerClient(conn)
+
+	// Closing the stream detaches from the instance without stopping it
+	attachClient, err := client.AttachToGadgetInstance(gadgetCtx.Context(), &api.AttachToGadgetInstanceRequest{
+		Id:      instance.Id,
+		Version: api.VersionGadgetRunProtocol,
+		History: history,
+	})
+	if err != nil {
+		return fmt.Errorf("attaching to gadget instance %q: %w", instance.Id, err)
+	}
+
+	_, err = r.handleEvents(gadgetCtx, instance.target, instance.ParamValues, attachClient.Recv, func() {}, func() {})
+	if gadgetCtx.Context().Err() != nil {
+		return nil
+	}
+	return err
+}
@@ -20,8 +20,11 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/datasource"
@@ -53,6 +56,11 @@ func (r *Runtime) GetGadgetInfo(gadgetCtx runtime.GadgetContext, runtimeParams *
 		return nil, fmt.Errorf("getting gadget info: %w", err)
 	}
 
+	err = r.addGapsDataSource(out.GadgetInfo)
+	if err != nil {
+		return nil, err
+	}
+
 	err = gadgetCtx.LoadGadgetInfo(out.GadgetInfo, paramValues, false)
 	if err != nil {
 		return nil, fmt.Errorf("initializing local operators: %w", err)
@@ -107,7 +115,92 @@ func (r *Runtime) runGadgetOnTargets(
 	return results, results.Err()
 }
 
+// runGadget runs the gadget on target. If reconnecting is enabled and the connection is lost after the gadget has
+// been started, it is started again on the same target with exponential backoff between attempts; events emitted
+// by the remote while disconnected are lost, which is reported by an event of the DataSource GapsDataSourceName.
 func (r *Runtime) runGadget(gadgetCtx runtime.GadgetContext, target target, allParams map[string]string) ([]byte, error) {
+	reconnect := r.globalParams.Get(ParamReconnect).AsBool()
+	maxAttempts := r.globalParams.Get(ParamReconnectMaxAttempts).AsUint32()
+	maxBackoff := r.globalParams.Get(ParamReconnectMaxBackoff).AsDuration()
+
+	var deadline time.Time
+	if gadgetCtx.Timeout() > 0 {
+		deadline = time.Now().Add(gadgetCtx.Timeout())
+	}
+
+	timeout := gadgetCtx.Timeout()
+	everConnected := false
+	attempts := uint32(0)
+	backoff := reconnectInitialBackoff
+	var disconnected time.Time
+	for {
+		reconnecting := everConnected
+		gapStart := disconnected
+		onConnected := func() {
+			if !reconnecting {
+				return
+			}
+			gadgetCtx.Logger().Warnf("%-20s | reconnected, events between %s and now are missing",
+				target.node, gapStart.Format(time.RFC3339))
+			err := emitGap(gadgetCtx, target.node, gapStart, time.Now())
+			if err != nil {
+				gadgetCtx.Logger().Warnf("%-20s | emitting gap: %v", target.node, err)
+			}
+		}
+
+		result, connected, err := r.runGadgetStream(gadgetCtx, target, allParams, timeout, onConnected)
+		if connected {
+			everConnected = true
+			attempts = 0
+			backoff = reconnectInitialBackoff
+		}
+		if err == nil || !reconnect || !everConnected || gadgetCtx.Context().Err() != nil {
+			return result, err
+		}
+		if connected {
+			if status.Code(err) != codes.Unavailable {
+				return result, err
+			}
+			disconnected = time.Now()
+		}
+
+		attempts++
+		if maxAttempts > 0 && attempts > maxAttempts {
+			return result, fmt.Errorf("giving up reconnecting after %d attempts: %w", maxAttempts, err)
+		}
+		if !deadline.IsZero() {
+			timeout = time.Until(deadline)
+			if timeout <= backoff {
+				return result, err
+			}
+			timeout -= backoff
+		}
+
+		gadgetCtx.Logger().Warnf("%-20s | connection lost (%v), reconnecting in %s (attempt %d)",
+			target.node, err, backoff, attempts)
+		select {
+		case <-time.After(backoff):
+		case <-gadgetCtx.Context().Done():
+			return result, err
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// runGadgetStream runs the gadget on target using a single connection. connected is true if the gadget was started
+// on the remote, i.e. its gadget info has been received; onConnected is called at that time.
+func (r *Runtime) runGadgetStream(
+	gadgetCtx runtime.GadgetContext,
+	target target,
+	allParams map[string]string,
+	gadgetTimeout time.Duration,
+	onConnected func(),
+) (result []byte, connected bool, err error) {
+	var isConnected atomic.Bool
+	defer func() {
+		connected = isConnected.Load()
+	}()
+
 	// Notice that we cannot use gadgetCtx.Context() here, as that would - when cancelled by the user - also cancel the
 	// underlying gRPC connection. That would then lead to results not being received anymore (mostly for profile
 	// gadgets.)
@@ -120,7 +213,7 @@ func (r *Runtime) runGadget(gadgetCtx runtime.GadgetContext, target target, allP
 
 	conn, err := r.dialContext(dialCtx, target, timeout)
 	if err != nil {
-		return nil, fmt.Errorf("dialing target on node %q: %w", target.node, err)
+		return nil, false, fmt.Errorf("dialing target on node %q: %w", target.node, err)
 	}
 	defer conn.Close()
 	client := api.NewGadgetManagerClient(conn)
@@ -130,96 +223,60 @@ func (r *Runtime) runGadget(gadgetCtx runtime.GadgetContext, target target, allP
 		ParamValues: allParams,
 		Args:        gadgetCtx.Args(),
 		LogLevel:    uint32(gadgetCtx.Logger().GetLevel()),
-		Timeout:     int64(gadgetCtx.Timeout()),
+		Timeout:     int64(gadgetTimeout),
 		Version:     api.VersionGadgetRunProtocol,
+
+		Credits:           r.globalParams.Get(ParamFlowControlCredits).AsUint32(),
+		FlowControlPolicy: r.globalParams.Get(ParamFlowControlPolicy).AsString(),
 	}
 
 	runClient, err := client.RunGadget(connCtx)
 	if err != nil && !errors.Is(err, context.Canceled) {
-		return nil, err
+		return nil, false, err
+	}
+
+	// Send is called from both the receiving goroutine (credits) and this one (stop request)
+	var sendLock sync.Mutex
+	send := func(controlRequest *api.GadgetControlRequest) error {
+		sendLock.Lock()
+		defer sendLock.Unlock()
+		return runClient.Send(controlRequest)
 	}
 
 	controlRequest := &api.GadgetControlRequest{Event: &api.GadgetControlRequest_RunRequest{RunRequest: runRequest}}
-	err = runClient.Send(controlRequest)
+	err = send(controlRequest)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	doneChan := make(chan error)
+	// With flow control enabled, request more credits once half of them have been consumed
+	consumed := uint32(0)
+	grantCredits := func() {
+		if runRequest.Credits == 0 {
+			return
+		}
+		consumed++
+		if consumed < (runRequest.Credits+1)/2 {
+			return
+		}
+		err := send(&api.GadgetControlRequest{Event: &api.GadgetControlRequest_CreditRequest{
+			CreditRequest: &api.GadgetCreditRequest{Credits: consumed},
+		}})
+		if err != nil {
+			gadgetCtx.Logger().Debugf("%-20s | requesting credits: %v", target.node, err)
+		}
+		consumed = 0
+	}
 
-	var result []byte
-	expectedSeq := uint32(1)
+	doneChan := make(chan error)
 
 	go func() {
-		dsMap := make(map[uint32]datasource.DataSource)
-		dsNameMap := make(map[string]uint32)
-		initialized := false
-		for {
-			ev, err := runClient.Recv()
-			if err != nil {
-				gadgetCtx.Logger().Debugf("%-20s | runClient returned with %v", target.node, err)
-				if !errors.Is(err, io.EOF) {
-					doneChan <- err
-					return
-				}
-				doneChan <- nil
-				return
-			}
-			switch ev.Type {
-			case api.EventTypeGadgetPayload:
-				if !initialized {
-					gadgetCtx.Logger().Warnf("%-20s | received payload without being initialized", target.node)
-					continue
-				}
-				if expectedSeq != ev.Seq {
-					gadgetCtx.Logger().Warnf("%-20s | expected seq %d, got %d, %d messages dropped", target.node, expectedSeq, ev.Seq, ev.Seq-expectedSeq)
-				}
-				expectedSeq = ev.Seq + 1
-				if ds, ok := dsMap[ev.DataSourceID]; ok && ds != nil {
-					d := ds.NewData()
-					err := proto.Unmarshal(ev.Payload, d.Raw())
-					if err != nil {
-						gadgetCtx.Logger().Debugf("error unmarshaling payload: %v", err)
-						continue
-					}
-					ds.EmitAndRelease(d)
-				}
-			case api.EventTypeGadgetResult:
-				gadgetCtx.Logger().Debugf("%-20s | got result from server", target.node)
-				result = ev.Payload
-			case api.EventTypeGadgetJobID: // not needed right now
-			case api.EventTypeGadgetInfo:
-				gi := &api.GadgetInfo{}
-				err = proto.Unmarshal(ev.Payload, gi)
-				if err != nil {
-					gadgetCtx.Logger().Warnf("unmarshaling gadget info: %v", err)
-					continue
-				}
-				for _, ds := range gi.DataSources {
-					dsNameMap[ds.Name] = ds.Id
-				}
-
-				// Try to load gadget info; if gadget info has already been loaded and this one
-				// doesn't match, this will terminate this particular client session
-				err = gadgetCtx.LoadGadgetInfo(gi, allParams, true)
-				if err != nil {
-					gadgetCtx.Logger().Warnf("deserizalize gadget info: %v", err)
-					continue
-				}
-				gadgetCtx.Logger().Debugf("loaded gadget info")
-				for _, ds := range gadgetCtx.GetDataSources() {
-					gadgetCtx.Logger().Debugf("registered ds %s", ds.Name())
-					dsMap[dsNameMap[ds.Name()]] = ds
-				}
-				initialized = true
-			default:
-				if ev.Type >= 1<<api.EventLogShift {
-					gadgetCtx.Logger().Log(logger.Level(ev.Type>>api.EventLogShift), fmt.Sprintf("%-20s | %s", target.node, string(ev.Payload)))
-					continue
-				}
-				gadgetCtx.Logger().Warnf("unknown payload type %d: %s", ev.Type, ev.Payload)
-			}
-		}
+		res, err := r.handleEvents(gadgetCtx, target, allParams, runClient.Recv, grantCredits, func() {
+			isConnected.Store(true)
+			onConnected()
+		})
+		result = res
+		doneChan <- err
 	}()
 
 	var runErr error
@@ -231,7 +288,7 @@ func (r *Runtime) runGadget(gadgetCtx runtime.GadgetContext, target target, allP
 		// Send stop request
 		gadgetCtx.Logger().Debugf("%-20s | sending stop request", target.node)
 		controlRequest := &api.GadgetControlRequest{Event: &api.GadgetControlRequest_StopRequest{StopRequest: &api.GadgetStopRequest{}}}
-		runClient.Send(controlRequest)
+		send(controlRequest)
 
 		// Wait for done or timeout
 		select {
@@ -239,8 +296,98 @@ func (r *Runtime) runGadget(gadgetCtx runtime.GadgetContext, target target, allP
 			gadgetCtx.Logger().Debugf("%-20s | done after cancel request (%v)", target.node, doneErr)
 			runErr = doneErr
 		case <-time.After(ResultTimeout * time.Second):
-			return nil, fmt.Errorf("timed out while getting result")
+			return nil, false, fmt.Errorf("timed out while getting result")
+		}
+	}
+	return result, false, runErr
+}
+
+// handleEvents emits the events received from target using recv until the stream ends and returns the result sent
+// by the remote, if any. onPayload is called for every payload event, onGadgetInfo once the gadget info has been
+// loaded.
+func (r *Runtime) handleEvents(
+	gadgetCtx runtime.GadgetContext,
+	target target,
+	allParams map[string]string,
+	recv func() (*api.GadgetEvent, error),
+	onPayload func(),
+	onGadgetInfo func(),
+) (result []byte, err error) {
+	dsMap := make(map[uint32]datasource.DataSource)
+	dsNameMap := make(map[string]uint32)
+	initialized := false
+	expectedSeq := uint32(1)
+	for {
+		ev, err := recv()
+		if err != nil {
+			gadgetCtx.Logger().Debugf("%-20s | runClient returned with %v", target.node, err)
+			if !errors.Is(err, io.EOF) {
+				return result, err
+			}
+			return result, nil
+		}
+		switch ev.Type {
+		case api.EventTypeGadgetPayload:
+			onPayload()
+			if !initialized {
+				gadgetCtx.Logger().Warnf("%-20s | received payload without being initialized", target.node)
+				continue
+			}
+			if expectedSeq != ev.Seq {
+				gadgetCtx.Logger().Warnf("%-20s | expected seq %d, got %d, %d messages dropped", target.node, expectedSeq, ev.Seq, ev.Seq-expectedSeq)
+			}
+			expectedSeq = ev.Seq + 1
+			if ds, ok := dsMap[ev.DataSourceID]; ok && ds != nil {
+				d := ds.NewData()
+				err := proto.Unmarshal(ev.Payload, d.Raw())
+				if err != nil {
+					gadgetCtx.Logger().Debugf("error unmarshaling payload: %v", err)
+					continue
+				}
+				ds.EmitAndRelease(d)
+			}
+		case api.EventTypeGadgetResult:
+			gadgetCtx.Logger().Debugf("%-20s | got result from server", target.node)
+			result = ev.Payload
+		case api.EventTypeGadgetJobID: // not needed right now
+		case api.EventTypeGadgetInfo:
+			gi := &api.GadgetInfo{}
+			err = proto.Unmarshal(ev.Payload, gi)
+			if err != nil {
+				gadgetCtx.Logger().Warnf("unmarshaling gadget info: %v", err)
+				continue
+			}
+			for _, ds := range gi.DataSources {
+				dsNameMap[ds.Name] = ds.Id
+			}
+			err = r.addGapsDataSource(gi)
+			if err != nil {
+				return result, err
+			}
+
+			// Try to load gadget info; if gadget info has already been loaded and this one
+			// doesn't match, this will terminate this particular client session
+			err = gadgetCtx.LoadGadgetInfo(gi, allParams, true)
+			if err != nil {
+				gadgetCtx.Logger().Warnf("deserizalize gadget info: %v", err)
+				continue
+			}
+			gadgetCtx.Logger().Debugf("loaded gadget info")
+			for _, ds := range gadgetCtx.GetDataSources() {
+				gadgetCtx.Logger().Debugf("registered ds %s", ds.Name())
+				// DataSources added locally, like the one for gaps, don't receive events from the remote
+				if id, ok := dsNameMap[ds.Name()]; ok {
+					dsMap[id] = ds
+				}
+			}
+			initialized = true
+			onGadgetInfo()
+		default:
+			if ev.Type >= 1<<api.EventLogShift {
+				gadgetCtx.Logger().Log(logger.Level(ev.Type>>api.EventLogShift), fmt.Sprintf("%-20s | %s", target.node, string(ev.Payload)))
+				continue
+			}
+			gadgetCtx.Logger().Warnf("unknown payload type %d: %s", ev.Type, ev.Payload)
 		}
 	}
-	return result, runErr
 }
@@ -0,0 +1,106 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package spiffe implements authentication of gRPC peers using SPIFFE X.509 SVIDs. SVIDs and trust bundles are
+// read from files, like the ones written by spiffe-helper or the SPIRE agent, so workloads can be authorized by
+// their identity instead of static credentials.
+package spiffe
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const scheme = "spiffe"
+
+// ID is a SPIFFE ID like spiffe://example.org/ns/gadget/sa/ig
+type ID struct {
+	TrustDomain string
+	Path        string
+}
+
+func (id ID) String() string {
+	return scheme + "://" + id.TrustDomain + id.Path
+}
+
+// ParseID parses and validates a SPIFFE ID
+func ParseID(s string) (ID, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return ID{}, fmt.Errorf("parsing SPIFFE ID %q: %w", s, err)
+	}
+	return idFromURL(u)
+}
+
+func idFromURL(u *url.URL) (ID, error) {
+	switch {
+	case u.Scheme != scheme:
+		return ID{}, fmt.Errorf("SPIFFE ID %q: scheme must be %q", u, scheme)
+	case u.Host == "":
+		return ID{}, fmt.Errorf("SPIFFE ID %q: missing trust domain", u)
+	case u.User != nil, u.Port() != "":
+		return ID{}, fmt.Errorf("SPIFFE ID %q: trust domain must not contain user info or port", u)
+	case u.RawQuery != "", u.Fragment != "":
+		return ID{}, fmt.Errorf("SPIFFE ID %q: must not contain query or fragment", u)
+	case strings.HasSuffix(u.Path, "/"):
+		return ID{}, fmt.Errorf("SPIFFE ID %q: path must not end with \"/\"", u)
+	}
+	return ID{TrustDomain: strings.ToLower(u.Host), Path: u.Path}, nil
+}
+
+// IDFromCertificate returns the SPIFFE ID of an X.509 SVID
+func IDFromCertificate(cert *x509.Certificate) (ID, error) {
+	switch len(cert.URIs) {
+	case 0:
+		return ID{}, errors.New("certificate contains no SPIFFE ID")
+	case 1:
+		return idFromURL(cert.URIs[0])
+	default:
+		return ID{}, fmt.Errorf("certificate contains %d URI SANs, expected exactly one", len(cert.URIs))
+	}
+}
+
+// Authorizer returns an error if a peer with the given ID isn't allowed to connect
+type Authorizer func(id ID) error
+
+// AuthorizeAny allows all peers with an SVID issued by the trust bundle
+func AuthorizeAny() Authorizer {
+	return func(id ID) error {
+		return nil
+	}
+}
+
+// AuthorizeIDs allows peers whose ID is in the given list. An entry without a path (spiffe://example.org) allows
+// all IDs of that trust domain.
+func AuthorizeIDs(allowed ...string) (Authorizer, error) {
+	ids := make([]ID, 0, len(allowed))
+	for _, s := range allowed {
+		id, err := ParseID(s)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return func(id ID) error {
+		for _, a := range ids {
+			if a.TrustDomain == id.TrustDomain && (a.Path == "" || a.Path == id.Path) {
+				return nil
+			}
+		}
+		return fmt.Errorf("SPIFFE ID %q is not authorized", id)
+	}, nil
+}
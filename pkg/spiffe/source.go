@@ -0,0 +1,114 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spiffe
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileSource provides an X.509 SVID and the trust bundle to verify peers with. The PEM files are read again when
+// they change, so rotated SVIDs are used without restarting.
+type FileSource struct {
+	certFile   string
+	keyFile    string
+	bundleFile string
+
+	mu       sync.Mutex
+	modTimes [3]time.Time
+	cert     *tls.Certificate
+	bundle   *x509.CertPool
+}
+
+// NewFileSource creates a FileSource and loads the given files
+func NewFileSource(certFile, keyFile, bundleFile string) (*FileSource, error) {
+	if certFile == "" || keyFile == "" || bundleFile == "" {
+		return nil, errors.New("SVID certificate, SVID key and trust bundle files are required")
+	}
+	s := &FileSource{
+		certFile:   certFile,
+		keyFile:    keyFile,
+		bundleFile: bundleFile,
+	}
+	if _, _, err := s.get(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSource) get() (*tls.Certificate, *x509.CertPool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var modTimes [3]time.Time
+	for i, f := range []string{s.certFile, s.keyFile, s.bundleFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		modTimes[i] = fi.ModTime()
+	}
+	if s.cert != nil && modTimes == s.modTimes {
+		return s.cert, s.bundle, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		if s.cert != nil {
+			// The files might be in the middle of being rotated
+			return s.cert, s.bundle, nil
+		}
+		return nil, nil, fmt.Errorf("loading SVID: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing SVID: %w", err)
+	}
+	if _, err := IDFromCertificate(leaf); err != nil {
+		return nil, nil, fmt.Errorf("invalid SVID %q: %w", s.certFile, err)
+	}
+	cert.Leaf = leaf
+
+	pem, err := os.ReadFile(s.bundleFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading trust bundle: %w", err)
+	}
+	bundle := x509.NewCertPool()
+	if !bundle.AppendCertsFromPEM(pem) {
+		return nil, nil, fmt.Errorf("no certificates found in trust bundle %q", s.bundleFile)
+	}
+
+	s.cert = &cert
+	s.bundle = bundle
+	s.modTimes = modTimes
+	return s.cert, s.bundle, nil
+}
+
+// Certificate returns the current SVID
+func (s *FileSource) Certificate() (*tls.Certificate, error) {
+	cert, _, err := s.get()
+	return cert, err
+}
+
+// Bundle returns the current trust bundle
+func (s *FileSource) Bundle() (*x509.CertPool, error) {
+	_, bundle, err := s.get()
+	return bundle, err
+}
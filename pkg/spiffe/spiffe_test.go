@@ -0,0 +1,167 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spiffe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseID(t *testing.T) {
+	id, err := ParseID("spiffe://Example.org/ns/gadget/sa/ig")
+	require.NoError(t, err)
+	require.Equal(t, ID{TrustDomain: "example.org", Path: "/ns/gadget/sa/ig"}, id)
+	require.Equal(t, "spiffe://example.org/ns/gadget/sa/ig", id.String())
+
+	for _, s := range []string{
+		"https://example.org/foo",
+		"spiffe:///foo",
+		"spiffe://example.org:8080/foo",
+		"spiffe://example.org/foo/",
+		"spiffe://example.org/foo?bar",
+	} {
+		_, err := ParseID(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestAuthorizeIDs(t *testing.T) {
+	authorize, err := AuthorizeIDs("spiffe://example.org/client", "spiffe://other.org")
+	require.NoError(t, err)
+
+	require.NoError(t, authorize(ID{TrustDomain: "example.org", Path: "/client"}))
+	require.NoError(t, authorize(ID{TrustDomain: "other.org", Path: "/anything"}))
+	require.Error(t, authorize(ID{TrustDomain: "example.org", Path: "/other"}))
+
+	_, err = AuthorizeIDs("example.org")
+	require.Error(t, err)
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		URIs:                  []*url.URL{{Scheme: "spiffe", Host: "example.org"}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+// newSource creates an SVID for id signed by ca and returns a source using it
+func (ca *testCA) newSource(t *testing.T, id string) *FileSource {
+	u, err := url.Parse(id)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		URIs:         []*url.URL{u},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	write := func(name, typ string, b []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}), 0o600))
+		return path
+	}
+	source, err := NewFileSource(
+		write("svid.pem", "CERTIFICATE", der),
+		write("svid_key.pem", "EC PRIVATE KEY", keyDer),
+		write("bundle.pem", "CERTIFICATE", ca.cert.Raw),
+	)
+	require.NoError(t, err)
+	return source
+}
+
+func handshake(serverConfig, clientConfig *tls.Config) (serverErr, clientErr error) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	done := make(chan error)
+	go func() {
+		err := tls.Server(serverConn, serverConfig).Handshake()
+		serverConn.Close()
+		done <- err
+	}()
+	clientErr = tls.Client(clientConn, clientConfig).Handshake()
+	clientConn.Close()
+	return <-done, clientErr
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverSource := ca.newSource(t, "spiffe://example.org/server")
+	clientSource := ca.newSource(t, "spiffe://example.org/client")
+
+	allowClient, err := AuthorizeIDs("spiffe://example.org/client")
+	require.NoError(t, err)
+	allowServer, err := AuthorizeIDs("spiffe://example.org/server")
+	require.NoError(t, err)
+
+	serverErr, clientErr := handshake(ServerTLSConfig(serverSource, allowClient), ClientTLSConfig(clientSource, allowServer))
+	require.NoError(t, serverErr)
+	require.NoError(t, clientErr)
+
+	// Client not authorized by the server
+	serverErr, _ = handshake(ServerTLSConfig(serverSource, allowServer), ClientTLSConfig(clientSource, AuthorizeAny()))
+	require.ErrorContains(t, serverErr, "not authorized")
+
+	// Server not authorized by the client
+	_, clientErr = handshake(ServerTLSConfig(serverSource, AuthorizeAny()), ClientTLSConfig(clientSource, allowClient))
+	require.ErrorContains(t, clientErr, "not authorized")
+
+	// SVID issued by another CA
+	otherSource := newTestCA(t).newSource(t, "spiffe://example.org/client")
+	serverErr, clientErr = handshake(ServerTLSConfig(serverSource, AuthorizeAny()), ClientTLSConfig(otherSource, AuthorizeAny()))
+	require.Error(t, serverErr)
+	require.ErrorContains(t, clientErr, "verifying peer SVID")
+}
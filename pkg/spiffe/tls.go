@@ -0,0 +1,88 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spiffe
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// ServerTLSConfig returns a TLS configuration that presents the SVID of source and only accepts clients with an SVID
+// issued by its trust bundle and allowed by authorize
+func ServerTLSConfig(source *FileSource, authorize Authorizer) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: tls.RequireAnyClientCert,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return source.Certificate()
+		},
+		VerifyPeerCertificate: verifyPeer(source, authorize),
+	}
+}
+
+// ClientTLSConfig returns a TLS configuration that presents the SVID of source and only accepts servers with an SVID
+// issued by its trust bundle and allowed by authorize
+func ClientTLSConfig(source *FileSource, authorize Authorizer) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// SVIDs don't contain host names, the server is verified using its SPIFFE ID in VerifyPeerCertificate
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return source.Certificate()
+		},
+		VerifyPeerCertificate: verifyPeer(source, authorize),
+	}
+}
+
+func verifyPeer(source *FileSource, authorize Authorizer) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("peer didn't present an SVID")
+		}
+		certs := make([]*x509.Certificate, 0, len(rawCerts))
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("parsing peer certificate: %w", err)
+			}
+			certs = append(certs, cert)
+		}
+
+		bundle, err := source.Bundle()
+		if err != nil {
+			return err
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err = certs[0].Verify(x509.VerifyOptions{
+			Roots:         bundle,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return fmt.Errorf("verifying peer SVID: %w", err)
+		}
+
+		id, err := IDFromCertificate(certs[0])
+		if err != nil {
+			return fmt.Errorf("peer SVID: %w", err)
+		}
+		return authorize(id)
+	}
+}
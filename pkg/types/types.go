@@ -108,9 +108,22 @@ func String2RuntimeName(name string) RuntimeName {
 type Container interface {
 	K8sMetadata() *BasicK8sMetadata
 	RuntimeMetadata() *BasicRuntimeMetadata
+	RestartMetadata() *BasicRestartMetadata
 	UsesHostNetwork() bool
 }
 
+// BasicRestartMetadata tells whether a container replaced a previous instance of itself, i.e. a container with the
+// same name in the same pod or, outside of Kubernetes, with the same name in the same runtime
+type BasicRestartMetadata struct {
+	// RestartCount is the number of times the container has been restarted. It's reported by the runtime if
+	// possible (e.g. the attempt of CRI containers), otherwise only restarts observed since starting are counted.
+	RestartCount uint32 `json:"restartCount,omitempty" column:"restartCount,width:7,hide"`
+
+	// PreviousContainerID is the ID of the container this one replaced; it's only known if the previous container
+	// was observed, too. It is the same as the current ID for runtimes restarting containers in place, like Docker.
+	PreviousContainerID string `json:"previousContainerId,omitempty" column:"previousContainerId,width:13,maxWidth:64,hide"`
+}
+
 type BasicRuntimeMetadata struct {
 	// RuntimeName is the name of the container runtime. It is useful to distinguish
 	// who is the "owner" of each container in a list of containers collected
@@ -64,12 +64,19 @@ type inodeKeeper struct {
 	counter int
 	file    *os.File
 	link    link.Link
+
+	// usdtSpecs holds the argument spec of the attached USDT probe at usdtSpecID if not nil
+	usdtSpecs  *UsdtSpecs
+	usdtSpecID uint32
 }
 
 func (t *inodeKeeper) close() {
 	if t.link != nil {
 		t.link.Close()
 	}
+	if t.usdtSpecs != nil {
+		t.usdtSpecs.remove(t.usdtSpecID)
+	}
 	t.file.Close()
 }
 
@@ -79,6 +86,7 @@ type Tracer[Event any] struct {
 	attachFilePath string
 	attachSymbol   string
 	prog           *ebpf.Program
+	usdtSpecs      *UsdtSpecs
 
 	// keeps the inodes for each attached container
 	// when users write library names in ebpf section names, it's possible to
@@ -120,6 +128,30 @@ func NewTracer[Event any](logger logger.Logger) (*Tracer[Event], error) {
 	return t, nil
 }
 
+// ParseTarget splits the target of a program, `<file_path>:<symbol>` for uprobes and uretprobes and
+// `<file_path>:<provider>:<probe>` for USDT, into the file to attach to and the symbol. The file is either an
+// absolute path or a library name, both are resolved in the mount namespace of each container.
+func ParseTarget(progType ProgType, attachTo string) (string, string, error) {
+	parts := strings.SplitN(attachTo, ":", 2)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid section name %q", attachTo)
+	}
+	if !filepath.IsAbs(parts[0]) && strings.Contains(parts[0], "/") {
+		return "", "", fmt.Errorf("section name must be either an absolute path or a library name: %q", parts[0])
+	}
+	if progType == ProgUSDT && len(strings.Split(parts[1], ":")) != 2 {
+		return "", "", fmt.Errorf("invalid USDT section name: %q", attachTo)
+	}
+	return parts[0], parts[1], nil
+}
+
+// SetUsdtSpecs makes the arguments of USDT probes available to the program; it has to be called before AttachProg
+func (t *Tracer[Event]) SetUsdtSpecs(specs *UsdtSpecs) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.usdtSpecs = specs
+}
+
 // AttachProg loads the ebpf program, and try attaching if there are pending containers
 func (t *Tracer[Event]) AttachProg(progName string, progType ProgType, attachTo string, prog *ebpf.Program) error {
 	if progType != ProgUprobe && progType != ProgUretprobe && progType != ProgUSDT {
@@ -133,15 +165,9 @@ func (t *Tracer[Event]) AttachProg(progName string, progType ProgType, attachTo
 		return errors.New("loading uprobe program twice")
 	}
 
-	parts := strings.SplitN(attachTo, ":", 2)
-	if len(parts) < 2 {
-		return fmt.Errorf("invalid section name %q", attachTo)
-	}
-	if !filepath.IsAbs(parts[0]) && strings.Contains(parts[0], "/") {
-		return fmt.Errorf("section name must be either an absolute path or a library name: %q", parts[0])
-	}
-	if progType == ProgUSDT && len(strings.Split(parts[1], ":")) != 2 {
-		return fmt.Errorf("invalid USDT section name: %q", attachTo)
+	filePath, symbol, err := ParseTarget(progType, attachTo)
+	if err != nil {
+		return err
 	}
 
 	t.mu.Lock()
@@ -153,8 +179,8 @@ func (t *Tracer[Event]) AttachProg(progName string, progType ProgType, attachTo
 
 	t.progName = progName
 	t.progType = progType
-	t.attachFilePath = parts[0]
-	t.attachSymbol = parts[1]
+	t.attachFilePath = filePath
+	t.attachSymbol = symbol
 	t.prog = prog
 
 	// attach to pending containers, then release the pending list
@@ -195,30 +221,43 @@ func (t *Tracer[Event]) searchForLibrary(containerPid uint32) ([]string, error)
 	return securedTargetPaths, nil
 }
 
-// attach uprobe program to the inode of the file passed in parameter
-func (t *Tracer[Event]) attachUprobe(file *os.File) (link.Link, error) {
-	attachPath := path.Join(host.HostProcFs, "self/fd/", fmt.Sprint(file.Fd()))
+// attach uprobe program to the inode of the file held by keeper
+func (t *Tracer[Event]) attachUprobe(keeper *inodeKeeper) error {
+	attachPath := path.Join(host.HostProcFs, "self/fd/", fmt.Sprint(keeper.file.Fd()))
 	ex, err := link.OpenExecutable(attachPath)
 	if err != nil {
-		return nil, fmt.Errorf("opening %q: %w", attachPath, err)
+		return fmt.Errorf("opening %q: %w", attachPath, err)
 	}
 	switch t.progType {
 	case ProgUprobe:
-		return ex.Uprobe(t.attachSymbol, t.prog, nil)
+		keeper.link, err = ex.Uprobe(t.attachSymbol, t.prog, nil)
+		return err
 	case ProgUretprobe:
-		return ex.Uretprobe(t.attachSymbol, t.prog, nil)
+		keeper.link, err = ex.Uretprobe(t.attachSymbol, t.prog, nil)
+		return err
 	case ProgUSDT:
 		attachInfo, err := getUsdtInfo(attachPath, t.attachSymbol)
 		if err != nil {
-			return nil, fmt.Errorf("reading USDT metadata: %w", err)
+			return fmt.Errorf("reading USDT metadata: %w", err)
+		}
+		opts := &link.UprobeOptions{
+			Address:      attachInfo.attachAddress,
+			RefCtrOffset: attachInfo.semaphoreAddress,
+		}
+		if t.usdtSpecs != nil {
+			id, err := t.usdtSpecs.add(attachInfo.args)
+			if err != nil {
+				return fmt.Errorf("handling arguments of USDT probe %q: %w", t.attachSymbol, err)
+			}
+			// The program finds the spec of its arguments using the cookie
+			opts.Cookie = uint64(id)
+			keeper.usdtSpecs = t.usdtSpecs
+			keeper.usdtSpecID = id
 		}
-		return ex.Uprobe(t.attachSymbol, t.prog,
-			&link.UprobeOptions{
-				Address:      attachInfo.attachAddress,
-				RefCtrOffset: attachInfo.semaphoreAddress,
-			})
+		keeper.link, err = ex.Uprobe(t.attachSymbol, t.prog, opts)
+		return err
 	default:
-		return nil, fmt.Errorf("attaching to inode: unsupported prog type: %q", t.progType)
+		return fmt.Errorf("attaching to inode: unsupported prog type: %q", t.progType)
 	}
 }
 
@@ -255,11 +294,11 @@ func (t *Tracer[Event]) attach(containerPid uint32) {
 
 		inode, exists := t.inodeRefCount[realInodePtr]
 		if !exists {
-			progLink, err := t.attachUprobe(file)
-			if err != nil {
+			keeper := &inodeKeeper{counter: 1, file: file}
+			if err := t.attachUprobe(keeper); err != nil {
 				t.logger.Debugf("failed to attach uprobe %q: %s", t.progName, err.Error())
 			}
-			t.inodeRefCount[realInodePtr] = &inodeKeeper{1, file, progLink}
+			t.inodeRefCount[realInodePtr] = keeper
 		} else {
 			inode.counter++
 			file.Close()
@@ -40,6 +40,8 @@ type noteHeader struct {
 type usdtAttachInfo struct {
 	attachAddress    uint64
 	semaphoreAddress uint64
+	// args describes the location of the arguments of the probe, e.g. "-4@%eax 8@-16(%rbp)"
+	args string
 }
 
 func vaddr2ElfOffset(f *elf.File, addr uint64) (uint64, error) {
@@ -154,7 +156,8 @@ func getUsdtInfo(filepath string, attachSymbol string) (*usdtAttachInfo, error)
 		provider := readStringFromBytes(desc, uint32(3*wordSize))
 		probe := readStringFromBytes(desc, uint32(3*wordSize+len(provider)+1))
 		if provider == providerName && probe == probeName {
-			return &usdtAttachInfo{location, elfSemaphore}, nil
+			args := readStringFromBytes(desc, uint32(3*wordSize+len(provider)+1+len(probe)+1))
+			return &usdtAttachInfo{location, elfSemaphore, args}, nil
 		}
 	}
 	return nil, errors.New("no matching USDT metadata")
@@ -0,0 +1,300 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uprobetracer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cilium/ebpf"
+)
+
+// Keep these aligned with include/gadget/usdt.h
+const (
+	// UsdtSpecsMapName is the map gadgets use to read the arguments of USDT probes
+	UsdtSpecsMapName = "gadget_usdt_specs"
+
+	usdtMaxArgs  = 12
+	usdtArgSize  = 16
+	usdtSpecSize = usdtMaxArgs*usdtArgSize + 8
+)
+
+type usdtArgType uint32
+
+const (
+	usdtArgConst usdtArgType = iota
+	usdtArgReg
+	usdtArgRegDeref
+)
+
+// usdtArg tells where to find an argument of a USDT probe: a constant in valOff, the register at regOff in pt_regs
+// or the memory at valOff from the address in that register. The value is shifted left and right by bitshift to
+// truncate and, if signed, sign-extend it to 64 bits.
+type usdtArg struct {
+	valOff   uint64
+	argType  usdtArgType
+	regOff   int16
+	signed   bool
+	bitshift int8
+}
+
+// Offsets of registers in struct pt_regs
+var (
+	x86RegOffsets = func() map[string]int16 {
+		offsets := make(map[string]int16)
+		for off, names := range [][]string{
+			{"r15", "r15d", "r15w", "r15b"},
+			{"r14", "r14d", "r14w", "r14b"},
+			{"r13", "r13d", "r13w", "r13b"},
+			{"r12", "r12d", "r12w", "r12b"},
+			{"rbp", "ebp", "bp", "bpl"},
+			{"rbx", "ebx", "bx", "bl"},
+			{"r11", "r11d", "r11w", "r11b"},
+			{"r10", "r10d", "r10w", "r10b"},
+			{"r9", "r9d", "r9w", "r9b"},
+			{"r8", "r8d", "r8w", "r8b"},
+			{"rax", "eax", "ax", "al"},
+			{"rcx", "ecx", "cx", "cl"},
+			{"rdx", "edx", "dx", "dl"},
+			{"rsi", "esi", "si", "sil"},
+			{"rdi", "edi", "di", "dil"},
+			{},
+			{"rip", "eip", "ip"},
+			{},
+			{},
+			{"rsp", "esp", "sp", "spl"},
+		} {
+			for _, name := range names {
+				offsets[name] = int16(off * 8)
+			}
+		}
+		return offsets
+	}()
+	arm64RegOffsets = func() map[string]int16 {
+		offsets := map[string]int16{"sp": 31 * 8}
+		for n := 0; n <= 30; n++ {
+			offsets["x"+strconv.Itoa(n)] = int16(n * 8)
+		}
+		return offsets
+	}()
+)
+
+var (
+	x86ArgConst    = regexp.MustCompile(`^\$(-?\w+)$`)
+	x86ArgReg      = regexp.MustCompile(`^%(\w+)$`)
+	x86ArgRegDeref = regexp.MustCompile(`^(-?\w*)\(%(\w+)\)$`)
+
+	arm64ArgConst    = regexp.MustCompile(`^(-?\d+)$`)
+	arm64ArgReg      = regexp.MustCompile(`^(\w+)$`)
+	arm64ArgRegDeref = regexp.MustCompile(`^\[(\w+)(?:,\s*(-?\w+))?\]$`)
+)
+
+// splitUsdtArgs splits the arguments of a USDT probe, which are separated by spaces that might also appear within
+// brackets
+func splitUsdtArgs(args string) []string {
+	var res []string
+	depth := 0
+	start := -1
+	for i, c := range args {
+		switch {
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == ' ' && depth == 0:
+			if start >= 0 {
+				res = append(res, args[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		res = append(res, args[start:])
+	}
+	return res
+}
+
+func parseInt(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if v, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return v, nil
+	}
+	// Constants might be unsigned values that don't fit into int64
+	v, err := strconv.ParseUint(s, 0, 64)
+	return int64(v), err
+}
+
+// parseUsdtArg parses the location of a single argument, e.g. "-4@-8(%rbp)" on amd64 or "8@[x1, 16]" on arm64
+func parseUsdtArg(arch, s string) (usdtArg, error) {
+	sizeStr, loc, ok := strings.Cut(s, "@")
+	if !ok {
+		return usdtArg{}, fmt.Errorf("invalid USDT argument %q", s)
+	}
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil {
+		return usdtArg{}, fmt.Errorf("invalid size of USDT argument %q", s)
+	}
+	arg := usdtArg{signed: size < 0}
+	if size < 0 {
+		size = -size
+	}
+	switch size {
+	case 1, 2, 4, 8:
+		arg.bitshift = int8(64 - size*8)
+	default:
+		return usdtArg{}, fmt.Errorf("invalid size of USDT argument %q", s)
+	}
+
+	var regOffsets map[string]int16
+	var constMatch, regMatch, derefMatch []string
+	switch arch {
+	case "amd64":
+		regOffsets = x86RegOffsets
+		constMatch = x86ArgConst.FindStringSubmatch(loc)
+		regMatch = x86ArgReg.FindStringSubmatch(loc)
+		if m := x86ArgRegDeref.FindStringSubmatch(loc); m != nil {
+			derefMatch = []string{m[0], m[2], m[1]}
+		}
+	case "arm64":
+		regOffsets = arm64RegOffsets
+		constMatch = arm64ArgConst.FindStringSubmatch(loc)
+		regMatch = arm64ArgReg.FindStringSubmatch(loc)
+		derefMatch = arm64ArgRegDeref.FindStringSubmatch(loc)
+	default:
+		return usdtArg{}, fmt.Errorf("USDT arguments aren't supported on %s", arch)
+	}
+
+	var reg, off string
+	switch {
+	case constMatch != nil:
+		v, err := parseInt(constMatch[1])
+		if err != nil {
+			return usdtArg{}, fmt.Errorf("invalid constant in USDT argument %q", s)
+		}
+		arg.argType = usdtArgConst
+		arg.valOff = uint64(v)
+		return arg, nil
+	case regMatch != nil:
+		arg.argType = usdtArgReg
+		reg = regMatch[1]
+	case derefMatch != nil:
+		arg.argType = usdtArgRegDeref
+		reg, off = derefMatch[1], derefMatch[2]
+	default:
+		return usdtArg{}, fmt.Errorf("unsupported USDT argument %q", s)
+	}
+
+	regOff, ok := regOffsets[reg]
+	if !ok {
+		return usdtArg{}, fmt.Errorf("unsupported register %q in USDT argument %q", reg, s)
+	}
+	arg.regOff = regOff
+	v, err := parseInt(off)
+	if err != nil {
+		return usdtArg{}, fmt.Errorf("invalid offset in USDT argument %q", s)
+	}
+	arg.valOff = uint64(v)
+	return arg, nil
+}
+
+// parseUsdtArgs parses the locations of all arguments of a USDT probe as found in its note
+func parseUsdtArgs(arch, args string) ([]usdtArg, error) {
+	parts := splitUsdtArgs(args)
+	if len(parts) > usdtMaxArgs {
+		return nil, fmt.Errorf("USDT probe has %d arguments, at most %d are supported", len(parts), usdtMaxArgs)
+	}
+	res := make([]usdtArg, 0, len(parts))
+	for _, part := range parts {
+		arg, err := parseUsdtArg(arch, part)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, arg)
+	}
+	return res, nil
+}
+
+// encodeUsdtSpec encodes the arguments as struct gadget_usdt_spec
+func encodeUsdtSpec(args []usdtArg) []byte {
+	buf := make([]byte, usdtSpecSize)
+	for n, arg := range args {
+		b := buf[n*usdtArgSize:]
+		binary.NativeEndian.PutUint64(b[0:], arg.valOff)
+		binary.NativeEndian.PutUint32(b[8:], uint32(arg.argType))
+		binary.NativeEndian.PutUint16(b[12:], uint16(arg.regOff))
+		if arg.signed {
+			b[14] = 1
+		}
+		b[15] = byte(arg.bitshift)
+	}
+	binary.NativeEndian.PutUint16(buf[usdtMaxArgs*usdtArgSize:], uint16(len(args)))
+	return buf
+}
+
+// UsdtSpecs stores the argument locations of attached USDT probes in the gadget_usdt_specs map of a gadget. The
+// index of each entry is passed to the program as attach cookie. It's shared by all tracers of a gadget.
+type UsdtSpecs struct {
+	m    *ebpf.Map
+	mu   sync.Mutex
+	used []bool
+}
+
+func NewUsdtSpecs(m *ebpf.Map) *UsdtSpecs {
+	return &UsdtSpecs{
+		m:    m,
+		used: make([]bool, m.MaxEntries()),
+	}
+}
+
+// add stores the spec of the arguments and returns its index
+func (s *UsdtSpecs) add(args string) (uint32, error) {
+	parsed, err := parseUsdtArgs(runtime.GOARCH, args)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, used := range s.used {
+		if used {
+			continue
+		}
+		if err := s.m.Put(uint32(id), encodeUsdtSpec(parsed)); err != nil {
+			return 0, fmt.Errorf("storing USDT spec: %w", err)
+		}
+		s.used[id] = true
+		return uint32(id), nil
+	}
+	return 0, errors.New("too many USDT probes attached")
+}
+
+// remove frees the spec with the given index
+func (s *UsdtSpecs) remove(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used[id] = false
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uprobetracer
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUsdtArgs(t *testing.T) {
+	for name, tc := range map[string]struct {
+		arch     string
+		args     string
+		expected []usdtArg
+		err      bool
+	}{
+		"amd64": {
+			arch: "amd64",
+			args: "-4@%eax 8@-16(%rbp) 8@(%rdi) -1@$-5 2@%r8w 8@16(%rip)",
+			expected: []usdtArg{
+				{argType: usdtArgReg, regOff: 80, signed: true, bitshift: 32},
+				{argType: usdtArgRegDeref, regOff: 32, valOff: uint64(0xfffffffffffffff0), bitshift: 0},
+				{argType: usdtArgRegDeref, regOff: 112, bitshift: 0},
+				{argType: usdtArgConst, valOff: uint64(0xfffffffffffffffb), signed: true, bitshift: 56},
+				{argType: usdtArgReg, regOff: 72, bitshift: 48},
+				{argType: usdtArgRegDeref, regOff: 128, valOff: 16, bitshift: 0},
+			},
+		},
+		"arm64": {
+			arch: "arm64",
+			args: "-4@x0 8@[sp, 16] 8@[x29] 4@7",
+			expected: []usdtArg{
+				{argType: usdtArgReg, regOff: 0, signed: true, bitshift: 32},
+				{argType: usdtArgRegDeref, regOff: 248, valOff: 16, bitshift: 0},
+				{argType: usdtArgRegDeref, regOff: 232, bitshift: 0},
+				{argType: usdtArgConst, valOff: 7, bitshift: 32},
+			},
+		},
+		"no args": {
+			arch:     "amd64",
+			args:     "",
+			expected: []usdtArg{},
+		},
+		"invalid size": {
+			arch: "amd64",
+			args: "3@%eax",
+			err:  true,
+		},
+		"unknown register": {
+			arch: "amd64",
+			args: "8@%xmm0",
+			err:  true,
+		},
+		"scaled index": {
+			arch: "amd64",
+			args: "8@-8(%rbp,%rax,8)",
+			err:  true,
+		},
+		"too many": {
+			arch: "amd64",
+			args: "8@%rax 8@%rax 8@%rax 8@%rax 8@%rax 8@%rax 8@%rax 8@%rax 8@%rax 8@%rax 8@%rax 8@%rax 8@%rax",
+			err:  true,
+		},
+		"unsupported arch": {
+			arch: "riscv64",
+			args: "8@a0",
+			err:  true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			args, err := parseUsdtArgs(tc.arch, tc.args)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, args)
+		})
+	}
+}
+
+func TestEncodeUsdtSpec(t *testing.T) {
+	spec := encodeUsdtSpec([]usdtArg{
+		{argType: usdtArgRegDeref, regOff: 32, valOff: 16, signed: true, bitshift: 32},
+	})
+	require.Len(t, spec, usdtSpecSize)
+	require.Equal(t, uint64(16), binary.NativeEndian.Uint64(spec[0:]))
+	require.Equal(t, uint32(usdtArgRegDeref), binary.NativeEndian.Uint32(spec[8:]))
+	require.Equal(t, uint16(32), binary.NativeEndian.Uint16(spec[12:]))
+	require.Equal(t, byte(1), spec[14])
+	require.Equal(t, byte(32), spec[15])
+	require.Equal(t, uint16(1), binary.NativeEndian.Uint16(spec[usdtMaxArgs*usdtArgSize:]))
+}
+
+func TestParseTarget(t *testing.T) {
+	file, symbol, err := ParseTarget(ProgUprobe, "libc:malloc")
+	require.NoError(t, err)
+	require.Equal(t, "libc", file)
+	require.Equal(t, "malloc", symbol)
+
+	file, symbol, err = ParseTarget(ProgUSDT, "/usr/bin/python3:python:function__entry")
+	require.NoError(t, err)
+	require.Equal(t, "/usr/bin/python3", file)
+	require.Equal(t, "python:function__entry", symbol)
+
+	for _, target := range []string{"libc", ":malloc", "lib/libc.so:malloc"} {
+		_, _, err := ParseTarget(ProgUprobe, target)
+		require.Error(t, err, target)
+	}
+	_, _, err = ParseTarget(ProgUSDT, "libc:probe")
+	require.Error(t, err)
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package capabilities determines the Linux capabilities needed to run the eBPF programs of a gadget and checks them
+// against the capabilities of the current process.
+package capabilities
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/syndtr/gocapability/capability"
+)
+
+// programCapabilities lists the capabilities needed to attach programs of the given type, in addition to CAP_BPF
+// that is needed to load any program
+var programCapabilities = map[ebpf.ProgramType][]capability.Cap{
+	ebpf.Kprobe:                {capability.CAP_PERFMON},
+	ebpf.TracePoint:            {capability.CAP_PERFMON},
+	ebpf.RawTracepoint:         {capability.CAP_PERFMON},
+	ebpf.RawTracepointWritable: {capability.CAP_PERFMON},
+	ebpf.PerfEvent:             {capability.CAP_PERFMON},
+	ebpf.Tracing:               {capability.CAP_PERFMON},
+	ebpf.LSM:                   {capability.CAP_PERFMON, capability.CAP_MAC_ADMIN},
+	ebpf.SocketFilter:          {capability.CAP_NET_RAW},
+	ebpf.SchedCLS:              {capability.CAP_NET_ADMIN},
+	ebpf.SchedACT:              {capability.CAP_NET_ADMIN},
+	ebpf.XDP:                   {capability.CAP_NET_ADMIN},
+	ebpf.CGroupSKB:             {capability.CAP_NET_ADMIN},
+	ebpf.CGroupSock:            {capability.CAP_NET_ADMIN},
+	ebpf.CGroupSockAddr:        {capability.CAP_NET_ADMIN},
+	ebpf.SockOps:               {capability.CAP_NET_ADMIN},
+}
+
+// Requirement is a capability needed by an eBPF program
+type Requirement struct {
+	Capability  capability.Cap
+	Program     string
+	ProgramType ebpf.ProgramType
+}
+
+// Name returns the name of c as used by the kernel, e.g. CAP_SYS_ADMIN
+func Name(c capability.Cap) string {
+	return "CAP_" + strings.ToUpper(c.String())
+}
+
+// Requirements returns the capabilities needed to load and attach the programs of spec, sorted by capability and
+// program
+func Requirements(spec *ebpf.CollectionSpec) []Requirement {
+	var res []Requirement
+	for name, p := range spec.Programs {
+		res = append(res, Requirement{Capability: capability.CAP_BPF, Program: name, ProgramType: p.Type})
+		for _, c := range programCapabilities[p.Type] {
+			res = append(res, Requirement{Capability: c, Program: name, ProgramType: p.Type})
+		}
+	}
+	slices.SortFunc(res, compareRequirements)
+	return res
+}
+
+func compareRequirements(a, b Requirement) int {
+	return cmp.Or(cmp.Compare(a.Capability, b.Capability), cmp.Compare(a.Program, b.Program))
+}
+
+// Names returns the sorted names of the distinct capabilities of reqs
+func Names(reqs []Requirement) []string {
+	var res []string
+	for _, r := range reqs {
+		if name := Name(r.Capability); !slices.Contains(res, name) {
+			res = append(res, name)
+		}
+	}
+	slices.Sort(res)
+	return res
+}
+
+// MissingError lists the requirements that aren't satisfied by the capabilities of the process
+type MissingError struct {
+	Missing []Requirement
+}
+
+func (e *MissingError) Error() string {
+	var reasons []string
+	for i := 0; i < len(e.Missing); {
+		c := e.Missing[i].Capability
+		var programs []string
+		for ; i < len(e.Missing) && e.Missing[i].Capability == c; i++ {
+			programs = append(programs, fmt.Sprintf("%s program %q", e.Missing[i].ProgramType, e.Missing[i].Program))
+		}
+		reasons = append(reasons, fmt.Sprintf("%s (needed by %s)", Name(c), strings.Join(programs, ", ")))
+	}
+	return "missing capabilities: " + strings.Join(reasons, "; ")
+}
+
+// Check returns a *MissingError if the current process lacks capabilities needed by the programs of spec
+func Check(spec *ebpf.CollectionSpec) error {
+	caps, err := capability.NewPid2(0)
+	if err != nil {
+		return fmt.Errorf("getting capabilities: %w", err)
+	}
+	if err := caps.Load(); err != nil {
+		return fmt.Errorf("getting capabilities: %w", err)
+	}
+	return check(spec, func(c capability.Cap) bool {
+		return caps.Get(capability.EFFECTIVE, c)
+	}, capability.CAP_LAST_CAP >= capability.CAP_BPF)
+}
+
+// check is Check with has reporting the effective capabilities. Kernels older than 5.8, that don't know CAP_BPF
+// (bpfCaps is false), require CAP_SYS_ADMIN instead of CAP_BPF and CAP_PERFMON; newer ones accept it as well.
+func check(spec *ebpf.CollectionSpec, has func(capability.Cap) bool, bpfCaps bool) error {
+	var missing []Requirement
+	for _, r := range Requirements(spec) {
+		if r.Capability == capability.CAP_BPF || r.Capability == capability.CAP_PERFMON {
+			if has(capability.CAP_SYS_ADMIN) {
+				continue
+			}
+			if !bpfCaps {
+				r.Capability = capability.CAP_SYS_ADMIN
+			}
+		}
+		if !has(r.Capability) {
+			missing = append(missing, r)
+		}
+	}
+	if len(missing) > 0 {
+		// Requirements might have been mapped to CAP_SYS_ADMIN above
+		slices.SortFunc(missing, compareRequirements)
+		missing = slices.CompactFunc(missing, func(a, b Requirement) bool {
+			return compareRequirements(a, b) == 0
+		})
+		return &MissingError{Missing: missing}
+	}
+	return nil
+}
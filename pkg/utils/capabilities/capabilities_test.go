@@ -0,0 +1,64 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capabilities
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/gocapability/capability"
+)
+
+func testSpec() *ebpf.CollectionSpec {
+	return &ebpf.CollectionSpec{
+		Programs: map[string]*ebpf.ProgramSpec{
+			"ig_execve": {Type: ebpf.TracePoint},
+			"ig_tcp":    {Type: ebpf.Kprobe},
+			"ig_dns":    {Type: ebpf.SocketFilter},
+		},
+	}
+}
+
+func hasCaps(caps ...capability.Cap) func(capability.Cap) bool {
+	return func(c capability.Cap) bool {
+		return slices.Contains(caps, c)
+	}
+}
+
+func TestRequirements(t *testing.T) {
+	require.Empty(t, Names(Requirements(&ebpf.CollectionSpec{})))
+	require.Equal(t, []string{"CAP_BPF", "CAP_NET_RAW", "CAP_PERFMON"}, Names(Requirements(testSpec())))
+}
+
+func TestCheck(t *testing.T) {
+	spec := testSpec()
+
+	require.NoError(t, check(spec, hasCaps(capability.CAP_BPF, capability.CAP_PERFMON, capability.CAP_NET_RAW), true))
+	require.NoError(t, check(spec, hasCaps(capability.CAP_SYS_ADMIN, capability.CAP_NET_RAW), true))
+	require.NoError(t, check(spec, hasCaps(capability.CAP_SYS_ADMIN, capability.CAP_NET_RAW), false))
+
+	err := check(spec, hasCaps(capability.CAP_BPF, capability.CAP_NET_RAW), true)
+	var missingErr *MissingError
+	require.ErrorAs(t, err, &missingErr)
+	require.Equal(t, []string{"CAP_PERFMON"}, Names(missingErr.Missing))
+	require.EqualError(t, err, `missing capabilities: CAP_PERFMON (needed by TracePoint program "ig_execve", Kprobe program "ig_tcp")`)
+
+	// Without CAP_BPF support in the kernel, CAP_SYS_ADMIN is needed
+	err = check(spec, hasCaps(capability.CAP_BPF, capability.CAP_PERFMON), false)
+	require.EqualError(t, err, `missing capabilities: CAP_NET_RAW (needed by SocketFilter program "ig_dns"); `+
+		`CAP_SYS_ADMIN (needed by SocketFilter program "ig_dns", TracePoint program "ig_execve", Kprobe program "ig_tcp")`)
+}
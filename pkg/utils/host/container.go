@@ -0,0 +1,88 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package host
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultContainerHostRoot is where the host filesystem is expected when running in a container without HOST_ROOT,
+// e.g. with "docker run -v /:/host"
+const DefaultContainerHostRoot = "/host"
+
+// detectHostRoot returns candidate if the host filesystem is mounted there, and "/" otherwise. The host filesystem
+// is recognized by the procfs it contains.
+func detectHostRoot(candidate string) string {
+	if _, err := os.Stat(filepath.Join(candidate, "proc/1/ns/mnt")); err != nil {
+		return "/"
+	}
+	return candidate
+}
+
+// checkHostRoot verifies that the host filesystem is usable when it's mounted in hostRoot, so that missing mounts
+// are reported clearly instead of making gadgets fail later on
+func checkHostRoot(hostRoot string) error {
+	if hostRoot == "/" {
+		return nil
+	}
+
+	if _, err := os.Stat(hostRoot); err != nil {
+		return fmt.Errorf("host filesystem not found at %s (did you forget -v /:%s?): %w", hostRoot, hostRoot, err)
+	}
+
+	procFs := filepath.Join(hostRoot, "proc")
+	var stat unix.Statfs_t
+	if err := unix.Statfs(procFs, &stat); err != nil || stat.Type != unix.PROC_SUPER_MAGIC {
+		return fmt.Errorf("%s is not the procfs of the host (did you mount the host filesystem with -v /:%s?)",
+			procFs, hostRoot)
+	}
+	if _, err := os.Stat(filepath.Join(procFs, "1")); err != nil {
+		return fmt.Errorf("%s doesn't show the processes of the host (did you try --pid=host?)", procFs)
+	}
+	return nil
+}
+
+// logNamespaces tells which namespaces of the host are shared, e.g. to debug ig running in a container
+func logNamespaces() {
+	for _, nsKind := range []string{"pid", "mnt", "net"} {
+		isHost, err := isHostNamespace(nsKind)
+		if err != nil {
+			log.Debugf("checking %s namespace: %v", nsKind, err)
+			continue
+		}
+		log.Debugf("running in the host %s namespace: %t", nsKind, isHost)
+	}
+}
+
+// HostSocketPath returns the path of the socket at path in the host filesystem if it isn't available at path,
+// e.g. because ig runs in a container without "-v /run:/run". It returns an empty string if path should be used.
+func HostSocketPath(path string) string {
+	if HostRoot == "/" {
+		return ""
+	}
+	if _, err := os.Stat(path); err == nil {
+		return ""
+	}
+	hostPath := filepath.Join(HostRoot, path)
+	if _, err := os.Stat(hostPath); err != nil {
+		return ""
+	}
+	return hostPath
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 The Inspektor Gadget authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package host
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectHostRoot(t *testing.T) {
+	dir := t.TempDir()
+	require.Equal(t, "/", detectHostRoot(dir))
+	require.Equal(t, "/", detectHostRoot(filepath.Join(dir, "missing")))
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "proc/1/ns"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "proc/1/ns/mnt"), nil, 0o644))
+	require.Equal(t, dir, detectHostRoot(dir))
+}
+
+func TestCheckHostRoot(t *testing.T) {
+	require.NoError(t, checkHostRoot("/"))
+
+	dir := t.TempDir()
+	err := checkHostRoot(filepath.Join(dir, "missing"))
+	require.ErrorContains(t, err, "did you forget -v /:")
+
+	// A plain directory isn't a procfs
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "proc/1"), 0o755))
+	err = checkHostRoot(dir)
+	require.ErrorContains(t, err, "is not the procfs of the host")
+}
+
+func TestHostSocketPath(t *testing.T) {
+	oldHostRoot := HostRoot
+	t.Cleanup(func() { HostRoot = oldHostRoot })
+
+	HostRoot = t.TempDir()
+	socket := "/run/test-runtime/runtime.sock"
+	require.Empty(t, HostSocketPath(socket))
+
+	require.NoError(t, os.MkdirAll(filepath.Join(HostRoot, "run/test-runtime"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(HostRoot, socket), nil, 0o644))
+	require.Equal(t, filepath.Join(HostRoot, socket), HostSocketPath(socket))
+
+	HostRoot = "/"
+	require.Empty(t, HostSocketPath(socket))
+}
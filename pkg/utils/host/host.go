@@ -40,7 +40,7 @@ func init() {
 	// Initialize HostRoot and HostProcFs
 	HostRoot = os.Getenv("HOST_ROOT")
 	if HostRoot == "" {
-		HostRoot = "/"
+		HostRoot = detectHostRoot(DefaultContainerHostRoot)
 	}
 	HostProcFs = filepath.Join(HostRoot, "/proc")
 }
@@ -129,7 +129,13 @@ func Init(config Config) error {
 		}
 	}
 
+	// Check the mounts after the WSL workaround, which might fix the procfs
+	if err := checkHostRoot(HostRoot); err != nil {
+		return err
+	}
+
 	initDone = true
+	logNamespaces()
 	return nil
 }
 